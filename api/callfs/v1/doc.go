@@ -0,0 +1,5 @@
+// Package callfsv1 holds the messages and service of the CallFS gRPC API,
+// generated from callfs.proto.
+package callfsv1
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative callfs.proto
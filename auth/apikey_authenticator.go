@@ -15,9 +15,11 @@ type APIKeyAuthenticator struct {
 }
 
 // NewAPIKeyAuthenticator creates a new API key authenticator.
-// The internalProxySecret is registered as a valid key with the "internal-proxy" user ID
-// so cross-server operations (UpdateFileOnInstance, etc.) can authenticate on peers.
-func NewAPIKeyAuthenticator(keys []string, internalProxySecret string) *APIKeyAuthenticator {
+// Admin keys are registered with "admin-N" user IDs, which IsAdmin recognizes.
+// The internalProxySecrets, the current one and any accepted during a rotation, are
+// registered as valid keys with the "internal-proxy" user ID so cross-server
+// operations (UpdateFileOnInstance, etc.) can authenticate on peers.
+func NewAPIKeyAuthenticator(keys, adminKeys, internalProxySecrets []string) *APIKeyAuthenticator {
 	validKeys := make(map[string]string)
 	userIndex := 1
 	for _, key := range keys {
@@ -26,8 +28,17 @@ func NewAPIKeyAuthenticator(keys []string, internalProxySecret string) *APIKeyAu
 			userIndex++
 		}
 	}
-	if internalProxySecret != "" {
-		validKeys[internalProxySecret] = "internal-proxy"
+	adminIndex := 1
+	for _, key := range adminKeys {
+		if key != "" {
+			validKeys[key] = fmt.Sprintf("%s%d", adminUserPrefix, adminIndex)
+			adminIndex++
+		}
+	}
+	for _, secret := range internalProxySecrets {
+		if secret != "" {
+			validKeys[secret] = InternalProxyUserID
+		}
 	}
 
 	return &APIKeyAuthenticator{
@@ -60,3 +71,27 @@ func (a *APIKeyAuthenticator) Authenticate(ctx context.Context, token string) (s
 	}
 	return foundUID, nil
 }
+
+// InternalProxyUserID is the user ID of requests authenticated with the
+// internal proxy secret, i.e. requests proxied by another instance
+const InternalProxyUserID = "internal-proxy"
+
+// adminUserPrefix marks user IDs issued for admin API keys
+const adminUserPrefix = "admin-"
+
+// IsAdmin reports whether userID was authenticated with an admin API key
+func IsAdmin(userID string) bool {
+	return strings.HasPrefix(userID, adminUserPrefix)
+}
+
+// MatchesSecret reports whether token equals one of secrets, comparing each
+// in constant time. Empty secrets never match.
+func MatchesSecret(token string, secrets []string) bool {
+	found := 0
+	for _, secret := range secrets {
+		if secret != "" {
+			found |= subtle.ConstantTimeCompare([]byte(token), []byte(secret))
+		}
+	}
+	return found == 1
+}
@@ -0,0 +1,34 @@
+package auth
+
+import "context"
+
+// Authenticators authenticates a token with each authenticator in turn, as
+// the user of the first that accepts it
+type Authenticators []Authenticator
+
+// Authenticate validates a token and returns the associated user ID
+func (as Authenticators) Authenticate(ctx context.Context, token string) (string, error) {
+	err := ErrAuthenticationFailed
+	for _, a := range as {
+		userID, authErr := a.Authenticate(ctx, token)
+		if authErr == nil {
+			return userID, nil
+		}
+		err = authErr
+	}
+	return "", err
+}
+
+// IdentityProviders maps a principal with the first provider that knows it
+type IdentityProviders []IdentityProvider
+
+// Identity returns the identity of userID from the first provider that has one
+func (ps IdentityProviders) Identity(ctx context.Context, userID string) (Identity, bool, error) {
+	for _, p := range ps {
+		id, found, err := p.Identity(ctx, userID)
+		if err != nil || found {
+			return id, found, err
+		}
+	}
+	return Identity{}, false, nil
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// OnBehalfOfHeader names the user a request is sent for: by a trusted
+// service acting for one of its users, or by an instance forwarding its
+// client's request to a peer
+const OnBehalfOfHeader = "X-CallFS-On-Behalf-Of"
+
+type userIDKey struct{}
+
+// WithUserID returns a context for requests made by userID
+func WithUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userIDKey{}, userID)
+}
+
+// UserIDFromContext returns the user a request was authenticated as
+func UserIDFromContext(ctx context.Context) (string, bool) {
+	userID, ok := ctx.Value(userIDKey{}).(string)
+	return userID, ok
+}
+
+type peerRequestKey struct{}
+
+// WithPeerRequest returns a context for a request another instance sent,
+// having already run it through its own checks and hooks
+func WithPeerRequest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, peerRequestKey{}, true)
+}
+
+// IsPeerRequest reports whether the request of ctx was sent by a peer
+func IsPeerRequest(ctx context.Context) bool {
+	peer, _ := ctx.Value(peerRequestKey{}).(bool)
+	return peer
+}
+
+// OnBehalfOfIdentityHeader carries the identity in the credentials of the
+// client an instance forwards a request for, as "uid:gid,gid...", since the
+// peer does not see those credentials
+const OnBehalfOfIdentityHeader = "X-CallFS-On-Behalf-Of-Identity"
+
+// claimedIdentity is the identity the credentials of a request carried, set
+// by the authenticator that accepted them
+type claimedIdentity struct {
+	mu     sync.Mutex
+	userID string
+	id     Identity
+	found  bool
+}
+
+type claimedIdentityKey struct{}
+
+// WithIdentityClaims returns a context in which the authenticator accepting
+// a request's credentials records the identity they carry, for
+// ClaimedIdentity
+func WithIdentityClaims(ctx context.Context) context.Context {
+	return context.WithValue(ctx, claimedIdentityKey{}, &claimedIdentity{})
+}
+
+// SetClaimedIdentity records that the credentials of the request of ctx
+// authenticate userID as id, or carry no identity when found is false
+func SetClaimedIdentity(ctx context.Context, userID string, id Identity, found bool) {
+	if claimed, ok := ctx.Value(claimedIdentityKey{}).(*claimedIdentity); ok {
+		claimed.mu.Lock()
+		claimed.userID, claimed.id, claimed.found = userID, id, found
+		claimed.mu.Unlock()
+	}
+}
+
+// ClaimedIdentity returns the identity the credentials of the request of ctx
+// carried for userID
+func ClaimedIdentity(ctx context.Context, userID string) (Identity, bool) {
+	claimed, ok := ctx.Value(claimedIdentityKey{}).(*claimedIdentity)
+	if !ok {
+		return Identity{}, false
+	}
+	claimed.mu.Lock()
+	defer claimed.mu.Unlock()
+	if !claimed.found || claimed.userID != userID {
+		return Identity{}, false
+	}
+	return claimed.id, true
+}
+
+// FormatIdentity encodes id for OnBehalfOfIdentityHeader
+func FormatIdentity(id Identity) string {
+	gids := make([]string, len(id.GIDs))
+	for i, gid := range id.GIDs {
+		gids[i] = strconv.Itoa(gid)
+	}
+	return strconv.Itoa(id.UID) + ":" + strings.Join(gids, ",")
+}
+
+// ParseIdentity decodes an identity formatted by FormatIdentity
+func ParseIdentity(value string) (Identity, error) {
+	uidPart, gidsPart, ok := strings.Cut(value, ":")
+	uid, err := strconv.Atoi(uidPart)
+	if !ok || err != nil || uid < 0 {
+		return Identity{}, fmt.Errorf("malformed identity %q", value)
+	}
+	id := Identity{UID: uid}
+	if gidsPart != "" {
+		for _, part := range strings.Split(gidsPart, ",") {
+			gid, err := strconv.Atoi(part)
+			if err != nil || gid < 0 {
+				return Identity{}, fmt.Errorf("malformed identity %q", value)
+			}
+			id.GIDs = append(id.GIDs, gid)
+		}
+	}
+	return id, nil
+}
@@ -0,0 +1,26 @@
+package auth
+
+import "strings"
+
+// Delegations lists, by the user ID of a trusted service, the users the
+// service may act on behalf of. An entry ending in "*" matches every user ID
+// it is a prefix of, as "oidc:*" does OIDC users.
+type Delegations map[string][]string
+
+// Allows reports whether serviceID may act on behalf of userID. No service
+// may act for an admin, the internal proxy or itself.
+func (d Delegations) Allows(serviceID, userID string) bool {
+	if userID == "" || userID == serviceID || IsAdmin(userID) || userID == InternalProxyUserID || userID == "root" {
+		return false
+	}
+	for _, allowed := range d[serviceID] {
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok {
+			if strings.HasPrefix(userID, prefix) {
+				return true
+			}
+		} else if allowed == userID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Identity is the Unix user a principal acts as
+type Identity struct {
+	UID  int
+	GIDs []int // The primary group first, then supplementary groups
+}
+
+// GID returns the primary group, in which new files are created
+func (id Identity) GID() int {
+	if len(id.GIDs) == 0 {
+		return id.UID
+	}
+	return id.GIDs[0]
+}
+
+// InGroup reports whether gid is one of the identity's groups
+func (id Identity) InGroup(gid int) bool {
+	if len(id.GIDs) == 0 {
+		return gid == id.UID
+	}
+	return slices.Contains(id.GIDs, gid)
+}
+
+// IdentityProvider maps authenticated principals to the Unix identities used
+// for permission checks and as the owners of the files they create. found is
+// false for a principal the provider does not know.
+type IdentityProvider interface {
+	Identity(ctx context.Context, userID string) (id Identity, found bool, err error)
+}
+
+// StaticIdentities is an IdentityProvider with a fixed table of user IDs
+type StaticIdentities map[string]Identity
+
+// Identity returns the identity of userID in the table
+func (s StaticIdentities) Identity(_ context.Context, userID string) (Identity, bool, error) {
+	id, ok := s[userID]
+	return id, ok, nil
+}
+
+// defaultIdentity derives the identity of a principal no provider knows:
+// root=0/0, api-user-N uses UID/GID 1000+N, others get 1000
+func defaultIdentity(userID string) Identity {
+	if userID == "root" {
+		return Identity{UID: 0, GIDs: []int{0}}
+	}
+	if strings.HasPrefix(userID, "api-user-") {
+		if n, err := strconv.Atoi(strings.TrimPrefix(userID, "api-user-")); err == nil {
+			return Identity{UID: 1000 + n, GIDs: []int{1000 + n}}
+		}
+	}
+	return Identity{UID: 1000, GIDs: []int{1000}}
+}
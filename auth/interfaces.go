@@ -14,6 +14,9 @@ const (
 	ReadPerm PermissionType = iota
 	WritePerm
 	DeletePerm
+	ChmodPerm  // Set the mode or timestamps of an existing path
+	ChownPerm  // Set the owning UID or GID of any path
+	SearchPerm // Search a directory, which reaching the paths below it needs
 )
 
 // Common authentication/authorization errors
@@ -29,6 +32,15 @@ type Authenticator interface {
 	Authenticate(ctx context.Context, token string) (userID string, err error)
 }
 
+// ScopedAuthenticator is an Authenticator whose tokens may be restricted to
+// part of the filesystem
+type ScopedAuthenticator interface {
+	Authenticator
+	// AuthenticateScoped validates a token and returns the associated user
+	// ID and the token's scope, or nil for an unrestricted token
+	AuthenticateScoped(ctx context.Context, token string) (userID string, scope *TokenScope, err error)
+}
+
 // Authorizer defines the interface for authorization checks
 type Authorizer interface {
 	// Authorize checks if a user has the specified permission for a path
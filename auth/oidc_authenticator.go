@@ -0,0 +1,594 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// OIDCUserPrefix marks the user IDs of principals authenticated by an OpenID
+// Connect identity provider, as in "oidc:alice". Members of an admin group
+// get adminUserPrefix in front, as in "admin-oidc:alice".
+const OIDCUserPrefix = "oidc:"
+
+const (
+	// oidcClockSkew is how far token times may be off the server's clock
+	oidcClockSkew = time.Minute
+	// oidcKeysMaxAge is how long signing keys are used before being fetched
+	// again; tokens signed with an unknown key fetch them at most every
+	// oidcKeysMinRefresh
+	oidcKeysMaxAge     = time.Hour
+	oidcKeysMinRefresh = 30 * time.Second
+	// oidcMaxCachedTokens bounds the introspection results kept
+	oidcMaxCachedTokens = 10000
+	// oidcMaxResponseBytes bounds the documents read from the provider
+	oidcMaxResponseBytes = 1 << 20
+)
+
+// OIDCOptions configures an OIDCAuthenticator
+type OIDCOptions struct {
+	Issuer       string // Must match the iss claim; discovery is read from it
+	Audience     string // Must be among the aud claim of JWTs; defaults to ClientID
+	ClientID     string
+	ClientSecret string   // Authenticates introspection and code exchange
+	Scopes       []string // Requested when signing in to the web file browser
+
+	// Endpoints found by discovery unless set
+	JWKSURL          string
+	IntrospectionURL string
+
+	UsernameClaim string         // Claim naming the user; dotted for nested claims
+	GroupsClaim   string         // Claim listing the user's groups or roles
+	AdminGroups   []string       // Members may call the admin API
+	UIDClaim      string         // Numeric claim with the user's UID
+	GIDClaim      string         // Numeric claim with the user's primary GID
+	GroupGIDs     map[string]int // Group name -> GID, for supplementary groups
+
+	IntrospectionCacheTTL time.Duration // How long introspection results are reused
+	IntrospectionRate     float64       // Introspection requests per second to the provider; 0 is unlimited
+	Timeout               time.Duration // Bounds each request to the provider
+}
+
+// OIDCAuthenticator authenticates bearer tokens issued by an OpenID Connect
+// provider, such as Keycloak or Auth0. JWTs are verified with the provider's
+// signing keys; other tokens are checked with token introspection (RFC 7662).
+// It is also the IdentityProvider of the users it authenticated, with the
+// UID and groups of the claims of the token each request carried.
+type OIDCAuthenticator struct {
+	opts   OIDCOptions
+	client *http.Client
+
+	mu           sync.Mutex
+	discovery    *oidcDiscovery
+	keys         map[string]any // Signing keys by key ID
+	keysFetched  time.Time
+	introspected map[[sha256.Size]byte]introspectionResult
+	inflight     map[[sha256.Size]byte]*pendingIntrospection
+
+	introspections *rate.Limiter // nil when unlimited
+}
+
+// oidcDiscovery holds the provider metadata the authenticator uses
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	IntrospectionEndpoint string `json:"introspection_endpoint"`
+}
+
+type introspectionResult struct {
+	claims  map[string]any // nil for an inactive token
+	expires time.Time
+}
+
+// pendingIntrospection is an introspection in progress, whose answer
+// requests with the same token wait for
+type pendingIntrospection struct {
+	done   chan struct{}
+	claims map[string]any
+	err    error
+}
+
+// ErrIntrospectionLimited is returned for a token that would have to be
+// introspected while the provider is already asked IntrospectionRate times
+// a second. It does not reject the token.
+var ErrIntrospectionLimited = errors.New("token introspection rate limit exceeded")
+
+// NewOIDCAuthenticator creates an authenticator for tokens of opts.Issuer.
+// Provider metadata and keys are fetched when first needed.
+func NewOIDCAuthenticator(opts OIDCOptions) *OIDCAuthenticator {
+	if opts.Audience == "" {
+		opts.Audience = opts.ClientID
+	}
+	if opts.UsernameClaim == "" {
+		opts.UsernameClaim = "sub"
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	a := &OIDCAuthenticator{
+		opts:         opts,
+		client:       &http.Client{Timeout: opts.Timeout},
+		introspected: make(map[[sha256.Size]byte]introspectionResult),
+		inflight:     make(map[[sha256.Size]byte]*pendingIntrospection),
+	}
+	if opts.IntrospectionRate > 0 {
+		a.introspections = rate.NewLimiter(rate.Limit(opts.IntrospectionRate), max(1, int(math.Ceil(opts.IntrospectionRate))))
+	}
+	return a
+}
+
+// Authenticate validates a token and returns the associated user ID
+func (a *OIDCAuthenticator) Authenticate(ctx context.Context, token string) (string, error) {
+	token = strings.TrimSpace(strings.TrimPrefix(token, "Bearer "))
+	if token == "" {
+		return "", ErrAuthenticationFailed
+	}
+
+	var claims map[string]any
+	var err error
+	if strings.Count(token, ".") == 2 {
+		claims, err = a.verifyJWT(ctx, token)
+	} else {
+		claims, err = a.introspect(ctx, token)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	userID, id, hasIdentity, err := a.principal(claims)
+	if err != nil {
+		return "", err
+	}
+	SetClaimedIdentity(ctx, userID, id, hasIdentity)
+	return userID, nil
+}
+
+// Identity returns the identity in the claims of the token the request of
+// ctx authenticated userID with, when they had a UID
+func (a *OIDCAuthenticator) Identity(ctx context.Context, userID string) (Identity, bool, error) {
+	id, ok := ClaimedIdentity(ctx, userID)
+	return id, ok, nil
+}
+
+// principal maps verified claims to a user ID and, when they carry a UID,
+// an identity. Claims never make a user root, which bypasses permission
+// checks: that takes an entry in auth.identities.
+func (a *OIDCAuthenticator) principal(claims map[string]any) (string, Identity, bool, error) {
+	username, _ := claimValue(claims, a.opts.UsernameClaim).(string)
+	if username == "" {
+		return "", Identity{}, false, fmt.Errorf("%w: no %s claim", ErrInvalidToken, a.opts.UsernameClaim)
+	}
+	groups := claimStrings(claimValue(claims, a.opts.GroupsClaim))
+
+	userID := OIDCUserPrefix + username
+	if slices.ContainsFunc(groups, func(g string) bool { return slices.Contains(a.opts.AdminGroups, g) }) {
+		userID = adminUserPrefix + userID
+	}
+
+	uid, ok := claimInt(claimValue(claims, a.opts.UIDClaim))
+	if a.opts.UIDClaim == "" || !ok {
+		return userID, Identity{}, false, nil
+	}
+	if uid <= 0 {
+		return "", Identity{}, false, fmt.Errorf("%w: %s claim %d is not allowed", ErrInvalidToken, a.opts.UIDClaim, uid)
+	}
+	id := Identity{UID: uid}
+	if gid, ok := claimInt(claimValue(claims, a.opts.GIDClaim)); a.opts.GIDClaim != "" && ok {
+		id.GIDs = append(id.GIDs, gid)
+	} else {
+		id.GIDs = append(id.GIDs, uid)
+	}
+	var supplementary []int
+	for _, group := range groups {
+		if gid, ok := a.opts.GroupGIDs[group]; ok && !slices.Contains(id.GIDs, gid) && !slices.Contains(supplementary, gid) {
+			supplementary = append(supplementary, gid)
+		}
+	}
+	sort.Ints(supplementary)
+	id.GIDs = append(id.GIDs, supplementary...)
+	return userID, id, true, nil
+}
+
+// verifyJWT checks the signature, issuer, audience and lifetime of a JWT and
+// returns its claims
+func (a *OIDCAuthenticator) verifyJWT(ctx context.Context, token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+	key, err := a.signingKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], parts[2]); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+	if iss, _ := claims["iss"].(string); iss != a.opts.Issuer {
+		return nil, fmt.Errorf("%w: issued by %q", ErrInvalidToken, iss)
+	}
+	if a.opts.Audience != "" && !slices.Contains(claimStrings(claims["aud"]), a.opts.Audience) {
+		return nil, fmt.Errorf("%w: not issued for audience %s", ErrInvalidToken, a.opts.Audience)
+	}
+	if err := checkTokenTimes(claims, true); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// checkTokenTimes rejects a token that expired or is not valid yet
+func checkTokenTimes(claims map[string]any, requireExp bool) error {
+	now := time.Now()
+	exp, ok := claimInt(claims["exp"])
+	if !ok && requireExp {
+		return fmt.Errorf("%w: no exp claim", ErrInvalidToken)
+	}
+	if ok && now.After(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if nbf, ok := claimInt(claims["nbf"]); ok && now.Add(oidcClockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	return nil
+}
+
+// introspect asks the provider whether an opaque token is active, reusing
+// answers for IntrospectionCacheTTL, or until the token expires. Requests
+// with a token already being introspected wait for that answer.
+func (a *OIDCAuthenticator) introspect(ctx context.Context, token string) (map[string]any, error) {
+	hash := sha256.Sum256([]byte(token))
+	a.mu.Lock()
+	cached, ok := a.introspected[hash]
+	if ok && time.Now().Before(cached.expires) {
+		a.mu.Unlock()
+		if cached.claims == nil {
+			return nil, fmt.Errorf("%w: inactive", ErrInvalidToken)
+		}
+		return cached.claims, nil
+	}
+	if pending, ok := a.inflight[hash]; ok {
+		a.mu.Unlock()
+		select {
+		case <-pending.done:
+			return pending.claims, pending.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	pending := &pendingIntrospection{done: make(chan struct{})}
+	a.inflight[hash] = pending
+	a.mu.Unlock()
+
+	pending.claims, pending.err = a.introspectToken(ctx, hash, token)
+	a.mu.Lock()
+	delete(a.inflight, hash)
+	a.mu.Unlock()
+	close(pending.done)
+	return pending.claims, pending.err
+}
+
+// introspectToken asks the provider about token and caches the answer
+func (a *OIDCAuthenticator) introspectToken(ctx context.Context, hash [sha256.Size]byte, token string) (map[string]any, error) {
+	endpoint := a.opts.IntrospectionURL
+	if endpoint == "" {
+		discovery, err := a.discover(ctx)
+		if err != nil {
+			return nil, err
+		}
+		endpoint = discovery.IntrospectionEndpoint
+	}
+	if endpoint == "" {
+		return nil, fmt.Errorf("%w: not a JWT, and the provider has no introspection endpoint", ErrInvalidToken)
+	}
+	if a.introspections != nil && !a.introspections.Allow() {
+		return nil, ErrIntrospectionLimited
+	}
+
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	var claims map[string]any
+	if err := a.postForm(ctx, endpoint, form, &claims); err != nil {
+		return nil, fmt.Errorf("token introspection failed: %w", err)
+	}
+
+	now := time.Now()
+	result := introspectionResult{expires: now.Add(a.opts.IntrospectionCacheTTL)}
+	if active, _ := claims["active"].(bool); active {
+		if iss, ok := claims["iss"].(string); ok && iss != a.opts.Issuer {
+			active = false
+		} else if checkTokenTimes(claims, false) != nil {
+			active = false
+		}
+		if active {
+			result.claims = claims
+			if exp, ok := claimInt(claims["exp"]); ok {
+				result.expires = minTime(result.expires, time.Unix(int64(exp), 0))
+			}
+		}
+	}
+	a.mu.Lock()
+	if len(a.introspected) >= oidcMaxCachedTokens {
+		for key, cached := range a.introspected {
+			if !now.Before(cached.expires) {
+				delete(a.introspected, key)
+			}
+		}
+		// Make room by dropping rejections first, so a flood of bad
+		// tokens does not evict the answers for users' tokens
+		for key, cached := range a.introspected {
+			if len(a.introspected) < oidcMaxCachedTokens {
+				break
+			}
+			if cached.claims == nil {
+				delete(a.introspected, key)
+			}
+		}
+		for key := range a.introspected {
+			if len(a.introspected) < oidcMaxCachedTokens {
+				break
+			}
+			delete(a.introspected, key)
+		}
+	}
+	a.introspected[hash] = result
+	a.mu.Unlock()
+
+	if result.claims == nil {
+		return nil, fmt.Errorf("%w: inactive", ErrInvalidToken)
+	}
+	return result.claims, nil
+}
+
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+// LoginEndpoints returns the provider's authorization and token endpoints,
+// for signing in to the web file browser
+func (a *OIDCAuthenticator) LoginEndpoints(ctx context.Context) (authorization, token string, err error) {
+	discovery, err := a.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" {
+		return "", "", errors.New("the OIDC provider has no authorization or token endpoint")
+	}
+	return discovery.AuthorizationEndpoint, discovery.TokenEndpoint, nil
+}
+
+// ClientID returns the client ID the web file browser signs in with
+func (a *OIDCAuthenticator) ClientID() string {
+	return a.opts.ClientID
+}
+
+// Scopes returns the scopes the web file browser requests when signing in
+func (a *OIDCAuthenticator) Scopes() []string {
+	return a.opts.Scopes
+}
+
+// ExchangeCode redeems an authorization code obtained with PKCE for an
+// access token, which it checks the authenticator accepts
+func (a *OIDCAuthenticator) ExchangeCode(ctx context.Context, code, codeVerifier, redirectURI string) (accessToken string, expiresIn int, err error) {
+	_, tokenEndpoint, err := a.LoginEndpoints(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"code_verifier": {codeVerifier},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {a.opts.ClientID},
+	}
+	var response struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := a.postForm(ctx, tokenEndpoint, form, &response); err != nil {
+		return "", 0, fmt.Errorf("code exchange failed: %w", err)
+	}
+	if response.AccessToken == "" {
+		return "", 0, errors.New("code exchange failed: no access token")
+	}
+	if _, err := a.Authenticate(ctx, response.AccessToken); err != nil {
+		return "", 0, fmt.Errorf("the access token issued is not accepted: %w", err)
+	}
+	return response.AccessToken, response.ExpiresIn, nil
+}
+
+// discover reads the provider metadata at the issuer's well-known URL. A
+// failure is retried on the next call.
+func (a *OIDCAuthenticator) discover(ctx context.Context) (*oidcDiscovery, error) {
+	a.mu.Lock()
+	discovery := a.discovery
+	a.mu.Unlock()
+	if discovery != nil {
+		return discovery, nil
+	}
+
+	discovery = &oidcDiscovery{}
+	if err := a.getJSON(ctx, strings.TrimSuffix(a.opts.Issuer, "/")+"/.well-known/openid-configuration", discovery); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	if discovery.Issuer != a.opts.Issuer {
+		return nil, fmt.Errorf("OIDC discovery failed: provider issuer %q differs from %q", discovery.Issuer, a.opts.Issuer)
+	}
+	a.mu.Lock()
+	a.discovery = discovery
+	a.mu.Unlock()
+	return discovery, nil
+}
+
+// signingKey returns the provider key with ID kid, or its only key when the
+// token names none, fetching the keys again when they are old or lack kid
+func (a *OIDCAuthenticator) signingKey(ctx context.Context, kid string) (any, error) {
+	a.mu.Lock()
+	key, stale := lookupKey(a.keys, kid), time.Since(a.keysFetched)
+	a.mu.Unlock()
+	if key != nil && stale < oidcKeysMaxAge {
+		return key, nil
+	}
+	if key == nil && stale < oidcKeysMinRefresh {
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidToken, kid)
+	}
+
+	jwksURL := a.opts.JWKSURL
+	if jwksURL == "" {
+		discovery, err := a.discover(ctx)
+		if err != nil {
+			return nil, err
+		}
+		jwksURL = discovery.JWKSURI
+	}
+	var set jsonWebKeySet
+	if err := a.getJSON(ctx, jwksURL, &set); err != nil {
+		if key != nil {
+			return key, nil // Keep using the old keys while the provider is unreachable
+		}
+		return nil, fmt.Errorf("failed to fetch OIDC signing keys: %w", err)
+	}
+	keys := set.publicKeys()
+
+	a.mu.Lock()
+	a.keys, a.keysFetched = keys, time.Now()
+	a.mu.Unlock()
+	if key = lookupKey(keys, kid); key == nil {
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidToken, kid)
+	}
+	return key, nil
+}
+
+func lookupKey(keys map[string]any, kid string) any {
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key
+		}
+	}
+	return keys[kid]
+}
+
+func (a *OIDCAuthenticator) getJSON(ctx context.Context, endpoint string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	return a.do(req, v)
+}
+
+// postForm posts form to endpoint with the client credentials
+func (a *OIDCAuthenticator) postForm(ctx context.Context, endpoint string, form url.Values, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if a.opts.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(a.opts.ClientID), url.QueryEscape(a.opts.ClientSecret))
+	}
+	return a.do(req, v)
+}
+
+func (a *OIDCAuthenticator) do(req *http.Request, v any) error {
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, oidcMaxResponseBytes))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned HTTP %d", req.URL.Redacted(), resp.StatusCode)
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", req.URL.Redacted(), err)
+	}
+	return nil
+}
+
+// claimValue returns the claim at a dotted path, such as realm_access.roles
+func claimValue(claims map[string]any, path string) any {
+	if path == "" {
+		return nil
+	}
+	var value any = claims
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	return value
+}
+
+// claimStrings returns a string claim, or the strings of a list claim
+func claimStrings(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// claimInt returns a numeric claim, which may be a string, without any
+// fraction
+func claimInt(value any) (int, bool) {
+	var s string
+	switch v := value.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = v
+	default:
+		return 0, false
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return int(n), true
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.Abs(f) > 1<<62 {
+		return 0, false
+	}
+	return int(f), true
+}
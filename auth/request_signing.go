@@ -0,0 +1,400 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SignatureScheme is the Authorization scheme of requests signed with the
+// internal proxy secret
+const SignatureScheme = "CallFS-HMAC-SHA256"
+
+// Headers signed along with the method, host, path and query of a request.
+// Every other X-CallFS-* header and Range are signed too.
+const (
+	SignatureTimestampHeader = "X-CallFS-Timestamp" // Unix seconds
+	SignatureNonceHeader     = "X-CallFS-Nonce"
+	ContentSHA256Header      = "X-CallFS-Content-SHA256" // Hex SHA-256 of the body, or StreamingPayload
+	DecodedLengthHeader      = "X-CallFS-Decoded-Length" // Length of a streamed body, when known
+)
+
+// StreamingPayload is the content hash of a streamed body, which is sent
+// chunked and signed in the ContentSignatureTrailer once it has been read
+const StreamingPayload = "STREAMING-SHA256-TRAILER"
+
+// ContentSignatureTrailer is the trailer carrying the signature of a streamed
+// body: the HMAC of the request signature and the SHA-256 of the body
+const ContentSignatureTrailer = "X-CallFS-Content-Signature"
+
+// Internal request signing modes
+const (
+	SigningRequired = "required" // Sign requests and accept only signed ones
+	SigningOptional = "optional" // Sign requests and also accept the secret as a bearer token
+	SigningOff      = "off"      // Send the secret as a bearer token and accept both
+)
+
+// SignatureMaxSkew is how far the timestamp of a signed request may be from
+// the receiver's clock. Nonces are remembered for as long, so a captured
+// request cannot be replayed.
+const SignatureMaxSkew = time.Minute
+
+// MaxSignedBodyBytes is the largest body signed with its content. Larger and
+// streamed bodies are sent as StreamingPayload.
+const MaxSignedBodyBytes = 256 << 20 // 256 MiB, the largest erasure shard
+
+// RequestSigner authenticates requests to peers with the internal proxy
+// secret, by signing them or, with signing off, sending the secret itself
+type RequestSigner struct {
+	secret string
+	key    []byte // nil when signing is off
+}
+
+// NewRequestSigner returns a RequestSigner for secret in mode
+func NewRequestSigner(secret, mode string) *RequestSigner {
+	s := &RequestSigner{secret: secret}
+	if mode != SigningOff {
+		s.key = requestSigningKey(secret)
+	}
+	return s
+}
+
+func requestSigningKey(secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("callfs internal requests"))
+	return mac.Sum(nil)
+}
+
+// Sign sets the headers authenticating req, and must be called once every
+// other header is set. The body is hashed when it can be read again without
+// consuming it, as for bytes and strings readers. Any other body is streamed:
+// it is sent chunked and hashed as it is read, and its signature follows it in
+// a trailer.
+func (s *RequestSigner) Sign(req *http.Request) error {
+	if s.key == nil {
+		req.Header.Set("Authorization", "Bearer "+s.secret)
+		return nil
+	}
+
+	contentHash := StreamingPayload
+	switch {
+	case req.Body == nil || req.Body == http.NoBody:
+		contentHash = hashHex(nil)
+	case req.GetBody != nil && req.ContentLength >= 0 && req.ContentLength <= MaxSignedBodyBytes:
+		body, err := req.GetBody()
+		if err != nil {
+			return fmt.Errorf("failed to read body to sign: %w", err)
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, body)
+		body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read body to sign: %w", err)
+		}
+		contentHash = hex.EncodeToString(h.Sum(nil))
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	req.Header.Set(SignatureTimestampHeader, strconv.FormatInt(time.Now().Unix(), 10))
+	req.Header.Set(SignatureNonceHeader, hex.EncodeToString(nonce))
+	req.Header.Set(ContentSHA256Header, contentHash)
+	if contentHash == StreamingPayload {
+		if req.ContentLength > 0 {
+			req.Header.Set(DecodedLengthHeader, strconv.FormatInt(req.ContentLength, 10))
+		}
+		// Trailers are only sent with a chunked body, and a retry could
+		// not sign the body again
+		req.ContentLength = -1
+		req.GetBody = nil
+		req.Trailer = http.Header{ContentSignatureTrailer: nil}
+	}
+	signature := signRequest(s.key, req, requestHost(req))
+	req.Header.Set("Authorization", SignatureScheme+" "+base64.RawURLEncoding.EncodeToString(signature))
+
+	if contentHash == StreamingPayload {
+		req.Body = &signingBody{ReadCloser: req.Body, hash: sha256.New(), key: s.key, signature: signature, trailer: req.Trailer}
+	}
+	return nil
+}
+
+// signingBody hashes a streamed body as it is sent, and sets the signature of
+// its content in the request trailer at its end. The client sends the trailer
+// once the body has been read to EOF.
+type signingBody struct {
+	io.ReadCloser
+	hash      hash.Hash
+	key       []byte
+	signature []byte
+	trailer   http.Header
+}
+
+func (b *signingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.hash.Write(p[:n])
+	if err == io.EOF {
+		b.trailer.Set(ContentSignatureTrailer, base64.RawURLEncoding.EncodeToString(signContent(b.key, b.signature, b.hash.Sum(nil))))
+	}
+	return n, err
+}
+
+// signContent returns the signature of a streamed body with hash sum, bound
+// to the request signed with signature
+func signContent(key, signature, sum []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(signature)
+	mac.Write(sum)
+	return mac.Sum(nil)
+}
+
+// requestHost returns the host a client request is sent to, as the receiver
+// sees it in its Host header
+func requestHost(req *http.Request) string {
+	if req.Host != "" {
+		return req.Host
+	}
+	return req.URL.Host
+}
+
+// signRequest returns the signature of the method, host, path, query and
+// signed headers of r. The host binds a request to the peer it was sent to.
+func signRequest(key []byte, r *http.Request, host string) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, part := range []string{
+		SignatureScheme,
+		r.Header.Get(SignatureTimestampHeader),
+		r.Header.Get(SignatureNonceHeader),
+		r.Method,
+		host,
+		r.URL.EscapedPath(),
+		r.URL.RawQuery,
+		r.Header.Get(ContentSHA256Header),
+		"range:" + strings.Join(r.Header.Values("Range"), ","),
+	} {
+		mac.Write([]byte(part))
+		mac.Write([]byte{'\n'})
+	}
+
+	// Every X-CallFS-* header, so a peer cannot be told to act for another
+	// user or to store other attributes
+	var names []string
+	for name := range r.Header {
+		if strings.HasPrefix(strings.ToLower(name), "x-callfs-") {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		mac.Write([]byte(name + ":" + strings.Join(r.Header.Values(name), ",")))
+		mac.Write([]byte{'\n'})
+	}
+	return mac.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// IsSignedRequest reports whether r carries a request signature
+func IsSignedRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Authorization"), SignatureScheme+" ")
+}
+
+// RequestVerifier checks requests from peers, signed with one of the
+// internal proxy secrets, and rejects the replay of one it has seen
+type RequestVerifier struct {
+	keys          [][]byte
+	bearerSecrets []string // Accepted as bearer tokens too, unless signing is required
+
+	mu        sync.Mutex
+	nonces    map[string]time.Time // Seen nonces, until their request expires
+	lastSweep time.Time
+}
+
+// NewRequestVerifier returns a RequestVerifier for secrets, the current one
+// and any accepted during a rotation, in mode
+func NewRequestVerifier(secrets []string, mode string) *RequestVerifier {
+	v := &RequestVerifier{nonces: make(map[string]time.Time)}
+	for _, secret := range secrets {
+		if secret != "" {
+			v.keys = append(v.keys, requestSigningKey(secret))
+		}
+	}
+	if mode != SigningRequired {
+		v.bearerSecrets = secrets
+	}
+	return v
+}
+
+// Verify checks that r was sent by a peer. A signed body is read and checked
+// against its hash, and replaced by its content. A streamed body is replaced
+// by one that fails at its end unless the content matches its signature, and
+// the length it was sent with is restored in r.ContentLength.
+func (v *RequestVerifier) Verify(r *http.Request) error {
+	encoded, signed := strings.CutPrefix(r.Header.Get("Authorization"), SignatureScheme+" ")
+	if !signed {
+		token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		if MatchesSecret(token, v.bearerSecrets) {
+			return nil
+		}
+		return ErrAuthenticationFailed
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+	timestamp, err := strconv.ParseInt(r.Header.Get(SignatureTimestampHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: missing timestamp", ErrInvalidToken)
+	}
+	signedAt := time.Unix(timestamp, 0)
+	if skew := time.Since(signedAt); skew > SignatureMaxSkew || skew < -SignatureMaxSkew {
+		return fmt.Errorf("%w: timestamp is %s away from this instance's clock", ErrInvalidToken, skew.Round(time.Second))
+	}
+	nonce := r.Header.Get(SignatureNonceHeader)
+	if nonce == "" || len(nonce) > 64 {
+		return fmt.Errorf("%w: missing nonce", ErrInvalidToken)
+	}
+	contentHash := r.Header.Get(ContentSHA256Header)
+	decodedLength := int64(-1)
+	if contentHash == StreamingPayload {
+		if _, declared := r.Trailer[http.CanonicalHeaderKey(ContentSignatureTrailer)]; !declared {
+			return fmt.Errorf("%w: streamed body has no signature trailer", ErrInvalidToken)
+		}
+		if value := r.Header.Get(DecodedLengthHeader); value != "" {
+			decodedLength, err = strconv.ParseInt(value, 10, 64)
+			if err != nil || decodedLength < 0 {
+				return fmt.Errorf("%w: malformed %s", ErrInvalidToken, DecodedLengthHeader)
+			}
+		}
+	}
+
+	var key []byte
+	for _, k := range v.keys {
+		if hmac.Equal(signature, signRequest(k, r, r.Host)) {
+			key = k
+			break
+		}
+	}
+	if key == nil {
+		return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+	if !v.useNonce(nonce, signedAt.Add(SignatureMaxSkew)) {
+		return fmt.Errorf("%w: replayed request", ErrInvalidToken)
+	}
+
+	if contentHash == StreamingPayload {
+		r.Body = &verifyingBody{
+			ReadCloser: r.Body,
+			hash:       sha256.New(),
+			key:        key,
+			signature:  signature,
+			trailer:    r.Trailer,
+			remaining:  decodedLength,
+		}
+		if decodedLength >= 0 {
+			r.ContentLength = decodedLength
+		}
+		return nil
+	}
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(io.LimitReader(r.Body, MaxSignedBodyBytes+1))
+		r.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read signed body: %w", err)
+		}
+	}
+	if len(body) > MaxSignedBodyBytes || !hmac.Equal([]byte(hashHex(body)), []byte(strings.ToLower(contentHash))) {
+		return fmt.Errorf("%w: body does not match its signed hash", ErrInvalidToken)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return nil
+}
+
+// verifyingBody hashes a streamed body as it is read, and at its end checks
+// it against the signature in the request trailer, returning an error in
+// place of EOF when they differ. A body longer or shorter than the length it
+// was signed with fails too.
+type verifyingBody struct {
+	io.ReadCloser
+	hash      hash.Hash
+	key       []byte
+	signature []byte
+	trailer   http.Header
+	remaining int64 // -1 when the length was not signed
+	verified  bool
+}
+
+func (b *verifyingBody) Read(p []byte) (int, error) {
+	if b.verified {
+		return 0, io.EOF
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.hash.Write(p[:n])
+	if b.remaining >= 0 {
+		b.remaining -= int64(n)
+		if b.remaining == 0 && err == nil {
+			// A reader that stops at the signed length must still see the
+			// end of the body, where the trailer is read
+			var extra [1]byte
+			var m int
+			m, err = io.ReadAtLeast(b.ReadCloser, extra[:], 1)
+			b.remaining -= int64(m)
+		}
+		if b.remaining < 0 {
+			return n, fmt.Errorf("%w: body is longer than its signed length", ErrInvalidToken)
+		}
+	}
+	if err != io.EOF {
+		return n, err
+	}
+
+	if b.remaining > 0 {
+		return n, fmt.Errorf("%w: body is shorter than its signed length", ErrInvalidToken)
+	}
+	trailer, decodeErr := base64.RawURLEncoding.DecodeString(b.trailer.Get(ContentSignatureTrailer))
+	if decodeErr != nil || !hmac.Equal(trailer, signContent(b.key, b.signature, b.hash.Sum(nil))) {
+		return n, fmt.Errorf("%w: body does not match its signature", ErrInvalidToken)
+	}
+	b.verified = true
+	return n, io.EOF
+}
+
+// useNonce records nonce until expires, reporting false if it was already
+// used
+func (v *RequestVerifier) useNonce(nonce string, expires time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(v.lastSweep) > SignatureMaxSkew {
+		v.lastSweep = now
+		for n, exp := range v.nonces {
+			if now.After(exp) {
+				delete(v.nonces, n)
+			}
+		}
+	}
+	if _, seen := v.nonces[nonce]; seen {
+		return false
+	}
+	v.nonces[nonce] = expires
+	return true
+}
@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testSecret = "test-internal-secret"
+
+// received returns the request a peer receives for the signed client request
+// req
+func received(t *testing.T, req *http.Request) *http.Request {
+	t.Helper()
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			t.Fatalf("read body: %v", err)
+		}
+	}
+	r := httptest.NewRequest(req.Method, req.URL.String(), bytes.NewReader(body))
+	r.Host = requestHost(req)
+	for name, values := range req.Header {
+		r.Header[name] = append([]string(nil), values...)
+	}
+	return r
+}
+
+func newSignedRequest(t *testing.T, method, body string) *http.Request {
+	t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, "http://peer.example:8443/v1/files/a/b.txt?x=1", reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-CallFS-On-Behalf-Of", "alice")
+	req.Header.Set("X-CallFS-Mode", "0640")
+	if err := NewRequestSigner(testSecret, SigningRequired).Sign(req); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return req
+}
+
+func TestVerifySignedRequest(t *testing.T) {
+	v := NewRequestVerifier([]string{testSecret}, SigningRequired)
+	r := received(t, newSignedRequest(t, http.MethodPost, "hello"))
+	if err := v.Verify(r); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	body, _ := io.ReadAll(r.Body)
+	if string(body) != "hello" {
+		t.Errorf("body after verify = %q", body)
+	}
+
+	// The same request again is a replay
+	r = received(t, newSignedRequest(t, http.MethodGet, ""))
+	replay := r.Clone(r.Context())
+	if err := v.Verify(r); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if err := v.Verify(replay); err == nil {
+		t.Error("replayed request was accepted")
+	}
+}
+
+func TestVerifyTamperedRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(r *http.Request)
+	}{
+		{"on behalf of", func(r *http.Request) { r.Header.Set("X-CallFS-On-Behalf-Of", "root") }},
+		{"mode", func(r *http.Request) { r.Header.Set("X-CallFS-Mode", "4777") }},
+		{"added header", func(r *http.Request) { r.Header.Set("X-CallFS-UID", "0") }},
+		{"removed header", func(r *http.Request) { r.Header.Del("X-CallFS-Mode") }},
+		{"range", func(r *http.Request) { r.Header.Set("Range", "bytes=0-9") }},
+		{"method", func(r *http.Request) { r.Method = http.MethodPut }},
+		{"host", func(r *http.Request) { r.Host = "other.example:8443" }},
+		{"path", func(r *http.Request) { r.URL.Path = "/v1/files/a/c.txt"; r.URL.RawPath = "" }},
+		{"query", func(r *http.Request) { r.URL.RawQuery = "x=2" }},
+		{"body", func(r *http.Request) { r.Body = io.NopCloser(strings.NewReader("hellO")) }},
+		{"unsigned payload", func(r *http.Request) { r.Header.Set(ContentSHA256Header, "UNSIGNED-PAYLOAD") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewRequestVerifier([]string{testSecret}, SigningRequired)
+			r := received(t, newSignedRequest(t, http.MethodPost, "hello"))
+			tt.tamper(r)
+			if err := v.Verify(r); err == nil {
+				t.Error("tampered request was accepted")
+			}
+		})
+	}
+}
+
+func TestVerifyUnsignedPayloadRefused(t *testing.T) {
+	// Signed correctly, but without its content
+	req := newSignedRequest(t, http.MethodPut, "hello")
+	req.Header.Set(ContentSHA256Header, "UNSIGNED-PAYLOAD")
+	key := requestSigningKey(testSecret)
+	req.Header.Set("Authorization", SignatureScheme+" "+base64.RawURLEncoding.EncodeToString(signRequest(key, req, requestHost(req))))
+
+	v := NewRequestVerifier([]string{testSecret}, SigningOptional)
+	if err := v.Verify(received(t, req)); err == nil {
+		t.Error("UNSIGNED-PAYLOAD body was accepted")
+	}
+}
+
+func TestVerifyBearerSecret(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/v1/files/a", nil)
+	req.Header.Set("Authorization", "Bearer "+testSecret)
+	if err := NewRequestVerifier([]string{testSecret}, SigningOptional).Verify(req); err != nil {
+		t.Errorf("optional mode refused the bearer secret: %v", err)
+	}
+	if err := NewRequestVerifier([]string{testSecret}, SigningRequired).Verify(req); err == nil {
+		t.Error("required mode accepted the bearer secret")
+	}
+}
+
+// streamingReader hides the type of its content, so the body cannot be read
+// again and is signed as a stream
+type streamingReader struct{ io.Reader }
+
+func TestVerifyStreamedBody(t *testing.T) {
+	content := strings.Repeat("streamed content ", 4096)
+	tests := []struct {
+		name   string
+		tamper func(r *http.Request)
+		ok     bool
+	}{
+		{"intact", func(r *http.Request) {}, true},
+		{"altered", func(r *http.Request) {
+			r.Body = io.NopCloser(&flipReader{Reader: r.Body, at: 100})
+		}, false},
+		{"truncated", func(r *http.Request) {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(io.LimitReader(r.Body, int64(len(content)-1)), drain{r.Body}), r.Body}
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewRequestVerifier([]string{testSecret}, SigningRequired)
+			var verifyErr, readErr error
+			var got []byte
+			var gotLength int64
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tt.tamper(r)
+				if verifyErr = v.Verify(r); verifyErr == nil {
+					gotLength = r.ContentLength
+					got, readErr = io.ReadAll(r.Body)
+				}
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer server.Close()
+
+			req, err := http.NewRequest(http.MethodPut, server.URL+"/v1/files/big", streamingReader{strings.NewReader(content)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.ContentLength = int64(len(content))
+			if err := NewRequestSigner(testSecret, SigningRequired).Sign(req); err != nil {
+				t.Fatalf("sign: %v", err)
+			}
+			if req.Header.Get(ContentSHA256Header) != StreamingPayload {
+				t.Fatalf("content hash = %q, want a streamed body", req.Header.Get(ContentSHA256Header))
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("send: %v", err)
+			}
+			resp.Body.Close()
+
+			if verifyErr != nil {
+				t.Fatalf("verify: %v", verifyErr)
+			}
+			if tt.ok {
+				if readErr != nil || string(got) != content {
+					t.Errorf("read %d bytes, err %v", len(got), readErr)
+				}
+				if gotLength != int64(len(content)) {
+					t.Errorf("ContentLength = %d, want %d", gotLength, len(content))
+				}
+			} else if readErr == nil {
+				t.Error("tampered body was read without error")
+			}
+		})
+	}
+}
+
+// flipReader changes the byte at offset at
+type flipReader struct {
+	io.Reader
+	at  int
+	pos int
+}
+
+func (f *flipReader) Read(p []byte) (int, error) {
+	n, err := f.Reader.Read(p)
+	if f.at >= f.pos && f.at < f.pos+n {
+		p[f.at-f.pos] ^= 0xff
+	}
+	f.pos += n
+	return n, err
+}
+
+// drain reads r to its end without returning its content
+type drain struct{ r io.Reader }
+
+func (d drain) Read(p []byte) (int, error) {
+	_, err := io.Copy(io.Discard, d.r)
+	if err == nil {
+		err = io.EOF
+	}
+	return 0, err
+}
@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ScopedTokenPrefix starts the tokens minted by POST /v1/tokens
+const ScopedTokenPrefix = "cfs_"
+
+// Verbs a scoped token may be granted
+const (
+	VerbRead   = "read"   // Read files, list directories and create download links
+	VerbWrite  = "write"  // Create and update paths and change their attributes
+	VerbDelete = "delete" // Delete paths, and move them away
+)
+
+// ErrInvalidScope is returned for a scope a token cannot be minted with
+var ErrInvalidScope = errors.New("invalid token scope")
+
+// TokenScope restricts a scoped token to the paths below Path and the
+// permissions of Verbs, until Expires
+type TokenScope struct {
+	Path    string    `json:"path"`
+	Verbs   []string  `json:"verbs"`
+	Expires time.Time `json:"expires"`
+}
+
+// Covers reports whether path is the scope's path or below it
+func (s *TokenScope) Covers(path string) bool {
+	prefix := strings.TrimSuffix(s.Path, "/")
+	path = "/" + strings.Trim(path, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// Allows reports whether the scope grants perm
+func (s *TokenScope) Allows(perm PermissionType) bool {
+	switch perm {
+	case ReadPerm, SearchPerm:
+		return slices.Contains(s.Verbs, VerbRead)
+	case WritePerm, ChmodPerm, ChownPerm:
+		return slices.Contains(s.Verbs, VerbWrite)
+	case DeletePerm:
+		return slices.Contains(s.Verbs, VerbDelete)
+	}
+	return false
+}
+
+// Within reports whether every request s allows, other allows too
+func (s *TokenScope) Within(other *TokenScope) bool {
+	if !other.Covers(s.Path) || s.Expires.After(other.Expires) {
+		return false
+	}
+	for _, verb := range s.Verbs {
+		if !slices.Contains(other.Verbs, verb) {
+			return false
+		}
+	}
+	return true
+}
+
+type tokenScopeKey struct{}
+
+// WithTokenScope returns a context whose requests are restricted to scope
+func WithTokenScope(ctx context.Context, scope *TokenScope) context.Context {
+	return context.WithValue(ctx, tokenScopeKey{}, scope)
+}
+
+// TokenScopeFromContext returns the scope of the token a request was
+// authenticated with, if it was a scoped token
+func TokenScopeFromContext(ctx context.Context) (*TokenScope, bool) {
+	scope, ok := ctx.Value(tokenScopeKey{}).(*TokenScope)
+	return scope, ok && scope != nil
+}
+
+// ScopedTokens mints scoped tokens and authenticates them, and every other
+// token with the authenticator it wraps. Tokens are signed, not stored, so
+// every instance sharing the secret accepts them until they expire.
+type ScopedTokens struct {
+	next         Authenticator
+	key          []byte
+	acceptedKeys [][]byte // Also verify tokens, during a rotation of the secret
+	maxTTL       time.Duration
+}
+
+// NewScopedTokens returns ScopedTokens signing with a key derived from
+// secret, which other uses of the secret cannot produce, and also verifying
+// with keys derived from acceptedSecrets. Tokens live at most maxTTL.
+func NewScopedTokens(next Authenticator, secret string, acceptedSecrets []string, maxTTL time.Duration) *ScopedTokens {
+	t := &ScopedTokens{next: next, key: scopedTokenKey(secret), maxTTL: maxTTL}
+	for _, s := range acceptedSecrets {
+		if s != "" {
+			t.acceptedKeys = append(t.acceptedKeys, scopedTokenKey(s))
+		}
+	}
+	return t
+}
+
+func scopedTokenKey(secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("callfs scoped tokens"))
+	return mac.Sum(nil)
+}
+
+// scopedTokenClaims is the signed payload of a scoped token
+type scopedTokenClaims struct {
+	UserID string   `json:"sub"`
+	Path   string   `json:"path"`
+	Verbs  []string `json:"verbs"`
+	Exp    int64    `json:"exp"`
+}
+
+// MaxTTL returns the longest lifetime of a token
+func (t *ScopedTokens) MaxTTL() time.Duration {
+	return t.maxTTL
+}
+
+// Issue mints a token acting as userID within scope. Its permissions are
+// those of userID, restricted by the scope.
+func (t *ScopedTokens) Issue(userID string, scope TokenScope) (string, error) {
+	if userID == "" || userID == InternalProxyUserID {
+		return "", fmt.Errorf("%w: tokens cannot act as %q", ErrInvalidScope, userID)
+	}
+	if !strings.HasPrefix(scope.Path, "/") {
+		return "", fmt.Errorf("%w: path must be absolute", ErrInvalidScope)
+	}
+	if len(scope.Verbs) == 0 {
+		return "", fmt.Errorf("%w: verbs must not be empty", ErrInvalidScope)
+	}
+	for _, verb := range scope.Verbs {
+		if verb != VerbRead && verb != VerbWrite && verb != VerbDelete {
+			return "", fmt.Errorf("%w: unknown verb %q", ErrInvalidScope, verb)
+		}
+	}
+	ttl := time.Until(scope.Expires)
+	if ttl <= 0 || ttl > t.maxTTL+time.Second {
+		return "", fmt.Errorf("%w: tokens must expire within %s", ErrInvalidScope, t.maxTTL)
+	}
+
+	payload, err := json.Marshal(scopedTokenClaims{UserID: userID, Path: scope.Path, Verbs: scope.Verbs, Exp: scope.Expires.Unix()})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return ScopedTokenPrefix + encoded + "." + base64.RawURLEncoding.EncodeToString(t.sign(t.key, encoded)), nil
+}
+
+func (t *ScopedTokens) sign(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// Authenticate authenticates unrestricted tokens with the wrapped
+// authenticator. Scoped tokens need AuthenticateScoped, so that their scope
+// is not lost.
+func (t *ScopedTokens) Authenticate(ctx context.Context, token string) (string, error) {
+	token = strings.TrimSpace(strings.TrimPrefix(token, "Bearer "))
+	if strings.HasPrefix(token, ScopedTokenPrefix) {
+		return "", ErrAuthenticationFailed
+	}
+	return t.next.Authenticate(ctx, token)
+}
+
+// AuthenticateScoped authenticates a token, returning the scope of a scoped
+// token or nil for any other
+func (t *ScopedTokens) AuthenticateScoped(ctx context.Context, token string) (string, *TokenScope, error) {
+	token = strings.TrimSpace(strings.TrimPrefix(token, "Bearer "))
+	rest, ok := strings.CutPrefix(token, ScopedTokenPrefix)
+	if !ok {
+		userID, err := t.next.Authenticate(ctx, token)
+		return userID, nil, err
+	}
+
+	encoded, signature, ok := strings.Cut(rest, ".")
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if !ok || err != nil {
+		return "", nil, ErrInvalidToken
+	}
+	valid := false
+	for _, key := range append([][]byte{t.key}, t.acceptedKeys...) {
+		if hmac.Equal(sig, t.sign(key, encoded)) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return "", nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, ErrInvalidToken
+	}
+	var claims scopedTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.UserID == "" {
+		return "", nil, ErrInvalidToken
+	}
+	expires := time.Unix(claims.Exp, 0)
+	if !time.Now().Before(expires) {
+		return "", nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	return claims.UserID, &TokenScope{Path: claims.Path, Verbs: claims.Verbs, Expires: expires}, nil
+}
@@ -12,96 +12,190 @@ import (
 // UnixAuthorizer implements Unix-style permission checking
 type UnixAuthorizer struct {
 	metadataStore metadata.Store
+	identities    IdentityProvider
+	directories   MetadataGetter // Reads the directories above a path; the store when nil
 }
 
-// NewUnixAuthorizer creates a new Unix-style authorizer
-func NewUnixAuthorizer(metadataStore metadata.Store) *UnixAuthorizer {
+// MetadataGetter reads the metadata of a path, such as through a cache in
+// front of the metadata store
+type MetadataGetter interface {
+	GetMetadata(ctx context.Context, path string) (*metadata.Metadata, error)
+}
+
+// NewUnixAuthorizer creates a new Unix-style authorizer. Principals are
+// checked as the identities the provider maps them to; those it does not
+// know, or all when identities is nil, get default identities.
+func NewUnixAuthorizer(metadataStore metadata.Store, identities IdentityProvider) *UnixAuthorizer {
 	return &UnixAuthorizer{
 		metadataStore: metadataStore,
+		identities:    identities,
+	}
+}
+
+// SetDirectoryCache reads the directories searched on the way to a path
+// through cache, so checking a deep path does not query the store for every
+// directory above it
+func (a *UnixAuthorizer) SetDirectoryCache(cache MetadataGetter) {
+	a.directories = cache
+}
+
+// Identity returns the identity userID acts as, and whether the provider
+// mapped it rather than it being a default identity
+func (a *UnixAuthorizer) Identity(ctx context.Context, userID string) (Identity, bool, error) {
+	if a.identities != nil {
+		id, found, err := a.identities.Identity(ctx, userID)
+		if err != nil {
+			return Identity{}, false, fmt.Errorf("failed to resolve identity of %s: %w", userID, err)
+		}
+		if found {
+			return id, true, nil
+		}
 	}
+	return defaultIdentity(userID), false, nil
 }
 
 // Authorize checks if a user has the specified permission for a path
 func (a *UnixAuthorizer) Authorize(ctx context.Context, userID string, path string, perm PermissionType) error {
+	// Scoped tokens reach only their path, with the permissions of their verbs
+	if scope, ok := TokenScopeFromContext(ctx); ok && (!scope.Covers(path) || !scope.Allows(perm)) {
+		return ErrPermissionDenied
+	}
+
+	// Only privileged users give files away, as chown(2) requires root
+	if perm == ChownPerm {
+		if privileged(userID) {
+			return nil
+		}
+		return ErrPermissionDenied
+	}
+
+	// Requests a peer forwards for a client act as that client; the internal
+	// proxy itself only acts for the instances' own operations
+	if userID == InternalProxyUserID {
+		return nil
+	}
+
+	id, _, err := a.Identity(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	// Like path resolution, reaching path needs search permission on every
+	// directory above it
+	if err := a.checkTraversal(ctx, id, path); err != nil {
+		return err
+	}
+
 	// Get metadata for the file/directory
 	md, err := a.metadataStore.Get(ctx, path)
 	if err != nil {
 		if err == metadata.ErrNotFound {
 			// For write operations on non-existent files, check parent directory
 			if perm == WritePerm {
-				return a.checkParentPermission(ctx, userID, path, WritePerm)
+				return a.checkParentPermission(ctx, id, path, WritePerm)
 			}
 			return metadata.ErrNotFound
 		}
 		return fmt.Errorf("failed to get metadata for authorization: %w", err)
 	}
 
-	// For now, implement basic permission logic
-	// In a real implementation, you would map userID to actual Unix UID/GID
-	return a.checkUnixPermissions(md, userID, perm)
+	// Like chmod(2) and utimes(2) with explicit times, only the owner may
+	// change the mode or timestamps
+	if perm == ChmodPerm {
+		if privileged(userID) || id.UID == md.UID {
+			return nil
+		}
+		return ErrPermissionDenied
+	}
+
+	return checkUnixPermissions(md, id, perm)
 }
 
-// checkUnixPermissions performs Unix-style permission checking
-func (a *UnixAuthorizer) checkUnixPermissions(md *metadata.Metadata, userID string, perm PermissionType) error {
-	// Parse mode string (e.g., "0644" -> 644)
-	mode, err := parseModeBits(md.Mode)
-	if err != nil {
-		return fmt.Errorf("invalid mode format: %s", md.Mode)
-	}
+// privileged reports whether userID may change any file's attributes: root,
+// admin keys and other instances proxying a request
+func privileged(userID string) bool {
+	return userID == "root" || IsAdmin(userID) || userID == InternalProxyUserID
+}
 
-	// Derive UID and GID from userID: root=0/0, api-user-N uses UID/GID 1000+N, others get 1000
-	var userUID, userGID int
-	if userID == "root" {
-		userUID = 0
-		userGID = 0
-	} else if strings.HasPrefix(userID, "api-user-") {
-		if n, err := strconv.Atoi(strings.TrimPrefix(userID, "api-user-")); err == nil {
-			userUID = 1000 + n
-			userGID = 1000 + n
-		} else {
-			userUID = 1000
-			userGID = 1000
-		}
-	} else {
-		userUID = 1000
-		userGID = 1000
+// checkTraversal checks that id may search each directory above path, from
+// the root down. A missing root, as before it is bootstrapped, is searchable.
+func (a *UnixAuthorizer) checkTraversal(ctx context.Context, id Identity, path string) error {
+	if id.UID == 0 {
+		return nil
 	}
-
-	// Determine permission bits to check: owner → group (using GID) → other
-	var permBits uint64
-	switch perm {
-	case ReadPerm:
-		if userUID == md.UID {
-			permBits = mode >> 6 & 4
-		} else if userGID == md.GID {
-			permBits = mode >> 3 & 4
-		} else {
-			permBits = mode & 4
+	get := a.metadataStore.Get
+	if a.directories != nil {
+		get = a.directories.GetMetadata
+	}
+	for _, dir := range ancestorDirs(path) {
+		md, err := get(ctx, dir)
+		if err != nil {
+			if err == metadata.ErrNotFound {
+				if dir == "/" {
+					continue
+				}
+				return metadata.ErrNotFound
+			}
+			return fmt.Errorf("failed to get metadata for authorization: %w", err)
 		}
-	case WritePerm:
-		if userUID == md.UID {
-			permBits = mode >> 6 & 2
-		} else if userGID == md.GID {
-			permBits = mode >> 3 & 2
-		} else {
-			permBits = mode & 2
+		if err := checkUnixPermissions(md, id, SearchPerm); err != nil {
+			return err
 		}
-	case DeletePerm:
-		if userUID == md.UID {
-			permBits = mode >> 6 & 2
-		} else if userGID == md.GID {
-			permBits = mode >> 3 & 2
-		} else {
-			permBits = mode & 2
+	}
+	return nil
+}
+
+// ancestorDirs returns the directories above path, from "/" down to its
+// parent
+func ancestorDirs(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	dirs := []string{"/"}
+	for i, c := range path {
+		if c == '/' {
+			dirs = append(dirs, "/"+path[:i])
 		}
 	}
+	return dirs
+}
+
+// checkUnixPermissions performs Unix-style permission checking for id. Like
+// Unix, it uses the owner bits for the owner, the group bits for members of
+// the group and the other bits for everyone else, never a combination.
+func checkUnixPermissions(md *metadata.Metadata, id Identity, perm PermissionType) error {
+	// Parse mode string (e.g., "0644" -> 644)
+	mode, err := parseModeBits(md.Mode)
+	if err != nil {
+		return fmt.Errorf("invalid mode format: %s", md.Mode)
+	}
 
 	// Root user bypasses permission checks
-	if userUID == 0 {
+	if id.UID == 0 {
 		return nil
 	}
 
-	if permBits == 0 {
+	var class uint64
+	switch {
+	case id.UID == md.UID:
+		class = mode >> 6 & 7
+	case id.InGroup(md.GID):
+		class = mode >> 3 & 7
+	default:
+		class = mode & 7
+	}
+
+	var want uint64
+	switch perm {
+	case ReadPerm:
+		want = 4
+	case WritePerm, DeletePerm:
+		want = 2
+	case SearchPerm:
+		want = 1
+	}
+	if class&want == 0 {
 		return ErrPermissionDenied
 	}
 
@@ -123,7 +217,7 @@ func parseModeBits(mode string) (uint64, error) {
 }
 
 // checkParentPermission checks permissions on parent directory
-func (a *UnixAuthorizer) checkParentPermission(ctx context.Context, userID string, path string, perm PermissionType) error {
+func (a *UnixAuthorizer) checkParentPermission(ctx context.Context, id Identity, path string, perm PermissionType) error {
 	// Extract parent directory path
 	lastSlash := strings.LastIndex(path, "/")
 	if lastSlash <= 0 {
@@ -135,7 +229,7 @@ func (a *UnixAuthorizer) checkParentPermission(ctx context.Context, userID strin
 			}
 			return fmt.Errorf("failed to get root metadata: %w", err)
 		}
-		return a.checkUnixPermissions(parentMd, userID, perm)
+		return checkUnixPermissions(parentMd, id, perm)
 	}
 
 	parentPath := path[:lastSlash]
@@ -152,5 +246,5 @@ func (a *UnixAuthorizer) checkParentPermission(ctx context.Context, userID strin
 		return fmt.Errorf("failed to get parent metadata: %w", err)
 	}
 
-	return a.checkUnixPermissions(parentMd, userID, perm)
+	return checkUnixPermissions(parentMd, id, perm)
 }
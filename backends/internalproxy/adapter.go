@@ -4,18 +4,28 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/backends"
+	corelog "github.com/ebogdum/callfs/core/log"
 	"github.com/ebogdum/callfs/metadata"
+	"github.com/ebogdum/callfs/metrics"
+	"github.com/ebogdum/callfs/scan"
 )
 
 type proxiedFileInfo struct {
@@ -32,47 +42,202 @@ type proxiedFileInfo struct {
 // InternalProxyAdapter implements the backends.Storage interface by proxying requests
 // to other CallFS instances for Local FS content
 type InternalProxyAdapter struct {
-	client            *http.Client
-	instanceMap       map[string]string // instanceID -> endpoint
-	internalAuthToken string
-	logger            *zap.Logger
+	client       *http.Client
+	streamClient *http.Client // No overall timeout; file bodies run under streamContext
+	instanceMu   sync.RWMutex
+	instanceMap  map[string]string   // instanceID -> endpoint
+	internalMap  map[string]string   // instanceID -> internal listener endpoint, for /v1/internal/* calls
+	signer       *auth.RequestSigner // Authenticates requests to peers
+	health       *peerHealth
+	logger       *zap.Logger
 }
 
-// NewInternalProxyAdapter creates a new internal proxy adapter
-func NewInternalProxyAdapter(peerEndpoints map[string]string, authToken string, skipTLSVerify bool, logger *zap.Logger) (*InternalProxyAdapter, error) {
-	// Configure HTTP transport with optional TLS skip verification
+// defaultProxyDialTimeout applies when ClientOptions.DialTimeout is unset
+const defaultProxyDialTimeout = 10 * time.Second
+
+// ClientOptions configures the HTTP client used to reach peers
+type ClientOptions struct {
+	SkipTLSVerify bool          // Skip certificate verification of https peers
+	H2C           bool          // Speak unencrypted HTTP/2 (h2c) to http peers; they must accept it
+	Timeout       time.Duration // Overall limit for requests without a file body (0 disables it)
+	DialTimeout   time.Duration // Limit for connecting to a peer, TLS handshake included
+	HeaderTimeout time.Duration // Limit for a peer's response headers once the request, body included, is sent (0 disables it)
+}
+
+// NewInternalProxyAdapter creates a new internal proxy adapter. Peers that keep
+// failing are taken out of use as described by health.
+func NewInternalProxyAdapter(peerEndpoints map[string]string, signer *auth.RequestSigner, opts ClientOptions, health HealthOptions, logger *zap.Logger) (*InternalProxyAdapter, error) {
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = defaultProxyDialTimeout
+	}
+
+	// Connecting and waiting for response headers are bounded here: a peer
+	// that accepts a request but never answers fails rather than hanging. The
+	// header clock starts once the request body is sent and stops before the
+	// response body is read, so file bodies may take any time. The
+	// non-streaming client also applies its overall timeout.
+	dialer := &net.Dialer{Timeout: opts.DialTimeout, KeepAlive: 30 * time.Second}
 	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
 		ForceAttemptHTTP2:     true,
 		MaxIdleConns:          256,
 		MaxIdleConnsPerHost:   100,
 		MaxConnsPerHost:       200,
 		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
+		TLSHandshakeTimeout:   opts.DialTimeout,
+		ResponseHeaderTimeout: opts.HeaderTimeout,
 		ExpectContinueTimeout: 1 * time.Second,
 		DisableCompression:    true, // Let the client handle compression
 	}
 
+	// HTTP/2 is negotiated with https peers; h2c has no negotiation, so every
+	// http peer is then spoken to in HTTP/2 directly
+	if opts.H2C {
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+
 	// Configure TLS settings if needed
-	if skipTLSVerify {
+	if opts.SkipTLSVerify {
 		transport.TLSClientConfig = &tls.Config{
 			InsecureSkipVerify: true,
 		}
 	}
 
-	// Configure HTTP client with optimized settings
 	client := &http.Client{
 		Transport: transport,
-		Timeout:   30 * time.Second,
+		Timeout:   opts.Timeout,
+	}
+
+	instanceMap := make(map[string]string, len(peerEndpoints))
+	for id, endpoint := range peerEndpoints {
+		instanceMap[id] = endpoint
 	}
 
 	return &InternalProxyAdapter{
-		client:            client,
-		instanceMap:       peerEndpoints,
-		internalAuthToken: authToken,
-		logger:            logger,
+		client:       client,
+		streamClient: &http.Client{Transport: transport},
+		instanceMap:  instanceMap,
+		signer:       signer,
+		health:       newPeerHealth(peerEndpoints, health, client, logger),
+		logger:       logger,
 	}, nil
 }
 
+// SetPeers replaces the set of peer instances, for example when instance
+// discovery reports a change. Requests already in flight are not affected.
+func (a *InternalProxyAdapter) SetPeers(peerEndpoints map[string]string) {
+	instanceMap := make(map[string]string, len(peerEndpoints))
+	for id, endpoint := range peerEndpoints {
+		instanceMap[id] = endpoint
+	}
+
+	a.instanceMu.Lock()
+	a.instanceMap = instanceMap
+	a.instanceMu.Unlock()
+	a.health.setPeers(instanceMap)
+}
+
+// endpoint returns the base URL of a peer instance
+func (a *InternalProxyAdapter) endpoint(instanceID string) (string, bool) {
+	a.instanceMu.RLock()
+	defer a.instanceMu.RUnlock()
+	endpoint, ok := a.instanceMap[instanceID]
+	return endpoint, ok
+}
+
+// PeerStatuses reports the health of every configured peer
+func (a *InternalProxyAdapter) PeerStatuses() []PeerStatus {
+	return a.health.statuses()
+}
+
+// do sends req to a peer through its circuit breaker. Transport errors and 5xx
+// responses count as failures; a request the caller canceled, or whose body
+// could not be read, counts as neither.
+func (a *InternalProxyAdapter) do(client *http.Client, instanceID string, req *http.Request) (*http.Response, error) {
+	if err := a.health.allow(instanceID); err != nil {
+		return nil, err
+	}
+
+	var body *trackedBody
+	if req.Body != nil && req.Body != http.NoBody {
+		body = &trackedBody{ReadCloser: req.Body}
+		req.Body = body
+	}
+
+	resp, err := client.Do(req)
+	switch {
+	case err != nil && (req.Context().Err() != nil || body.failed()):
+		a.health.abandon(instanceID)
+		return nil, err
+	case err != nil:
+		metrics.PeerRequestsTotal.WithLabelValues(instanceID, "failure").Inc()
+		a.health.record(instanceID, err)
+		return nil, fmt.Errorf("%w: %s: %v", ErrPeerUnavailable, instanceID, err)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		metrics.PeerRequestsTotal.WithLabelValues(instanceID, "failure").Inc()
+		a.health.record(instanceID, fmt.Errorf("status %d", resp.StatusCode))
+	default:
+		metrics.PeerRequestsTotal.WithLabelValues(instanceID, "success").Inc()
+		a.health.record(instanceID, nil)
+	}
+	return resp, nil
+}
+
+// streamContext returns the context for a request that carries a file body,
+// and a function to call once the transfer is over. The deadline of ctx bounds
+// only getting a connection to the peer; after that the transfer runs until it
+// completes, fails or ctx is canceled outright, so a large file is not cut off
+// by a timeout meant for the operation's setup.
+func streamContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	reqCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	var connected atomic.Bool
+	stop := context.AfterFunc(ctx, func() {
+		if !connected.Load() || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			cancel()
+		}
+	})
+	reqCtx = httptrace.WithClientTrace(reqCtx, &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) { connected.Store(true) },
+	})
+	return reqCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// streamBody is a response body that ends its streamContext when closed
+type streamBody struct {
+	io.ReadCloser
+	done context.CancelFunc
+}
+
+func (b *streamBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.done()
+	return err
+}
+
+// trackedBody remembers whether reading a request body failed, which is the
+// sender's fault rather than the peer's
+type trackedBody struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *trackedBody) failed() bool {
+	return b != nil && b.err != nil
+}
+
 // Open opens a file for reading by proxying to the owning instance
 // This method expects the instance ID to be provided via context
 func (a *InternalProxyAdapter) Open(ctx context.Context, path string) (io.ReadCloser, error) {
@@ -85,48 +250,210 @@ func (a *InternalProxyAdapter) Open(ctx context.Context, path string) (io.ReadCl
 
 // OpenFromInstance opens a file from a specific CallFS instance
 func (a *InternalProxyAdapter) OpenFromInstance(ctx context.Context, instanceID, path string) (io.ReadCloser, error) {
-	endpoint, exists := a.instanceMap[instanceID]
+	return a.openFromInstance(ctx, instanceID, path, -1, 0)
+}
+
+// OpenRange opens part of a file by proxying a Range request to the owning
+// instance. This method expects the instance ID to be provided via context
+func (a *InternalProxyAdapter) OpenRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	instanceID := a.getInstanceIDFromContext(ctx)
+	if instanceID == "" {
+		return nil, fmt.Errorf("internal proxy requires instance ID in context")
+	}
+	return a.openFromInstance(ctx, instanceID, path, offset, length)
+}
+
+// openFromInstance streams a file, or length bytes from offset when offset is
+// not negative, from a specific CallFS instance
+func (a *InternalProxyAdapter) openFromInstance(ctx context.Context, instanceID, path string, offset, length int64) (io.ReadCloser, error) {
+	endpoint, exists := a.endpoint(instanceID)
 	if !exists {
-		return nil, fmt.Errorf("unknown instance ID: %s", instanceID)
+		return nil, fmt.Errorf("%w: unknown instance ID %s", ErrPeerUnavailable, instanceID)
 	}
 
 	// Construct request URL
 	reqURL := buildProxyURL(endpoint, path)
 
-	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	reqCtx, done := streamContext(ctx)
+	req, err := http.NewRequestWithContext(reqCtx, "GET", reqURL, nil)
 	if err != nil {
+		done()
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	// Add internal authentication
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.internalAuthToken))
+	corelog.PropagateRequestID(ctx, req)
+	if offset >= 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	}
+
+	forwardIdentity(ctx, req)
+
+	// Authenticate as a peer
+	if err := a.signer.Sign(req); err != nil {
+		done()
+		return nil, fmt.Errorf("failed to sign request: %w", err)
+	}
 
-	a.logger.Debug("Proxying file open request",
+	corelog.WithContext(ctx, a.logger).Debug("Proxying file open request",
 		zap.String("instance_id", instanceID),
 		zap.String("path", path),
-		zap.String("url", reqURL))
+		zap.String("url", reqURL),
+		zap.String("range", req.Header.Get("Range")))
 
-	resp, err := a.client.Do(req)
+	resp, err := a.do(a.streamClient, instanceID, req)
 	if err != nil {
+		done()
 		return nil, fmt.Errorf("failed to proxy request: %w", err)
 	}
+	body := &streamBody{ReadCloser: resp.Body, done: done}
 
-	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close()
-		if resp.StatusCode == http.StatusNotFound {
-			return nil, metadata.ErrNotFound
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset >= 0:
+		return body, nil
+	case resp.StatusCode == http.StatusOK && offset >= 0:
+		// The peer ignored the Range header; skip to the requested bytes
+		if _, err := io.CopyN(io.Discard, body, offset); err != nil {
+			body.Close()
+			return nil, fmt.Errorf("failed to seek proxied file: %w", err)
 		}
-		return nil, fmt.Errorf("proxy request failed with status %d", resp.StatusCode)
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(body, length), body}, nil
+	case resp.StatusCode == http.StatusOK:
+		return body, nil
 	}
 
-	return resp.Body, nil
+	body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, metadata.ErrNotFound
+	}
+	return nil, fmt.Errorf("proxy request failed with status %d", resp.StatusCode)
 }
 
-// Create creates a new file by proxying to the target instance
+// Create creates a new file by proxying to the instance that will own it
+// This method expects the instance ID to be provided via context
 func (a *InternalProxyAdapter) Create(ctx context.Context, path string, reader io.Reader, size int64) error {
-	return fmt.Errorf("Create method not supported for internal proxy - files are created locally")
+	instanceID := a.getInstanceIDFromContext(ctx)
+	if instanceID == "" {
+		return fmt.Errorf("internal proxy requires instance ID in context")
+	}
+	return a.CreateOnInstance(ctx, instanceID, path, reader, size, nil)
 }
 
+// CreateOnInstance creates a new file on a specific CallFS instance, which
+// becomes its owner. The peer creates it for the user of ctx, with the mode,
+// owner, times and extended attributes of md, and checks the content against
+// md.Checksum when set; md may be nil. It returns metadata.ErrAlreadyExists
+// if the path exists.
+func (a *InternalProxyAdapter) CreateOnInstance(ctx context.Context, instanceID, path string, reader io.Reader, size int64, md *metadata.Metadata) error {
+	endpoint, exists := a.endpoint(instanceID)
+	if !exists {
+		return fmt.Errorf("%w: unknown instance ID %s", ErrPeerUnavailable, instanceID)
+	}
+
+	// Construct request URL
+	reqURL := buildProxyURL(endpoint, path)
+
+	reqCtx, done := streamContext(ctx)
+	defer done()
+	req, err := http.NewRequestWithContext(reqCtx, "POST", reqURL, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	corelog.PropagateRequestID(ctx, req)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if size > 0 {
+		req.ContentLength = size
+	}
+	forwardIdentity(ctx, req)
+	if md != nil {
+		setInodeHeaders(req, md)
+	}
+
+	// Authenticate as a peer
+	if err := a.signer.Sign(req); err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	corelog.WithContext(ctx, a.logger).Debug("Proxying file create request",
+		zap.String("instance_id", instanceID),
+		zap.String("path", path),
+		zap.String("url", reqURL))
+
+	resp, err := a.do(a.streamClient, instanceID, req)
+	if err != nil {
+		return fmt.Errorf("failed to proxy request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if isReadOnly(resp) {
+		return fmt.Errorf("%w on instance %s", backends.ErrReadOnly, instanceID)
+	}
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return nil
+	case http.StatusConflict:
+		return metadata.ErrAlreadyExists
+	case http.StatusInsufficientStorage:
+		return fmt.Errorf("%w on instance %s", backends.ErrInsufficientStorage, instanceID)
+	case http.StatusServiceUnavailable: // Draining, or its own backend is unavailable
+		return fmt.Errorf("%w: instance %s answered 503", ErrPeerUnavailable, instanceID)
+	case http.StatusUnprocessableEntity:
+		return fmt.Errorf("%w on instance %s", scan.ErrContentInfected, instanceID)
+	}
+	var errResp struct {
+		Message string `json:"message"`
+	}
+	if json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&errResp) == nil && errResp.Message != "" {
+		return fmt.Errorf("proxy request failed with status %d: %s", resp.StatusCode, errResp.Message)
+	}
+	return fmt.Errorf("proxy request failed with status %d", resp.StatusCode)
+}
+
+// forwardIdentity names the user of ctx in req, and the identity their
+// credentials carried, so the peer acts as the client this instance
+// authenticated rather than as the internal proxy
+func forwardIdentity(ctx context.Context, req *http.Request) {
+	if userID, ok := auth.UserIDFromContext(ctx); ok && userID != "" && userID != auth.InternalProxyUserID {
+		req.Header.Set(auth.OnBehalfOfHeader, userID)
+		if id, ok := auth.ClaimedIdentity(ctx, userID); ok {
+			req.Header.Set(auth.OnBehalfOfIdentityHeader, auth.FormatIdentity(id))
+		}
+	}
+}
+
+// setInodeHeaders sets the headers of the attributes of md a peer stores with
+// a file, the same headers a client uploads them with
+func setInodeHeaders(req *http.Request, md *metadata.Metadata) {
+	if md.Mode != "" {
+		req.Header.Set("X-CallFS-Mode", md.Mode)
+	}
+	req.Header.Set("X-CallFS-UID", strconv.Itoa(md.UID))
+	req.Header.Set("X-CallFS-GID", strconv.Itoa(md.GID))
+	if !md.ATime.IsZero() {
+		req.Header.Set("X-CallFS-ATime", md.ATime.UTC().Format(time.RFC3339Nano))
+	}
+	if !md.MTime.IsZero() {
+		req.Header.Set("X-CallFS-MTime", md.MTime.UTC().Format(time.RFC3339Nano))
+	}
+	if len(md.XAttrs) > 0 {
+		values := url.Values{}
+		for name, value := range md.XAttrs {
+			values.Set(name, value)
+		}
+		req.Header.Set("X-CallFS-XAttrs", values.Encode())
+	}
+	if md.Checksum != "" {
+		req.Header.Set("X-CallFS-Checksum", md.Checksum)
+	}
+}
+
+// IfVersionHeader carries the ContentVersion an update proxied to the owner
+// of a file is made against. The owner answers 412 when the file changed.
+const IfVersionHeader = "X-CallFS-If-Version"
+
 // Update updates a file by proxying to the owning instance
 func (a *InternalProxyAdapter) Update(ctx context.Context, path string, reader io.Reader, size int64) error {
 	instanceID := a.getInstanceIDFromContext(ctx)
@@ -136,34 +463,56 @@ func (a *InternalProxyAdapter) Update(ctx context.Context, path string, reader i
 	return a.UpdateOnInstance(ctx, instanceID, path, reader, size)
 }
 
-// UpdateOnInstance updates a file on a specific CallFS instance
+// UpdateOnInstance updates a file on a specific CallFS instance, for the
+// user of ctx
 func (a *InternalProxyAdapter) UpdateOnInstance(ctx context.Context, instanceID, path string, reader io.Reader, size int64) error {
-	endpoint, exists := a.instanceMap[instanceID]
+	return a.updateOnInstance(ctx, instanceID, path, reader, size, "")
+}
+
+// UpdateOnInstanceIfVersion updates a file on a specific CallFS instance
+// unless its content is no longer the version given, which fails with
+// metadata.ErrModified. The instance checks it under the lock of the path.
+func (a *InternalProxyAdapter) UpdateOnInstanceIfVersion(ctx context.Context, instanceID, path string, reader io.Reader, size int64, version string) error {
+	return a.updateOnInstance(ctx, instanceID, path, reader, size, version)
+}
+
+func (a *InternalProxyAdapter) updateOnInstance(ctx context.Context, instanceID, path string, reader io.Reader, size int64, version string) error {
+	endpoint, exists := a.endpoint(instanceID)
 	if !exists {
-		return fmt.Errorf("unknown instance ID: %s", instanceID)
+		return fmt.Errorf("%w: unknown instance ID %s", ErrPeerUnavailable, instanceID)
 	}
 
 	// Construct request URL
 	reqURL := buildProxyURL(endpoint, path)
 
-	req, err := http.NewRequestWithContext(ctx, "PUT", reqURL, reader)
+	reqCtx, done := streamContext(ctx)
+	defer done()
+	req, err := http.NewRequestWithContext(reqCtx, "PUT", reqURL, reader)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 
-	// Add internal authentication
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.internalAuthToken))
+	corelog.PropagateRequestID(ctx, req)
 	req.Header.Set("Content-Type", "application/octet-stream")
 	if size > 0 {
 		req.ContentLength = size
 	}
+	if version != "" {
+		req.Header.Set(IfVersionHeader, version)
+	}
+	forwardIdentity(ctx, req)
+
+	// Authenticate as a peer
+	if err := a.signer.Sign(req); err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
 
-	a.logger.Debug("Proxying file update request",
+	corelog.WithContext(ctx, a.logger).Debug("Proxying file update request",
 		zap.String("instance_id", instanceID),
 		zap.String("path", path),
 		zap.String("url", reqURL))
 
-	resp, err := a.client.Do(req)
+	resp, err := a.do(a.streamClient, instanceID, req)
 	if err != nil {
 		return fmt.Errorf("failed to proxy request: %w", err)
 	}
@@ -173,6 +522,21 @@ func (a *InternalProxyAdapter) UpdateOnInstance(ctx context.Context, instanceID,
 		if resp.StatusCode == http.StatusNotFound {
 			return metadata.ErrNotFound
 		}
+		if resp.StatusCode == http.StatusPreconditionFailed {
+			return fmt.Errorf("%w on instance %s", metadata.ErrModified, instanceID)
+		}
+		if resp.StatusCode == http.StatusInsufficientStorage {
+			return fmt.Errorf("%w on instance %s", backends.ErrInsufficientStorage, instanceID)
+		}
+		if resp.StatusCode == http.StatusUnprocessableEntity {
+			return fmt.Errorf("%w on instance %s", scan.ErrContentInfected, instanceID)
+		}
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			return fmt.Errorf("%w: instance %s answered 503", ErrPeerUnavailable, instanceID)
+		}
+		if isReadOnly(resp) {
+			return fmt.Errorf("%w on instance %s", backends.ErrReadOnly, instanceID)
+		}
 		return fmt.Errorf("proxy request failed with status %d", resp.StatusCode)
 	}
 
@@ -190,9 +554,21 @@ func (a *InternalProxyAdapter) Delete(ctx context.Context, path string) error {
 
 // DeleteOnInstance deletes a file on a specific CallFS instance
 func (a *InternalProxyAdapter) DeleteOnInstance(ctx context.Context, instanceID, path string) error {
-	endpoint, exists := a.instanceMap[instanceID]
+	return a.deleteOnInstance(ctx, instanceID, path, time.Time{})
+}
+
+// DeleteOnInstanceIfUnmodified deletes a file on a specific CallFS instance
+// unless it was modified after since, which fails with metadata.ErrModified.
+// If-Unmodified-Since holds whole seconds, so it is sent as the start of the
+// last whole second ending by since.
+func (a *InternalProxyAdapter) DeleteOnInstanceIfUnmodified(ctx context.Context, instanceID, path string, since time.Time) error {
+	return a.deleteOnInstance(ctx, instanceID, path, since.Add(time.Nanosecond-time.Second).Truncate(time.Second))
+}
+
+func (a *InternalProxyAdapter) deleteOnInstance(ctx context.Context, instanceID, path string, unmodifiedSince time.Time) error {
+	endpoint, exists := a.endpoint(instanceID)
 	if !exists {
-		return fmt.Errorf("unknown instance ID: %s", instanceID)
+		return fmt.Errorf("%w: unknown instance ID %s", ErrPeerUnavailable, instanceID)
 	}
 
 	// Construct request URL
@@ -202,16 +578,24 @@ func (a *InternalProxyAdapter) DeleteOnInstance(ctx context.Context, instanceID,
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
+	if !unmodifiedSince.IsZero() {
+		req.Header.Set("If-Unmodified-Since", unmodifiedSince.UTC().Format(http.TimeFormat))
+	}
 
-	// Add internal authentication
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.internalAuthToken))
+	forwardIdentity(ctx, req)
+
+	// Authenticate as a peer
+	if err := a.signer.Sign(req); err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+	corelog.PropagateRequestID(ctx, req)
 
-	a.logger.Debug("Proxying file delete request",
+	corelog.WithContext(ctx, a.logger).Debug("Proxying file delete request",
 		zap.String("instance_id", instanceID),
 		zap.String("path", path),
 		zap.String("url", reqURL))
 
-	resp, err := a.client.Do(req)
+	resp, err := a.do(a.client, instanceID, req)
 	if err != nil {
 		return fmt.Errorf("failed to proxy request: %w", err)
 	}
@@ -221,6 +605,15 @@ func (a *InternalProxyAdapter) DeleteOnInstance(ctx context.Context, instanceID,
 		if resp.StatusCode == http.StatusNotFound {
 			return metadata.ErrNotFound
 		}
+		if resp.StatusCode == http.StatusPreconditionFailed {
+			return fmt.Errorf("%w on instance %s", metadata.ErrModified, instanceID)
+		}
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			return fmt.Errorf("%w: instance %s answered 503", ErrPeerUnavailable, instanceID)
+		}
+		if isReadOnly(resp) {
+			return fmt.Errorf("%w on instance %s", backends.ErrReadOnly, instanceID)
+		}
 		return fmt.Errorf("proxy request failed with status %d", resp.StatusCode)
 	}
 
@@ -238,9 +631,9 @@ func (a *InternalProxyAdapter) Stat(ctx context.Context, path string) (*metadata
 
 // StatOnInstance gets file metadata from a specific CallFS instance
 func (a *InternalProxyAdapter) StatOnInstance(ctx context.Context, instanceID, path string) (*metadata.Metadata, error) {
-	endpoint, exists := a.instanceMap[instanceID]
+	endpoint, exists := a.endpoint(instanceID)
 	if !exists {
-		return nil, fmt.Errorf("unknown instance ID: %s", instanceID)
+		return nil, fmt.Errorf("%w: unknown instance ID %s", ErrPeerUnavailable, instanceID)
 	}
 
 	// Construct request URL
@@ -251,10 +644,15 @@ func (a *InternalProxyAdapter) StatOnInstance(ctx context.Context, instanceID, p
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	// Add internal authentication
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.internalAuthToken))
+	forwardIdentity(ctx, req)
+
+	// Authenticate as a peer
+	if err := a.signer.Sign(req); err != nil {
+		return nil, fmt.Errorf("failed to sign request: %w", err)
+	}
+	corelog.PropagateRequestID(ctx, req)
 
-	resp, err := a.client.Do(req)
+	resp, err := a.do(a.client, instanceID, req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to proxy request: %w", err)
 	}
@@ -292,22 +690,91 @@ func (a *InternalProxyAdapter) StatOnInstance(ctx context.Context, instanceID, p
 	if cleanPath == "/" {
 		name = "/"
 	}
+	nlink, _ := strconv.Atoi(resp.Header.Get("X-CallFS-NLink"))
+	var symlinkTarget *string
+	if header := resp.Header.Get("X-CallFS-Symlink-Target"); header != "" {
+		if target, err := url.PathUnescape(header); err == nil {
+			symlinkTarget = &target
+		}
+	}
+	var xattrs map[string]string
+	if values, err := url.ParseQuery(resp.Header.Get("X-CallFS-XAttrs")); err == nil && len(values) > 0 {
+		xattrs = make(map[string]string, len(values))
+		for name := range values {
+			xattrs[name] = values.Get(name)
+		}
+	}
 
 	return &metadata.Metadata{
-		Name:        name,
-		Path:        cleanPath,
-		Type:        typeHeader,
-		Size:        size,
-		Mode:        mode,
-		UID:         uid,
-		GID:         gid,
-		MTime:       mTime,
-		ATime:       mTime,
-		CTime:       mTime,
-		BackendType: "localfs",
+		Name:          name,
+		Path:          cleanPath,
+		Type:          typeHeader,
+		Size:          size,
+		Mode:          mode,
+		UID:           uid,
+		GID:           gid,
+		MTime:         mTime,
+		ATime:         mTime,
+		CTime:         mTime,
+		BackendType:   "localfs",
+		SymlinkTarget: symlinkTarget,
+		Checksum:      resp.Header.Get("X-CallFS-Checksum"),
+		NLink:         nlink,
+		XAttrs:        xattrs,
 	}, nil
 }
 
+// StatJSONOnInstance gets the full metadata of an entry from a specific
+// CallFS instance through its JSON stat endpoint, following a symlink when
+// followSymlinks is set
+func (a *InternalProxyAdapter) StatJSONOnInstance(ctx context.Context, instanceID, path string, followSymlinks bool) (*metadata.Metadata, error) {
+	endpoint, exists := a.endpoint(instanceID)
+	if !exists {
+		return nil, fmt.Errorf("%w: unknown instance ID %s", ErrPeerUnavailable, instanceID)
+	}
+
+	reqURL, err := url.JoinPath(strings.TrimRight(endpoint, "/"), "v1", "stat", strings.TrimLeft(path, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request URL: %w", err)
+	}
+	reqURL += "?follow_symlinks=" + strconv.FormatBool(followSymlinks)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	forwardIdentity(ctx, req)
+
+	// Authenticate as a peer
+	if err := a.signer.Sign(req); err != nil {
+		return nil, fmt.Errorf("failed to sign request: %w", err)
+	}
+	corelog.PropagateRequestID(ctx, req)
+
+	resp, err := a.do(a.client, instanceID, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to proxy request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, metadata.ErrNotFound
+	case http.StatusForbidden:
+		return nil, metadata.ErrForbidden
+	default:
+		return nil, fmt.Errorf("proxy request failed with status %d", resp.StatusCode)
+	}
+
+	var md metadata.Metadata
+	if err := json.NewDecoder(resp.Body).Decode(&md); err != nil {
+		return nil, fmt.Errorf("failed to decode stat response: %w", err)
+	}
+	return &md, nil
+}
+
 // ListDirectory lists directory contents by proxying to the owning instance
 func (a *InternalProxyAdapter) ListDirectory(ctx context.Context, path string) ([]*metadata.Metadata, error) {
 	instanceID := a.getInstanceIDFromContext(ctx)
@@ -319,9 +786,9 @@ func (a *InternalProxyAdapter) ListDirectory(ctx context.Context, path string) (
 
 // ListDirectoryOnInstance lists directory contents from a specific CallFS instance
 func (a *InternalProxyAdapter) ListDirectoryOnInstance(ctx context.Context, instanceID, path string) ([]*metadata.Metadata, error) {
-	endpoint, exists := a.instanceMap[instanceID]
+	endpoint, exists := a.endpoint(instanceID)
 	if !exists {
-		return nil, fmt.Errorf("unknown instance ID: %s", instanceID)
+		return nil, fmt.Errorf("%w: unknown instance ID %s", ErrPeerUnavailable, instanceID)
 	}
 
 	// Construct request URL
@@ -332,15 +799,20 @@ func (a *InternalProxyAdapter) ListDirectoryOnInstance(ctx context.Context, inst
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	// Add internal authentication
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.internalAuthToken))
+	forwardIdentity(ctx, req)
+
+	// Authenticate as a peer
+	if err := a.signer.Sign(req); err != nil {
+		return nil, fmt.Errorf("failed to sign request: %w", err)
+	}
+	corelog.PropagateRequestID(ctx, req)
 
-	a.logger.Debug("Proxying directory list request",
+	corelog.WithContext(ctx, a.logger).Debug("Proxying directory list request",
 		zap.String("instance_id", instanceID),
 		zap.String("path", path),
 		zap.String("url", reqURL))
 
-	resp, err := a.client.Do(req)
+	resp, err := a.do(a.client, instanceID, req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to proxy request: %w", err)
 	}
@@ -408,7 +880,8 @@ func (a *InternalProxyAdapter) CreateDirectory(ctx context.Context, path string)
 
 // Close closes the HTTP client resources
 func (a *InternalProxyAdapter) Close() error {
-	a.client.CloseIdleConnections()
+	a.health.close()
+	a.client.CloseIdleConnections() // Shared with streamClient
 	return nil
 }
 
@@ -430,6 +903,18 @@ func WithInstanceID(ctx context.Context, instanceID string) context.Context {
 	return context.WithValue(ctx, instanceIDKey, instanceID)
 }
 
+// isReadOnly reports whether a peer refused a change because it, or the path,
+// is in read-only mode
+func isReadOnly(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusForbidden {
+		return false
+	}
+	var errResp struct {
+		Code string `json:"code"`
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&errResp) == nil && errResp.Code == "READ_ONLY"
+}
+
 // buildProxyURL constructs a properly encoded URL for proxying to a peer instance.
 // Uses url.JoinPath for correct per-segment encoding of multi-segment paths.
 func buildProxyURL(endpoint, path string) string {
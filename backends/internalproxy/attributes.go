@@ -0,0 +1,68 @@
+package internalproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ebogdum/callfs/backends"
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// AttributesPath is the internal endpoint applying file modes and times on
+// an instance's local filesystem backend
+const AttributesPath = "/v1/internal/attributes"
+
+// AttributesRequest asks an instance to change the mode, times or extended
+// attributes of a path on its local filesystem. Fields left empty are
+// unchanged.
+type AttributesRequest struct {
+	Path   string            `json:"path"`
+	Mode   *os.FileMode      `json:"mode,omitempty"`
+	ATime  time.Time         `json:"atime,omitzero"`
+	MTime  time.Time         `json:"mtime,omitzero"`
+	XAttrs map[string]string `json:"xattrs,omitempty"`
+}
+
+// SetAttributesOnInstance asks a peer to change the mode, times or extended
+// attributes of a path on its local filesystem backend
+func (a *InternalProxyAdapter) SetAttributesOnInstance(ctx context.Context, instanceID string, attrs AttributesRequest) error {
+	endpoint, exists := a.internalEndpoint(instanceID)
+	if !exists {
+		return fmt.Errorf("%w: unknown instance ID %s", ErrPeerUnavailable, instanceID)
+	}
+
+	body, err := json.Marshal(attrs)
+	if err != nil {
+		return fmt.Errorf("failed to encode attributes request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(endpoint, "/")+AttributesPath, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := a.signer.Sign(req); err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	resp, err := a.do(a.client, instanceID, req)
+	if err != nil {
+		return fmt.Errorf("failed to request attribute change: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return metadata.ErrNotFound
+	}
+	if resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("%w on instance %s", backends.ErrReadOnly, instanceID)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("attribute change request failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
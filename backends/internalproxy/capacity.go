@@ -0,0 +1,77 @@
+package internalproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// CapacityPath is the internal endpoint reporting an instance's local storage space
+const CapacityPath = "/v1/internal/capacity"
+
+// Capacity is the space of an instance's local filesystem backend
+type Capacity struct {
+	InstanceID string `json:"instance_id"`
+	FreeBytes  int64  `json:"free_bytes"`
+	TotalBytes int64  `json:"total_bytes"`
+}
+
+// SetInternalEndpoints records the dedicated internal listeners of peers that
+// serve /v1/internal/* apart from their public API
+func (a *InternalProxyAdapter) SetInternalEndpoints(endpoints map[string]string) {
+	internalMap := make(map[string]string, len(endpoints))
+	for id, endpoint := range endpoints {
+		internalMap[id] = endpoint
+	}
+	a.instanceMu.Lock()
+	a.internalMap = internalMap
+	a.instanceMu.Unlock()
+}
+
+// internalEndpoint returns the base URL serving /v1/internal/* on a peer: its
+// internal listener when one is known, its API endpoint otherwise
+func (a *InternalProxyAdapter) internalEndpoint(instanceID string) (string, bool) {
+	a.instanceMu.RLock()
+	defer a.instanceMu.RUnlock()
+	endpoint, ok := a.instanceMap[instanceID]
+	if !ok {
+		return "", false
+	}
+	if internal, ok := a.internalMap[instanceID]; ok {
+		endpoint = internal
+	}
+	return endpoint, true
+}
+
+// CapacityOnInstance asks a peer how much local storage space it has
+func (a *InternalProxyAdapter) CapacityOnInstance(ctx context.Context, instanceID string) (*Capacity, error) {
+	endpoint, exists := a.internalEndpoint(instanceID)
+	if !exists {
+		return nil, fmt.Errorf("%w: unknown instance ID %s", ErrPeerUnavailable, instanceID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(endpoint, "/")+CapacityPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if err := a.signer.Sign(req); err != nil {
+		return nil, fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	resp, err := a.do(a.client, instanceID, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request capacity: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("capacity request failed with status %d", resp.StatusCode)
+	}
+
+	var c Capacity
+	if err := json.NewDecoder(resp.Body).Decode(&c); err != nil {
+		return nil, fmt.Errorf("failed to decode capacity: %w", err)
+	}
+	return &c, nil
+}
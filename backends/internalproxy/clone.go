@@ -0,0 +1,51 @@
+package internalproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ClonePath is the internal endpoint copying files within an instance's
+// local filesystem backend
+const ClonePath = "/v1/internal/clone"
+
+// CloneRequest asks an instance to copy files on its local filesystem
+type CloneRequest struct {
+	Files []RenameRequest `json:"files"` // From each path to its copy
+}
+
+// CloneOnInstance asks a peer to copy files on its local filesystem backend,
+// sharing their content where it can
+func (a *InternalProxyAdapter) CloneOnInstance(ctx context.Context, instanceID string, files []RenameRequest) error {
+	endpoint, exists := a.internalEndpoint(instanceID)
+	if !exists {
+		return fmt.Errorf("%w: unknown instance ID %s", ErrPeerUnavailable, instanceID)
+	}
+
+	body, err := json.Marshal(CloneRequest{Files: files})
+	if err != nil {
+		return fmt.Errorf("failed to encode clone request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(endpoint, "/")+ClonePath, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := a.signer.Sign(req); err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	resp, err := a.do(a.client, instanceID, req)
+	if err != nil {
+		return fmt.Errorf("failed to request clone: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("clone request failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
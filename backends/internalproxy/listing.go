@@ -0,0 +1,62 @@
+package internalproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ListingPath is the internal endpoint listing a directory of an instance's
+// local filesystem backend
+const ListingPath = "/v1/internal/listing"
+
+// Listing is what an instance's local filesystem backend holds in a directory
+type Listing struct {
+	InstanceID string         `json:"instance_id"`
+	Path       string         `json:"path"`
+	Entries    []ListingEntry `json:"entries"`
+}
+
+// ListingEntry is one child in a Listing
+type ListingEntry struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // "file" or "directory"
+	Size int64  `json:"size"`
+}
+
+// LocalListingOnInstance asks a peer what its local filesystem backend holds
+// in a directory, regardless of the metadata store. A directory the peer does
+// not have is returned as an empty listing.
+func (a *InternalProxyAdapter) LocalListingOnInstance(ctx context.Context, instanceID, path string) (*Listing, error) {
+	endpoint, exists := a.internalEndpoint(instanceID)
+	if !exists {
+		return nil, fmt.Errorf("%w: unknown instance ID %s", ErrPeerUnavailable, instanceID)
+	}
+
+	reqURL := strings.TrimRight(endpoint, "/") + ListingPath + "?" + url.Values{"path": {path}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if err := a.signer.Sign(req); err != nil {
+		return nil, fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	resp, err := a.do(a.client, instanceID, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request listing: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("listing request failed with status %d", resp.StatusCode)
+	}
+
+	var l Listing
+	if err := json.NewDecoder(resp.Body).Decode(&l); err != nil {
+		return nil, fmt.Errorf("failed to decode listing: %w", err)
+	}
+	return &l, nil
+}
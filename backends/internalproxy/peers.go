@@ -0,0 +1,294 @@
+package internalproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/metrics"
+)
+
+// ErrPeerUnavailable is returned when a peer cannot be reached or its circuit
+// is open. Callers may fall back to a replica of the data.
+var ErrPeerUnavailable = errors.New("peer instance unavailable")
+
+// DrainingHeader is set on the /healthz responses of an instance in drain
+// mode. Peers treat it as unavailable, so new requests go elsewhere while
+// its requests in progress finish.
+const DrainingHeader = "X-CallFS-Draining"
+
+// Circuit states
+const (
+	CircuitClosed   = "closed"    // requests flow normally
+	CircuitOpen     = "open"      // requests are rejected until the open period ends
+	CircuitHalfOpen = "half_open" // one trial request decides whether to close again
+)
+
+// HealthOptions configures peer health probing and circuit breaking
+type HealthOptions struct {
+	CheckInterval    time.Duration // How often each peer's /healthz is probed (0 disables probes)
+	FailureThreshold int           // Consecutive failures that open a peer's circuit
+	OpenDuration     time.Duration // How long an open circuit rejects requests before a trial
+}
+
+// PeerStatus is the health of one peer as seen by this instance
+type PeerStatus struct {
+	InstanceID          string     `json:"instance_id"`
+	Endpoint            string     `json:"endpoint"`
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastFailure         *time.Time `json:"last_failure,omitempty"`
+	OpenUntil           *time.Time `json:"open_until,omitempty"`
+}
+
+// peerState is the circuit breaker for one peer
+type peerState struct {
+	endpoint    string
+	state       string
+	failures    int
+	trial       bool // a half-open trial request is in flight
+	lastError   string
+	lastSuccess time.Time
+	lastFailure time.Time
+	openUntil   time.Time
+}
+
+// peerHealth tracks peer availability from proxied requests and probes
+type peerHealth struct {
+	mu     sync.Mutex
+	peers  map[string]*peerState
+	opts   HealthOptions
+	client *http.Client
+	logger *zap.Logger
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newPeerHealth(endpoints map[string]string, opts HealthOptions, client *http.Client, logger *zap.Logger) *peerHealth {
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = 3
+	}
+	if opts.OpenDuration <= 0 {
+		opts.OpenDuration = 30 * time.Second
+	}
+
+	h := &peerHealth{
+		peers:  make(map[string]*peerState, len(endpoints)),
+		opts:   opts,
+		client: client,
+		logger: logger,
+		stop:   make(chan struct{}),
+	}
+	for id, endpoint := range endpoints {
+		h.peers[id] = &peerState{endpoint: endpoint, state: CircuitClosed}
+		metrics.PeerHealthy.WithLabelValues(id).Set(1)
+	}
+
+	if opts.CheckInterval > 0 {
+		h.wg.Add(1)
+		go h.monitor()
+	}
+	return h
+}
+
+// allow reports whether a request to the peer may proceed. An open circuit
+// rejects requests until its open period ends, then lets one trial through.
+func (h *peerHealth) allow(instanceID string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	p, ok := h.peers[instanceID]
+	if !ok {
+		return nil
+	}
+	switch p.state {
+	case CircuitOpen:
+		if time.Now().Before(p.openUntil) {
+			metrics.PeerRequestsTotal.WithLabelValues(instanceID, "rejected").Inc()
+			return fmt.Errorf("%w: %s (circuit open)", ErrPeerUnavailable, instanceID)
+		}
+		p.state = CircuitHalfOpen
+		p.trial = true
+	case CircuitHalfOpen:
+		if p.trial {
+			metrics.PeerRequestsTotal.WithLabelValues(instanceID, "rejected").Inc()
+			return fmt.Errorf("%w: %s (circuit half-open)", ErrPeerUnavailable, instanceID)
+		}
+		p.trial = true
+	}
+	return nil
+}
+
+// record updates the peer's circuit with the outcome of a request or probe
+func (h *peerHealth) record(instanceID string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	p, ok := h.peers[instanceID]
+	if !ok {
+		return
+	}
+	p.trial = false
+
+	now := time.Now()
+	if err == nil {
+		p.lastSuccess = now
+		p.failures = 0
+		if p.state != CircuitClosed {
+			h.logger.Info("Peer instance available again", zap.String("peer", instanceID))
+			p.state = CircuitClosed
+			p.openUntil = time.Time{}
+			metrics.PeerHealthy.WithLabelValues(instanceID).Set(1)
+		}
+		return
+	}
+
+	p.lastFailure = now
+	p.lastError = err.Error()
+	p.failures++
+	if p.state == CircuitHalfOpen || (p.state == CircuitClosed && p.failures >= h.opts.FailureThreshold) {
+		if p.state == CircuitClosed {
+			h.logger.Warn("Peer instance marked unavailable",
+				zap.String("peer", instanceID),
+				zap.Int("consecutive_failures", p.failures),
+				zap.Error(err))
+			metrics.PeerCircuitTripsTotal.WithLabelValues(instanceID).Inc()
+		}
+		p.state = CircuitOpen
+		p.openUntil = now.Add(h.opts.OpenDuration)
+		metrics.PeerHealthy.WithLabelValues(instanceID).Set(0)
+	}
+}
+
+// abandon clears a trial slot for a request the caller canceled, which says
+// nothing about the peer's health
+func (h *peerHealth) abandon(instanceID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if p, ok := h.peers[instanceID]; ok {
+		p.trial = false
+	}
+}
+
+// setPeers replaces the tracked peers. Peers that are still present keep
+// their circuit state; a peer whose endpoint changed starts over closed.
+func (h *peerHealth) setPeers(endpoints map[string]string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for id := range h.peers {
+		if _, ok := endpoints[id]; !ok {
+			delete(h.peers, id)
+			metrics.PeerHealthy.DeleteLabelValues(id)
+		}
+	}
+	for id, endpoint := range endpoints {
+		if p, ok := h.peers[id]; ok && p.endpoint == endpoint {
+			continue
+		}
+		h.peers[id] = &peerState{endpoint: endpoint, state: CircuitClosed}
+		metrics.PeerHealthy.WithLabelValues(id).Set(1)
+	}
+}
+
+// statuses returns a snapshot of every peer, sorted by instance ID
+func (h *peerHealth) statuses() []PeerStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	out := make([]PeerStatus, 0, len(h.peers))
+	for id, p := range h.peers {
+		s := PeerStatus{
+			InstanceID:          id,
+			Endpoint:            p.endpoint,
+			State:               p.state,
+			ConsecutiveFailures: p.failures,
+			LastError:           p.lastError,
+		}
+		if !p.lastSuccess.IsZero() {
+			t := p.lastSuccess
+			s.LastSuccess = &t
+		}
+		if !p.lastFailure.IsZero() {
+			t := p.lastFailure
+			s.LastFailure = &t
+		}
+		if p.state == CircuitOpen {
+			t := p.openUntil
+			s.OpenUntil = &t
+		}
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].InstanceID < out[j].InstanceID })
+	return out
+}
+
+func (h *peerHealth) monitor() {
+	defer h.wg.Done()
+	ticker := time.NewTicker(h.opts.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			h.probeAll()
+		case <-h.stop:
+			return
+		}
+	}
+}
+
+// probeAll checks every peer's liveness endpoint. A successful probe closes
+// an open circuit without waiting for live traffic to risk a trial request.
+func (h *peerHealth) probeAll() {
+	h.mu.Lock()
+	endpoints := make(map[string]string, len(h.peers))
+	for id, p := range h.peers {
+		endpoints[id] = p.endpoint
+	}
+	h.mu.Unlock()
+
+	for id, endpoint := range endpoints {
+		h.record(id, h.probe(endpoint))
+	}
+}
+
+func (h *peerHealth) probe(endpoint string) error {
+	timeout := min(h.opts.CheckInterval, 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(endpoint, "/")+"/healthz", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check returned status %d", resp.StatusCode)
+	}
+	if resp.Header.Get(DrainingHeader) != "" {
+		return fmt.Errorf("peer is draining")
+	}
+	return nil
+}
+
+func (h *peerHealth) close() {
+	select {
+	case <-h.stop:
+	default:
+		close(h.stop)
+	}
+	h.wg.Wait()
+}
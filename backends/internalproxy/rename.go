@@ -0,0 +1,57 @@
+package internalproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ebogdum/callfs/backends"
+)
+
+// RenamePath is the internal endpoint moving a path within an instance's
+// local filesystem backend
+const RenamePath = "/v1/internal/rename"
+
+// RenameRequest asks an instance to move From to To on its local filesystem
+type RenameRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// RenameOnInstance asks a peer to move a file or directory on its local
+// filesystem backend. A path the peer does not hold is not an error.
+func (a *InternalProxyAdapter) RenameOnInstance(ctx context.Context, instanceID, from, to string) error {
+	endpoint, exists := a.internalEndpoint(instanceID)
+	if !exists {
+		return fmt.Errorf("%w: unknown instance ID %s", ErrPeerUnavailable, instanceID)
+	}
+
+	body, err := json.Marshal(RenameRequest{From: from, To: to})
+	if err != nil {
+		return fmt.Errorf("failed to encode rename request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(endpoint, "/")+RenamePath, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := a.signer.Sign(req); err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	resp, err := a.do(a.client, instanceID, req)
+	if err != nil {
+		return fmt.Errorf("failed to request rename: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("%w on instance %s", backends.ErrReadOnly, instanceID)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("rename request failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -2,11 +2,16 @@ package localfs
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"syscall"
+	"time"
 
+	"github.com/ebogdum/callfs/backends"
+	"github.com/ebogdum/callfs/config"
 	"github.com/ebogdum/callfs/internal/pathutil"
 	"github.com/ebogdum/callfs/metadata"
 )
@@ -14,10 +19,22 @@ import (
 // LocalFSAdapter implements the backends.Storage interface for local filesystem
 type LocalFSAdapter struct {
 	rootPath string
+	sync     bool     // fsync written files and their directory before returning
+	xattrs   []string // Extended attributes Stat reports
+
+	// Writes that would leave less are rejected; 0 disables a check
+	minFreeBytes  int64
+	minFreeInodes int64
 }
 
-// NewLocalFSAdapter creates a new local filesystem adapter
-func NewLocalFSAdapter(rootPath string) (*LocalFSAdapter, error) {
+// NewLocalFSAdapter creates a new local filesystem adapter rooted at
+// cfg.LocalFSRootPath. With cfg.LocalFSSync, a write returns only once the
+// file's content and its directory entry are on stable storage. Writes that
+// would leave less than cfg.LocalFSMinFreeBytes or cfg.LocalFSMinFreeInodes
+// free are rejected with backends.ErrInsufficientStorage.
+func NewLocalFSAdapter(cfg config.BackendConfig) (*LocalFSAdapter, error) {
+	rootPath := cfg.LocalFSRootPath
+
 	// Ensure root path exists
 	if err := os.MkdirAll(rootPath, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create root path %s: %w", rootPath, err)
@@ -30,11 +47,37 @@ func NewLocalFSAdapter(rootPath string) (*LocalFSAdapter, error) {
 
 	return &LocalFSAdapter{
 		rootPath: rootPath,
+		sync:     cfg.LocalFSSync,
+		xattrs:   cfg.LocalFSXAttrs,
+
+		minFreeBytes:  cfg.LocalFSMinFreeBytes,
+		minFreeInodes: cfg.LocalFSMinFreeInodes,
 	}, nil
 }
 
+// Ping verifies the root path is still an accessible directory
+func (a *LocalFSAdapter) Ping(ctx context.Context) error {
+	info, err := os.Stat(a.rootPath)
+	if err != nil {
+		return fmt.Errorf("root path %s is not accessible: %w", a.rootPath, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("root path %s is not a directory", a.rootPath)
+	}
+	return nil
+}
+
 // Open opens a file for reading
 func (a *LocalFSAdapter) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	file, err := a.OpenFile(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// OpenFile opens a file for reading as an *os.File
+func (a *LocalFSAdapter) OpenFile(ctx context.Context, path string) (*os.File, error) {
 	fullPath, err := pathutil.SafeJoin(a.rootPath, path)
 	if err != nil {
 		return nil, metadata.ErrForbidden
@@ -51,20 +94,56 @@ func (a *LocalFSAdapter) Open(ctx context.Context, path string) (io.ReadCloser,
 	return file, nil
 }
 
+// OpenRange opens length bytes of a file starting at offset
+func (a *LocalFSAdapter) OpenRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	reader, err := a.Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	file := reader.(io.ReadSeekCloser)
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to seek file %s: %w", path, err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, length), file}, nil
+}
+
 // Create creates a new file with content from the reader
 func (a *LocalFSAdapter) Create(ctx context.Context, path string, reader io.Reader, size int64) error {
+	return a.write(path, reader, size, true)
+}
+
+// Update replaces the content of a file with content from the reader.
+// Readers see either the old or the new content, never a partial write.
+func (a *LocalFSAdapter) Update(ctx context.Context, path string, reader io.Reader, size int64) error {
+	return a.write(path, reader, size, false)
+}
+
+// write stores content in a temp file in the target's directory and renames
+// it over the target, so the target is replaced atomically. With exclusive,
+// an existing target is left alone and ErrAlreadyExists returned. Writes of
+// size bytes, or of unknown size when it is negative, that the filesystem
+// has no room for are rejected before anything is written.
+func (a *LocalFSAdapter) write(path string, reader io.Reader, size int64, exclusive bool) error {
 	fullPath, err := pathutil.SafeJoin(a.rootPath, path)
 	if err != nil {
 		return metadata.ErrForbidden
 	}
+	dir := filepath.Dir(fullPath)
+
+	if err := a.checkSpace(size); err != nil {
+		return err
+	}
 
 	// Ensure parent directory exists
-	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+	if err := os.MkdirAll(dir, 0755); err != nil {
 		return fmt.Errorf("failed to create parent directory: %w", err)
 	}
 
-	// Write to a temp file, fsync, then rename for atomicity
-	tmpFile, err := os.CreateTemp(filepath.Dir(fullPath), ".callfs-tmp-*")
+	tmpFile, err := os.CreateTemp(dir, ".callfs-tmp-*")
 	if err != nil {
 		return fmt.Errorf("failed to create temp file: %w", err)
 	}
@@ -74,15 +153,23 @@ func (a *LocalFSAdapter) Create(ctx context.Context, path string, reader io.Read
 	if copyErr != nil {
 		tmpFile.Close()
 		os.Remove(tmpPath)
+		if errors.Is(copyErr, syscall.ENOSPC) {
+			return fmt.Errorf("%w: %w", backends.ErrInsufficientStorage, copyErr)
+		}
 		return fmt.Errorf("failed to write file content: %w", copyErr)
 	}
 
-	if err := tmpFile.Sync(); err != nil {
-		tmpFile.Close()
+	if a.sync {
+		if err := tmpFile.Sync(); err != nil {
+			tmpFile.Close()
+			os.Remove(tmpPath)
+			return fmt.Errorf("failed to fsync file: %w", err)
+		}
+	}
+	if err := tmpFile.Close(); err != nil {
 		os.Remove(tmpPath)
-		return fmt.Errorf("failed to fsync file: %w", err)
+		return fmt.Errorf("failed to close temp file: %w", err)
 	}
-	tmpFile.Close()
 
 	if err := os.Chmod(tmpPath, 0644); err != nil {
 		os.Remove(tmpPath)
@@ -90,9 +177,11 @@ func (a *LocalFSAdapter) Create(ctx context.Context, path string, reader io.Read
 	}
 
 	// Check if destination already exists (O_EXCL equivalent)
-	if _, statErr := os.Lstat(fullPath); statErr == nil {
-		os.Remove(tmpPath)
-		return metadata.ErrAlreadyExists
+	if exclusive {
+		if _, statErr := os.Lstat(fullPath); statErr == nil {
+			os.Remove(tmpPath)
+			return metadata.ErrAlreadyExists
+		}
 	}
 
 	if err := os.Rename(tmpPath, fullPath); err != nil {
@@ -100,58 +189,20 @@ func (a *LocalFSAdapter) Create(ctx context.Context, path string, reader io.Read
 		return fmt.Errorf("failed to rename temp file: %w", err)
 	}
 
-	return nil
-}
-
-// Update updates an existing file with new content from the reader
-func (a *LocalFSAdapter) Update(ctx context.Context, path string, reader io.Reader, size int64) error {
-	fullPath, err := pathutil.SafeJoin(a.rootPath, path)
-	if err != nil {
-		return metadata.ErrForbidden
-	}
-
-	// Ensure parent directory exists
-	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
-		return fmt.Errorf("failed to create parent directory: %w", err)
-	}
-
-	// Write to temp file, fsync, then atomic rename to avoid data loss
-	tmpFile, err := os.CreateTemp(filepath.Dir(fullPath), ".callfs-tmp-*")
-	if err != nil {
-		return fmt.Errorf("failed to create temp file for update: %w", err)
-	}
-	tmpPath := tmpFile.Name()
-
-	_, copyErr := io.Copy(tmpFile, reader)
-	if copyErr != nil {
-		tmpFile.Close()
-		os.Remove(tmpPath)
-		return fmt.Errorf("failed to write file content: %w", copyErr)
-	}
-
-	if err := tmpFile.Sync(); err != nil {
-		tmpFile.Close()
-		os.Remove(tmpPath)
-		return fmt.Errorf("failed to fsync file: %w", err)
-	}
-	tmpFile.Close()
-
-	if err := os.Chmod(tmpPath, 0644); err != nil {
-		os.Remove(tmpPath)
-		return fmt.Errorf("failed to set file permissions: %w", err)
-	}
-
-	if err := os.Rename(tmpPath, fullPath); err != nil {
-		os.Remove(tmpPath)
-		return fmt.Errorf("failed to rename temp file: %w", err)
+	// The rename is durable only once the directory is
+	if a.sync {
+		if err := syncDir(dir); err != nil {
+			return fmt.Errorf("failed to fsync directory: %w", err)
+		}
 	}
 
 	return nil
 }
 
-// Delete removes a file or empty directory
+// Delete removes a file, symlink or empty directory. A symlink is removed
+// itself, not its target.
 func (a *LocalFSAdapter) Delete(ctx context.Context, path string) error {
-	fullPath, err := pathutil.SafeJoin(a.rootPath, path)
+	fullPath, err := pathutil.SafeJoinNoFollow(a.rootPath, path)
 	if err != nil {
 		return metadata.ErrForbidden
 	}
@@ -167,14 +218,16 @@ func (a *LocalFSAdapter) Delete(ctx context.Context, path string) error {
 	return nil
 }
 
-// Stat returns metadata for a file or directory
+// Stat returns metadata for a file, directory or symlink. A symlink is
+// reported as itself, with its target, rather than followed. The link count
+// and the configured extended attributes are included.
 func (a *LocalFSAdapter) Stat(ctx context.Context, path string) (*metadata.Metadata, error) {
-	fullPath, err := pathutil.SafeJoin(a.rootPath, path)
+	fullPath, err := pathutil.SafeJoinNoFollow(a.rootPath, path)
 	if err != nil {
 		return nil, metadata.ErrForbidden
 	}
 
-	info, err := os.Stat(fullPath)
+	info, err := os.Lstat(fullPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, metadata.ErrNotFound
@@ -193,18 +246,56 @@ func (a *LocalFSAdapter) Stat(ctx context.Context, path string) (*metadata.Metad
 	}
 
 	// Determine type and extract platform-specific metadata
-	if info.IsDir() {
+	switch {
+	case info.IsDir():
 		md.Type = "directory"
-	} else {
+	case info.Mode()&os.ModeSymlink != 0:
+		md.Type = "symlink"
+		target, err := os.Readlink(fullPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read symlink %s: %w", path, err)
+		}
+		md.SymlinkTarget = &target
+	default:
 		md.Type = "file"
 	}
 
 	// Extract platform-specific metadata (permissions, ownership, timestamps)
 	md.Mode, md.UID, md.GID, md.ATime, md.CTime = extractUnixMetadata(info)
+	md.NLink = linkCount(info)
+
+	if len(a.xattrs) > 0 {
+		md.XAttrs, err = readXAttrs(fullPath, a.xattrs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read extended attributes of %s: %w", path, err)
+		}
+	}
 
 	return md, nil
 }
 
+// ResolveSymlink returns the path, relative to the root, of the entry the
+// symlink at path points to, following every symlink on the way. Targets
+// outside the root are refused with metadata.ErrForbidden, and missing ones
+// return metadata.ErrNotFound.
+func (a *LocalFSAdapter) ResolveSymlink(ctx context.Context, path string) (string, error) {
+	fullPath, err := pathutil.SafeJoin(a.rootPath, path)
+	if err != nil {
+		return "", metadata.ErrForbidden
+	}
+	if _, err := os.Stat(fullPath); err != nil {
+		if os.IsNotExist(err) {
+			return "", metadata.ErrNotFound
+		}
+		return "", fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	rel, err := filepath.Rel(filepath.Clean(a.rootPath), fullPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", path, err)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
 // ListDirectory returns metadata for all children of a directory
 func (a *LocalFSAdapter) ListDirectory(ctx context.Context, path string) ([]*metadata.Metadata, error) {
 	fullPath, err := pathutil.SafeJoin(a.rootPath, path)
@@ -250,6 +341,10 @@ func (a *LocalFSAdapter) CreateDirectory(ctx context.Context, path string) error
 		return nil
 	}
 
+	if err := a.checkSpace(0); err != nil {
+		return err
+	}
+
 	err = os.MkdirAll(fullPath, 0755)
 	if err != nil {
 		return fmt.Errorf("failed to create directory %s: %w", path, err)
@@ -258,6 +353,173 @@ func (a *LocalFSAdapter) CreateDirectory(ctx context.Context, path string) error
 	return nil
 }
 
+// Rename moves a file or directory within the root path
+func (a *LocalFSAdapter) Rename(ctx context.Context, oldPath, newPath string) error {
+	oldFull, err := pathutil.SafeJoin(a.rootPath, oldPath)
+	if err != nil {
+		return metadata.ErrForbidden
+	}
+	newFull, err := pathutil.SafeJoin(a.rootPath, newPath)
+	if err != nil {
+		return metadata.ErrForbidden
+	}
+
+	if _, err := os.Lstat(oldFull); err != nil {
+		if os.IsNotExist(err) {
+			return metadata.ErrNotFound
+		}
+		return fmt.Errorf("failed to stat %s: %w", oldPath, err)
+	}
+	if _, err := os.Lstat(newFull); err == nil {
+		return metadata.ErrAlreadyExists
+	}
+
+	if err := os.MkdirAll(filepath.Dir(newFull), 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory of %s: %w", newPath, err)
+	}
+	if err := os.Rename(oldFull, newFull); err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %w", oldPath, newPath, err)
+	}
+	return nil
+}
+
+// Clone copies a file, sharing its content copy-on-write where the
+// filesystem supports it and copying it otherwise. The copy is a file of its
+// own, with the mode and modification time of the original, so changes to
+// either leave the other alone.
+func (a *LocalFSAdapter) Clone(ctx context.Context, oldPath, newPath string) error {
+	oldFull, err := pathutil.SafeJoin(a.rootPath, oldPath)
+	if err != nil {
+		return metadata.ErrForbidden
+	}
+	newFull, err := pathutil.SafeJoin(a.rootPath, newPath)
+	if err != nil {
+		return metadata.ErrForbidden
+	}
+
+	src, err := os.Open(oldFull)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return metadata.ErrNotFound
+		}
+		return fmt.Errorf("failed to open %s: %w", oldPath, err)
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", oldPath, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("cannot clone %s: not a regular file", oldPath)
+	}
+
+	dir := filepath.Dir(newFull)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory of %s: %w", newPath, err)
+	}
+
+	// Copy to a temporary name, then replace any earlier copy
+	tmpFile, err := os.CreateTemp(dir, ".callfs-tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+	fail := func(err error) error {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if reflink(tmpFile, src) != nil {
+		if err := a.checkSpace(info.Size()); err != nil {
+			return fail(err)
+		}
+		if _, err := io.Copy(tmpFile, src); err != nil {
+			if errors.Is(err, syscall.ENOSPC) {
+				return fail(fmt.Errorf("%w: %w", backends.ErrInsufficientStorage, err))
+			}
+			return fail(fmt.Errorf("failed to copy %s: %w", oldPath, err))
+		}
+	}
+	if a.sync {
+		if err := tmpFile.Sync(); err != nil {
+			return fail(fmt.Errorf("failed to fsync file: %w", err))
+		}
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := os.Chmod(tmpPath, info.Mode().Perm()); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+	if err := os.Chtimes(tmpPath, time.Time{}, info.ModTime()); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to set file times: %w", err)
+	}
+	if err := os.Rename(tmpPath, newFull); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to clone %s to %s: %w", oldPath, newPath, err)
+	}
+
+	if a.sync {
+		if err := syncDir(dir); err != nil {
+			return fmt.Errorf("failed to fsync directory: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetAttributes changes the permission bits and times of a file or directory.
+// The owner keeps read and write access, and search access to directories, so
+// the server can always reach what it stores.
+func (a *LocalFSAdapter) SetAttributes(ctx context.Context, path string, mode *os.FileMode, atime, mtime time.Time) error {
+	fullPath, err := pathutil.SafeJoin(a.rootPath, path)
+	if err != nil {
+		return metadata.ErrForbidden
+	}
+
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return metadata.ErrNotFound
+		}
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	if mode != nil {
+		perm := mode.Perm() | 0600
+		if info.IsDir() {
+			perm |= 0700
+		}
+		if err := os.Chmod(fullPath, perm); err != nil {
+			return fmt.Errorf("failed to change mode of %s: %w", path, err)
+		}
+	}
+	if !atime.IsZero() || !mtime.IsZero() {
+		if err := os.Chtimes(fullPath, atime, mtime); err != nil {
+			return fmt.Errorf("failed to change times of %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// SetXAttr sets an extended attribute of a stored file
+func (a *LocalFSAdapter) SetXAttr(ctx context.Context, path, name, value string) error {
+	fullPath, err := pathutil.SafeJoin(a.rootPath, path)
+	if err != nil {
+		return metadata.ErrForbidden
+	}
+	if err := writeXAttr(fullPath, name, value); err != nil {
+		if os.IsNotExist(err) {
+			return metadata.ErrNotFound
+		}
+		return fmt.Errorf("failed to set extended attribute %s of %s: %w", name, path, err)
+	}
+	return nil
+}
+
 // Close closes any resources used by the storage backend
 func (a *LocalFSAdapter) Close() error {
 	// No resources to close for local filesystem
@@ -0,0 +1,20 @@
+//go:build !linux && !darwin && !freebsd
+
+package localfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Capacity is not supported on this platform
+func (a *LocalFSAdapter) Capacity(ctx context.Context) (free, total int64, err error) {
+	return 0, 0, fmt.Errorf("filesystem capacity: %w", errors.ErrUnsupported)
+}
+
+// checkSpace is not supported on this platform; writes fail only when the
+// filesystem is full
+func (a *LocalFSAdapter) checkSpace(size int64) error {
+	return nil
+}
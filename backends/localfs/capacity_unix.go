@@ -0,0 +1,46 @@
+//go:build linux || darwin || freebsd
+
+package localfs
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+
+	"github.com/ebogdum/callfs/backends"
+)
+
+// Capacity returns the free and total bytes of the filesystem holding the
+// root path. Free space is what unprivileged users may still allocate.
+func (a *LocalFSAdapter) Capacity(ctx context.Context) (free, total int64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(a.rootPath, &st); err != nil {
+		return 0, 0, fmt.Errorf("failed to stat filesystem of %s: %w", a.rootPath, err)
+	}
+	return int64(uint64(st.Bavail) * uint64(st.Bsize)), int64(uint64(st.Blocks) * uint64(st.Bsize)), nil
+}
+
+// checkSpace rejects a write of size bytes that does not fit, or that would
+// leave less free space or fewer free inodes than configured. A negative
+// size checks only what is free now. Filesystems without a fixed number of
+// inodes skip that check.
+func (a *LocalFSAdapter) checkSpace(size int64) error {
+	if size <= 0 && a.minFreeBytes <= 0 && a.minFreeInodes <= 0 {
+		return nil
+	}
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(a.rootPath, &st); err != nil {
+		return fmt.Errorf("failed to stat filesystem of %s: %w", a.rootPath, err)
+	}
+
+	free := int64(uint64(st.Bavail) * uint64(st.Bsize))
+	if free-max(size, 0) < a.minFreeBytes {
+		return fmt.Errorf("%w: %d bytes free, %d requested with %d reserved",
+			backends.ErrInsufficientStorage, free, max(size, 0), a.minFreeBytes)
+	}
+	if a.minFreeInodes > 0 && st.Files > 0 && int64(st.Ffree) <= a.minFreeInodes {
+		return fmt.Errorf("%w: %d inodes free with %d reserved",
+			backends.ErrInsufficientStorage, int64(st.Ffree), a.minFreeInodes)
+	}
+	return nil
+}
@@ -33,3 +33,11 @@ func extractUnixMetadata(info os.FileInfo) (mode string, uid, gid int, atime, ct
 
 	return
 }
+
+// linkCount returns the number of hard links to a file
+func linkCount(info os.FileInfo) int {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Nlink)
+	}
+	return 1
+}
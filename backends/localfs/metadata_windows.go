@@ -22,3 +22,8 @@ func extractUnixMetadata(info os.FileInfo) (mode string, uid, gid int, atime, ct
 
 	return
 }
+
+// linkCount is not reported on Windows; every file counts as one link
+func linkCount(info os.FileInfo) int {
+	return 1
+}
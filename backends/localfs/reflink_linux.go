@@ -0,0 +1,15 @@
+//go:build linux
+
+package localfs
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflink makes dst share the content of src copy-on-write, on filesystems
+// that support it such as Btrfs and XFS
+func reflink(dst, src *os.File) error {
+	return unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+}
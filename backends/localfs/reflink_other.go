@@ -0,0 +1,13 @@
+//go:build !linux
+
+package localfs
+
+import (
+	"errors"
+	"os"
+)
+
+// reflink is not supported here; content is copied instead
+func reflink(dst, src *os.File) error {
+	return errors.ErrUnsupported
+}
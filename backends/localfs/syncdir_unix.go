@@ -0,0 +1,15 @@
+//go:build !windows
+
+package localfs
+
+import "os"
+
+// syncDir flushes a directory's entries to stable storage
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package localfs
+
+// syncDir is a no-op: Windows cannot fsync a directory, and NTFS journals
+// renames itself
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build darwin || freebsd
+
+package localfs
+
+import "golang.org/x/sys/unix"
+
+// errNoXAttr is returned for an extended attribute a file does not have
+const errNoXAttr = unix.ENOATTR
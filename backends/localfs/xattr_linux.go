@@ -0,0 +1,8 @@
+//go:build linux
+
+package localfs
+
+import "golang.org/x/sys/unix"
+
+// errNoXAttr is returned for an extended attribute a file does not have
+const errNoXAttr = unix.ENODATA
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd
+
+package localfs
+
+import "errors"
+
+// readXAttrs is not supported on this platform; no attributes are reported
+func readXAttrs(fullPath string, names []string) (map[string]string, error) {
+	return nil, nil
+}
+
+// writeXAttr is not supported on this platform
+func writeXAttr(fullPath, name, value string) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,48 @@
+//go:build linux || darwin || freebsd
+
+package localfs
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXAttrs returns the named extended attributes of a file, or of a symlink
+// itself. Attributes the file does not have are left out.
+func readXAttrs(fullPath string, names []string) (map[string]string, error) {
+	attrs := make(map[string]string)
+	for _, name := range names {
+		size, err := unix.Lgetxattr(fullPath, name, nil)
+		if isMissingXAttr(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, size)
+		size, err = unix.Lgetxattr(fullPath, name, value)
+		if isMissingXAttr(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		attrs[name] = string(value[:size])
+	}
+	if len(attrs) == 0 {
+		return nil, nil
+	}
+	return attrs, nil
+}
+
+// writeXAttr sets an extended attribute of a file
+func writeXAttr(fullPath, name, value string) error {
+	return unix.Lsetxattr(fullPath, name, []byte(value), 0)
+}
+
+// isMissingXAttr reports whether err means the attribute is not set, or the
+// filesystem does not support extended attributes
+func isMissingXAttr(err error) bool {
+	return errors.Is(err, errNoXAttr) || errors.Is(err, unix.ENOTSUP)
+}
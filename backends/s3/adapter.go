@@ -1,15 +1,20 @@
 package s3
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"go.uber.org/zap"
 
+	"github.com/ebogdum/callfs/backends"
 	"github.com/ebogdum/callfs/config"
 )
 
@@ -20,6 +25,10 @@ type S3Adapter struct {
 	serverSideEncryption string
 	acl                  string
 	kmsKeyID             string
+	downloadConcurrency  int    // Parts fetched ahead by a parallel download; 0 disables them
+	downloadPartSize     int64  // Bytes per part of a parallel download
+	objectLockMode       string // Retention mode of held objects; holds are not applied when empty
+	cache                *diskCache
 	logger               *zap.Logger
 }
 
@@ -63,16 +72,44 @@ func NewS3Adapter(cfg config.BackendConfig, logger *zap.Logger) (*S3Adapter, err
 		return nil, fmt.Errorf("failed to access S3 bucket %s: %w", cfg.S3BucketName, err)
 	}
 
+	partSize := cfg.S3DownloadPartSize
+	if partSize <= 0 {
+		partSize = DefaultDownloadPartSize
+	}
+
+	var cache *diskCache
+	if cfg.S3CacheDir != "" {
+		cache, err = newDiskCache(cfg.S3CacheDir, cfg.S3CacheMaxBytes, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &S3Adapter{
 		client:               client,
 		bucketName:           cfg.S3BucketName,
 		serverSideEncryption: cfg.S3ServerSideEncryption,
 		acl:                  cfg.S3ACL,
 		kmsKeyID:             cfg.S3KMSKeyID,
+		downloadConcurrency:  cfg.S3DownloadConcurrency,
+		downloadPartSize:     partSize,
+		objectLockMode:       cfg.S3ObjectLockMode,
+		cache:                cache,
 		logger:               logger,
 	}, nil
 }
 
+// Ping verifies the configured bucket is reachable
+func (a *S3Adapter) Ping(ctx context.Context) error {
+	_, err := a.client.HeadBucketWithContext(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(a.bucketName),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to access S3 bucket %s: %w", a.bucketName, err)
+	}
+	return nil
+}
+
 // Close closes any resources used by the S3 adapter
 func (a *S3Adapter) Close() error {
 	// No resources to close for S3
@@ -108,3 +145,19 @@ func isS3NotFound(err error) bool {
 		strings.Contains(errStr, "NotFound") ||
 		strings.Contains(errStr, "status code: 404")
 }
+
+// s3Error marks err as backends.ErrBackendUnavailable when S3 could not be
+// reached, failed or throttled the request
+func s3Error(err error) error {
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		status := reqErr.StatusCode()
+		if status >= http.StatusInternalServerError || status == http.StatusTooManyRequests || reqErr.Code() == "SlowDown" {
+			return fmt.Errorf("%w: %w", backends.ErrBackendUnavailable, err)
+		}
+		return err
+	}
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == request.ErrCodeRequestError {
+		return fmt.Errorf("%w: %w", backends.ErrBackendUnavailable, err)
+	}
+	return err
+}
@@ -0,0 +1,370 @@
+package s3
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/backends"
+	corelog "github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/metadata"
+	"github.com/ebogdum/callfs/metrics"
+)
+
+// cacheTempPrefix names the files a cache fill writes before it completes
+const cacheTempPrefix = "tmp-"
+
+// diskCache keeps the content of recently read objects on local disk, up to
+// maxBytes, evicting the least recently used. Each file is named after the
+// hash of its key and its ETag, so an entry is served only while the object
+// still has that ETag. A nil cache caches nothing.
+type diskCache struct {
+	dir      string
+	maxBytes int64
+	logger   *zap.Logger
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // By key hash, of *cacheEntry
+	lru     *list.List               // Most recently used first
+	size    int64
+}
+
+// cacheEntry is an object held by the cache
+type cacheEntry struct {
+	keyHash string
+	etag    string
+	size    int64
+}
+
+func (e *cacheEntry) fileName() string {
+	return e.keyHash + "." + hex.EncodeToString([]byte(e.etag))
+}
+
+// newDiskCache opens the cache in dir, keeping the entries a previous run
+// left there, oldest first in line for eviction
+func newDiskCache(dir string, maxBytes int64, logger *zap.Logger) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create S3 cache directory: %w", err)
+	}
+	c := &diskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		logger:   logger,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read S3 cache directory: %w", err)
+	}
+	type found struct {
+		entry *cacheEntry
+		info  os.FileInfo
+	}
+	var kept []found
+	for _, file := range files {
+		name := file.Name()
+		keyHash, etagHex, ok := strings.Cut(name, ".")
+		etag, err := hex.DecodeString(etagHex)
+		info, infoErr := file.Info()
+		if strings.HasPrefix(name, cacheTempPrefix) || !ok || err != nil || infoErr != nil || !info.Mode().IsRegular() {
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		kept = append(kept, found{&cacheEntry{keyHash: keyHash, etag: string(etag), size: info.Size()}, info})
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].info.ModTime().After(kept[j].info.ModTime()) })
+	for _, f := range kept {
+		if _, dup := c.entries[f.entry.keyHash]; dup {
+			_ = os.Remove(filepath.Join(dir, f.entry.fileName()))
+			continue
+		}
+		c.entries[f.entry.keyHash] = c.lru.PushBack(f.entry)
+		c.size += f.entry.size
+	}
+	c.evict()
+	return c, nil
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// lookup returns the entry of key, or nil
+func (c *diskCache) lookup(key string) *cacheEntry {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[hashKey(key)]; ok {
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*cacheEntry)
+	}
+	return nil
+}
+
+// open opens the file of entry. An entry evicted since its lookup fails to
+// open; one evicted after stays readable until closed.
+func (c *diskCache) open(entry *cacheEntry) (*os.File, error) {
+	return os.Open(filepath.Join(c.dir, entry.fileName()))
+}
+
+// add records a filled entry, replacing any other of its key, and evicts the
+// least recently used entries beyond the cache size
+func (c *diskCache) add(entry *cacheEntry) {
+	c.mu.Lock()
+	if elem, ok := c.entries[entry.keyHash]; ok {
+		if elem.Value.(*cacheEntry).etag == entry.etag {
+			// Filled twice at once; the rename replaced the same content
+			c.lru.MoveToFront(elem)
+			c.mu.Unlock()
+			return
+		}
+		c.removeLocked(elem)
+	}
+	c.entries[entry.keyHash] = c.lru.PushFront(entry)
+	c.size += entry.size
+	c.mu.Unlock()
+	c.evict()
+}
+
+func (c *diskCache) evict() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.size > c.maxBytes && c.lru.Len() > 0 {
+		c.removeLocked(c.lru.Back())
+		metrics.S3CacheEvictionsTotal.Inc()
+	}
+	metrics.S3CacheBytes.Set(float64(c.size))
+}
+
+// removeLocked drops an entry and its file; c.mu must be held
+func (c *diskCache) removeLocked(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	if current, ok := c.entries[entry.keyHash]; ok && current == elem {
+		delete(c.entries, entry.keyHash)
+	}
+	c.size -= entry.size
+	if err := os.Remove(filepath.Join(c.dir, entry.fileName())); err != nil && !errors.Is(err, os.ErrNotExist) {
+		c.logger.Warn("Failed to remove S3 cache file", zap.String("file", entry.fileName()), zap.Error(err))
+	}
+}
+
+// invalidate drops the entries of keys
+func (c *diskCache) invalidate(keys ...string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if elem, ok := c.entries[hashKey(key)]; ok {
+			c.removeLocked(elem)
+		}
+	}
+	metrics.S3CacheBytes.Set(float64(c.size))
+}
+
+// flush drops the entry of key, or every entry when key is empty, and
+// returns how many entries and bytes it removed
+func (c *diskCache) flush(key string) (int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var elems []*list.Element
+	if key != "" {
+		if elem, ok := c.entries[hashKey(key)]; ok {
+			elems = append(elems, elem)
+		}
+	} else {
+		for elem := c.lru.Front(); elem != nil; elem = elem.Next() {
+			elems = append(elems, elem)
+		}
+	}
+	var bytes int64
+	for _, elem := range elems {
+		bytes += elem.Value.(*cacheEntry).size
+		c.removeLocked(elem)
+	}
+	metrics.S3CacheBytes.Set(float64(c.size))
+	return len(elems), bytes
+}
+
+// fill returns body, the content of the object at key, which it copies into
+// the cache as it is read. The entry is added once body was read to the end;
+// objects without an ETag or larger than the cache are not cached.
+func (c *diskCache) fill(key string, result *s3.GetObjectOutput) io.ReadCloser {
+	etag := aws.StringValue(result.ETag)
+	size := aws.Int64Value(result.ContentLength)
+	if etag == "" || result.ContentLength == nil || size > c.maxBytes {
+		return result.Body
+	}
+	tmp, err := os.CreateTemp(c.dir, cacheTempPrefix+"*")
+	if err != nil {
+		c.logger.Warn("Failed to create S3 cache file", zap.Error(err))
+		return result.Body
+	}
+	return &fillingReader{
+		body:  result.Body,
+		cache: c,
+		tmp:   tmp,
+		entry: &cacheEntry{keyHash: hashKey(key), etag: etag, size: size},
+	}
+}
+
+// fillingReader copies what is read from an S3 object into a cache file
+type fillingReader struct {
+	body    io.ReadCloser
+	cache   *diskCache
+	tmp     *os.File // nil once the fill completed or was abandoned
+	entry   *cacheEntry
+	written int64
+}
+
+func (f *fillingReader) Read(p []byte) (int, error) {
+	n, err := f.body.Read(p)
+	if f.tmp != nil && n > 0 {
+		if _, werr := f.tmp.Write(p[:n]); werr != nil {
+			f.cache.logger.Warn("Failed to write S3 cache file", zap.Error(werr))
+			f.abandon()
+		}
+		f.written += int64(n)
+	}
+	if f.tmp != nil && err == io.EOF {
+		f.complete()
+	}
+	return n, err
+}
+
+// complete moves a fully written file into the cache
+func (f *fillingReader) complete() {
+	tmp := f.tmp
+	f.tmp = nil
+	if f.written != f.entry.size {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(f.cache.dir, f.entry.fileName())); err != nil {
+		f.cache.logger.Warn("Failed to add S3 cache file", zap.Error(err))
+		_ = os.Remove(tmp.Name())
+		return
+	}
+	f.cache.add(f.entry)
+}
+
+// abandon discards a partial file
+func (f *fillingReader) abandon() {
+	if f.tmp != nil {
+		_ = f.tmp.Close()
+		_ = os.Remove(f.tmp.Name())
+		f.tmp = nil
+	}
+}
+
+func (f *fillingReader) Close() error {
+	f.abandon()
+	return f.body.Close()
+}
+
+// openCached opens the object at key from entry, its cache entry or nil, if
+// the object still has the cached ETag, and otherwise from S3, caching what
+// is read. With a non-negative length, only length bytes from offset are
+// opened and nothing new is cached.
+func (a *S3Adapter) openCached(ctx context.Context, key string, entry *cacheEntry, offset, length int64) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(a.bucketName),
+		Key:    aws.String(key),
+	}
+	if length >= 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	}
+	if entry != nil {
+		input.IfNoneMatch = aws.String(entry.etag)
+	}
+
+	result, err := a.client.GetObjectWithContext(ctx, input)
+	if err != nil {
+		if entry != nil && isS3NotModified(err) {
+			if file, err := a.cache.open(entry); err == nil {
+				metrics.S3CacheRequestsTotal.WithLabelValues("hit").Inc()
+				if length < 0 {
+					return file, nil
+				}
+				return struct {
+					io.Reader
+					io.Closer
+				}{io.NewSectionReader(file, offset, length), file}, nil
+			}
+			input.IfNoneMatch = nil // Evicted meanwhile
+			result, err = a.client.GetObjectWithContext(ctx, input)
+		}
+	}
+	if err != nil {
+		if isS3NotFound(err) {
+			a.cache.invalidate(key)
+			return nil, metadata.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get object from S3: %w", s3Error(err))
+	}
+
+	if entry != nil {
+		metrics.S3CacheRequestsTotal.WithLabelValues("stale").Inc()
+	} else {
+		metrics.S3CacheRequestsTotal.WithLabelValues("miss").Inc()
+	}
+	corelog.WithContext(ctx, a.logger).Debug("File opened from S3",
+		zap.String("bucket", a.bucketName),
+		zap.String("key", key),
+		zap.Bool("cached", entry != nil))
+	if length >= 0 {
+		return result.Body, nil
+	}
+	return a.cache.fill(key, result), nil
+}
+
+// FlushCache empties the local content cache, or drops path from it when
+// path is not empty
+func (a *S3Adapter) FlushCache(ctx context.Context, path string) (int, int64, error) {
+	if a.cache == nil {
+		return 0, 0, backends.ErrCacheDisabled
+	}
+	key := ""
+	if path != "" {
+		key = a.pathToKey(path)
+	}
+	files, bytes := a.cache.flush(key)
+	corelog.WithContext(ctx, a.logger).Info("S3 cache flushed",
+		zap.String("key", key), zap.Int("files", files), zap.Int64("bytes", bytes))
+	return files, bytes, nil
+}
+
+// isS3NotModified checks if an error answers a conditional GET whose ETag
+// still matches
+func isS3NotModified(err error) bool {
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		return reqErr.StatusCode() == http.StatusNotModified
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"go.uber.org/zap"
+
+	corelog "github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// Clone copies an object within the bucket, without downloading it. Copies
+// are limited to the 5 GB a single CopyObject call accepts.
+func (a *S3Adapter) Clone(ctx context.Context, oldPath, newPath string) error {
+	oldKey := a.pathToKey(oldPath)
+	newKey := a.pathToKey(newPath)
+
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(a.bucketName),
+		CopySource: aws.String(url.PathEscape(a.bucketName + "/" + oldKey)),
+		Key:        aws.String(newKey),
+	}
+	if a.serverSideEncryption != "" {
+		input.ServerSideEncryption = aws.String(a.serverSideEncryption)
+		if a.serverSideEncryption == "aws:kms" && a.kmsKeyID != "" {
+			input.SSEKMSKeyId = aws.String(a.kmsKeyID)
+		}
+	}
+	if a.acl != "" {
+		input.ACL = aws.String(a.acl)
+	}
+	if _, err := a.client.CopyObjectWithContext(ctx, input); err != nil {
+		if isS3NotFound(err) {
+			return metadata.ErrNotFound
+		}
+		return fmt.Errorf("failed to copy object %s in S3: %w", oldKey, s3Error(err))
+	}
+	a.cache.invalidate(newKey)
+
+	corelog.WithContext(ctx, a.logger).Debug("Cloned in S3",
+		zap.String("bucket", a.bucketName),
+		zap.String("from", oldKey),
+		zap.String("to", newKey))
+
+	return nil
+}
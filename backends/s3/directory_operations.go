@@ -11,6 +11,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3"
 	"go.uber.org/zap"
 
+	corelog "github.com/ebogdum/callfs/core/log"
 	"github.com/ebogdum/callfs/metadata"
 )
 
@@ -33,7 +34,7 @@ func (a *S3Adapter) ListDirectory(ctx context.Context, path string) ([]*metadata
 	for {
 		result, err := a.client.ListObjectsV2WithContext(ctx, input)
 		if err != nil {
-			return nil, fmt.Errorf("failed to list objects in S3: %w", err)
+			return nil, fmt.Errorf("failed to list objects in S3: %w", s3Error(err))
 		}
 
 		// Process directory objects (common prefixes)
@@ -127,10 +128,10 @@ func (a *S3Adapter) CreateDirectory(ctx context.Context, path string) error {
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to create directory marker in S3: %w", err)
+		return fmt.Errorf("failed to create directory marker in S3: %w", s3Error(err))
 	}
 
-	a.logger.Debug("Directory created in S3",
+	corelog.WithContext(ctx, a.logger).Debug("Directory created in S3",
 		zap.String("bucket", a.bucketName),
 		zap.String("key", key))
 
@@ -0,0 +1,197 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"go.uber.org/zap"
+
+	corelog "github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// DefaultDownloadPartSize is the size of the ranges a parallel download
+// fetches, unless configured otherwise
+const DefaultDownloadPartSize = 16 * 1024 * 1024
+
+// downloadPart is a fetched part of a parallel download
+type downloadPart struct {
+	reader io.ReadCloser
+	err    error
+}
+
+// parallelReader streams the parts of an object range in order while later
+// parts are fetched by concurrent ranged GETs
+type parallelReader struct {
+	current   io.ReadCloser
+	parts     chan chan downloadPart // In order; bounded by the concurrency
+	remaining int64
+	cancel    context.CancelFunc
+	ctx       context.Context
+}
+
+// openParallel opens length bytes of the object at key starting at offset,
+// or everything after offset when length is negative. The first part is
+// streamed as it arrives; up to downloadConcurrency later parts are fetched
+// ahead of the reader and held in memory. Later parts must match the ETag of
+// the first, so an object replaced mid-download fails the read instead of
+// mixing contents.
+func (a *S3Adapter) openParallel(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	first := a.downloadPartSize
+	if length >= 0 {
+		first = min(first, length)
+	}
+	result, err := a.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(a.bucketName),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+first-1)),
+	})
+	if err != nil {
+		if isS3NotFound(err) {
+			return nil, metadata.ErrNotFound
+		}
+		if isS3InvalidRange(err) && offset == 0 && length < 0 {
+			return a.getObject(ctx, key) // An empty object has no byte ranges
+		}
+		return nil, fmt.Errorf("failed to get object range from S3: %w", s3Error(err))
+	}
+
+	if length < 0 {
+		total, err := objectSize(aws.StringValue(result.ContentRange))
+		if err != nil {
+			result.Body.Close()
+			return nil, err
+		}
+		length = total - offset
+	}
+	if length <= first {
+		return result.Body, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	r := &parallelReader{
+		current:   result.Body,
+		parts:     make(chan chan downloadPart, a.downloadConcurrency),
+		remaining: length,
+		cancel:    cancel,
+		ctx:       ctx,
+	}
+	etag := result.ETag
+	go func() {
+		defer close(r.parts)
+		end := offset + length
+		for start := offset + first; start < end; start += a.downloadPartSize {
+			part := make(chan downloadPart, 1)
+			select {
+			case r.parts <- part:
+			case <-ctx.Done():
+				return
+			}
+			size := min(a.downloadPartSize, end-start)
+			go func(start int64) {
+				part <- a.fetchPart(ctx, key, etag, start, size)
+			}(start)
+		}
+	}()
+
+	corelog.WithContext(ctx, a.logger).Debug("Parallel download started from S3",
+		zap.String("bucket", a.bucketName),
+		zap.String("key", key),
+		zap.Int64("offset", offset),
+		zap.Int64("length", length),
+		zap.Int("concurrency", a.downloadConcurrency))
+	return r, nil
+}
+
+// fetchPart reads size bytes of the object at key starting at start into
+// memory, provided the object still has etag
+func (a *S3Adapter) fetchPart(ctx context.Context, key string, etag *string, start, size int64) downloadPart {
+	result, err := a.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket:  aws.String(a.bucketName),
+		Key:     aws.String(key),
+		Range:   aws.String(fmt.Sprintf("bytes=%d-%d", start, start+size-1)),
+		IfMatch: etag,
+	})
+	if err != nil {
+		return downloadPart{err: fmt.Errorf("failed to get object range from S3: %w", s3Error(err))}
+	}
+	defer result.Body.Close()
+
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(result.Body, buf); err != nil {
+		return downloadPart{err: fmt.Errorf("failed to read object range from S3: %w", s3Error(err))}
+	}
+	return downloadPart{reader: io.NopCloser(bytes.NewReader(buf))}
+}
+
+func (r *parallelReader) Read(p []byte) (int, error) {
+	for {
+		if r.current != nil {
+			n, err := r.current.Read(p)
+			r.remaining -= int64(n)
+			if err == io.EOF {
+				r.current.Close()
+				r.current = nil
+				if n > 0 {
+					return n, nil
+				}
+				continue
+			}
+			return n, err
+		}
+
+		part, ok := <-r.parts
+		if !ok {
+			if r.remaining > 0 {
+				if err := r.ctx.Err(); err != nil {
+					return 0, err
+				}
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, io.EOF
+		}
+		result := <-part
+		if result.err != nil {
+			return 0, result.err
+		}
+		r.current = result.reader
+	}
+}
+
+// Close stops fetching parts
+func (r *parallelReader) Close() error {
+	r.cancel()
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
+
+// objectSize returns the object size of a Content-Range header
+func objectSize(contentRange string) (int64, error) {
+	i := strings.LastIndex(contentRange, "/")
+	if i < 0 {
+		return 0, fmt.Errorf("unexpected Content-Range %q from S3", contentRange)
+	}
+	size, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected Content-Range %q from S3", contentRange)
+	}
+	return size, nil
+}
+
+// isS3InvalidRange checks if an error rejects the range of a GET
+func isS3InvalidRange(err error) bool {
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		return reqErr.StatusCode() == http.StatusRequestedRangeNotSatisfiable
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"go.uber.org/zap"
+
+	corelog "github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// Rename moves a file, or every object under a directory prefix, to a new
+// path. S3 has no rename, so each object is copied and then deleted; copies
+// are limited to the 5 GB a single CopyObject call accepts.
+func (a *S3Adapter) Rename(ctx context.Context, oldPath, newPath string) error {
+	oldKey := a.pathToKey(oldPath)
+	newKey := a.pathToKey(newPath)
+
+	keys, err := a.subtreeKeys(ctx, oldKey)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return metadata.ErrNotFound
+	}
+	existing, err := a.subtreeKeys(ctx, newKey)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return metadata.ErrAlreadyExists
+	}
+
+	for _, key := range keys {
+		input := &s3.CopyObjectInput{
+			Bucket:     aws.String(a.bucketName),
+			CopySource: aws.String(url.PathEscape(a.bucketName + "/" + key)),
+			Key:        aws.String(newKey + strings.TrimPrefix(key, oldKey)),
+		}
+		if a.serverSideEncryption != "" {
+			input.ServerSideEncryption = aws.String(a.serverSideEncryption)
+			if a.serverSideEncryption == "aws:kms" && a.kmsKeyID != "" {
+				input.SSEKMSKeyId = aws.String(a.kmsKeyID)
+			}
+		}
+		if a.acl != "" {
+			input.ACL = aws.String(a.acl)
+		}
+		if _, err := a.client.CopyObjectWithContext(ctx, input); err != nil {
+			return fmt.Errorf("failed to copy object %s in S3: %w", key, s3Error(err))
+		}
+	}
+
+	for _, key := range keys {
+		_, err := a.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(a.bucketName),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete object %s from S3: %w", key, s3Error(err))
+		}
+	}
+	a.cache.invalidate(keys...)
+
+	corelog.WithContext(ctx, a.logger).Debug("Renamed in S3",
+		zap.String("bucket", a.bucketName),
+		zap.String("from", oldKey),
+		zap.String("to", newKey),
+		zap.Int("objects", len(keys)))
+
+	return nil
+}
+
+// subtreeKeys returns key itself if it is an object, and every object below
+// key as a directory prefix, including its marker
+func (a *S3Adapter) subtreeKeys(ctx context.Context, key string) ([]string, error) {
+	var keys []string
+	_, err := a.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(a.bucketName),
+		Key:    aws.String(key),
+	})
+	switch {
+	case err == nil:
+		keys = append(keys, key)
+	case !isS3NotFound(err):
+		return nil, fmt.Errorf("failed to stat object in S3: %w", s3Error(err))
+	}
+
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(a.bucketName),
+		Prefix: aws.String(key + "/"),
+	}
+	err = a.client.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			if object.Key != nil {
+				keys = append(keys, *object.Key)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects in S3: %w", s3Error(err))
+	}
+	return keys, nil
+}
@@ -0,0 +1,54 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/ebogdum/callfs/backends"
+)
+
+// IncompleteUploads lists the multipart uploads of the bucket initiated
+// before startedBefore. Uploads interrupted by a crash keep their parts, and
+// their storage cost, until they are aborted.
+func (a *S3Adapter) IncompleteUploads(ctx context.Context, startedBefore time.Time) ([]backends.IncompleteUpload, error) {
+	var uploads []backends.IncompleteUpload
+	err := a.client.ListMultipartUploadsPagesWithContext(ctx, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(a.bucketName),
+	}, func(page *s3.ListMultipartUploadsOutput, _ bool) bool {
+		for _, upload := range page.Uploads {
+			if upload.Key == nil || upload.UploadId == nil || upload.Initiated == nil {
+				continue
+			}
+			if !upload.Initiated.Before(startedBefore) {
+				continue
+			}
+			uploads = append(uploads, backends.IncompleteUpload{
+				Path:      a.keyToPath(*upload.Key),
+				UploadID:  *upload.UploadId,
+				Initiated: *upload.Initiated,
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list multipart uploads in S3: %w", s3Error(err))
+	}
+	return uploads, nil
+}
+
+// AbortUpload aborts a multipart upload, deleting its parts
+func (a *S3Adapter) AbortUpload(ctx context.Context, upload backends.IncompleteUpload) error {
+	_, err := a.client.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(a.bucketName),
+		Key:      aws.String(a.pathToKey(upload.Path)),
+		UploadId: aws.String(upload.UploadID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to abort multipart upload of %s: %w", upload.Path, err)
+	}
+	return nil
+}
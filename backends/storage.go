@@ -4,11 +4,26 @@ package backends
 
 import (
 	"context"
+	"errors"
 	"io"
+	"os"
+	"time"
 
 	"github.com/ebogdum/callfs/metadata"
 )
 
+// ErrInsufficientStorage is returned by a write the backend does not have
+// the space or inodes to hold
+var ErrInsufficientStorage = errors.New("insufficient storage space")
+
+// ErrBackendUnavailable is returned when the service behind a backend cannot
+// be reached or fails, and the request may succeed if retried
+var ErrBackendUnavailable = errors.New("storage backend unavailable")
+
+// ErrReadOnly is returned for a change refused because the instance, or the
+// path, is in read-only mode
+var ErrReadOnly = errors.New("read-only mode")
+
 // Storage defines the interface for backend storage operations
 // This interface abstracts file operations across different storage backends
 type Storage interface {
@@ -36,3 +51,114 @@ type Storage interface {
 	// Close closes any resources used by the storage backend
 	Close() error
 }
+
+// RangeReader is implemented by backends that can read part of a file
+// without transferring the bytes before it. Callers fall back to Open and
+// discarding the prefix for backends without it.
+type RangeReader interface {
+	// OpenRange opens length bytes of a file starting at offset
+	OpenRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error)
+}
+
+// FileOpener is implemented by backends that keep files on a local
+// filesystem, so their content can be sent to a client by the kernel
+// without passing through userspace buffers
+type FileOpener interface {
+	// OpenFile opens a file for reading
+	OpenFile(ctx context.Context, path string) (*os.File, error)
+}
+
+// SymlinkResolver is implemented by backends that keep symlinks
+type SymlinkResolver interface {
+	// ResolveSymlink returns the path of the entry the symlink at path
+	// points to, or path itself when it is not a symlink
+	ResolveSymlink(ctx context.Context, path string) (string, error)
+}
+
+// ErrCacheDisabled is returned by ContentCache when no cache is configured
+var ErrCacheDisabled = errors.New("no content cache is configured")
+
+// ContentCache is implemented by backends that can keep file content on
+// local disk
+type ContentCache interface {
+	// FlushCache drops path from the cache, or everything when path is
+	// empty, and returns the files and bytes removed
+	FlushCache(ctx context.Context, path string) (files int, bytes int64, err error)
+}
+
+// HealthChecker is implemented by backends that can verify connectivity to
+// their underlying storage. Backends without it (noop, internal proxy) are
+// skipped by readiness checks.
+type HealthChecker interface {
+	Ping(ctx context.Context) error
+}
+
+// CapacityReporter is implemented by backends that can report the space
+// available on their underlying storage
+type CapacityReporter interface {
+	// Capacity returns the free and total bytes
+	Capacity(ctx context.Context) (free, total int64, err error)
+}
+
+// Renamer is implemented by backends that can move a file, or a directory
+// with everything below it, to a new path
+type Renamer interface {
+	// Rename moves oldPath to newPath, creating the parent directories of
+	// newPath. It returns metadata.ErrNotFound if oldPath does not exist and
+	// metadata.ErrAlreadyExists if newPath does.
+	Rename(ctx context.Context, oldPath, newPath string) error
+}
+
+// Cloner is implemented by backends that can copy a file without sending its
+// content through the server
+type Cloner interface {
+	// Clone copies the file at oldPath to newPath, creating the parent
+	// directories of newPath and replacing any file there. Later writes to
+	// oldPath do not change the copy. It returns metadata.ErrNotFound if
+	// oldPath does not exist.
+	Clone(ctx context.Context, oldPath, newPath string) error
+}
+
+// Holder is implemented by backends that can lock the files they store
+// against change and deletion, such as S3 buckets with Object Lock
+type Holder interface {
+	// SetHold places a legal hold on path, or lifts it, and retains path
+	// until retainUntil when it is not nil. It returns metadata.ErrNotFound
+	// if path does not exist.
+	SetHold(ctx context.Context, path string, legalHold bool, retainUntil *time.Time) error
+}
+
+// AttributeSetter is implemented by backends that keep permission bits and
+// timestamps of their own
+type AttributeSetter interface {
+	// SetAttributes applies mode, atime and mtime to path, leaving any of them
+	// that is nil or zero unchanged. It returns metadata.ErrNotFound if path
+	// does not exist.
+	SetAttributes(ctx context.Context, path string, mode *os.FileMode, atime, mtime time.Time) error
+}
+
+// XAttrSetter is implemented by backends that can set extended attributes on
+// the files they store
+type XAttrSetter interface {
+	// SetXAttr sets the extended attribute name of path to value. It returns
+	// metadata.ErrNotFound if path does not exist.
+	SetXAttr(ctx context.Context, path, name, value string) error
+}
+
+// IncompleteUpload is a multipart upload that was started but neither
+// completed nor aborted, whose parts are still stored
+type IncompleteUpload struct {
+	Path      string
+	UploadID  string
+	Initiated time.Time
+}
+
+// UploadCleaner is implemented by backends that keep the parts of
+// interrupted multipart uploads
+type UploadCleaner interface {
+	// IncompleteUploads lists the uploads initiated before startedBefore
+	IncompleteUploads(ctx context.Context, startedBefore time.Time) ([]IncompleteUpload, error)
+
+	// AbortUpload discards an incomplete upload and its parts
+	AbortUpload(ctx context.Context, upload IncompleteUpload) error
+}
@@ -0,0 +1,10 @@
+// Package callfs holds files of the repository that are built into the
+// callfs binary.
+package callfs
+
+import _ "embed"
+
+// SampleConfig is config.yaml.example, which callfs config init writes
+//
+//go:embed config.yaml.example
+var SampleConfig []byte
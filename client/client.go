@@ -0,0 +1,265 @@
+// Package client is a Go client for the CallFS REST API.
+//
+//	c, err := client.New("https://callfs.example.com:8443", apiKey)
+//	if err != nil { ... }
+//	info, err := c.Stat(ctx, "/reports/2024.csv")
+//	r, err := c.Open(ctx, "/reports/2024.csv")
+//
+// Requests that fail with a transient error are retried, and every method
+// stops when its context is done.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors matched by errors.Is against the *Error of a failed request
+var (
+	ErrNotFound         = errors.New("callfs: not found")
+	ErrAlreadyExists    = errors.New("callfs: already exists")
+	ErrNotEmpty         = errors.New("callfs: directory not empty")
+	ErrPermissionDenied = errors.New("callfs: permission denied")
+	ErrUnauthorized     = errors.New("callfs: authentication failed")
+)
+
+// Error is an error response from the API
+type Error struct {
+	StatusCode int    // HTTP status of the response
+	Code       string // Error code, such as FILE_NOT_FOUND
+	Message    string
+	RequestID  string // X-Request-ID of the request, to find it in the server log
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("callfs: HTTP %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("callfs: %s (HTTP %d, %s)", e.Message, e.StatusCode, e.Code)
+}
+
+// Is matches the sentinel errors of this package by status
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrAlreadyExists:
+		return e.StatusCode == http.StatusConflict && e.Code == "FILE_ALREADY_EXISTS"
+	case ErrNotEmpty:
+		return e.StatusCode == http.StatusConflict && e.Code == "DIRECTORY_NOT_EMPTY"
+	case ErrPermissionDenied:
+		return e.StatusCode == http.StatusForbidden
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	}
+	return false
+}
+
+// Client calls the API of a CallFS server. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	apiKey     string
+	httpClient *http.Client
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sends requests with hc, for custom TLS settings or
+// transports. Its Timeout should be zero, as it would cut off long transfers;
+// bound requests with their context instead.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithRetries sets how many times a request failing with a transient error
+// is retried, and the backoff before the first retry, which doubles for each
+// one after it. The default is 3 retries from 200ms; 0 disables retries.
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.minBackoff = backoff
+	}
+}
+
+// New returns a client for the server at baseURL, such as
+// https://callfs.example.com:8443, authenticating with apiKey
+func New(baseURL, apiKey string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q: want http(s)://host[:port]", baseURL)
+	}
+	c := &Client{
+		baseURL:    u,
+		apiKey:     apiKey,
+		httpClient: &http.Client{},
+		maxRetries: 3,
+		minBackoff: 200 * time.Millisecond,
+		maxBackoff: 10 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx whose requests carry id as their
+// X-Request-ID, so the server logs them under it. The server ignores IDs that
+// are longer than 64 characters or not made of letters, digits, '-', '_'
+// and '.'.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// request describes an API call for do
+type request struct {
+	method     string
+	path       string // URL path below the base URL, already escaped
+	query      url.Values
+	header     http.Header
+	body       io.Reader
+	size       int64 // Size of body, or -1 if unknown
+	idempotent bool  // Safe to send again after a failure the server may have acted on
+}
+
+// do sends req, retrying transient failures, and returns the response of a
+// successful request. A body can only be sent again when it is an
+// io.ReadSeeker.
+func (c *Client) do(ctx context.Context, req *request) (*http.Response, error) {
+	var start int64
+	seeker, rewindable := req.body.(io.Seeker)
+	if rewindable {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			rewindable = false
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, req)
+		retryable := c.retryable(req, resp, err)
+		if err == nil && resp.StatusCode < 400 {
+			return resp, nil
+		}
+		if err == nil {
+			err = responseError(resp)
+		}
+		if !retryable || attempt >= c.maxRetries || (req.body != nil && !rewindable) || ctx.Err() != nil {
+			return nil, err
+		}
+
+		wait := c.backoff(attempt, resp)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if rewindable {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return nil, fmt.Errorf("failed to rewind request body: %w", err)
+			}
+		}
+	}
+}
+
+// send makes one attempt at req
+func (c *Client) send(ctx context.Context, req *request) (*http.Response, error) {
+	u := *c.baseURL
+	u.RawPath = c.baseURL.EscapedPath() + req.path
+	u.Path, _ = url.PathUnescape(u.RawPath)
+	u.RawQuery = req.query.Encode()
+
+	body := req.body
+	if body != nil && req.size == 0 {
+		body = http.NoBody
+	} else if body != nil {
+		body = io.NopCloser(body) // The transport must not close a body that may be sent again
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		httpReq.ContentLength = req.size
+	}
+	for name, values := range req.header {
+		httpReq.Header[name] = values
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	if id, _ := ctx.Value(requestIDKey{}).(string); id != "" {
+		httpReq.Header.Set("X-Request-ID", id)
+	}
+	return c.httpClient.Do(httpReq)
+}
+
+// retryable reports whether the outcome of req is worth another attempt:
+// rate limiting, which the server rejects before acting, and for idempotent
+// requests connection failures and unavailable servers
+func (c *Client) retryable(req *request, resp *http.Response, err error) bool {
+	if err != nil {
+		var urlErr *url.Error
+		return req.idempotent && errors.As(err, &urlErr) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return req.idempotent
+	}
+	return false
+}
+
+// backoff is the wait before retry attempt+1: the server's Retry-After, or
+// an exponential backoff with jitter
+func (c *Client) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			return min(time.Duration(seconds)*time.Second, c.maxBackoff)
+		}
+	}
+	wait := min(c.minBackoff<<attempt, c.maxBackoff)
+	return wait/2 + rand.N(wait/2+1)
+}
+
+// responseError reads the error of a failed response and closes it
+func responseError(resp *http.Response) error {
+	defer resp.Body.Close()
+	apiErr := &Error{StatusCode: resp.StatusCode, RequestID: resp.Header.Get("X-Request-ID")}
+	var body struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&body); err == nil {
+		apiErr.Code, apiErr.Message = body.Code, body.Message
+	}
+	return apiErr
+}
+
+// escapePath returns the escaped URL path of the absolute CallFS path p
+func escapePath(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client of a server answering with handler, with
+// retries that do not wait
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	c, err := New(srv.URL+"/", "test-key", WithRetries(2, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestRequestBuilding(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.EscapedPath(); got != "/v1/files/reports/q%3F%201.csv" {
+			t.Errorf("path %q", got)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer test-key" {
+			t.Errorf("Authorization %q", got)
+		}
+		if got := r.Header.Get("X-Request-ID"); got != "req-1" {
+			t.Errorf("X-Request-ID %q", got)
+		}
+		if got := r.Header.Get("Range"); got != "bytes=2-5" {
+			t.Errorf("Range %q", got)
+		}
+		w.WriteHeader(http.StatusPartialContent)
+		io.WriteString(w, "llo ")
+	})
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	r, err := c.OpenRange(ctx, "/reports/q? 1.csv", 2, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if data, _ := io.ReadAll(r); string(data) != "llo " {
+		t.Errorf("read %q", data)
+	}
+}
+
+func TestStat(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("method %s", r.Method)
+		}
+		h := w.Header()
+		h.Set("X-CallFS-Type", "file")
+		h.Set("X-CallFS-Size", "42")
+		h.Set("X-CallFS-Mode", "0640")
+		h.Set("X-CallFS-UID", "1001")
+		h.Set("X-CallFS-MTime", "2024-05-01T10:00:00Z")
+	})
+
+	info, err := c.Stat(context.Background(), "/a/b.txt/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "b.txt" || info.Path != "/a/b.txt" || info.IsDir() || info.Size != 42 || info.Mode != "0640" || info.UID != 1001 ||
+		!info.MTime.Equal(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("stat %+v", info)
+	}
+}
+
+func TestErrorDecoding(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		target error
+	}{
+		{http.StatusNotFound, `{"code":"FILE_NOT_FOUND","message":"file not found"}`, ErrNotFound},
+		{http.StatusConflict, `{"code":"FILE_ALREADY_EXISTS","message":"exists"}`, ErrAlreadyExists},
+		{http.StatusConflict, `{"code":"DIRECTORY_NOT_EMPTY","message":"not empty"}`, ErrNotEmpty},
+		{http.StatusForbidden, `{"code":"PERMISSION_DENIED"}`, ErrPermissionDenied},
+		{http.StatusUnauthorized, `not json`, ErrUnauthorized},
+	}
+	for _, tt := range tests {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Request-ID", "req-2")
+			w.WriteHeader(tt.status)
+			io.WriteString(w, tt.body)
+		})
+		err := c.Remove(context.Background(), "/f")
+		if !errors.Is(err, tt.target) {
+			t.Errorf("HTTP %d %s: err = %v, want %v", tt.status, tt.body, err, tt.target)
+			continue
+		}
+		var apiErr *Error
+		if !errors.As(err, &apiErr) || apiErr.RequestID != "req-2" {
+			t.Errorf("HTTP %d: error %#v without the request ID", tt.status, err)
+		}
+		if tt.target == ErrAlreadyExists && errors.Is(err, ErrNotEmpty) {
+			t.Error("FILE_ALREADY_EXISTS matched ErrNotEmpty")
+		}
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var attempts atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "content" {
+			t.Errorf("attempt %d sent %q", attempts.Load()+1, body)
+		}
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	// An idempotent upload is sent again, from the start of its body
+	if err := c.Upload(context.Background(), "/f", strings.NewReader("content"), 7); err != nil || attempts.Load() != 3 {
+		t.Errorf("upload after %d attempts: %v", attempts.Load(), err)
+	}
+
+	// A rename is not retried when the server may have acted on it
+	attempts.Store(0)
+	c = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	if err := c.Rename(context.Background(), "/a", "/b"); err == nil || attempts.Load() != 1 {
+		t.Errorf("rename after %d attempts: %v", attempts.Load(), err)
+	}
+
+	// Rate limiting is retried for any request, up to the limit
+	attempts.Store(0)
+	c = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	if err := c.Remove(context.Background(), "/f"); err == nil || attempts.Load() != 3 {
+		t.Errorf("remove after %d attempts: %v", attempts.Load(), err)
+	}
+}
@@ -0,0 +1,273 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileInfo describes a file or directory
+type FileInfo struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Type     string    `json:"type"` // "file" or "directory"; "symlink" from Stat of a local symlink
+	Size     int64     `json:"size"`
+	Mode     string    `json:"mode"` // Octal permission bits, such as "0644"
+	UID      int       `json:"uid"`
+	GID      int       `json:"gid"`
+	MTime    time.Time `json:"mtime"`
+	Checksum string    `json:"-"` // "sha256=<hex>" of a file's content when known; set by Stat only
+}
+
+// IsDir reports whether the entry is a directory
+func (fi *FileInfo) IsDir() bool {
+	return fi.Type == "directory"
+}
+
+// Stat returns the metadata of the file or directory at path
+func (c *Client) Stat(ctx context.Context, path string) (*FileInfo, error) {
+	resp, err := c.do(ctx, &request{method: http.MethodHead, path: "/v1/files" + escapePath(path), idempotent: true})
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	h := resp.Header
+	clean := strings.TrimSuffix(path, "/")
+	info := &FileInfo{
+		Name:     clean[strings.LastIndex(clean, "/")+1:],
+		Path:     clean,
+		Type:     h.Get("X-CallFS-Type"),
+		Mode:     h.Get("X-CallFS-Mode"),
+		Checksum: h.Get("X-CallFS-Checksum"),
+	}
+	if info.Path == "" {
+		info.Path = "/"
+	}
+	info.Size, _ = strconv.ParseInt(h.Get("X-CallFS-Size"), 10, 64)
+	info.UID, _ = strconv.Atoi(h.Get("X-CallFS-UID"))
+	info.GID, _ = strconv.Atoi(h.Get("X-CallFS-GID"))
+	info.MTime, _ = time.Parse(time.RFC3339, h.Get("X-CallFS-MTime"))
+	return info, nil
+}
+
+// Open returns the content of the file at path. The caller reads it as it
+// arrives and must close it.
+func (c *Client) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	return c.OpenRange(ctx, path, 0, -1)
+}
+
+// OpenRange returns length bytes of the file at path from offset, or all of
+// them from offset when length is negative. The caller must close it.
+func (c *Client) OpenRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("callfs: negative offset %d", offset)
+	}
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	header := http.Header{}
+	if offset > 0 || length > 0 {
+		byteRange := fmt.Sprintf("bytes=%d-", offset)
+		if length > 0 {
+			byteRange += strconv.FormatInt(offset+length-1, 10)
+		}
+		header.Set("Range", byteRange)
+	}
+	resp, err := c.do(ctx, &request{method: http.MethodGet, path: "/v1/files" + escapePath(path), header: header, idempotent: true})
+	if err != nil {
+		return nil, err
+	}
+
+	// Erasure-coded files are sent whole; skip to the range
+	if resp.StatusCode == http.StatusOK && offset > 0 {
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			if err == io.EOF {
+				return io.NopCloser(strings.NewReader("")), nil
+			}
+			return nil, err
+		}
+	}
+	if resp.StatusCode == http.StatusOK && length > 0 {
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(resp.Body, length), resp.Body}, nil
+	}
+	return resp.Body, nil
+}
+
+// UploadOption sets an attribute stored with an uploaded file
+type UploadOption func(http.Header)
+
+// WithModTime stores t, to the second, as the modification time of the file.
+// Changing it on an existing file needs its owner or an admin key.
+func WithModTime(t time.Time) UploadOption {
+	return func(h http.Header) { h.Set("X-CallFS-MTime", t.UTC().Format(time.RFC3339)) }
+}
+
+// WithMode stores the permission bits of mode on the file
+func WithMode(mode os.FileMode) UploadOption {
+	return func(h http.Header) { h.Set("X-CallFS-Mode", fmt.Sprintf("%04o", mode.Perm())) }
+}
+
+// WithChecksum has the server reject the upload unless its content has the
+// hex SHA-256 digest sha256
+func WithChecksum(sha256 string) UploadOption {
+	return func(h http.Header) { h.Set("X-CallFS-Checksum", "sha256="+sha256) }
+}
+
+func uploadHeader(opts []UploadOption) http.Header {
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	for _, opt := range opts {
+		opt(header)
+	}
+	return header
+}
+
+// Upload stores size bytes read from r as the file at path, replacing any
+// file there. A negative size sends r until EOF. Failed uploads are retried
+// only when r is an io.ReadSeeker.
+func (c *Client) Upload(ctx context.Context, path string, r io.Reader, size int64, opts ...UploadOption) error {
+	if size < 0 {
+		size = -1
+	}
+	resp, err := c.do(ctx, &request{
+		method:     http.MethodPut,
+		path:       "/v1/files" + escapePath(path),
+		header:     uploadHeader(opts),
+		body:       r,
+		size:       size,
+		idempotent: true,
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Writer streams content to a file as it is written. Close completes the
+// upload and returns its result.
+type Writer struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+// Create returns a Writer storing what is written to it as the file at path,
+// replacing any file there when it is closed. The upload is not retried.
+func (c *Client) Create(ctx context.Context, path string, opts ...UploadOption) (*Writer, error) {
+	pr, pw := io.Pipe()
+	w := &Writer{pw: pw, done: make(chan error, 1)}
+	header := uploadHeader(opts)
+	go func() {
+		resp, err := c.do(ctx, &request{
+			method: http.MethodPut,
+			path:   "/v1/files" + escapePath(path),
+			header: header,
+			body:   pr,
+			size:   -1,
+		})
+		if err == nil {
+			resp.Body.Close()
+		}
+		// Unblock writes the server stopped reading
+		pr.CloseWithError(fmt.Errorf("callfs: upload ended: %w", orEOF(err)))
+		w.done <- err
+	}()
+	return w, nil
+}
+
+func orEOF(err error) error {
+	if err == nil {
+		return io.ErrClosedPipe
+	}
+	return err
+}
+
+// Write sends p to the server
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close ends the content and waits for the server to store it
+func (w *Writer) Close() error {
+	w.pw.Close()
+	return <-w.done
+}
+
+// Abort cancels the upload; nothing is stored
+func (w *Writer) Abort() {
+	w.pw.CloseWithError(errors.New("callfs: upload aborted"))
+	<-w.done
+}
+
+// List returns the entries of the directory at path
+func (c *Client) List(ctx context.Context, path string) ([]FileInfo, error) {
+	dir := strings.TrimSuffix(escapePath(path), "/") + "/"
+	resp, err := c.do(ctx, &request{method: http.MethodGet, path: "/v1/directories" + dir, idempotent: true})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var listing struct {
+		Items []FileInfo `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listing); err != nil {
+		return nil, fmt.Errorf("callfs: failed to decode listing: %w", err)
+	}
+	return listing.Items, nil
+}
+
+// Mkdir creates the directory at path, whose parent must exist
+func (c *Client) Mkdir(ctx context.Context, path string) error {
+	return c.mkdir(ctx, path, url.Values{"op": {"mkdir"}}, false)
+}
+
+// MkdirAll creates the directory at path and any missing parents. An
+// existing directory is not an error.
+func (c *Client) MkdirAll(ctx context.Context, path string) error {
+	return c.mkdir(ctx, path, url.Values{"op": {"mkdir"}, "parents": {"true"}}, true)
+}
+
+func (c *Client) mkdir(ctx context.Context, path string, query url.Values, idempotent bool) error {
+	resp, err := c.do(ctx, &request{method: http.MethodPost, path: "/v1/files" + escapePath(path), query: query, idempotent: idempotent})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Remove deletes the file or empty directory at path
+func (c *Client) Remove(ctx context.Context, path string) error {
+	resp, err := c.do(ctx, &request{method: http.MethodDelete, path: "/v1/files" + escapePath(path)})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Rename moves the file or directory at from to to, which must not exist
+func (c *Client) Rename(ctx context.Context, from, to string) error {
+	resp, err := c.do(ctx, &request{
+		method: "MOVE",
+		path:   "/v1/files" + escapePath(from),
+		header: http.Header{"Destination": {escapePath(to)}},
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Link is a single-use download link
+type Link struct {
+	URL     string    `json:"url"` // Downloads the file once, without an API key
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// GenerateLink returns a link that downloads the file at path once, within
+// expiry
+func (c *Client) GenerateLink(ctx context.Context, path string, expiry time.Duration) (*Link, error) {
+	body, err := json.Marshal(map[string]any{"path": path, "expiry_seconds": int(expiry / time.Second)})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, &request{
+		method: http.MethodPost,
+		path:   "/v1/links/generate",
+		header: http.Header{"Content-Type": {"application/json"}},
+		body:   bytes.NewReader(body),
+		size:   int64(len(body)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var link Link
+	if err := json.NewDecoder(resp.Body).Decode(&link); err != nil {
+		return nil, fmt.Errorf("callfs: failed to decode link: %w", err)
+	}
+	return &link, nil
+}
@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package main
+
+import "os"
+
+// notifyDrain does nothing where there is no SIGUSR1; drain the instance
+// through /v1/admin/drain instead
+func notifyDrain(c chan<- os.Signal) {}
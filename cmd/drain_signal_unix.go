@@ -0,0 +1,14 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyDrain relays SIGUSR1, which drains the instance, to c
+func notifyDrain(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1)
+}
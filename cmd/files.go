@@ -0,0 +1,635 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ebogdum/callfs/client"
+)
+
+// File operation commands talk to a running server through the client
+// package, with --endpoint and --api-key or CALLFS_ENDPOINT and CALLFS_API_KEY
+
+var lsCmd = &cobra.Command{
+	Use:   "ls [path...]",
+	Short: "List directories on a CallFS server",
+	RunE:  runLs,
+}
+
+var statCmd = &cobra.Command{
+	Use:   "stat path...",
+	Short: "Show the metadata of files and directories",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  runStat,
+}
+
+var catCmd = &cobra.Command{
+	Use:   "cat path...",
+	Short: "Write files to standard output",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  runCat,
+}
+
+var getCmd = &cobra.Command{
+	Use:   "get remote [local]",
+	Short: "Download a file, or a directory with -r",
+	Long:  "Download remote to local, which defaults to its name in the current directory. A local path of - writes a file to standard output.",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE:  runGet,
+}
+
+var putCmd = &cobra.Command{
+	Use:   "put local remote",
+	Short: "Upload a file, or a directory with -r",
+	Long:  "Upload local to remote, replacing existing files. A remote directory or a path ending in / receives local under its own name. A local path of - uploads standard input.",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runPut,
+}
+
+var cpCmd = &cobra.Command{
+	Use:   "cp src dst",
+	Short: "Copy a file, or a directory with -r, within the server",
+	Long:  "Copy src to dst on the server. The content is streamed through this client. A directory or a path ending in / as dst receives src under its own name.",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runCp,
+}
+
+var mvCmd = &cobra.Command{
+	Use:   "mv src dst",
+	Short: "Move or rename a file or directory",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runMv,
+}
+
+var rmCmd = &cobra.Command{
+	Use:   "rm path...",
+	Short: "Delete files, or directories with -r",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  runRm,
+}
+
+var mkdirCmd = &cobra.Command{
+	Use:   "mkdir path...",
+	Short: "Create directories",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  runMkdir,
+}
+
+var linkCmd = &cobra.Command{
+	Use:   "link",
+	Short: "Single-use download link commands",
+}
+
+var linkCreateCmd = &cobra.Command{
+	Use:   "create path",
+	Short: "Create a single-use download link for a file",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runLinkCreate,
+}
+
+var clientEndpoint string
+var clientAPIKey string
+var clientInsecure bool
+var fileRecursive bool
+var lsLong bool
+var mkdirParents bool
+var linkExpiry time.Duration
+
+// registerFileCommands adds the file operation commands to rootCmd
+func registerFileCommands() {
+	commands := []*cobra.Command{lsCmd, statCmd, catCmd, getCmd, putCmd, cpCmd, mvCmd, rmCmd, mkdirCmd, linkCmd, syncCmd, shellCmd}
+	for _, c := range commands {
+		c.PersistentFlags().StringVar(&clientEndpoint, "endpoint", os.Getenv("CALLFS_ENDPOINT"), "Server API URL (e.g. https://callfs.example.com:8443; default $CALLFS_ENDPOINT)")
+		c.PersistentFlags().StringVar(&clientAPIKey, "api-key", "", "API key (default $CALLFS_API_KEY)")
+		c.PersistentFlags().BoolVarP(&clientInsecure, "insecure", "k", false, "Skip verification of the server's TLS certificate")
+		c.SilenceUsage = true
+		c.SilenceErrors = true // main logs the error
+	}
+	for _, c := range []*cobra.Command{lsCmd, getCmd, putCmd, cpCmd, rmCmd} {
+		c.Flags().BoolVarP(&fileRecursive, "recursive", "r", false, "Include directories and everything below them")
+	}
+	lsCmd.Flags().BoolVarP(&lsLong, "long", "l", false, "Show mode, owner, size and modification time")
+	mkdirCmd.Flags().BoolVarP(&mkdirParents, "parents", "p", false, "Create missing parents; existing directories are not an error")
+	linkCreateCmd.Flags().DurationVar(&linkExpiry, "expiry", time.Hour, "How long the link stays valid")
+	syncCmd.Flags().BoolVar(&syncDownload, "download", false, "Download remote-dir to local-dir, given in that order")
+	syncCmd.Flags().BoolVar(&syncDelete, "delete", false, "Delete files in the destination that are not in the source")
+	syncCmd.Flags().BoolVarP(&syncChecksum, "checksum", "c", false, "Compare files by size and SHA-256 instead of size and modification time")
+	syncCmd.Flags().BoolVarP(&syncDryRun, "dry-run", "n", false, "Show what would change without changing anything")
+	syncCmd.Flags().IntVarP(&syncParallel, "parallel", "j", 4, "Number of files transferred at once")
+	linkCmd.AddCommand(linkCreateCmd)
+	rootCmd.AddCommand(commands...)
+}
+
+// newFileClient returns a client for the configured server, and a context
+// cancelled on interrupt
+func newFileClient() (*client.Client, context.Context, context.CancelFunc, error) {
+	if clientAPIKey == "" {
+		clientAPIKey = os.Getenv("CALLFS_API_KEY")
+	}
+	if clientEndpoint == "" || clientAPIKey == "" {
+		return nil, nil, nil, fmt.Errorf("--endpoint and --api-key (or CALLFS_ENDPOINT and CALLFS_API_KEY) are required")
+	}
+	var opts []client.Option
+	if clientInsecure {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} // #nosec G402 -- requested with --insecure
+		opts = append(opts, client.WithHTTPClient(&http.Client{Transport: transport}))
+	}
+	c, err := client.New(clientEndpoint, clientAPIKey, opts...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	return c, ctx, cancel, nil
+}
+
+// remotePath makes p absolute
+func remotePath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// walkRemote calls fn with every directory below and including dir, and its
+// entries, parents before children
+func walkRemote(ctx context.Context, c *client.Client, dir string, fn func(dir string, entries []client.FileInfo) error) error {
+	entries, err := c.List(ctx, dir)
+	if err != nil {
+		return fmt.Errorf("%s: %w", dir, err)
+	}
+	if err := fn(dir, entries); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if err := walkRemote(ctx, c, entry.Path, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// destination resolves where src is copied to when given dst: into dst when
+// it is an existing directory or ends in /
+func destination(ctx context.Context, c *client.Client, src, dst string) (string, error) {
+	if strings.HasSuffix(dst, "/") {
+		return path.Join(remotePath(dst), path.Base(src)), nil
+	}
+	dst = remotePath(dst)
+	info, err := c.Stat(ctx, dst)
+	if err == nil && info.IsDir() {
+		return path.Join(dst, path.Base(src)), nil
+	}
+	if err != nil && !errors.Is(err, client.ErrNotFound) {
+		return "", err
+	}
+	return dst, nil
+}
+
+func runLs(cmd *cobra.Command, args []string) error {
+	c, ctx, cancel, err := newFileClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	if len(args) == 0 {
+		args = []string{"/"}
+	}
+
+	for i, arg := range args {
+		p := remotePath(arg)
+		info, err := c.Stat(ctx, p)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		if !info.IsDir() {
+			printEntry(*info)
+			continue
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		if !fileRecursive {
+			if len(args) > 1 {
+				fmt.Printf("%s:\n", p)
+			}
+			entries, err := c.List(ctx, p)
+			if err != nil {
+				return fmt.Errorf("%s: %w", p, err)
+			}
+			printEntries(entries)
+			continue
+		}
+		first := true
+		err = walkRemote(ctx, c, p, func(dir string, entries []client.FileInfo) error {
+			if !first {
+				fmt.Println()
+			}
+			first = false
+			fmt.Printf("%s:\n", dir)
+			printEntries(entries)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printEntries(entries []client.FileInfo) {
+	for _, entry := range entries {
+		printEntry(entry)
+	}
+}
+
+func printEntry(entry client.FileInfo) {
+	name := entry.Name
+	if entry.IsDir() {
+		name += "/"
+	}
+	if !lsLong {
+		fmt.Println(name)
+		return
+	}
+	kind := "-"
+	switch entry.Type {
+	case "directory":
+		kind = "d"
+	case "symlink":
+		kind = "l"
+	}
+	fmt.Printf("%s%s %5d %5d %12d %s %s\n", kind, entry.Mode, entry.UID, entry.GID, entry.Size,
+		entry.MTime.Local().Format("2006-01-02 15:04"), name)
+}
+
+func runStat(cmd *cobra.Command, args []string) error {
+	c, ctx, cancel, err := newFileClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	for _, arg := range args {
+		info, err := c.Stat(ctx, remotePath(arg))
+		if err != nil {
+			return fmt.Errorf("%s: %w", arg, err)
+		}
+		fmt.Printf("Path:     %s\nType:     %s\nSize:     %d\nMode:     %s\nOwner:    %d:%d\nModified: %s\n",
+			info.Path, info.Type, info.Size, info.Mode, info.UID, info.GID, info.MTime.Format(time.RFC3339))
+		if info.Checksum != "" {
+			fmt.Printf("Checksum: %s\n", info.Checksum)
+		}
+	}
+	return nil
+}
+
+func runCat(cmd *cobra.Command, args []string) error {
+	c, ctx, cancel, err := newFileClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	for _, arg := range args {
+		r, err := c.Open(ctx, remotePath(arg))
+		if err != nil {
+			return fmt.Errorf("%s: %w", arg, err)
+		}
+		_, err = io.Copy(os.Stdout, r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", arg, err)
+		}
+	}
+	return nil
+}
+
+func runGet(cmd *cobra.Command, args []string) error {
+	c, ctx, cancel, err := newFileClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	src := remotePath(args[0])
+	info, err := c.Stat(ctx, src)
+	if err != nil {
+		return fmt.Errorf("%s: %w", src, err)
+	}
+	dst := path.Base(src)
+	if len(args) == 2 {
+		dst = args[1]
+	}
+	if dst == "-" {
+		if info.IsDir() {
+			return fmt.Errorf("%s is a directory", src)
+		}
+		r, err := c.Open(ctx, src)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		_, err = io.Copy(os.Stdout, r)
+		return err
+	}
+	if local, err := os.Stat(dst); err == nil && local.IsDir() {
+		dst = filepath.Join(dst, path.Base(src))
+	}
+
+	if !info.IsDir() {
+		return download(ctx, c, *info, dst)
+	}
+	if !fileRecursive {
+		return fmt.Errorf("%s is a directory (use -r)", src)
+	}
+	return walkRemote(ctx, c, src, func(dir string, entries []client.FileInfo) error {
+		localDir := filepath.Join(dst, filepath.FromSlash(strings.TrimPrefix(dir, src)))
+		if err := os.MkdirAll(localDir, 0o755); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.Type == "file" {
+				if err := download(ctx, c, entry, filepath.Join(localDir, entry.Name)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// download writes the file of info to local, through a temporary file that
+// replaces it once complete, and keeps its modification time
+func download(ctx context.Context, c *client.Client, info client.FileInfo, local string) error {
+	r, err := c.Open(ctx, info.Path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", info.Path, err)
+	}
+	defer r.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(local), ".callfs-get-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Chmod(0o644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", info.Path, err)
+	}
+	if err := os.Rename(tmp.Name(), local); err != nil {
+		return err
+	}
+	if !info.MTime.IsZero() {
+		_ = os.Chtimes(local, info.MTime, info.MTime)
+	}
+	fmt.Printf("%s -> %s (%d bytes)\n", info.Path, local, n)
+	return nil
+}
+
+func runPut(cmd *cobra.Command, args []string) error {
+	c, ctx, cancel, err := newFileClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	src := args[0]
+	if src == "-" {
+		dst := remotePath(args[1])
+		if err := c.Upload(ctx, dst, os.Stdin, -1); err != nil {
+			return fmt.Errorf("%s: %w", dst, err)
+		}
+		fmt.Printf("- -> %s\n", dst)
+		return nil
+	}
+	local, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	dst, err := destination(ctx, c, filepath.ToSlash(filepath.Clean(src)), args[1])
+	if err != nil {
+		return err
+	}
+
+	if !local.IsDir() {
+		return upload(ctx, c, src, dst)
+	}
+	if !fileRecursive {
+		return fmt.Errorf("%s is a directory (use -r)", src)
+	}
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := path.Join(dst, filepath.ToSlash(rel))
+		switch {
+		case d.IsDir():
+			if err := c.MkdirAll(ctx, target); err != nil {
+				return fmt.Errorf("%s: %w", target, err)
+			}
+		case d.Type().IsRegular():
+			return upload(ctx, c, p, target)
+		}
+		return nil
+	})
+}
+
+// upload stores the local file src at dst
+func upload(ctx context.Context, c *client.Client, src, dst string, opts ...client.UploadOption) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := c.Upload(ctx, dst, f, info.Size(), opts...); err != nil {
+		return fmt.Errorf("%s: %w", dst, err)
+	}
+	fmt.Printf("%s -> %s (%d bytes)\n", src, dst, info.Size())
+	return nil
+}
+
+func runCp(cmd *cobra.Command, args []string) error {
+	c, ctx, cancel, err := newFileClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	src := remotePath(args[0])
+	info, err := c.Stat(ctx, src)
+	if err != nil {
+		return fmt.Errorf("%s: %w", src, err)
+	}
+	dst, err := destination(ctx, c, src, args[1])
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return copyRemote(ctx, c, *info, dst)
+	}
+	if !fileRecursive {
+		return fmt.Errorf("%s is a directory (use -r)", src)
+	}
+	if dst == src || strings.HasPrefix(dst, src+"/") {
+		return fmt.Errorf("cannot copy %s into itself", src)
+	}
+	return walkRemote(ctx, c, src, func(dir string, entries []client.FileInfo) error {
+		target := path.Join(dst, strings.TrimPrefix(dir, src))
+		if err := c.MkdirAll(ctx, target); err != nil {
+			return fmt.Errorf("%s: %w", target, err)
+		}
+		for _, entry := range entries {
+			if entry.Type == "file" {
+				if err := copyRemote(ctx, c, entry, path.Join(target, entry.Name)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// copyRemote streams the file of info to dst
+func copyRemote(ctx context.Context, c *client.Client, info client.FileInfo, dst string) error {
+	r, err := c.Open(ctx, info.Path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", info.Path, err)
+	}
+	defer r.Close()
+	if err := c.Upload(ctx, dst, r, info.Size); err != nil {
+		return fmt.Errorf("%s: %w", dst, err)
+	}
+	fmt.Printf("%s -> %s (%d bytes)\n", info.Path, dst, info.Size)
+	return nil
+}
+
+func runMv(cmd *cobra.Command, args []string) error {
+	c, ctx, cancel, err := newFileClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	src := remotePath(args[0])
+	dst, err := destination(ctx, c, src, args[1])
+	if err != nil {
+		return err
+	}
+	return c.Rename(ctx, src, dst)
+}
+
+func runRm(cmd *cobra.Command, args []string) error {
+	c, ctx, cancel, err := newFileClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	for _, arg := range args {
+		p := remotePath(arg)
+		if p == "/" {
+			return fmt.Errorf("refusing to remove /")
+		}
+		info, err := c.Stat(ctx, p)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		if info.IsDir() && !fileRecursive {
+			return fmt.Errorf("%s is a directory (use -r)", p)
+		}
+		if info.IsDir() {
+			err = removeAll(ctx, c, p)
+		} else {
+			err = c.Remove(ctx, p)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// removeAll deletes dir and everything below it, children first
+func removeAll(ctx context.Context, c *client.Client, dir string) error {
+	entries, err := c.List(ctx, dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			err = removeAll(ctx, c, entry.Path)
+		} else {
+			err = c.Remove(ctx, entry.Path)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", entry.Path, err)
+		}
+	}
+	return c.Remove(ctx, dir)
+}
+
+func runMkdir(cmd *cobra.Command, args []string) error {
+	c, ctx, cancel, err := newFileClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	for _, arg := range args {
+		p := remotePath(arg)
+		if mkdirParents {
+			err = c.MkdirAll(ctx, p)
+		} else {
+			err = c.Mkdir(ctx, p)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func runLinkCreate(cmd *cobra.Command, args []string) error {
+	c, ctx, cancel, err := newFileClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	link, err := c.GenerateLink(ctx, remotePath(args[0]), linkExpiry)
+	if err != nil {
+		return err
+	}
+	fmt.Println(link.URL)
+	fmt.Fprintf(os.Stderr, "Expires %s; the link can be used once\n", link.Expires.Local().Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/config"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/jobs"
+	"github.com/ebogdum/callfs/links"
+	"github.com/ebogdum/callfs/locks"
+	"github.com/ebogdum/callfs/metadata"
+	metadataraft "github.com/ebogdum/callfs/metadata/raft"
+)
+
+// newJobsElector returns the elector of the instance running the leader-only
+// jobs selected by jobs.leader_election
+func newJobsElector(cfg *config.AppConfig, lockManager locks.Manager, raftStore *metadataraft.Store, clustered bool, logger *zap.Logger) jobs.Elector {
+	if cfg.Jobs.LeaderElection == "raft" {
+		return jobs.NewRaftElector(raftStore)
+	}
+	if clustered && strings.EqualFold(cfg.DLM.Type, "local") {
+		logger.Warn("Leader-only background jobs run on every instance: jobs.leader_election=lease needs a dlm shared by the instances, not dlm.type=local")
+	}
+	return jobs.NewLeaseElector(lockManager, logger)
+}
+
+// registerJobs adds the background jobs to scheduler with their configured
+// schedules
+func registerJobs(scheduler *jobs.Scheduler, cfg *config.AppConfig, engine *core.Engine, metadataStore metadata.Store, logger *zap.Logger) error {
+	linkCleanup := cfg.Jobs.LinkCleanup
+	if err := scheduler.Register(jobs.Job{
+		Name:       "link_cleanup",
+		Schedule:   linkCleanup.Schedule,
+		Jitter:     linkCleanup.Jitter,
+		LeaderOnly: linkCleanup.LeaderOnly,
+		Timeout:    linkCleanup.Timeout,
+		Run: func(ctx context.Context) error {
+			return links.CleanupLinks(ctx, metadataStore, logger)
+		},
+	}); err != nil {
+		return err
+	}
+
+	if cfg.GC.Enabled {
+		gc := cfg.Jobs.GC
+		opts := core.GCOptions{GracePeriod: cfg.GC.GracePeriod, DryRun: cfg.GC.DryRun}
+		if err := scheduler.Register(jobs.Job{
+			Name:       "gc",
+			Schedule:   cfg.GCSchedule(),
+			Jitter:     gc.Jitter,
+			LeaderOnly: gc.LeaderOnly,
+			Timeout:    gc.Timeout,
+			Run: func(ctx context.Context) error {
+				return engine.RunGarbageCollection(ctx, opts)
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Lifecycle.Enabled {
+		lifecycle := cfg.Jobs.Lifecycle
+		dryRun := cfg.Lifecycle.DryRun
+		if err := scheduler.Register(jobs.Job{
+			Name:       "lifecycle",
+			Schedule:   lifecycle.Schedule,
+			Jitter:     lifecycle.Jitter,
+			LeaderOnly: lifecycle.LeaderOnly,
+			Timeout:    lifecycle.Timeout,
+			Run: func(ctx context.Context) error {
+				return engine.RunLifecycle(ctx, dryRun)
+			},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lifecycleRules converts the configured lifecycle rules for the engine
+func lifecycleRules(cfg config.LifecycleConfig) []core.LifecycleRule {
+	rules := make([]core.LifecycleRule, 0, len(cfg.Rules))
+	for _, rule := range cfg.Rules {
+		rules = append(rules, core.LifecycleRule{
+			Name:      rule.Name,
+			Prefix:    rule.Prefix,
+			Pattern:   rule.Pattern,
+			OlderThan: rule.OlderThan,
+			Action:    rule.Action,
+		})
+	}
+	return rules
+}
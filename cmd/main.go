@@ -21,15 +21,23 @@ package main
 //	@description				Type "Bearer" followed by a space and JWT token.
 
 import (
+	"bufio"
+	"bytes"
 	"context"
-	"crypto/subtle"
-	"crypto/tls"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
@@ -38,7 +46,10 @@ import (
 	"github.com/quic-go/quic-go/http3"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
 
+	"github.com/ebogdum/callfs"
 	"github.com/ebogdum/callfs/auth"
 	"github.com/ebogdum/callfs/backends"
 	"github.com/ebogdum/callfs/backends/internalproxy"
@@ -47,7 +58,12 @@ import (
 	"github.com/ebogdum/callfs/backends/s3"
 	"github.com/ebogdum/callfs/config"
 	"github.com/ebogdum/callfs/core"
+	corelog "github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/discovery"
 	"github.com/ebogdum/callfs/erasure"
+	"github.com/ebogdum/callfs/hooks"
+	"github.com/ebogdum/callfs/invalidation"
+	"github.com/ebogdum/callfs/jobs"
 	"github.com/ebogdum/callfs/links"
 	"github.com/ebogdum/callfs/locks"
 	"github.com/ebogdum/callfs/metadata"
@@ -56,8 +72,13 @@ import (
 	metadataredis "github.com/ebogdum/callfs/metadata/redis"
 	"github.com/ebogdum/callfs/metadata/schema"
 	metadatasqlite "github.com/ebogdum/callfs/metadata/sqlite"
+	"github.com/ebogdum/callfs/nfs"
+	"github.com/ebogdum/callfs/preview"
+	"github.com/ebogdum/callfs/scan"
+	"github.com/ebogdum/callfs/search"
 	"github.com/ebogdum/callfs/server"
 	"github.com/ebogdum/callfs/server/handlers"
+	authMiddleware "github.com/ebogdum/callfs/server/middleware"
 )
 
 var rootCmd = &cobra.Command{
@@ -90,6 +111,97 @@ var clusterJoinCmd = &cobra.Command{
 	RunE:  runClusterJoin,
 }
 
+var raftCmd = &cobra.Command{
+	Use:   "raft",
+	Short: "Raft cluster membership commands",
+}
+
+var raftMembersCmd = &cobra.Command{
+	Use:   "members",
+	Short: "List voters and learners in the Raft cluster",
+	RunE:  runRaftMembers,
+}
+
+var raftAddVoterCmd = &cobra.Command{
+	Use:   "add-voter",
+	Short: "Add a voting node, or promote a learner",
+	RunE:  runRaftAddServer(false),
+}
+
+var raftAddLearnerCmd = &cobra.Command{
+	Use:   "add-learner",
+	Short: "Add a non-voting learner node",
+	RunE:  runRaftAddServer(true),
+}
+
+var raftRemoveCmd = &cobra.Command{
+	Use:   "remove",
+	Short: "Remove a node from the Raft cluster",
+	RunE:  runRaftRemove,
+}
+
+var raftTransferCmd = &cobra.Command{
+	Use:   "transfer-leadership",
+	Short: "Transfer Raft leadership to another voter",
+	RunE:  runRaftTransferLeadership,
+}
+
+var sqliteCmd = &cobra.Command{
+	Use:   "sqlite",
+	Short: "SQLite metadata store maintenance commands",
+}
+
+var sqliteBackupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Take an online backup of the SQLite metadata database",
+	RunE:  runSQLiteBackup,
+}
+
+var sqliteCheckpointCmd = &cobra.Command{
+	Use:   "checkpoint",
+	Short: "Checkpoint the SQLite write-ahead log",
+	RunE:  runSQLiteCheckpoint,
+}
+
+var metadataCmd = &cobra.Command{
+	Use:   "metadata",
+	Short: "Metadata store export and import commands",
+}
+
+var metadataExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Dump all inodes, links and erasure profiles from the configured metadata store",
+	RunE:  runMetadataExport,
+}
+
+var metadataImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Restore a metadata dump into the configured metadata store",
+	RunE:  runMetadataImport,
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Move every file owned by an instance to another instance or to S3",
+	Long: "Start a migration on the node at --endpoint and follow its progress. With --to local the files move to that node; " +
+		"with --to s3 they move to the S3 backend. Running the same migration again resumes an interrupted one.",
+	RunE: runMigrate,
+}
+
+var adminCmd = &cobra.Command{
+	Use:   "admin",
+	Short: "Administration helpers",
+}
+
+var adminKeygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Generate random API keys and secrets",
+	Long: "Print random keys for auth.api_keys, auth.admin_api_keys, auth.internal_proxy_secret and auth.single_use_link_secret, " +
+		"one per line, as hex from the operating system's secure random source.",
+	Args: cobra.NoArgs,
+	RunE: runAdminKeygen,
+}
+
 var validateCmd = &cobra.Command{
 	Use:   "validate",
 	Short: "Validate configuration",
@@ -97,16 +209,53 @@ var validateCmd = &cobra.Command{
 	RunE:  validateConfig,
 }
 
+var configInitCmd = &cobra.Command{
+	Use:   "init [file]",
+	Short: "Write a commented sample configuration",
+	Long: "Write the commented sample configuration to file, config.yaml by default, or to standard output with -. " +
+		"Its API key and secrets are generated like those of callfs admin keygen. An existing file is kept unless --force is given.",
+	Args: cobra.MaximumNArgs(1),
+	RunE: runConfigInit,
+}
+
+var configPrintCmd = &cobra.Command{
+	Use:   "print",
+	Short: "Print the effective configuration",
+	Long: "Print the configuration in effect after merging the defaults, the config file and the CALLFS_ environment variables, with secrets masked. " +
+		"In YAML, each setting taken from the config file or the environment is followed by a comment saying where it was set.",
+	Args: cobra.NoArgs,
+	RunE: runConfigPrint,
+}
+
 var configFilePath string
+var serverRole string
 var joinLeaderURL string
 var joinNodeID string
 var joinRaftAddr string
 var joinAPIEndpoint string
 var joinInternalSecret string
+var joinLearner bool
+var sqliteEndpoint string
+var sqliteBackupName string
+var sqliteBackupCheckpoint bool
+var sqliteCheckpointMode string
+var metadataDumpFormat string
+var metadataDumpFile string
+var migrateEndpoint string
+var migrateAPIKey string
+var migrateFrom string
+var migrateTo string
+var migrateDetach bool
+var keygenCount int
+var keygenBytes int
+var configInitForce bool
+var configPrintFormat string
 
 func main() {
 	// Add flags to server command
 	serverCmd.Flags().StringVarP(&configFilePath, "config", "c", "", "Path to configuration file")
+	serverCmd.Flags().StringVar(&serverRole, "role", "", "Run as api (no background jobs), worker (background jobs only) or all (overrides server.role)")
+	serverCmd.Flags().BoolVar(&failFast, "fail-fast", false, "Exit at once if the metadata store, lock manager or S3 bucket is unreachable instead of waiting for server.startup_timeout")
 	configCmd.PersistentFlags().StringVarP(&configFilePath, "config", "c", "", "Path to configuration file")
 	clusterCmd.PersistentFlags().StringVarP(&configFilePath, "config", "c", "", "Path to configuration file")
 	clusterJoinCmd.Flags().StringVar(&joinLeaderURL, "leader", "", "Leader API URL (e.g. http://10.0.0.1:8443)")
@@ -114,12 +263,57 @@ func main() {
 	clusterJoinCmd.Flags().StringVar(&joinRaftAddr, "raft-addr", "", "Joining node Raft address (e.g. 10.0.0.2:7000)")
 	clusterJoinCmd.Flags().StringVar(&joinAPIEndpoint, "api-endpoint", "", "Joining node API endpoint (e.g. http://10.0.0.2:8443)")
 	clusterJoinCmd.Flags().StringVar(&joinInternalSecret, "internal-secret", "", "Shared internal proxy secret")
+	clusterJoinCmd.Flags().BoolVar(&joinLearner, "learner", false, "Join as a non-voting learner")
 	_ = clusterJoinCmd.MarkFlagRequired("leader")
 	clusterCmd.AddCommand(clusterJoinCmd)
 
+	raftCmd.PersistentFlags().StringVarP(&configFilePath, "config", "c", "", "Path to configuration file")
+	raftCmd.PersistentFlags().StringVar(&joinLeaderURL, "leader", "", "Leader API URL (any node for members)")
+	raftCmd.PersistentFlags().StringVar(&joinInternalSecret, "internal-secret", "", "Shared internal proxy secret")
+	_ = raftCmd.MarkPersistentFlagRequired("leader")
+	for _, c := range []*cobra.Command{raftAddVoterCmd, raftAddLearnerCmd} {
+		c.Flags().StringVar(&joinNodeID, "node-id", "", "Node ID")
+		c.Flags().StringVar(&joinRaftAddr, "raft-addr", "", "Node Raft address (e.g. 10.0.0.2:7000)")
+		c.Flags().StringVar(&joinAPIEndpoint, "api-endpoint", "", "Node API endpoint (e.g. http://10.0.0.2:8443)")
+		_ = c.MarkFlagRequired("node-id")
+		_ = c.MarkFlagRequired("raft-addr")
+	}
+	raftRemoveCmd.Flags().StringVar(&joinNodeID, "node-id", "", "Node ID to remove")
+	_ = raftRemoveCmd.MarkFlagRequired("node-id")
+	raftTransferCmd.Flags().StringVar(&joinNodeID, "node-id", "", "Target voter (default: most up to date voter)")
+	raftCmd.AddCommand(raftMembersCmd, raftAddVoterCmd, raftAddLearnerCmd, raftRemoveCmd, raftTransferCmd)
+
+	sqliteCmd.PersistentFlags().StringVarP(&configFilePath, "config", "c", "", "Path to configuration file")
+	sqliteCmd.PersistentFlags().StringVar(&sqliteEndpoint, "endpoint", "", "Node API URL (e.g. http://127.0.0.1:8443)")
+	sqliteCmd.PersistentFlags().StringVar(&joinInternalSecret, "internal-secret", "", "Shared internal proxy secret")
+	_ = sqliteCmd.MarkPersistentFlagRequired("endpoint")
+	sqliteBackupCmd.Flags().StringVar(&sqliteBackupName, "name", "", "Backup file name inside metadata_store.sqlite_backup_dir (default: timestamped)")
+	sqliteBackupCmd.Flags().BoolVar(&sqliteBackupCheckpoint, "checkpoint", false, "Truncate the WAL after the backup")
+	sqliteCheckpointCmd.Flags().StringVar(&sqliteCheckpointMode, "mode", "truncate", "Checkpoint mode: passive, full, restart or truncate")
+	sqliteCmd.AddCommand(sqliteBackupCmd, sqliteCheckpointCmd)
+
+	metadataCmd.PersistentFlags().StringVarP(&configFilePath, "config", "c", "", "Path to configuration file")
+	metadataCmd.PersistentFlags().StringVar(&metadataDumpFormat, "format", "jsonl", "Dump format (jsonl)")
+	metadataExportCmd.Flags().StringVarP(&metadataDumpFile, "output", "o", "", "Output file (default: stdout)")
+	metadataImportCmd.Flags().StringVarP(&metadataDumpFile, "input", "i", "", "Input file (default: stdin)")
+	metadataCmd.AddCommand(metadataExportCmd, metadataImportCmd)
+
 	// Add subcommands
-	configCmd.AddCommand(validateCmd)
-	rootCmd.AddCommand(serverCmd, configCmd, clusterCmd)
+	migrateCmd.Flags().StringVar(&migrateEndpoint, "endpoint", "", "API URL of the node that runs the migration (e.g. http://10.0.0.2:8443)")
+	migrateCmd.Flags().StringVar(&migrateAPIKey, "api-key", "", "Admin API key")
+	migrateCmd.Flags().StringVar(&migrateFrom, "from", "", "Instance ID whose files are moved")
+	migrateCmd.Flags().StringVar(&migrateTo, "to", core.MigrationTargetLocal, "Target: local (the --endpoint node) or s3")
+	migrateCmd.Flags().BoolVar(&migrateDetach, "detach", false, "Start the migration and return without following it")
+
+	adminKeygenCmd.Flags().IntVarP(&keygenCount, "count", "n", 1, "Number of keys to generate")
+	adminKeygenCmd.Flags().IntVar(&keygenBytes, "bytes", 32, "Random bytes per key; the key has twice as many hex characters")
+	adminCmd.AddCommand(adminKeygenCmd)
+
+	configInitCmd.Flags().BoolVar(&configInitForce, "force", false, "Overwrite an existing file")
+	configPrintCmd.Flags().StringVar(&configPrintFormat, "format", "yaml", "Output format: yaml or json")
+	configCmd.AddCommand(validateCmd, configInitCmd, configPrintCmd)
+	rootCmd.AddCommand(serverCmd, configCmd, clusterCmd, raftCmd, sqliteCmd, metadataCmd, migrateCmd, adminCmd)
+	registerFileCommands()
 
 	// If no command specified, default to server
 	if len(os.Args) == 1 {
@@ -132,8 +326,10 @@ func main() {
 }
 
 func runClusterJoin(cmd *cobra.Command, args []string) error {
+	signing := auth.SigningRequired
 	cfg, err := config.LoadConfigFromFile(configFilePath)
 	if err == nil {
+		signing = cfg.Auth.InternalRequestSigning
 		if strings.TrimSpace(joinNodeID) == "" {
 			joinNodeID = strings.TrimSpace(cfg.Raft.NodeID)
 		}
@@ -170,6 +366,7 @@ func runClusterJoin(cmd *cobra.Command, args []string) error {
 		NodeID:      joinNodeID,
 		RaftAddr:    joinRaftAddr,
 		APIEndpoint: joinAPIEndpoint,
+		Learner:     joinLearner,
 	}
 	body, err := json.Marshal(payload)
 	if err != nil {
@@ -182,7 +379,9 @@ func runClusterJoin(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to create join request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", joinInternalSecret))
+	if err := auth.NewRequestSigner(joinInternalSecret, signing).Sign(req); err != nil {
+		return fmt.Errorf("failed to sign join request: %w", err)
+	}
 
 	client := &http.Client{Timeout: 15 * time.Second}
 	resp, err := client.Do(req)
@@ -207,38 +406,355 @@ func runClusterJoin(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-// runServer starts the CallFS server
-func runServer(cmd *cobra.Command, args []string) error {
-	// Create context for the entire server lifecycle
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+func runRaftMembers(cmd *cobra.Command, args []string) error {
+	out, err := raftAdminRequest(http.MethodGet, "/v1/internal/raft/members", nil)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%-20s %-24s %-8s %-7s %s\n", "NODE ID", "RAFT ADDRESS", "ROLE", "LEADER", "API ENDPOINT")
+	for _, m := range out.Members {
+		fmt.Printf("%-20s %-24s %-8s %-7t %s\n", m.NodeID, m.RaftAddr, m.Suffrage, m.Leader, m.APIEndpoint)
+	}
+	return nil
+}
 
-	// Load configuration
-	cfg, err := config.LoadConfigFromFile(configFilePath)
+func runRaftAddServer(learner bool) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		out, err := raftAdminRequest(http.MethodPost, "/v1/internal/raft/join", metadataraft.JoinRequest{
+			NodeID:      strings.TrimSpace(joinNodeID),
+			RaftAddr:    strings.TrimSpace(joinRaftAddr),
+			APIEndpoint: strings.TrimSpace(joinAPIEndpoint),
+			Learner:     learner,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Node added: node=%s learner=%t leader=%s\n", joinNodeID, learner, out.LeaderID)
+		return nil
+	}
+}
+
+func runRaftRemove(cmd *cobra.Command, args []string) error {
+	out, err := raftAdminRequest(http.MethodPost, "/v1/internal/raft/remove", metadataraft.MembershipRequest{NodeID: strings.TrimSpace(joinNodeID)})
 	if err != nil {
-		return fmt.Errorf("failed to load configuration: %w", err)
+		return err
 	}
+	fmt.Printf("Node removed: node=%s leader=%s\n", joinNodeID, out.LeaderID)
+	return nil
+}
 
-	// Initialize logger
+func runRaftTransferLeadership(cmd *cobra.Command, args []string) error {
+	out, err := raftAdminRequest(http.MethodPost, "/v1/internal/raft/transfer-leadership", metadataraft.MembershipRequest{NodeID: strings.TrimSpace(joinNodeID)})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Leadership transferred: status=%s\n", out.Status)
+	return nil
+}
+
+func runSQLiteBackup(cmd *cobra.Command, args []string) error {
+	var out metadatasqlite.MaintenanceResponse
+	status, err := internalAdminRequest(sqliteEndpoint, http.MethodPost, "/v1/internal/sqlite/backup", metadatasqlite.BackupRequest{
+		Name:       strings.TrimSpace(sqliteBackupName),
+		Checkpoint: sqliteBackupCheckpoint,
+	}, &out, 0)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("backup failed: %s", out.Error)
+	}
+	fmt.Printf("Backup written: path=%s size=%d\n", out.Path, out.SizeBytes)
+	if out.Checkpoint != nil {
+		fmt.Printf("WAL checkpoint: busy=%t log_frames=%d checkpointed_frames=%d\n", out.Checkpoint.Busy, out.Checkpoint.LogFrames, out.Checkpoint.CheckpointedFrames)
+	}
+	return nil
+}
+
+func runSQLiteCheckpoint(cmd *cobra.Command, args []string) error {
+	var out metadatasqlite.MaintenanceResponse
+	status, err := internalAdminRequest(sqliteEndpoint, http.MethodPost, "/v1/internal/sqlite/checkpoint", metadatasqlite.CheckpointRequest{
+		Mode: strings.TrimSpace(sqliteCheckpointMode),
+	}, &out, 30*time.Second)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK || out.Checkpoint == nil {
+		return fmt.Errorf("checkpoint failed: %s", out.Error)
+	}
+	fmt.Printf("WAL checkpoint: busy=%t log_frames=%d checkpointed_frames=%d\n", out.Checkpoint.Busy, out.Checkpoint.LogFrames, out.Checkpoint.CheckpointedFrames)
+	return nil
+}
+
+func runMetadataExport(cmd *cobra.Command, args []string) error {
+	store, logger, err := openMetadataStoreForDump()
+	if err != nil {
+		return err
+	}
+	defer logger.Sync()
+	defer store.Close()
+
+	out := os.Stdout
+	if metadataDumpFile != "" {
+		// Exclusive create so an existing dump is never truncated
+		out, err = os.OpenFile(metadataDumpFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to create dump file: %w", err)
+		}
+	}
+
+	buf := bufio.NewWriter(out)
+	stats, err := metadata.Export(context.Background(), store, buf)
+	if err == nil {
+		err = buf.Flush()
+	}
+	if metadataDumpFile != "" {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(metadataDumpFile)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("export failed: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d inodes, %d links, %d erasure profiles\n", stats.Inodes, stats.Links, stats.Erasure)
+	return nil
+}
+
+func runMetadataImport(cmd *cobra.Command, args []string) error {
+	in := io.Reader(os.Stdin)
+	if metadataDumpFile != "" {
+		f, err := os.Open(metadataDumpFile)
+		if err != nil {
+			return fmt.Errorf("failed to open dump file: %w", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	store, logger, err := openMetadataStoreForDump()
+	if err != nil {
+		return err
+	}
+	defer logger.Sync()
+	defer store.Close()
+
+	stats, err := metadata.Import(context.Background(), store, bufio.NewReader(in))
+	if err != nil {
+		return fmt.Errorf("import stopped after %d inodes, %d links, %d erasure profiles: %w", stats.Inodes, stats.Links, stats.Erasure, err)
+	}
+	fmt.Fprintf(os.Stderr, "Imported %d inodes, %d links, %d erasure profiles\n", stats.Inodes, stats.Links, stats.Erasure)
+	return nil
+}
+
+// openMetadataStoreForDump validates --format and opens the store named in
+// the config file. Logs go to stderr so a dump can be written to stdout.
+func openMetadataStoreForDump() (metadata.Store, *zap.Logger, error) {
+	if format := strings.ToLower(strings.TrimSpace(metadataDumpFormat)); format != "jsonl" {
+		return nil, nil, fmt.Errorf("unsupported dump format %q (supported: jsonl)", metadataDumpFormat)
+	}
+
+	cfg, err := config.LoadConfigFromFile(configFilePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
 	logger, err := initializeLogger(cfg.Log)
 	if err != nil {
-		return fmt.Errorf("failed to initialize logger: %w", err)
+		return nil, nil, fmt.Errorf("failed to initialize logger: %w", err)
 	}
-	defer func() {
-		if err := logger.Sync(); err != nil {
-			// Log to stderr since logger may not be working
-			fmt.Fprintf(os.Stderr, "Failed to sync logger: %v\n", err)
+	store, err := openMetadataStore(&cfg, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	return store, logger, nil
+}
+
+// internalAdminRequest calls an internal endpoint on baseURL, signed with
+// --internal-secret or auth.internal_proxy_secret from the config file as
+// auth.internal_request_signing says, and decodes the JSON response into
+// out. A zero timeout waits indefinitely.
+func internalAdminRequest(baseURL, method, path string, payload, out any, timeout time.Duration) (int, error) {
+	secret := strings.TrimSpace(joinInternalSecret)
+	signing := auth.SigningRequired
+	if cfg, err := config.LoadConfigFromFile(configFilePath); err == nil {
+		if secret == "" {
+			secret = strings.TrimSpace(cfg.Auth.InternalProxySecret)
 		}
-	}()
+		signing = cfg.Auth.InternalRequestSigning
+	}
+	if secret == "" {
+		return 0, fmt.Errorf("internal secret is required (use --internal-secret or set auth.internal_proxy_secret in config)")
+	}
+	return apiRequest(baseURL, auth.NewRequestSigner(secret, signing).Sign, method, path, payload, out, timeout)
+}
 
-	logger.Info("Starting CallFS server",
-		zap.String("instance_id", cfg.InstanceDiscovery.InstanceID),
-		zap.String("listen_addr", cfg.Server.ListenAddr))
+// apiRequest calls path on baseURL, authenticated by authenticate, and
+// decodes the JSON response into out. A zero timeout waits indefinitely.
+func apiRequest(baseURL string, authenticate func(*http.Request) error, method, path string, payload, out any, timeout time.Duration) (int, error) {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
 
-	// Initialize metadata store
-	logger.Info("Initializing metadata store")
-	var metadataStore metadata.Store
-	var raftMetadataStore *metadataraft.Store
+	url := strings.TrimRight(baseURL, "/") + path
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := authenticate(req); err != nil {
+		return 0, fmt.Errorf("failed to authenticate request: %w", err)
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to contact node: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return resp.StatusCode, fmt.Errorf("failed to decode response (status %d): %w", resp.StatusCode, err)
+	}
+	return resp.StatusCode, nil
+}
+
+func runMigrate(cmd *cobra.Command, args []string) error {
+	if migrateEndpoint == "" || migrateAPIKey == "" || migrateFrom == "" {
+		return fmt.Errorf("--endpoint, --api-key and --from are required")
+	}
+
+	var status core.MigrationStatus
+	var errResp handlers.ErrorResponse
+	code, err := migrateRequest(http.MethodPost, "/v1/admin/migrations", core.MigrationRequest{
+		SourceInstance: strings.TrimSpace(migrateFrom),
+		Target:         strings.TrimSpace(migrateTo),
+	}, &status, &errResp)
+	if err != nil {
+		return err
+	}
+	if code != http.StatusAccepted {
+		return fmt.Errorf("failed to start migration: %s", errResp.Message)
+	}
+	fmt.Printf("Migration %s started: %s -> %s\n", status.ID, status.SourceInstance, migrationTargetName(status))
+	if migrateDetach {
+		return nil
+	}
+
+	// Ctrl-C stops following; the migration keeps running on the node
+	for status.State == core.MigrationRunning {
+		time.Sleep(2 * time.Second)
+		code, err = migrateRequest(http.MethodGet, "/v1/admin/migrations/"+status.ID, nil, &status, &errResp)
+		if err != nil {
+			return err
+		}
+		if code != http.StatusOK {
+			return fmt.Errorf("failed to get migration progress: %s", errResp.Message)
+		}
+		fmt.Printf("%d/%d migrated, %d skipped, %d failed, %d bytes copied\n",
+			status.Migrated, status.Total, status.Skipped, status.Failed, status.BytesCopied)
+	}
+
+	for _, e := range status.Errors {
+		fmt.Printf("  %s\n", e)
+	}
+	if status.State != core.MigrationCompleted {
+		if status.Error != "" {
+			return fmt.Errorf("migration %s: %s", status.State, status.Error)
+		}
+		return fmt.Errorf("migration %s", status.State)
+	}
+	if status.Failed > 0 {
+		return fmt.Errorf("migration completed with %d failed entries; run it again to retry them", status.Failed)
+	}
+	fmt.Println("Migration completed")
+	return nil
+}
+
+func runAdminKeygen(cmd *cobra.Command, args []string) error {
+	if keygenCount < 1 {
+		return fmt.Errorf("--count must be at least 1")
+	}
+	if keygenBytes < 16 {
+		return fmt.Errorf("--bytes must be at least 16")
+	}
+	for range keygenCount {
+		key, err := randomKey(keygenBytes)
+		if err != nil {
+			return err
+		}
+		fmt.Println(key)
+	}
+	return nil
+}
+
+// randomKey returns n random bytes from the secure random source as hex
+func randomKey(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to read random bytes: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// migrateRequest calls the admin migration API with --api-key, decoding a
+// success response into out and an error response into errOut
+func migrateRequest(method, path string, payload, out any, errOut *handlers.ErrorResponse) (int, error) {
+	var raw json.RawMessage
+	bearer := func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer "+migrateAPIKey)
+		return nil
+	}
+	code, err := apiRequest(migrateEndpoint, bearer, method, path, payload, &raw, 30*time.Second)
+	if err != nil {
+		return code, err
+	}
+	if code >= 300 {
+		if err := json.Unmarshal(raw, errOut); err != nil || errOut.Message == "" {
+			errOut.Message = fmt.Sprintf("status %d", code)
+		}
+		return code, nil
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return code, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return code, nil
+}
+
+func migrationTargetName(status core.MigrationStatus) string {
+	if status.TargetInstance != "" {
+		return status.TargetInstance
+	}
+	return status.Target
+}
+
+// raftAdminRequest calls an internal raft endpoint on --leader
+func raftAdminRequest(method, path string, payload any) (*metadataraft.MembershipResponse, error) {
+	var out metadataraft.MembershipResponse
+	status, err := internalAdminRequest(joinLeaderURL, method, path, payload, &out, 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		if out.Error == "not leader" && out.LeaderID != "" {
+			return nil, fmt.Errorf("request failed: not leader (current leader is %s)", out.LeaderID)
+		}
+		if out.Error != "" {
+			return nil, fmt.Errorf("request failed: %s", out.Error)
+		}
+		return nil, fmt.Errorf("request failed with status %d", status)
+	}
+	return &out, nil
+}
+
+// openMetadataStore opens the metadata store selected by metadata_store.type
+func openMetadataStore(cfg *config.AppConfig, logger *zap.Logger) (metadata.Store, error) {
 	metadataStoreType := strings.ToLower(strings.TrimSpace(cfg.MetadataStore.Type))
 	switch metadataStoreType {
 	case "raft":
@@ -262,45 +778,106 @@ func runServer(cmd *cobra.Command, args []string) error {
 			SnapshotInterval:    cfg.Raft.SnapshotInterval,
 			SnapshotThreshold:   cfg.Raft.SnapshotThreshold,
 			RetainSnapshotCount: cfg.Raft.RetainSnapshotCount,
-			InternalAuthToken:   cfg.Auth.InternalProxySecret,
+			InternalSigner:      auth.NewRequestSigner(cfg.Auth.InternalProxySecret, cfg.Auth.InternalRequestSigning),
+			ReadConsistency:     metadata.ReadConsistency(strings.ToLower(cfg.Raft.ReadConsistency)),
 		}, logger)
 		if storeErr != nil {
-			return fmt.Errorf("failed to initialize raft metadata store: %w", storeErr)
+			return nil, fmt.Errorf("failed to initialize raft metadata store: %w", storeErr)
 		}
-		raftMetadataStore = store
-		metadataStore = store
+		return store, nil
 	case "sqlite":
 		store, storeErr := metadatasqlite.NewSQLiteStore(cfg.MetadataStore.SQLitePath, logger)
 		if storeErr != nil {
-			return fmt.Errorf("failed to initialize sqlite metadata store: %w", storeErr)
+			return nil, fmt.Errorf("failed to initialize sqlite metadata store: %w", storeErr)
 		}
-		metadataStore = store
+		return store, nil
 	case "redis":
 		store, storeErr := metadataredis.NewRedisStore(
-			cfg.MetadataStore.RedisAddr,
-			cfg.MetadataStore.RedisPassword,
-			cfg.MetadataStore.RedisDB,
+			cfg.MetadataStore.RedisConn(),
 			cfg.MetadataStore.RedisKeyPrefix,
+			metadataredis.Options{LinkTTL: cfg.MetadataStore.RedisLinkTTL},
 			logger,
 		)
 		if storeErr != nil {
-			return fmt.Errorf("failed to initialize redis metadata store: %w", storeErr)
+			return nil, fmt.Errorf("failed to initialize redis metadata store: %w", storeErr)
 		}
-		metadataStore = store
+		return store, nil
 	case "postgres":
 		logger.Info("Running database migrations")
 		if err := schema.RunMigrations(cfg.MetadataStore.DSN); err != nil {
-			return fmt.Errorf("failed to run database migrations: %w", err)
+			return nil, fmt.Errorf("failed to run database migrations: %w", err)
 		}
 
-		store, storeErr := postgres.NewPostgresStore(cfg.MetadataStore.DSN, logger)
+		store, storeErr := postgres.NewPostgresStore(cfg.MetadataStore.DSN, postgres.Options{
+			MaxOpenConns:    cfg.MetadataStore.MaxOpenConns,
+			MaxIdleConns:    cfg.MetadataStore.MaxIdleConns,
+			ConnMaxLifetime: cfg.MetadataStore.ConnMaxLifetime,
+			ConnMaxIdleTime: cfg.MetadataStore.ConnMaxIdleTime,
+			StatementCache:  cfg.MetadataStore.StatementCache,
+
+			ReplicaDSNs:          cfg.MetadataStore.ReadReplicaDSNs,
+			ReplicaMaxLag:        cfg.MetadataStore.ReplicaMaxLag,
+			ReplicaCheckInterval: cfg.MetadataStore.ReplicaCheckInterval,
+		}, logger)
 		if storeErr != nil {
-			return fmt.Errorf("failed to initialize postgres metadata store: %w", storeErr)
+			return nil, fmt.Errorf("failed to initialize postgres metadata store: %w", storeErr)
 		}
-		metadataStore = store
+		return store, nil
 	default:
-		return fmt.Errorf("unsupported metadata store type: %s", cfg.MetadataStore.Type)
+		return nil, fmt.Errorf("unsupported metadata store type: %s", cfg.MetadataStore.Type)
+	}
+}
+
+// runServer starts the CallFS server
+func runServer(cmd *cobra.Command, args []string) error {
+	// Create context for the entire server lifecycle
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Load configuration
+	cfg, err := config.LoadConfigFromFile(configFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if serverRole != "" {
+		if err := cfg.SetRole(serverRole); err != nil {
+			return fmt.Errorf("invalid --role: %w", err)
+		}
+	}
+
+	// Initialize logger
+	logger, err := initializeLogger(cfg.Log)
+	if err != nil {
+		return fmt.Errorf("failed to initialize logger: %w", err)
 	}
+	defer func() {
+		if err := logger.Sync(); err != nil {
+			// Log to stderr since logger may not be working
+			fmt.Fprintf(os.Stderr, "Failed to sync logger: %v\n", err)
+		}
+	}()
+
+	logger.Info("Starting CallFS server",
+		zap.String("instance_id", cfg.InstanceDiscovery.InstanceID),
+		zap.String("role", cfg.Server.Role),
+		zap.String("listen_addr", cfg.Server.ListenAddr))
+
+	// Initialize metadata store
+	logger.Info("Initializing metadata store")
+	openStore := func() (metadata.Store, error) { return openMetadataStore(&cfg, logger) }
+	var metadataStore metadata.Store
+	switch strings.ToLower(strings.TrimSpace(cfg.MetadataStore.Type)) {
+	case "postgres", "redis":
+		// A store on another host may still be starting; embedded ones are opened once
+		metadataStore, err = waitForDependency(ctx, "metadata_store", cfg.Server, logger, openStore)
+	default:
+		metadataStore, err = openStore()
+	}
+	if err != nil {
+		return err
+	}
+	raftMetadataStore, _ := metadataStore.(*metadataraft.Store)
+	sqliteMetadataStore, _ := metadataStore.(*metadatasqlite.SQLiteStore)
 	defer metadataStore.Close()
 
 	// Initialize distributed lock manager
@@ -309,16 +886,28 @@ func runServer(cmd *cobra.Command, args []string) error {
 	dlmType := strings.ToLower(strings.TrimSpace(cfg.DLM.Type))
 	switch dlmType {
 	case "local":
-		lockManager = locks.NewLocalManager()
+		lockManager = locks.NewLocalManager(cfg.DLM.LockTTL)
 	case "redis":
-		manager, managerErr := locks.NewRedisManager(cfg.DLM.RedisAddr, cfg.DLM.RedisPassword, logger)
+		manager, managerErr := waitForDependency(ctx, "dlm", cfg.Server, logger, func() (*locks.RedisManager, error) {
+			return locks.NewRedisManager(cfg.DLM.RedisConn(), cfg.DLM.LockTTL, logger)
+		})
 		if managerErr != nil {
 			return fmt.Errorf("failed to initialize redis lock manager: %w", managerErr)
 		}
 		lockManager = manager
+	case "redlock":
+		manager, managerErr := waitForDependency(ctx, "dlm", cfg.Server, logger, func() (*locks.RedlockManager, error) {
+			return locks.NewRedlockManager(cfg.DLM.RedlockConns(), cfg.DLM.LockTTL, logger)
+		})
+		if managerErr != nil {
+			return fmt.Errorf("failed to initialize redlock lock manager: %w", managerErr)
+		}
+		lockManager = manager
 	default:
 		return fmt.Errorf("unsupported dlm type: %s", cfg.DLM.Type)
 	}
+	advisoryLocker, _ := lockManager.(locks.AdvisoryLocker)
+	lockManager = locks.WithMetrics(lockManager)
 	defer lockManager.Close()
 
 	// Initialize backend adapters conditionally
@@ -327,8 +916,10 @@ func runServer(cmd *cobra.Command, args []string) error {
 	// Initialize LocalFS backend if root path is configured
 	var localFSBackend backends.Storage
 	if cfg.Backend.LocalFSRootPath != "" {
-		logger.Info("Initializing LocalFS backend", zap.String("root_path", cfg.Backend.LocalFSRootPath))
-		backend, err := localfs.NewLocalFSAdapter(cfg.Backend.LocalFSRootPath)
+		logger.Info("Initializing LocalFS backend",
+			zap.String("root_path", cfg.Backend.LocalFSRootPath),
+			zap.Bool("sync", cfg.Backend.LocalFSSync))
+		backend, err := localfs.NewLocalFSAdapter(cfg.Backend)
 		if err != nil {
 			return fmt.Errorf("failed to initialize LocalFS backend: %w", err)
 		}
@@ -343,7 +934,9 @@ func runServer(cmd *cobra.Command, args []string) error {
 	var s3Backend backends.Storage
 	if cfg.Backend.S3BucketName != "" {
 		logger.Info("Initializing S3 backend", zap.String("bucket", cfg.Backend.S3BucketName))
-		backend, err := s3.NewS3Adapter(cfg.Backend, logger)
+		backend, err := waitForDependency(ctx, "s3", cfg.Server, logger, func() (*s3.S3Adapter, error) {
+			return s3.NewS3Adapter(cfg.Backend, logger)
+		})
 		if err != nil {
 			return fmt.Errorf("failed to initialize S3 backend: %w", err)
 		}
@@ -354,19 +947,43 @@ func runServer(cmd *cobra.Command, args []string) error {
 		s3Backend = noop.NewNoopAdapter()
 	}
 
-	// Initialize internal proxy backend if peer endpoints are configured
+	// Initialize internal proxy backend if peer endpoints are configured or
+	// discovered. Workers take no peer traffic, so they don't announce
+	// themselves to a discovery source.
+	var peerSource discovery.Source
+	if cfg.Server.ServesAPI() {
+		peerSource, err = newPeerDiscoverySource(&cfg, logger)
+		if err != nil {
+			return fmt.Errorf("failed to initialize peer discovery: %w", err)
+		}
+	}
+	if closer, ok := peerSource.(io.Closer); ok {
+		defer closer.Close()
+	}
 	var internalProxyBackend backends.Storage
 	var internalProxyAdapter *internalproxy.InternalProxyAdapter
-	if len(cfg.InstanceDiscovery.PeerEndpoints) > 0 {
+	if len(cfg.InstanceDiscovery.PeerEndpoints) > 0 || peerSource != nil {
 		logger.Info("Initializing internal proxy backend", zap.Int("peer_count", len(cfg.InstanceDiscovery.PeerEndpoints)))
 		adapter, err := internalproxy.NewInternalProxyAdapter(
 			cfg.InstanceDiscovery.PeerEndpoints,
-			cfg.Auth.InternalProxySecret,
-			cfg.Backend.InternalProxySkipTLSVerify,
+			auth.NewRequestSigner(cfg.Auth.InternalProxySecret, cfg.Auth.InternalRequestSigning),
+			internalproxy.ClientOptions{
+				SkipTLSVerify: cfg.Backend.InternalProxySkipTLSVerify,
+				H2C:           cfg.Backend.InternalProxyH2C,
+				Timeout:       cfg.Backend.InternalProxyTimeout,
+				DialTimeout:   cfg.Backend.InternalProxyDialTimeout,
+				HeaderTimeout: cfg.Backend.InternalProxyHeaderTimeout,
+			},
+			internalproxy.HealthOptions{
+				CheckInterval:    cfg.InstanceDiscovery.PeerHealthCheckInterval,
+				FailureThreshold: cfg.InstanceDiscovery.PeerFailureThreshold,
+				OpenDuration:     cfg.InstanceDiscovery.PeerCircuitOpenDuration,
+			},
 			logger)
 		if err != nil {
 			return fmt.Errorf("failed to initialize internal proxy backend: %w", err)
 		}
+		adapter.SetInternalEndpoints(cfg.InstanceDiscovery.InternalPeerEndpoints)
 		internalProxyAdapter = adapter
 		internalProxyBackend = adapter
 		defer internalProxyBackend.Close()
@@ -390,8 +1007,183 @@ func runServer(cmd *cobra.Command, args []string) error {
 		cfg.HA.ReplicationEnabled,
 		cfg.HA.ReplicaBackend,
 		cfg.HA.RequireReplicaSuccess,
+		core.CacheOptions{
+			Enabled:     cfg.MetadataCache.Enabled,
+			TTL:         cfg.MetadataCache.TTL,
+			MaxEntries:  cfg.MetadataCache.MaxEntries,
+			NegativeTTL: cfg.MetadataCache.NegativeTTL,
+		},
 		logger)
 	defer coreEngine.Close()
+	coreEngine.SetPlacementPolicy(cfg.Backend.PlacementPolicy)
+	coreEngine.SetS3Quota(cfg.Backend.S3QuotaBytes)
+	coreEngine.SetBandwidthLimits(core.BandwidthLimits{
+		PerTransfer: cfg.RateLimit.TransferBytesPerSec,
+		LocalFS:     cfg.RateLimit.LocalFSBytesPerSec,
+		S3:          cfg.RateLimit.S3BytesPerSec,
+	})
+	coreEngine.SetWriteLimits(core.WriteLimits{
+		LocalFS:      cfg.RateLimit.LocalFSMaxConcurrentWrites,
+		S3:           cfg.RateLimit.S3MaxConcurrentWrites,
+		Peer:         cfg.RateLimit.PeerMaxConcurrentWrites,
+		QueueTimeout: cfg.RateLimit.WriteQueueTimeout,
+	})
+	coreEngine.SetStreamTuning(core.StreamTuning{
+		BufferSize: cfg.Backend.ReadBufferSize,
+		ReadAhead:  cfg.Backend.ReadAhead,
+	})
+	coreEngine.SetSlowThresholds(core.SlowThresholds{
+		MetadataQuery: cfg.Log.SlowMetadataQuery,
+		BackendOp:     cfg.Log.SlowBackendOp,
+	})
+	if raftMetadataStore != nil {
+		coreEngine.SetReadConsistency(metadata.ReadConsistency(strings.ToLower(cfg.Raft.ReadConsistency)))
+	}
+	coreEngine.SetReadOnly(core.ReadOnlyMode{
+		Instance: cfg.Server.ReadOnly,
+		Prefixes: cfg.Server.ReadOnlyPrefixes,
+	})
+	if cfg.Snapshots.Enabled {
+		coreEngine.SetSnapshotRoot(cfg.Snapshots.Path)
+	}
+	if cfg.Scan.Type != "" {
+		scanner, err := scan.New(cfg.Scan.Type, cfg.Scan.Address, cfg.Scan.Timeout)
+		if err != nil {
+			return fmt.Errorf("failed to configure upload scanning: %w", err)
+		}
+		coreEngine.SetScanner(scanner, core.ScanPolicy{
+			Action:         cfg.Scan.Action,
+			QuarantinePath: cfg.Scan.QuarantinePath,
+			TagXAttr:       cfg.Scan.TagXAttr,
+			FailOpen:       cfg.Scan.FailOpen,
+			SpoolDir:       cfg.Scan.SpoolDir,
+		})
+		logger.Info("Upload scanning enabled",
+			zap.String("scanner", cfg.Scan.Type),
+			zap.String("address", cfg.Scan.Address),
+			zap.String("action", cfg.Scan.Action),
+			zap.Bool("fail_open", cfg.Scan.FailOpen))
+	}
+	if len(cfg.Hooks.Rules) > 0 {
+		rules := make([]hooks.Rule, 0, len(cfg.Hooks.Rules))
+		for _, rc := range cfg.Hooks.Rules {
+			hook, err := hooks.New(hooks.Spec{Type: rc.Type, Command: rc.Command, Env: rc.Env, URL: rc.URL, Options: rc.Options})
+			if err != nil {
+				return fmt.Errorf("failed to configure hook %q: %w", rc.Name, err)
+			}
+			rules = append(rules, hooks.Rule{
+				Name:      rc.Name,
+				Stage:     hooks.Stage(rc.Stage),
+				Prefixes:  rc.Prefixes,
+				Hook:      hook,
+				Timeout:   rc.Timeout,
+				OnFailure: rc.OnFailure,
+				Transform: rc.Transform,
+			})
+			logger.Info("Content hook enabled",
+				zap.String("hook", rc.Name),
+				zap.String("stage", rc.Stage),
+				zap.String("type", rc.Type),
+				zap.Strings("prefixes", rc.Prefixes))
+		}
+		coreEngine.SetHooks(hooks.NewRunner(rules, cfg.Hooks.SpoolDir, cfg.Hooks.PostWriteConcurrency, logger))
+	}
+	if cfg.Preview.Enabled {
+		coreEngine.SetPreviewer(preview.NewRenderer(preview.Options{
+			MaxSourceBytes: cfg.Preview.MaxSourceBytes,
+			MaxPixels:      cfg.Preview.MaxPixels,
+			Quality:        cfg.Preview.Quality,
+			PDFCommand:     cfg.Preview.PDFCommand,
+			PDFTimeout:     cfg.Preview.PDFTimeout,
+		}), cfg.Backend.DefaultBackend, cfg.Preview.Concurrency)
+	}
+	if cfg.Search.Type != "" {
+		index, err := search.New(cfg.Search.Type, search.Options{
+			URL:      cfg.Search.URL,
+			Index:    cfg.Search.Index,
+			Username: cfg.Search.Username,
+			Password: cfg.Search.Password,
+			Timeout:  cfg.Search.Timeout,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to configure search: %w", err)
+		}
+		// An unreachable cluster only delays indexing; reindex once it is back
+		if err := index.Ping(ctx); err != nil {
+			logger.Warn("Search index unreachable at startup", zap.String("url", cfg.Search.URL), zap.Error(err))
+		}
+		coreEngine.SetSearchIndex(index, search.NewExtractor(search.ExtractorOptions{
+			MaxBytes:   cfg.Search.MaxContentBytes,
+			PDFCommand: cfg.Search.PDFCommand,
+			PDFTimeout: cfg.Search.PDFTimeout,
+		}), core.IndexOptions{
+			Prefixes:        cfg.Search.Prefixes,
+			MaxContentBytes: cfg.Search.MaxContentBytes,
+			Concurrency:     cfg.Search.Concurrency,
+		})
+		logger.Info("Search indexing enabled",
+			zap.String("type", cfg.Search.Type),
+			zap.String("url", cfg.Search.URL),
+			zap.String("index", cfg.Search.Index),
+			zap.Strings("prefixes", cfg.Search.Prefixes))
+	}
+	if advisoryLocker != nil {
+		coreEngine.SetAdvisoryLocker(advisoryLocker)
+	}
+	if journal, ok := metadataStore.(metadata.IntentJournal); ok {
+		coreEngine.SetIntentJournal(journal)
+	}
+	if cfg.AccessStats.Enabled {
+		if store, ok := metadataStore.(metadata.AccessStatsStore); ok {
+			coreEngine.SetAccessStats(store, cfg.AccessStats.FlushInterval)
+		} else {
+			logger.Warn("Access statistics are not supported by the metadata store", zap.String("type", cfg.MetadataStore.Type))
+		}
+	}
+
+	// Keep the peer set current while instances come and go
+	if membership, ok := peerSource.(discovery.Membership); ok {
+		coreEngine.SetMembership(membership)
+	}
+	if peerSource != nil {
+		logger.Info("Starting peer discovery",
+			zap.String("source", peerSource.Name()),
+			zap.Duration("interval", cfg.InstanceDiscovery.DiscoveryInterval))
+		watcher := discovery.NewWatcher(peerSource,
+			cfg.InstanceDiscovery.DiscoveryInterval,
+			cfg.InstanceDiscovery.InstanceID,
+			cfg.InstanceDiscovery.PeerEndpoints,
+			func(peers map[string]string) {
+				coreEngine.SetPeerEndpoints(peers)
+				if raftMetadataStore != nil {
+					// Raft forwarding targets /v1/internal/raft, which only an
+					// internal listener serves when one is configured
+					for id, endpoint := range peers {
+						if internal, ok := cfg.InstanceDiscovery.InternalPeerEndpoints[id]; ok {
+							endpoint = internal
+						} else if cfg.Server.InternalListenAddr != "" {
+							continue
+						}
+						raftMetadataStore.SetDiscoveredAPIPeerEndpoint(id, endpoint)
+					}
+				}
+			},
+			logger)
+		watcher.Start(ctx)
+		defer watcher.Close()
+	}
+
+	// Connect the metadata cache to the other instances
+	cacheBus, err := newCacheInvalidationBus(&cfg, logger)
+	if err != nil {
+		return fmt.Errorf("failed to initialize cache invalidation: %w", err)
+	}
+	if cacheBus != nil {
+		defer cacheBus.Close()
+		if err := coreEngine.SetCacheInvalidator(ctx, cacheBus); err != nil {
+			return fmt.Errorf("failed to subscribe to cache invalidations: %w", err)
+		}
+	}
 
 	// Initialize erasure manager if enabled
 	if cfg.Erasure.Enabled {
@@ -421,6 +1213,11 @@ func runServer(cmd *cobra.Command, args []string) error {
 		if cfg.Server.ExternalURL != "" {
 			erasurePeers[cfg.InstanceDiscovery.InstanceID] = cfg.Server.ExternalURL
 		}
+		// Shard traffic targets /v1/internal/*, so prefer internal endpoints when
+		// peers expose a dedicated internal listener.
+		for id, ep := range cfg.InstanceDiscovery.InternalPeerEndpoints {
+			erasurePeers[id] = ep
+		}
 
 		em := erasure.NewManager(
 			erasureMetaStore,
@@ -428,7 +1225,7 @@ func runServer(cmd *cobra.Command, args []string) error {
 			&cfg.Erasure,
 			cfg.InstanceDiscovery.InstanceID,
 			erasurePeers,
-			cfg.Auth.InternalProxySecret,
+			auth.NewRequestSigner(cfg.Auth.InternalProxySecret, cfg.Auth.InternalRequestSigning),
 			logger,
 		)
 		coreEngine.SetErasureManager(em)
@@ -464,55 +1261,158 @@ func runServer(cmd *cobra.Command, args []string) error {
 
 	// Initialize authentication and authorization
 	logger.Info("Initializing authentication and authorization")
-	authenticator := auth.NewAPIKeyAuthenticator(cfg.Auth.APIKeys, cfg.Auth.InternalProxySecret)
-	authorizer := auth.NewUnixAuthorizer(metadataStore)
+	// Peers sign their requests with the internal proxy secrets, which are
+	// accepted as bearer tokens only while signing is not required
+	peerVerifier := auth.NewRequestVerifier(cfg.Auth.InternalProxySecrets(), cfg.Auth.InternalRequestSigning)
+	var bearerSecrets []string
+	if cfg.Auth.InternalRequestSigning != auth.SigningRequired {
+		bearerSecrets = cfg.Auth.InternalProxySecrets()
+	}
+	var authenticator auth.Authenticator = auth.NewAPIKeyAuthenticator(cfg.Auth.APIKeys, cfg.Auth.AdminAPIKeys, bearerSecrets)
+	staticIdentities := make(auth.StaticIdentities, len(cfg.Auth.Identities))
+	for userID, id := range cfg.Auth.Identities {
+		staticIdentities[userID] = auth.Identity{UID: id.UID, GIDs: id.GIDs}
+	}
+	identities := auth.IdentityProviders{staticIdentities}
+	var oidcAuthenticator *auth.OIDCAuthenticator
+	if oidc := cfg.Auth.OIDC; oidc.Issuer != "" {
+		oidcAuthenticator = auth.NewOIDCAuthenticator(auth.OIDCOptions{
+			Issuer:                oidc.Issuer,
+			Audience:              oidc.Audience,
+			ClientID:              oidc.ClientID,
+			ClientSecret:          oidc.ClientSecret,
+			Scopes:                oidc.Scopes,
+			JWKSURL:               oidc.JWKSURL,
+			IntrospectionURL:      oidc.IntrospectionURL,
+			UsernameClaim:         oidc.UsernameClaim,
+			GroupsClaim:           oidc.GroupsClaim,
+			AdminGroups:           oidc.AdminGroups,
+			UIDClaim:              oidc.UIDClaim,
+			GIDClaim:              oidc.GIDClaim,
+			GroupGIDs:             oidc.GroupGIDs,
+			IntrospectionCacheTTL: oidc.IntrospectionCacheTTL,
+			IntrospectionRate:     oidc.IntrospectionRate,
+			Timeout:               oidc.Timeout,
+		})
+		// API keys are checked first, so they keep working when the
+		// provider is unreachable
+		authenticator = auth.Authenticators{authenticator, oidcAuthenticator}
+		identities = append(identities, oidcAuthenticator)
+		logger.Info("OIDC authentication enabled", zap.String("issuer", oidc.Issuer))
+	}
+	var scopedTokens *auth.ScopedTokens
+	if cfg.Auth.ScopedTokenMaxTTL > 0 {
+		scopedTokens = auth.NewScopedTokens(authenticator, cfg.Auth.SingleUseLinkSecret, cfg.Auth.AcceptedSingleUseLinkSecrets, cfg.Auth.ScopedTokenMaxTTL)
+		authenticator = scopedTokens
+	}
+	authorizer := auth.NewUnixAuthorizer(metadataStore, identities)
+	authorizer.SetDirectoryCache(coreEngine)
 
 	// Initialize link manager
 	logger.Info("Initializing link manager")
-	linkManager, err := links.NewLinkManager(metadataStore, cfg.Auth.SingleUseLinkSecret, logger)
+	linkManager, err := links.NewLinkManager(metadataStore, cfg.Auth.SingleUseLinkSecret, cfg.Auth.AcceptedSingleUseLinkSecrets, logger)
 	if err != nil {
 		return fmt.Errorf("failed to initialize link manager: %w", err)
 	}
 
-	// Start background cleanup worker
-	links.StartCleanupWorker(ctx, metadataStore, 5*time.Minute, logger)
+	// Background jobs run on workers, and on API processes unless
+	// server.role=api leaves them to dedicated workers
+	coreEngine.SetHoldPolicy(core.HoldPolicy{
+		MaxOwnerRetention: cfg.Backend.HoldMaxOwnerRetention,
+		Compliance:        cfg.Backend.S3ObjectLockMode == "COMPLIANCE",
+	})
+	coreEngine.SetGCGracePeriod(cfg.GC.GracePeriod)
+	coreEngine.SetLifecycleRules(lifecycleRules(cfg.Lifecycle))
+	clustered := len(cfg.InstanceDiscovery.PeerEndpoints) > 0 || peerSource != nil
+	jobScheduler := jobs.NewScheduler(newJobsElector(&cfg, lockManager, raftMetadataStore, clustered, logger), logger)
+	if cfg.Jobs.LeaderElection == "raft" {
+		// The raft leader leads the jobs, so nodes that run none must not lead
+		raftMetadataStore.RestrictLeadership(ctx, cfg.Server.RunsJobs())
+	}
+	if cfg.Server.RunsJobs() {
+		if err := registerJobs(jobScheduler, &cfg, coreEngine, metadataStore, logger); err != nil {
+			return fmt.Errorf("failed to schedule background jobs: %w", err)
+		}
+		jobScheduler.Start(ctx)
+	}
+
+	// Finish or undo file operations interrupted by the last shutdown. A
+	// worker sharing the instance ID of an API process must not touch the
+	// operations that process has in progress.
+	if cfg.Server.ServesAPI() {
+		go coreEngine.RecoverIntents(ctx)
+	}
+
+	// Clients failing authentication too often are locked out, and requests
+	// rate limited, across the REST and gRPC APIs alike
+	authFailures := authMiddleware.NewAuthFailures(cfg.RateLimit)
+	requestLimiter := authMiddleware.NewRequestLimiter(cfg.RateLimit, logger)
 
 	// Initialize HTTP router
 	logger.Info("Initializing HTTP router")
-	router := server.NewRouter(coreEngine, authenticator, authorizer, linkManager, &cfg.Server, &cfg.Backend, cfg.Server.ExternalURL, logger)
-	rootHandler := http.Handler(router)
+	var rootHandler http.Handler
+	if cfg.Server.ServesAPI() {
+		rootHandler = server.NewRouter(coreEngine, authenticator, oidcAuthenticator, auth.Delegations(cfg.Auth.OnBehalfOf), scopedTokens, peerVerifier, authorizer, linkManager, jobScheduler, &cfg.Server, &cfg.Backend, &cfg.Metrics, authFailures, requestLimiter, &cfg.Preview, &cfg.Log, cfg.Server.ExternalURL, logger)
+	} else {
+		rootHandler = server.NewWorkerRouter(coreEngine, &cfg.Server, logger)
+	}
+
+	// Internal /v1/internal/* endpoints are collected on their own mux so they
+	// can either be merged into the public handler or served from a dedicated
+	// private listener (server.internal_listen_addr).
+	internalMux := http.NewServeMux()
+	hasInternalRoutes := false
 
 	// Register internal shard endpoints if erasure is enabled.
-	// These endpoints are protected by the InternalProxySecret bearer token.
+	// These endpoints accept requests from peers only.
 	if cfg.Erasure.Enabled {
-		mux := http.NewServeMux()
-		mux.Handle("/", rootHandler)
-		mux.HandleFunc("/v1/internal/shards/", recoverMiddleware(logger, func(w http.ResponseWriter, r *http.Request) {
+		hasInternalRoutes = true
+		internalMux.HandleFunc("/v1/internal/shards/", recoverMiddleware(logger, func(w http.ResponseWriter, r *http.Request) {
 			switch r.Method {
 			case http.MethodPut:
-				handlers.InternalStoreShardHandler(localFSBackend, cfg.Auth.InternalProxySecret, logger)(w, r)
+				handlers.InternalStoreShardHandler(localFSBackend, peerVerifier, logger)(w, r)
 			case http.MethodGet:
-				handlers.InternalGetShardHandler(localFSBackend, cfg.Auth.InternalProxySecret, logger)(w, r)
+				handlers.InternalGetShardHandler(localFSBackend, peerVerifier, logger)(w, r)
 			case http.MethodDelete:
-				handlers.InternalDeleteShardHandler(localFSBackend, cfg.Auth.InternalProxySecret, logger)(w, r)
+				handlers.InternalDeleteShardHandler(localFSBackend, peerVerifier, logger)(w, r)
 			default:
 				w.WriteHeader(http.StatusMethodNotAllowed)
 			}
 		}))
-		rootHandler = mux
+	}
+
+	// Peers read this node's free space for capacity-weighted placement
+	if capacityReporter, ok := localFSBackend.(backends.CapacityReporter); ok {
+		hasInternalRoutes = true
+		internalMux.HandleFunc(internalproxy.CapacityPath, recoverMiddleware(logger, handlers.InternalCapacityHandler(capacityReporter, cfg.InstanceDiscovery.InstanceID, peerVerifier, logger)))
+	}
+
+	// Peers read this node's local directories for merged listings
+	hasInternalRoutes = true
+	internalMux.HandleFunc(internalproxy.ListingPath, recoverMiddleware(logger, handlers.InternalListingHandler(coreEngine, peerVerifier, logger)))
+
+	// Peers move this node's local content when renaming a subtree
+	internalMux.HandleFunc(internalproxy.RenamePath, recoverMiddleware(logger, handlers.InternalRenameHandler(coreEngine, peerVerifier, logger)))
+	internalMux.HandleFunc(internalproxy.AttributesPath, recoverMiddleware(logger, handlers.InternalAttributesHandler(coreEngine, peerVerifier, logger)))
+
+	// Peers copy this node's local content when taking a snapshot
+	internalMux.HandleFunc(internalproxy.ClonePath, recoverMiddleware(logger, handlers.InternalCloneHandler(coreEngine, peerVerifier, logger)))
+
+	if sqliteMetadataStore != nil {
+		hasInternalRoutes = true
+		internalMux.HandleFunc("/v1/internal/sqlite/backup", recoverMiddleware(logger, handlers.InternalSQLiteBackupHandler(sqliteMetadataStore, cfg.MetadataStore.SQLiteBackupDir, peerVerifier, logger)))
+		internalMux.HandleFunc("/v1/internal/sqlite/checkpoint", recoverMiddleware(logger, handlers.InternalSQLiteCheckpointHandler(sqliteMetadataStore, peerVerifier, logger)))
 	}
 
 	if raftMetadataStore != nil {
-		mux := http.NewServeMux()
-		mux.Handle("/", rootHandler)
-		mux.HandleFunc("/v1/internal/raft/join", recoverMiddleware(logger, func(w http.ResponseWriter, r *http.Request) {
+		hasInternalRoutes = true
+		internalMux.HandleFunc("/v1/internal/raft/join", recoverMiddleware(logger, func(w http.ResponseWriter, r *http.Request) {
 			if r.Method != http.MethodPost {
 				w.WriteHeader(http.StatusMethodNotAllowed)
 				return
 			}
 
-			authHeader := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer"))
-			if subtle.ConstantTimeCompare([]byte(authHeader), []byte(cfg.Auth.InternalProxySecret)) != 1 {
+			if err := peerVerifier.Verify(r); err != nil {
 				w.WriteHeader(http.StatusUnauthorized)
 				_ = json.NewEncoder(w).Encode(metadataraft.JoinResponse{Status: "error", Error: "unauthorized"})
 				return
@@ -532,7 +1432,11 @@ func runServer(cmd *cobra.Command, args []string) error {
 				return
 			}
 
-			if err := raftMetadataStore.AddVoter(r.Context(), req.NodeID, req.RaftAddr, req.APIEndpoint); err != nil {
+			addServer := raftMetadataStore.AddVoter
+			if req.Learner {
+				addServer = raftMetadataStore.AddLearner
+			}
+			if err := addServer(r.Context(), req.NodeID, req.RaftAddr, req.APIEndpoint); err != nil {
 				status := http.StatusBadGateway
 				if strings.Contains(strings.ToLower(err.Error()), "required") {
 					status = http.StatusBadRequest
@@ -545,14 +1449,17 @@ func runServer(cmd *cobra.Command, args []string) error {
 			w.Header().Set("Content-Type", "application/json")
 			_ = json.NewEncoder(w).Encode(metadataraft.JoinResponse{Status: "joined", LeaderID: raftMetadataStore.LeaderID()})
 		}))
-		mux.HandleFunc("/v1/internal/raft/metadata/apply", recoverMiddleware(logger, func(w http.ResponseWriter, r *http.Request) {
+		internalMux.HandleFunc("/v1/internal/raft/read-index", recoverMiddleware(logger, handlers.InternalRaftReadIndexHandler(raftMetadataStore, peerVerifier, logger)))
+		internalMux.HandleFunc("/v1/internal/raft/members", recoverMiddleware(logger, handlers.InternalRaftMembersHandler(raftMetadataStore, peerVerifier, logger)))
+		internalMux.HandleFunc("/v1/internal/raft/remove", recoverMiddleware(logger, handlers.InternalRaftRemoveHandler(raftMetadataStore, peerVerifier, logger)))
+		internalMux.HandleFunc("/v1/internal/raft/transfer-leadership", recoverMiddleware(logger, handlers.InternalRaftTransferLeadershipHandler(raftMetadataStore, peerVerifier, logger)))
+		internalMux.HandleFunc("/v1/internal/raft/metadata/apply", recoverMiddleware(logger, func(w http.ResponseWriter, r *http.Request) {
 			if r.Method != http.MethodPost {
 				w.WriteHeader(http.StatusMethodNotAllowed)
 				return
 			}
 
-			authHeader2 := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer"))
-			if subtle.ConstantTimeCompare([]byte(authHeader2), []byte(cfg.Auth.InternalProxySecret)) != 1 {
+			if err := peerVerifier.Verify(r); err != nil {
 				w.WriteHeader(http.StatusUnauthorized)
 				_ = json.NewEncoder(w).Encode(metadataraft.ForwardApplyResponse{Error: "unauthorized"})
 				return
@@ -585,25 +1492,56 @@ func runServer(cmd *cobra.Command, args []string) error {
 				logger.Error("Failed to encode raft apply response", zap.Error(err))
 			}
 		}))
-		rootHandler = mux
 	}
 
-	// Create HTTP server
-	srv := &http.Server{
-		Addr:         cfg.Server.ListenAddr,
-		Handler:      rootHandler,
-		ReadTimeout:  cfg.Server.ReadTimeout,
-		WriteTimeout: cfg.Server.WriteTimeout,
-		IdleTimeout:  120 * time.Second,
+	// Peer requests carry the request ID of the call that made them, so their
+	// logs correlate with it
+	internalHandler := authMiddleware.V1RequestIDMiddleware()(internalMux)
+	internalListenAddr := strings.TrimSpace(cfg.Server.InternalListenAddr)
+	if !cfg.Server.ServesAPI() {
+		// Workers serve peers nothing either
+		internalListenAddr = ""
+		hasInternalRoutes = false
+	}
+	if internalListenAddr == "" && hasInternalRoutes {
+		internalMux.Handle("/", rootHandler)
+		rootHandler = internalHandler
 	}
 
+	// Optional Apache-style access log covering both public and internal listeners
+	if cfg.Log.AccessLogPath != "" {
+		accessLogFile, err := corelog.OpenRotatingFile(cfg.Log.AccessLogPath, logRotation(cfg.Log.Rotation))
+		if err != nil {
+			return fmt.Errorf("failed to open access log %s: %w", cfg.Log.AccessLogPath, err)
+		}
+		defer accessLogFile.Close()
+
+		accessLog := authMiddleware.AccessLogMiddleware(accessLogFile)
+		rootHandler = accessLog(rootHandler)
+		internalHandler = accessLog(internalHandler)
+		logger.Info("Access log enabled", zap.String("path", cfg.Log.AccessLogPath))
+	}
+
+	// Read and write timeouts bound stalls rather than whole requests, so
+	// large transfers are not cut off
+	progressDeadlines := authMiddleware.ProgressDeadlineMiddleware(cfg.Server.ReadTimeout, cfg.Server.WriteTimeout)
+	rootHandler = progressDeadlines(rootHandler)
+	internalHandler = progressDeadlines(internalHandler)
+
+	// Create HTTP server
+	srv := newAPIServer(cfg.Server.ListenAddr, rootHandler, &cfg)
+
 	var metricsSrv *http.Server
+	var internalSrv *http.Server
 	var quicSrv *http3.Server
-	serverErrCh := make(chan error, 3)
+	var grpcSrv *grpc.Server
+	var nfsSrv *nfs.Server
+	serverErrCh := make(chan error, 6)
 
 	if cfg.Metrics.ListenAddr != "" {
+		metricsAccess := authMiddleware.MetricsAccessMiddleware(cfg.Metrics, logger)
 		metricsMux := http.NewServeMux()
-		metricsMux.Handle("/metrics", promhttp.Handler())
+		metricsMux.Handle("/metrics", metricsAccess(promhttp.Handler()))
 		metricsSrv = &http.Server{
 			Addr:         cfg.Metrics.ListenAddr,
 			Handler:      metricsMux,
@@ -620,13 +1558,14 @@ func runServer(cmd *cobra.Command, args []string) error {
 		}()
 	}
 
-	if cfg.Server.EnableQUIC {
+	if cfg.Server.EnableQUIC && cfg.Server.ServesAPI() {
+		// QUIC always uses TLS 1.3, whose cipher suites are not configurable
+		quicTLS := cfg.Server.TLS.ServerTLS()
+		quicTLS.NextProtos = []string{"h3"}
 		quicSrv = &http3.Server{
-			Addr:    cfg.Server.QUICListenAddr,
-			Handler: rootHandler,
-			TLSConfig: &tls.Config{
-				NextProtos: []string{"h3"},
-			},
+			Addr:      cfg.Server.QUICListenAddr,
+			Handler:   rootHandler,
+			TLSConfig: quicTLS,
 		}
 
 		go func() {
@@ -639,36 +1578,71 @@ func runServer(cmd *cobra.Command, args []string) error {
 		}()
 	}
 
-	// Start server in a goroutine
-	go func() {
-		protocol := strings.ToLower(cfg.Server.Protocol)
-		if protocol == "" {
-			protocol = "https"
+	if cfg.Server.EnableGRPC && cfg.Server.ServesAPI() {
+		grpcSrv, err = server.NewGRPCServer(coreEngine, authenticator, authorizer, linkManager, &cfg.Server, &cfg.Backend, authFailures, requestLimiter, cfg.Server.ExternalURL, logger)
+		if err != nil {
+			return err
+		}
+		grpcListener, err := net.Listen("tcp", cfg.Server.GRPCListenAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s for gRPC: %w", cfg.Server.GRPCListenAddr, err)
 		}
 
-		switch protocol {
-		case "http":
-			logger.Info("Starting HTTP server", zap.String("addr", cfg.Server.ListenAddr))
-			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				serverErrCh <- fmt.Errorf("HTTP server failed: %w", err)
+		go func() {
+			logger.Info("Starting gRPC server",
+				zap.String("addr", cfg.Server.GRPCListenAddr),
+				zap.String("protocol", "grpc"))
+			if err := grpcSrv.Serve(grpcListener); err != nil {
+				serverErrCh <- fmt.Errorf("gRPC server failed: %w", err)
 			}
-		case "auto":
-			if cfg.Server.CertFile != "" && cfg.Server.KeyFile != "" {
-				logger.Info("Starting HTTPS server (auto mode)", zap.String("addr", cfg.Server.ListenAddr))
-				if err := srv.ListenAndServeTLS(cfg.Server.CertFile, cfg.Server.KeyFile); err != nil && err != http.ErrServerClosed {
-					serverErrCh <- fmt.Errorf("HTTPS server (auto) failed: %w", err)
-				}
-				return
+		}()
+	}
+
+	if cfg.NFS.Enabled && cfg.Server.ServesAPI() {
+		nfsSrv, err = server.NewNFSServer(coreEngine, authorizer, &cfg.Server, &cfg.Backend, cfg.NFS, logger)
+		if err != nil {
+			return err
+		}
+		nfsListener, err := net.Listen("tcp", cfg.NFS.ListenAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s for NFS: %w", cfg.NFS.ListenAddr, err)
+		}
+
+		go func() {
+			logger.Info("Starting NFS server",
+				zap.String("addr", cfg.NFS.ListenAddr),
+				zap.String("user_id", cfg.NFS.User))
+			if err := nfsSrv.Serve(nfsListener); err != nil && !errors.Is(err, nfs.ErrServerClosed) {
+				serverErrCh <- fmt.Errorf("NFS server failed: %w", err)
 			}
+		}()
+	}
+
+	// Start the dedicated internal listener when configured
+	if internalListenAddr != "" {
+		internalSrv = newAPIServer(internalListenAddr, internalHandler, &cfg)
 
-			logger.Info("Starting HTTP server (auto mode fallback)", zap.String("addr", cfg.Server.ListenAddr))
-			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				serverErrCh <- fmt.Errorf("HTTP server (auto) failed: %w", err)
+		go func() {
+			if err := serveHTTP(internalSrv, cfg.Server, "internal", logger); err != nil {
+				serverErrCh <- err
 			}
-		default:
-			logger.Info("Starting HTTPS server", zap.String("addr", cfg.Server.ListenAddr))
-			if err := srv.ListenAndServeTLS(cfg.Server.CertFile, cfg.Server.KeyFile); err != nil && err != http.ErrServerClosed {
-				serverErrCh <- fmt.Errorf("HTTPS server failed: %w", err)
+		}()
+	}
+
+	// Start server in a goroutine
+	go func() {
+		if err := serveHTTP(srv, cfg.Server, "public", logger); err != nil {
+			serverErrCh <- err
+		}
+	}()
+
+	// SIGUSR1 drains the instance ahead of a rolling restart
+	drainSignal := make(chan os.Signal, 1)
+	notifyDrain(drainSignal)
+	go func() {
+		for range drainSignal {
+			if err := coreEngine.Drain(ctx, true); err != nil {
+				logger.Error("Failed to drain instance", zap.Error(err))
 			}
 		}
 	}()
@@ -685,6 +1659,19 @@ func runServer(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	// Load balancers and peers stop sending requests while /readyz fails and
+	// the requests in progress finish
+	if err := coreEngine.Drain(ctx, true); err != nil {
+		logger.Error("Failed to drain instance", zap.Error(err))
+	}
+	if cfg.Server.DrainDelay > 0 {
+		logger.Info("Draining before shutdown", zap.Duration("drain_delay", cfg.Server.DrainDelay))
+		select {
+		case <-time.After(cfg.Server.DrainDelay):
+		case <-quit: // A second signal stops at once
+		}
+	}
+
 	logger.Info("Shutting down server...")
 
 	// Create a deadline for shutdown
@@ -701,10 +1688,19 @@ func runServer(cmd *cobra.Command, args []string) error {
 	// so every server gets a shutdown attempt (prevents leaking QUIC server
 	// when metrics shutdown fails, etc.)
 	var shutdownErr error
+	if internalSrv != nil {
+		if err := internalSrv.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Internal server forced to shutdown", zap.Error(err))
+			shutdownErr = err
+		}
+	}
+
 	if metricsSrv != nil {
 		if err := metricsSrv.Shutdown(shutdownCtx); err != nil {
 			logger.Error("Metrics server forced to shutdown", zap.Error(err))
-			shutdownErr = err
+			if shutdownErr == nil {
+				shutdownErr = err
+			}
 		}
 	}
 
@@ -717,6 +1713,31 @@ func runServer(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	if grpcSrv != nil {
+		// Streams still running when the deadline passes are cut off
+		stopped := make(chan struct{})
+		go func() {
+			grpcSrv.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-shutdownCtx.Done():
+			logger.Error("gRPC server forced to shutdown", zap.Error(shutdownCtx.Err()))
+			grpcSrv.Stop()
+		}
+	}
+
+	if nfsSrv != nil {
+		// Writes held for clients are stored before the engine stops
+		if err := nfsSrv.Shutdown(shutdownCtx); err != nil {
+			logger.Error("NFS server forced to shutdown", zap.Error(err))
+			if shutdownErr == nil {
+				shutdownErr = err
+			}
+		}
+	}
+
 	if shutdownErr != nil {
 		return shutdownErr
 	}
@@ -725,6 +1746,144 @@ func runServer(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// serveHTTP runs srv using the TLS mode selected by server.protocol and blocks
+// until the server stops. A nil error is returned on graceful shutdown.
+func serveHTTP(srv *http.Server, serverCfg config.ServerConfig, name string, logger *zap.Logger) error {
+	protocol := strings.ToLower(serverCfg.Protocol)
+	if protocol == "" {
+		protocol = "https"
+	}
+
+	switch protocol {
+	case "http":
+		logger.Info("Starting HTTP server", zap.String("listener", name), zap.String("addr", srv.Addr))
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			return fmt.Errorf("%s HTTP server failed: %w", name, err)
+		}
+	case "auto":
+		if serverCfg.CertFile != "" && serverCfg.KeyFile != "" {
+			logger.Info("Starting HTTPS server (auto mode)", zap.String("listener", name), zap.String("addr", srv.Addr))
+			if err := srv.ListenAndServeTLS(serverCfg.CertFile, serverCfg.KeyFile); err != nil && err != http.ErrServerClosed {
+				return fmt.Errorf("%s HTTPS server (auto) failed: %w", name, err)
+			}
+			return nil
+		}
+
+		logger.Info("Starting HTTP server (auto mode fallback)", zap.String("listener", name), zap.String("addr", srv.Addr))
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			return fmt.Errorf("%s HTTP server (auto) failed: %w", name, err)
+		}
+	default:
+		logger.Info("Starting HTTPS server", zap.String("listener", name), zap.String("addr", srv.Addr))
+		if err := srv.ListenAndServeTLS(serverCfg.CertFile, serverCfg.KeyFile); err != nil && err != http.ErrServerClosed {
+			return fmt.Errorf("%s HTTPS server failed: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// newAPIServer returns an API listener. Its handler applies the read and
+// write timeouts, so the server only bounds headers and idle connections.
+func newAPIServer(addr string, handler http.Handler, cfg *config.AppConfig) *http.Server {
+	h2 := cfg.Server.HTTP2
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		MaxHeaderBytes:    cfg.Server.MaxHeaderBytes,
+		TLSConfig:         cfg.Server.TLS.ServerTLS(),
+		Protocols:         serverProtocols(cfg),
+		HTTP2: &http.HTTP2Config{
+			MaxConcurrentStreams:          h2.MaxConcurrentStreams,
+			MaxReceiveBufferPerStream:     h2.MaxReceiveBufferPerStream,
+			MaxReceiveBufferPerConnection: h2.MaxReceiveBufferPerConnection,
+			SendPingTimeout:               h2.PingInterval,
+		},
+	}
+}
+
+// serverProtocols returns the protocols of the API listeners: the defaults,
+// plus unencrypted HTTP/2 when instances speak it to each other
+func serverProtocols(cfg *config.AppConfig) *http.Protocols {
+	if !cfg.Backend.InternalProxyH2C {
+		return nil
+	}
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	return protocols
+}
+
+// newCacheInvalidationBus creates the cross-instance cache invalidation bus
+// selected by metadata_cache.invalidation, or nil when it is disabled
+func newCacheInvalidationBus(cfg *config.AppConfig, logger *zap.Logger) (invalidation.Bus, error) {
+	mode := strings.ToLower(strings.TrimSpace(cfg.MetadataCache.Invalidation))
+	storeType := strings.ToLower(strings.TrimSpace(cfg.MetadataStore.Type))
+	dlmType := strings.ToLower(strings.TrimSpace(cfg.DLM.Type))
+
+	if mode == "" || mode == "auto" {
+		switch {
+		case storeType == "postgres":
+			mode = "postgres"
+		case storeType == "redis" || dlmType == "redis":
+			mode = "redis"
+		default:
+			mode = "none"
+		}
+	}
+
+	channel := cfg.MetadataCache.InvalidationChannel
+	switch mode {
+	case "postgres":
+		logger.Info("Using PostgreSQL LISTEN/NOTIFY for cache invalidation", zap.String("channel", channel))
+		return invalidation.NewPostgresBus(cfg.MetadataStore.DSN, channel, logger)
+	case "redis":
+		logger.Info("Using Redis pub/sub for cache invalidation", zap.String("channel", channel))
+		if storeType == "redis" {
+			return invalidation.NewRedisBus(cfg.MetadataStore.RedisConn(), channel, logger)
+		}
+		return invalidation.NewRedisBus(cfg.DLM.RedisConn(), channel, logger)
+	default:
+		logger.Info("Cross-instance cache invalidation disabled")
+		return nil, nil
+	}
+}
+
+// newPeerDiscoverySource returns the configured peer discovery source, or nil
+// when peers are only configured statically
+func newPeerDiscoverySource(cfg *config.AppConfig, logger *zap.Logger) (discovery.Source, error) {
+	d := cfg.InstanceDiscovery
+	switch d.DiscoveryType {
+	case "gossip":
+		var key []byte
+		if d.GossipSecretKey != "" {
+			var err error
+			if key, err = base64.StdEncoding.DecodeString(d.GossipSecretKey); err != nil {
+				return nil, fmt.Errorf("invalid gossip secret key: %w", err)
+			}
+		}
+		return discovery.NewGossipSource(discovery.GossipOptions{
+			InstanceID:    d.InstanceID,
+			Endpoint:      cfg.Server.ExternalURL,
+			BindAddr:      d.GossipBindAddr,
+			AdvertiseAddr: d.GossipAdvertiseAddr,
+			Join:          d.GossipJoin,
+			SecretKey:     key,
+		}, logger)
+	case "dns":
+		return discovery.NewDNSSource(d.DNSSRVName, d.DiscoveryScheme), nil
+	case "consul":
+		return discovery.NewConsulSource(d.ConsulAddr, d.ConsulService, d.ConsulTag, d.ConsulToken, d.DiscoveryScheme), nil
+	case "kubernetes":
+		return discovery.NewKubernetesSource(d.KubernetesNamespace, d.KubernetesService, d.KubernetesPortName, d.DiscoveryScheme)
+	default:
+		return nil, nil
+	}
+}
+
 // validateConfig validates the CallFS configuration and displays settings
 func validateConfig(cmd *cobra.Command, args []string) error {
 	fmt.Println("Validating configuration...")
@@ -737,7 +1896,11 @@ func validateConfig(cmd *cobra.Command, args []string) error {
 
 	fmt.Println("Configuration is valid")
 	fmt.Printf("Instance ID: %s\n", cfg.InstanceDiscovery.InstanceID)
+	fmt.Printf("Role: %s\n", cfg.Server.Role)
 	fmt.Printf("Listen Address: %s\n", cfg.Server.ListenAddr)
+	if cfg.Server.InternalListenAddr != "" {
+		fmt.Printf("Internal Listen Address: %s\n", cfg.Server.InternalListenAddr)
+	}
 	fmt.Printf("Metadata Store DSN: %s\n", maskDSN(cfg.MetadataStore.DSN))
 	fmt.Printf("Redis Address: %s\n", cfg.DLM.RedisAddr)
 	fmt.Printf("Local FS Root: %s\n", cfg.Backend.LocalFSRootPath)
@@ -749,6 +1912,63 @@ func validateConfig(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// sampleConfigSecrets are the placeholders of the sample configuration that
+// config init replaces with generated keys
+var sampleConfigSecrets = []string{"your-api-key-here", "your-internal-secret-here", "your-link-secret-here"}
+
+func runConfigInit(cmd *cobra.Command, args []string) error {
+	target := "config.yaml"
+	if len(args) > 0 {
+		target = args[0]
+	}
+	if ext := strings.ToLower(filepath.Ext(target)); target != "-" && ext != ".yaml" && ext != ".yml" {
+		return fmt.Errorf("the sample configuration is YAML; name the file .yaml or .yml")
+	}
+	sample := string(callfs.SampleConfig)
+	for _, placeholder := range sampleConfigSecrets {
+		key, err := randomKey(32)
+		if err != nil {
+			return err
+		}
+		sample = strings.ReplaceAll(sample, placeholder, key)
+	}
+
+	if target == "-" {
+		_, err := os.Stdout.WriteString(sample)
+		return err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if configInitForce {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	// The file holds secrets, so only its owner may read it
+	f, err := os.OpenFile(target, flags, 0o600)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%s already exists; use --force to overwrite it", target)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(sample); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s; edit it, then check it with callfs config validate -c %s\n", target, target)
+	return nil
+}
+
+func runConfigPrint(cmd *cobra.Command, args []string) error {
+	data, err := config.MarshalEffective(configFilePath, strings.ToLower(configPrintFormat))
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
 // maskDSN masks sensitive parts of the database DSN for display
 func maskDSN(dsn string) string {
 	if dsn == "" {
@@ -779,11 +1999,14 @@ func recoverMiddleware(logger *zap.Logger, next http.HandlerFunc) http.HandlerFu
 // initializeLogger creates a zap logger based on configuration
 func initializeLogger(logCfg config.LogConfig) (*zap.Logger, error) {
 	var cfg zap.Config
+	var encoder func(zapcore.EncoderConfig) zapcore.Encoder
 
 	if logCfg.Format == "json" {
 		cfg = zap.NewProductionConfig()
+		encoder = zapcore.NewJSONEncoder
 	} else {
 		cfg = zap.NewDevelopmentConfig()
+		encoder = zapcore.NewConsoleEncoder
 	}
 
 	// Set log level
@@ -800,5 +2023,43 @@ func initializeLogger(logCfg config.LogConfig) (*zap.Logger, error) {
 		cfg.Level = zap.NewAtomicLevelAt(zap.InfoLevel)
 	}
 
-	return cfg.Build()
+	output := logCfg.Output
+	if output == "" {
+		output = corelog.OutputStderr
+	}
+	rotation := logRotation(logCfg.Rotation)
+	core, err := corelog.NewCore(output, encoder(cfg.EncoderConfig), cfg.Level, rotation)
+	if err != nil {
+		return nil, err
+	}
+	// Error entries are also routed to their own output
+	if logCfg.ErrorOutput != "" {
+		errorCore, err := corelog.NewCore(logCfg.ErrorOutput, encoder(cfg.EncoderConfig), zap.ErrorLevel, rotation)
+		if err != nil {
+			return nil, err
+		}
+		core = zapcore.NewTee(core, errorCore)
+	}
+	if cfg.Sampling != nil {
+		core = zapcore.NewSamplerWithOptions(core, time.Second, cfg.Sampling.Initial, cfg.Sampling.Thereafter)
+	}
+
+	opts := []zap.Option{zap.AddCaller(), zap.ErrorOutput(zapcore.Lock(os.Stderr))}
+	if cfg.Development {
+		opts = append(opts, zap.Development(), zap.AddStacktrace(zap.WarnLevel))
+	} else {
+		opts = append(opts, zap.AddStacktrace(zap.ErrorLevel))
+	}
+	return zap.New(core, opts...), nil
+}
+
+// logRotation returns the rotation of log files configured by cfg
+func logRotation(cfg config.LogRotationConfig) corelog.RotationOptions {
+	return corelog.RotationOptions{
+		MaxBytes:   cfg.MaxBytes,
+		Interval:   cfg.Interval,
+		MaxBackups: cfg.MaxBackups,
+		MaxAge:     cfg.MaxAge,
+		Compress:   cfg.Compress,
+	}
 }
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/term"
+
+	"github.com/ebogdum/callfs/client"
+)
+
+var shellCmd = &cobra.Command{
+	Use:   "shell [dir]",
+	Short: "Browse the server interactively",
+	Long: "Start a prompt that runs the file commands relative to a current directory, which cd changes. " +
+		"Tab completes command names and paths. When standard input is not a terminal, commands are read from it one per line and the first failure ends the shell.",
+	Args: cobra.MaximumNArgs(1),
+	RunE: runShell,
+}
+
+// shellCommands are the file commands the shell runs, by name
+var shellCommands = map[string]*cobra.Command{
+	"ls":    lsCmd,
+	"stat":  statCmd,
+	"cat":   catCmd,
+	"get":   getCmd,
+	"put":   putCmd,
+	"cp":    cpCmd,
+	"mv":    mvCmd,
+	"rm":    rmCmd,
+	"mkdir": mkdirCmd,
+	"ln":    linkCreateCmd,
+	"sync":  syncCmd,
+}
+
+// shellBuiltins are the commands the shell handles itself
+var shellBuiltins = map[string]string{
+	"cd":   "Change the current directory; - returns to the previous one",
+	"pwd":  "Print the current directory",
+	"lcd":  "Change the local directory used by get, put and sync",
+	"lpwd": "Print the local directory",
+	"help": "List the commands; <command> -h describes one",
+	"exit": "Leave the shell",
+}
+
+// shell is the state of an interactive session
+type shell struct {
+	c        *client.Client
+	cwd      string
+	prevDir  string
+	term     *term.Terminal               // nil when reading commands from a pipe
+	listings map[string][]client.FileInfo // Directories listed for completion, until the next command
+	tabs     int                          // Consecutive presses of Tab
+}
+
+func runShell(cmd *cobra.Command, args []string) error {
+	c, _, stop, err := newFileClient()
+	if err != nil {
+		return err
+	}
+	stop() // Each command handles interrupts itself
+
+	s := &shell{c: c, cwd: "/", prevDir: "/", listings: map[string][]client.FileInfo{}}
+	if len(args) == 1 {
+		if err := s.cd(args[0]); err != nil {
+			return err
+		}
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return s.runBatch(os.Stdin)
+	}
+	return s.runInteractive(fd)
+}
+
+// runInteractive reads commands with line editing, history and completion.
+// The terminal is in raw mode only while a line is read, so commands print
+// and are interrupted as usual.
+func (s *shell) runInteractive(fd int) error {
+	s.term = term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, "")
+	s.term.AutoCompleteCallback = s.complete
+
+	for {
+		if width, height, err := term.GetSize(fd); err == nil && width > 0 {
+			_ = s.term.SetSize(width, height)
+		}
+		s.term.SetPrompt(fmt.Sprintf("callfs:%s> ", s.cwd))
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		line, err := s.term.ReadLine()
+		_ = term.Restore(fd, state)
+		if err == io.EOF {
+			fmt.Println()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := s.exec(line); errors.Is(err, errShellExit) {
+			return nil
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+	}
+}
+
+// runBatch runs the commands of a script, stopping at the first failure
+func (s *shell) runBatch(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if err := s.exec(scanner.Text()); errors.Is(err, errShellExit) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+var errShellExit = errors.New("exit")
+
+// exec runs one command line
+func (s *shell) exec(line string) error {
+	clear(s.listings)
+	words, err := splitWords(line)
+	if err != nil {
+		return err
+	}
+	if len(words) == 0 || strings.HasPrefix(words[0], "#") {
+		return nil
+	}
+	name, args := words[0], words[1:]
+
+	switch name {
+	case "exit", "quit":
+		return errShellExit
+	case "help", "?":
+		s.help()
+		return nil
+	case "pwd":
+		fmt.Println(s.cwd)
+		return nil
+	case "cd":
+		dir := "/"
+		if len(args) > 0 {
+			dir = args[0]
+		}
+		return s.cd(dir)
+	case "lcd":
+		dir, err := os.UserHomeDir()
+		if len(args) > 0 {
+			dir, err = args[0], nil
+		}
+		if err != nil {
+			return err
+		}
+		return os.Chdir(dir)
+	case "lpwd":
+		dir, err := os.Getwd()
+		if err == nil {
+			fmt.Println(dir)
+		}
+		return err
+	}
+
+	cmd, ok := shellCommands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q (try help)", name)
+	}
+	return s.runFileCommand(name, cmd, args)
+}
+
+// runFileCommand runs a file command with its remote paths resolved against
+// the current directory. Its flags start from their defaults every time.
+func (s *shell) runFileCommand(name string, cmd *cobra.Command, words []string) error {
+	cmd.InitDefaultHelpFlag()
+	cmd.LocalNonPersistentFlags().VisitAll(func(f *pflag.Flag) {
+		_ = f.Value.Set(f.DefValue)
+		f.Changed = false
+	})
+	if err := cmd.ParseFlags(words); err != nil {
+		return err
+	}
+	if help, _ := cmd.Flags().GetBool("help"); help {
+		return cmd.Help()
+	}
+
+	args := cmd.Flags().Args()
+	if name == "ls" && len(args) == 0 {
+		args = []string{s.cwd}
+	}
+	for i, arg := range args {
+		if isRemoteArg(name, i, syncDownload) {
+			args[i] = s.resolve(arg)
+		}
+	}
+	if err := cmd.ValidateArgs(args); err != nil {
+		return err
+	}
+	return cmd.RunE(cmd, args)
+}
+
+// isRemoteArg reports whether argument i of a shell command is a path on the
+// server
+func isRemoteArg(name string, i int, download bool) bool {
+	switch name {
+	case "get", "ln":
+		return i == 0
+	case "put":
+		return i == 1
+	case "sync":
+		return (i == 0) == download
+	case "lcd":
+		return false
+	}
+	return true
+}
+
+// resolve makes p absolute against the current directory, keeping a trailing
+// slash, which put and cp give a meaning
+func (s *shell) resolve(p string) string {
+	abs := path.Join(s.cwd, p)
+	if strings.HasPrefix(p, "/") {
+		abs = path.Clean(p)
+	}
+	if strings.HasSuffix(p, "/") && abs != "/" {
+		abs += "/"
+	}
+	return abs
+}
+
+func (s *shell) cd(dir string) error {
+	if dir == "-" {
+		dir = s.prevDir
+	}
+	dir = path.Clean(s.resolve(dir))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	info, err := s.c.Stat(ctx, dir)
+	if err != nil {
+		return fmt.Errorf("%s: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	s.prevDir, s.cwd = s.cwd, dir
+	return nil
+}
+
+func (s *shell) help() {
+	lines := make([]string, 0, len(shellCommands)+len(shellBuiltins))
+	for name, cmd := range shellCommands {
+		lines = append(lines, fmt.Sprintf("  %-6s %s", name, cmd.Short))
+	}
+	for name, short := range shellBuiltins {
+		lines = append(lines, fmt.Sprintf("  %-6s %s", name, short))
+	}
+	sort.Strings(lines)
+	fmt.Println("Commands:")
+	fmt.Println(strings.Join(lines, "\n"))
+	fmt.Println("Relative paths on the server start from the current directory; local paths from the local one.")
+}
+
+// complete is the terminal's completion callback. Tab extends the word
+// before the cursor to the longest prefix its candidates share, and lists
+// them when pressed again without progress.
+func (s *shell) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		s.tabs = 0
+		return "", 0, false
+	}
+	s.tabs++
+
+	head := line[:pos]
+	start := wordStart(head)
+	words, _ := splitWords(head[:start])
+	word, err := splitWords(head[start:])
+	if err != nil || len(word) > 1 {
+		return line, pos, true
+	}
+	partial := ""
+	if len(word) == 1 {
+		partial = word[0]
+	}
+
+	var dirPart string
+	var matches []string
+	if len(words) == 0 {
+		for name := range shellCommands {
+			matches = append(matches, name)
+		}
+		for name := range shellBuiltins {
+			matches = append(matches, name)
+		}
+		matches = filterPrefix(matches, partial)
+	} else {
+		dirPart = partial[:strings.LastIndex(partial, "/")+1]
+		arg := 0
+		download := false
+		for _, w := range words[1:] {
+			if w == "--download" {
+				download = true
+			}
+			if !strings.HasPrefix(w, "-") {
+				arg++
+			}
+		}
+		dirsOnly := words[0] == "cd" || words[0] == "lcd"
+		if isRemoteArg(words[0], arg, download) {
+			matches = s.remoteNames(dirPart, dirsOnly)
+		} else {
+			matches = localNames(dirPart, dirsOnly)
+		}
+		matches = filterPrefix(matches, partial[len(dirPart):])
+	}
+	if len(matches) == 0 {
+		return line, pos, true
+	}
+	sort.Strings(matches)
+
+	completed := dirPart + commonPrefix(matches)
+	if len(matches) > 1 && completed == partial {
+		if s.tabs > 1 {
+			fmt.Fprintln(s.term, strings.Join(matches, "  "))
+		}
+		return line, pos, true
+	}
+	replacement := escapeWord(completed)
+	if len(matches) == 1 && !strings.HasSuffix(completed, "/") {
+		replacement += " "
+	}
+	return head[:start] + replacement + line[pos:], start + len(replacement), true
+}
+
+// remoteNames returns the entries of the server directory dirPart, with
+// directories ending in /
+func (s *shell) remoteNames(dirPart string, dirsOnly bool) []string {
+	dir := path.Clean(s.resolve(dirPart + "."))
+	entries, ok := s.listings[dir]
+	if !ok {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		entries, _ = s.c.List(ctx, dir)
+		s.listings[dir] = entries
+	}
+	var names []string
+	for _, entry := range entries {
+		switch {
+		case entry.IsDir():
+			names = append(names, entry.Name+"/")
+		case !dirsOnly:
+			names = append(names, entry.Name)
+		}
+	}
+	return names
+}
+
+// localNames returns the entries of the local directory dirPart, with
+// directories ending in /
+func localNames(dirPart string, dirsOnly bool) []string {
+	dir := dirPart
+	if dir == "" {
+		dir = "."
+	}
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, entry := range entries {
+		isDir := entry.IsDir()
+		if entry.Type()&os.ModeSymlink != 0 {
+			info, err := os.Stat(dir + "/" + entry.Name())
+			isDir = err == nil && info.IsDir()
+		}
+		switch {
+		case isDir:
+			names = append(names, entry.Name()+"/")
+		case !dirsOnly:
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+func filterPrefix(names []string, prefix string) []string {
+	var matches []string
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			matches = append(matches, name)
+		}
+	}
+	return matches
+}
+
+func commonPrefix(names []string) string {
+	prefix := names[0]
+	for _, name := range names[1:] {
+		for !strings.HasPrefix(name, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// splitWords splits a command line at spaces, honoring single and double
+// quotes and backslash escapes
+func splitWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// wordStart returns where the last word of head begins
+func wordStart(head string) int {
+	start := 0
+	for i := 0; i < len(head); i++ {
+		switch head[i] {
+		case '\\':
+			i++
+		case ' ':
+			start = i + 1
+		}
+	}
+	return start
+}
+
+// escapeWord escapes the characters splitWords treats specially
+func escapeWord(word string) string {
+	var b strings.Builder
+	for _, r := range word {
+		if strings.ContainsRune(" \t\\'\"", r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/config"
+)
+
+// maxStartupBackoff caps the wait between attempts to reach a dependency
+const maxStartupBackoff = 30 * time.Second
+
+// failFast makes the server exit when a dependency is unreachable at startup
+// instead of waiting for it
+var failFast bool
+
+// waitForDependency calls open until it succeeds, so a server started with
+// its metadata store, lock manager or S3 bucket still coming up waits for
+// them. Attempts back off exponentially from server.startup_retry_backoff
+// until server.startup_timeout has passed; with --fail-fast or a zero
+// timeout, the first error is returned.
+func waitForDependency[T any](ctx context.Context, name string, cfg config.ServerConfig, logger *zap.Logger, open func() (T, error)) (T, error) {
+	deadline := time.Now().Add(cfg.StartupTimeout)
+	backoff := cfg.StartupRetryBackoff
+	for attempt := 1; ; attempt++ {
+		value, err := open()
+		if err == nil {
+			if attempt > 1 {
+				logger.Info("Dependency available", zap.String("dependency", name), zap.Int("attempts", attempt))
+			}
+			return value, nil
+		}
+		if failFast || backoff <= 0 || time.Now().Add(backoff).After(deadline) {
+			return value, err
+		}
+
+		logger.Warn("Dependency unavailable, retrying",
+			zap.String("dependency", name),
+			zap.Int("attempt", attempt),
+			zap.Duration("retry_in", backoff),
+			zap.Error(err))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return value, fmt.Errorf("%w (stopped waiting: %w)", err, ctx.Err())
+		}
+		backoff = min(backoff*2, maxStartupBackoff)
+	}
+}
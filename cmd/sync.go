@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ebogdum/callfs/client"
+)
+
+var syncCmd = &cobra.Command{
+	Use:   "sync local-dir remote-dir",
+	Short: "Synchronize a directory tree with the server",
+	Long: "Upload the files below local-dir that are missing from remote-dir or differ from it, and create missing directories. " +
+		"With --download the arguments are remote-dir local-dir and files are downloaded instead. " +
+		"Files are compared by size and modification time, which sync copies, or by size and SHA-256 with --checksum. " +
+		"Files whose destination cannot be changed are reported and the others are still synchronized.",
+	Args: cobra.ExactArgs(2),
+	RunE: runSync,
+}
+
+var syncDownload bool
+var syncDelete bool
+var syncChecksum bool
+var syncDryRun bool
+var syncParallel int
+
+// syncEntry is a file or directory of a tree being synchronized
+type syncEntry struct {
+	dir   bool
+	size  int64
+	mtime time.Time
+}
+
+// syncStats counts what a sync did, updated by its workers
+type syncStats struct {
+	transferred atomic.Int64
+	bytes       atomic.Int64
+	unchanged   atomic.Int64
+	deleted     atomic.Int64
+	failed      atomic.Int64
+}
+
+// syncer copies one tree onto the other. Paths within the trees are relative
+// and slash-separated.
+type syncer struct {
+	ctx        context.Context
+	c          *client.Client
+	localRoot  string
+	remoteRoot string
+	download   bool
+	stats      syncStats
+}
+
+func runSync(cmd *cobra.Command, args []string) error {
+	if syncParallel < 1 {
+		return fmt.Errorf("--parallel must be at least 1")
+	}
+	c, ctx, cancel, err := newFileClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	s := &syncer{ctx: ctx, c: c, download: syncDownload}
+	if s.download {
+		s.remoteRoot, s.localRoot = remotePath(args[0]), filepath.Clean(args[1])
+	} else {
+		s.localRoot, s.remoteRoot = filepath.Clean(args[0]), remotePath(args[1])
+	}
+	start := time.Now()
+
+	local, err := s.localTree()
+	if err != nil {
+		return err
+	}
+	remote, err := s.remoteTree()
+	if err != nil {
+		return err
+	}
+	if local == nil {
+		return fmt.Errorf("%s is not a directory", s.localRoot)
+	}
+	if remote == nil {
+		return fmt.Errorf("%s is not a directory", s.remoteRoot)
+	}
+	src, dst := local, remote
+	if s.download {
+		src, dst = remote, local
+	}
+
+	s.run(src, dst)
+
+	stats := &s.stats
+	summary := fmt.Sprintf("%d transferred (%d bytes), %d unchanged, %d deleted in %s",
+		stats.transferred.Load(), stats.bytes.Load(), stats.unchanged.Load(), stats.deleted.Load(), time.Since(start).Round(time.Millisecond))
+	if syncDryRun {
+		summary = "dry run: " + summary
+	}
+	fmt.Println(summary)
+	if failed := stats.failed.Load(); failed > 0 {
+		return fmt.Errorf("%d files failed to synchronize", failed)
+	}
+	return ctx.Err()
+}
+
+// run brings dst in line with src: it replaces entries whose type changed,
+// creates missing directories, transfers files that differ with
+// syncParallel workers, then deletes what is only in dst with --delete
+func (s *syncer) run(src, dst map[string]syncEntry) {
+	var replaced []string
+	for rel, entry := range src {
+		if existing, ok := dst[rel]; ok && existing.dir != entry.dir {
+			replaced = append(replaced, rel)
+		}
+	}
+	for _, rel := range replaced {
+		s.remove(rel, dst[rel].dir)
+		for other := range dst {
+			if other == rel || strings.HasPrefix(other, rel+"/") {
+				delete(dst, other)
+			}
+		}
+	}
+
+	var dirs, files, extra []string
+	for rel, entry := range src {
+		_, ok := dst[rel]
+		switch {
+		case entry.dir && !ok:
+			dirs = append(dirs, rel)
+		case !entry.dir:
+			files = append(files, rel)
+		}
+	}
+	for rel := range dst {
+		if _, ok := src[rel]; !ok {
+			extra = append(extra, rel)
+		}
+	}
+	sort.Strings(dirs) // Parents first
+	sort.Strings(files)
+	sort.Strings(extra)
+
+	for _, rel := range dirs {
+		if s.ctx.Err() != nil {
+			return
+		}
+		s.mkdir(rel)
+	}
+
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for range min(syncParallel, max(len(files), 1)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rel := range work {
+				s.syncFile(rel, src[rel], dst)
+			}
+		}()
+	}
+	for _, rel := range files {
+		if s.ctx.Err() != nil {
+			break
+		}
+		work <- rel
+	}
+	close(work)
+	wg.Wait()
+
+	if !syncDelete || s.ctx.Err() != nil {
+		return
+	}
+	removedDir := ""
+	for _, rel := range extra {
+		if removedDir != "" && strings.HasPrefix(rel, removedDir+"/") {
+			continue // Went with its directory
+		}
+		if s.remove(rel, dst[rel].dir) && dst[rel].dir {
+			removedDir = rel
+		}
+	}
+}
+
+// syncFile transfers the source file rel unless the destination has it
+func (s *syncer) syncFile(rel string, entry syncEntry, dst map[string]syncEntry) {
+	var opts []client.UploadOption
+	existing, exists := dst[rel]
+	exists = exists && !existing.dir
+	if syncChecksum {
+		same, sum, err := s.sameContent(rel, entry, existing, exists)
+		if err != nil {
+			s.fail(rel, err)
+			return
+		}
+		if same {
+			s.stats.unchanged.Add(1)
+			return
+		}
+		if sum != "" {
+			opts = append(opts, client.WithChecksum(sum))
+		}
+	} else if exists && existing.size == entry.size && existing.mtime.Truncate(time.Second).Equal(entry.mtime.Truncate(time.Second)) {
+		s.stats.unchanged.Add(1)
+		return
+	}
+
+	localPath, remotePath := s.localPath(rel), s.remotePath(rel)
+	var err error
+	switch {
+	case syncDryRun && s.download:
+		fmt.Printf("download %s -> %s\n", remotePath, localPath)
+	case syncDryRun:
+		fmt.Printf("upload %s -> %s\n", localPath, remotePath)
+	case s.download:
+		err = download(s.ctx, s.c, client.FileInfo{Path: remotePath, MTime: entry.mtime}, localPath)
+	default:
+		opts = append(opts, client.WithModTime(entry.mtime))
+		err = upload(s.ctx, s.c, localPath, remotePath, opts...)
+	}
+	if err != nil {
+		s.fail(rel, err)
+		return
+	}
+	s.stats.transferred.Add(1)
+	s.stats.bytes.Add(entry.size)
+}
+
+// sameContent compares the SHA-256 of the two copies of rel, and returns the
+// local one when uploading so the server can verify it
+func (s *syncer) sameContent(rel string, entry, existing syncEntry, exists bool) (bool, string, error) {
+	if exists && existing.size != entry.size {
+		exists = false
+	}
+	if !exists && s.download {
+		return false, "", nil
+	}
+	localSum, err := fileSHA256(s.localPath(rel))
+	if err != nil || !exists {
+		return false, localSum, err
+	}
+	info, err := s.c.Stat(s.ctx, s.remotePath(rel))
+	if err != nil {
+		return false, "", fmt.Errorf("%s: %w", s.remotePath(rel), err)
+	}
+	// Files without a recorded checksum count as changed
+	return info.Checksum == "sha256="+localSum, localSum, nil
+}
+
+func fileSHA256(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// mkdir creates the destination directory rel
+func (s *syncer) mkdir(rel string) {
+	var err error
+	switch {
+	case syncDryRun:
+		fmt.Printf("mkdir %s\n", s.destination(rel))
+	case s.download:
+		err = os.MkdirAll(s.localPath(rel), 0o755)
+	default:
+		err = s.c.MkdirAll(s.ctx, s.remotePath(rel))
+	}
+	if err != nil {
+		s.fail(rel, err)
+	}
+}
+
+// remove deletes the destination entry rel and reports whether it is gone
+func (s *syncer) remove(rel string, dir bool) bool {
+	target := s.destination(rel)
+	var err error
+	switch {
+	case syncDryRun:
+		fmt.Printf("delete %s\n", target)
+	case s.download:
+		err = os.RemoveAll(target)
+	case dir:
+		err = removeAll(s.ctx, s.c, target)
+	default:
+		err = s.c.Remove(s.ctx, target)
+	}
+	if err != nil {
+		s.fail(rel, err)
+		return false
+	}
+	if !syncDryRun {
+		fmt.Printf("deleted %s\n", target)
+	}
+	s.stats.deleted.Add(1)
+	return true
+}
+
+func (s *syncer) fail(rel string, err error) {
+	s.stats.failed.Add(1)
+	fmt.Fprintf(os.Stderr, "Error: %s: %v\n", rel, err)
+}
+
+func (s *syncer) localPath(rel string) string {
+	return filepath.Join(s.localRoot, filepath.FromSlash(rel))
+}
+
+func (s *syncer) remotePath(rel string) string {
+	return path.Join(s.remoteRoot, rel)
+}
+
+func (s *syncer) destination(rel string) string {
+	if s.download {
+		return s.localPath(rel)
+	}
+	return s.remotePath(rel)
+}
+
+// localTree returns the directories and regular files below localRoot, or
+// nil when localRoot is not a directory. A missing destination is empty.
+func (s *syncer) localTree() (map[string]syncEntry, error) {
+	info, err := os.Stat(s.localRoot)
+	if errors.Is(err, fs.ErrNotExist) && s.download {
+		if !syncDryRun {
+			err = os.MkdirAll(s.localRoot, 0o755)
+		}
+		return map[string]syncEntry{}, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, nil
+	}
+
+	tree := map[string]syncEntry{}
+	err = filepath.WalkDir(s.localRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == s.localRoot || !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.localRoot, p)
+		if err != nil {
+			return err
+		}
+		tree[filepath.ToSlash(rel)] = syncEntry{dir: d.IsDir(), size: info.Size(), mtime: info.ModTime()}
+		return nil
+	})
+	return tree, err
+}
+
+// remoteTree returns the entries below remoteRoot, or nil when remoteRoot is
+// not a directory. A missing destination is empty.
+func (s *syncer) remoteTree() (map[string]syncEntry, error) {
+	info, err := s.c.Stat(s.ctx, s.remoteRoot)
+	if errors.Is(err, client.ErrNotFound) && !s.download {
+		if !syncDryRun {
+			err = s.c.MkdirAll(s.ctx, s.remoteRoot)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.remoteRoot, err)
+		}
+		return map[string]syncEntry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.remoteRoot, err)
+	}
+	if !info.IsDir() {
+		return nil, nil
+	}
+
+	tree := map[string]syncEntry{}
+	prefix := strings.TrimSuffix(s.remoteRoot, "/") + "/"
+	err = walkRemote(s.ctx, s.c, s.remoteRoot, func(_ string, entries []client.FileInfo) error {
+		for _, entry := range entries {
+			tree[strings.TrimPrefix(entry.Path, prefix)] = syncEntry{dir: entry.IsDir(), size: entry.Size, mtime: entry.MTime}
+		}
+		return nil
+	})
+	return tree, err
+}
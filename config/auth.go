@@ -0,0 +1,121 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// InternalProxySecrets returns the secrets peers may authenticate with: the
+// internal proxy secret, then those accepted during a rotation
+func (c AuthConfig) InternalProxySecrets() []string {
+	return append([]string{c.InternalProxySecret}, c.AcceptedInternalProxySecrets...)
+}
+
+// SingleUseLinkSecrets returns the secrets links are verified with: the link
+// secret, which signs new links, then those accepted during a rotation
+func (c AuthConfig) SingleUseLinkSecrets() []string {
+	return append([]string{c.SingleUseLinkSecret}, c.AcceptedSingleUseLinkSecrets...)
+}
+
+// UserIDs returns the user IDs the API keys authenticate as: api-user-N for
+// the Nth of the API keys and admin-N for the Nth of the admin API keys
+func (c AuthConfig) UserIDs() []string {
+	userIDs := make([]string, 0, len(c.APIKeys)+len(c.AdminAPIKeys))
+	for i := range c.APIKeys {
+		userIDs = append(userIDs, fmt.Sprintf("api-user-%d", i+1))
+	}
+	for i := range c.AdminAPIKeys {
+		userIDs = append(userIDs, fmt.Sprintf("admin-%d", i+1))
+	}
+	return userIDs
+}
+
+// validateUserSettings checks the identities of API keys, and that they, the
+// per-user inode defaults and the services acting on behalf of users name the
+// user IDs of configured keys
+func validateUserSettings(cfg *AppConfig) error {
+	userIDs := cfg.Auth.UserIDs()
+	for userID, id := range cfg.Auth.Identities {
+		if !slices.Contains(userIDs, userID) && !cfg.Auth.isOIDCUserID(userID) {
+			return fmt.Errorf("auth.identities.%s: %w", userID, errUnknownUserID)
+		}
+		if id.UID < 0 || slices.ContainsFunc(id.GIDs, func(gid int) bool { return gid < 0 }) {
+			return fmt.Errorf("auth.identities.%s: uid and gids must not be negative", userID)
+		}
+	}
+	for userID := range cfg.Server.InodeDefaults.Users {
+		if !slices.Contains(userIDs, userID) && !cfg.Auth.isOIDCUserID(userID) {
+			return fmt.Errorf("server.inode_defaults.users.%s: %w", userID, errUnknownUserID)
+		}
+	}
+	for serviceID, targets := range cfg.Auth.OnBehalfOf {
+		if !slices.Contains(userIDs, serviceID) && !cfg.Auth.isOIDCUserID(serviceID) {
+			return fmt.Errorf("auth.on_behalf_of.%s: %w", serviceID, errUnknownUserID)
+		}
+		for _, target := range targets {
+			switch {
+			case target == "oidc:*" && cfg.Auth.OIDC.Issuer != "":
+			case strings.HasPrefix(target, "admin-"):
+				// Acting for an admin would open the admin API to the service
+				return fmt.Errorf("auth.on_behalf_of.%s: %s is an admin user, on whose behalf no service may act", serviceID, target)
+			case target == serviceID:
+				return fmt.Errorf("auth.on_behalf_of.%s lists the service itself", serviceID)
+			case !slices.Contains(userIDs, target) && !cfg.Auth.isOIDCUserID(target):
+				return fmt.Errorf("auth.on_behalf_of.%s: %s: %w", serviceID, target, errUnknownUserID)
+			}
+		}
+	}
+	return nil
+}
+
+var errUnknownUserID = errors.New("no API key has this user ID; the Nth of auth.api_keys is api-user-N and the Nth of auth.admin_api_keys is admin-N")
+
+// isOIDCUserID reports whether userID is one OIDC users may authenticate as,
+// oidc:<name> or admin-oidc:<name>, with OIDC enabled
+func (c AuthConfig) isOIDCUserID(userID string) bool {
+	return c.OIDC.Issuer != "" && strings.HasPrefix(strings.TrimPrefix(userID, "admin-"), "oidc:")
+}
+
+// validateOIDC checks the OIDC provider settings, when an issuer enables them
+func validateOIDC(cfg *AppConfig) error {
+	oidc := cfg.Auth.OIDC
+	if oidc.Issuer == "" {
+		return nil
+	}
+	for name, value := range map[string]string{"issuer": oidc.Issuer, "jwks_url": oidc.JWKSURL, "introspection_url": oidc.IntrospectionURL} {
+		if value == "" {
+			continue
+		}
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("auth.oidc.%s must be an http or https URL", name)
+		}
+	}
+	if oidc.ClientID == "" && oidc.Audience == "" {
+		return fmt.Errorf("auth.oidc.client_id or auth.oidc.audience must be set, to check whom tokens were issued for")
+	}
+	if oidc.UsernameClaim == "" {
+		return fmt.Errorf("auth.oidc.username_claim must be set")
+	}
+	if (len(oidc.AdminGroups) > 0 || len(oidc.GroupGIDs) > 0) && oidc.GroupsClaim == "" {
+		return fmt.Errorf("auth.oidc.groups_claim must be set to use auth.oidc.admin_groups or auth.oidc.group_gids")
+	}
+	if oidc.GIDClaim != "" && oidc.UIDClaim == "" {
+		return fmt.Errorf("auth.oidc.gid_claim needs auth.oidc.uid_claim")
+	}
+	for group, gid := range oidc.GroupGIDs {
+		if gid < 0 {
+			return fmt.Errorf("auth.oidc.group_gids.%s must not be negative", group)
+		}
+	}
+	if oidc.IntrospectionRate < 0 {
+		return fmt.Errorf("auth.oidc.introspection_rate must not be negative")
+	}
+	if oidc.IntrospectionCacheTTL < 0 || oidc.Timeout <= 0 {
+		return fmt.Errorf("auth.oidc.introspection_cache_ttl must not be negative and auth.oidc.timeout must be positive")
+	}
+	return nil
+}
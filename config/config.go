@@ -12,70 +12,315 @@ type AppConfig struct {
 	Metrics           MetricsConfig           `koanf:"metrics"`
 	Backend           BackendConfig           `koanf:"backend"`
 	MetadataStore     MetadataStoreConfig     `koanf:"metadata_store"`
+	MetadataCache     MetadataCacheConfig     `koanf:"metadata_cache"`
 	Raft              RaftConfig              `koanf:"raft"`
 	DLM               DLMConfig               `koanf:"dlm"`
 	HA                HAConfig                `koanf:"ha"`
 	InstanceDiscovery InstanceDiscoveryConfig `koanf:"instance_discovery"`
 	Erasure           ErasureConfig           `koanf:"erasure"`
+	GC                GCConfig                `koanf:"gc"`
+	Jobs              JobsConfig              `koanf:"jobs"`
+	Scan              ScanConfig              `koanf:"scan"`
+	Hooks             HooksConfig             `koanf:"hooks"`
+	Preview           PreviewConfig           `koanf:"preview"`
+	Snapshots         SnapshotsConfig         `koanf:"snapshots"`
+	Lifecycle         LifecycleConfig         `koanf:"lifecycle"`
+	Search            SearchConfig            `koanf:"search"`
+	AccessStats       AccessStatsConfig       `koanf:"access_stats"`
+	RateLimit         RateLimitConfig         `koanf:"rate_limit"`
+	NFS               NFSConfig               `koanf:"nfs"`
 }
 
 // ServerConfig holds HTTP server configuration
 type ServerConfig struct {
-	ListenAddr        string        `koanf:"listen_addr"`
-	Protocol          string        `koanf:"protocol"`
-	ExternalURL       string        `koanf:"external_url"`
-	CertFile          string        `koanf:"cert_file"`
-	KeyFile           string        `koanf:"key_file"`
-	EnableQUIC        bool          `koanf:"enable_quic"`
-	QUICListenAddr    string        `koanf:"quic_listen_addr"`
-	ReadTimeout       time.Duration `koanf:"read_timeout"`
-	WriteTimeout      time.Duration `koanf:"write_timeout"`
-	FileOpTimeout     time.Duration `koanf:"file_op_timeout"`
-	MetadataOpTimeout time.Duration `koanf:"metadata_op_timeout"`
+	Role                string              `koanf:"role"` // "all", "api" (no background jobs) or "worker" (background jobs only)
+	ListenAddr          string              `koanf:"listen_addr"`
+	InternalListenAddr  string              `koanf:"internal_listen_addr"` // Optional private listener for /v1/internal/* routes
+	Protocol            string              `koanf:"protocol"`
+	ExternalURL         string              `koanf:"external_url"`
+	CertFile            string              `koanf:"cert_file"`
+	KeyFile             string              `koanf:"key_file"`
+	EnableQUIC          bool                `koanf:"enable_quic"`
+	QUICListenAddr      string              `koanf:"quic_listen_addr"`
+	EnableGRPC          bool                `koanf:"enable_grpc"`         // Serve the gRPC API, over TLS, on GRPCListenAddr
+	GRPCListenAddr      string              `koanf:"grpc_listen_addr"`    // TCP address of the gRPC API
+	ReadTimeout         time.Duration       `koanf:"read_timeout"`        // Longest a request body may stall
+	WriteTimeout        time.Duration       `koanf:"write_timeout"`       // Longest a response may stall, counted from the last of the body received
+	ReadHeaderTimeout   time.Duration       `koanf:"read_header_timeout"` // Time allowed to read request headers
+	IdleTimeout         time.Duration       `koanf:"idle_timeout"`        // Keep-alive connections idle longer are closed
+	MaxHeaderBytes      int                 `koanf:"max_header_bytes"`    // Largest request headers accepted
+	HTTP2               HTTP2Config         `koanf:"http2"`
+	TLS                 TLSConfig           `koanf:"tls"`
+	SecurityHeaders     HeadersConfig       `koanf:"security_headers"` // Headers sent with every response
+	FileOpTimeout       time.Duration       `koanf:"file_op_timeout"`
+	MetadataOpTimeout   time.Duration       `koanf:"metadata_op_timeout"`
+	HealthCheckTimeout  time.Duration       `koanf:"health_check_timeout"`    // Per-dependency timeout for /readyz checks
+	DrainDelay          time.Duration       `koanf:"drain_delay"`             // Time a stopping instance drains before it closes its listeners
+	ReadOnly            bool                `koanf:"read_only"`               // Refuse every change to files served by this instance
+	ReadOnlyPrefixes    []string            `koanf:"read_only_prefixes"`      // Refuse changes to paths at or below these
+	StartupTimeout      time.Duration       `koanf:"startup_timeout"`         // How long startup waits for the metadata store, lock manager and S3 (0 fails at once)
+	StartupRetryBackoff time.Duration       `koanf:"startup_retry_backoff"`   // First wait between attempts to reach them, doubling up to 30s
+	MaxFileSize         int64               `koanf:"max_file_size"`           // Maximum upload size in bytes
+	MaxFileSizeByPrefix map[string]int64    `koanf:"max_file_size_by_prefix"` // Path prefix -> max upload size in bytes (longest prefix wins)
+	SpoolDir            string              `koanf:"spool_dir"`               // Files patched with deltas are built here; the system temp directory when empty
+	EnableUI            bool                `koanf:"enable_ui"`               // Serve the web file browser at /ui
+	InodeDefaults       InodeDefaultsConfig `koanf:"inode_defaults"`          // Owner and modes of files and directories clients create
+}
+
+// InodeDefaultsConfig sets the owner and modes of the files and directories
+// clients create. Modes are octal strings such as "0644".
+type InodeDefaultsConfig struct {
+	UID      int                        `koanf:"uid"`
+	GID      int                        `koanf:"gid"`
+	FileMode string                     `koanf:"file_mode"`
+	DirMode  string                     `koanf:"dir_mode"`
+	Umask    string                     `koanf:"umask"` // Cleared from the modes clients ask for when creating
+	Users    map[string]InodeUserConfig `koanf:"users"` // User ID, such as api-user-1 or admin-1 -> overrides
+}
+
+// InodeUserConfig overrides the inode defaults for one user. Unset fields
+// keep the defaults.
+type InodeUserConfig struct {
+	UID      *int   `koanf:"uid"`
+	GID      *int   `koanf:"gid"`
+	FileMode string `koanf:"file_mode"`
+	DirMode  string `koanf:"dir_mode"`
+	Umask    string `koanf:"umask"`
+}
+
+// TLSConfig hardens TLS on the API listeners
+type TLSConfig struct {
+	MinVersion     string   `koanf:"min_version"`     // "1.2" or "1.3"
+	CipherSuites   []string `koanf:"cipher_suites"`   // TLS 1.2 suites by name, such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256; empty keeps Go's
+	SessionTickets bool     `koanf:"session_tickets"` // Let clients resume sessions with tickets
+}
+
+// HeadersConfig sets the security headers of every response. An empty value
+// leaves its header out.
+type HeadersConfig struct {
+	HSTSMaxAge            time.Duration `koanf:"hsts_max_age"` // Strict-Transport-Security on TLS connections; 0 leaves it out
+	HSTSIncludeSubdomains bool          `koanf:"hsts_include_subdomains"`
+	HSTSPreload           bool          `koanf:"hsts_preload"`
+	ContentSecurityPolicy string        `koanf:"content_security_policy"`
+	FrameOptions          string        `koanf:"frame_options"` // DENY or SAMEORIGIN
+	ReferrerPolicy        string        `koanf:"referrer_policy"`
+}
+
+// HTTP2Config tunes HTTP/2 connections of the API listeners. Zero keeps Go's
+// defaults.
+type HTTP2Config struct {
+	MaxConcurrentStreams          int           `koanf:"max_concurrent_streams"`            // Requests a client may have in flight per connection
+	MaxReceiveBufferPerStream     int           `koanf:"max_receive_buffer_per_stream"`     // Flow-control window of an upload; raise it for fast, distant clients
+	MaxReceiveBufferPerConnection int           `koanf:"max_receive_buffer_per_connection"` // Flow-control window of all uploads of a connection
+	PingInterval                  time.Duration `koanf:"ping_interval"`                     // Ping connections silent for this long, and close those that do not answer
 }
 
 // AuthConfig holds authentication configuration
 type AuthConfig struct {
 	APIKeys             []string `koanf:"api_keys"`
+	AdminAPIKeys        []string `koanf:"admin_api_keys"` // Keys allowed to call /v1/admin/*
 	InternalProxySecret string   `koanf:"internal_proxy_secret"`
 	SingleUseLinkSecret string   `koanf:"single_use_link_secret"`
+
+	// Secrets accepted besides the two above while they are rotated. They
+	// authenticate peers and verify links but are never sent or used to sign.
+	AcceptedInternalProxySecrets []string `koanf:"accepted_internal_proxy_secrets"`
+	AcceptedSingleUseLinkSecrets []string `koanf:"accepted_single_use_link_secrets"`
+
+	// How peers authenticate to each other: "required" signs requests with
+	// the internal proxy secret and accepts only signed ones, "optional" also
+	// accepts the secret as a bearer token and "off" sends it as one
+	InternalRequestSigning string `koanf:"internal_request_signing"`
+
+	// Unix identities of the API keys, by user ID (api-user-1, admin-1, ...).
+	// Keys without one are checked as UID and GID 1000+N for api-user-N, or
+	// 1000.
+	Identities map[string]IdentityConfig `koanf:"identities"`
+
+	// User IDs each trusted service may act on behalf of with the
+	// X-CallFS-On-Behalf-Of header, by the service's user ID. "oidc:*"
+	// allows any OIDC user.
+	OnBehalfOf map[string][]string `koanf:"on_behalf_of"`
+
+	// Longest lifetime of the scoped tokens minted by POST /v1/tokens; zero
+	// disables them
+	ScopedTokenMaxTTL time.Duration `koanf:"scoped_token_max_ttl"`
+
+	OIDC OIDCConfig `koanf:"oidc"`
+}
+
+// OIDCConfig accepts tokens of an OpenID Connect provider besides API keys,
+// and lets users sign in to the web file browser with it. Setting the issuer
+// enables it.
+type OIDCConfig struct {
+	Issuer                string         `koanf:"issuer"`        // e.g. https://keycloak.example.com/realms/callfs
+	ClientID              string         `koanf:"client_id"`     // Client the web file browser signs in with
+	ClientSecret          string         `koanf:"client_secret"` // For introspection and confidential clients
+	Audience              string         `koanf:"audience"`      // Required in the aud claim of JWTs; defaults to client_id
+	JWKSURL               string         `koanf:"jwks_url"`      // Found by discovery when empty
+	IntrospectionURL      string         `koanf:"introspection_url"`
+	Scopes                []string       `koanf:"scopes"`         // Requested when signing in to the web file browser
+	UsernameClaim         string         `koanf:"username_claim"` // User IDs are oidc:<claim value>
+	GroupsClaim           string         `koanf:"groups_claim"`   // Dotted for nested claims, e.g. realm_access.roles
+	AdminGroups           []string       `koanf:"admin_groups"`   // Members may call /v1/admin/*
+	UIDClaim              string         `koanf:"uid_claim"`      // Numeric claim with the user's UID
+	GIDClaim              string         `koanf:"gid_claim"`      // Numeric claim with the primary GID; defaults to the UID
+	GroupGIDs             map[string]int `koanf:"group_gids"`     // Group name -> supplementary GID
+	IntrospectionCacheTTL time.Duration  `koanf:"introspection_cache_ttl"`
+	IntrospectionRate     float64        `koanf:"introspection_rate"` // Introspection requests per second; 0 is unlimited
+	Timeout               time.Duration  `koanf:"timeout"`            // Bounds each request to the provider
+}
+
+// IdentityConfig is the Unix user an API key acts as, for permission checks
+// and as the owner of the files it creates
+type IdentityConfig struct {
+	UID  int   `koanf:"uid"`
+	GIDs []int `koanf:"gids"` // Primary group first; defaults to the UID
 }
 
 // LogConfig holds logging configuration
 type LogConfig struct {
-	Level  string `koanf:"level"`
-	Format string `koanf:"format"`
+	Level             string            `koanf:"level"`
+	Format            string            `koanf:"format"`
+	Output            string            `koanf:"output"`              // stderr, stdout, syslog, syslog://host:port, syslog+tcp://host:port or a file path
+	ErrorOutput       string            `koanf:"error_output"`        // Optional: where error entries are also written; same values as output
+	Rotation          LogRotationConfig `koanf:"rotation"`            // Of file outputs and the access log
+	AccessLogPath     string            `koanf:"access_log_path"`     // Optional Apache-style access log file
+	SlowMetadataQuery time.Duration     `koanf:"slow_metadata_query"` // Warn of metadata queries taking longer; 0 disables
+	SlowBackendOp     time.Duration     `koanf:"slow_backend_op"`     // Warn of backend operations taking longer; 0 disables
+	SlowRequest       time.Duration     `koanf:"slow_request"`        // Warn of HTTP requests taking longer; 0 disables
+}
+
+// LogRotationConfig holds when log files are rotated and how many rotated
+// files are kept. A zero value disables the corresponding rule.
+type LogRotationConfig struct {
+	MaxBytes   int64         `koanf:"max_bytes"`   // Rotate before a file grows past this size
+	Interval   time.Duration `koanf:"interval"`    // Rotate files open this long
+	MaxBackups int           `koanf:"max_backups"` // Rotated files kept
+	MaxAge     time.Duration `koanf:"max_age"`     // Rotated files older than this are removed
+	Compress   bool          `koanf:"compress"`    // Gzip rotated files
+}
+
+// RateLimitConfig holds request rate, upload concurrency and bandwidth limits for the /v1 API.
+// A zero value disables the corresponding limit.
+type RateLimitConfig struct {
+	GlobalRPS                  float64 `koanf:"global_rps"`
+	GlobalBurst                int     `koanf:"global_burst"`
+	PerKeyRPS                  float64 `koanf:"per_key_rps"` // Applied per authenticated API key
+	PerKeyBurst                int     `koanf:"per_key_burst"`
+	PerIPRPS                   float64 `koanf:"per_ip_rps"`
+	PerIPBurst                 int     `koanf:"per_ip_burst"`
+	MaxConcurrentUploads       int     `koanf:"max_concurrent_uploads"`         // Across all clients
+	MaxConcurrentUploadsPerKey int     `koanf:"max_concurrent_uploads_per_key"` // Per authenticated API key
+	TransferBytesPerSec        int64   `koanf:"transfer_bytes_per_sec"`         // Content of each upload or download
+	LocalFSBytesPerSec         int64   `koanf:"localfs_bytes_per_sec"`          // All transfers to and from the local filesystem
+	S3BytesPerSec              int64   `koanf:"s3_bytes_per_sec"`               // All transfers to and from S3
+
+	// Uploads each backend stores at once; further uploads wait up to
+	// write_queue_timeout for a slot, then fail with 503
+	LocalFSMaxConcurrentWrites int           `koanf:"localfs_max_concurrent_writes"`
+	S3MaxConcurrentWrites      int           `koanf:"s3_max_concurrent_writes"`
+	PeerMaxConcurrentWrites    int           `koanf:"peer_max_concurrent_writes"` // Uploads forwarded to other instances
+	WriteQueueTimeout          time.Duration `koanf:"write_queue_timeout"`
+
+	// Failed authentications from one IP, or with one key, within
+	// auth_failure_window that lock it out; each lockout lasts twice the last,
+	// from auth_lockout up to auth_lockout_max
+	AuthFailureLimit  int           `koanf:"auth_failure_limit"`
+	AuthFailureWindow time.Duration `koanf:"auth_failure_window"`
+	AuthLockout       time.Duration `koanf:"auth_lockout"`
+	AuthLockoutMax    time.Duration `koanf:"auth_lockout_max"`
+
+	// Reverse proxies (CIDRs or IPs) whose X-Forwarded-For header names the
+	// client IP the per-IP limits and lockouts apply to; other clients are
+	// known by their remote address
+	TrustedProxies []string `koanf:"trusted_proxies"`
 }
 
 // MetricsConfig holds metrics server configuration
 type MetricsConfig struct {
-	ListenAddr string `koanf:"listen_addr"`
+	ListenAddr        string   `koanf:"listen_addr"`         // Dedicated metrics listener; empty serves /metrics on the main API listener
+	BasicAuthUsername string   `koanf:"basic_auth_username"` // Optional basic auth for the dedicated listener
+	BasicAuthPassword string   `koanf:"basic_auth_password"`
+	AllowedCIDRs      []string `koanf:"allowed_cidrs"` // Optional client allowlist for the dedicated listener
 }
 
 // BackendConfig holds backend storage configuration
 type BackendConfig struct {
-	DefaultBackend             string `koanf:"default_backend"` // Default backend for new files: "localfs" or "s3"
-	LocalFSRootPath            string `koanf:"localfs_root_path"`
-	S3AccessKey                string `koanf:"s3_access_key"`
-	S3SecretKey                string `koanf:"s3_secret_key"`
-	S3Region                   string `koanf:"s3_region"`
-	S3BucketName               string `koanf:"s3_bucket_name"`
-	S3Endpoint                 string `koanf:"s3_endpoint"`                    // Custom S3 endpoint (e.g., for MinIO)
-	S3ServerSideEncryption     string `koanf:"s3_server_side_encryption"`      // SSE algorithm (AES256, aws:kms)
-	S3ACL                      string `koanf:"s3_acl"`                         // Object ACL (private, public-read, etc.)
-	S3KMSKeyID                 string `koanf:"s3_kms_key_id"`                  // KMS key ID for SSE-KMS
-	InternalProxySkipTLSVerify bool   `koanf:"internal_proxy_skip_tls_verify"` // Skip TLS certificate verification for internal proxy requests
+	DefaultBackend             string        `koanf:"default_backend"`  // Default backend for new files: "localfs" or "s3"
+	PlacementPolicy            string        `koanf:"placement_policy"` // Owner of new localfs files: local | hash | capacity
+	LocalFSRootPath            string        `koanf:"localfs_root_path"`
+	LocalFSSync                bool          `koanf:"localfs_sync"`            // fsync localfs writes before acknowledging them
+	LocalFSXAttrs              []string      `koanf:"localfs_xattrs"`          // Extended attributes reported by localfs stat and settable on upload
+	LocalFSMinFreeBytes        int64         `koanf:"localfs_min_free_bytes"`  // Free space localfs writes must leave (0 disables the check)
+	LocalFSMinFreeInodes       int64         `koanf:"localfs_min_free_inodes"` // Free inodes localfs writes must leave (0 disables the check)
+	S3AccessKey                string        `koanf:"s3_access_key"`
+	S3SecretKey                string        `koanf:"s3_secret_key"`
+	S3Region                   string        `koanf:"s3_region"`
+	S3BucketName               string        `koanf:"s3_bucket_name"`
+	S3Endpoint                 string        `koanf:"s3_endpoint"`                    // Custom S3 endpoint (e.g., for MinIO)
+	S3ServerSideEncryption     string        `koanf:"s3_server_side_encryption"`      // SSE algorithm (AES256, aws:kms)
+	S3ACL                      string        `koanf:"s3_acl"`                         // Object ACL (private, public-read, etc.)
+	S3KMSKeyID                 string        `koanf:"s3_kms_key_id"`                  // KMS key ID for SSE-KMS
+	S3QuotaBytes               int64         `koanf:"s3_quota_bytes"`                 // Capacity reported for S3 by statfs (0 for unlimited)
+	S3DownloadConcurrency      int           `koanf:"s3_download_concurrency"`        // Ranged GETs a download runs ahead of the client (0 disables parallel downloads)
+	S3DownloadPartSize         int64         `koanf:"s3_download_part_size"`          // Bytes per ranged GET of a parallel download
+	S3CacheDir                 string        `koanf:"s3_cache_dir"`                   // Local disk cache of S3 content (empty disables it)
+	S3CacheMaxBytes            int64         `koanf:"s3_cache_max_bytes"`             // Size of the S3 content cache
+	S3ObjectLockMode           string        `koanf:"s3_object_lock_mode"`            // Object Lock retention of immutable files: GOVERNANCE or COMPLIANCE (empty leaves objects unlocked)
+	HoldMaxOwnerRetention      time.Duration `koanf:"hold_max_owner_retention"`       // Longest retention owners place on their files (0 leaves holds to admins)
+	ReadBufferSize             int           `koanf:"read_buffer_size"`               // Bytes read from a backend at a time for downloads (0 reads as much as the client asks for)
+	ReadAhead                  int           `koanf:"read_ahead"`                     // Buffers read ahead of a download's client (0 disables read-ahead)
+	InternalProxySkipTLSVerify bool          `koanf:"internal_proxy_skip_tls_verify"` // Skip TLS certificate verification for internal proxy requests
+	InternalProxyH2C           bool          `koanf:"internal_proxy_h2c"`             // Unencrypted HTTP/2 between instances when server.protocol is http
+	InternalProxyTimeout       time.Duration `koanf:"internal_proxy_timeout"`         // Limit for proxied requests without a file body (0 disables it)
+	InternalProxyDialTimeout   time.Duration `koanf:"internal_proxy_dial_timeout"`    // Limit for connecting to a peer
+	InternalProxyHeaderTimeout time.Duration `koanf:"internal_proxy_header_timeout"`  // Limit for a peer's response headers once the request is sent (0 disables it)
 }
 
 // MetadataStoreConfig holds metadata store configuration
 type MetadataStoreConfig struct {
-	Type           string `koanf:"type"` // postgres | sqlite | redis | raft
-	DSN            string `koanf:"dsn"`
-	SQLitePath     string `koanf:"sqlite_path"`
-	RedisAddr      string `koanf:"redis_addr"`
-	RedisPassword  string `koanf:"redis_password"`
-	RedisDB        int    `koanf:"redis_db"`
-	RedisKeyPrefix string `koanf:"redis_key_prefix"`
+	Type            string `koanf:"type"` // postgres | sqlite | redis | raft
+	DSN             string `koanf:"dsn"`
+	SQLitePath      string `koanf:"sqlite_path"`
+	SQLiteBackupDir string `koanf:"sqlite_backup_dir"` // Directory for online backups taken via /v1/internal/sqlite/backup
+	RedisAddr       string `koanf:"redis_addr"`
+	RedisPassword   string `koanf:"redis_password"`
+	RedisDB         int    `koanf:"redis_db"`
+	RedisKeyPrefix  string `koanf:"redis_key_prefix"`
+	RedisLinkTTL    bool   `koanf:"redis_link_ttl"` // Expire single-use link keys at expires_at with Redis TTLs
+
+	// Redis topology, ACL and TLS (see RedisConn)
+	RedisMode             string   `koanf:"redis_mode"`  // standalone | sentinel | cluster
+	RedisAddrs            []string `koanf:"redis_addrs"` // Sentinel addresses or cluster seed nodes; redis_addr when empty
+	RedisMasterName       string   `koanf:"redis_master_name"`
+	RedisUsername         string   `koanf:"redis_username"`
+	RedisSentinelPassword string   `koanf:"redis_sentinel_password"`
+	RedisTLS              bool     `koanf:"redis_tls"`
+	RedisTLSCAFile        string   `koanf:"redis_tls_ca_file"`
+	RedisTLSSkipVerify    bool     `koanf:"redis_tls_skip_verify"`
+
+	// PostgreSQL connection pool tuning
+	MaxOpenConns    int           `koanf:"max_open_conns"`     // Maximum open connections (0 = unlimited)
+	MaxIdleConns    int           `koanf:"max_idle_conns"`     // Maximum idle connections kept in the pool
+	ConnMaxLifetime time.Duration `koanf:"conn_max_lifetime"`  // Maximum time a connection may be reused (0 = forever)
+	ConnMaxIdleTime time.Duration `koanf:"conn_max_idle_time"` // Maximum time a connection may sit idle (0 = forever)
+	StatementCache  bool          `koanf:"statement_cache"`    // Prepare each query once and reuse the statement
+
+	// PostgreSQL read replicas for Get and directory listings
+	ReadReplicaDSNs      []string      `koanf:"read_replica_dsns"`
+	ReplicaMaxLag        time.Duration `koanf:"replica_max_lag"`        // Skip replicas lagging further behind (0 = no limit)
+	ReplicaCheckInterval time.Duration `koanf:"replica_check_interval"` // How often replica health and lag are checked
+}
+
+// MetadataCacheConfig holds settings for the in-memory metadata cache
+type MetadataCacheConfig struct {
+	Enabled             bool          `koanf:"enabled"`
+	TTL                 time.Duration `koanf:"ttl"`                  // Lifetime of cached metadata
+	MaxEntries          int           `koanf:"max_entries"`          // Maximum number of cached paths
+	NegativeTTL         time.Duration `koanf:"negative_ttl"`         // Lifetime of cached "not found" results (0 disables)
+	Invalidation        string        `koanf:"invalidation"`         // auto | postgres | redis | none
+	InvalidationChannel string        `koanf:"invalidation_channel"` // NOTIFY / pub-sub channel name
 }
 
 // RaftConfig holds consensus and replication settings for independent cluster metadata synchronization.
@@ -92,13 +337,29 @@ type RaftConfig struct {
 	SnapshotInterval    time.Duration     `koanf:"snapshot_interval"`
 	SnapshotThreshold   uint64            `koanf:"snapshot_threshold"`
 	RetainSnapshotCount int               `koanf:"retain_snapshot_count"`
+	ReadConsistency     string            `koanf:"read_consistency"` // eventual | linearizable
 }
 
 // DLMConfig holds distributed lock manager configuration
 type DLMConfig struct {
-	Type          string `koanf:"type"` // redis | local
-	RedisAddr     string `koanf:"redis_addr"`
-	RedisPassword string `koanf:"redis_password"`
+	Type          string        `koanf:"type"`     // redis | redlock | local
+	LockTTL       time.Duration `koanf:"lock_ttl"` // Lock expiry; held locks are renewed every third of it
+	RedisAddr     string        `koanf:"redis_addr"`
+	RedisPassword string        `koanf:"redis_password"`
+
+	// Redis topology, ACL and TLS (see RedisConn)
+	RedisMode             string   `koanf:"redis_mode"`  // standalone | sentinel | cluster
+	RedisAddrs            []string `koanf:"redis_addrs"` // Sentinel addresses or cluster seed nodes; redis_addr when empty
+	RedisMasterName       string   `koanf:"redis_master_name"`
+	RedisUsername         string   `koanf:"redis_username"`
+	RedisSentinelPassword string   `koanf:"redis_sentinel_password"`
+	RedisTLS              bool     `koanf:"redis_tls"`
+	RedisTLSCAFile        string   `koanf:"redis_tls_ca_file"`
+	RedisTLSSkipVerify    bool     `koanf:"redis_tls_skip_verify"`
+
+	// RedlockAddrs lists independent Redis masters for dlm.type=redlock. They
+	// share the username, password and TLS settings above.
+	RedlockAddrs []string `koanf:"redlock_addrs"`
 }
 
 // HAConfig controls optional high-availability replication behavior
@@ -118,8 +379,163 @@ type ErasureConfig struct {
 	ShardPath    string `koanf:"shard_path"`    // base path for shard storage
 }
 
+// GCConfig holds garbage collection of orphaned backend objects: files with
+// no metadata, leftover temporary uploads and incomplete S3 multipart uploads
+type GCConfig struct {
+	Enabled     bool          `koanf:"enabled"`      // Collect periodically in the background
+	Interval    time.Duration `koanf:"interval"`     // Time between collections
+	GracePeriod time.Duration `koanf:"grace_period"` // Minimum age of an orphan before it is collected
+	DryRun      bool          `koanf:"dry_run"`      // Only log the orphans found
+}
+
+// JobsConfig schedules the background jobs
+type JobsConfig struct {
+	LeaderElection string    `koanf:"leader_election"` // How the instance running leader-only jobs is elected: "auto", "lease" or "raft"
+	LinkCleanup    JobConfig `koanf:"link_cleanup"`    // Removal of expired and used single-use links
+	GC             JobConfig `koanf:"gc"`              // Garbage collection, when gc.enabled
+	Lifecycle      JobConfig `koanf:"lifecycle"`       // Lifecycle rules, when lifecycle.enabled
+}
+
+// JobConfig schedules one background job
+type JobConfig struct {
+	Schedule   string        `koanf:"schedule"`    // Cron expression such as "*/5 * * * *", or a shorthand such as "@every 5m"
+	Jitter     time.Duration `koanf:"jitter"`      // Random delay of up to this before each run
+	LeaderOnly bool          `koanf:"leader_only"` // Run on one instance of the cluster at a time
+	Timeout    time.Duration `koanf:"timeout"`     // Longest a run may take; 0 for no limit
+}
+
+// ScanConfig holds malware scanning of uploads by a clamd or ICAP server
+type ScanConfig struct {
+	Type           string        `koanf:"type"`            // "clamd" or "icap"; empty disables scanning
+	Address        string        `koanf:"address"`         // clamd socket path or host:port, or icap://host:port/service
+	Timeout        time.Duration `koanf:"timeout"`         // Bound on connecting, each write and the wait for a verdict
+	Action         string        `koanf:"action"`          // On detection: "reject", "quarantine" or "tag"
+	QuarantinePath string        `koanf:"quarantine_path"` // Directory quarantined uploads are moved below
+	TagXAttr       string        `koanf:"tag_xattr"`       // Extended attribute set to the threat with action=tag
+	FailOpen       bool          `koanf:"fail_open"`       // Accept uploads the scanner fails on instead of rejecting them
+	SpoolDir       string        `koanf:"spool_dir"`       // Updates are scanned into temporary files here; the system temp directory when empty
+}
+
+// HooksConfig holds the content processing hooks run around file operations
+type HooksConfig struct {
+	SpoolDir             string           `koanf:"spool_dir"`              // Temporary files of content run through hooks (empty uses the system's)
+	PostWriteConcurrency int              `koanf:"post_write_concurrency"` // post_write hooks run at once
+	Rules                []HookRuleConfig `koanf:"rules"`
+}
+
+// HookRuleConfig binds a hook to the files below some path prefixes at one
+// stage of their operations
+type HookRuleConfig struct {
+	Name      string            `koanf:"name"`
+	Stage     string            `koanf:"stage"`      // "pre_write", "post_write" or "pre_read"
+	Prefixes  []string          `koanf:"prefixes"`   // Paths the hook applies to; all paths when empty
+	Type      string            `koanf:"type"`       // "exec", "http" or a hook compiled in with hooks.Register
+	Command   []string          `koanf:"command"`    // Command line of exec hooks
+	Env       []string          `koanf:"env"`        // Environment variables of the server exec hooks also get, besides PATH
+	URL       string            `koanf:"url"`        // Endpoint of http hooks
+	Options   map[string]string `koanf:"options"`    // Options of compiled-in hooks
+	Timeout   time.Duration     `koanf:"timeout"`    // Bound on each run (0 uses 30s)
+	OnFailure string            `koanf:"on_failure"` // "reject" or "ignore" content a pre_write or pre_read hook fails on
+	Transform bool              `koanf:"transform"`  // Replace the content with the hook's output
+}
+
+// SnapshotsConfig holds the point-in-time copies of directories served by
+// /v1/snapshots
+type SnapshotsConfig struct {
+	Enabled bool   `koanf:"enabled"`
+	Path    string `koanf:"path"` // Directory snapshots are kept below, read-only to clients
+}
+
+// LifecycleConfig holds the rules the lifecycle job applies to old files
+type LifecycleConfig struct {
+	Enabled bool                  `koanf:"enabled"`
+	DryRun  bool                  `koanf:"dry_run"` // Only log what the rules match
+	Rules   []LifecycleRuleConfig `koanf:"rules"`
+}
+
+// LifecycleRuleConfig acts on the entries below a path prefix that were last
+// modified long enough ago
+type LifecycleRuleConfig struct {
+	Name      string        `koanf:"name"`
+	Prefix    string        `koanf:"prefix"`     // Directory the rule covers, with everything below it
+	Pattern   string        `koanf:"pattern"`    // Glob matched against entry names, such as "*.log"; all entries when empty
+	OlderThan time.Duration `koanf:"older_than"` // Time since an entry was last modified
+	Action    string        `koanf:"action"`     // "delete", "move_to_s3" or "delete_empty_dirs"
+}
+
+// SearchConfig holds the indexing of files searched by /v1/search/content
+type SearchConfig struct {
+	Type            string        `koanf:"type"`              // "elasticsearch" or "opensearch"; empty disables indexing
+	URL             string        `koanf:"url"`               // Base URL of the cluster, such as http://localhost:9200
+	Index           string        `koanf:"index"`             // Index the documents are kept in
+	Username        string        `koanf:"username"`          // Basic authentication; none when empty
+	Password        string        `koanf:"password"`          // Basic authentication password
+	Timeout         time.Duration `koanf:"timeout"`           // Bound on each request to the cluster
+	Prefixes        []string      `koanf:"prefixes"`          // Paths indexed; all paths when empty
+	MaxContentBytes int64         `koanf:"max_content_bytes"` // Text indexed of each file (0 indexes metadata only)
+	PDFCommand      []string      `koanf:"pdf_command"`       // Writes the text of a PDF on stdin to stdout; empty leaves PDFs without text
+	PDFTimeout      time.Duration `koanf:"pdf_timeout"`       // Bound on pdf_command
+	Concurrency     int           `koanf:"concurrency"`       // Files indexed at once after writes
+}
+
+// AccessStatsConfig holds the download statistics served by /v1/stats
+type AccessStatsConfig struct {
+	Enabled       bool          `koanf:"enabled"`
+	FlushInterval time.Duration `koanf:"flush_interval"` // How often counts are written to the metadata store
+}
+
+// NFSConfig holds the NFSv3 gateway to the namespace
+type NFSConfig struct {
+	Enabled      bool          `koanf:"enabled"`
+	ListenAddr   string        `koanf:"listen_addr"`   // TCP address of the NFS and MOUNT programs
+	User         string        `koanf:"user"`          // CallFS user every NFS client acts as
+	AllowedCIDRs []string      `koanf:"allowed_cidrs"` // Clients allowed to connect; AUTH_SYS credentials are not verified
+	SpoolDir     string        `koanf:"spool_dir"`     // Holds files being written until they are stored; the system temp directory when empty
+	FlushDelay   time.Duration `koanf:"flush_delay"`   // Idle time after which uncommitted writes are stored
+}
+
+// PreviewConfig holds the thumbnails served by /v1/preview
+type PreviewConfig struct {
+	Enabled        bool          `koanf:"enabled"`
+	DefaultSize    int           `koanf:"default_size"`     // Width and height bound of previews a request doesn't size
+	MaxSize        int           `koanf:"max_size"`         // Largest width or height a request may ask for
+	MaxSourceBytes int64         `koanf:"max_source_bytes"` // Largest file a preview is rendered of
+	MaxPixels      int64         `koanf:"max_pixels"`       // Largest image, in pixels, a preview is rendered of
+	Quality        int           `koanf:"quality"`          // JPEG quality of previews, 1 to 100
+	Concurrency    int           `koanf:"concurrency"`      // Previews rendered at once
+	PDFCommand     []string      `koanf:"pdf_command"`      // Renders the first page of a PDF on stdin to PNG or JPEG on stdout; empty disables PDF previews
+	PDFTimeout     time.Duration `koanf:"pdf_timeout"`      // Bound on pdf_command
+}
+
 // InstanceDiscoveryConfig holds instance discovery configuration
 type InstanceDiscoveryConfig struct {
-	InstanceID    string            `koanf:"instance_id"`
-	PeerEndpoints map[string]string `koanf:"peer_endpoints"`
+	InstanceID            string            `koanf:"instance_id"`
+	PeerEndpoints         map[string]string `koanf:"peer_endpoints"`
+	InternalPeerEndpoints map[string]string `koanf:"internal_peer_endpoints"` // instance_id -> internal listener endpoint
+
+	// Peer health checking and circuit breaking for the internal proxy
+	PeerHealthCheckInterval time.Duration `koanf:"peer_health_check_interval"` // 0 disables background probes
+	PeerFailureThreshold    int           `koanf:"peer_failure_threshold"`     // Consecutive failures before a peer is skipped
+	PeerCircuitOpenDuration time.Duration `koanf:"peer_circuit_open_duration"` // How long a failed peer is skipped before a retry
+
+	// Dynamic peer discovery; discovered peers are added to PeerEndpoints
+	DiscoveryType     string        `koanf:"discovery_type"`     // "static" (default), "dns", "consul", "kubernetes" or "gossip"
+	DiscoveryInterval time.Duration `koanf:"discovery_interval"` // Poll interval, or the longest wait of a watch
+	DiscoveryScheme   string        `koanf:"discovery_scheme"`   // URL scheme of discovered endpoints; defaults from server.protocol
+
+	DNSSRVName string `koanf:"dns_srv_name"` // e.g. _callfs._tcp.callfs.default.svc.cluster.local
+
+	ConsulAddr    string `koanf:"consul_addr"` // Consul HTTP API address
+	ConsulService string `koanf:"consul_service"`
+	ConsulTag     string `koanf:"consul_tag"`
+	ConsulToken   string `koanf:"consul_token"`
+
+	KubernetesNamespace string `koanf:"kubernetes_namespace"` // Defaults to the pod's own namespace
+	KubernetesService   string `koanf:"kubernetes_service"`
+	KubernetesPortName  string `koanf:"kubernetes_port_name"` // Endpoint port to use; the first port when empty
+
+	GossipBindAddr      string   `koanf:"gossip_bind_addr"`      // host:port for gossip traffic (TCP and UDP)
+	GossipAdvertiseAddr string   `koanf:"gossip_advertise_addr"` // host:port other instances gossip with; bind address when empty
+	GossipJoin          []string `koanf:"gossip_join"`           // Gossip addresses of existing members
+	GossipSecretKey     string   `koanf:"gossip_secret_key"`     // Base64 AES key (16, 24 or 32 bytes) encrypting gossip
 }
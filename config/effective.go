@@ -0,0 +1,197 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// maskedValue replaces secrets in printed configurations
+const maskedValue = "***"
+
+// secretSettingNames are the settings, besides those named like secrets,
+// passwords and tokens, whose values are never printed
+var secretSettingNames = map[string]bool{
+	"api_keys":       true,
+	"admin_api_keys": true,
+	"s3_access_key":  true,
+}
+
+// dsnSettingNames are the settings holding database DSNs, printed without
+// their passwords
+var dsnSettingNames = map[string]bool{
+	"dsn":               true,
+	"read_replica_dsns": true,
+}
+
+// dsnPasswordPattern finds the password of a key=value DSN
+var dsnPasswordPattern = regexp.MustCompile(`(?i)(password=)('[^']*'|\S+)`)
+
+// MarshalEffective loads the configuration like LoadConfigFromFile and
+// encodes the settings in effect as "yaml" or "json", with secrets masked.
+// In YAML, each setting taken from the config file or the environment is
+// followed by a comment saying where it was set.
+func MarshalEffective(configFilePath, format string) ([]byte, error) {
+	if format != "yaml" && format != "json" {
+		return nil, fmt.Errorf("format must be yaml or json")
+	}
+	cfg, sources, err := loadConfig(configFilePath)
+	if err != nil {
+		return nil, err
+	}
+	node, err := settingsNode(reflect.ValueOf(cfg), "", sources)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	if format == "json" {
+		var settings any
+		if err := node.Decode(&settings); err != nil {
+			return nil, fmt.Errorf("failed to encode config: %w", err)
+		}
+		data, err := json.MarshalIndent(settings, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode config: %w", err)
+		}
+		return append(data, '\n'), nil
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// settingsNode encodes v, the setting key, in the order of the config
+// structs, with the keys of the config file
+func settingsNode(v reflect.Value, key string, sources *settingSources) (*yaml.Node, error) {
+	switch {
+	case v.Kind() == reflect.Struct:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		for i := range v.NumField() {
+			name := v.Type().Field(i).Tag.Get("koanf")
+			if name == "" {
+				continue
+			}
+			child, err := settingsNode(v.Field(i), joinKey(key, name), sources)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, keyNode(name, child), child)
+		}
+		return node, nil
+
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String && v.Len() > 0:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		names := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			names = append(names, k.String())
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			child, err := settingsNode(v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key())), joinKey(key, name), sources)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, keyNode(name, child), child)
+		}
+		annotateNode(node, key, sources)
+		return node, nil
+
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct:
+		// Items of lists of settings, such as hook rules, have no key of their own
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		for i := range v.Len() {
+			child, err := settingsNode(v.Index(i), key, sources)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+		annotateNode(node, key, sources)
+		return node, nil
+	}
+
+	node := &yaml.Node{}
+	if err := node.Encode(maskSetting(key[strings.LastIndex(key, ".")+1:], v.Interface())); err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	annotateNode(node, key, sources)
+	return node, nil
+}
+
+// keyNode returns the key of value in a mapping. The comment of a list or
+// mapping moves to its key, as YAML writes its items on the lines below.
+func keyNode(name string, value *yaml.Node) *yaml.Node {
+	key := &yaml.Node{Kind: yaml.ScalarNode, Value: name}
+	if value.Kind != yaml.ScalarNode {
+		key.LineComment, value.LineComment = value.LineComment, ""
+	}
+	return key
+}
+
+func annotateNode(node *yaml.Node, key string, sources *settingSources) {
+	if source := sources.source(key); source != "" {
+		node.LineComment = "from " + source
+	}
+}
+
+func joinKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// maskSetting returns the value to print for the setting name
+func maskSetting(name string, value any) any {
+	var mask func(string) string
+	switch {
+	case dsnSettingNames[name]:
+		mask = redactDSN
+	case secretSettingNames[name] || strings.Contains(name, "secret") || strings.Contains(name, "password") || strings.Contains(name, "token"):
+		mask = func(s string) string {
+			if s == "" {
+				return ""
+			}
+			return maskedValue
+		}
+	default:
+		return value
+	}
+
+	switch v := value.(type) {
+	case string:
+		return mask(v)
+	case []string:
+		masked := make([]string, len(v))
+		for i, s := range v {
+			masked[i] = mask(s)
+		}
+		return masked
+	}
+	return value
+}
+
+// redactDSN masks the password of a URL or key=value DSN
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		if _, set := u.User.Password(); set {
+			return strings.Replace(dsn, u.User.String(), url.User(u.User.Username()).String()+":"+maskedValue, 1)
+		}
+		return dsn
+	}
+	return dsnPasswordPattern.ReplaceAllString(dsn, "${1}"+maskedValue)
+}
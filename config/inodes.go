@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// InodeDefaults are the owner and modes of the inodes a user creates
+type InodeDefaults struct {
+	UID      int
+	GID      int
+	FileMode os.FileMode
+	DirMode  os.FileMode
+	Umask    os.FileMode
+}
+
+// ForUser returns the defaults of inodes created by userID: those of
+// server.inode_defaults with the user's overrides. The modes were checked
+// when the configuration was loaded.
+func (c InodeDefaultsConfig) ForUser(userID string) InodeDefaults {
+	d := InodeDefaults{UID: c.UID, GID: c.GID}
+	d.FileMode, _ = ParseOctalMode(c.FileMode)
+	d.DirMode, _ = ParseOctalMode(c.DirMode)
+	d.Umask, _ = ParseOctalMode(c.Umask)
+
+	user, ok := c.Users[userID]
+	if !ok {
+		return d
+	}
+	if user.UID != nil {
+		d.UID = *user.UID
+	}
+	if user.GID != nil {
+		d.GID = *user.GID
+	}
+	for _, override := range []struct {
+		value string
+		mode  *os.FileMode
+	}{{user.FileMode, &d.FileMode}, {user.DirMode, &d.DirMode}, {user.Umask, &d.Umask}} {
+		if override.value != "" {
+			*override.mode, _ = ParseOctalMode(override.value)
+		}
+	}
+	return d
+}
+
+// ParseOctalMode parses permission bits written in octal with a leading 0,
+// such as "0644" or "0o644". The leading 0 catches modes a YAML parser has
+// already turned into decimal numbers, which need quoting.
+func ParseOctalMode(s string) (os.FileMode, error) {
+	if !strings.HasPrefix(s, "0") {
+		return 0, fmt.Errorf("mode %q must be quoted octal with a leading 0, such as \"0644\"", s)
+	}
+	mode, err := strconv.ParseUint(strings.TrimPrefix(s, "0o"), 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("mode %q must be octal permission bits, such as \"0644\"", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// validate checks the modes and IDs of the defaults and of every override
+func (c InodeDefaultsConfig) validate() error {
+	if c.UID < 0 || c.GID < 0 {
+		return fmt.Errorf("server.inode_defaults.uid and server.inode_defaults.gid must not be negative")
+	}
+	for name, mode := range map[string]string{"file_mode": c.FileMode, "dir_mode": c.DirMode, "umask": c.Umask} {
+		if _, err := ParseOctalMode(mode); err != nil {
+			return fmt.Errorf("server.inode_defaults.%s: %w", name, err)
+		}
+	}
+	for userID, user := range c.Users {
+		prefix := "server.inode_defaults.users." + userID
+		if user.UID != nil && *user.UID < 0 || user.GID != nil && *user.GID < 0 {
+			return fmt.Errorf("%s: uid and gid must not be negative", prefix)
+		}
+		for name, mode := range map[string]string{"file_mode": user.FileMode, "dir_mode": user.DirMode, "umask": user.Umask} {
+			if mode == "" {
+				continue
+			}
+			if _, err := ParseOctalMode(mode); err != nil {
+				return fmt.Errorf("%s.%s: %w", prefix, name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ebogdum/callfs/internal/cron"
+)
+
+// GCSchedule returns the schedule of the gc job: jobs.gc.schedule, or every
+// gc.interval when it is not set
+func (c *AppConfig) GCSchedule() string {
+	if c.Jobs.GC.Schedule != "" {
+		return c.Jobs.GC.Schedule
+	}
+	return "@every " + c.GC.Interval.String()
+}
+
+// validateJobs checks the schedules of the background jobs, and resolves an
+// "auto" leader election to raft on raft nodes and to a lock manager lease
+// elsewhere
+func validateJobs(cfg *AppConfig) error {
+	raft := strings.ToLower(cfg.MetadataStore.Type) == "raft"
+	cfg.Jobs.LeaderElection = strings.ToLower(strings.TrimSpace(cfg.Jobs.LeaderElection))
+	switch cfg.Jobs.LeaderElection {
+	case "", "auto":
+		cfg.Jobs.LeaderElection = "lease"
+		if raft {
+			cfg.Jobs.LeaderElection = "raft"
+		}
+	case "lease":
+	case "raft":
+		if !raft {
+			return fmt.Errorf("jobs.leader_election=raft requires metadata_store.type=raft")
+		}
+	default:
+		return fmt.Errorf("jobs.leader_election must be one of: auto, lease, raft")
+	}
+
+	type job struct {
+		name     string
+		job      JobConfig
+		schedule string
+	}
+	jobs := []job{{"link_cleanup", cfg.Jobs.LinkCleanup, cfg.Jobs.LinkCleanup.Schedule}}
+	if cfg.GC.Enabled {
+		jobs = append(jobs, job{"gc", cfg.Jobs.GC, cfg.GCSchedule()})
+	}
+	if cfg.Lifecycle.Enabled {
+		jobs = append(jobs, job{"lifecycle", cfg.Jobs.Lifecycle, cfg.Jobs.Lifecycle.Schedule})
+	}
+	for _, j := range jobs {
+		if _, err := cron.Parse(j.schedule); err != nil {
+			return fmt.Errorf("jobs.%s.schedule is invalid: %w", j.name, err)
+		}
+		if j.job.Jitter < 0 || j.job.Timeout < 0 {
+			return fmt.Errorf("jobs.%s.jitter and jobs.%s.timeout must not be negative", j.name, j.name)
+		}
+	}
+	return nil
+}
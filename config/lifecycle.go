@@ -0,0 +1,44 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// validateLifecycle checks the lifecycle rules
+func validateLifecycle(cfg *AppConfig) error {
+	names := make(map[string]bool)
+	for i, rule := range cfg.Lifecycle.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("lifecycle.rules[%d].name is required", i)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("lifecycle.rules[%d].name %q is used by another rule", i, rule.Name)
+		}
+		names[rule.Name] = true
+
+		if !strings.HasPrefix(rule.Prefix, "/") {
+			return fmt.Errorf("lifecycle rule %q: prefix must be an absolute path", rule.Name)
+		}
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return fmt.Errorf("lifecycle rule %q: invalid pattern %q: %w", rule.Name, rule.Pattern, err)
+		}
+		if rule.OlderThan <= 0 {
+			return fmt.Errorf("lifecycle rule %q: older_than must be positive", rule.Name)
+		}
+		switch rule.Action {
+		case "delete", "delete_empty_dirs":
+		case "move_to_s3":
+			if cfg.Backend.S3BucketName == "" {
+				return fmt.Errorf("lifecycle rule %q: move_to_s3 requires backend.s3_bucket_name", rule.Name)
+			}
+		default:
+			return fmt.Errorf("lifecycle rule %q: action must be one of: delete, move_to_s3, delete_empty_dirs (got %q)", rule.Name, rule.Action)
+		}
+	}
+	if cfg.Lifecycle.Enabled && len(cfg.Lifecycle.Rules) == 0 {
+		return fmt.Errorf("lifecycle.rules must not be empty when lifecycle.enabled=true")
+	}
+	return nil
+}
@@ -1,21 +1,28 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/knadh/koanf/parsers/json"
+	"github.com/knadh/koanf/parsers/toml/v2"
 	"github.com/knadh/koanf/parsers/yaml"
 	"github.com/knadh/koanf/providers/env"
-	"github.com/knadh/koanf/providers/file"
+	"github.com/knadh/koanf/providers/rawbytes"
 	"github.com/knadh/koanf/providers/structs"
 	"github.com/knadh/koanf/v2"
 )
 
 // LoadConfig loads configuration from multiple sources with strict priority:
 // 1. Environment variables (highest priority)
-// 2. Config file (config.yaml or config.json)
+// 2. Config file (config.yaml, config.yml, config.json or config.toml)
 // 3. Defaults (lowest priority)
 func LoadConfig() (AppConfig, error) {
 	return LoadConfigFromFile("")
@@ -25,46 +32,41 @@ func LoadConfig() (AppConfig, error) {
 // 1. Environment variables (highest priority)
 // 2. Specified config file or default config files
 // 3. Defaults (lowest priority)
+//
+// Errors name the settings at fault and where they were set: the line of the
+// config file or the environment variable.
 func LoadConfigFromFile(configFilePath string) (AppConfig, error) {
+	cfg, _, err := loadConfig(configFilePath)
+	return cfg, err
+}
+
+// loadConfig loads the configuration and records where its settings came from
+func loadConfig(configFilePath string) (AppConfig, *settingSources, error) {
 	k := koanf.New(".")
+	sources := &settingSources{env: map[string]string{}}
 
 	// Load default configuration first
 	defaultCfg := DefaultAppConfig()
 	if err := k.Load(structs.Provider(defaultCfg, "koanf"), nil); err != nil {
-		return AppConfig{}, fmt.Errorf("failed to load default config: %w", err)
+		return AppConfig{}, nil, fmt.Errorf("failed to load default config: %w", err)
 	}
 
 	// Load from config file
 	if configFilePath != "" {
 		// Use specified config file
 		if _, err := os.Stat(configFilePath); err != nil {
-			return AppConfig{}, fmt.Errorf("specified config file %s not found: %w", configFilePath, err)
-		}
-
-		var parser koanf.Parser
-		if strings.HasSuffix(configFilePath, ".yaml") || strings.HasSuffix(configFilePath, ".yml") {
-			parser = yaml.Parser()
-		} else if strings.HasSuffix(configFilePath, ".json") {
-			parser = json.Parser()
+			return AppConfig{}, nil, fmt.Errorf("specified config file %s not found: %w", configFilePath, err)
 		}
-
-		if err := k.Load(file.Provider(configFilePath), parser); err != nil {
-			return AppConfig{}, fmt.Errorf("failed to load config file %s: %w", configFilePath, err)
+		if err := loadConfigFile(k, configFilePath, sources); err != nil {
+			return AppConfig{}, nil, err
 		}
 	} else {
 		// Load from default config files if they exist
-		configFiles := []string{"config.yaml", "config.yml", "config.json"}
+		configFiles := []string{"config.yaml", "config.yml", "config.json", "config.toml"}
 		for _, configFile := range configFiles {
 			if _, err := os.Stat(configFile); err == nil {
-				var parser koanf.Parser
-				if strings.HasSuffix(configFile, ".yaml") || strings.HasSuffix(configFile, ".yml") {
-					parser = yaml.Parser()
-				} else if strings.HasSuffix(configFile, ".json") {
-					parser = json.Parser()
-				}
-
-				if err := k.Load(file.Provider(configFile), parser); err != nil {
-					return AppConfig{}, fmt.Errorf("failed to load config file %s: %w", configFile, err)
+				if err := loadConfigFile(k, configFile, sources); err != nil {
+					return AppConfig{}, nil, err
 				}
 				break
 			}
@@ -73,26 +75,71 @@ func LoadConfigFromFile(configFilePath string) (AppConfig, error) {
 
 	// Load environment variables with CALLFS_ prefix
 	if err := k.Load(env.Provider("CALLFS_", ".", func(s string) string {
-		key := strings.TrimPrefix(s, "CALLFS_")
-		key = strings.ToLower(key)
-		key = strings.ReplaceAll(key, "__", ".")
+		key := envSettingKey(s)
+		if key != "" {
+			sources.env[key] = s
+		}
 		return key
 	}), nil); err != nil {
-		return AppConfig{}, fmt.Errorf("failed to load environment variables: %w", err)
+		return AppConfig{}, nil, fmt.Errorf("failed to load environment variables: %w", err)
+	}
+
+	// Replace file://, env://, vault:// and awssm:// references with their secrets
+	if err := resolveSecretRefs(k); err != nil {
+		return AppConfig{}, nil, fmt.Errorf("failed to resolve secret reference: %w", sources.annotate(err))
 	}
 
 	// Unmarshal into config struct
 	var cfg AppConfig
 	if err := k.Unmarshal("", &cfg); err != nil {
-		return AppConfig{}, fmt.Errorf("failed to unmarshal config: %w", err)
+		return AppConfig{}, nil, fmt.Errorf("failed to unmarshal config: %w", sources.annotate(err))
 	}
 
 	// Validate required fields
 	if err := validateConfig(&cfg); err != nil {
-		return AppConfig{}, fmt.Errorf("config validation failed: %w", err)
+		return AppConfig{}, nil, fmt.Errorf("config validation failed: %w", sources.annotate(err))
+	}
+
+	return cfg, sources, nil
+}
+
+// loadConfigFile merges the config file path into k, after checking that
+// every setting it has exists
+func loadConfigFile(k *koanf.Koanf, path string, sources *settingSources) error {
+	parser, format, err := configParser(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", path, err)
 	}
+	fileK := koanf.New(".")
+	if err := fileK.Load(rawbytes.Provider(data), parser); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	sources.file, sources.fileK, sources.lines = path, fileK, settingLines(data, format)
+	if err := sources.checkUnknown(); err != nil {
+		return err
+	}
+	if err := k.Merge(fileK); err != nil {
+		return fmt.Errorf("failed to load config file %s: %w", path, err)
+	}
+	return nil
+}
 
-	return cfg, nil
+// configParser returns the parser and format of a config file by its extension
+func configParser(path string) (koanf.Parser, string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return yaml.Parser(), "yaml", nil
+	case ".json":
+		return json.Parser(), "json", nil
+	case ".toml":
+		return toml.Parser(), "toml", nil
+	}
+	return nil, "", fmt.Errorf("config file %s must have a .yaml, .yml, .json or .toml extension", path)
 }
 
 // validateConfig validates that required configuration fields are set
@@ -101,6 +148,10 @@ func validateConfig(cfg *AppConfig) error {
 		return fmt.Errorf("server.listen_addr is required")
 	}
 
+	if addr := strings.TrimSpace(cfg.Server.InternalListenAddr); addr != "" && addr == strings.TrimSpace(cfg.Server.ListenAddr) {
+		return fmt.Errorf("server.internal_listen_addr must differ from server.listen_addr")
+	}
+
 	if cfg.Server.Protocol == "" {
 		cfg.Server.Protocol = "https"
 	}
@@ -126,6 +177,133 @@ func validateConfig(cfg *AppConfig) error {
 		}
 	}
 
+	if cfg.Server.EnableGRPC {
+		if cfg.Server.CertFile == "" || cfg.Server.KeyFile == "" {
+			return fmt.Errorf("server.cert_file and server.key_file are required when server.enable_grpc=true")
+		}
+		addr := strings.TrimSpace(cfg.Server.GRPCListenAddr)
+		if addr == "" {
+			return fmt.Errorf("server.grpc_listen_addr is required when server.enable_grpc=true")
+		}
+		if addr == strings.TrimSpace(cfg.Server.ListenAddr) || addr == strings.TrimSpace(cfg.Server.InternalListenAddr) {
+			return fmt.Errorf("server.grpc_listen_addr must differ from server.listen_addr and server.internal_listen_addr")
+		}
+	}
+
+	if cfg.Server.ReadTimeout < 0 || cfg.Server.WriteTimeout < 0 || cfg.Server.ReadHeaderTimeout < 0 || cfg.Server.IdleTimeout < 0 {
+		return fmt.Errorf("server.read_timeout, server.write_timeout, server.read_header_timeout and server.idle_timeout must not be negative")
+	}
+	if cfg.Server.MaxHeaderBytes < 0 {
+		return fmt.Errorf("server.max_header_bytes must not be negative")
+	}
+	if h2 := cfg.Server.HTTP2; h2.MaxConcurrentStreams < 0 || h2.MaxReceiveBufferPerStream < 0 || h2.MaxReceiveBufferPerConnection < 0 || h2.PingInterval < 0 {
+		return fmt.Errorf("server.http2 settings must not be negative")
+	}
+
+	if err := cfg.Server.TLS.validate(); err != nil {
+		return err
+	}
+	if err := cfg.Server.SecurityHeaders.validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.Server.InodeDefaults.validate(); err != nil {
+		return err
+	}
+
+	if cfg.Server.DrainDelay < 0 {
+		return fmt.Errorf("server.drain_delay must not be negative")
+	}
+	for _, prefix := range cfg.Server.ReadOnlyPrefixes {
+		if !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("server.read_only_prefixes: prefix %q must start with /", prefix)
+		}
+	}
+	if cfg.Server.StartupTimeout < 0 || cfg.Server.StartupRetryBackoff < 0 {
+		return fmt.Errorf("server.startup_timeout and server.startup_retry_backoff must not be negative")
+	}
+	if cfg.Server.HealthCheckTimeout <= 0 {
+		cfg.Server.HealthCheckTimeout = 3 * time.Second
+	}
+
+	if cfg.Metrics.BasicAuthUsername != "" && cfg.Metrics.BasicAuthPassword == "" {
+		return fmt.Errorf("metrics.basic_auth_password is required when metrics.basic_auth_username is set")
+	}
+	for _, cidr := range cfg.Metrics.AllowedCIDRs {
+		cidr = strings.TrimSpace(cidr)
+		if _, _, err := net.ParseCIDR(cidr); err != nil && net.ParseIP(cidr) == nil {
+			return fmt.Errorf("metrics.allowed_cidrs: %q is not a CIDR or IP address", cidr)
+		}
+	}
+
+	if cfg.Server.MaxFileSize <= 0 {
+		return fmt.Errorf("server.max_file_size must be > 0")
+	}
+	for prefix, limit := range cfg.Server.MaxFileSizeByPrefix {
+		if !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("server.max_file_size_by_prefix: prefix %q must start with /", prefix)
+		}
+		if limit <= 0 {
+			return fmt.Errorf("server.max_file_size_by_prefix: limit for %q must be > 0", prefix)
+		}
+	}
+
+	if cfg.MetadataCache.Enabled && (cfg.MetadataCache.TTL <= 0 || cfg.MetadataCache.MaxEntries <= 0) {
+		return fmt.Errorf("metadata_cache.ttl and metadata_cache.max_entries must be > 0 when the cache is enabled")
+	}
+	if cfg.MetadataCache.NegativeTTL < 0 {
+		return fmt.Errorf("metadata_cache.negative_ttl must not be negative")
+	}
+	if cfg.Log.SlowMetadataQuery < 0 || cfg.Log.SlowBackendOp < 0 || cfg.Log.SlowRequest < 0 {
+		return fmt.Errorf("log.slow_metadata_query, log.slow_backend_op and log.slow_request must not be negative")
+	}
+	if strings.TrimSpace(cfg.Log.Output) == "" {
+		cfg.Log.Output = "stderr"
+	}
+	if err := validateLogOutput(cfg.Log.Output); err != nil {
+		return fmt.Errorf("log.output: %w", err)
+	}
+	if err := validateLogOutput(cfg.Log.ErrorOutput); err != nil {
+		return fmt.Errorf("log.error_output: %w", err)
+	}
+	if r := cfg.Log.Rotation; r.MaxBytes < 0 || r.Interval < 0 || r.MaxBackups < 0 || r.MaxAge < 0 {
+		return fmt.Errorf("log.rotation settings must not be negative")
+	}
+
+	switch strings.ToLower(cfg.MetadataCache.Invalidation) {
+	case "", "auto", "postgres", "redis", "none":
+	default:
+		return fmt.Errorf("metadata_cache.invalidation must be one of: auto, postgres, redis, none")
+	}
+	if strings.EqualFold(cfg.MetadataCache.Invalidation, "postgres") && cfg.MetadataStore.DSN == "" {
+		return fmt.Errorf("metadata_cache.invalidation=postgres requires metadata_store.dsn")
+	}
+
+	if cfg.RateLimit.GlobalRPS < 0 || cfg.RateLimit.PerKeyRPS < 0 || cfg.RateLimit.PerIPRPS < 0 {
+		return fmt.Errorf("rate_limit rates must not be negative")
+	}
+	if cfg.RateLimit.MaxConcurrentUploads < 0 || cfg.RateLimit.MaxConcurrentUploadsPerKey < 0 {
+		return fmt.Errorf("rate_limit upload concurrency limits must not be negative")
+	}
+	if cfg.RateLimit.TransferBytesPerSec < 0 || cfg.RateLimit.LocalFSBytesPerSec < 0 || cfg.RateLimit.S3BytesPerSec < 0 {
+		return fmt.Errorf("rate_limit bandwidth limits must not be negative")
+	}
+	if rl := cfg.RateLimit; rl.LocalFSMaxConcurrentWrites < 0 || rl.S3MaxConcurrentWrites < 0 || rl.PeerMaxConcurrentWrites < 0 || rl.WriteQueueTimeout < 0 {
+		return fmt.Errorf("rate_limit concurrent write limits and rate_limit.write_queue_timeout must not be negative")
+	}
+	if cfg.RateLimit.AuthFailureLimit < 0 {
+		return fmt.Errorf("rate_limit.auth_failure_limit must not be negative")
+	}
+	if rl := cfg.RateLimit; rl.AuthFailureLimit > 0 && (rl.AuthFailureWindow <= 0 || rl.AuthLockout <= 0 || rl.AuthLockoutMax < rl.AuthLockout) {
+		return fmt.Errorf("rate_limit.auth_failure_window and rate_limit.auth_lockout must be positive, and rate_limit.auth_lockout_max at least rate_limit.auth_lockout")
+	}
+	for _, cidr := range cfg.RateLimit.TrustedProxies {
+		cidr = strings.TrimSpace(cidr)
+		if _, _, err := net.ParseCIDR(cidr); err != nil && net.ParseIP(cidr) == nil {
+			return fmt.Errorf("rate_limit.trusted_proxies: %q is not a CIDR or IP address", cidr)
+		}
+	}
+
 	if cfg.MetadataStore.Type == "" {
 		cfg.MetadataStore.Type = "postgres"
 	}
@@ -135,13 +313,36 @@ func validateConfig(cfg *AppConfig) error {
 		if cfg.MetadataStore.DSN == "" {
 			return fmt.Errorf("metadata_store.dsn is required when metadata_store.type=postgres")
 		}
+		if cfg.MetadataStore.MaxOpenConns < 0 || cfg.MetadataStore.MaxIdleConns < 0 {
+			return fmt.Errorf("metadata_store.max_open_conns and metadata_store.max_idle_conns must not be negative")
+		}
+		if cfg.MetadataStore.MaxOpenConns > 0 && cfg.MetadataStore.MaxIdleConns > cfg.MetadataStore.MaxOpenConns {
+			return fmt.Errorf("metadata_store.max_idle_conns must not exceed metadata_store.max_open_conns")
+		}
+		if cfg.MetadataStore.ConnMaxLifetime < 0 || cfg.MetadataStore.ConnMaxIdleTime < 0 {
+			return fmt.Errorf("metadata_store.conn_max_lifetime and metadata_store.conn_max_idle_time must not be negative")
+		}
+		if len(cfg.MetadataStore.ReadReplicaDSNs) > 0 {
+			if cfg.MetadataStore.ReplicaMaxLag < 0 {
+				return fmt.Errorf("metadata_store.replica_max_lag must not be negative")
+			}
+			if cfg.MetadataStore.ReplicaCheckInterval <= 0 {
+				return fmt.Errorf("metadata_store.replica_check_interval must be > 0 when read replicas are configured")
+			}
+		}
 	case "sqlite":
 		if cfg.MetadataStore.SQLitePath == "" {
 			return fmt.Errorf("metadata_store.sqlite_path is required when metadata_store.type=sqlite")
 		}
+		if strings.TrimSpace(cfg.MetadataStore.SQLiteBackupDir) == "" {
+			return fmt.Errorf("metadata_store.sqlite_backup_dir is required when metadata_store.type=sqlite")
+		}
 	case "redis":
-		if cfg.MetadataStore.RedisAddr == "" {
-			return fmt.Errorf("metadata_store.redis_addr is required when metadata_store.type=redis")
+		if cfg.MetadataStore.RedisAddr == "" && len(cfg.MetadataStore.RedisAddrs) == 0 {
+			return fmt.Errorf("metadata_store.redis_addr or metadata_store.redis_addrs is required when metadata_store.type=redis")
+		}
+		if err := cfg.MetadataStore.RedisConn().Validate(); err != nil {
+			return fmt.Errorf("metadata_store redis settings: %w", err)
 		}
 	case "raft":
 		if !cfg.Raft.Enabled {
@@ -171,6 +372,11 @@ func validateConfig(cfg *AppConfig) error {
 		if cfg.Raft.RetainSnapshotCount <= 0 {
 			return fmt.Errorf("raft.retain_snapshot_count must be > 0 when metadata_store.type=raft")
 		}
+		switch strings.ToLower(cfg.Raft.ReadConsistency) {
+		case "eventual", "linearizable":
+		default:
+			return fmt.Errorf("raft.read_consistency must be one of: eventual, linearizable")
+		}
 	default:
 		return fmt.Errorf("metadata_store.type must be one of: postgres, sqlite, redis, raft")
 	}
@@ -178,15 +384,28 @@ func validateConfig(cfg *AppConfig) error {
 	if cfg.DLM.Type == "" {
 		cfg.DLM.Type = "redis"
 	}
+	if cfg.DLM.LockTTL <= 0 {
+		cfg.DLM.LockTTL = 30 * time.Second
+	}
+	if cfg.DLM.LockTTL < 3*time.Second {
+		return fmt.Errorf("dlm.lock_ttl must be at least 3s")
+	}
 
 	switch strings.ToLower(cfg.DLM.Type) {
 	case "redis":
-		if cfg.DLM.RedisAddr == "" {
-			return fmt.Errorf("dlm.redis_addr is required when dlm.type=redis")
+		if cfg.DLM.RedisAddr == "" && len(cfg.DLM.RedisAddrs) == 0 {
+			return fmt.Errorf("dlm.redis_addr or dlm.redis_addrs is required when dlm.type=redis")
+		}
+		if err := cfg.DLM.RedisConn().Validate(); err != nil {
+			return fmt.Errorf("dlm redis settings: %w", err)
+		}
+	case "redlock":
+		if len(cfg.DLM.RedlockConns()) < 3 {
+			return fmt.Errorf("dlm.redlock_addrs must list at least 3 independent redis nodes when dlm.type=redlock")
 		}
 	case "local":
 	default:
-		return fmt.Errorf("dlm.type must be one of: redis, local")
+		return fmt.Errorf("dlm.type must be one of: redis, redlock, local")
 	}
 
 	if cfg.HA.ReplicationEnabled {
@@ -199,6 +418,21 @@ func validateConfig(cfg *AppConfig) error {
 	if cfg.InstanceDiscovery.InstanceID == "" {
 		return fmt.Errorf("instance_discovery.instance_id is required")
 	}
+	if cfg.InstanceDiscovery.PeerHealthCheckInterval < 0 {
+		return fmt.Errorf("instance_discovery.peer_health_check_interval must not be negative")
+	}
+	if cfg.InstanceDiscovery.PeerFailureThreshold <= 0 {
+		cfg.InstanceDiscovery.PeerFailureThreshold = 3
+	}
+	if cfg.InstanceDiscovery.PeerCircuitOpenDuration <= 0 {
+		cfg.InstanceDiscovery.PeerCircuitOpenDuration = 30 * time.Second
+	}
+	if err := validateDiscovery(cfg); err != nil {
+		return err
+	}
+	if err := validateRole(cfg); err != nil {
+		return err
+	}
 
 	if len(cfg.Auth.APIKeys) == 0 {
 		return fmt.Errorf("auth.api_keys must contain at least one key")
@@ -213,6 +447,24 @@ func validateConfig(cfg *AppConfig) error {
 		}
 	}
 
+	for _, key := range cfg.Auth.AdminAPIKeys {
+		if len(key) < 16 {
+			return fmt.Errorf("auth.admin_api_keys: each key must be at least 16 characters")
+		}
+		if slices.Contains(cfg.Auth.APIKeys, key) {
+			return fmt.Errorf("auth.admin_api_keys must not repeat a key from auth.api_keys")
+		}
+	}
+	if err := validateOIDC(cfg); err != nil {
+		return err
+	}
+	if cfg.Auth.ScopedTokenMaxTTL < 0 {
+		return fmt.Errorf("auth.scoped_token_max_ttl must not be negative")
+	}
+	if err := validateUserSettings(cfg); err != nil {
+		return err
+	}
+
 	if cfg.Erasure.Enabled {
 		if cfg.Erasure.DataShards < 2 {
 			cfg.Erasure.DataShards = 4
@@ -232,13 +484,379 @@ func validateConfig(cfg *AppConfig) error {
 		return fmt.Errorf("backend.default_backend must be one of: localfs, s3 (got %q)", cfg.Backend.DefaultBackend)
 	}
 
+	cfg.Backend.PlacementPolicy = strings.ToLower(strings.TrimSpace(cfg.Backend.PlacementPolicy))
+	switch cfg.Backend.PlacementPolicy {
+	case "":
+		cfg.Backend.PlacementPolicy = "local"
+	case "local", "hash", "capacity":
+		// valid
+	default:
+		return fmt.Errorf("backend.placement_policy must be one of: local, hash, capacity (got %q)", cfg.Backend.PlacementPolicy)
+	}
+
+	if cfg.GC.GracePeriod <= 0 {
+		return fmt.Errorf("gc.grace_period must be positive")
+	}
+	if cfg.GC.Enabled && cfg.GC.Interval <= 0 && cfg.Jobs.GC.Schedule == "" {
+		return fmt.Errorf("gc.interval must be positive when gc.enabled=true")
+	}
+	if err := validateJobs(cfg); err != nil {
+		return err
+	}
+
+	if err := validateScan(cfg); err != nil {
+		return err
+	}
+
+	if err := validateHooks(cfg); err != nil {
+		return err
+	}
+
+	if err := validateLifecycle(cfg); err != nil {
+		return err
+	}
+
+	if err := validatePreview(cfg); err != nil {
+		return err
+	}
+
+	if err := validateSearch(cfg); err != nil {
+		return err
+	}
+
+	if cfg.AccessStats.Enabled && cfg.AccessStats.FlushInterval <= 0 {
+		return fmt.Errorf("access_stats.flush_interval must be positive when access_stats.enabled=true")
+	}
+
+	if err := validateNFS(cfg); err != nil {
+		return err
+	}
+
+	if cfg.Snapshots.Enabled && (!strings.HasPrefix(cfg.Snapshots.Path, "/") || strings.Trim(cfg.Snapshots.Path, "/") == "") {
+		return fmt.Errorf("snapshots.path must be an absolute path below / when snapshots.enabled=true")
+	}
+
+	if cfg.Backend.S3QuotaBytes < 0 {
+		return fmt.Errorf("backend.s3_quota_bytes must not be negative")
+	}
+	if cfg.Backend.S3DownloadConcurrency < 0 {
+		return fmt.Errorf("backend.s3_download_concurrency must not be negative")
+	}
+	if cfg.Backend.S3DownloadPartSize < 0 {
+		return fmt.Errorf("backend.s3_download_part_size must not be negative")
+	}
+	if cfg.Backend.S3CacheDir != "" && cfg.Backend.S3CacheMaxBytes <= 0 {
+		return fmt.Errorf("backend.s3_cache_max_bytes must be > 0 when backend.s3_cache_dir is set")
+	}
+	switch cfg.Backend.S3ObjectLockMode {
+	case "", "GOVERNANCE", "COMPLIANCE":
+	default:
+		return fmt.Errorf("backend.s3_object_lock_mode must be one of: GOVERNANCE, COMPLIANCE")
+	}
+	if cfg.Backend.HoldMaxOwnerRetention < 0 {
+		return fmt.Errorf("backend.hold_max_owner_retention must not be negative")
+	}
+	if cfg.Backend.LocalFSMinFreeBytes < 0 {
+		return fmt.Errorf("backend.localfs_min_free_bytes must not be negative")
+	}
+	if cfg.Backend.LocalFSMinFreeInodes < 0 {
+		return fmt.Errorf("backend.localfs_min_free_inodes must not be negative")
+	}
+	if cfg.Backend.ReadBufferSize < 0 {
+		return fmt.Errorf("backend.read_buffer_size must not be negative")
+	}
+	if cfg.Backend.ReadAhead < 0 {
+		return fmt.Errorf("backend.read_ahead must not be negative")
+	}
+
+	if cfg.Backend.InternalProxyTimeout < 0 {
+		return fmt.Errorf("backend.internal_proxy_timeout must not be negative")
+	}
+	if cfg.Backend.InternalProxyDialTimeout <= 0 {
+		cfg.Backend.InternalProxyDialTimeout = 10 * time.Second
+	}
+	if cfg.Backend.InternalProxyHeaderTimeout < 0 {
+		return fmt.Errorf("backend.internal_proxy_header_timeout must not be negative")
+	}
+
 	if cfg.Auth.InternalProxySecret == "" || cfg.Auth.InternalProxySecret == "change-me-internal-secret" {
 		return fmt.Errorf("auth.internal_proxy_secret must be set and not use default value")
 	}
 
+	cfg.Auth.InternalRequestSigning = strings.ToLower(cfg.Auth.InternalRequestSigning)
+	switch cfg.Auth.InternalRequestSigning {
+	case "required", "optional", "off":
+	default:
+		return fmt.Errorf("auth.internal_request_signing must be one of: required, optional, off")
+	}
+
 	if cfg.Auth.SingleUseLinkSecret == "" || cfg.Auth.SingleUseLinkSecret == "change-me-link-secret" {
 		return fmt.Errorf("auth.single_use_link_secret must be set and not use default value")
 	}
 
+	for _, secret := range cfg.Auth.AcceptedInternalProxySecrets {
+		if len(secret) < 16 || secret == "change-me-internal-secret" {
+			return fmt.Errorf("auth.accepted_internal_proxy_secrets: each secret must be at least 16 characters and not use the default value")
+		}
+	}
+	for _, secret := range cfg.Auth.AcceptedSingleUseLinkSecrets {
+		if len(secret) < 16 || secret == "change-me-link-secret" {
+			return fmt.Errorf("auth.accepted_single_use_link_secrets: each secret must be at least 16 characters and not use the default value")
+		}
+	}
+
+	return nil
+}
+
+// validateHooks checks the content processing hook rules. Hook types other
+// than exec and http are checked when the hooks are created.
+func validateHooks(cfg *AppConfig) error {
+	if cfg.Hooks.PostWriteConcurrency < 1 {
+		return fmt.Errorf("hooks.post_write_concurrency must be at least 1")
+	}
+	names := make(map[string]bool)
+	for i, rule := range cfg.Hooks.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("hooks.rules[%d].name is required", i)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("hooks.rules[%d].name %q is used by another rule", i, rule.Name)
+		}
+		names[rule.Name] = true
+
+		switch rule.Stage {
+		case "pre_write", "pre_read":
+		case "post_write":
+			if rule.Transform {
+				return fmt.Errorf("hook %q: post_write hooks cannot transform content", rule.Name)
+			}
+		default:
+			return fmt.Errorf("hook %q: stage must be one of: pre_write, post_write, pre_read (got %q)", rule.Name, rule.Stage)
+		}
+		for _, prefix := range rule.Prefixes {
+			if !strings.HasPrefix(prefix, "/") {
+				return fmt.Errorf("hook %q: prefix %q must be an absolute path", rule.Name, prefix)
+			}
+		}
+		switch rule.Type {
+		case "exec":
+			if len(rule.Command) == 0 {
+				return fmt.Errorf("hook %q: command is required for exec hooks", rule.Name)
+			}
+		case "http":
+			if !strings.HasPrefix(rule.URL, "http://") && !strings.HasPrefix(rule.URL, "https://") {
+				return fmt.Errorf("hook %q: url must be an http:// or https:// URL for http hooks", rule.Name)
+			}
+		case "":
+			return fmt.Errorf("hook %q: type is required", rule.Name)
+		}
+		if rule.Timeout < 0 {
+			return fmt.Errorf("hook %q: timeout must not be negative", rule.Name)
+		}
+		switch rule.OnFailure {
+		case "", "reject", "ignore":
+		default:
+			return fmt.Errorf("hook %q: on_failure must be one of: reject, ignore (got %q)", rule.Name, rule.OnFailure)
+		}
+	}
+	return nil
+}
+
+// validatePreview checks the preview endpoint settings
+func validatePreview(cfg *AppConfig) error {
+	p := cfg.Preview
+	if !p.Enabled {
+		return nil
+	}
+	if p.MaxSize < 1 || p.DefaultSize < 1 || p.DefaultSize > p.MaxSize {
+		return fmt.Errorf("preview.default_size must be between 1 and preview.max_size (%d)", p.MaxSize)
+	}
+	if p.MaxSourceBytes <= 0 || p.MaxPixels <= 0 {
+		return fmt.Errorf("preview.max_source_bytes and preview.max_pixels must be positive")
+	}
+	if p.Quality < 1 || p.Quality > 100 {
+		return fmt.Errorf("preview.quality must be between 1 and 100")
+	}
+	if p.Concurrency < 1 {
+		return fmt.Errorf("preview.concurrency must be at least 1")
+	}
+	if len(p.PDFCommand) > 0 && p.PDFTimeout <= 0 {
+		return fmt.Errorf("preview.pdf_timeout must be positive when preview.pdf_command is set")
+	}
+	return nil
+}
+
+// validateNFS checks the NFS gateway settings
+func validateNFS(cfg *AppConfig) error {
+	n := cfg.NFS
+	if !n.Enabled {
+		return nil
+	}
+	addr := strings.TrimSpace(n.ListenAddr)
+	if addr == "" {
+		return fmt.Errorf("nfs.listen_addr is required when nfs.enabled=true")
+	}
+	if addr == strings.TrimSpace(cfg.Server.ListenAddr) || addr == strings.TrimSpace(cfg.Server.InternalListenAddr) ||
+		(cfg.Server.EnableGRPC && addr == strings.TrimSpace(cfg.Server.GRPCListenAddr)) {
+		return fmt.Errorf("nfs.listen_addr must differ from the server listen addresses")
+	}
+	if strings.TrimSpace(n.User) == "" {
+		return fmt.Errorf("nfs.user is required when nfs.enabled=true")
+	}
+	// AUTH_SYS credentials are not verified, so the network is the only
+	// check: every client is admitted only when listed, as 0.0.0.0/0
+	if len(n.AllowedCIDRs) == 0 {
+		return fmt.Errorf("nfs.allowed_cidrs is required when nfs.enabled=true; use 0.0.0.0/0 and ::/0 to admit every client")
+	}
+	for _, cidr := range n.AllowedCIDRs {
+		cidr = strings.TrimSpace(cidr)
+		if _, _, err := net.ParseCIDR(cidr); err != nil && net.ParseIP(cidr) == nil {
+			return fmt.Errorf("nfs.allowed_cidrs: %q is not a CIDR or IP address", cidr)
+		}
+	}
+	if n.FlushDelay <= 0 {
+		return fmt.Errorf("nfs.flush_delay must be positive when nfs.enabled=true")
+	}
+	return nil
+}
+
+// validateSearch checks the indexing settings
+func validateSearch(cfg *AppConfig) error {
+	s := cfg.Search
+	switch s.Type {
+	case "":
+		return nil
+	case "elasticsearch", "opensearch":
+		// valid
+	default:
+		return fmt.Errorf("search.type must be one of: elasticsearch, opensearch (got %q)", s.Type)
+	}
+	if u, err := url.Parse(s.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("search.url must be an http:// or https:// URL when search.type is set")
+	}
+	if s.Index == "" || strings.ContainsAny(s.Index, "/ ") {
+		return fmt.Errorf("search.index must be a name without slashes or spaces")
+	}
+	if s.Timeout <= 0 {
+		return fmt.Errorf("search.timeout must be positive")
+	}
+	for _, prefix := range s.Prefixes {
+		if !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("search.prefixes: prefix %q must start with /", prefix)
+		}
+	}
+	if s.MaxContentBytes < 0 {
+		return fmt.Errorf("search.max_content_bytes must not be negative")
+	}
+	if len(s.PDFCommand) > 0 && s.PDFTimeout <= 0 {
+		return fmt.Errorf("search.pdf_timeout must be positive when search.pdf_command is set")
+	}
+	if s.Concurrency < 1 {
+		return fmt.Errorf("search.concurrency must be at least 1")
+	}
+	return nil
+}
+
+// validateScan checks the upload scanning settings
+func validateScan(cfg *AppConfig) error {
+	switch cfg.Scan.Type {
+	case "":
+		return nil
+	case "clamd", "icap":
+		// valid
+	default:
+		return fmt.Errorf("scan.type must be one of: clamd, icap (got %q)", cfg.Scan.Type)
+	}
+	if cfg.Scan.Address == "" {
+		return fmt.Errorf("scan.address is required when scan.type is set")
+	}
+	if cfg.Scan.Timeout <= 0 {
+		return fmt.Errorf("scan.timeout must be positive")
+	}
+	switch cfg.Scan.Action {
+	case "reject":
+	case "quarantine":
+		if !strings.HasPrefix(cfg.Scan.QuarantinePath, "/") || strings.Trim(cfg.Scan.QuarantinePath, "/") == "" {
+			return fmt.Errorf("scan.quarantine_path must be an absolute path below / when scan.action=quarantine")
+		}
+	case "tag":
+		if cfg.Scan.TagXAttr == "" {
+			return fmt.Errorf("scan.tag_xattr is required when scan.action=tag")
+		}
+	default:
+		return fmt.Errorf("scan.action must be one of: reject, quarantine, tag (got %q)", cfg.Scan.Action)
+	}
+	return nil
+}
+
+// validateDiscovery checks the dynamic peer discovery settings
+func validateDiscovery(cfg *AppConfig) error {
+	d := &cfg.InstanceDiscovery
+	d.DiscoveryType = strings.ToLower(strings.TrimSpace(d.DiscoveryType))
+	if d.DiscoveryType == "" {
+		d.DiscoveryType = "static"
+	}
+	if d.DiscoveryInterval <= 0 {
+		d.DiscoveryInterval = 30 * time.Second
+	}
+	if d.DiscoveryScheme == "" {
+		d.DiscoveryScheme = "https"
+		if strings.ToLower(cfg.Server.Protocol) == "http" {
+			d.DiscoveryScheme = "http"
+		}
+	}
+	if d.DiscoveryScheme != "http" && d.DiscoveryScheme != "https" {
+		return fmt.Errorf("instance_discovery.discovery_scheme must be http or https")
+	}
+
+	switch d.DiscoveryType {
+	case "static":
+	case "dns":
+		if strings.TrimSpace(d.DNSSRVName) == "" {
+			return fmt.Errorf("instance_discovery.dns_srv_name is required when instance_discovery.discovery_type=dns")
+		}
+	case "consul":
+		if strings.TrimSpace(d.ConsulService) == "" {
+			return fmt.Errorf("instance_discovery.consul_service is required when instance_discovery.discovery_type=consul")
+		}
+		if strings.TrimSpace(d.ConsulAddr) == "" {
+			d.ConsulAddr = "http://127.0.0.1:8500"
+		}
+	case "kubernetes":
+		if strings.TrimSpace(d.KubernetesService) == "" {
+			return fmt.Errorf("instance_discovery.kubernetes_service is required when instance_discovery.discovery_type=kubernetes")
+		}
+	case "gossip":
+		if strings.TrimSpace(cfg.Server.ExternalURL) == "" {
+			return fmt.Errorf("server.external_url is required when instance_discovery.discovery_type=gossip; it is the endpoint advertised to other instances")
+		}
+		if strings.TrimSpace(d.GossipBindAddr) == "" {
+			d.GossipBindAddr = "0.0.0.0:7946"
+		}
+		if d.GossipSecretKey != "" {
+			key, err := base64.StdEncoding.DecodeString(d.GossipSecretKey)
+			if err != nil {
+				return fmt.Errorf("instance_discovery.gossip_secret_key must be base64: %w", err)
+			}
+			if n := len(key); n != 16 && n != 24 && n != 32 {
+				return fmt.Errorf("instance_discovery.gossip_secret_key must decode to 16, 24 or 32 bytes, got %d", n)
+			}
+		}
+	default:
+		return fmt.Errorf("instance_discovery.discovery_type must be one of: static, dns, consul, kubernetes, gossip")
+	}
+	return nil
+}
+
+// validateLogOutput checks the syslog address of a log output. Other outputs
+// are file paths, or empty for none.
+func validateLogOutput(output string) error {
+	if !strings.HasPrefix(output, "syslog:") && !strings.HasPrefix(output, "syslog+tcp:") {
+		return nil
+	}
+	u, err := url.Parse(output)
+	if err != nil || u.Host == "" || (u.Path != "" && u.Path != "/") || (u.Scheme != "syslog" && u.Scheme != "syslog+tcp") {
+		return fmt.Errorf("%q must be syslog, syslog://host:port or syslog+tcp://host:port", output)
+	}
 	return nil
 }
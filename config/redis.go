@@ -0,0 +1,67 @@
+package config
+
+import "github.com/ebogdum/callfs/internal/redisclient"
+
+// RedisConn returns the connection options for the Redis metadata store
+func (c MetadataStoreConfig) RedisConn() redisclient.Options {
+	return redisclient.Options{
+		Mode:             c.RedisMode,
+		Addrs:            redisAddrs(c.RedisAddrs, c.RedisAddr),
+		MasterName:       c.RedisMasterName,
+		Username:         c.RedisUsername,
+		Password:         c.RedisPassword,
+		SentinelPassword: c.RedisSentinelPassword,
+		DB:               c.RedisDB,
+		TLS:              c.RedisTLS,
+		TLSCAFile:        c.RedisTLSCAFile,
+		TLSSkipVerify:    c.RedisTLSSkipVerify,
+	}
+}
+
+// RedisConn returns the connection options for the Redis lock manager
+func (c DLMConfig) RedisConn() redisclient.Options {
+	return redisclient.Options{
+		Mode:             c.RedisMode,
+		Addrs:            redisAddrs(c.RedisAddrs, c.RedisAddr),
+		MasterName:       c.RedisMasterName,
+		Username:         c.RedisUsername,
+		Password:         c.RedisPassword,
+		SentinelPassword: c.RedisSentinelPassword,
+		TLS:              c.RedisTLS,
+		TLSCAFile:        c.RedisTLSCAFile,
+		TLSSkipVerify:    c.RedisTLSSkipVerify,
+	}
+}
+
+// RedlockConns returns one standalone connection per Redlock node
+func (c DLMConfig) RedlockConns() []redisclient.Options {
+	addrs := redisAddrs(c.RedlockAddrs, "")
+	conns := make([]redisclient.Options, 0, len(addrs))
+	for _, addr := range addrs {
+		conns = append(conns, redisclient.Options{
+			Mode:          redisclient.ModeStandalone,
+			Addrs:         []string{addr},
+			Username:      c.RedisUsername,
+			Password:      c.RedisPassword,
+			TLS:           c.RedisTLS,
+			TLSCAFile:     c.RedisTLSCAFile,
+			TLSSkipVerify: c.RedisTLSSkipVerify,
+		})
+	}
+	return conns
+}
+
+// redisAddrs prefers the multi-address list and falls back to the single
+// address kept for standalone deployments
+func redisAddrs(addrs []string, addr string) []string {
+	out := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		if a != "" {
+			out = append(out, a)
+		}
+	}
+	if len(out) == 0 && addr != "" {
+		out = append(out, addr)
+	}
+	return out
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Roles of a server process
+const (
+	RoleAll    = "all"    // Serve the API and run background jobs
+	RoleAPI    = "api"    // Serve the API only
+	RoleWorker = "worker" // Run background jobs only
+)
+
+// ServesAPI reports whether the process serves the file API
+func (c ServerConfig) ServesAPI() bool {
+	return c.Role != RoleWorker
+}
+
+// RunsJobs reports whether the process runs the background jobs: expired
+// link cleanup and garbage collection
+func (c ServerConfig) RunsJobs() bool {
+	return c.Role != RoleAPI
+}
+
+// SetRole overrides server.role, as the --role flag of callfs server does
+func (c *AppConfig) SetRole(role string) error {
+	c.Server.Role = role
+	return validateRole(c)
+}
+
+// validateRole checks server.role. Workers share the metadata store of the
+// API processes, which a raft node cannot without joining the cluster.
+func validateRole(cfg *AppConfig) error {
+	cfg.Server.Role = strings.ToLower(strings.TrimSpace(cfg.Server.Role))
+	switch cfg.Server.Role {
+	case "":
+		cfg.Server.Role = RoleAll
+	case RoleAll, RoleAPI, RoleWorker:
+	default:
+		return fmt.Errorf("server.role must be one of: all, api, worker")
+	}
+	if cfg.Server.Role == RoleWorker && strings.ToLower(cfg.MetadataStore.Type) == "raft" {
+		return fmt.Errorf("server.role=worker requires a postgres, redis or sqlite metadata store")
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/knadh/koanf/v2"
+)
+
+// Any string setting, or item of a list setting, may name where its value is
+// kept instead of holding it:
+//
+//	file:///run/secrets/api_key      content of the file, without trailing newlines
+//	env://VAR                        value of the environment variable VAR
+//	vault://secret/data/callfs#key   field of a HashiCorp Vault secret, read with VAULT_ADDR and VAULT_TOKEN
+//	awssm://name-or-arn[#key]        AWS Secrets Manager secret, or a field of its JSON value
+const (
+	fileSecretScheme  = "file://"
+	envSecretScheme   = "env://"
+	vaultSecretScheme = "vault://"
+	awsSecretScheme   = "awssm://"
+)
+
+// secretResolveTimeout bounds fetching every secret of a configuration
+const secretResolveTimeout = 30 * time.Second
+
+// resolveSecretRefs replaces the secret references among the loaded values
+// of k with the secrets they name. Errors name the setting, never a secret.
+func resolveSecretRefs(k *koanf.Koanf) error {
+	ctx, cancel := context.WithTimeout(context.Background(), secretResolveTimeout)
+	defer cancel()
+	r := &secretResolver{ctx: ctx, vault: map[string]map[string]any{}, aws: map[string]string{}}
+
+	for key, value := range k.All() {
+		switch v := value.(type) {
+		case string:
+			resolved, ok, err := r.resolve(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			if ok {
+				if err := k.Set(key, resolved); err != nil {
+					return fmt.Errorf("%s: %w", key, err)
+				}
+			}
+		case []any:
+			items := make([]any, len(v))
+			changed := false
+			for i, item := range v {
+				items[i] = item
+				s, isString := item.(string)
+				if !isString {
+					continue
+				}
+				resolved, ok, err := r.resolve(s)
+				if err != nil {
+					return fmt.Errorf("%s[%d]: %w", key, i, err)
+				}
+				if ok {
+					items[i], changed = resolved, true
+				}
+			}
+			if changed {
+				if err := k.Set(key, items); err != nil {
+					return fmt.Errorf("%s: %w", key, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// secretResolver fetches secrets, reading each Vault or AWS secret once
+type secretResolver struct {
+	ctx        context.Context
+	vault      map[string]map[string]any // Fields by secret path
+	aws        map[string]string         // Secret strings by ID
+	awsSession *session.Session
+}
+
+// resolve returns the secret value names, or false when value is not a
+// secret reference
+func (r *secretResolver) resolve(value string) (string, bool, error) {
+	var secret string
+	var err error
+	switch {
+	case strings.HasPrefix(value, fileSecretScheme):
+		secret, err = readSecretFile(strings.TrimPrefix(value, fileSecretScheme))
+	case strings.HasPrefix(value, envSecretScheme):
+		name := strings.TrimPrefix(value, envSecretScheme)
+		var set bool
+		if secret, set = os.LookupEnv(name); !set {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+	case strings.HasPrefix(value, vaultSecretScheme):
+		secret, err = r.vaultSecret(strings.TrimPrefix(value, vaultSecretScheme))
+	case strings.HasPrefix(value, awsSecretScheme):
+		secret, err = r.awsSecret(strings.TrimPrefix(value, awsSecretScheme))
+	default:
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return secret, true, nil
+}
+
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// vaultSecret reads a field of the secret at path, from a KV version 1 or 2
+// secrets engine
+func (r *secretResolver) vaultSecret(ref string) (string, error) {
+	path, field, _ := strings.Cut(ref, "#")
+	if path == "" || field == "" {
+		return "", fmt.Errorf("vault reference must be vault://<path>#<field>")
+	}
+	fields, ok := r.vault[path]
+	if !ok {
+		addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+		if addr == "" || token == "" {
+			return "", fmt.Errorf("VAULT_ADDR and VAULT_TOKEN must be set to read vault:// secrets")
+		}
+		req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+		if err != nil {
+			return "", fmt.Errorf("invalid Vault request: %w", err)
+		}
+		req.Header.Set("X-Vault-Token", token)
+		if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+			req.Header.Set("X-Vault-Namespace", namespace)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("failed to read Vault secret %s: %w", path, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("failed to read Vault secret %s: HTTP %d", path, resp.StatusCode)
+		}
+		var body struct {
+			Data map[string]any `json:"data"`
+		}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+			return "", fmt.Errorf("failed to decode Vault secret %s: %w", path, err)
+		}
+		fields = body.Data
+		// KV version 2 nests the fields under data, next to metadata
+		if nested, isV2 := fields["data"].(map[string]any); isV2 && fields["metadata"] != nil {
+			fields = nested
+		}
+		r.vault[path] = fields
+	}
+	value, ok := fields[field].(string)
+	if !ok {
+		return "", fmt.Errorf("no string field %s in Vault secret %s", field, path)
+	}
+	return value, nil
+}
+
+// awsSecret reads a secret from AWS Secrets Manager, with the credentials and
+// region of the environment, or the region of the secret's ARN
+func (r *secretResolver) awsSecret(ref string) (string, error) {
+	id, key, hasKey := strings.Cut(ref, "#")
+	if id == "" || hasKey && key == "" {
+		return "", fmt.Errorf("AWS Secrets Manager reference must be awssm://<name or ARN>[#<key>]")
+	}
+	value, ok := r.aws[id]
+	if !ok {
+		if r.awsSession == nil {
+			sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+			if err != nil {
+				return "", fmt.Errorf("failed to create AWS session: %w", err)
+			}
+			r.awsSession = sess
+		}
+		awsConfig := aws.NewConfig()
+		if parsed, err := arn.Parse(id); err == nil && parsed.Region != "" {
+			awsConfig = awsConfig.WithRegion(parsed.Region)
+		}
+		out, err := secretsmanager.New(r.awsSession, awsConfig).GetSecretValueWithContext(r.ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(id)})
+		if err != nil {
+			return "", fmt.Errorf("failed to read AWS secret %s: %w", id, err)
+		}
+		if out.SecretString == nil {
+			return "", fmt.Errorf("AWS secret %s is binary; only string secrets are supported", id)
+		}
+		value = *out.SecretString
+		r.aws[id] = value
+	}
+	if !hasKey {
+		return value, nil
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("AWS secret %s is not a JSON object", id)
+	}
+	field, ok := fields[key].(string)
+	if !ok {
+		return "", fmt.Errorf("AWS secret %s has no string key %s", id, key)
+	}
+	return field, nil
+}
@@ -0,0 +1,261 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/knadh/koanf/v2"
+	"go.yaml.in/yaml/v3"
+)
+
+// settingSources records where the loaded settings came from, so errors can
+// point at the line or environment variable to fix
+type settingSources struct {
+	file  string
+	fileK *koanf.Koanf      // Settings of the config file
+	lines map[string]int    // Line of each setting in the file, when known
+	env   map[string]string // Environment variable of each setting set by one
+}
+
+// source describes where key was set, or returns "" for a default
+func (s *settingSources) source(key string) string {
+	if name, ok := s.env[key]; ok {
+		return "environment variable " + name
+	}
+	if s.fileK == nil || !s.fileK.Exists(key) {
+		return ""
+	}
+	if line := s.lines[key]; line > 0 {
+		return fmt.Sprintf("%s line %d", s.file, line)
+	}
+	return s.file
+}
+
+// settingPattern finds dotted setting names in error messages
+var settingPattern = regexp.MustCompile(`[a-z][a-z0-9_]*(\.[a-z0-9_-]+)+`)
+
+// annotate appends to err where the settings it names were set
+func (s *settingSources) annotate(err error) error {
+	var notes []string
+	seen := map[string]bool{}
+	for _, key := range settingPattern.FindAllString(err.Error(), -1) {
+		if seen[key] || !knownSetting(key) {
+			continue
+		}
+		seen[key] = true
+		if source := s.source(key); source != "" {
+			notes = append(notes, key+" from "+source)
+		}
+	}
+	if len(notes) == 0 {
+		return err
+	}
+	return fmt.Errorf("%w (%s)", err, strings.Join(notes, "; "))
+}
+
+// checkUnknown rejects the settings of the config file that AppConfig does
+// not have, which are most often misspelled or misplaced
+func (s *settingSources) checkUnknown() error {
+	var unknown []string
+	for _, key := range s.fileK.Keys() {
+		if knownSetting(key) {
+			continue
+		}
+		if line := s.lines[key]; line > 0 {
+			unknown = append(unknown, fmt.Sprintf("%q (line %d)", key, line))
+		} else {
+			unknown = append(unknown, fmt.Sprintf("%q", key))
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	return fmt.Errorf("unknown settings in %s: %s", s.file, strings.Join(unknown, ", "))
+}
+
+// knownSetting reports whether key names a setting of AppConfig, or an entry
+// of one of its map settings. The entries of maps of settings, such as
+// per-user overrides, must be settings themselves.
+func knownSetting(key string) bool {
+	t := reflect.TypeOf(AppConfig{})
+	for _, part := range strings.Split(key, ".") {
+		switch t.Kind() {
+		case reflect.Map:
+			if t.Elem().Kind() != reflect.Struct {
+				return true
+			}
+			t = t.Elem()
+		case reflect.Struct:
+			field, ok := settingField(t, part)
+			if !ok {
+				return false
+			}
+			t = field.Type
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func settingField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := range t.NumField() {
+		if field := t.Field(i); field.Tag.Get("koanf") == name {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// envSettingKey returns the setting the CALLFS_ variable name sets, or ""
+// when it sets none. Nested keys are separated by a single underscore, as in
+// CALLFS_SERVER_LISTEN_ADDR, or by two, as in CALLFS_SERVER__LISTEN_ADDR.
+func envSettingKey(name string) string {
+	rest := strings.ToLower(strings.TrimPrefix(name, "CALLFS_"))
+	if strings.Contains(rest, "__") {
+		return strings.ReplaceAll(rest, "__", ".")
+	}
+	return matchEnvKey(reflect.TypeOf(AppConfig{}), rest)
+}
+
+// matchEnvKey splits the underscore-separated rest into the keys of the
+// settings of t: a field named rest, or else every field whose name starts it
+func matchEnvKey(t reflect.Type, rest string) string {
+	switch t.Kind() {
+	case reflect.Map:
+		return rest
+	case reflect.Struct:
+	default:
+		return ""
+	}
+	if _, ok := settingField(t, rest); ok {
+		return rest
+	}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("koanf")
+		if tag == "" {
+			continue
+		}
+		if remainder, ok := strings.CutPrefix(rest, tag+"_"); ok {
+			if key := matchEnvKey(field.Type, remainder); key != "" {
+				return tag + "." + key
+			}
+		}
+	}
+	return ""
+}
+
+// settingLines returns the line of each setting of a YAML, JSON or TOML
+// config file. Settings it cannot place are missing.
+func settingLines(data []byte, format string) map[string]int {
+	lines := map[string]int{}
+	switch format {
+	case "yaml":
+		var doc yaml.Node
+		if yaml.Unmarshal(data, &doc) == nil && len(doc.Content) > 0 {
+			yamlLines(doc.Content[0], "", lines)
+		}
+	case "json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		if tok, err := dec.Token(); err == nil && tok == json.Delim('{') {
+			_ = jsonLines(dec, data, "", lines)
+		}
+	case "toml":
+		tomlLines(data, lines)
+	}
+	return lines
+}
+
+func yamlLines(node *yaml.Node, prefix string, lines map[string]int) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := prefix + node.Content[i].Value
+		lines[key] = node.Content[i].Line
+		yamlLines(node.Content[i+1], key+".", lines)
+	}
+}
+
+// jsonLines records the keys of the object whose '{' dec has just read
+func jsonLines(dec *json.Decoder, data []byte, prefix string, lines map[string]int) error {
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		name, ok := tok.(string)
+		if !ok {
+			return errors.New("object key is not a string")
+		}
+		key := prefix + name
+		lines[key] = 1 + bytes.Count(data[:dec.InputOffset()], []byte("\n"))
+
+		if tok, err = dec.Token(); err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'):
+			err = jsonLines(dec, data, key+".", lines)
+		case json.Delim('['):
+			err = skipJSON(dec)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token() // Closing '}'
+	return err
+}
+
+// skipJSON skips the rest of the array or object whose opening dec has read
+func skipJSON(dec *json.Decoder) error {
+	for depth := 1; depth > 0; {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
+
+// tomlLines places the bare keys of TOML tables, which configurations use;
+// quoted keys and inline tables are left out
+func tomlLines(data []byte, lines map[string]int) {
+	table, inArray := "", false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "[["):
+			inArray = true // Items of arrays of tables are values of one setting
+		case strings.HasPrefix(line, "["):
+			inArray = false
+			name, _, _ := strings.Cut(strings.TrimPrefix(line, "["), "]")
+			table = strings.TrimSpace(name)
+			if table != "" {
+				lines[table] = n
+				table += "."
+			}
+		case !inArray:
+			name, _, found := strings.Cut(line, "=")
+			name = strings.TrimSpace(name)
+			if found && name != "" && !strings.ContainsAny(name, "\"' #") {
+				lines[table+name] = n
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// tlsVersions are the values of server.tls.min_version
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// http2CipherSuites are the TLS 1.2 suites HTTP/2 requires one of
+var http2CipherSuites = []string{
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+}
+
+// ServerTLS returns the TLS settings of the API listeners, without their
+// certificate. The config must have been validated.
+func (c TLSConfig) ServerTLS() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:             tlsVersions[c.MinVersion],
+		SessionTicketsDisabled: !c.SessionTickets,
+	}
+	for _, name := range c.CipherSuites {
+		for _, suite := range tls.CipherSuites() {
+			if suite.Name == name {
+				cfg.CipherSuites = append(cfg.CipherSuites, suite.ID)
+			}
+		}
+	}
+	return cfg
+}
+
+// validate checks the version and cipher suites
+func (c *TLSConfig) validate() error {
+	if c.MinVersion == "" {
+		c.MinVersion = "1.2"
+	}
+	if _, ok := tlsVersions[c.MinVersion]; !ok {
+		return fmt.Errorf("server.tls.min_version must be one of: 1.2, 1.3")
+	}
+	if len(c.CipherSuites) == 0 {
+		return nil
+	}
+	if c.MinVersion == "1.3" {
+		return fmt.Errorf("server.tls.cipher_suites apply to TLS 1.2 only and cannot be set with min_version 1.3")
+	}
+	for _, name := range c.CipherSuites {
+		if !slices.ContainsFunc(tls.CipherSuites(), func(suite *tls.CipherSuite) bool {
+			return suite.Name == name && slices.Contains(suite.SupportedVersions, tls.VersionTLS12)
+		}) {
+			return fmt.Errorf("server.tls.cipher_suites: %q is not a secure TLS 1.2 cipher suite", name)
+		}
+	}
+	if !slices.ContainsFunc(c.CipherSuites, func(name string) bool { return slices.Contains(http2CipherSuites, name) }) {
+		return fmt.Errorf("server.tls.cipher_suites must include %s, which HTTP/2 requires", strings.Join(http2CipherSuites, " or "))
+	}
+	return nil
+}
+
+// validate checks the header values that have a fixed set
+func (c *HeadersConfig) validate() error {
+	if c.HSTSMaxAge < 0 {
+		return fmt.Errorf("server.security_headers.hsts_max_age must not be negative")
+	}
+	if c.HSTSMaxAge%time.Second != 0 {
+		return fmt.Errorf("server.security_headers.hsts_max_age must be whole seconds")
+	}
+	c.FrameOptions = strings.ToUpper(c.FrameOptions)
+	switch c.FrameOptions {
+	case "", "DENY", "SAMEORIGIN":
+	default:
+		return fmt.Errorf("server.security_headers.frame_options must be one of: DENY, SAMEORIGIN, or empty")
+	}
+	return nil
+}
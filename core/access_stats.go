@@ -0,0 +1,243 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// accessStatsPageSize is how many entries are read per page when statistics
+// are aggregated
+const accessStatsPageSize = 1000
+
+// topLinkFiles is how many files LinkStats lists
+const topLinkFiles = 20
+
+// accessStatsState counts downloads between writes to the metadata store
+type accessStatsState struct {
+	store metadata.AccessStatsStore
+
+	mu      sync.Mutex
+	pending map[string]*metadata.AccessStats // Counts not yet written, by path
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// LinkStats aggregates the downloads of the files below a directory, and the
+// single-use links to them
+type LinkStats struct {
+	Path          string                  `json:"path"`
+	ActiveLinks   int                     `json:"active_links"`   // Links not yet used or expired
+	LinkDownloads int64                   `json:"link_downloads"` // Files downloaded through links
+	Downloads     int64                   `json:"downloads"`      // All downloads, including through links
+	BytesServed   int64                   `json:"bytes_served"`
+	Files         []*metadata.AccessStats `json:"files"` // Most downloaded through links first
+}
+
+// SetAccessStats makes downloads recorded with RecordAccess count towards
+// the statistics in store, written every flushInterval and when the engine
+// is closed
+func (e *Engine) SetAccessStats(store metadata.AccessStatsStore, flushInterval time.Duration) {
+	s := &accessStatsState{
+		store:   store,
+		pending: make(map[string]*metadata.AccessStats),
+		stop:    make(chan struct{}),
+	}
+	e.accessStats = s
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				e.flushAccessStats()
+				return
+			}
+			e.flushAccessStats()
+		}
+	}()
+}
+
+// AccessStatsEnabled reports whether SetAccessStats was called
+func (e *Engine) AccessStatsEnabled() bool {
+	return e.accessStats != nil
+}
+
+// stopAccessStats writes the pending counts and stops the writer, if any
+func (e *Engine) stopAccessStats() {
+	if e.accessStats != nil {
+		close(e.accessStats.stop)
+		e.accessStats.wg.Wait()
+	}
+}
+
+// RecordAccess counts a download of bytes from the file at path
+func (e *Engine) RecordAccess(path string, bytes int64, viaLink bool) {
+	s := e.accessStats
+	if s == nil {
+		return
+	}
+	delta := &metadata.AccessStats{Path: path, Downloads: 1, BytesServed: bytes, LastAccess: time.Now().UTC()}
+	if viaLink {
+		delta.LinkDownloads = 1
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st, ok := s.pending[path]; ok {
+		st.Add(delta)
+	} else {
+		s.pending[path] = delta
+	}
+}
+
+// flushAccessStats writes the pending counts to the store. Counts that fail
+// to be written are kept for the next attempt.
+func (e *Engine) flushAccessStats() {
+	s := e.accessStats
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = make(map[string]*metadata.AccessStats)
+	s.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	batch := make([]*metadata.AccessStats, 0, len(pending))
+	for _, st := range pending {
+		batch = append(batch, st)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := s.store.AddAccessStats(ctx, batch); err != nil {
+		e.logger.Warn("Failed to write access statistics", zap.Int("paths", len(batch)), zap.Error(err))
+		s.mu.Lock()
+		for path, st := range pending {
+			if newer, ok := s.pending[path]; ok {
+				st.Add(newer)
+			}
+			s.pending[path] = st
+		}
+		s.mu.Unlock()
+	}
+}
+
+// pendingAccessStats returns a copy of the counts of path not yet written
+func (e *Engine) pendingAccessStats(path string) *metadata.AccessStats {
+	s := e.accessStats
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st, ok := s.pending[path]; ok {
+		c := *st
+		return &c
+	}
+	return nil
+}
+
+// AccessStats returns the download statistics of the file at path: those
+// written by every instance, and those of this instance not yet written
+func (e *Engine) AccessStats(ctx context.Context, path string) (*metadata.AccessStats, error) {
+	if e.accessStats == nil {
+		return nil, fmt.Errorf("access statistics are disabled")
+	}
+	st, err := e.accessStats.store.GetAccessStats(ctx, path)
+	if errors.Is(err, metadata.ErrNotFound) {
+		st = &metadata.AccessStats{Path: path}
+	} else if err != nil {
+		return nil, err
+	}
+	if pending := e.pendingAccessStats(path); pending != nil {
+		st.Add(pending)
+	}
+	return st, nil
+}
+
+// clearAccessStats drops the statistics of path and of everything below it,
+// so a file created there later starts anew
+func (e *Engine) clearAccessStats(ctx context.Context, path string) {
+	s := e.accessStats
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	for p := range s.pending {
+		if p == path || strings.HasPrefix(p, path+"/") {
+			delete(s.pending, p)
+		}
+	}
+	s.mu.Unlock()
+	if err := s.store.DeleteAccessStats(ctx, path); err != nil {
+		e.ctxLogger(ctx).Warn("Failed to clear access statistics", zap.String("path", path), zap.Error(err))
+	}
+}
+
+// LinkStats aggregates the written download statistics of the files below
+// the directory at path, and counts the active single-use links to them.
+// Only files visible reports true for are listed by name.
+func (e *Engine) LinkStats(ctx context.Context, path string, visible func(path string) bool) (*LinkStats, error) {
+	if e.accessStats == nil {
+		return nil, fmt.Errorf("access statistics are disabled")
+	}
+	below := func(p string) bool {
+		return path == "/" || p == path || strings.HasPrefix(p, path+"/")
+	}
+
+	report := &LinkStats{Path: path, Files: []*metadata.AccessStats{}}
+	cursor := ""
+	for {
+		page, err := e.accessStats.store.ListAccessStats(ctx, path, accessStatsPageSize, cursor)
+		if err != nil {
+			return nil, err
+		}
+		for _, st := range page {
+			report.Downloads += st.Downloads
+			report.LinkDownloads += st.LinkDownloads
+			report.BytesServed += st.BytesServed
+			if st.LinkDownloads > 0 && visible(st.Path) {
+				report.Files = append(report.Files, st)
+			}
+		}
+		if len(page) < accessStatsPageSize {
+			break
+		}
+		cursor = page[len(page)-1].Path
+	}
+	sort.SliceStable(report.Files, func(i, j int) bool {
+		return report.Files[i].LinkDownloads > report.Files[j].LinkDownloads
+	})
+	if len(report.Files) > topLinkFiles {
+		report.Files = report.Files[:topLinkFiles]
+	}
+
+	now := time.Now()
+	cursor = ""
+	for {
+		links, err := e.metadataStore.ListSingleUseLinks(ctx, accessStatsPageSize, cursor)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list single-use links: %w", err)
+		}
+		for _, link := range links {
+			if link.Status == "active" && link.ExpiresAt.After(now) && below(link.FilePath) {
+				report.ActiveLinks++
+			}
+		}
+		if len(links) < accessStatsPageSize {
+			break
+		}
+		cursor = links[len(links)-1].Token
+	}
+	return report, nil
+}
@@ -0,0 +1,130 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ebogdum/callfs/locks"
+)
+
+// Lease limits of advisory locks
+const (
+	DefaultAdvisoryLockTTL = 60 * time.Second
+	MaxAdvisoryLockTTL     = time.Hour
+)
+
+var (
+	// ErrAdvisoryLocksUnsupported is returned when the lock manager cannot hold advisory locks
+	ErrAdvisoryLocksUnsupported = errors.New("advisory locks are not supported by the configured lock manager")
+
+	// ErrInvalidAdvisoryLock is returned for a malformed lock request
+	ErrInvalidAdvisoryLock = errors.New("invalid advisory lock request")
+)
+
+// AdvisoryLockRequest asks for an advisory lock on a file or byte range
+type AdvisoryLockRequest struct {
+	Mode       string `json:"mode"`        // read or write
+	Start      int64  `json:"start"`       // First byte to lock
+	Length     int64  `json:"length"`      // Bytes to lock; 0 locks up to any end of file
+	TTLSeconds int    `json:"ttl_seconds"` // Lease; DefaultAdvisoryLockTTL when 0
+}
+
+// SetAdvisoryLocker enables advisory locks held by l. The distributed lock
+// manager provides them when it supports them.
+func (e *Engine) SetAdvisoryLocker(l locks.AdvisoryLocker) {
+	e.advisoryLocker = l
+}
+
+// LockRange takes an advisory lock for owner on an existing path. Locks of
+// the same owner never conflict with each other, and the lease lapses unless
+// refreshed. A conflicting lock of another owner fails with an error naming
+// it, wrapping locks.ErrAdvisoryConflict.
+func (e *Engine) LockRange(ctx context.Context, path, owner string, req AdvisoryLockRequest) (*locks.AdvisoryLock, error) {
+	if e.advisoryLocker == nil {
+		return nil, ErrAdvisoryLocksUnsupported
+	}
+	switch {
+	case req.Mode != locks.AdvisoryRead && req.Mode != locks.AdvisoryWrite:
+		return nil, fmt.Errorf("%w: mode must be read or write", ErrInvalidAdvisoryLock)
+	case req.Start < 0 || req.Length < 0:
+		return nil, fmt.Errorf("%w: start and length must not be negative", ErrInvalidAdvisoryLock)
+	}
+	ttl, err := advisoryLockTTL(req.TTLSeconds)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := e.metadataStore.Get(ctx, path); err != nil {
+		return nil, err
+	}
+
+	id, err := newAdvisoryLockID()
+	if err != nil {
+		return nil, err
+	}
+	lock := locks.AdvisoryLock{
+		ID:        id,
+		Path:      path,
+		Owner:     owner,
+		Mode:      req.Mode,
+		Start:     req.Start,
+		Length:    req.Length,
+		ExpiresAt: time.Now().Add(ttl).UTC(),
+	}
+	if err := e.advisoryLocker.LockRange(ctx, lock); err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
+
+// RefreshRangeLock renews the lease of an advisory lock for ttlSeconds, or
+// DefaultAdvisoryLockTTL when 0
+func (e *Engine) RefreshRangeLock(ctx context.Context, path, id string, ttlSeconds int) (*locks.AdvisoryLock, error) {
+	if e.advisoryLocker == nil {
+		return nil, ErrAdvisoryLocksUnsupported
+	}
+	ttl, err := advisoryLockTTL(ttlSeconds)
+	if err != nil {
+		return nil, err
+	}
+	return e.advisoryLocker.RefreshRange(ctx, path, id, time.Now().Add(ttl).UTC())
+}
+
+// RangeLock returns the advisory lock id on path
+func (e *Engine) RangeLock(ctx context.Context, path, id string) (*locks.AdvisoryLock, error) {
+	if e.advisoryLocker == nil {
+		return nil, ErrAdvisoryLocksUnsupported
+	}
+	return e.advisoryLocker.AdvisoryLock(ctx, path, id)
+}
+
+// UnlockRange releases an advisory lock
+func (e *Engine) UnlockRange(ctx context.Context, path, id string) error {
+	if e.advisoryLocker == nil {
+		return ErrAdvisoryLocksUnsupported
+	}
+	return e.advisoryLocker.UnlockRange(ctx, path, id)
+}
+
+func advisoryLockTTL(seconds int) (time.Duration, error) {
+	maxSeconds := int(MaxAdvisoryLockTTL / time.Second)
+	switch {
+	case seconds == 0:
+		return DefaultAdvisoryLockTTL, nil
+	case seconds < 0 || seconds > maxSeconds:
+		return 0, fmt.Errorf("%w: ttl_seconds must be between 1 and %d", ErrInvalidAdvisoryLock, maxSeconds)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func newAdvisoryLockID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate lock ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,204 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/backends"
+	"github.com/ebogdum/callfs/backends/internalproxy"
+	"github.com/ebogdum/callfs/locks"
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// ErrInvalidAttributes is returned for a mode, owner or timestamp that cannot be stored
+var ErrInvalidAttributes = errors.New("invalid file attributes")
+
+// FileAttributes are client-supplied attributes of a file or directory, as
+// set by chmod, chown, touch and setfattr. Nil fields are left unchanged.
+type FileAttributes struct {
+	Mode   *os.FileMode // Permission bits only
+	UID    *int
+	GID    *int
+	ATime  *time.Time
+	MTime  *time.Time
+	XAttrs map[string]string // Extended attributes, kept by localfs only
+}
+
+// IsZero reports whether no attribute is set
+func (a FileAttributes) IsZero() bool {
+	return a.Mode == nil && a.UID == nil && a.GID == nil && a.ATime == nil && a.MTime == nil && len(a.XAttrs) == 0
+}
+
+// ChangesOwner reports whether applying a to md would change its UID or GID
+func (a FileAttributes) ChangesOwner(md *metadata.Metadata) bool {
+	return (a.UID != nil && *a.UID != md.UID) || (a.GID != nil && *a.GID != md.GID)
+}
+
+// ChangesMode reports whether a sets the mode, a timestamp or an extended
+// attribute of md. Explicit times count even when equal, as writing the file
+// would otherwise move them.
+func (a FileAttributes) ChangesMode(md *metadata.Metadata) bool {
+	return (a.Mode != nil && formatMode(*a.Mode) != md.Mode) || a.ATime != nil || a.MTime != nil || len(a.XAttrs) > 0
+}
+
+// apply copies the attributes set in a onto md
+func (a FileAttributes) apply(md *metadata.Metadata) {
+	if a.Mode != nil {
+		md.Mode = formatMode(*a.Mode)
+	}
+	if a.UID != nil {
+		md.UID = *a.UID
+	}
+	if a.GID != nil {
+		md.GID = *a.GID
+	}
+	if a.ATime != nil {
+		md.ATime = *a.ATime
+	}
+	if a.MTime != nil {
+		md.MTime = *a.MTime
+	}
+}
+
+// formatMode renders permission bits the way metadata stores them, like "0644"
+func formatMode(mode os.FileMode) string {
+	return fmt.Sprintf("%04o", uint32(mode.Perm()))
+}
+
+// SetAttributes stores client-supplied attributes in the metadata of path and
+// changes its ctime, as chmod and chown do. The mode and times are also
+// applied to the local filesystem of the instance owning the path, on a best
+// effort basis; the metadata stays authoritative. Extended attributes are only
+// kept there, so failing to set them fails the change. Held files, read-only
+// paths and snapshots keep their attributes.
+func (e *Engine) SetAttributes(ctx context.Context, path string, attrs FileAttributes) (*metadata.Metadata, error) {
+	if e.IsReadOnly(path) || e.IsSnapshotPath(path) {
+		return nil, backends.ErrReadOnly
+	}
+	if attrs.Mode != nil && *attrs.Mode&^os.ModePerm != 0 {
+		return nil, fmt.Errorf("%w: mode may only contain permission bits", ErrInvalidAttributes)
+	}
+	if (attrs.UID != nil && *attrs.UID < 0) || (attrs.GID != nil && *attrs.GID < 0) {
+		return nil, fmt.Errorf("%w: uid and gid must not be negative", ErrInvalidAttributes)
+	}
+
+	md, err := e.metadataStore.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	lockKey := fmt.Sprintf("file:%s", path)
+	if md.Type == "directory" {
+		lockKey = fmt.Sprintf("dir:%s", path)
+	}
+	token, acquired, err := e.lockManager.Acquire(ctx, lockKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	if !acquired {
+		return nil, fmt.Errorf("failed to acquire lock for attribute change")
+	}
+	defer func() {
+		if err := e.lockManager.Release(context.Background(), lockKey, token); err != nil {
+			e.ctxLogger(ctx).Error("Failed to release lock", zap.String("lock_key", lockKey), zap.Error(err))
+		}
+	}()
+	ctx, stopRenewal := locks.KeepAlive(ctx, e.lockManager, lockKey, token, e.logger)
+	defer stopRenewal()
+
+	// Re-read under the lock; a writer may have replaced the entry
+	if md, err = e.metadataStore.Get(ctx, path); err != nil {
+		return nil, err
+	}
+	if err := checkHold(md); err != nil {
+		return nil, err
+	}
+	if err := e.checkNotMoving(ctx, path); err != nil {
+		return nil, err
+	}
+	localFS := md.BackendType == "localfs" && !md.ErasureCoded
+	if len(attrs.XAttrs) > 0 && !localFS {
+		return nil, fmt.Errorf("%w: extended attributes are only kept by the localfs backend", ErrInvalidAttributes)
+	}
+	attrs.apply(md)
+	md.CTime = time.Now()
+	md.UpdatedAt = time.Now()
+	if err := e.metadataStore.Update(ctx, md); err != nil {
+		return nil, fmt.Errorf("failed to update metadata: %w", err)
+	}
+	e.invalidatePathAndParent(ctx, path)
+	if md.Type == "file" {
+		e.indexInBackground(ctx, path)
+	}
+
+	if localFS && (attrs.Mode != nil || attrs.ATime != nil || attrs.MTime != nil || len(attrs.XAttrs) > 0) {
+		if err := e.setLocalFSAttributes(ctx, md, attrs); err != nil {
+			if len(attrs.XAttrs) > 0 {
+				return nil, fmt.Errorf("failed to set extended attributes: %w", err)
+			}
+			e.ctxLogger(ctx).Warn("Failed to apply attributes to stored copy",
+				zap.String("path", path), zap.Error(err))
+		}
+	}
+	return md, nil
+}
+
+// setLocalFSAttributes applies the mode, times and extended attributes of
+// attrs to the copy of md held by its owning instance
+func (e *Engine) setLocalFSAttributes(ctx context.Context, md *metadata.Metadata, attrs FileAttributes) error {
+	req := internalproxy.AttributesRequest{Path: md.Path, Mode: attrs.Mode, XAttrs: attrs.XAttrs}
+	if attrs.ATime != nil {
+		req.ATime = *attrs.ATime
+	}
+	if attrs.MTime != nil {
+		req.MTime = *attrs.MTime
+	}
+
+	if md.CallFSInstanceID == nil || *md.CallFSInstanceID == e.currentInstanceID {
+		return e.LocalSetAttributes(ctx, req)
+	}
+	if e.internalProxyAdapter == nil {
+		return fmt.Errorf("%w: no peers are configured", internalproxy.ErrPeerUnavailable)
+	}
+	return e.internalProxyAdapter.SetAttributesOnInstance(ctx, *md.CallFSInstanceID, req)
+}
+
+// LocalSetAttributes changes the mode, times or extended attributes of a path
+// on this instance's local filesystem backend, without touching the metadata
+// store. A path that is not stored locally is not an error.
+func (e *Engine) LocalSetAttributes(ctx context.Context, req internalproxy.AttributesRequest) error {
+	if e.IsReadOnly(req.Path) {
+		return backends.ErrReadOnly
+	}
+	setter, ok := e.localFSBackend.(backends.AttributeSetter)
+	if !ok {
+		return nil
+	}
+	relativePath := strings.TrimPrefix(req.Path, "/")
+	err := setter.SetAttributes(ctx, relativePath, req.Mode, req.ATime, req.MTime)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return nil
+	}
+	if err != nil || len(req.XAttrs) == 0 {
+		return err
+	}
+
+	xattrSetter, ok := e.localFSBackend.(backends.XAttrSetter)
+	if !ok {
+		return fmt.Errorf("%w: the local filesystem backend cannot set extended attributes", ErrInvalidAttributes)
+	}
+	for _, name := range slices.Sorted(maps.Keys(req.XAttrs)) {
+		if err := xattrSetter.SetXAttr(ctx, relativePath, name, req.XAttrs[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,8 +2,10 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
@@ -23,7 +25,7 @@ func (e *Engine) selectBackend(ctx context.Context, md *metadata.Metadata) (cont
 		case "s3":
 			return ctx, e.s3Backend
 		default:
-			e.logger.Warn("Unknown backend type, defaulting to local FS",
+			e.ctxLogger(ctx).Warn("Unknown backend type, defaulting to local FS",
 				zap.String("backend_type", md.BackendType))
 			return ctx, e.localFSBackend
 		}
@@ -42,7 +44,7 @@ func (e *Engine) selectBackend(ctx context.Context, md *metadata.Metadata) (cont
 	case "s3":
 		return ctx, e.s3Backend
 	default:
-		e.logger.Warn("Unknown backend type, defaulting to local FS",
+		e.ctxLogger(ctx).Warn("Unknown backend type, defaulting to local FS",
 			zap.String("backend_type", md.BackendType))
 		return ctx, e.localFSBackend
 	}
@@ -62,47 +64,89 @@ func (e *Engine) selectBackendByType(backendType string) backends.Storage {
 
 // ensureParentDirectories creates parent directories if they don't exist
 func (e *Engine) ensureParentDirectories(ctx context.Context, path string, backendType string) error {
-	parentPath := filepath.Dir(path)
-	if parentPath == "/" || parentPath == "." {
-		return nil // Root directory should always exist
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		err = e.createMissingParents(ctx, path, backendType)
+		if !errors.Is(err, metadata.ErrAlreadyExists) {
+			return err
+		}
+		// A concurrent request created some of the same parents; retry with
+		// whatever is still missing
 	}
+	return err
+}
 
-	// Check if parent exists
-	if _, err := e.metadataStore.Get(ctx, parentPath); err == nil {
-		return nil // Parent exists
+// createMissingParents creates every missing ancestor of path, committing all
+// of their metadata in a single batch
+func (e *Engine) createMissingParents(ctx context.Context, path string, backendType string) error {
+	// Collect missing ancestors, nearest first
+	var missing []string
+	for dir := filepath.Dir(path); dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		_, err := e.metadataStore.Get(ctx, dir)
+		if err == nil {
+			break // Everything above an existing directory exists too
+		}
+		if !errors.Is(err, metadata.ErrNotFound) {
+			return fmt.Errorf("failed to check parent directory %s: %w", dir, err)
+		}
+		missing = append(missing, dir)
 	}
-
-	// Recursively ensure grandparent exists
-	if err := e.ensureParentDirectories(ctx, parentPath, backendType); err != nil {
-		return err
+	if len(missing) == 0 {
+		return nil
 	}
 
 	if backendType == "" {
 		backendType = "localfs"
 	}
+	storage := e.selectBackendByType(backendType)
+
+	// Create backend directories top-down, then commit the metadata in one
+	// batch so a failure never leaves a partial chain behind
+	now := time.Now()
+	ops := make([]metadata.BatchOp, 0, len(missing))
+	for i := len(missing) - 1; i >= 0; i-- {
+		dir := missing[i]
+		if err := storage.CreateDirectory(ctx, strings.TrimPrefix(dir, "/")); err != nil {
+			return fmt.Errorf("failed to create directory %s in backend: %w", dir, err)
+		}
 
-	// Create parent directory (world-writable so any authenticated user can create children)
-	parentMd := &metadata.Metadata{
-		Name:        filepath.Base(parentPath),
-		Type:        "directory",
-		Mode:        "0777",
-		UID:         0,
-		GID:         0,
-		BackendType: backendType,
+		// Parent directories are world-writable so any authenticated user can create children
+		parentMd := &metadata.Metadata{
+			Name:        filepath.Base(dir),
+			Path:        dir,
+			Type:        "directory",
+			Mode:        "0777",
+			UID:         0,
+			GID:         0,
+			BackendType: backendType,
+			ATime:       now,
+			MTime:       now,
+			CTime:       now,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		if backendType == "localfs" {
+			parentMd.CallFSInstanceID = &e.currentInstanceID
+		}
+		ops = append(ops, metadata.BatchOp{Type: metadata.BatchCreate, Metadata: parentMd})
 	}
 
-	err := e.CreateDirectory(ctx, parentPath, parentMd)
-	if err != nil && err == metadata.ErrAlreadyExists {
-		return nil // Suppress race: concurrent creates of same parent
+	if err := e.metadataStore.Batch(ctx, ops); err != nil {
+		return fmt.Errorf("failed to store parent directory metadata: %w", err)
 	}
-	return err
+
+	for _, dir := range missing {
+		e.invalidateCache(ctx, dir)
+	}
+
+	return nil
 }
 
 // EnsureRootDirectory ensures that the root directory metadata exists
 func (e *Engine) EnsureRootDirectory(ctx context.Context) error {
 	// Check if root directory already exists
 	if _, err := e.metadataStore.Get(ctx, "/"); err == nil {
-		e.logger.Debug("Root directory already exists")
+		e.ctxLogger(ctx).Debug("Root directory already exists")
 		return nil
 	}
 
@@ -124,6 +168,6 @@ func (e *Engine) EnsureRootDirectory(ctx context.Context) error {
 		return fmt.Errorf("failed to create root directory metadata: %w", err)
 	}
 
-	e.logger.Info("Root directory created successfully")
+	e.ctxLogger(ctx).Info("Root directory created successfully")
 	return nil
 }
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+
+	"github.com/ebogdum/callfs/backends"
+)
+
+// BandwidthLimits caps the bytes per second file transfers move. A zero
+// limit disables it.
+type BandwidthLimits struct {
+	PerTransfer int64 // Each upload or download
+	LocalFS     int64 // All transfers to and from this instance's local filesystem
+	S3          int64 // All transfers to and from S3
+}
+
+// bandwidthState holds the limiters of SetBandwidthLimits
+type bandwidthState struct {
+	perTransfer rate.Limit
+	backends    map[backends.Storage]*rate.Limiter // Shared by every transfer of a backend
+}
+
+// SetBandwidthLimits throttles the content of uploads and downloads
+func (e *Engine) SetBandwidthLimits(limits BandwidthLimits) {
+	e.bandwidth = bandwidthState{
+		perTransfer: rate.Limit(limits.PerTransfer),
+		backends:    make(map[backends.Storage]*rate.Limiter),
+	}
+	if limits.LocalFS > 0 {
+		e.bandwidth.backends[e.localFSBackend] = newByteLimiter(limits.LocalFS)
+	}
+	if limits.S3 > 0 {
+		e.bandwidth.backends[e.s3Backend] = newByteLimiter(limits.S3)
+	}
+}
+
+// newByteLimiter returns a limiter of bytesPerSec whose burst is one second
+// of transfer
+func newByteLimiter(bytesPerSec int64) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(bytesPerSec), int(bytesPerSec))
+}
+
+// throttle limits reads from reader, the content of one transfer to or from
+// storage, to the per-transfer rate and to storage's rate. Transfers through
+// the internal proxy are throttled by the owning instance, and erasure-coded
+// files, which have no storage, only per transfer. reader is returned as is
+// when no limit applies.
+func (e *Engine) throttle(reader io.Reader, storage backends.Storage) io.Reader {
+	limiters := e.limitersFor(storage)
+	if len(limiters) == 0 {
+		return reader
+	}
+	return newThrottledReader(reader, limiters)
+}
+
+// throttleReadCloser is throttle for content that must be closed
+func (e *Engine) throttleReadCloser(reader io.ReadCloser, storage backends.Storage) io.ReadCloser {
+	limiters := e.limitersFor(storage)
+	if len(limiters) == 0 {
+		return reader
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{newThrottledReader(reader, limiters), reader}
+}
+
+// throttles reports whether transfers to or from storage are throttled
+func (e *Engine) throttles(storage backends.Storage) bool {
+	_, limited := e.bandwidth.backends[storage]
+	return e.bandwidth.perTransfer > 0 || (storage != nil && limited)
+}
+
+// limitersFor returns the limiters of a new transfer to or from storage
+func (e *Engine) limitersFor(storage backends.Storage) []*rate.Limiter {
+	var limiters []*rate.Limiter
+	if e.bandwidth.perTransfer > 0 {
+		limiters = append(limiters, newByteLimiter(int64(e.bandwidth.perTransfer)))
+	}
+	if storage != nil {
+		if limiter, ok := e.bandwidth.backends[storage]; ok {
+			limiters = append(limiters, limiter)
+		}
+	}
+	return limiters
+}
+
+// throttledReader waits for tokens from every limiter for the bytes each read
+// returns. Reads are no larger than the smallest burst, so a wait can always
+// be satisfied. Waits ignore the transfer's context: its deadline bounds the
+// start of a transfer rather than its length, and a client that goes away
+// fails the next read or write instead.
+type throttledReader struct {
+	reader   io.Reader
+	limiters []*rate.Limiter
+	chunk    int
+}
+
+func newThrottledReader(reader io.Reader, limiters []*rate.Limiter) *throttledReader {
+	chunk := limiters[0].Burst()
+	for _, limiter := range limiters[1:] {
+		chunk = min(chunk, limiter.Burst())
+	}
+	return &throttledReader{reader: reader, limiters: limiters, chunk: chunk}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > t.chunk {
+		p = p[:t.chunk]
+	}
+	n, err := t.reader.Read(p)
+	for _, limiter := range t.limiters {
+		if waitErr := limiter.WaitN(context.Background(), n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
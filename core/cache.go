@@ -1,15 +1,32 @@
 package core
 
 import (
+	"container/list"
+	"hash/fnv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ebogdum/callfs/metadata"
+	"github.com/ebogdum/callfs/metrics"
 )
 
-// CacheEntry represents a cached metadata entry with expiration
+// cacheShardCount is the number of independently locked cache shards
+const cacheShardCount = 16
+
+// CacheOptions configures a MetadataCache
+type CacheOptions struct {
+	Enabled     bool
+	TTL         time.Duration // Lifetime of cached metadata
+	MaxEntries  int           // Maximum number of cached paths
+	NegativeTTL time.Duration // Lifetime of cached "not found" results (0 disables negative caching)
+}
+
+// CacheEntry represents a cached metadata entry with expiration. A nil
+// Metadata records that the path does not exist.
 type CacheEntry struct {
+	Path      string
 	Metadata  *metadata.Metadata
 	ExpiresAt time.Time
 }
@@ -19,22 +36,47 @@ func (e *CacheEntry) IsExpired() bool {
 	return time.Now().After(e.ExpiresAt)
 }
 
-// MetadataCache provides a simple in-memory cache for metadata with TTL support
+// cacheShard is an LRU list of entries guarded by its own lock. The front of
+// the list holds the most recently used entry.
+type cacheShard struct {
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List
+	capacity int
+}
+
+// MetadataCache is an in-memory LRU cache for metadata with TTL support.
+// Entries are spread over hashed shards so lookups on different paths do not
+// contend, and a path trie lets InvalidatePrefix visit only affected entries.
 type MetadataCache struct {
-	cache    map[string]*CacheEntry
-	mu       sync.RWMutex
-	ttl      time.Duration
-	maxSize  int
-	stopChan chan struct{}
+	shards      [cacheShardCount]*cacheShard
+	index       *prefixIndex
+	size        atomic.Int64
+	enabled     bool
+	ttl         time.Duration
+	negativeTTL time.Duration
+	stopChan    chan struct{}
 }
 
-// NewMetadataCache creates a new metadata cache with the specified TTL and max size
-func NewMetadataCache(ttl time.Duration, maxSize int) *MetadataCache {
+// NewMetadataCache creates a new metadata cache from opts
+func NewMetadataCache(opts CacheOptions) *MetadataCache {
+	// MaxEntries is split evenly across shards, so the effective limit is
+	// rounded up to a multiple of the shard count
+	capacity := (opts.MaxEntries + cacheShardCount - 1) / cacheShardCount
+
 	cache := &MetadataCache{
-		cache:    make(map[string]*CacheEntry),
-		ttl:      ttl,
-		maxSize:  maxSize,
-		stopChan: make(chan struct{}),
+		index:       newPrefixIndex(),
+		enabled:     opts.Enabled && opts.TTL > 0 && opts.MaxEntries > 0,
+		ttl:         opts.TTL,
+		negativeTTL: opts.NegativeTTL,
+		stopChan:    make(chan struct{}),
+	}
+	for i := range cache.shards {
+		cache.shards[i] = &cacheShard{
+			entries:  make(map[string]*list.Element),
+			lru:      list.New(),
+			capacity: capacity,
+		}
 	}
 
 	// Start background cleanup goroutine
@@ -43,23 +85,48 @@ func NewMetadataCache(ttl time.Duration, maxSize int) *MetadataCache {
 	return cache
 }
 
-// Get retrieves metadata from the cache
-func (c *MetadataCache) Get(path string) (*metadata.Metadata, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+// shardFor returns the shard responsible for path
+func (c *MetadataCache) shardFor(path string) *cacheShard {
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	return c.shards[h.Sum32()%cacheShardCount]
+}
 
-	entry, exists := c.cache[path]
-	if !exists {
+// Get retrieves metadata from the cache. found reports whether the path was
+// cached at all; a found entry with nil metadata is a cached "not found".
+func (c *MetadataCache) Get(path string) (md *metadata.Metadata, found bool) {
+	if !c.enabled {
 		return nil, false
 	}
 
+	shard := c.shardFor(path)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	elem, exists := shard.entries[path]
+	if !exists {
+		metrics.MetadataCacheRequestsTotal.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	entry := elem.Value.(*CacheEntry)
 	if entry.IsExpired() {
-		// Entry expired but we'll clean it up asynchronously
+		// Expired entries are cleaned up asynchronously
+		metrics.MetadataCacheRequestsTotal.WithLabelValues("miss").Inc()
 		return nil, false
 	}
+	shard.lru.MoveToFront(elem)
+
+	if entry.Metadata == nil {
+		metrics.MetadataCacheRequestsTotal.WithLabelValues("negative_hit").Inc()
+		return nil, true
+	}
+	metrics.MetadataCacheRequestsTotal.WithLabelValues("hit").Inc()
+	return cloneMetadata(entry.Metadata), true
+}
 
-	// Deep copy: clone pointer fields to prevent callers from mutating cached state
-	cp := *entry.Metadata
+// cloneMetadata deep-copies pointer fields so callers cannot mutate cached state
+func cloneMetadata(md *metadata.Metadata) *metadata.Metadata {
+	cp := *md
 	if cp.ParentID != nil {
 		v := *cp.ParentID
 		cp.ParentID = &v
@@ -72,69 +139,157 @@ func (c *MetadataCache) Get(path string) (*metadata.Metadata, bool) {
 		v := *cp.SymlinkTarget
 		cp.SymlinkTarget = &v
 	}
-	return &cp, true
+	return &cp
 }
 
 // Set stores metadata in the cache
 func (c *MetadataCache) Set(path string, md *metadata.Metadata) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	// Check if cache is at max capacity
-	if len(c.cache) >= c.maxSize {
-		// Simple eviction: remove one expired entry or oldest entry
-		c.evictOneEntry()
+	if md == nil {
+		return
 	}
+	c.store(path, md, c.ttl)
+}
 
-	c.cache[path] = &CacheEntry{
-		Metadata:  md,
-		ExpiresAt: time.Now().Add(c.ttl),
+// SetNotFound records that path does not exist, for the negative TTL
+func (c *MetadataCache) SetNotFound(path string) {
+	if c.negativeTTL <= 0 {
+		return
 	}
+	c.store(path, nil, c.negativeTTL)
 }
 
-// Invalidate removes an entry from the cache
-func (c *MetadataCache) Invalidate(path string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+func (c *MetadataCache) store(path string, md *metadata.Metadata, ttl time.Duration) {
+	if !c.enabled {
+		return
+	}
+
+	entry := &CacheEntry{Path: path, Metadata: md, ExpiresAt: time.Now().Add(ttl)}
+	shard := c.shardFor(path)
 
-	delete(c.cache, path)
+	// A path already cached is in the index, so replacing its entry needs
+	// only its shard. An invalidation that detached it from the index and
+	// has yet to reach its shard still removes it.
+	shard.mu.Lock()
+	replaced := c.replaceEntry(shard, entry)
+	shard.mu.Unlock()
+	if replaced {
+		return
+	}
+
+	// Lock order is index, then shard. Holding the index lock while the
+	// shard is updated keeps a concurrent InvalidatePrefix from missing
+	// an entry that is half inserted.
+	c.index.mu.Lock()
+	defer c.index.mu.Unlock()
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if c.replaceEntry(shard, entry) {
+		return
+	}
+
+	if shard.lru.Len() >= shard.capacity {
+		c.evictOldest(shard)
+	}
+
+	shard.entries[path] = shard.lru.PushFront(entry)
+	c.index.add(path)
+	metrics.MetadataCacheEntries.Set(float64(c.size.Add(1)))
 }
 
-// InvalidatePrefix removes all entries with the given path prefix (respecting path boundaries)
-func (c *MetadataCache) InvalidatePrefix(prefix string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// replaceEntry replaces the entry of entry.Path in shard, if it has one
+// (caller must hold the shard lock)
+func (c *MetadataCache) replaceEntry(shard *cacheShard, entry *CacheEntry) bool {
+	elem, exists := shard.entries[entry.Path]
+	if !exists {
+		return false
+	}
+	elem.Value = entry
+	shard.lru.MoveToFront(elem)
+	return true
+}
 
-	for path := range c.cache {
-		if path == prefix || strings.HasPrefix(path, prefix+"/") {
-			delete(c.cache, path)
-		}
+// evictOldest removes the least recently used entry of shard (caller must
+// hold the index and shard locks)
+func (c *MetadataCache) evictOldest(shard *cacheShard) {
+	elem := shard.lru.Back()
+	if elem == nil {
+		return
+	}
+	entry := elem.Value.(*CacheEntry)
+
+	reason := "capacity"
+	if entry.IsExpired() {
+		reason = "expired"
 	}
+	c.removeElement(shard, elem)
+	c.index.remove(entry.Path)
+	metrics.MetadataCacheEvictionsTotal.WithLabelValues(reason).Inc()
 }
 
-// Close stops the background cleanup goroutine
-func (c *MetadataCache) Close() {
-	close(c.stopChan)
+// removeElement drops elem from shard (caller must hold the shard lock)
+func (c *MetadataCache) removeElement(shard *cacheShard, elem *list.Element) {
+	shard.lru.Remove(elem)
+	delete(shard.entries, elem.Value.(*CacheEntry).Path)
+	metrics.MetadataCacheEntries.Set(float64(c.size.Add(-1)))
 }
 
-// evictOneEntry removes one entry to make space (caller must hold lock)
-func (c *MetadataCache) evictOneEntry() {
-	now := time.Now()
+// deleteEntry removes path from its shard, if present (caller must hold the index lock)
+func (c *MetadataCache) deleteEntry(path string) {
+	shard := c.shardFor(path)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
 
-	// First try to find an expired entry
-	for path, entry := range c.cache {
-		if now.After(entry.ExpiresAt) {
-			delete(c.cache, path)
-			return
-		}
+	if elem, exists := shard.entries[path]; exists {
+		c.removeElement(shard, elem)
 	}
+}
 
-	// If no expired entries, remove the first one we find
-	// In a production implementation, you might want LRU eviction
-	for path := range c.cache {
-		delete(c.cache, path)
+// Invalidate removes an entry from the cache
+func (c *MetadataCache) Invalidate(path string) {
+	c.index.mu.Lock()
+	defer c.index.mu.Unlock()
+
+	c.index.remove(path)
+	c.deleteEntry(path)
+}
+
+// InvalidatePrefix removes all entries with the given path prefix (respecting
+// path boundaries). A prefix of "/" only matches the root itself; use Clear to
+// drop everything.
+func (c *MetadataCache) InvalidatePrefix(prefix string) {
+	c.index.mu.Lock()
+	defer c.index.mu.Unlock()
+
+	if len(pathSegments(prefix)) == 0 {
+		c.index.remove("/")
+		c.deleteEntry("/")
 		return
 	}
+	for _, path := range c.index.detach(prefix) {
+		c.deleteEntry(path)
+	}
+}
+
+// Clear removes every entry from the cache
+func (c *MetadataCache) Clear() {
+	c.index.mu.Lock()
+	defer c.index.mu.Unlock()
+
+	c.index.root = &pathNode{}
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		shard.entries = make(map[string]*list.Element)
+		shard.lru.Init()
+		shard.mu.Unlock()
+	}
+	c.size.Store(0)
+	metrics.MetadataCacheEntries.Set(0)
+}
+
+// Close stops the background cleanup goroutine
+func (c *MetadataCache) Close() {
+	close(c.stopChan)
 }
 
 // cleanupExpiredEntries runs periodically to clean up expired cache entries
@@ -154,13 +309,130 @@ func (c *MetadataCache) cleanupExpiredEntries() {
 
 // performCleanup removes expired entries from the cache
 func (c *MetadataCache) performCleanup() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.index.mu.Lock()
+	defer c.index.mu.Unlock()
 
 	now := time.Now()
-	for path, entry := range c.cache {
-		if now.After(entry.ExpiresAt) {
-			delete(c.cache, path)
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		for _, elem := range shard.entries {
+			entry := elem.Value.(*CacheEntry)
+			if now.After(entry.ExpiresAt) {
+				c.removeElement(shard, elem)
+				c.index.remove(entry.Path)
+				metrics.MetadataCacheEvictionsTotal.WithLabelValues("expired").Inc()
+			}
 		}
+		shard.mu.Unlock()
+	}
+}
+
+// pathNode is one path segment in a prefixIndex
+type pathNode struct {
+	children map[string]*pathNode
+	cached   bool
+}
+
+// prefixIndex is a trie of cached paths split on "/", so a subtree of the
+// namespace can be found without scanning every cached entry
+type prefixIndex struct {
+	mu   sync.Mutex
+	root *pathNode
+}
+
+func newPrefixIndex() *prefixIndex {
+	return &prefixIndex{root: &pathNode{}}
+}
+
+// pathSegments splits an absolute path into its components; "/" has none
+func pathSegments(path string) []string {
+	trimmed := strings.Trim(path, "/")
+	if trimmed == "" {
+		return nil
+	}
+	return strings.Split(trimmed, "/")
+}
+
+// add marks path as cached (caller must hold mu)
+func (x *prefixIndex) add(path string) {
+	node := x.root
+	for _, seg := range pathSegments(path) {
+		child, ok := node.children[seg]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[string]*pathNode)
+			}
+			child = &pathNode{}
+			node.children[seg] = child
+		}
+		node = child
+	}
+	node.cached = true
+}
+
+// walk returns the nodes from the root down to segs, or nil if the path is
+// not in the index (caller must hold mu)
+func (x *prefixIndex) walk(segs []string) []*pathNode {
+	nodes := make([]*pathNode, 0, len(segs)+1)
+	node := x.root
+	nodes = append(nodes, node)
+	for _, seg := range segs {
+		child, ok := node.children[seg]
+		if !ok {
+			return nil
+		}
+		node = child
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// prune drops trailing nodes that no longer lead to any cached path
+func prune(nodes []*pathNode, segs []string) {
+	for i := len(segs); i > 0; i-- {
+		n := nodes[i]
+		if n.cached || len(n.children) > 0 {
+			return
+		}
+		delete(nodes[i-1].children, segs[i-1])
+	}
+}
+
+// remove unmarks path and prunes nodes left without cached descendants
+// (caller must hold mu)
+func (x *prefixIndex) remove(path string) {
+	segs := pathSegments(path)
+	nodes := x.walk(segs)
+	if nodes == nil {
+		return
+	}
+	nodes[len(nodes)-1].cached = false
+	prune(nodes, segs)
+}
+
+// detach removes prefix and everything below it from the index and returns
+// the cached paths that were there. prefix must not be the root (caller must
+// hold mu)
+func (x *prefixIndex) detach(prefix string) []string {
+	segs := pathSegments(prefix)
+	nodes := x.walk(segs)
+	if nodes == nil {
+		return nil
+	}
+
+	paths := collectPaths(nodes[len(nodes)-1], "/"+strings.Join(segs, "/"), nil)
+	delete(nodes[len(nodes)-2].children, segs[len(segs)-1])
+	prune(nodes[:len(nodes)-1], segs[:len(segs)-1])
+	return paths
+}
+
+// collectPaths appends every cached path at or below node
+func collectPaths(node *pathNode, path string, out []string) []string {
+	if node.cached {
+		out = append(out, path)
+	}
+	for seg, child := range node.children {
+		out = collectPaths(child, strings.TrimSuffix(path, "/")+"/"+seg, out)
 	}
+	return out
 }
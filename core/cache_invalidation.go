@@ -0,0 +1,65 @@
+package core
+
+import (
+	"context"
+	"path/filepath"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/invalidation"
+)
+
+// SetCacheInvalidator connects the metadata cache to a cross-instance
+// invalidation bus. Local invalidations are published to the bus, and
+// invalidations from other instances are applied until ctx is cancelled.
+func (e *Engine) SetCacheInvalidator(ctx context.Context, bus invalidation.Bus) error {
+	e.cacheInvalidator = bus
+	return bus.Subscribe(ctx, e.applyRemoteInvalidation)
+}
+
+// applyRemoteInvalidation evicts entries named by an invalidation from another instance
+func (e *Engine) applyRemoteInvalidation(msg invalidation.Message) {
+	if msg.Origin == e.currentInstanceID {
+		return
+	}
+	switch {
+	case msg.All:
+		e.metadataCache.Clear()
+	case msg.Prefix:
+		e.metadataCache.InvalidatePrefix(msg.Path)
+	default:
+		e.metadataCache.Invalidate(msg.Path)
+	}
+}
+
+// invalidateCache evicts path locally and on every other instance
+func (e *Engine) invalidateCache(ctx context.Context, path string) {
+	e.metadataCache.Invalidate(path)
+	e.publishInvalidation(ctx, invalidation.Message{Path: path})
+}
+
+// invalidateCachePrefix evicts prefix and everything below it locally and on
+// every other instance
+func (e *Engine) invalidateCachePrefix(ctx context.Context, prefix string) {
+	e.metadataCache.InvalidatePrefix(prefix)
+	e.publishInvalidation(ctx, invalidation.Message{Path: prefix, Prefix: true})
+}
+
+// invalidatePathAndParent evicts path and the entries under its parent
+// directory locally and on every other instance. Creates must evict path
+// itself so a cached "not found" doesn't outlive the new entry.
+func (e *Engine) invalidatePathAndParent(ctx context.Context, path string) {
+	e.invalidateCache(ctx, path)
+	e.invalidateCachePrefix(ctx, filepath.Dir(path))
+}
+
+func (e *Engine) publishInvalidation(ctx context.Context, msg invalidation.Message) {
+	if e.cacheInvalidator == nil {
+		return
+	}
+	msg.Origin = e.currentInstanceID
+	if err := e.cacheInvalidator.Publish(ctx, msg); err != nil {
+		e.ctxLogger(ctx).Warn("Failed to publish cache invalidation",
+			zap.String("path", msg.Path), zap.Error(err))
+	}
+}
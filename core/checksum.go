@@ -0,0 +1,188 @@
+package core
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// ErrChecksumMismatch is returned when uploaded content does not match the
+// checksum supplied by the client.
+var ErrChecksumMismatch = errors.New("content checksum mismatch")
+
+// ContentVerifier is implemented by readers that can confirm, once fully
+// consumed, that the content matched a client-supplied checksum. The engine
+// checks readers for this interface before committing metadata.
+type ContentVerifier interface {
+	Verify() error
+}
+
+// ChecksumReader hashes content as it is read and compares the digest with
+// an expected value when the underlying reader reaches EOF. On mismatch Read
+// returns ErrChecksumMismatch instead of io.EOF, so backends that write to a
+// temporary object abort rather than commit the bad content.
+type ChecksumReader struct {
+	reader    io.Reader
+	hash      hash.Hash
+	algorithm string
+	expected  func() string
+	done      bool
+	err       error
+}
+
+// NewChecksumReader wraps r with a checksum of the given algorithm (sha256,
+// sha1, crc32 or crc32c). expected is called once the content has been read,
+// which allows the digest to arrive in an HTTP trailer. The digest may be hex
+// or base64 encoded.
+func NewChecksumReader(r io.Reader, algorithm string, expected func() string) (*ChecksumReader, error) {
+	algorithm = strings.ToLower(strings.TrimSpace(algorithm))
+	var h hash.Hash
+	switch algorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha1":
+		h = sha1.New()
+	case "crc32":
+		h = crc32.NewIEEE()
+	case "crc32c":
+		h = crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+
+	return &ChecksumReader{
+		reader:    r,
+		hash:      h,
+		algorithm: algorithm,
+		expected:  expected,
+	}, nil
+}
+
+func (c *ChecksumReader) Read(p []byte) (int, error) {
+	if c.done {
+		if c.err != nil {
+			return 0, c.err
+		}
+		return 0, io.EOF
+	}
+
+	n, err := c.reader.Read(p)
+	if n > 0 {
+		c.hash.Write(p[:n])
+	}
+	if err == io.EOF {
+		c.done = true
+		c.err = c.compare()
+		if c.err != nil {
+			return n, c.err
+		}
+	}
+	return n, err
+}
+
+// Announced returns the expected checksum as "<algorithm>=<digest>" when it
+// was sent ahead of the content, and "" when it follows it in a trailer
+func (c *ChecksumReader) Announced() string {
+	if c.done {
+		return ""
+	}
+	if want := strings.TrimSpace(c.expected()); want != "" {
+		return c.algorithm + "=" + want
+	}
+	return ""
+}
+
+// Verify reports whether the full content was read and matched the expected checksum
+func (c *ChecksumReader) Verify() error {
+	if !c.done {
+		return fmt.Errorf("%w: content was not fully read", ErrChecksumMismatch)
+	}
+	return c.err
+}
+
+func (c *ChecksumReader) compare() error {
+	want := strings.TrimSpace(c.expected())
+	if want == "" {
+		return fmt.Errorf("%w: no %s checksum received", ErrChecksumMismatch, c.algorithm)
+	}
+
+	sum := c.hash.Sum(nil)
+	if strings.EqualFold(want, hex.EncodeToString(sum)) || want == base64.StdEncoding.EncodeToString(sum) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s digest does not match", ErrChecksumMismatch, c.algorithm)
+}
+
+// checksumFailure returns the mismatch seen by a ChecksumReader that reached
+// EOF, so backend errors caused by it can be reported as such
+func checksumFailure(reader io.Reader) error {
+	if c, ok := reader.(*ChecksumReader); ok && c.done {
+		return c.err
+	}
+	return nil
+}
+
+// verifyContent returns the verification result for readers carrying a checksum
+func verifyContent(reader io.Reader) error {
+	if v, ok := reader.(ContentVerifier); ok {
+		return v.Verify()
+	}
+	return nil
+}
+
+// ContentDigest computes the SHA-256 checksum metadata records for content as
+// it is read
+type ContentDigest struct {
+	reader io.Reader
+	hash   hash.Hash
+	size   int64 // Expected bytes, or negative when unknown
+	read   int64
+	eof    bool
+}
+
+// NewContentDigest wraps r, content of size bytes or of unknown size when
+// size is negative
+func NewContentDigest(r io.Reader, size int64) *ContentDigest {
+	return &ContentDigest{reader: r, hash: sha256.New(), size: size}
+}
+
+func (d *ContentDigest) Read(p []byte) (int, error) {
+	n, err := d.reader.Read(p)
+	if n > 0 {
+		d.hash.Write(p[:n])
+		d.read += int64(n)
+	}
+	if err == io.EOF {
+		d.eof = true
+	}
+	return n, err
+}
+
+// Sum returns the checksum as "sha256=<hex>", or an empty string when the
+// content was not read in full
+func (d *ContentDigest) Sum() string {
+	if !d.eof && (d.size < 0 || d.read != d.size) {
+		return ""
+	}
+	return "sha256=" + hex.EncodeToString(d.hash.Sum(nil))
+}
+
+// ContentVersion identifies the content of md: a prefix of its SHA-256 when
+// known, or a hash of its modification time and size otherwise
+func ContentVersion(md *metadata.Metadata) string {
+	version, ok := strings.CutPrefix(md.Checksum, "sha256=")
+	if ok && len(version) >= 16 {
+		return version[:16]
+	}
+	sum := sha256.Sum256(fmt.Appendf(nil, "%d/%d", md.MTime.UnixNano(), md.Size))
+	return hex.EncodeToString(sum[:8])
+}
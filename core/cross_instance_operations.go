@@ -5,39 +5,147 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/backends"
 	"github.com/ebogdum/callfs/backends/internalproxy"
 	"github.com/ebogdum/callfs/metadata"
+	"github.com/ebogdum/callfs/metrics"
 )
 
 // UpdateFileOnInstance updates a file on a specific instance using the internal proxy
 func (e *Engine) UpdateFileOnInstance(ctx context.Context, instanceID, path string, reader io.Reader, size int64) error {
+	return e.updateFileOnInstance(ctx, instanceID, path, reader, size, "")
+}
+
+// UpdateFileOnInstanceIfVersion updates a file on a specific instance, as
+// UpdateFileIfVersion does there. An empty version updates it unconditionally.
+func (e *Engine) UpdateFileOnInstanceIfVersion(ctx context.Context, instanceID, path string, reader io.Reader, size int64, version string) error {
+	return e.updateFileOnInstance(ctx, instanceID, path, reader, size, version)
+}
+
+func (e *Engine) updateFileOnInstance(ctx context.Context, instanceID, path string, reader io.Reader, size int64, version string) error {
+	if e.IsReadOnly(path) {
+		return backends.ErrReadOnly
+	}
+	if err := e.checkHoldOf(ctx, path); err != nil {
+		return err
+	}
+
 	// Use internal proxy with instance ID context
 	ctx = internalproxy.WithInstanceID(ctx, instanceID)
 
 	// Convert absolute path to relative path for backend
 	relativePath := strings.TrimPrefix(path, "/")
 
+	release, err := e.acquireWrite(ctx, "peer")
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	// Use the internal proxy backend to update the file
-	err := e.internalProxyBackend.Update(ctx, relativePath, reader, size)
+	upload := e.throttle(measureUpload(reader, "peer"), e.internalProxyBackend)
+	if version == "" {
+		err = e.internalProxyBackend.Update(ctx, relativePath, upload, size)
+	} else {
+		if e.internalProxyAdapter == nil {
+			return fmt.Errorf("internal proxy not configured: no peer endpoints available")
+		}
+		err = e.internalProxyAdapter.UpdateOnInstanceIfVersion(ctx, instanceID, relativePath, upload, size, version)
+	}
 	if err == nil {
 		// Invalidate local cache since remote state changed
-		e.metadataCache.Invalidate(path)
+		e.invalidateCache(ctx, path)
 	}
 	return err
 }
 
+// createFileOnInstance forwards the creation of a new file to the instance
+// chosen to own it and fills md with the metadata that instance stored
+func (e *Engine) createFileOnInstance(ctx context.Context, instanceID, path string, reader io.Reader, size int64, md *metadata.Metadata) error {
+	if _, err := e.metadataStore.Get(ctx, path); err == nil {
+		return metadata.ErrAlreadyExists
+	}
+
+	release, err := e.acquireWrite(ctx, "peer")
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// The owner creates the file for the client, with the attributes it asked
+	// for, and checks the content against its checksum too
+	sent := *md
+	sent.Checksum = ""
+	if c, ok := reader.(*ChecksumReader); ok {
+		sent.Checksum = c.Announced()
+	}
+
+	relativePath := strings.TrimPrefix(path, "/")
+	if err := e.internalProxyAdapter.CreateOnInstance(ctx, instanceID, relativePath, e.throttle(measureUpload(reader, "peer"), e.internalProxyBackend), size, &sent); err != nil {
+		if err == metadata.ErrAlreadyExists {
+			return err
+		}
+		if checksumErr := checksumFailure(reader); checksumErr != nil {
+			return checksumErr
+		}
+		return fmt.Errorf("failed to create file on instance %s: %w", instanceID, err)
+	}
+	metrics.PlacementForwardsTotal.WithLabelValues(instanceID).Inc()
+
+	// The owner stored the metadata; drop anything cached here before reading it
+	e.invalidatePathAndParent(ctx, path)
+	stored, err := e.metadataStore.Get(ctx, path)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata of file created on instance %s: %w", instanceID, err)
+	}
+	// Keep the caller's attributes; only what the owner assigned is taken
+	md.ID, md.ParentID, md.Path, md.Name = stored.ID, stored.ParentID, stored.Path, stored.Name
+	md.Size, md.Checksum, md.CallFSInstanceID = stored.Size, stored.Checksum, stored.CallFSInstanceID
+	md.CreatedAt, md.UpdatedAt = stored.CreatedAt, stored.UpdatedAt
+
+	e.ctxLogger(ctx).Info("File created on placed instance",
+		zap.String("path", path),
+		zap.String("instance_id", instanceID),
+		zap.Int64("size", md.Size))
+	return nil
+}
+
 // DeleteFileOnInstance deletes a file on a specific instance using the internal proxy
 func (e *Engine) DeleteFileOnInstance(ctx context.Context, instanceID, path string) error {
+	return e.deleteFileOnInstance(ctx, instanceID, path, time.Time{})
+}
+
+// DeleteFileOnInstanceIfUnmodified deletes a file on a specific instance, as
+// DeleteFileIfUnmodified does there
+func (e *Engine) DeleteFileOnInstanceIfUnmodified(ctx context.Context, instanceID, path string, since time.Time) error {
+	return e.deleteFileOnInstance(ctx, instanceID, path, since)
+}
+
+func (e *Engine) deleteFileOnInstance(ctx context.Context, instanceID, path string, unmodifiedSince time.Time) error {
 	if e.internalProxyAdapter == nil {
 		return fmt.Errorf("internal proxy not configured: no peer endpoints available")
 	}
+	if e.IsReadOnly(path) {
+		return backends.ErrReadOnly
+	}
+	if err := e.checkHoldOf(ctx, path); err != nil {
+		return err
+	}
 
 	relativePath := strings.TrimPrefix(path, "/")
-	err := e.internalProxyAdapter.DeleteOnInstance(ctx, instanceID, relativePath)
+	var err error
+	if unmodifiedSince.IsZero() {
+		err = e.internalProxyAdapter.DeleteOnInstance(ctx, instanceID, relativePath)
+	} else {
+		err = e.internalProxyAdapter.DeleteOnInstanceIfUnmodified(ctx, instanceID, relativePath, unmodifiedSince)
+	}
 	if err == nil {
 		// Invalidate local cache since remote state changed
-		e.metadataCache.Invalidate(path)
+		e.invalidateCache(ctx, path)
 	}
 	return err
 }
@@ -51,3 +159,54 @@ func (e *Engine) StatFileOnInstance(ctx context.Context, instanceID, path string
 	relativePath := strings.TrimPrefix(path, "/")
 	return e.internalProxyAdapter.StatOnInstance(ctx, instanceID, relativePath)
 }
+
+// PeerStatuses reports the health of every peer instance, or nil when no
+// peers are configured
+func (e *Engine) PeerStatuses() []internalproxy.PeerStatus {
+	if e.internalProxyAdapter == nil {
+		return nil
+	}
+	return e.internalProxyAdapter.PeerStatuses()
+}
+
+// replicaBackendFor returns a backend on this instance holding a copy of a
+// file owned by another instance: the shared S3 bucket, when the file lives
+// there or HA replication copies files there. It returns nil otherwise.
+func (e *Engine) replicaBackendFor(md *metadata.Metadata) backends.Storage {
+	if e.s3Backend == nil {
+		return nil
+	}
+	if md.BackendType == "s3" {
+		return e.s3Backend
+	}
+	if e.replicationEnabled && strings.EqualFold(strings.TrimSpace(e.replicaBackend), "s3") {
+		return e.s3Backend
+	}
+	return nil
+}
+
+// failoverToReplica retries a read against a replica after the owning peer
+// turned out to be unavailable. It returns peerErr when no replica serves it.
+func (e *Engine) failoverToReplica(ctx context.Context, md *metadata.Metadata, peerErr error, open func(backends.Storage) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	replica := e.replicaBackendFor(md)
+	if replica == nil {
+		return nil, peerErr
+	}
+
+	reader, err := open(replica)
+	if err != nil {
+		e.ctxLogger(ctx).Warn("Owning instance unavailable and replica read failed",
+			zap.String("path", md.Path),
+			zap.Error(err))
+		return nil, peerErr
+	}
+
+	instanceID := ""
+	if md.CallFSInstanceID != nil {
+		instanceID = *md.CallFSInstanceID
+	}
+	e.ctxLogger(ctx).Warn("Owning instance unavailable, serving file from replica",
+		zap.String("path", md.Path),
+		zap.String("instance_id", instanceID))
+	return reader, nil
+}
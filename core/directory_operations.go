@@ -2,12 +2,16 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/ebogdum/callfs/backends"
+	"github.com/ebogdum/callfs/locks"
 	"github.com/ebogdum/callfs/metadata"
 )
 
@@ -32,54 +36,86 @@ func (e *Engine) ListDirectory(ctx context.Context, path string) ([]*metadata.Me
 	return children, nil
 }
 
-// ListDirectoryRecursive lists directory contents recursively
-func (e *Engine) ListDirectoryRecursive(ctx context.Context, path string, maxDepth int) ([]*metadata.Metadata, error) {
-	if maxDepth < 0 {
-		maxDepth = 100 // Default maximum depth to prevent infinite recursion
-	}
+// descendantPageSize is the number of entries fetched per ListDescendants call
+const descendantPageSize = 1000
 
+// ListDirectoryRecursive lists directory contents recursively, down to
+// maxDepth levels below the immediate children
+func (e *Engine) ListDirectoryRecursive(ctx context.Context, path string, maxDepth int) ([]*metadata.Metadata, error) {
 	var allItems []*metadata.Metadata
-	return e.listDirectoryRecursiveHelper(ctx, path, 0, maxDepth, allItems)
+	err := e.WalkDirectoryRecursive(ctx, path, maxDepth, func(item *metadata.Metadata) error {
+		allItems = append(allItems, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return allItems, nil
 }
 
-// listDirectoryRecursiveHelper is the recursive helper function
-func (e *Engine) listDirectoryRecursiveHelper(ctx context.Context, path string, currentDepth, maxDepth int, allItems []*metadata.Metadata) ([]*metadata.Metadata, error) {
-	if currentDepth > maxDepth {
-		return allItems, nil
+// WalkDirectoryRecursive calls fn for the entries ListDirectoryRecursive
+// lists, in path order, as they are read from the metadata store. Listings
+// too large to hold are sent this way.
+func (e *Engine) WalkDirectoryRecursive(ctx context.Context, path string, maxDepth int, fn func(*metadata.Metadata) error) error {
+	if maxDepth < 0 {
+		maxDepth = 100 // Default maximum depth to prevent infinite recursion
 	}
 
-	// Get immediate children
-	children, err := e.ListDirectory(ctx, path)
+	md, err := e.metadataStore.Get(ctx, path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list directory %s: %w", path, err)
+		return fmt.Errorf("failed to get directory metadata: %w", err)
+	}
+	if md.Type != "directory" {
+		return fmt.Errorf("path is not a directory")
 	}
 
-	// Add all children to results
-	allItems = append(allItems, children...)
+	base := strings.TrimSuffix(path, "/")
+	err = e.walkDescendants(ctx, path, func(item *metadata.Metadata) error {
+		// Depth 0 is the immediate children of path
+		depth := strings.Count(strings.TrimPrefix(item.Path, base+"/"), "/")
+		if depth <= maxDepth {
+			return fn(item)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list directory %s: %w", path, err)
+	}
+	return nil
+}
 
-	// Recursively process subdirectories
-	for _, child := range children {
-		if child.Type == "directory" {
-			subItems, err := e.listDirectoryRecursiveHelper(ctx, child.Path, currentDepth+1, maxDepth, nil)
-			if err != nil {
-				e.logger.Warn("Failed to list subdirectory",
-					zap.String("path", child.Path),
-					zap.Error(err))
-				continue // Continue with other directories instead of failing completely
+// walkDescendants calls fn for every entry below path in path order, paging
+// through the metadata store
+func (e *Engine) walkDescendants(ctx context.Context, path string, fn func(*metadata.Metadata) error) error {
+	cursor := ""
+	for {
+		page, err := e.metadataStore.ListDescendants(ctx, path, descendantPageSize, cursor)
+		if err != nil {
+			return err
+		}
+		for _, item := range page {
+			if err := fn(item); err != nil {
+				return err
 			}
-			allItems = append(allItems, subItems...)
 		}
+		if len(page) < descendantPageSize {
+			return nil
+		}
+		cursor = page[len(page)-1].Path
 	}
-
-	return allItems, nil
 }
 
-// CreateDirectory creates a new directory
+// CreateDirectory creates a new directory. Read-only paths are refused with
+// backends.ErrReadOnly.
 func (e *Engine) CreateDirectory(ctx context.Context, path string, md *metadata.Metadata) error {
+	if e.IsReadOnly(path) {
+		return backends.ErrReadOnly
+	}
+
 	lockKey := fmt.Sprintf("dir:%s", path)
 
 	// Acquire distributed lock
-	acquired, err := e.lockManager.Acquire(ctx, lockKey)
+	token, acquired, err := e.lockManager.Acquire(ctx, lockKey)
 	if err != nil {
 		return fmt.Errorf("failed to acquire lock: %w", err)
 	}
@@ -87,15 +123,21 @@ func (e *Engine) CreateDirectory(ctx context.Context, path string, md *metadata.
 		return fmt.Errorf("failed to acquire lock for directory creation")
 	}
 	defer func() {
-		if err := e.lockManager.Release(context.Background(), lockKey); err != nil {
-			e.logger.Error("Failed to release lock", zap.String("lock_key", lockKey), zap.Error(err))
+		if err := e.lockManager.Release(context.Background(), lockKey, token); err != nil {
+			e.ctxLogger(ctx).Error("Failed to release lock", zap.String("lock_key", lockKey), zap.Error(err))
 		}
 	}()
 
+	ctx, stopRenewal := locks.KeepAlive(ctx, e.lockManager, lockKey, token, e.logger)
+	defer stopRenewal()
+
 	// Check if directory already exists
 	if _, err := e.metadataStore.Get(ctx, path); err == nil {
 		return metadata.ErrAlreadyExists
 	}
+	if err := e.checkNotMoving(ctx, path); err != nil {
+		return err
+	}
 
 	// Ensure parent directories exist
 	if err := e.ensureParentDirectories(ctx, path, md.BackendType); err != nil {
@@ -111,7 +153,10 @@ func (e *Engine) CreateDirectory(ctx context.Context, path string, md *metadata.
 	storage := e.selectBackendByType(md.BackendType)
 	// Convert absolute path to relative path for backend
 	relativePath := strings.TrimPrefix(path, "/")
-	if err := storage.CreateDirectory(ctx, relativePath); err != nil {
+	start := time.Now()
+	err = storage.CreateDirectory(ctx, relativePath)
+	e.observeBackendOp(ctx, md.BackendType, "mkdir", path, time.Since(start))
+	if err != nil {
 		return fmt.Errorf("failed to create directory in backend: %w", err)
 	}
 
@@ -125,15 +170,68 @@ func (e *Engine) CreateDirectory(ctx context.Context, path string, md *metadata.
 	if err := e.metadataStore.Create(ctx, md); err != nil {
 		// Attempt to clean up directory from backend
 		if deleteErr := storage.Delete(ctx, relativePath); deleteErr != nil {
-			e.logger.Error("Failed to cleanup directory after metadata creation failure",
+			e.ctxLogger(ctx).Error("Failed to cleanup directory after metadata creation failure",
 				zap.String("path", path), zap.Error(deleteErr))
 		}
 		return fmt.Errorf("failed to store metadata: %w", err)
 	}
 
-	e.logger.Info("Directory created successfully",
+	e.invalidatePathAndParent(ctx, path)
+
+	e.ctxLogger(ctx).Info("Directory created successfully",
 		zap.String("path", path),
 		zap.String("backend", md.BackendType))
 
 	return nil
 }
+
+// MakeDirectory creates path as a directory like mkdir, copying md for its
+// metadata. With parents, missing ancestors are created first and an existing
+// directory is not an error, like mkdir -p. It returns the directories it
+// created, outermost first, including those created before a failure.
+func (e *Engine) MakeDirectory(ctx context.Context, path string, parents bool, md *metadata.Metadata) ([]string, error) {
+	path = filepath.Clean(path)
+	if path == "/" {
+		if parents {
+			return []string{}, nil
+		}
+		return nil, metadata.ErrAlreadyExists
+	}
+
+	created := []string{}
+	elements := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	current := ""
+	for i, element := range elements {
+		current += "/" + element
+		last := i == len(elements)-1
+
+		existing, err := e.metadataStore.Get(ctx, current)
+		if errors.Is(err, metadata.ErrNotFound) {
+			if !parents && !last {
+				return created, err
+			}
+			dirMd := *md
+			dirMd.Name = element
+			err = e.CreateDirectory(ctx, current, &dirMd)
+			if err == nil {
+				created = append(created, current)
+				continue
+			}
+			if !errors.Is(err, metadata.ErrAlreadyExists) {
+				return created, err
+			}
+			// Created concurrently; check it like any existing entry
+			existing, err = e.metadataStore.Get(ctx, current)
+		}
+		if err != nil {
+			return created, err
+		}
+		if existing.Type != "directory" {
+			return created, fmt.Errorf("%s: %w", current, ErrNotDirectory)
+		}
+		if last && !parents {
+			return created, metadata.ErrAlreadyExists
+		}
+	}
+	return created, nil
+}
@@ -0,0 +1,52 @@
+package core
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// leaderStore is implemented by metadata stores with an elected leader, such
+// as raft
+type leaderStore interface {
+	IsLeader() bool
+	TransferLeadership(ctx context.Context, nodeID, raftAddr string) error
+}
+
+// Drain puts the instance in drain mode ahead of a shutdown: it rejects new
+// writes, reports itself unready to load balancers and unavailable to peers,
+// and lets the requests in progress finish. With handOffLeadership, a raft
+// leader hands leadership to another voter first, so the cluster keeps
+// accepting writes while this instance stops.
+func (e *Engine) Drain(ctx context.Context, handOffLeadership bool) error {
+	if !e.draining.Swap(true) {
+		e.logger.Info("Instance draining")
+		e.advertise()
+	}
+	if !handOffLeadership {
+		return nil
+	}
+	store, ok := e.metadataStore.Store.(leaderStore)
+	if !ok || !store.IsLeader() {
+		return nil
+	}
+	if err := store.TransferLeadership(ctx, "", ""); err != nil {
+		return fmt.Errorf("failed to hand off raft leadership: %w", err)
+	}
+	e.logger.Info("Raft leadership handed off for drain", zap.String("instance_id", e.currentInstanceID))
+	return nil
+}
+
+// Undrain takes the instance out of drain mode
+func (e *Engine) Undrain() {
+	if e.draining.Swap(false) {
+		e.logger.Info("Instance no longer draining")
+		e.advertise()
+	}
+}
+
+// Draining reports whether the instance is in drain mode
+func (e *Engine) Draining() bool {
+	return e.draining.Load()
+}
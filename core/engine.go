@@ -1,32 +1,70 @@
 package core
 
 import (
+	"context"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/ebogdum/callfs/backends"
 	"github.com/ebogdum/callfs/backends/internalproxy"
+	"github.com/ebogdum/callfs/backends/noop"
+	corelog "github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/discovery"
 	"github.com/ebogdum/callfs/erasure"
+	"github.com/ebogdum/callfs/hooks"
+	"github.com/ebogdum/callfs/invalidation"
 	"github.com/ebogdum/callfs/locks"
 	"github.com/ebogdum/callfs/metadata"
 )
 
 // Engine represents the core CallFS engine that orchestrates operations
 type Engine struct {
-	metadataStore        metadata.Store
+	metadataStore        *instrumentedStore
 	localFSBackend       backends.Storage
 	s3Backend            backends.Storage
 	internalProxyBackend backends.Storage
 	internalProxyAdapter *internalproxy.InternalProxyAdapter // Direct access for instance-specific methods
 	lockManager          locks.Manager
 	currentInstanceID    string
+	peerMu               sync.RWMutex
 	peerEndpoints        map[string]string // Instance ID -> endpoint URL
 	replicationEnabled   bool
 	replicaBackend       string
 	requireReplicaAck    bool
 	erasureManager       *erasure.Manager
 	metadataCache        *MetadataCache
+	readConsistency      metadata.ReadConsistency
+	cacheInvalidator     invalidation.Bus     // Optional cross-instance cache invalidation
+	membership           discovery.Membership // Optional gossip membership
+	migrationsMu         sync.Mutex
+	migrations           map[string]*migrationJob
+	transfersMu          sync.Mutex
+	transfers            map[string]*Transfer // Uploads tracked by client ID, by user
+	placement            *placer              // Placement of new localfs files; local when nil
+	advisoryLocker       locks.AdvisoryLocker // Client byte-range locks; unsupported when nil
+	s3QuotaBytes         int64                // Capacity StatFS reports for S3; unlimited when 0
+	usageCache           usageCache
+	health               healthCache
+	directoryUsageCache  directoryUsageCache
+	gc                   gcState
+	intentJournal        metadata.IntentJournal // Crash recovery of file operations; disabled when nil
+	bandwidth            bandwidthState
+	writeLimits          writeLimitState
+	stream               *streamState      // Set by SetStreamTuning
+	scanning             *scanState        // Malware scanning of uploads; disabled when nil
+	hooks                *hooks.Runner     // Content processing hooks; none when nil
+	previews             *previewState     // Set by SetPreviewer
+	slowBackendOp        time.Duration     // Backend operations logged as slow; none when 0
+	draining             atomic.Bool       // Set by Drain
+	readOnly             readOnlyState     // Set by SetReadOnly
+	snapshotRoot         string            // Directory of snapshots; disabled when empty
+	holdPolicy           HoldPolicy        // Set by SetHoldPolicy
+	lifecycle            lifecycleState    // Set by SetLifecycleRules
+	indexing             *indexState       // Set by SetSearchIndex
+	accessStats          *accessStatsState // Set by SetAccessStats
 	logger               *zap.Logger
 }
 
@@ -43,10 +81,11 @@ func NewEngine(
 	replicationEnabled bool,
 	replicaBackend string,
 	requireReplicaAck bool,
+	cacheOptions CacheOptions,
 	logger *zap.Logger,
 ) *Engine {
 	return &Engine{
-		metadataStore:        metadataStore,
+		metadataStore:        &instrumentedStore{Store: metadataStore, logger: logger},
 		localFSBackend:       localFSBackend,
 		s3Backend:            s3Backend,
 		internalProxyBackend: internalProxyBackend,
@@ -57,7 +96,7 @@ func NewEngine(
 		replicationEnabled:   replicationEnabled,
 		replicaBackend:       replicaBackend,
 		requireReplicaAck:    requireReplicaAck,
-		metadataCache:        NewMetadataCache(5*time.Minute, 1000), // 5 min TTL, max 1000 entries
+		metadataCache:        NewMetadataCache(cacheOptions),
 		logger:               logger,
 	}
 }
@@ -77,15 +116,78 @@ func (e *Engine) GetErasureManager() *erasure.Manager {
 	return e.erasureManager
 }
 
+// GetLockManager returns the distributed lock manager
+func (e *Engine) GetLockManager() locks.Manager {
+	return e.lockManager
+}
+
 // Close shuts down the engine and releases background resources.
 func (e *Engine) Close() {
+	e.cancelMigrations()
+	e.stopPlacement()
+	e.stopAccessStats()
+	e.stopIndexing()
 	e.metadataCache.Close()
 }
 
 // GetPeerEndpoint returns the endpoint URL for a given instance ID
 func (e *Engine) GetPeerEndpoint(instanceID string) string {
+	e.peerMu.RLock()
+	defer e.peerMu.RUnlock()
 	if endpoint, exists := e.peerEndpoints[instanceID]; exists {
 		return endpoint
 	}
 	return ""
 }
+
+// SetPeerEndpoints replaces the known peer endpoints, including those used by
+// the internal proxy
+func (e *Engine) SetPeerEndpoints(peerEndpoints map[string]string) {
+	e.peerMu.Lock()
+	e.peerEndpoints = peerEndpoints
+	e.peerMu.Unlock()
+	if e.internalProxyAdapter != nil {
+		e.internalProxyAdapter.SetPeers(peerEndpoints)
+	}
+}
+
+// SetMembership attaches the cluster membership reported by gossip, and
+// advertises through it what this instance takes
+func (e *Engine) SetMembership(m discovery.Membership) {
+	e.membership = m
+	e.advertise()
+}
+
+// advertise tells the other members which backends this instance stores new
+// files on, and whether it refuses writes, so they place files elsewhere
+func (e *Engine) advertise() {
+	if e.membership == nil {
+		return
+	}
+	var a discovery.Advertisement
+	if _, disabled := e.localFSBackend.(*noop.NoopAdapter); !disabled && e.localFSBackend != nil {
+		a.Backends = append(a.Backends, "localfs")
+	}
+	if _, disabled := e.s3Backend.(*noop.NoopAdapter); !disabled && e.s3Backend != nil {
+		a.Backends = append(a.Backends, "s3")
+	}
+	mode := e.ReadOnlyMode()
+	a.ReadOnly, a.ReadOnlyPrefixes = mode.Instance, mode.Prefixes
+	a.Draining = e.Draining()
+	if err := e.membership.Advertise(a); err != nil {
+		e.logger.Warn("Failed to advertise instance state through gossip", zap.Error(err))
+	}
+}
+
+// Members lists the instances known through gossip, or nil without gossip
+func (e *Engine) Members() []discovery.Member {
+	if e.membership == nil {
+		return nil
+	}
+	return e.membership.Members()
+}
+
+// ctxLogger returns the engine logger annotated with the request ID carried by ctx
+func (e *Engine) ctxLogger(ctx context.Context) *zap.Logger {
+	return corelog.WithContext(ctx, e.logger)
+}
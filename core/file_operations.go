@@ -3,18 +3,26 @@ package core
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"path/filepath"
+	"os"
 	"strings"
 	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/ebogdum/callfs/backends"
+	"github.com/ebogdum/callfs/backends/internalproxy"
+	"github.com/ebogdum/callfs/backends/noop"
+	"github.com/ebogdum/callfs/locks"
 	"github.com/ebogdum/callfs/metadata"
 	"github.com/ebogdum/callfs/metrics"
 )
 
+// ErrDirectoryNotEmpty is returned by DeleteFile for a directory with children
+var ErrDirectoryNotEmpty = errors.New("directory not empty")
+
 // GetFile retrieves file content
 func (e *Engine) GetFile(ctx context.Context, path string) (io.ReadCloser, error) {
 	// Get metadata to determine storage location
@@ -27,13 +35,25 @@ func (e *Engine) GetFile(ctx context.Context, path string) (io.ReadCloser, error
 		return nil, fmt.Errorf("path is not a file")
 	}
 
+	processed, err := e.OpenProcessed(ctx, md)
+	if err != nil {
+		return nil, err
+	}
+	if processed != nil {
+		return processed, nil
+	}
+	return e.openContent(ctx, path, md)
+}
+
+// openContent opens the content of the file at path as stored
+func (e *Engine) openContent(ctx context.Context, path string, md *metadata.Metadata) (io.ReadCloser, error) {
 	// Handle erasure-coded files via server-side reassembly
 	if md.ErasureCoded && e.erasureManager != nil {
 		data, err := e.erasureManager.RetrieveFile(ctx, path)
 		if err != nil {
 			return nil, fmt.Errorf("failed to retrieve erasure-coded file: %w", err)
 		}
-		return io.NopCloser(bytes.NewReader(data)), nil
+		return e.throttleReadCloser(io.NopCloser(bytes.NewReader(data)), nil), nil
 	}
 
 	// Route to appropriate backend
@@ -41,31 +61,138 @@ func (e *Engine) GetFile(ctx context.Context, path string) (io.ReadCloser, error
 
 	// Convert absolute path to relative path for backend
 	relativePath := strings.TrimPrefix(path, "/")
+	start := time.Now()
 	reader, err := storage.Open(ctx, relativePath)
+	e.observeBackendOp(ctx, e.transferBackend(md, storage), "open", path, time.Since(start))
+	if errors.Is(err, internalproxy.ErrPeerUnavailable) {
+		reader, err = e.failoverToReplica(ctx, md, err, func(s backends.Storage) (io.ReadCloser, error) {
+			return s.Open(ctx, relativePath)
+		})
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
 
-	e.logger.Debug("File opened successfully",
+	e.ctxLogger(ctx).Debug("File opened successfully",
 		zap.String("path", path),
 		zap.String("backend", md.BackendType),
 		zap.Int64("size", md.Size))
 
-	return reader, nil
+	return e.throttleReadCloser(e.streamReadCloser(reader, e.transferBackend(md, storage)), storage), nil
 }
 
-// CreateFile creates a new file with content
-func (e *Engine) CreateFile(ctx context.Context, path string, reader io.Reader, size int64, md *metadata.Metadata) error {
+// GetFileRange retrieves length bytes of file content starting at offset.
+// The caller must ensure the range lies within the file.
+func (e *Engine) GetFileRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	md, err := e.GetMetadata(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file metadata: %w", err)
+	}
+
+	if md.Type != "file" {
+		return nil, fmt.Errorf("path is not a file")
+	}
+
+	processed, err := e.OpenProcessed(ctx, md)
+	if err != nil {
+		return nil, err
+	}
+	if processed != nil {
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.NewSectionReader(processed, offset, length), processed}, nil
+	}
+
+	if md.ErasureCoded && e.erasureManager != nil {
+		data, err := e.erasureManager.RetrieveFile(ctx, path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve erasure-coded file: %w", err)
+		}
+		if offset+length > int64(len(data)) {
+			return nil, fmt.Errorf("range exceeds file size")
+		}
+		return e.throttleReadCloser(io.NopCloser(bytes.NewReader(data[offset:offset+length])), nil), nil
+	}
+
+	ctx, storage := e.selectBackend(ctx, md)
+	relativePath := strings.TrimPrefix(path, "/")
+
+	openRange := func(s backends.Storage) (io.ReadCloser, error) {
+		if rr, ok := s.(backends.RangeReader); ok {
+			return rr.OpenRange(ctx, relativePath, offset, length)
+		}
+
+		// Backend cannot seek; read and drop the bytes before the range
+		reader, err := s.Open(ctx, relativePath)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.CopyN(io.Discard, reader, offset); err != nil {
+			reader.Close()
+			return nil, fmt.Errorf("failed to skip to range start: %w", err)
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(reader, length), reader}, nil
+	}
+
 	start := time.Now()
+	reader, err := openRange(storage)
+	e.observeBackendOp(ctx, e.transferBackend(md, storage), "open", path, time.Since(start))
+	if errors.Is(err, internalproxy.ErrPeerUnavailable) {
+		reader, err = e.failoverToReplica(ctx, md, err, openRange)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file range: %w", err)
+	}
+	return e.throttleReadCloser(e.streamReadCloser(reader, e.transferBackend(md, storage)), storage), nil
+}
+
+// OpenLocalFile opens a file stored on this instance's local filesystem, so
+// it can be sent without copying its content through userspace. It returns
+// nil without an error when the file is stored elsewhere or its transfers
+// are throttled; GetFile streams those.
+func (e *Engine) OpenLocalFile(ctx context.Context, md *metadata.Metadata) (*os.File, error) {
+	if md.Type != "file" || md.ErasureCoded {
+		return nil, nil
+	}
+	ctx, storage := e.selectBackend(ctx, md)
+	opener, ok := storage.(backends.FileOpener)
+	if !ok || storage != e.localFSBackend || e.throttles(storage) {
+		return nil, nil
+	}
+
+	file, err := opener.OpenFile(ctx, strings.TrimPrefix(md.Path, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return file, nil
+}
+
+// CreateFile creates a new file with content. Read-only paths are refused
+// with backends.ErrReadOnly.
+func (e *Engine) CreateFile(ctx context.Context, path string, reader io.Reader, size int64, md *metadata.Metadata) error {
 	defer func() {
 		metrics.FileOperationsTotal.WithLabelValues("create", md.BackendType).Inc()
-		metrics.BackendOpDuration.WithLabelValues(md.BackendType, "create").Observe(time.Since(start).Seconds())
 	}()
 
+	if e.IsReadOnly(path) {
+		return backends.ErrReadOnly
+	}
+
+	// The chosen owner takes the lock itself, so forward before locking
+	if md.BackendType == "localfs" {
+		if owner := e.placeFile(ctx, path); owner != e.currentInstanceID {
+			return e.createFileOnInstance(ctx, owner, path, reader, size, md)
+		}
+	}
+
 	lockKey := fmt.Sprintf("file:%s", path)
 
 	// Acquire distributed lock
-	acquired, err := e.lockManager.Acquire(ctx, lockKey)
+	token, acquired, err := e.lockManager.Acquire(ctx, lockKey)
 	if err != nil {
 		return fmt.Errorf("failed to acquire lock: %w", err)
 	}
@@ -73,15 +200,21 @@ func (e *Engine) CreateFile(ctx context.Context, path string, reader io.Reader,
 		return fmt.Errorf("failed to acquire lock for file creation")
 	}
 	defer func() {
-		if err := e.lockManager.Release(context.Background(), lockKey); err != nil {
-			e.logger.Error("Failed to release lock", zap.String("lock_key", lockKey), zap.Error(err))
+		if err := e.lockManager.Release(context.Background(), lockKey, token); err != nil {
+			e.ctxLogger(ctx).Error("Failed to release lock", zap.String("lock_key", lockKey), zap.Error(err))
 		}
 	}()
 
+	ctx, stopRenewal := locks.KeepAlive(ctx, e.lockManager, lockKey, token, e.logger)
+	defer stopRenewal()
+
 	// Check if file already exists
 	if _, err := e.metadataStore.Get(ctx, path); err == nil {
 		return metadata.ErrAlreadyExists
 	}
+	if err := e.checkNotMoving(ctx, path); err != nil {
+		return err
+	}
 
 	// Ensure parent directories exist
 	if err := e.ensureParentDirectories(ctx, path, md.BackendType); err != nil {
@@ -92,24 +225,78 @@ func (e *Engine) CreateFile(ctx context.Context, path string, reader io.Reader,
 		md.CallFSInstanceID = &e.currentInstanceID
 	}
 
+	intent, err := e.beginIntent(ctx, metadata.IntentCreate, path, md)
+	if err != nil {
+		return fmt.Errorf("failed to record intent: %w", err)
+	}
+	defer e.endIntent(ctx, intent)
+
 	// Create file in appropriate backend
 	storage := e.selectBackendByType(md.BackendType)
 	// Convert absolute path to relative path for backend
 	relativePath := strings.TrimPrefix(path, "/")
-	if err := storage.Create(ctx, relativePath, reader, size); err != nil {
+	release, err := e.acquireWrite(ctx, md.BackendType)
+	if err != nil {
+		return err
+	}
+	defer release()
+	content := reader
+	scanned := e.scanUpload(ctx, path, reader)
+	if scanned != nil {
+		defer scanned.stop()
+		content = scanned
+	}
+	digest := NewContentDigest(content, size)
+	upload := measureUpload(e.throttle(digest, storage), md.BackendType)
+	start := time.Now()
+	err = storage.Create(ctx, relativePath, upload, size)
+	e.observeBackendOp(ctx, md.BackendType, "create", path, time.Since(start)-upload.waited())
+	if err != nil {
+		if checksumErr := checksumFailure(reader); checksumErr != nil {
+			return checksumErr
+		}
+		if scanErr := scanned.failure(); scanErr != nil {
+			return scanErr
+		}
 		return fmt.Errorf("failed to create file in backend: %w", err)
 	}
 
+	// Reject content that doesn't match the client-supplied checksum before
+	// any metadata is committed
+	if err := verifyContent(reader); err != nil {
+		if deleteErr := storage.Delete(ctx, relativePath); deleteErr != nil {
+			e.ctxLogger(ctx).Error("Failed to remove object after checksum mismatch",
+				zap.String("path", path), zap.Error(deleteErr))
+		}
+		return err
+	}
+
+	// Act on the scanner's verdict before the file becomes visible
+	if verdict, err := scanned.finish(); err != nil {
+		if deleteErr := storage.Delete(ctx, relativePath); deleteErr != nil {
+			e.ctxLogger(ctx).Error("Failed to remove object after scan", zap.String("path", path), zap.Error(deleteErr))
+		}
+		return err
+	} else if verdict.Infected {
+		md.Path = path
+		md.Size = size
+		md.Checksum = digest.Sum()
+		if err := e.actOnInfected(ctx, storage, path, md, verdict); err != nil {
+			return err
+		}
+	}
+
 	// Store metadata
 	md.Path = path
 	md.Size = size
+	md.Checksum = digest.Sum()
 	md.CreatedAt = time.Now()
 	md.UpdatedAt = time.Now()
 
 	if err := e.metadataStore.Create(ctx, md); err != nil {
 		// Attempt to clean up file from backend
 		if deleteErr := storage.Delete(ctx, relativePath); deleteErr != nil {
-			e.logger.Error("Failed to cleanup file after metadata creation failure",
+			e.ctxLogger(ctx).Error("Failed to cleanup file after metadata creation failure",
 				zap.String("path", path), zap.Error(deleteErr))
 		}
 		return fmt.Errorf("failed to store metadata: %w", err)
@@ -120,9 +307,11 @@ func (e *Engine) CreateFile(ctx context.Context, path string, reader io.Reader,
 	}
 
 	// Invalidate parent directory cache entries
-	e.metadataCache.InvalidatePrefix(filepath.Dir(path))
+	e.invalidatePathAndParent(ctx, path)
+	e.runPostWriteHooks(ctx, path)
+	e.indexInBackground(ctx, path)
 
-	e.logger.Info("File created successfully",
+	e.ctxLogger(ctx).Info("File created successfully",
 		zap.String("path", path),
 		zap.String("backend", md.BackendType),
 		zap.Int64("size", size))
@@ -130,12 +319,63 @@ func (e *Engine) CreateFile(ctx context.Context, path string, reader io.Reader,
 	return nil
 }
 
-// UpdateFile updates an existing file with new content
+// Touch sets the access and modification times of path, creating it as an
+// empty file with md if it does not exist, like touch. The times are now
+// unless attrs sets them; any other attributes in attrs are applied too. It
+// reports whether the file was created.
+func (e *Engine) Touch(ctx context.Context, path string, md *metadata.Metadata, attrs FileAttributes) (bool, error) {
+	if attrs.ATime == nil && attrs.MTime == nil {
+		now := time.Now()
+		attrs.ATime, attrs.MTime = &now, &now
+	}
+
+	created := false
+	_, err := e.metadataStore.Get(ctx, path)
+	if errors.Is(err, metadata.ErrNotFound) {
+		err = e.CreateFile(ctx, path, bytes.NewReader(nil), 0, md)
+		switch {
+		case err == nil:
+			created = true
+		case errors.Is(err, metadata.ErrAlreadyExists):
+			// Created concurrently; touch it like any existing file
+		default:
+			return false, err
+		}
+	} else if err != nil {
+		return false, err
+	}
+
+	if _, err := e.SetAttributes(ctx, path, attrs); err != nil {
+		return created, err
+	}
+	return created, nil
+}
+
+// UpdateFile updates an existing file with new content. Read-only paths are
+// refused with backends.ErrReadOnly.
 func (e *Engine) UpdateFile(ctx context.Context, path string, reader io.Reader, size int64, md *metadata.Metadata) error {
+	return e.updateFile(ctx, path, reader, size, md, "")
+}
+
+// UpdateFileIfVersion updates a file unless its content is no longer the
+// ContentVersion version, which fails with metadata.ErrModified. The check is
+// made under the lock of the path, so a write racing the update is kept. An
+// empty version updates the file unconditionally.
+func (e *Engine) UpdateFileIfVersion(ctx context.Context, path string, reader io.Reader, size int64, md *metadata.Metadata, version string) error {
+	return e.updateFile(ctx, path, reader, size, md, version)
+}
+
+// updateFile updates a file, unless its content is no longer version when
+// that is set
+func (e *Engine) updateFile(ctx context.Context, path string, reader io.Reader, size int64, md *metadata.Metadata, version string) error {
+	if e.IsReadOnly(path) {
+		return backends.ErrReadOnly
+	}
+
 	lockKey := fmt.Sprintf("file:%s", path)
 
 	// Acquire distributed lock
-	acquired, err := e.lockManager.Acquire(ctx, lockKey)
+	token, acquired, err := e.lockManager.Acquire(ctx, lockKey)
 	if err != nil {
 		return fmt.Errorf("failed to acquire lock: %w", err)
 	}
@@ -143,11 +383,14 @@ func (e *Engine) UpdateFile(ctx context.Context, path string, reader io.Reader,
 		return fmt.Errorf("failed to acquire lock for file update")
 	}
 	defer func() {
-		if err := e.lockManager.Release(context.Background(), lockKey); err != nil {
-			e.logger.Error("Failed to release lock", zap.String("lock_key", lockKey), zap.Error(err))
+		if err := e.lockManager.Release(context.Background(), lockKey, token); err != nil {
+			e.ctxLogger(ctx).Error("Failed to release lock", zap.String("lock_key", lockKey), zap.Error(err))
 		}
 	}()
 
+	ctx, stopRenewal := locks.KeepAlive(ctx, e.lockManager, lockKey, token, e.logger)
+	defer stopRenewal()
+
 	// Get existing metadata
 	existingMd, err := e.metadataStore.Get(ctx, path)
 	if err != nil {
@@ -157,29 +400,93 @@ func (e *Engine) UpdateFile(ctx context.Context, path string, reader io.Reader,
 	if existingMd.Type != "file" {
 		return fmt.Errorf("path is not a file")
 	}
+	if err := checkHold(existingMd); err != nil {
+		return err
+	}
+	if version != "" && ContentVersion(existingMd) != version {
+		return metadata.ErrModified
+	}
+	if err := e.checkNotMoving(ctx, path); err != nil {
+		return err
+	}
+
+	intent, err := e.beginIntent(ctx, metadata.IntentUpdate, path, existingMd)
+	if err != nil {
+		return fmt.Errorf("failed to record intent: %w", err)
+	}
+	defer e.endIntent(ctx, intent)
 
 	// Update file in appropriate backend
 	ctx, storage := e.selectBackend(ctx, existingMd)
 	// Convert absolute path to relative path for backend
 	relativePath := strings.TrimPrefix(path, "/")
-	if err := storage.Update(ctx, relativePath, reader, size); err != nil {
+
+	// A scanned update is spooled and only written once the scanner allows
+	// it, so the file keeps its content when the update is refused
+	content := reader
+	spool, verdict, err := e.spoolUpload(ctx, path, reader)
+	if err != nil {
+		if checksumErr := checksumFailure(reader); checksumErr != nil {
+			return checksumErr
+		}
+		return err
+	}
+	if spool != nil {
+		defer spool.Close()
+		content = spool
+	}
+	if verdict.Infected && e.scanning.policy.Action == ScanActionQuarantine {
+		return e.quarantineUpdate(ctx, storage, existingMd, spool, verdict)
+	}
+
+	backend := e.transferBackend(existingMd, storage)
+	release, err := e.acquireWrite(ctx, backend)
+	if err != nil {
+		return err
+	}
+	defer release()
+	digest := NewContentDigest(content, size)
+	upload := measureUpload(e.throttle(digest, storage), backend)
+	start := time.Now()
+	err = storage.Update(ctx, relativePath, upload, size)
+	e.observeBackendOp(ctx, backend, "update", path, time.Since(start)-upload.waited())
+	if err != nil {
+		if checksumErr := checksumFailure(reader); checksumErr != nil {
+			return checksumErr
+		}
 		return fmt.Errorf("failed to update file in backend: %w", err)
 	}
 
+	// Backends abort the write when a checksum reader fails at EOF, so a
+	// mismatch here means the backend stopped reading early; don't record it
+	if err := verifyContent(reader); err != nil {
+		e.invalidateCache(ctx, path)
+		return err
+	}
+
 	// Update metadata
 	existingMd.Size = size
+	existingMd.Checksum = digest.Sum()
 	existingMd.MTime = time.Now()
 	existingMd.UpdatedAt = time.Now()
+	md.Checksum = existingMd.Checksum // Kept by callers that correct the size
+
+	if verdict.Infected {
+		// Only a policy that tags infected content stores it
+		if err := e.actOnInfected(ctx, storage, path, existingMd, verdict); err != nil {
+			return err
+		}
+	}
 
 	if existingMd.CallFSInstanceID == nil && existingMd.BackendType == "localfs" {
 		existingMd.CallFSInstanceID = &e.currentInstanceID
 	}
 
 	if err := e.metadataStore.Update(ctx, existingMd); err != nil {
-		e.logger.Error("Metadata update failed after backend write - inconsistent state",
+		e.ctxLogger(ctx).Error("Metadata update failed after backend write - inconsistent state",
 			zap.String("path", path), zap.Error(err))
 		// Invalidate cache so subsequent reads don't serve stale metadata
-		e.metadataCache.Invalidate(path)
+		e.invalidateCache(ctx, path)
 		return fmt.Errorf("failed to update metadata: %w", err)
 	}
 
@@ -188,10 +495,12 @@ func (e *Engine) UpdateFile(ctx context.Context, path string, reader io.Reader,
 	}
 
 	// Invalidate cache for this file and parent directory
-	e.metadataCache.Invalidate(path)
-	e.metadataCache.InvalidatePrefix(filepath.Dir(path))
+	e.invalidatePathAndParent(ctx, path)
+	e.runPostWriteHooks(ctx, path)
+	e.indexInBackground(ctx, path)
+	e.dropPreviews(ctx, path)
 
-	e.logger.Info("File updated successfully",
+	e.ctxLogger(ctx).Info("File updated successfully",
 		zap.String("path", path),
 		zap.String("backend", existingMd.BackendType),
 		zap.Int64("size", size))
@@ -199,12 +508,30 @@ func (e *Engine) UpdateFile(ctx context.Context, path string, reader io.Reader,
 	return nil
 }
 
-// DeleteFile removes a file
+// DeleteFile removes a file. Read-only paths are refused with
+// backends.ErrReadOnly.
 func (e *Engine) DeleteFile(ctx context.Context, path string) error {
+	return e.deleteFile(ctx, path, time.Time{})
+}
+
+// DeleteFileIfUnmodified removes a file or empty directory unless it was
+// modified after since, which fails with metadata.ErrModified. The check is
+// made under the lock of the path, so a write racing the delete is kept.
+func (e *Engine) DeleteFileIfUnmodified(ctx context.Context, path string, since time.Time) error {
+	return e.deleteFile(ctx, path, since)
+}
+
+// deleteFile removes a file, unless it was modified after unmodifiedSince
+// when that is set
+func (e *Engine) deleteFile(ctx context.Context, path string, unmodifiedSince time.Time) error {
+	if e.IsReadOnly(path) {
+		return backends.ErrReadOnly
+	}
+
 	lockKey := fmt.Sprintf("file:%s", path)
 
 	// Acquire distributed lock
-	acquired, err := e.lockManager.Acquire(ctx, lockKey)
+	token, acquired, err := e.lockManager.Acquire(ctx, lockKey)
 	if err != nil {
 		return fmt.Errorf("failed to acquire lock: %w", err)
 	}
@@ -212,16 +539,28 @@ func (e *Engine) DeleteFile(ctx context.Context, path string) error {
 		return fmt.Errorf("failed to acquire lock for file deletion")
 	}
 	defer func() {
-		if err := e.lockManager.Release(context.Background(), lockKey); err != nil {
-			e.logger.Error("Failed to release lock", zap.String("lock_key", lockKey), zap.Error(err))
+		if err := e.lockManager.Release(context.Background(), lockKey, token); err != nil {
+			e.ctxLogger(ctx).Error("Failed to release lock", zap.String("lock_key", lockKey), zap.Error(err))
 		}
 	}()
 
+	ctx, stopRenewal := locks.KeepAlive(ctx, e.lockManager, lockKey, token, e.logger)
+	defer stopRenewal()
+
 	// Get metadata
 	md, err := e.metadataStore.Get(ctx, path)
 	if err != nil {
 		return fmt.Errorf("failed to get metadata: %w", err)
 	}
+	if err := checkHold(md); err != nil {
+		return err
+	}
+	if !unmodifiedSince.IsZero() && md.MTime.After(unmodifiedSince) {
+		return metadata.ErrModified
+	}
+	if err := e.checkNotMoving(ctx, path); err != nil {
+		return err
+	}
 
 	// Check if it's a directory and if it's empty
 	if md.Type == "directory" {
@@ -230,8 +569,16 @@ func (e *Engine) DeleteFile(ctx context.Context, path string) error {
 			return fmt.Errorf("failed to check directory contents: %w", err)
 		}
 		if len(children) > 0 {
-			return fmt.Errorf("directory not empty")
+			return ErrDirectoryNotEmpty
+		}
+	}
+
+	if md.Type == "file" {
+		intent, err := e.beginIntent(ctx, metadata.IntentDelete, path, md)
+		if err != nil {
+			return fmt.Errorf("failed to record intent: %w", err)
 		}
+		defer e.endIntent(ctx, intent)
 	}
 
 	// Handle erasure-coded files
@@ -242,9 +589,11 @@ func (e *Engine) DeleteFile(ctx context.Context, path string) error {
 		if err := e.metadataStore.Delete(ctx, path); err != nil {
 			return fmt.Errorf("failed to delete metadata: %w", err)
 		}
-		e.metadataCache.Invalidate(path)
-		e.metadataCache.InvalidatePrefix(filepath.Dir(path))
-		e.logger.Info("Erasure-coded file deleted", zap.String("path", path))
+		e.invalidatePathAndParent(ctx, path)
+		e.unindexInBackground(ctx, path)
+		e.clearAccessStats(ctx, path)
+		e.dropPreviews(ctx, path)
+		e.ctxLogger(ctx).Info("Erasure-coded file deleted", zap.String("path", path))
 		return nil
 	}
 
@@ -256,10 +605,18 @@ func (e *Engine) DeleteFile(ctx context.Context, path string) error {
 	if err := e.metadataStore.Delete(ctx, path); err != nil {
 		return fmt.Errorf("failed to delete metadata: %w", err)
 	}
+	e.unindexInBackground(ctx, path)
+	e.clearAccessStats(ctx, path)
+	if md.Type == "file" {
+		e.dropPreviews(ctx, path)
+	}
 
 	// Best-effort backend deletion
-	if err := storage.Delete(ctx, relativePath); err != nil {
-		e.logger.Warn("Failed to delete from backend after metadata removal",
+	start := time.Now()
+	err = storage.Delete(ctx, relativePath)
+	e.observeBackendOp(ctx, e.transferBackend(md, storage), "delete", path, time.Since(start))
+	if err != nil {
+		e.ctxLogger(ctx).Warn("Failed to delete from backend after metadata removal",
 			zap.String("path", path), zap.Error(err))
 	}
 
@@ -268,10 +625,9 @@ func (e *Engine) DeleteFile(ctx context.Context, path string) error {
 	}
 
 	// Invalidate cache for this file and parent directory
-	e.metadataCache.Invalidate(path)
-	e.metadataCache.InvalidatePrefix(filepath.Dir(path))
+	e.invalidatePathAndParent(ctx, path)
 
-	e.logger.Info("File deleted successfully",
+	e.ctxLogger(ctx).Info("File deleted successfully",
 		zap.String("path", path),
 		zap.String("backend", md.BackendType))
 
@@ -293,7 +649,9 @@ func (e *Engine) CreateErasureMetadata(ctx context.Context, path string, md *met
 		return fmt.Errorf("failed to store erasure metadata: %w", err)
 	}
 
-	e.metadataCache.InvalidatePrefix(filepath.Dir(path))
+	e.invalidatePathAndParent(ctx, path)
+	e.runPostWriteHooks(ctx, path)
+	e.indexInBackground(ctx, path)
 	return nil
 }
 
@@ -303,31 +661,127 @@ func (e *Engine) UpdateMetadataOnly(ctx context.Context, md *metadata.Metadata)
 	if err := e.metadataStore.Update(ctx, md); err != nil {
 		return fmt.Errorf("failed to update metadata: %w", err)
 	}
-	e.metadataCache.Invalidate(md.Path)
+	e.invalidateCache(ctx, md.Path)
+	e.dropPreviews(ctx, md.Path)
 	return nil
 }
 
 // GetMetadata retrieves metadata with cache support
 func (e *Engine) GetMetadata(ctx context.Context, path string) (*metadata.Metadata, error) {
-	// Try cache first
-	if cachedMd, found := e.metadataCache.Get(path); found {
-		e.logger.Debug("Cache hit for metadata", zap.String("path", path))
-		return cachedMd, nil
+	// Try cache first, unless the read must be linearizable
+	if e.readConsistencyOf(ctx) != metadata.ConsistencyLinearizable {
+		if cachedMd, found := e.metadataCache.Get(path); found {
+			e.ctxLogger(ctx).Debug("Cache hit for metadata", zap.String("path", path))
+			if cachedMd == nil {
+				return nil, metadata.ErrNotFound
+			}
+			return cachedMd, nil
+		}
 	}
 
 	// Cache miss - fetch from store
 	md, err := e.metadataStore.Get(ctx, path)
 	if err != nil {
+		if errors.Is(err, metadata.ErrNotFound) {
+			// Remember the miss briefly so repeated probes don't hit the store
+			e.metadataCache.SetNotFound(path)
+		}
 		return nil, err
 	}
 
 	// Store in cache
 	e.metadataCache.Set(path, md)
-	e.logger.Debug("Cache miss for metadata - stored in cache", zap.String("path", path))
+	e.ctxLogger(ctx).Debug("Cache miss for metadata - stored in cache", zap.String("path", path))
 
 	return md, nil
 }
 
+// SetReadConsistency sets the consistency of reads that do not request one,
+// as the metadata store applies it: raft.read_consistency for the Raft
+// store. Linearizable reads skip the metadata cache.
+func (e *Engine) SetReadConsistency(c metadata.ReadConsistency) {
+	e.readConsistency = c
+}
+
+// readConsistencyOf returns the consistency a read on ctx must have
+func (e *Engine) readConsistencyOf(ctx context.Context) metadata.ReadConsistency {
+	if c := metadata.ReadConsistencyFromContext(ctx); c != metadata.ConsistencyDefault {
+		return c
+	}
+	return e.readConsistency
+}
+
+// WithStoredAttributes returns a copy of md completed with what this
+// instance's local filesystem reports for the entry: its link count, the
+// configured extended attributes and, for a symlink, its type and target. md
+// itself is returned for entries stored elsewhere, and when the local
+// filesystem cannot be read.
+func (e *Engine) WithStoredAttributes(ctx context.Context, md *metadata.Metadata) *metadata.Metadata {
+	if md.BackendType != "localfs" || (md.CallFSInstanceID != nil && *md.CallFSInstanceID != e.currentInstanceID) {
+		return md
+	}
+	if _, disabled := e.localFSBackend.(*noop.NoopAdapter); disabled {
+		return md
+	}
+
+	stored, err := e.localFSBackend.Stat(ctx, strings.TrimPrefix(md.Path, "/"))
+	if err != nil {
+		e.ctxLogger(ctx).Warn("Failed to stat stored entry", zap.String("path", md.Path), zap.Error(err))
+		return md
+	}
+	completed := *md
+	completed.NLink = stored.NLink
+	completed.XAttrs = stored.XAttrs
+	if stored.Type == "symlink" {
+		completed.Type = stored.Type
+		completed.SymlinkTarget = stored.SymlinkTarget
+	}
+	return &completed
+}
+
+// Stat returns the metadata of the entry at path, completed with what the
+// instance holding it reports. With followSymlinks, a symlink is resolved
+// and the entry it points to is returned, with its own path.
+func (e *Engine) Stat(ctx context.Context, path string, followSymlinks bool) (*metadata.Metadata, error) {
+	md, err := e.GetMetadata(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if md.CallFSInstanceID != nil && *md.CallFSInstanceID != e.currentInstanceID && e.internalProxyAdapter != nil {
+		remote, err := e.internalProxyAdapter.StatJSONOnInstance(ctx, *md.CallFSInstanceID, path, followSymlinks)
+		if errors.Is(err, internalproxy.ErrPeerUnavailable) {
+			// The metadata store already knows the entry
+			e.ctxLogger(ctx).Warn("Owning instance unavailable, answering stat from metadata store",
+				zap.String("instance_id", *md.CallFSInstanceID),
+				zap.String("path", path))
+			return md, nil
+		}
+		return remote, err
+	}
+
+	md = e.WithStoredAttributes(ctx, md)
+	if !followSymlinks || md.Type != "symlink" {
+		return md, nil
+	}
+	resolver, ok := e.localFSBackend.(backends.SymlinkResolver)
+	if !ok {
+		return md, nil
+	}
+	target, err := resolver.ResolveSymlink(ctx, strings.TrimPrefix(path, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to follow symlink %s: %w", path, err)
+	}
+	if target == "." {
+		target = "" // The root
+	}
+	targetMD, err := e.GetMetadata(ctx, "/"+target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to follow symlink %s: %w", path, err)
+	}
+	return e.WithStoredAttributes(ctx, targetMD), nil
+}
+
 func (e *Engine) replicateFileToSecondaryBackend(ctx context.Context, path string, size int64, primaryBackend string) error {
 	if !e.replicationEnabled {
 		return nil
@@ -347,7 +801,7 @@ func (e *Engine) replicateFileToSecondaryBackend(ctx context.Context, path strin
 		if e.requireReplicaAck {
 			return fmt.Errorf("failed to open source for replication: %w", err)
 		}
-		e.logger.Warn("Replication skipped: failed opening source",
+		e.ctxLogger(ctx).Warn("Replication skipped: failed opening source",
 			zap.String("path", path),
 			zap.String("primary_backend", primaryBackend),
 			zap.String("replica_backend", replicaBackend),
@@ -363,7 +817,7 @@ func (e *Engine) replicateFileToSecondaryBackend(ctx context.Context, path strin
 			if e.requireReplicaAck {
 				return fmt.Errorf("failed to reopen source for replica create: %w", openErr)
 			}
-			e.logger.Warn("Replication skipped: failed reopening source",
+			e.ctxLogger(ctx).Warn("Replication skipped: failed reopening source",
 				zap.String("path", path),
 				zap.String("replica_backend", replicaBackend),
 				zap.Error(openErr))
@@ -376,7 +830,7 @@ func (e *Engine) replicateFileToSecondaryBackend(ctx context.Context, path strin
 			if e.requireReplicaAck {
 				return fmt.Errorf("failed to replicate file to secondary backend: %w", err)
 			}
-			e.logger.Warn("Replication to secondary backend failed",
+			e.ctxLogger(ctx).Warn("Replication to secondary backend failed",
 				zap.String("path", path),
 				zap.String("replica_backend", replicaBackend),
 				zap.Error(err))
@@ -384,7 +838,7 @@ func (e *Engine) replicateFileToSecondaryBackend(ctx context.Context, path strin
 		}
 	}
 
-	e.logger.Debug("Replicated file to secondary backend",
+	e.ctxLogger(ctx).Debug("Replicated file to secondary backend",
 		zap.String("path", path),
 		zap.String("primary_backend", primaryBackend),
 		zap.String("replica_backend", replicaBackend))
@@ -408,7 +862,7 @@ func (e *Engine) deleteReplicatedFile(ctx context.Context, path string, primaryB
 		if e.requireReplicaAck {
 			return fmt.Errorf("failed to delete replicated file: %w", err)
 		}
-		e.logger.Warn("Failed deleting replicated file",
+		e.ctxLogger(ctx).Warn("Failed deleting replicated file",
 			zap.String("path", path),
 			zap.String("replica_backend", replicaBackend),
 			zap.Error(err))
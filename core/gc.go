@@ -0,0 +1,333 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/backends"
+	"github.com/ebogdum/callfs/backends/noop"
+	"github.com/ebogdum/callfs/locks"
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// Kinds of orphaned backend objects
+const (
+	OrphanTemp             = "temp"              // A temporary file of an upload that never finished
+	OrphanUntracked        = "untracked"         // A file with no metadata
+	OrphanStray            = "stray"             // A file whose metadata places it elsewhere
+	OrphanIncompleteUpload = "incomplete_upload" // The parts of an interrupted S3 multipart upload
+)
+
+// DefaultGCGracePeriod is the minimum age of an orphan before it is collected,
+// unless configured otherwise. Uploads in progress are younger.
+const DefaultGCGracePeriod = 24 * time.Hour
+
+// gcS3LockKey serializes collection of the shared S3 bucket across instances
+const gcS3LockKey = "gc:s3"
+
+// ErrGCRunning is returned when a collection is already running on this instance
+var ErrGCRunning = errors.New("garbage collection is already running")
+
+// GCOptions configures the scheduled garbage collection
+type GCOptions struct {
+	GracePeriod time.Duration // Minimum age of an orphan
+	DryRun      bool          // Report orphans without removing them
+}
+
+// Orphan is a backend object that no metadata accounts for
+type Orphan struct {
+	Location string    `json:"location"` // Instance ID, or "s3"
+	Path     string    `json:"path"`
+	Kind     string    `json:"kind"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`            // Initiation time of an incomplete upload
+	UploadID string    `json:"upload_id,omitempty"` // Of an incomplete upload
+	Removed  bool      `json:"removed"`
+	Error    string    `json:"error,omitempty"` // Why it could not be removed
+}
+
+// GCReport is the outcome of one garbage collection
+type GCReport struct {
+	InstanceID  string    `json:"instance_id"`
+	DryRun      bool      `json:"dry_run"`
+	GracePeriod string    `json:"grace_period"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	Scanned     int64     `json:"scanned"` // Backend files examined
+	Orphans     []Orphan  `json:"orphans"`
+	Removed     int       `json:"removed"`
+	// Locations that could not be scanned, with the reason
+	Skipped map[string]string `json:"skipped,omitempty"`
+}
+
+// gcState guards collections on this instance
+type gcState struct {
+	running     sync.Mutex
+	gracePeriod time.Duration
+}
+
+// RunGarbageCollection collects orphans once and logs the outcome, as the
+// scheduled gc job does
+func (e *Engine) RunGarbageCollection(ctx context.Context, opts GCOptions) error {
+	report, err := e.CollectGarbage(ctx, opts.GracePeriod, opts.DryRun)
+	if err != nil {
+		return err
+	}
+	for _, orphan := range report.Orphans {
+		if orphan.Removed {
+			continue // Logged when removed
+		}
+		e.logger.Info("Found orphaned backend object",
+			zap.String("location", orphan.Location),
+			zap.String("path", orphan.Path),
+			zap.String("kind", orphan.Kind),
+			zap.String("error", orphan.Error))
+	}
+	e.logger.Info("Garbage collection finished",
+		zap.Int64("scanned", report.Scanned),
+		zap.Int("orphans", len(report.Orphans)),
+		zap.Int("removed", report.Removed),
+		zap.Any("skipped", report.Skipped))
+	return nil
+}
+
+// SetGCGracePeriod sets the grace period of collections started on demand
+func (e *Engine) SetGCGracePeriod(gracePeriod time.Duration) {
+	e.gc.gracePeriod = gracePeriod
+}
+
+// GCGracePeriod returns the grace period of collections started on demand
+func (e *Engine) GCGracePeriod() time.Duration {
+	if e.gc.gracePeriod > 0 {
+		return e.gc.gracePeriod
+	}
+	return DefaultGCGracePeriod
+}
+
+// CollectGarbage finds the objects of this instance's local filesystem and of
+// S3 that no metadata accounts for and that are older than gracePeriod:
+// temporary upload files, files with no metadata, files whose metadata places
+// them on another instance or backend, and incomplete S3 multipart uploads.
+// Unless dryRun is set they are removed, after their metadata is checked
+// again under the file's lock. Each instance collects its own local
+// filesystem; S3 is collected by one instance at a time. Erasure shards are
+// left alone.
+func (e *Engine) CollectGarbage(ctx context.Context, gracePeriod time.Duration, dryRun bool) (*GCReport, error) {
+	if !e.gc.running.TryLock() {
+		return nil, ErrGCRunning
+	}
+	defer e.gc.running.Unlock()
+
+	report := &GCReport{
+		InstanceID:  e.currentInstanceID,
+		DryRun:      dryRun,
+		GracePeriod: gracePeriod.String(),
+		StartedAt:   time.Now().UTC(),
+		Orphans:     []Orphan{},
+	}
+	cutoff := time.Now().Add(-gracePeriod)
+	skip := func(location string, err error) {
+		e.ctxLogger(ctx).Warn("Garbage collection skipped a location", zap.String("location", location), zap.Error(err))
+		if report.Skipped == nil {
+			report.Skipped = make(map[string]string)
+		}
+		report.Skipped[location] = err.Error()
+	}
+
+	if err := e.collectBackend(ctx, e.localFSBackend, e.currentInstanceID, cutoff, dryRun, report); err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		skip(e.currentInstanceID, err)
+	}
+	if _, disabled := e.s3Backend.(*noop.NoopAdapter); !disabled && e.s3Backend != nil {
+		if err := e.collectS3(ctx, cutoff, dryRun, report); err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			skip(s3Location, err)
+		}
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	return report, nil
+}
+
+// collectS3 collects the S3 bucket while holding the cluster-wide S3
+// collection lock
+func (e *Engine) collectS3(ctx context.Context, cutoff time.Time, dryRun bool, report *GCReport) error {
+	token, acquired, err := e.lockManager.Acquire(ctx, gcS3LockKey)
+	if err != nil {
+		return fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	if !acquired {
+		return fmt.Errorf("another instance is collecting S3")
+	}
+	defer func() {
+		if err := e.lockManager.Release(context.Background(), gcS3LockKey, token); err != nil {
+			e.ctxLogger(ctx).Error("Failed to release lock", zap.String("lock_key", gcS3LockKey), zap.Error(err))
+		}
+	}()
+	ctx, stopRenewal := locks.KeepAlive(ctx, e.lockManager, gcS3LockKey, token, e.logger)
+	defer stopRenewal()
+
+	if err := e.collectBackend(ctx, e.s3Backend, s3Location, cutoff, dryRun, report); err != nil {
+		return err
+	}
+
+	cleaner, ok := e.s3Backend.(backends.UploadCleaner)
+	if !ok {
+		return nil
+	}
+	uploads, err := cleaner.IncompleteUploads(ctx, cutoff)
+	if err != nil {
+		return err
+	}
+	for _, upload := range uploads {
+		orphan := Orphan{
+			Location: s3Location,
+			Path:     upload.Path,
+			Kind:     OrphanIncompleteUpload,
+			ModTime:  upload.Initiated,
+			UploadID: upload.UploadID,
+		}
+		if !dryRun {
+			if err := cleaner.AbortUpload(ctx, upload); err != nil {
+				orphan.Error = err.Error()
+			} else {
+				orphan.Removed = true
+				report.Removed++
+			}
+		}
+		report.Orphans = append(report.Orphans, orphan)
+	}
+	return nil
+}
+
+// collectBackend walks storage, which is location, and collects the files
+// older than cutoff that no metadata accounts for
+func (e *Engine) collectBackend(ctx context.Context, storage backends.Storage, location string, cutoff time.Time, dryRun bool, report *GCReport) error {
+	var walk func(dir string, tracked bool) error
+	walk = func(dir string, tracked bool) error {
+		items, err := storage.ListDirectory(ctx, strings.TrimPrefix(dir, "/"))
+		if errors.Is(err, metadata.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %w", dir, err)
+		}
+
+		// Below a directory without metadata nothing is tracked
+		byName := make(map[string]*metadata.Metadata)
+		if tracked {
+			children, err := e.metadataStore.ListChildren(ctx, dir)
+			if err != nil {
+				return fmt.Errorf("failed to list metadata of %s: %w", dir, err)
+			}
+			for _, child := range children {
+				byName[child.Name] = child
+			}
+		}
+
+		for _, item := range items {
+			if dir == "/" && item.Name == ".erasure" {
+				continue
+			}
+			itemPath := path.Join(dir, item.Name)
+			child := byName[item.Name]
+			if item.Type == "directory" {
+				if err := walk(itemPath, child != nil && child.Type == "directory"); err != nil {
+					return err
+				}
+				continue
+			}
+
+			report.Scanned++
+			kind := e.orphanKind(item.Name, child, location)
+			if kind == "" || !item.MTime.Before(cutoff) {
+				continue
+			}
+			orphan := Orphan{Location: location, Path: itemPath, Kind: kind, Size: item.Size, ModTime: item.MTime}
+			if !dryRun {
+				removed, err := e.removeOrphan(ctx, storage, location, orphan)
+				if err != nil {
+					orphan.Error = err.Error()
+				} else if !removed {
+					continue // Its metadata appeared meanwhile
+				}
+				orphan.Removed = removed
+				if removed {
+					report.Removed++
+				}
+			}
+			report.Orphans = append(report.Orphans, orphan)
+		}
+		return nil
+	}
+	return walk("/", true)
+}
+
+// orphanKind classifies a backend file named name at location against its
+// metadata, which is nil when there is none. It is empty for a file the
+// metadata accounts for.
+func (e *Engine) orphanKind(name string, md *metadata.Metadata, location string) string {
+	switch {
+	case strings.HasPrefix(name, ".callfs-tmp-"):
+		return OrphanTemp
+	case md == nil:
+		return OrphanUntracked
+	case md.Type != "file" || md.ErasureCoded || !e.holdsFile(md, location):
+		return OrphanStray
+	}
+	return ""
+}
+
+// removeOrphan deletes an orphan after checking its metadata again under the
+// file's lock, as an upload or a rename may have claimed the path since it
+// was found. It reports false if the file is no longer an orphan.
+func (e *Engine) removeOrphan(ctx context.Context, storage backends.Storage, location string, orphan Orphan) (bool, error) {
+	if orphan.Kind != OrphanTemp {
+		lockKey := "file:" + orphan.Path
+		token, acquired, err := e.lockManager.Acquire(ctx, lockKey)
+		if err != nil {
+			return false, fmt.Errorf("failed to acquire lock: %w", err)
+		}
+		if !acquired {
+			return false, fmt.Errorf("the file is locked")
+		}
+		defer func() {
+			if err := e.lockManager.Release(context.Background(), lockKey, token); err != nil {
+				e.ctxLogger(ctx).Error("Failed to release lock", zap.String("lock_key", lockKey), zap.Error(err))
+			}
+		}()
+
+		md, err := e.metadataStore.Get(ctx, orphan.Path)
+		if err != nil && !errors.Is(err, metadata.ErrNotFound) {
+			return false, fmt.Errorf("failed to check metadata: %w", err)
+		}
+		if err == nil && e.orphanKind(path.Base(orphan.Path), md, location) == "" {
+			return false, nil
+		}
+		// Content below a directory being moved is claimed once it moves
+		if err := e.checkNotMoving(ctx, orphan.Path); err != nil {
+			return false, err
+		}
+	}
+
+	err := storage.Delete(ctx, strings.TrimPrefix(orphan.Path, "/"))
+	if err != nil && !errors.Is(err, metadata.ErrNotFound) {
+		return false, err
+	}
+	e.ctxLogger(ctx).Info("Removed orphaned backend object",
+		zap.String("location", location),
+		zap.String("path", orphan.Path),
+		zap.String("kind", orphan.Kind))
+	return true, nil
+}
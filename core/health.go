@@ -0,0 +1,99 @@
+package core
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/backends"
+)
+
+// ComponentHealth describes the result of checking a single dependency
+type ComponentHealth struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"` // "ok" or "error"
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+// Healthy reports whether the component check succeeded
+func (c ComponentHealth) Healthy() bool {
+	return c.Status == "ok"
+}
+
+// healthCacheTTL is how long a health check answers probes, which
+// unauthenticated clients may send at any rate
+const healthCacheTTL = 2 * time.Second
+
+// healthCache holds the result of the last health check
+type healthCache struct {
+	mu        sync.Mutex
+	results   []ComponentHealth
+	checkedAt time.Time
+}
+
+type healthCheck struct {
+	name string
+	ping func(context.Context) error
+}
+
+// CheckHealth pings the metadata store, lock manager and every configured
+// storage backend concurrently. Each check is bounded by timeout. A result
+// younger than healthCacheTTL is reused, and concurrent callers wait for the
+// same check. Failure details are logged rather than returned so they are
+// not exposed on unauthenticated probe endpoints.
+func (e *Engine) CheckHealth(ctx context.Context, timeout time.Duration) []ComponentHealth {
+	e.health.mu.Lock()
+	defer e.health.mu.Unlock()
+	if e.health.results == nil || time.Since(e.health.checkedAt) >= healthCacheTTL {
+		// Shared by every waiting caller, so not canceled with this one
+		e.health.results = e.checkHealth(context.WithoutCancel(ctx), timeout)
+		e.health.checkedAt = time.Now()
+	}
+	return slices.Clone(e.health.results)
+}
+
+// checkHealth runs the checks of CheckHealth
+func (e *Engine) checkHealth(ctx context.Context, timeout time.Duration) []ComponentHealth {
+	checks := []healthCheck{
+		{"metadata_store", e.metadataStore.Ping},
+		{"lock_manager", e.lockManager.Ping},
+	}
+	if hc, ok := e.localFSBackend.(backends.HealthChecker); ok {
+		checks = append(checks, healthCheck{"backend_localfs", hc.Ping})
+	}
+	if hc, ok := e.s3Backend.(backends.HealthChecker); ok {
+		checks = append(checks, healthCheck{"backend_s3", hc.Ping})
+	}
+
+	results := make([]ComponentHealth, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, name string, ping func(context.Context) error) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := ping(checkCtx)
+			results[i] = ComponentHealth{
+				Name:      name,
+				Status:    "ok",
+				LatencyMS: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				results[i].Status = "error"
+				e.ctxLogger(ctx).Warn("Health check failed",
+					zap.String("component", name),
+					zap.Error(err))
+			}
+		}(i, check.name, check.ping)
+	}
+	wg.Wait()
+
+	return results
+}
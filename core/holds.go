@@ -0,0 +1,165 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/backends"
+	"github.com/ebogdum/callfs/locks"
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// ErrHeld is returned for a change to a file under an immutability hold
+var ErrHeld = errors.New("file is immutable")
+
+// ErrInvalidHold is returned for a hold that cannot be placed
+var ErrInvalidHold = errors.New("invalid hold")
+
+// ErrHoldNotAllowed is returned for a hold only an admin may place
+var ErrHoldNotAllowed = errors.New("hold not allowed")
+
+// HoldPolicy limits the holds owners of files place on them. Admins place
+// any hold.
+type HoldPolicy struct {
+	MaxOwnerRetention time.Duration // Longest retention an owner may place; owners place none when 0
+	Compliance        bool          // S3 Object Lock is in COMPLIANCE mode, which nobody can lift
+}
+
+// SetHoldPolicy sets the limits of holds placed by owners
+func (e *Engine) SetHoldPolicy(policy HoldPolicy) {
+	e.holdPolicy = policy
+}
+
+// Hold is the immutability of a file. An immutable file cannot be updated,
+// deleted or renamed until RetainUntil, or at all while it is nil.
+type Hold struct {
+	Immutable   bool       `json:"immutable"`
+	RetainUntil *time.Time `json:"retain_until,omitempty"`
+}
+
+// checkHold returns ErrHeld if md is under a hold now
+func checkHold(md *metadata.Metadata) error {
+	if !md.Held(time.Now()) {
+		return nil
+	}
+	if md.RetainUntil == nil {
+		return fmt.Errorf("%w: %s is on hold", ErrHeld, md.Path)
+	}
+	return fmt.Errorf("%w: %s is retained until %s", ErrHeld, md.Path, md.RetainUntil.UTC().Format(time.RFC3339))
+}
+
+// checkHoldOf returns ErrHeld if the entry at path is under a hold now. The
+// owner of a file forwarded a change checks again under its lock; this
+// answers before the content is sent.
+func (e *Engine) checkHoldOf(ctx context.Context, path string) error {
+	md, err := e.metadataStore.Get(ctx, path)
+	if err != nil {
+		return nil // Left to the owner to report
+	}
+	return checkHold(md)
+}
+
+// SetHold places, changes or lifts the hold of the file at path. A hold with
+// an end may only be extended until it expires, and an indefinite hold only
+// changed when privileged, by an admin. Unless privileged, the caller is the
+// owner of the file and may only place retention up to the hold policy's
+// limit, and none on files S3 locks in COMPLIANCE mode. Files stored in S3
+// are also locked with S3 Object Lock where the backend supports it.
+func (e *Engine) SetHold(ctx context.Context, path string, hold Hold, privileged bool) (*metadata.Metadata, error) {
+	now := time.Now()
+	switch {
+	case hold.RetainUntil != nil && !hold.Immutable:
+		return nil, fmt.Errorf("%w: retain_until requires immutable", ErrInvalidHold)
+	case hold.RetainUntil != nil && !hold.RetainUntil.After(now):
+		return nil, fmt.Errorf("%w: retain_until must be in the future", ErrInvalidHold)
+	case e.IsSnapshotPath(path):
+		return nil, fmt.Errorf("%w: snapshots cannot be held", ErrInvalidHold)
+	}
+	if !privileged && hold.Immutable {
+		switch {
+		case hold.RetainUntil == nil:
+			return nil, fmt.Errorf("%w: only admins place indefinite holds", ErrHoldNotAllowed)
+		case hold.RetainUntil.After(now.Add(e.holdPolicy.MaxOwnerRetention)):
+			return nil, fmt.Errorf("%w: owners retain files for at most %s", ErrHoldNotAllowed, e.holdPolicy.MaxOwnerRetention)
+		}
+	}
+
+	lockKey := fmt.Sprintf("file:%s", path)
+	token, acquired, err := e.lockManager.Acquire(ctx, lockKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	if !acquired {
+		return nil, fmt.Errorf("failed to acquire lock for hold change")
+	}
+	defer func() {
+		if err := e.lockManager.Release(context.Background(), lockKey, token); err != nil {
+			e.ctxLogger(ctx).Error("Failed to release lock", zap.String("lock_key", lockKey), zap.Error(err))
+		}
+	}()
+	ctx, stopRenewal := locks.KeepAlive(ctx, e.lockManager, lockKey, token, e.logger)
+	defer stopRenewal()
+
+	md, err := e.metadataStore.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if md.Type != "file" {
+		return nil, fmt.Errorf("%w: only files can be held", ErrInvalidHold)
+	}
+	if err := e.checkNotMoving(ctx, path); err != nil {
+		return nil, err
+	}
+	if !privileged && hold.Immutable && e.holdPolicy.Compliance && md.BackendType == "s3" && !md.ErasureCoded {
+		return nil, fmt.Errorf("%w: only admins place COMPLIANCE retention", ErrHoldNotAllowed)
+	}
+	if md.Held(now) {
+		switch {
+		case md.RetainUntil == nil && !privileged:
+			return nil, fmt.Errorf("%w: only admins change an indefinite hold", ErrHeld)
+		case md.RetainUntil != nil && (!hold.Immutable || hold.RetainUntil == nil || hold.RetainUntil.Before(*md.RetainUntil)):
+			return nil, fmt.Errorf("%w: retention until %s can only be extended", ErrHeld, md.RetainUntil.UTC().Format(time.RFC3339))
+		}
+	}
+
+	if md.BackendType == "s3" && !md.ErasureCoded {
+		if holder, ok := e.s3Backend.(backends.Holder); ok {
+			if err := holder.SetHold(ctx, path, hold.Immutable && hold.RetainUntil == nil, hold.RetainUntil); err != nil {
+				return nil, fmt.Errorf("failed to lock object: %w", err)
+			}
+		}
+	}
+
+	previous := Hold{Immutable: md.Immutable, RetainUntil: md.RetainUntil}
+	md.Immutable = hold.Immutable
+	md.RetainUntil = hold.RetainUntil
+	md.UpdatedAt = now
+	if err := e.metadataStore.Update(ctx, md); err != nil {
+		return nil, fmt.Errorf("failed to update metadata: %w", err)
+	}
+	e.invalidatePathAndParent(ctx, path)
+
+	e.ctxLogger(ctx).Warn("File hold changed",
+		zap.String("path", path),
+		zap.Bool("immutable", hold.Immutable),
+		zap.Timep("retain_until", hold.RetainUntil),
+		zap.Bool("was_immutable", previous.Immutable),
+		zap.Timep("was_retained_until", previous.RetainUntil))
+	return md, nil
+}
+
+// lockMovedObject applies the hold of md to its copy just written to S3
+func (e *Engine) lockMovedObject(ctx context.Context, md *metadata.Metadata) error {
+	if !md.Held(time.Now()) {
+		return nil
+	}
+	holder, ok := e.s3Backend.(backends.Holder)
+	if !ok {
+		return nil
+	}
+	return holder.SetHold(ctx, md.Path, md.RetainUntil == nil, md.RetainUntil)
+}
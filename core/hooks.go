@@ -0,0 +1,81 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ebogdum/callfs/hooks"
+	"github.com/ebogdum/callfs/metadata"
+)
+
+type hooksAppliedKey struct{}
+
+// WithHooksApplied marks ctx so that pre-write and pre-read hooks are not run
+// again. Requests forwarded by another instance carry it, as the instance the
+// client talks to ran them already.
+func WithHooksApplied(ctx context.Context) context.Context {
+	return context.WithValue(ctx, hooksAppliedKey{}, true)
+}
+
+func hooksApplied(ctx context.Context) bool {
+	applied, _ := ctx.Value(hooksAppliedKey{}).(bool)
+	return applied
+}
+
+// SetHooks makes file operations run the content processing hooks of runner
+func (e *Engine) SetHooks(runner *hooks.Runner) {
+	e.hooks = runner
+}
+
+// ProcessUpload runs the pre-write hooks matching path over an upload before
+// it is stored. It returns nil when none applies; otherwise the caller stores
+// the returned content, of its own size, in place of the upload and closes
+// it. Content a hook refuses or fails on returns an error.
+func (e *Engine) ProcessUpload(ctx context.Context, path string, content io.Reader) (*hooks.Content, error) {
+	if hooksApplied(ctx) {
+		return nil, nil
+	}
+	return e.hooks.Process(ctx, hooks.StagePreWrite, path, content)
+}
+
+// OpenProcessed opens the file of md through the pre-read hooks matching its
+// path, and returns nil when none applies. The content is served in place of
+// the file, and differs from it in size and checksum when a hook rewrote it.
+func (e *Engine) OpenProcessed(ctx context.Context, md *metadata.Metadata) (*hooks.Content, error) {
+	if md.Type != "file" || hooksApplied(ctx) || !e.hooks.Matches(hooks.StagePreRead, md.Path) {
+		return nil, nil
+	}
+	reader, err := e.openContent(ctx, md.Path, md)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	processed, err := e.hooks.Process(ctx, hooks.StagePreRead, md.Path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process file: %w", err)
+	}
+	return processed, nil
+}
+
+// TransformsContent reports whether pre-write or pre-read hooks rewrite the
+// content of path, so clients may see other content than is stored
+func (e *Engine) TransformsContent(ctx context.Context, path string) bool {
+	return !hooksApplied(ctx) && (e.hooks.Matches(hooks.StagePreWrite, path) || e.hooks.Matches(hooks.StagePreRead, path))
+}
+
+// runPostWriteHooks starts the post-write hooks matching path, which read
+// the file as stored
+func (e *Engine) runPostWriteHooks(ctx context.Context, path string) {
+	e.hooks.RunPostWrite(ctx, path, func(ctx context.Context) (io.ReadCloser, int64, error) {
+		md, err := e.GetMetadata(ctx, path)
+		if err != nil {
+			return nil, 0, err
+		}
+		reader, err := e.openContent(ctx, path, md)
+		if err != nil {
+			return nil, 0, err
+		}
+		return reader, md.Size, nil
+	})
+}
@@ -0,0 +1,259 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/metadata"
+	"github.com/ebogdum/callfs/metrics"
+	"github.com/ebogdum/callfs/search"
+)
+
+// IndexOptions configures the indexing of files for search
+type IndexOptions struct {
+	Prefixes        []string // Paths indexed; all paths when empty
+	MaxContentBytes int64    // Text indexed of each file; 0 indexes metadata only
+	Concurrency     int      // Files indexed at once after writes
+}
+
+// ReindexReport is the outcome of Reindex
+type ReindexReport struct {
+	Path    string `json:"path"`
+	Indexed int    `json:"indexed"` // Files whose documents were written
+	Failed  int    `json:"failed"`
+}
+
+// indexQueueSize is how many index updates may wait for a worker. Updates
+// beyond it are dropped until the workers catch up.
+const indexQueueSize = 10000
+
+// errIndexQueueFull is logged for an update dropped because the queue is full
+var errIndexQueueFull = errors.New("search index queue is full")
+
+// indexState holds the search index of SetSearchIndex
+type indexState struct {
+	index     search.Index
+	extractor *search.Extractor
+	opts      IndexOptions
+
+	queue chan indexTask // Updates waiting for a worker
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// indexTask is an update of the search index made after a write
+type indexTask struct {
+	ctx    context.Context
+	path   string
+	remove bool // Remove the documents at and below path instead of indexing them
+}
+
+// SetSearchIndex makes file operations keep index up to date: files created
+// or updated are indexed in the background with the text extractor finds in
+// them, and deleted or moved files are removed from it. opts.Concurrency
+// workers apply the updates until the engine is closed.
+func (e *Engine) SetSearchIndex(index search.Index, extractor *search.Extractor, opts IndexOptions) {
+	s := &indexState{
+		index:     index,
+		extractor: extractor,
+		opts:      opts,
+		queue:     make(chan indexTask, indexQueueSize),
+		stop:      make(chan struct{}),
+	}
+	e.indexing = s
+
+	for range max(opts.Concurrency, 1) {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			for {
+				select {
+				case task := <-s.queue:
+					e.applyIndexTask(task)
+				case <-s.stop:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// stopIndexing stops the indexing workers, if any, once their current
+// updates are done. Updates still queued are dropped; Reindex repairs them.
+func (e *Engine) stopIndexing() {
+	if e.indexing != nil {
+		close(e.indexing.stop)
+		e.indexing.wg.Wait()
+	}
+}
+
+// SearchEnabled reports whether SetSearchIndex was called
+func (e *Engine) SearchEnabled() bool {
+	return e.indexing != nil
+}
+
+// SearchContent queries the search index. Hits are not checked against the
+// permissions of the caller.
+func (e *Engine) SearchContent(ctx context.Context, q search.Query) (search.Result, error) {
+	if e.indexing == nil {
+		return search.Result{}, fmt.Errorf("%w: search is not configured", search.ErrUnavailable)
+	}
+	return e.indexing.index.Search(ctx, q)
+}
+
+// isIndexed reports whether files at path belong in the search index
+func (e *Engine) isIndexed(path string) bool {
+	if e.IsSnapshotPath(path) {
+		return false
+	}
+	if len(e.indexing.opts.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range e.indexing.opts.Prefixes {
+		trimmed := strings.TrimSuffix(prefix, "/")
+		if trimmed == "" || path == trimmed || strings.HasPrefix(path, trimmed+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// indexInBackground queues the indexing of the file at path, or of the files
+// below the directory at path. Failures are logged; Reindex repairs what they
+// leave out.
+func (e *Engine) indexInBackground(ctx context.Context, path string) {
+	e.queueIndexTask(ctx, path, false)
+}
+
+// unindexInBackground queues the removal of the documents of path and of
+// everything below it from the search index
+func (e *Engine) unindexInBackground(ctx context.Context, path string) {
+	e.queueIndexTask(ctx, path, true)
+}
+
+// queueIndexTask hands an update to the indexing workers without waiting:
+// writes are not held up by a slow index, and when the queue is full the
+// update is dropped and counted as failed
+func (e *Engine) queueIndexTask(ctx context.Context, path string, remove bool) {
+	if e.indexing == nil || e.IsSnapshotPath(path) {
+		return
+	}
+	// Keep the request's logging fields, not its cancellation
+	task := indexTask{ctx: context.WithoutCancel(ctx), path: path, remove: remove}
+	select {
+	case e.indexing.queue <- task:
+	default:
+		operation := "put"
+		if remove {
+			operation = "delete"
+		}
+		recordIndexOperation(operation, errIndexQueueFull)
+		e.ctxLogger(ctx).Warn("Dropped search index update", zap.String("path", path), zap.Error(errIndexQueueFull))
+	}
+}
+
+// applyIndexTask makes the update of task, logging a failure
+func (e *Engine) applyIndexTask(task indexTask) {
+	if task.remove {
+		err := e.indexing.index.Delete(task.ctx, task.path)
+		recordIndexOperation("delete", err)
+		if err != nil {
+			e.ctxLogger(task.ctx).Warn("Failed to remove file from search index", zap.String("path", task.path), zap.Error(err))
+		}
+		return
+	}
+	if _, err := e.Reindex(task.ctx, task.path); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+		e.ctxLogger(task.ctx).Warn("Failed to index file for search", zap.String("path", task.path), zap.Error(err))
+	}
+}
+
+// Reindex writes the documents of the file at path, or of every file below
+// the directory at path, to the search index
+func (e *Engine) Reindex(ctx context.Context, path string) (*ReindexReport, error) {
+	if e.indexing == nil {
+		return nil, fmt.Errorf("%w: search is not configured", search.ErrUnavailable)
+	}
+	md, err := e.metadataStore.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReindexReport{Path: path}
+	visit := func(item *metadata.Metadata) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if item.Type != "file" || !e.isIndexed(item.Path) {
+			return nil
+		}
+		if err := e.indexFile(ctx, item); err != nil {
+			report.Failed++
+			e.ctxLogger(ctx).Warn("Failed to index file for search", zap.String("path", item.Path), zap.Error(err))
+			return nil
+		}
+		report.Indexed++
+		return nil
+	}
+	if err := visit(md); err != nil {
+		return report, err
+	}
+	if md.Type == "directory" {
+		if err := e.walkDescendants(ctx, path, visit); err != nil {
+			return report, fmt.Errorf("failed to list directory %s: %w", path, err)
+		}
+	}
+	return report, nil
+}
+
+// indexFile writes the document of md, with the text of its content
+func (e *Engine) indexFile(ctx context.Context, md *metadata.Metadata) error {
+	doc := search.Document{
+		Path:        md.Path,
+		Name:        md.Name,
+		Size:        md.Size,
+		Mode:        md.Mode,
+		UID:         md.UID,
+		GID:         md.GID,
+		MTime:       md.MTime,
+		BackendType: md.BackendType,
+		Checksum:    md.Checksum,
+	}
+	if e.indexing.opts.MaxContentBytes > 0 && md.Size > 0 {
+		reader, err := e.openContent(ctx, md.Path, md)
+		if err != nil {
+			return err
+		}
+		doc.Content, err = e.indexing.extractor.Extract(ctx, md.Name, reader)
+		reader.Close()
+		if err != nil {
+			// The metadata is still worth finding
+			e.ctxLogger(ctx).Warn("Failed to extract text for search", zap.String("path", md.Path), zap.Error(err))
+		}
+	}
+
+	err := e.indexing.index.Put(ctx, doc)
+	recordIndexOperation("put", err)
+	if err != nil {
+		return err
+	}
+
+	// A delete that ran while the content was read is undone by the put
+	if _, err := e.metadataStore.Get(ctx, md.Path); errors.Is(err, metadata.ErrNotFound) {
+		err = e.indexing.index.Delete(ctx, md.Path)
+		recordIndexOperation("delete", err)
+	}
+	return nil
+}
+
+func recordIndexOperation(operation string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	metrics.SearchIndexOperationsTotal.WithLabelValues(operation, result).Inc()
+}
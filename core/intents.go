@@ -0,0 +1,230 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// intentRecoveryAttempts bounds the passes RecoverIntents makes over intents
+// that cannot be resolved yet, such as those whose file lock a crashed
+// process still held
+const intentRecoveryAttempts = 10
+
+// SetIntentJournal makes create, update and delete record their intent in
+// journal before touching a backend, so RecoverIntents can finish or undo
+// operations interrupted by a crash
+func (e *Engine) SetIntentJournal(journal metadata.IntentJournal) {
+	e.intentJournal = journal
+}
+
+// beginIntent records that op is about to change the backend content of
+// path. It returns nil when no journal is configured.
+func (e *Engine) beginIntent(ctx context.Context, op, path string, md *metadata.Metadata) (*metadata.Intent, error) {
+	if e.intentJournal == nil {
+		return nil, nil
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate intent ID: %w", err)
+	}
+	intent := &metadata.Intent{
+		ID:          hex.EncodeToString(id),
+		InstanceID:  e.currentInstanceID,
+		Op:          op,
+		Path:        path,
+		BackendType: md.BackendType,
+		CreatedAt:   time.Now().UTC(),
+	}
+	if md.CallFSInstanceID != nil {
+		intent.Owner = *md.CallFSInstanceID
+	}
+	if err := e.intentJournal.RecordIntent(ctx, intent); err != nil {
+		return nil, err
+	}
+	return intent, nil
+}
+
+// endIntent clears an intent once its operation finished or failed cleanly.
+// An intent that cannot be cleared is resolved by the next recovery.
+func (e *Engine) endIntent(ctx context.Context, intent *metadata.Intent) {
+	if intent == nil {
+		return
+	}
+	if err := e.intentJournal.ClearIntent(context.WithoutCancel(ctx), intent); err != nil {
+		e.ctxLogger(ctx).Warn("Failed to clear intent",
+			zap.String("path", intent.Path), zap.String("op", intent.Op), zap.Error(err))
+	}
+}
+
+// RecoverIntents resolves the intents this instance left behind when it
+// stopped in the middle of a file operation. A create whose metadata was not
+// committed is rolled back by removing its content; an update is rolled
+// forward by recording the size and time of the content now stored, whose
+// checksum is then unknown; a delete is completed. Intents whose file is still locked, for instance by the
+// crashed process until its lock expires, are retried every lock TTL.
+func (e *Engine) RecoverIntents(ctx context.Context) {
+	if e.intentJournal == nil {
+		return
+	}
+	for attempt := 1; ; attempt++ {
+		pending, err := e.recoverIntents(ctx)
+		if err != nil {
+			e.logger.Error("Failed to recover intents", zap.Error(err))
+		}
+		if err == nil && pending == 0 {
+			return
+		}
+		if attempt == intentRecoveryAttempts {
+			e.logger.Error("Intents left unresolved until the next start", zap.Int("pending", pending))
+			return
+		}
+		select {
+		case <-time.After(e.lockManager.TTL()):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// recoverIntents makes one pass over this instance's intents and returns
+// how many remain
+func (e *Engine) recoverIntents(ctx context.Context) (int, error) {
+	intents, err := e.intentJournal.ListIntents(ctx, e.currentInstanceID)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, intent := range intents {
+		if err := e.recoverIntent(ctx, intent); err != nil {
+			e.logger.Warn("Failed to recover intent",
+				zap.String("path", intent.Path), zap.String("op", intent.Op), zap.Error(err))
+			pending++
+			continue
+		}
+		e.endIntent(ctx, intent)
+		e.logger.Info("Recovered interrupted file operation",
+			zap.String("path", intent.Path), zap.String("op", intent.Op), zap.Time("started_at", intent.CreatedAt))
+	}
+	return pending, nil
+}
+
+// recoverIntent finishes or undoes one interrupted operation under the
+// file's lock
+func (e *Engine) recoverIntent(ctx context.Context, intent *metadata.Intent) error {
+	lockKey := "file:" + intent.Path
+	token, acquired, err := e.lockManager.Acquire(ctx, lockKey)
+	if err != nil {
+		return fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	if !acquired {
+		return fmt.Errorf("the file is locked")
+	}
+	defer func() {
+		if err := e.lockManager.Release(context.Background(), lockKey, token); err != nil {
+			e.logger.Error("Failed to release lock", zap.String("lock_key", lockKey), zap.Error(err))
+		}
+	}()
+
+	if err := e.checkNotMoving(ctx, intent.Path); err != nil {
+		return err
+	}
+	md, err := e.metadataStore.Get(ctx, intent.Path)
+	if err != nil && !errors.Is(err, metadata.ErrNotFound) {
+		return fmt.Errorf("failed to get metadata: %w", err)
+	}
+	// Metadata written after the intent belongs to a later operation, which
+	// the interrupted one must not touch
+	current := err == nil && md.CreatedAt.Before(intent.CreatedAt) && intentMatches(intent, md)
+	defer e.invalidatePathAndParent(ctx, intent.Path)
+
+	ctx, storage := e.selectBackend(ctx, intentLocation(intent))
+	relativePath := strings.TrimPrefix(intent.Path, "/")
+
+	switch intent.Op {
+	case metadata.IntentCreate:
+		if err == nil && intentMatches(intent, md) {
+			// Committed; the replica may not have been written
+			return e.replicateFileToSecondaryBackend(ctx, intent.Path, md.Size, md.BackendType)
+		}
+		if err == nil && e.holdsFile(md, e.expectedLocation(intentLocation(intent))) {
+			return nil // A later create stored its content in the same place
+		}
+		if err := storage.Delete(ctx, relativePath); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+			return fmt.Errorf("failed to remove uncommitted content: %w", err)
+		}
+		return nil
+
+	case metadata.IntentUpdate:
+		if !current {
+			return nil
+		}
+		stored, err := storage.Stat(ctx, relativePath)
+		if err != nil {
+			return fmt.Errorf("failed to stat content: %w", err)
+		}
+		if stored.Size == md.Size && !stored.MTime.After(md.MTime) {
+			return nil // The content was not replaced
+		}
+		md.Size = stored.Size
+		md.MTime = stored.MTime
+		md.Checksum = "" // Not known for the content the update left
+		md.UpdatedAt = time.Now()
+		if err := e.metadataStore.Update(ctx, md); err != nil {
+			return fmt.Errorf("failed to update metadata: %w", err)
+		}
+		return e.replicateFileToSecondaryBackend(ctx, intent.Path, md.Size, md.BackendType)
+
+	case metadata.IntentDelete:
+		if err == nil && !current {
+			return nil // The path was created again since
+		}
+		if intent.BackendType == "erasure" {
+			if e.erasureManager != nil {
+				if err := e.erasureManager.DeleteFile(ctx, intent.Path); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+					return fmt.Errorf("failed to delete erasure-coded file: %w", err)
+				}
+			}
+		} else if err := storage.Delete(ctx, relativePath); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+			return fmt.Errorf("failed to delete content: %w", err)
+		}
+		if current {
+			if err := e.metadataStore.Delete(ctx, intent.Path); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+				return fmt.Errorf("failed to delete metadata: %w", err)
+			}
+		}
+		if intent.BackendType == "erasure" {
+			return nil
+		}
+		return e.deleteReplicatedFile(ctx, intent.Path, intent.BackendType)
+	}
+	return fmt.Errorf("unknown intent operation %q", intent.Op)
+}
+
+// intentMatches reports whether md places the file where intent did
+func intentMatches(intent *metadata.Intent, md *metadata.Metadata) bool {
+	owner := ""
+	if md.CallFSInstanceID != nil {
+		owner = *md.CallFSInstanceID
+	}
+	return md.BackendType == intent.BackendType && owner == intent.Owner
+}
+
+// intentLocation returns metadata locating the content an intent touched
+func intentLocation(intent *metadata.Intent) *metadata.Metadata {
+	md := &metadata.Metadata{Path: intent.Path, BackendType: intent.BackendType}
+	if intent.Owner != "" {
+		owner := intent.Owner
+		md.CallFSInstanceID = &owner
+	}
+	return md
+}
@@ -0,0 +1,360 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/backends/noop"
+	"github.com/ebogdum/callfs/locks"
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// Actions of lifecycle rules
+const (
+	LifecycleDelete          = "delete"            // Delete matching files
+	LifecycleMoveToS3        = "move_to_s3"        // Move matching localfs files to S3
+	LifecycleDeleteEmptyDirs = "delete_empty_dirs" // Delete matching directories once empty
+)
+
+// maxLifecycleActions bounds the actions listed in a report; all are counted
+// and logged
+const maxLifecycleActions = 1000
+
+// ErrLifecycleRunning is returned when the lifecycle rules are already being
+// applied on this instance
+var ErrLifecycleRunning = errors.New("lifecycle rules are already being applied")
+
+// LifecycleRule acts on the entries at or below Prefix whose name matches
+// Pattern and that were last modified more than OlderThan ago
+type LifecycleRule struct {
+	Name      string        `json:"name"`
+	Prefix    string        `json:"prefix"`
+	Pattern   string        `json:"pattern,omitempty"` // path.Match glob; all names when empty
+	OlderThan time.Duration `json:"older_than"`
+	Action    string        `json:"action"`
+}
+
+// MarshalJSON encodes OlderThan as a Go duration string, such as "720h0m0s"
+func (r LifecycleRule) MarshalJSON() ([]byte, error) {
+	type rule LifecycleRule
+	return json.Marshal(struct {
+		rule
+		OlderThan string `json:"older_than"`
+	}{rule(r), r.OlderThan.String()})
+}
+
+// LifecycleAction is one entry a rule matched
+type LifecycleAction struct {
+	Rule   string    `json:"rule"`
+	Action string    `json:"action"`
+	Path   string    `json:"path"`
+	Size   int64     `json:"size"`
+	MTime  time.Time `json:"mtime"`
+	Done   bool      `json:"done"`            // False in a dry run, or when it failed
+	Error  string    `json:"error,omitempty"` // Why it failed
+}
+
+// LifecycleReport is the outcome of one application of the lifecycle rules
+type LifecycleReport struct {
+	InstanceID string            `json:"instance_id"`
+	DryRun     bool              `json:"dry_run"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	Scanned    int64             `json:"scanned"` // Entries examined, by all rules
+	Matched    int               `json:"matched"`
+	Applied    int               `json:"applied"`
+	Failed     int               `json:"failed"`
+	Actions    []LifecycleAction `json:"actions"` // The first 1000 matched
+}
+
+// lifecycleState holds the lifecycle rules and guards their application on
+// this instance
+type lifecycleState struct {
+	running sync.Mutex
+	rules   []LifecycleRule
+}
+
+// SetLifecycleRules sets the rules ApplyLifecycle applies
+func (e *Engine) SetLifecycleRules(rules []LifecycleRule) {
+	for i := range rules {
+		rules[i].Prefix = path.Clean(rules[i].Prefix)
+	}
+	e.lifecycle.rules = rules
+}
+
+// LifecycleRules returns the rules ApplyLifecycle applies
+func (e *Engine) LifecycleRules() []LifecycleRule {
+	return append([]LifecycleRule{}, e.lifecycle.rules...)
+}
+
+// RunLifecycle applies the lifecycle rules once and logs the outcome, as the
+// scheduled lifecycle job does
+func (e *Engine) RunLifecycle(ctx context.Context, dryRun bool) error {
+	report, err := e.ApplyLifecycle(ctx, dryRun)
+	if err != nil {
+		return err
+	}
+	e.logger.Info("Lifecycle rules applied",
+		zap.Bool("dry_run", report.DryRun),
+		zap.Int64("scanned", report.Scanned),
+		zap.Int("matched", report.Matched),
+		zap.Int("applied", report.Applied),
+		zap.Int("failed", report.Failed))
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d entries matched by lifecycle rules could not be handled", report.Failed, report.Matched)
+	}
+	return nil
+}
+
+// ApplyLifecycle applies each lifecycle rule to the entries it matches, in
+// the order the rules are set: deletes old files, moves old files from local
+// filesystems to S3, or deletes old directories that are empty, including
+// those emptied by the deletion of their subdirectories. With dryRun, the
+// entries are only reported. Every entry matched is logged. Snapshots and
+// paths in read-only mode are left alone.
+func (e *Engine) ApplyLifecycle(ctx context.Context, dryRun bool) (*LifecycleReport, error) {
+	if !e.lifecycle.running.TryLock() {
+		return nil, ErrLifecycleRunning
+	}
+	defer e.lifecycle.running.Unlock()
+	// The rules act for the cluster, so peers do not check them against the
+	// permissions of an admin applying them
+	ctx = auth.WithUserID(ctx, auth.InternalProxyUserID)
+
+	report := &LifecycleReport{
+		InstanceID: e.currentInstanceID,
+		DryRun:     dryRun,
+		StartedAt:  time.Now().UTC(),
+		Actions:    []LifecycleAction{},
+	}
+	for _, rule := range e.lifecycle.rules {
+		if err := e.applyLifecycleRule(ctx, rule, dryRun, report); err != nil {
+			report.FinishedAt = time.Now().UTC()
+			return report, fmt.Errorf("lifecycle rule %s: %w", rule.Name, err)
+		}
+	}
+	report.FinishedAt = time.Now().UTC()
+	return report, nil
+}
+
+// applyLifecycleRule applies one rule, adding what it matched to report
+func (e *Engine) applyLifecycleRule(ctx context.Context, rule LifecycleRule, dryRun bool, report *LifecycleReport) error {
+	if rule.Action == LifecycleMoveToS3 {
+		if _, disabled := e.s3Backend.(*noop.NoopAdapter); disabled {
+			return fmt.Errorf("no S3 backend is configured")
+		}
+	}
+
+	cutoff := time.Now().Add(-rule.OlderThan)
+	matches := func(md *metadata.Metadata) bool {
+		if !md.MTime.Before(cutoff) || e.IsSnapshotPath(md.Path) || e.IsReadOnly(md.Path) {
+			return false
+		}
+		if rule.Action != LifecycleMoveToS3 && md.Held(time.Now()) {
+			return false
+		}
+		if matched, _ := path.Match(rule.Pattern, md.Name); rule.Pattern != "" && !matched {
+			return false
+		}
+		switch rule.Action {
+		case LifecycleDeleteEmptyDirs:
+			return md.Type == "directory"
+		case LifecycleMoveToS3:
+			return md.Type == "file" && md.BackendType == "localfs" && !md.ErasureCoded
+		default:
+			return md.Type == "file"
+		}
+	}
+
+	// Directories are deleted deepest first, so parents emptied by the
+	// deletion of their children go too
+	var dirs []*metadata.Metadata
+	err := e.walkDescendants(ctx, rule.Prefix, func(md *metadata.Metadata) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		report.Scanned++
+		if !matches(md) {
+			return nil
+		}
+		if rule.Action == LifecycleDeleteEmptyDirs {
+			dirs = append(dirs, md)
+			return nil
+		}
+		e.recordLifecycleAction(ctx, rule, md, dryRun, report, func() (bool, error) {
+			if rule.Action == LifecycleMoveToS3 {
+				return e.moveToS3(ctx, md.Path, cutoff)
+			}
+			return e.deleteExpired(ctx, md.Path, cutoff)
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// A dry run deletes nothing, so counts as gone the directories it reported
+	reported := make(map[string]bool)
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		md := dirs[i]
+		children, err := e.metadataStore.ListChildren(ctx, md.Path)
+		if err != nil && !errors.Is(err, metadata.ErrNotFound) {
+			return fmt.Errorf("failed to list %s: %w", md.Path, err)
+		}
+		empty := true
+		for _, child := range children {
+			empty = empty && reported[child.Path]
+		}
+		if !empty {
+			continue
+		}
+		if dryRun {
+			reported[md.Path] = true
+		}
+		e.recordLifecycleAction(ctx, rule, md, dryRun, report, func() (bool, error) {
+			return e.deleteExpired(ctx, md.Path, cutoff)
+		})
+	}
+	return nil
+}
+
+// recordLifecycleAction applies apply to md unless dryRun, then logs the
+// outcome and adds it to report. apply reports false for an entry changed
+// since it was matched, which is left alone.
+func (e *Engine) recordLifecycleAction(ctx context.Context, rule LifecycleRule, md *metadata.Metadata, dryRun bool, report *LifecycleReport, apply func() (bool, error)) {
+	action := LifecycleAction{Rule: rule.Name, Action: rule.Action, Path: md.Path, Size: md.Size, MTime: md.MTime}
+	if !dryRun {
+		done, err := apply()
+		if err == nil && !done {
+			return
+		}
+		action.Done = done
+		if err != nil {
+			action.Error = err.Error()
+		}
+	}
+
+	fields := []zap.Field{
+		zap.String("rule", rule.Name),
+		zap.String("action", rule.Action),
+		zap.String("path", md.Path),
+		zap.Int64("size", md.Size),
+		zap.Time("mtime", md.MTime),
+		zap.Bool("dry_run", dryRun),
+	}
+	report.Matched++
+	switch {
+	case action.Error != "":
+		report.Failed++
+		e.ctxLogger(ctx).Warn("Lifecycle action failed", append(fields, zap.String("error", action.Error))...)
+	case action.Done:
+		report.Applied++
+		e.ctxLogger(ctx).Info("Lifecycle action applied", fields...)
+	default:
+		e.ctxLogger(ctx).Info("Lifecycle action matched", fields...)
+	}
+	if len(report.Actions) < maxLifecycleActions {
+		report.Actions = append(report.Actions, action)
+	}
+}
+
+// deleteExpired deletes the file or empty directory at filePath, where it is
+// stored, unless it was modified after cutoff. The instance deleting it
+// checks again under the lock of the path, so a file rewritten since it was
+// read here is kept.
+func (e *Engine) deleteExpired(ctx context.Context, filePath string, cutoff time.Time) (bool, error) {
+	md, err := e.metadataStore.Get(ctx, filePath)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read metadata: %w", err)
+	}
+	if !md.MTime.Before(cutoff) {
+		return false, nil
+	}
+
+	if md.CallFSInstanceID != nil && *md.CallFSInstanceID != e.currentInstanceID {
+		err = e.DeleteFileOnInstanceIfUnmodified(ctx, *md.CallFSInstanceID, filePath, cutoff)
+	} else {
+		err = e.DeleteFileIfUnmodified(ctx, filePath, cutoff)
+	}
+	if errors.Is(err, ErrDirectoryNotEmpty) || errors.Is(err, metadata.ErrModified) || errors.Is(err, metadata.ErrNotFound) {
+		return false, nil // Written to or removed since it was matched
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// moveToS3 moves the localfs file at filePath to S3 under its lock, unless it
+// was modified after cutoff. A copy left on this instance is deleted; copies
+// on other instances are left to their garbage collection, which finds them
+// stray.
+func (e *Engine) moveToS3(ctx context.Context, filePath string, cutoff time.Time) (bool, error) {
+	lockKey := fmt.Sprintf("file:%s", filePath)
+	token, acquired, err := e.lockManager.Acquire(ctx, lockKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	if !acquired {
+		return false, fmt.Errorf("file is locked by another operation")
+	}
+	defer func() {
+		if err := e.lockManager.Release(context.Background(), lockKey, token); err != nil {
+			e.ctxLogger(ctx).Error("Failed to release lock", zap.String("lock_key", lockKey), zap.Error(err))
+		}
+	}()
+	ctx, stopRenewal := locks.KeepAlive(ctx, e.lockManager, lockKey, token, e.logger)
+	defer stopRenewal()
+
+	// Re-read under the lock; a writer may have replaced or removed the file
+	md, err := e.metadataStore.Get(ctx, filePath)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read metadata: %w", err)
+	}
+	if md.Type != "file" || md.BackendType != "localfs" || md.ErasureCoded || !md.MTime.Before(cutoff) {
+		return false, nil
+	}
+	if err := e.checkNotMoving(ctx, filePath); err != nil {
+		return false, err
+	}
+	local := ownedBy(md, e.currentInstanceID)
+
+	if _, err := e.copyFromOwner(ctx, md, e.s3Backend); err != nil {
+		return false, err
+	}
+	if err := e.lockMovedObject(ctx, md); err != nil {
+		return false, fmt.Errorf("failed to lock object: %w", err)
+	}
+	md.BackendType = "s3"
+	md.CallFSInstanceID = nil
+	md.UpdatedAt = time.Now()
+	if err := e.metadataStore.Update(ctx, md); err != nil {
+		return false, fmt.Errorf("failed to update metadata: %w", err)
+	}
+	e.invalidatePathAndParent(ctx, filePath)
+
+	if local {
+		if err := e.localFSBackend.Delete(ctx, strings.TrimPrefix(filePath, "/")); err != nil {
+			e.ctxLogger(ctx).Warn("Failed to delete local copy of file moved to S3", zap.String("path", filePath), zap.Error(err))
+		}
+	}
+	return true, nil
+}
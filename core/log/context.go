@@ -0,0 +1,47 @@
+package log
+
+import (
+	"context"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+type contextKey struct{}
+
+var requestIDKey = contextKey{}
+
+// RequestIDHeader is the HTTP header used to carry request IDs between
+// clients and CallFS instances.
+const RequestIDHeader = "X-Request-ID"
+
+// WithRequestID returns a copy of ctx carrying the given request ID
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestIDFromContext returns the request ID stored in ctx, or "" if none
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// WithContext returns logger annotated with the request ID from ctx so that
+// every line logged while serving a request can be correlated.
+func WithContext(ctx context.Context, logger *zap.Logger) *zap.Logger {
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		return logger.With(zap.String("request_id", requestID))
+	}
+	return logger
+}
+
+// PropagateRequestID copies the request ID from ctx onto an outbound
+// inter-node request so the receiving instance logs under the same ID.
+func PropagateRequestID(ctx context.Context, req *http.Request) {
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		req.Header.Set(RequestIDHeader, requestID)
+	}
+}
@@ -0,0 +1,59 @@
+package log
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Log outputs other than file paths
+const (
+	OutputStderr = "stderr"
+	OutputStdout = "stdout"
+	OutputSyslog = "syslog" // The local syslog daemon, or syslog://host:port over UDP and syslog+tcp://host:port
+)
+
+// NewCore returns a core writing the entries level enables, encoded by
+// encoder, to output: stderr, stdout, syslog, or a file rotated per rotation
+func NewCore(output string, encoder zapcore.Encoder, level zapcore.LevelEnabler, rotation RotationOptions) (zapcore.Core, error) {
+	switch {
+	case output == OutputStderr:
+		return zapcore.NewCore(encoder, zapcore.Lock(os.Stderr), level), nil
+	case output == OutputStdout:
+		return zapcore.NewCore(encoder, zapcore.Lock(os.Stdout), level), nil
+	case output == OutputSyslog || strings.HasPrefix(output, "syslog://") || strings.HasPrefix(output, "syslog+tcp://"):
+		network, addr, err := syslogAddress(output)
+		if err != nil {
+			return nil, err
+		}
+		return newSyslogCore(network, addr, encoder, level)
+	}
+
+	file, err := OpenRotatingFile(output, rotation)
+	if err != nil {
+		return nil, err
+	}
+	return zapcore.NewCore(encoder, file, level), nil
+}
+
+// syslogAddress returns the network and address of a syslog output, both
+// empty for the local daemon
+func syslogAddress(output string) (string, string, error) {
+	if output == OutputSyslog {
+		return "", "", nil
+	}
+	u, err := url.Parse(output)
+	if err != nil || u.Host == "" || (u.Path != "" && u.Path != "/") {
+		return "", "", fmt.Errorf("log output %q must be syslog, syslog://host:port or syslog+tcp://host:port", output)
+	}
+	switch u.Scheme {
+	case "syslog":
+		return "udp", u.Host, nil
+	case "syslog+tcp":
+		return "tcp", u.Host, nil
+	}
+	return "", "", fmt.Errorf("log output %q must be syslog, syslog://host:port or syslog+tcp://host:port", output)
+}
@@ -0,0 +1,34 @@
+package log
+
+import "testing"
+
+func TestSyslogAddress(t *testing.T) {
+	tests := []struct {
+		output, network, addr string
+		ok                    bool
+	}{
+		{"syslog", "", "", true},
+		{"syslog://logs:514", "udp", "logs:514", true},
+		{"syslog+tcp://logs:601", "tcp", "logs:601", true},
+		{"syslog://", "", "", false},
+		{"syslog://logs:514/path", "", "", false},
+		{"syslog+tls://logs:6514", "", "", false},
+	}
+	for _, tt := range tests {
+		network, addr, err := syslogAddress(tt.output)
+		if network != tt.network || addr != tt.addr || (err == nil) != tt.ok {
+			t.Errorf("syslogAddress(%q) = %q, %q, %v", tt.output, network, addr, err)
+		}
+	}
+}
+
+func TestNewCoreSyslogLikeFileNames(t *testing.T) {
+	// Paths that merely start with "syslog" are log files
+	t.Chdir(t.TempDir())
+	for _, output := range []string{"syslog.log", "syslog-callfs"} {
+		core, err := NewCore(output, nil, nil, RotationOptions{})
+		if err != nil || core == nil {
+			t.Errorf("%s: %v", output, err)
+		}
+	}
+}
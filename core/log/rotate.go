@@ -0,0 +1,197 @@
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat stamps rotated files, and sorts them oldest first
+const backupTimeFormat = "20060102T150405.000"
+
+// RotationOptions configures the rotation of a log file. A zero value never
+// rotates it.
+type RotationOptions struct {
+	MaxBytes   int64         // Rotate before the file grows past this size; 0 disables
+	Interval   time.Duration // Rotate files open this long; 0 disables
+	MaxBackups int           // Rotated files kept; 0 keeps all
+	MaxAge     time.Duration // Rotated files older than this are removed; 0 keeps all
+	Compress   bool          // Gzip rotated files
+}
+
+// RotatingFile is a log file that is renamed to path.<time> and reopened
+// empty when it grows too large or has been open too long. Old rotated files
+// are compressed and removed in the background.
+type RotatingFile struct {
+	path string
+	opts RotationOptions
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	cleaning sync.Mutex // Held while rotated files are compressed and removed
+}
+
+// OpenRotatingFile opens the log file at path for appending, creating it and
+// its directory if needed
+func OpenRotatingFile(path string, opts RotationOptions) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, opts: opts}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open log file %s: %w", f.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file %s: %w", f.path, err)
+	}
+	f.file, f.size, f.openedAt = file, info.Size(), time.Now()
+	return nil
+}
+
+// Write appends p to the file, rotating it first when due. An entry larger
+// than MaxBytes is written to a file of its own.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tooLarge := f.opts.MaxBytes > 0 && f.size > 0 && f.size+int64(len(p)) > f.opts.MaxBytes
+	tooOld := f.opts.Interval > 0 && time.Since(f.openedAt) >= f.opts.Interval
+	if tooLarge || tooOld {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Sync flushes the file to disk
+func (f *RotatingFile) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Sync()
+}
+
+// Close closes the file
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+// rotate renames the file aside and reopens it (caller must hold the lock)
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file for rotation: %w", err)
+	}
+	backup := f.backupName(time.Now())
+	if err := os.Rename(f.path, backup); err != nil && !os.IsNotExist(err) {
+		// Keep writing to the same file rather than losing entries
+		if openErr := f.open(); openErr != nil {
+			return openErr
+		}
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	go f.cleanup()
+	return nil
+}
+
+// backupName returns the name a file rotated at now is renamed to. A rotation
+// within the same millisecond as the last takes the next free millisecond,
+// so it does not replace the file rotated before.
+func (f *RotatingFile) backupName(now time.Time) string {
+	for {
+		name := f.path + "." + now.Format(backupTimeFormat)
+		_, err := os.Lstat(name)
+		_, gzErr := os.Lstat(name + ".gz")
+		if os.IsNotExist(err) && os.IsNotExist(gzErr) {
+			return name
+		}
+		now = now.Add(time.Millisecond)
+	}
+}
+
+// cleanup compresses rotated files and removes those beyond MaxBackups or
+// older than MaxAge
+func (f *RotatingFile) cleanup() {
+	f.cleaning.Lock()
+	defer f.cleaning.Unlock()
+
+	backups, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return
+	}
+	// Names end in their rotation time, so they sort oldest first
+	backups = slices.DeleteFunc(backups, func(name string) bool {
+		_, err := backupTime(f.path, name)
+		return err != nil
+	})
+	slices.Sort(backups)
+
+	for i, name := range backups {
+		rotatedAt, _ := backupTime(f.path, name)
+		expired := f.opts.MaxAge > 0 && time.Since(rotatedAt) > f.opts.MaxAge
+		surplus := f.opts.MaxBackups > 0 && i < len(backups)-f.opts.MaxBackups
+		if expired || surplus {
+			os.Remove(name)
+			continue
+		}
+		if f.opts.Compress && !strings.HasSuffix(name, ".gz") {
+			compressFile(name)
+		}
+	}
+}
+
+// backupTime returns the rotation time of name, a rotated file of path
+func backupTime(path, name string) (time.Time, error) {
+	stamp := strings.TrimSuffix(strings.TrimPrefix(name, path+"."), ".gz")
+	return time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+}
+
+// compressFile replaces name with name.gz
+func compressFile(name string) {
+	src, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+	if err != nil {
+		return
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return
+	}
+	os.Remove(name)
+}
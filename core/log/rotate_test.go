@@ -0,0 +1,105 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rotated returns the rotated files of path, oldest first
+func rotated(t *testing.T, path string) []string {
+	t.Helper()
+	names, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestRotatingFileRotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "callfs.log")
+	f, err := OpenRotatingFile(path, RotationOptions{MaxBytes: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Entries are never split, and one past MaxBytes gets a file of its own
+	for _, entry := range []string{"aaaa\n", "bbbb\n", "cccc\n", "a very long entry\n", "dd\n"} {
+		if _, err := f.Write([]byte(entry)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var contents []string
+	for _, name := range append(rotated(t, path), path) {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(data))
+	}
+	want := []string{"aaaa\nbbbb\n", "cccc\n", "a very long entry\n", "dd\n"}
+	if !slices.Equal(contents, want) {
+		t.Errorf("rotated and current contents %q, want %q", contents, want)
+	}
+}
+
+func TestRotatingFileKeepsRotationsInSameMillisecond(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "callfs.log")
+	f, err := OpenRotatingFile(path, RotationOptions{MaxBytes: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for range 5 {
+		if _, err := f.Write([]byte("x\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if backups := rotated(t, path); len(backups) != 4 {
+		t.Errorf("rotated files %v, want 4", backups)
+	}
+}
+
+func TestRotatingFileCleanup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "callfs.log")
+	now := time.Now()
+	for _, age := range []time.Duration{72 * time.Hour, 3 * time.Hour, 2 * time.Hour, time.Hour} {
+		name := path + "." + now.Add(-age).Format(backupTimeFormat)
+		if err := os.WriteFile(name, []byte("entry\n"), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Files not named like rotations are left alone
+	other := path + ".old"
+	if err := os.WriteFile(other, nil, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &RotatingFile{path: path, opts: RotationOptions{MaxBackups: 2, MaxAge: 48 * time.Hour, Compress: true}}
+	f.cleanup()
+
+	backups := rotated(t, path)
+	want := []string{
+		path + "." + now.Add(-2*time.Hour).Format(backupTimeFormat) + ".gz",
+		path + "." + now.Add(-time.Hour).Format(backupTimeFormat) + ".gz",
+		other,
+	}
+	if !slices.Equal(backups, want) {
+		t.Errorf("after cleanup %v, want %v", backups, want)
+	}
+	for _, name := range backups {
+		if strings.HasSuffix(name, ".gz") {
+			if rotatedAt, err := backupTime(path, name); err != nil || now.Sub(rotatedAt) > 3*time.Hour {
+				t.Errorf("%s: rotation time %v, %v", name, rotatedAt, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package log
+
+import (
+	"fmt"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func newSyslogCore(network, addr string, encoder zapcore.Encoder, level zapcore.LevelEnabler) (zapcore.Core, error) {
+	return nil, fmt.Errorf("syslog log output is not supported on this platform")
+}
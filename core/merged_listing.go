@@ -0,0 +1,313 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/backends"
+	"github.com/ebogdum/callfs/backends/internalproxy"
+	"github.com/ebogdum/callfs/backends/noop"
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// ErrNotDirectory is returned when a directory operation names a file
+var ErrNotDirectory = errors.New("path is not a directory")
+
+// Kinds of inconsistency reported by ListDirectoryMerged
+const (
+	IssueUntracked    = "untracked"     // Stored in a backend but has no metadata
+	IssueMissing      = "missing"       // Its owner or backend does not hold it
+	IssueStray        = "stray"         // A copy outside its owner or backend
+	IssueTypeMismatch = "type_mismatch" // A file where metadata has a directory, or the reverse
+	IssueSizeMismatch = "size_mismatch" // The stored size differs from the metadata
+)
+
+// s3Location names the S3 backend among the locations of a merged listing
+const s3Location = "s3"
+
+// ListingIssue is one inconsistency of a merged listing entry
+type ListingIssue struct {
+	Kind     string `json:"kind"`
+	Location string `json:"location"` // Instance ID, or "s3"
+	Detail   string `json:"detail,omitempty"`
+}
+
+// MergedEntry is one child of a directory as seen by the metadata store and
+// every backend
+type MergedEntry struct {
+	Name      string         `json:"name"`
+	Path      string         `json:"path"`
+	Type      string         `json:"type"`
+	Size      int64          `json:"size"`
+	Tracked   bool           `json:"tracked"`           // Has metadata
+	Backend   string         `json:"backend,omitempty"` // From the metadata
+	Owner     string         `json:"owner,omitempty"`   // Instance owning a localfs entry
+	Locations []string       `json:"locations"`         // Where the entry was found
+	Issues    []ListingIssue `json:"issues,omitempty"`
+}
+
+// MergedListing is a directory listing reconciled across the metadata store,
+// the local filesystem of every instance and S3
+type MergedListing struct {
+	Path         string         `json:"path"`
+	Entries      []*MergedEntry `json:"entries"`
+	Inconsistent int            `json:"inconsistent"` // Entries with at least one issue
+	// Locations that could not be listed, with the reason. Entries they should
+	// hold are not reported missing.
+	Unreachable map[string]string `json:"unreachable,omitempty"`
+}
+
+// LocalListing lists what this instance's local filesystem backend holds in
+// a directory. Temporary upload files and erasure shards are left out, and a
+// directory that does not exist locally is empty.
+func (e *Engine) LocalListing(ctx context.Context, path string) (*internalproxy.Listing, error) {
+	listing := &internalproxy.Listing{InstanceID: e.currentInstanceID, Path: path, Entries: []internalproxy.ListingEntry{}}
+	children, err := e.localFSBackend.ListDirectory(ctx, strings.TrimPrefix(path, "/"))
+	if errors.Is(err, metadata.ErrNotFound) {
+		return listing, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range children {
+		if isInternalBackendEntry(path, child.Name) {
+			continue
+		}
+		listing.Entries = append(listing.Entries, internalproxy.ListingEntry{Name: child.Name, Type: child.Type, Size: child.Size})
+	}
+	return listing, nil
+}
+
+// isInternalBackendEntry reports whether a backend entry is CallFS's own
+// bookkeeping rather than a stored file
+func isInternalBackendEntry(dir, name string) bool {
+	return strings.HasPrefix(name, ".callfs-tmp-") || (dir == "/" && name == ".erasure")
+}
+
+// ListDirectoryMerged lists a directory from the metadata store and from
+// every place its children can be stored: the local filesystem of this
+// instance and of each peer, and S3. Entries are matched by name and checked
+// against their metadata, so files that lost their metadata, metadata whose
+// file is gone, copies left on the wrong instance and size or type
+// differences are reported. Locations that cannot be listed are reported as
+// unreachable rather than failing the listing.
+func (e *Engine) ListDirectoryMerged(ctx context.Context, path string) (*MergedListing, error) {
+	md, err := e.metadataStore.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if md.Type != "directory" {
+		return nil, ErrNotDirectory
+	}
+
+	children, err := e.metadataStore.ListChildren(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directory children: %w", err)
+	}
+
+	entries := make(map[string]*MergedEntry, len(children))
+	for _, child := range children {
+		entry := &MergedEntry{
+			Name:      child.Name,
+			Path:      child.Path,
+			Type:      child.Type,
+			Size:      child.Size,
+			Tracked:   true,
+			Backend:   child.BackendType,
+			Locations: []string{},
+		}
+		if child.BackendType == "localfs" && child.CallFSInstanceID != nil {
+			entry.Owner = *child.CallFSInstanceID
+		}
+		entries[child.Name] = entry
+	}
+
+	listings, unreachable := e.listLocations(ctx, path)
+	byMetadata := make(map[string]*metadata.Metadata, len(children))
+	for _, child := range children {
+		byMetadata[child.Name] = child
+	}
+
+	for location, found := range listings {
+		for _, item := range found {
+			entry, ok := entries[item.Name]
+			if !ok {
+				entry = &MergedEntry{
+					Name:      item.Name,
+					Path:      strings.TrimSuffix(path, "/") + "/" + item.Name,
+					Type:      item.Type,
+					Size:      item.Size,
+					Locations: []string{},
+				}
+				entries[item.Name] = entry
+			}
+			entry.Locations = append(entry.Locations, location)
+
+			child := byMetadata[item.Name]
+			switch {
+			case child == nil:
+				entry.addIssue(IssueUntracked, location, "")
+			case child.Type != item.Type:
+				entry.addIssue(IssueTypeMismatch, location, fmt.Sprintf("stored as a %s", item.Type))
+			case child.Type != "file" || child.ErasureCoded:
+				// Directories exist wherever files below them were written, and
+				// erasure-coded files are stored as shards elsewhere
+			case !e.holdsFile(child, location):
+				entry.addIssue(IssueStray, location, "")
+			case child.Size != item.Size:
+				entry.addIssue(IssueSizeMismatch, location, fmt.Sprintf("stored size %d", item.Size))
+			}
+		}
+	}
+
+	for _, child := range children {
+		if child.Type != "file" || child.ErasureCoded {
+			continue
+		}
+		location := e.expectedLocation(child)
+		if location == "" {
+			continue
+		}
+		if _, unknown := unreachable[location]; unknown {
+			continue
+		}
+		entry := entries[child.Name]
+		switch _, listed := listings[location]; {
+		case !listed && location == s3Location:
+			entry.addIssue(IssueMissing, location, "the S3 backend is not enabled")
+		case !listed:
+			entry.addIssue(IssueMissing, location, "not a known instance")
+		case !entry.foundAt(location):
+			entry.addIssue(IssueMissing, location, "")
+		}
+	}
+
+	merged := &MergedListing{Path: path, Entries: make([]*MergedEntry, 0, len(entries))}
+	for _, entry := range entries {
+		sort.Strings(entry.Locations)
+		sort.SliceStable(entry.Issues, func(i, j int) bool { return entry.Issues[i].Location < entry.Issues[j].Location })
+		if len(entry.Issues) > 0 {
+			merged.Inconsistent++
+		}
+		merged.Entries = append(merged.Entries, entry)
+	}
+	sort.Slice(merged.Entries, func(i, j int) bool { return merged.Entries[i].Name < merged.Entries[j].Name })
+	if len(unreachable) > 0 {
+		merged.Unreachable = unreachable
+	}
+	return merged, nil
+}
+
+// listLocations lists path on this instance, every peer and S3 concurrently.
+// It returns the entries found by location, and the error of each location
+// that could not be listed.
+func (e *Engine) listLocations(ctx context.Context, path string) (map[string][]internalproxy.ListingEntry, map[string]string) {
+	var (
+		mu          sync.Mutex
+		wg          sync.WaitGroup
+		listings    = make(map[string][]internalproxy.ListingEntry)
+		unreachable = make(map[string]string)
+	)
+	collect := func(location string, list func() ([]internalproxy.ListingEntry, error)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			found, err := list()
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				e.ctxLogger(ctx).Warn("Failed to list directory for merged listing",
+					zap.String("path", path), zap.String("location", location), zap.Error(err))
+				unreachable[location] = err.Error()
+				return
+			}
+			listings[location] = found
+		}()
+	}
+
+	collect(e.currentInstanceID, func() ([]internalproxy.ListingEntry, error) {
+		listing, err := e.LocalListing(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		return listing.Entries, nil
+	})
+	for _, peer := range e.PeerStatuses() {
+		collect(peer.InstanceID, func() ([]internalproxy.ListingEntry, error) {
+			listing, err := e.internalProxyAdapter.LocalListingOnInstance(ctx, peer.InstanceID, path)
+			if err != nil {
+				return nil, err
+			}
+			return listing.Entries, nil
+		})
+	}
+	if _, disabled := e.s3Backend.(*noop.NoopAdapter); !disabled {
+		collect(s3Location, func() ([]internalproxy.ListingEntry, error) {
+			return listBackend(ctx, e.s3Backend, path)
+		})
+	}
+
+	wg.Wait()
+	return listings, unreachable
+}
+
+// listBackend lists a directory of a shared backend as listing entries
+func listBackend(ctx context.Context, storage backends.Storage, path string) ([]internalproxy.ListingEntry, error) {
+	children, err := storage.ListDirectory(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	found := make([]internalproxy.ListingEntry, 0, len(children))
+	for _, child := range children {
+		found = append(found, internalproxy.ListingEntry{Name: child.Name, Type: child.Type, Size: child.Size})
+	}
+	return found, nil
+}
+
+// expectedLocation returns where a file's content should be stored: its
+// owning instance, or S3. It is empty when the metadata names neither.
+func (e *Engine) expectedLocation(md *metadata.Metadata) string {
+	switch {
+	case md.BackendType == "s3":
+		return s3Location
+	case md.BackendType == "localfs" && md.CallFSInstanceID != nil:
+		return *md.CallFSInstanceID
+	}
+	return ""
+}
+
+// holdsFile reports whether location is expected to hold a copy of a file:
+// its owner, or a backend HA replication copies files to. Replicas of S3 files
+// are written to the instance that received the upload, so any instance may
+// hold one.
+func (e *Engine) holdsFile(md *metadata.Metadata, location string) bool {
+	if location == e.expectedLocation(md) {
+		return true
+	}
+	if !e.replicationEnabled {
+		return false
+	}
+	if location == s3Location {
+		return md.BackendType == "localfs" && e.replicaBackend == "s3"
+	}
+	return md.BackendType == "s3" && e.replicaBackend == "localfs"
+}
+
+func (m *MergedEntry) addIssue(kind, location, detail string) {
+	m.Issues = append(m.Issues, ListingIssue{Kind: kind, Location: location, Detail: detail})
+}
+
+func (m *MergedEntry) foundAt(location string) bool {
+	for _, l := range m.Locations {
+		if l == location {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,401 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/backends"
+	"github.com/ebogdum/callfs/backends/internalproxy"
+	"github.com/ebogdum/callfs/backends/noop"
+	"github.com/ebogdum/callfs/locks"
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// Migration targets
+const (
+	MigrationTargetLocal = "local" // The local filesystem of the instance running the migration
+	MigrationTargetS3    = "s3"
+)
+
+// Migration states
+const (
+	MigrationRunning   = "running"
+	MigrationCompleted = "completed"
+	MigrationFailed    = "failed"
+	MigrationCanceled  = "canceled"
+)
+
+// maxMigrationErrors caps the per-entry failures kept in a migration status
+const maxMigrationErrors = 50
+
+// finishedMigrationRetention is how long finished migrations stay listed
+const finishedMigrationRetention = 24 * time.Hour
+
+var (
+	// ErrMigrationNotFound is returned for an unknown migration ID
+	ErrMigrationNotFound = errors.New("migration not found")
+
+	// ErrMigrationRunning is returned when the source instance is already being migrated
+	ErrMigrationRunning = errors.New("a migration of this instance is already running")
+
+	// ErrInvalidMigration is returned for migration requests that cannot be carried out
+	ErrInvalidMigration = errors.New("invalid migration request")
+)
+
+// MigrationRequest selects the instance whose files are moved and where to
+type MigrationRequest struct {
+	SourceInstance string `json:"source_instance"`
+	Target         string `json:"target"` // "local" or "s3"
+}
+
+// MigrationStatus reports the progress of a migration
+type MigrationStatus struct {
+	ID             string     `json:"id"`
+	SourceInstance string     `json:"source_instance"`
+	Target         string     `json:"target"`
+	TargetInstance string     `json:"target_instance,omitempty"` // Set for local migrations
+	State          string     `json:"state"`
+	Total          int        `json:"total"`    // Entries owned by the source when the migration started
+	Migrated       int        `json:"migrated"` // Entries moved so far
+	Skipped        int        `json:"skipped"`  // Entries changed or removed by someone else meanwhile
+	Failed         int        `json:"failed"`
+	BytesCopied    int64      `json:"bytes_copied"`
+	CurrentPath    string     `json:"current_path,omitempty"`
+	Errors         []string   `json:"errors,omitempty"` // The first failures, one per entry
+	Error          string     `json:"error,omitempty"`  // Why the migration stopped early
+	StartedAt      time.Time  `json:"started_at"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+}
+
+type migrationJob struct {
+	mu     sync.Mutex
+	status MigrationStatus
+	cancel context.CancelFunc
+}
+
+func (j *migrationJob) update(fn func(*MigrationStatus)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn(&j.status)
+}
+
+func (j *migrationJob) snapshot() MigrationStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s := j.status
+	s.Errors = append([]string(nil), j.status.Errors...)
+	return s
+}
+
+// StartMigration moves every localfs file and directory owned by
+// req.SourceInstance to this instance's local filesystem or to S3, in the
+// background. Content is streamed from the source through the internal proxy
+// (or from an S3 replica when the source is down), and each entry's owner is
+// updated once its copy is complete. Entries already moved are not owned by
+// the source any more, so running the same migration again resumes it.
+func (e *Engine) StartMigration(req MigrationRequest) (MigrationStatus, error) {
+	req.SourceInstance = strings.TrimSpace(req.SourceInstance)
+	req.Target = strings.ToLower(strings.TrimSpace(req.Target))
+	switch {
+	case req.SourceInstance == "":
+		return MigrationStatus{}, fmt.Errorf("%w: source_instance is required", ErrInvalidMigration)
+	case req.Target != MigrationTargetLocal && req.Target != MigrationTargetS3:
+		return MigrationStatus{}, fmt.Errorf("%w: target must be %q or %q", ErrInvalidMigration, MigrationTargetLocal, MigrationTargetS3)
+	case req.Target == MigrationTargetLocal && req.SourceInstance == e.currentInstanceID:
+		return MigrationStatus{}, fmt.Errorf("%w: files of %s are already local to this instance; run the migration on the instance that should receive them", ErrInvalidMigration, req.SourceInstance)
+	}
+	if _, disabled := e.targetStorage(req.Target).(*noop.NoopAdapter); disabled {
+		return MigrationStatus{}, fmt.Errorf("%w: the %s backend is not configured on this instance", ErrInvalidMigration, req.Target)
+	}
+
+	e.migrationsMu.Lock()
+	defer e.migrationsMu.Unlock()
+	if e.migrations == nil {
+		e.migrations = make(map[string]*migrationJob)
+	}
+	for id, job := range e.migrations {
+		s := job.snapshot()
+		if s.State == MigrationRunning && s.SourceInstance == req.SourceInstance {
+			return s, ErrMigrationRunning
+		}
+		if s.FinishedAt != nil && time.Since(*s.FinishedAt) > finishedMigrationRetention {
+			delete(e.migrations, id)
+		}
+	}
+
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return MigrationStatus{}, fmt.Errorf("failed to generate migration ID: %w", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &migrationJob{
+		status: MigrationStatus{
+			ID:             hex.EncodeToString(idBytes),
+			SourceInstance: req.SourceInstance,
+			Target:         req.Target,
+			State:          MigrationRunning,
+			StartedAt:      time.Now().UTC(),
+		},
+		cancel: cancel,
+	}
+	if req.Target == MigrationTargetLocal {
+		job.status.TargetInstance = e.currentInstanceID
+	}
+	e.migrations[job.status.ID] = job
+
+	go e.runMigration(ctx, job, req)
+	return job.snapshot(), nil
+}
+
+// Migration returns the status of a migration started on this instance
+func (e *Engine) Migration(id string) (MigrationStatus, error) {
+	e.migrationsMu.Lock()
+	job, ok := e.migrations[id]
+	e.migrationsMu.Unlock()
+	if !ok {
+		return MigrationStatus{}, ErrMigrationNotFound
+	}
+	return job.snapshot(), nil
+}
+
+// Migrations lists the migrations started on this instance, newest first
+func (e *Engine) Migrations() []MigrationStatus {
+	e.migrationsMu.Lock()
+	out := make([]MigrationStatus, 0, len(e.migrations))
+	for _, job := range e.migrations {
+		out = append(out, job.snapshot())
+	}
+	e.migrationsMu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].StartedAt.After(out[j].StartedAt) })
+	return out
+}
+
+// CancelMigration stops a running migration after the entry in progress.
+// Entries already moved stay moved.
+func (e *Engine) CancelMigration(id string) (MigrationStatus, error) {
+	e.migrationsMu.Lock()
+	job, ok := e.migrations[id]
+	e.migrationsMu.Unlock()
+	if !ok {
+		return MigrationStatus{}, ErrMigrationNotFound
+	}
+	job.cancel()
+	return job.snapshot(), nil
+}
+
+// cancelMigrations stops every running migration
+func (e *Engine) cancelMigrations() {
+	e.migrationsMu.Lock()
+	defer e.migrationsMu.Unlock()
+	for _, job := range e.migrations {
+		job.cancel()
+	}
+}
+
+func (e *Engine) targetStorage(target string) backends.Storage {
+	if target == MigrationTargetS3 {
+		return e.s3Backend
+	}
+	return e.localFSBackend
+}
+
+// ownedBy reports whether md is stored on the local filesystem of instanceID
+func ownedBy(md *metadata.Metadata, instanceID string) bool {
+	return md.BackendType == "localfs" && md.CallFSInstanceID != nil && *md.CallFSInstanceID == instanceID
+}
+
+func (e *Engine) runMigration(ctx context.Context, job *migrationJob, req MigrationRequest) {
+	logger := e.logger.With(
+		zap.String("migration_id", job.status.ID),
+		zap.String("source_instance", req.SourceInstance),
+		zap.String("target", req.Target))
+	logger.Info("Migration started")
+
+	// Count first so progress can be reported against a total
+	total := 0
+	err := e.walkDescendants(ctx, "/", func(md *metadata.Metadata) error {
+		if ownedBy(md, req.SourceInstance) {
+			total++
+		}
+		return nil
+	})
+	if err == nil {
+		job.update(func(s *MigrationStatus) { s.Total = total })
+
+		// Paths come in order, so directories are moved before their contents
+		err = e.walkDescendants(ctx, "/", func(md *metadata.Metadata) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if !ownedBy(md, req.SourceInstance) {
+				return nil
+			}
+			job.update(func(s *MigrationStatus) { s.CurrentPath = md.Path })
+
+			copied, moved, err := e.migrateEntry(ctx, md.Path, md.Type, req)
+			job.update(func(s *MigrationStatus) {
+				s.BytesCopied += copied
+				switch {
+				case err != nil:
+					s.Failed++
+					if len(s.Errors) < maxMigrationErrors {
+						s.Errors = append(s.Errors, fmt.Sprintf("%s: %v", md.Path, err))
+					}
+				case moved:
+					s.Migrated++
+				default:
+					s.Skipped++
+				}
+			})
+			if err != nil && ctx.Err() == nil {
+				logger.Warn("Failed to migrate entry", zap.String("path", md.Path), zap.Error(err))
+			}
+			return nil
+		})
+	}
+
+	finished := time.Now().UTC()
+	job.update(func(s *MigrationStatus) {
+		s.CurrentPath = ""
+		s.FinishedAt = &finished
+		switch {
+		case errors.Is(err, context.Canceled):
+			s.State = MigrationCanceled
+		case err != nil:
+			s.State = MigrationFailed
+			s.Error = err.Error()
+		case s.Failed > 0:
+			s.State = MigrationFailed
+			s.Error = fmt.Sprintf("%d entries could not be migrated; run the migration again to retry them", s.Failed)
+		default:
+			s.State = MigrationCompleted
+		}
+	})
+	s := job.snapshot()
+	logger.Info("Migration finished",
+		zap.String("state", s.State),
+		zap.Int("migrated", s.Migrated),
+		zap.Int("skipped", s.Skipped),
+		zap.Int("failed", s.Failed),
+		zap.Int64("bytes_copied", s.BytesCopied))
+}
+
+// migrateEntry moves one file or directory under its lock. It reports the
+// bytes copied and whether the entry was moved; an entry that is no longer
+// owned by the source is skipped.
+func (e *Engine) migrateEntry(ctx context.Context, path, entryType string, req MigrationRequest) (int64, bool, error) {
+	lockKey := fmt.Sprintf("file:%s", path)
+	if entryType == "directory" {
+		lockKey = fmt.Sprintf("dir:%s", path)
+	}
+	token, acquired, err := e.lockManager.Acquire(ctx, lockKey)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	if !acquired {
+		return 0, false, fmt.Errorf("entry is locked by another operation")
+	}
+	defer func() {
+		if err := e.lockManager.Release(context.Background(), lockKey, token); err != nil {
+			e.logger.Error("Failed to release lock", zap.String("lock_key", lockKey), zap.Error(err))
+		}
+	}()
+	ctx, stopRenewal := locks.KeepAlive(ctx, e.lockManager, lockKey, token, e.logger)
+	defer stopRenewal()
+
+	// Re-read under the lock; a writer may have replaced or removed the entry
+	md, err := e.metadataStore.Get(ctx, path)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read metadata: %w", err)
+	}
+	if err := e.checkNotMoving(ctx, path); err != nil {
+		return 0, false, err
+	}
+	if !ownedBy(md, req.SourceInstance) {
+		return 0, false, nil
+	}
+
+	target := e.targetStorage(req.Target)
+	relativePath := strings.TrimPrefix(path, "/")
+	var copied int64
+	if md.Type == "directory" {
+		if err := target.CreateDirectory(ctx, relativePath); err != nil {
+			return 0, false, fmt.Errorf("failed to create directory: %w", err)
+		}
+	} else {
+		if copied, err = e.copyFromOwner(ctx, md, target); err != nil {
+			return copied, false, err
+		}
+	}
+
+	if req.Target == MigrationTargetLocal {
+		md.CallFSInstanceID = &e.currentInstanceID
+	} else {
+		md.BackendType = "s3"
+		md.CallFSInstanceID = nil
+	}
+	md.UpdatedAt = time.Now()
+	if err := e.metadataStore.Update(ctx, md); err != nil {
+		return copied, false, fmt.Errorf("failed to update metadata: %w", err)
+	}
+	e.invalidatePathAndParent(ctx, path)
+
+	if md.Type == "file" {
+		if err := e.replicateFileToSecondaryBackend(ctx, path, md.Size, md.BackendType); err != nil {
+			e.logger.Warn("Migrated file could not be replicated", zap.String("path", path), zap.Error(err))
+		}
+	}
+	return copied, true, nil
+}
+
+// copyFromOwner streams a file from its current owner into target. Targets
+// overwrite, so a copy left behind by an interrupted run is replaced.
+func (e *Engine) copyFromOwner(ctx context.Context, md *metadata.Metadata, target backends.Storage) (int64, error) {
+	relativePath := strings.TrimPrefix(md.Path, "/")
+	sourceCtx, source := e.selectBackend(ctx, md)
+	reader, err := source.Open(sourceCtx, relativePath)
+	if errors.Is(err, internalproxy.ErrPeerUnavailable) {
+		reader, err = e.failoverToReplica(ctx, md, err, func(s backends.Storage) (io.ReadCloser, error) {
+			return s.Open(ctx, relativePath)
+		})
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open source: %w", err)
+	}
+	defer reader.Close()
+
+	counter := &countingReader{r: reader}
+	if err := target.Update(ctx, relativePath, counter, md.Size); err != nil {
+		return counter.n, fmt.Errorf("failed to write copy: %w", err)
+	}
+	if counter.n != md.Size {
+		return counter.n, fmt.Errorf("copied %d bytes, expected %d", counter.n, md.Size)
+	}
+	return counter.n, nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
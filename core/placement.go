@@ -0,0 +1,206 @@
+package core
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/backends"
+	"github.com/ebogdum/callfs/backends/internalproxy"
+	"github.com/ebogdum/callfs/discovery"
+)
+
+// Placement policies for new localfs files
+const (
+	PlacementLocal    = "local"    // The instance receiving the create owns the file
+	PlacementHash     = "hash"     // Consistent hashing of the path over healthy instances
+	PlacementCapacity = "capacity" // Consistent hashing weighted by each instance's free space
+)
+
+// capacityRefreshInterval is how often free space is collected for capacity
+// placement. A healthy instance with unknown free space triggers an earlier
+// refresh, at most every capacityRetryInterval.
+const (
+	capacityRefreshInterval = 30 * time.Second
+	capacityRetryInterval   = 5 * time.Second
+)
+
+type localPlacementKey struct{}
+
+// WithLocalPlacement marks ctx so that new files are created on this instance
+// whatever the placement policy. Creates forwarded by another instance carry
+// it, as they have already been placed.
+func WithLocalPlacement(ctx context.Context) context.Context {
+	return context.WithValue(ctx, localPlacementKey{}, true)
+}
+
+// placer chooses the owner of new localfs files
+type placer struct {
+	policy string
+
+	mu          sync.RWMutex
+	capacity    map[string]int64 // Free bytes by instance ID as of the last refresh
+	refreshedAt time.Time
+
+	refresh chan struct{}
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// SetPlacementPolicy selects which instance owns new localfs files. With
+// PlacementHash or PlacementCapacity a create received by this instance is
+// forwarded to the chosen owner through the internal proxy.
+func (e *Engine) SetPlacementPolicy(policy string) {
+	p := &placer{policy: policy, refresh: make(chan struct{}, 1), stop: make(chan struct{})}
+	e.placement = p
+	if policy != PlacementCapacity {
+		return
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(capacityRefreshInterval)
+		defer ticker.Stop()
+		for {
+			e.refreshCapacity()
+			select {
+			case <-ticker.C:
+			case <-p.refresh:
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopPlacement stops the capacity refresher, if any
+func (e *Engine) stopPlacement() {
+	if e.placement != nil {
+		close(e.placement.stop)
+		e.placement.wg.Wait()
+	}
+}
+
+// refreshCapacity collects the free space of this instance and its healthy peers
+func (e *Engine) refreshCapacity() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	capacity := make(map[string]int64)
+	if cr, ok := e.localFSBackend.(backends.CapacityReporter); ok {
+		if free, _, err := cr.Capacity(ctx); err == nil {
+			capacity[e.currentInstanceID] = free
+		} else {
+			e.logger.Warn("Failed to read local capacity", zap.Error(err))
+		}
+	}
+	if e.internalProxyAdapter != nil {
+		for _, peer := range e.internalProxyAdapter.PeerStatuses() {
+			if peer.State != internalproxy.CircuitClosed {
+				continue
+			}
+			c, err := e.internalProxyAdapter.CapacityOnInstance(ctx, peer.InstanceID)
+			if err != nil {
+				e.logger.Debug("Failed to read peer capacity", zap.String("peer", peer.InstanceID), zap.Error(err))
+				continue
+			}
+			capacity[peer.InstanceID] = c.FreeBytes
+		}
+	}
+
+	e.placement.mu.Lock()
+	e.placement.capacity = capacity
+	e.placement.refreshedAt = time.Now()
+	e.placement.mu.Unlock()
+}
+
+// placeFile returns the instance that should own a new localfs file at path.
+// Only this instance and peers whose circuit is closed are candidates, so a
+// failed peer stops receiving files until it recovers. With gossip, peers
+// that advertise they do not take the file, as they are draining, read-only
+// for path or without a local backend, are left out too.
+func (e *Engine) placeFile(ctx context.Context, path string) string {
+	p := e.placement
+	if p == nil || p.policy == PlacementLocal || e.internalProxyAdapter == nil || ctx.Value(localPlacementKey{}) != nil {
+		return e.currentInstanceID
+	}
+
+	advertised := make(map[string]discovery.Advertisement)
+	for _, m := range e.Members() {
+		if m.State == "alive" {
+			advertised[m.InstanceID] = m.Advertisement
+		}
+	}
+	weights := map[string]float64{e.currentInstanceID: 1}
+	for _, peer := range e.internalProxyAdapter.PeerStatuses() {
+		if peer.State != internalproxy.CircuitClosed {
+			continue
+		}
+		if a, ok := advertised[peer.InstanceID]; ok && !a.Accepts("localfs", path) {
+			continue
+		}
+		weights[peer.InstanceID] = 1
+	}
+
+	if p.policy == PlacementCapacity {
+		unknown := false
+		p.mu.RLock()
+		for id := range weights {
+			// Instances whose free space is unknown are left out until the next refresh
+			free, ok := p.capacity[id]
+			unknown = unknown || !ok
+			weights[id] = float64(max(free, 0))
+		}
+		stale := time.Since(p.refreshedAt) > capacityRetryInterval
+		p.mu.RUnlock()
+		if unknown && stale {
+			select {
+			case p.refresh <- struct{}{}:
+			default:
+			}
+		}
+	}
+
+	if owner := rendezvousOwner(path, weights); owner != "" {
+		return owner
+	}
+	return e.currentInstanceID
+}
+
+// rendezvousOwner picks the instance with the highest weighted rendezvous
+// score for key. Adding or removing an instance only moves the keys that it
+// wins or held, and each instance wins keys in proportion to its weight.
+func rendezvousOwner(key string, weights map[string]float64) string {
+	owner, best := "", math.Inf(-1)
+	for id, weight := range weights {
+		if weight <= 0 {
+			continue
+		}
+		h := fnv.New64a()
+		h.Write([]byte(id))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		// Map the hash to a uniform value in (0, 1). FNV-1a barely mixes the
+		// last bytes into the high bits, so finish it first.
+		u := (float64(mix64(h.Sum64())>>11) + 0.5) / (1 << 53)
+		if score := -weight / math.Log(u); score > best || (score == best && id < owner) {
+			owner, best = id, score
+		}
+	}
+	return owner
+}
+
+// mix64 is the splitmix64 finalizer: every input bit affects every output bit.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
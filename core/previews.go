@@ -0,0 +1,205 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/metadata"
+	"github.com/ebogdum/callfs/metrics"
+	"github.com/ebogdum/callfs/preview"
+)
+
+// PreviewNamespace is the directory rendered previews are cached in. Its
+// entries belong to root, so only the preview endpoint serves them.
+const PreviewNamespace = "/.previews"
+
+// previewState holds the renderer of SetPreviewer
+type previewState struct {
+	renderer    *preview.Renderer
+	backendType string        // Backend previews are cached in
+	slots       chan struct{} // Bounds the renders in progress
+}
+
+// SetPreviewer enables Preview, rendering previews with renderer at most
+// concurrency at a time and caching them in backendType
+func (e *Engine) SetPreviewer(renderer *preview.Renderer, backendType string, concurrency int) {
+	e.previews = &previewState{
+		renderer:    renderer,
+		backendType: backendType,
+		slots:       make(chan struct{}, max(concurrency, 1)),
+	}
+}
+
+// PreviewsEnabled reports whether SetPreviewer was called
+func (e *Engine) PreviewsEnabled() bool {
+	return e.previews != nil
+}
+
+// PreviewTag identifies the preview of md fitting within width by height. It
+// changes whenever the file's content does.
+func PreviewTag(md *metadata.Metadata, width, height int) string {
+	return fmt.Sprintf("%dx%d-%s", width, height, ContentVersion(md))
+}
+
+// previewDir is the directory of the cached previews of path, named after
+// its hash so the namespace does not reveal file names
+func previewDir(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return PreviewNamespace + "/" + hex.EncodeToString(sum[:16])
+}
+
+// Preview returns a JPEG preview of the file of md that fits within width by
+// height, and its size. Previews are rendered once per version of the file
+// and cached below PreviewNamespace; a new version replaces those of the
+// previous one. Files no preview can be rendered of return
+// preview.ErrUnsupported.
+func (e *Engine) Preview(ctx context.Context, md *metadata.Metadata, width, height int) (io.ReadCloser, int64, error) {
+	if md.Type != "file" || !e.previews.renderer.Supports(md.Name) {
+		metrics.PreviewRequestsTotal.WithLabelValues("unsupported").Inc()
+		return nil, 0, preview.ErrUnsupported
+	}
+	tag := PreviewTag(md, width, height)
+	dir := previewDir(md.Path)
+	cachePath := dir + "/" + tag + ".jpg"
+
+	// Only previews written by the engine, owned by root, are trusted
+	if cached, err := e.GetMetadata(ctx, cachePath); err == nil && cached.Type == "file" && cached.UID == 0 {
+		reader, err := e.openContent(ctx, cachePath, cached)
+		if err == nil {
+			metrics.PreviewRequestsTotal.WithLabelValues("hit").Inc()
+			return reader, cached.Size, nil
+		}
+		e.ctxLogger(ctx).Warn("Failed to open cached preview", zap.String("path", md.Path), zap.Error(err))
+	}
+
+	select {
+	case e.previews.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+	defer func() { <-e.previews.slots }()
+
+	start := time.Now()
+	data, err := e.renderPreview(ctx, md, width, height)
+	if err != nil {
+		if errors.Is(err, preview.ErrUnsupported) {
+			metrics.PreviewRequestsTotal.WithLabelValues("unsupported").Inc()
+		} else {
+			metrics.PreviewRequestsTotal.WithLabelValues("error").Inc()
+		}
+		return nil, 0, err
+	}
+	metrics.PreviewRenderDuration.Observe(time.Since(start).Seconds())
+	metrics.PreviewRequestsTotal.WithLabelValues("rendered").Inc()
+
+	// A preview that could not be cached is still served
+	if err := e.cachePreview(ctx, dir, cachePath, tag, data); err != nil {
+		e.ctxLogger(ctx).Warn("Failed to cache preview", zap.String("path", md.Path), zap.Error(err))
+	}
+	return io.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
+
+// dropPreviews removes the cached previews of the file at path, once it was
+// updated, deleted or moved away. Failures are logged; the previews of a new
+// version never match the old ones.
+func (e *Engine) dropPreviews(ctx context.Context, path string) {
+	if e.previews == nil || path == PreviewNamespace || strings.HasPrefix(path, PreviewNamespace+"/") {
+		return
+	}
+	dir := previewDir(path)
+	if _, err := e.metadataStore.Get(ctx, dir); err != nil {
+		return
+	}
+	entries, err := e.metadataStore.ListChildren(ctx, dir)
+	if err == nil {
+		for _, entry := range entries {
+			if err = e.DeleteFile(ctx, entry.Path); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+				break
+			}
+		}
+	}
+	if err == nil || errors.Is(err, metadata.ErrNotFound) {
+		err = e.DeleteFile(ctx, dir)
+	}
+	if err != nil && !errors.Is(err, metadata.ErrNotFound) {
+		e.ctxLogger(ctx).Warn("Failed to remove cached previews", zap.String("path", path), zap.Error(err))
+	}
+}
+
+// dropMovedPreviews removes the cached previews of the files that a rename
+// of oldPath, a directory, moved to newPath
+func (e *Engine) dropMovedPreviews(ctx context.Context, oldPath, newPath string) {
+	if e.previews == nil {
+		return
+	}
+	err := e.walkDescendants(ctx, newPath, func(item *metadata.Metadata) error {
+		if item.Type == "file" {
+			e.dropPreviews(ctx, oldPath+strings.TrimPrefix(item.Path, newPath))
+		}
+		return nil
+	})
+	if err != nil {
+		e.ctxLogger(ctx).Warn("Failed to remove cached previews of moved files", zap.String("path", oldPath), zap.Error(err))
+	}
+}
+
+// renderPreview reads the file of md as it is downloaded, through any
+// pre-read hooks, and renders its preview
+func (e *Engine) renderPreview(ctx context.Context, md *metadata.Metadata, width, height int) ([]byte, error) {
+	reader, err := e.GetFile(ctx, md.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return e.previews.renderer.Render(ctx, md.Name, reader, width, height)
+}
+
+// cachePreview stores a rendered preview at cachePath, readable by root only,
+// and removes the previews in dir of other versions of the file
+func (e *Engine) cachePreview(ctx context.Context, dir, cachePath, tag string, data []byte) error {
+	now := time.Now()
+	private := metadata.Metadata{
+		Mode:        "0700",
+		UID:         0,
+		GID:         0,
+		BackendType: e.previews.backendType,
+		ATime:       now,
+		MTime:       now,
+		CTime:       now,
+	}
+	if _, err := e.MakeDirectory(ctx, dir, true, &private); err != nil {
+		return err
+	}
+
+	fileMd := private
+	fileMd.Name = tag + ".jpg"
+	fileMd.Type = "file"
+	fileMd.Mode = "0600"
+	err := e.CreateFile(ctx, cachePath, bytes.NewReader(data), int64(len(data)), &fileMd)
+	if err != nil && !errors.Is(err, metadata.ErrAlreadyExists) {
+		return err
+	}
+
+	_, version, _ := strings.Cut(tag, "-")
+	entries, err := e.ListDirectory(ctx, dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Type == "file" && !strings.HasSuffix(entry.Name, "-"+version+".jpg") {
+			if err := e.DeleteFile(ctx, entry.Path); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+				e.ctxLogger(ctx).Warn("Failed to remove outdated preview", zap.String("path", entry.Path), zap.Error(err))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package core
+
+import (
+	"path"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// ReadOnlyMode is which files of the instance refuse changes
+type ReadOnlyMode struct {
+	Instance bool     `json:"instance"` // Every path
+	Prefixes []string `json:"prefixes"` // Paths at or below these
+}
+
+// readOnlyState is the read-only mode of the instance
+type readOnlyState struct {
+	mu   sync.RWMutex
+	mode ReadOnlyMode
+}
+
+// SetReadOnly replaces the read-only mode of the instance. The engine refuses
+// uploads, new directories, deletes, moves and attribute changes of the paths
+// it covers with backends.ErrReadOnly, whatever front end or peer asks for
+// them; reads go on.
+func (e *Engine) SetReadOnly(mode ReadOnlyMode) {
+	prefixes := make([]string, 0, len(mode.Prefixes))
+	for _, prefix := range mode.Prefixes {
+		prefixes = append(prefixes, path.Clean("/"+prefix))
+	}
+	mode.Prefixes = prefixes
+
+	e.readOnly.mu.Lock()
+	e.readOnly.mode = mode
+	e.readOnly.mu.Unlock()
+
+	if mode.Instance || len(mode.Prefixes) > 0 {
+		e.logger.Info("Read-only mode set", zap.Bool("instance", mode.Instance), zap.Strings("prefixes", mode.Prefixes))
+	}
+	e.advertise()
+}
+
+// ReadOnlyMode returns the read-only mode of the instance
+func (e *Engine) ReadOnlyMode() ReadOnlyMode {
+	e.readOnly.mu.RLock()
+	defer e.readOnly.mu.RUnlock()
+	mode := e.readOnly.mode
+	mode.Prefixes = append([]string{}, mode.Prefixes...)
+	return mode
+}
+
+// IsReadOnly reports whether changes to filePath are refused
+func (e *Engine) IsReadOnly(filePath string) bool {
+	e.readOnly.mu.RLock()
+	defer e.readOnly.mu.RUnlock()
+	if e.readOnly.mode.Instance {
+		return true
+	}
+	filePath = path.Clean("/" + filePath)
+	for _, prefix := range e.readOnly.mode.Prefixes {
+		if prefix == "/" || filePath == prefix || strings.HasPrefix(filePath, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,324 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/backends"
+	"github.com/ebogdum/callfs/backends/internalproxy"
+	"github.com/ebogdum/callfs/backends/noop"
+	"github.com/ebogdum/callfs/locks"
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// ErrInvalidRename is returned for a move that can never succeed, such as
+// moving a directory into itself
+var ErrInvalidRename = errors.New("invalid rename")
+
+// LocalRename moves a file or directory on this instance's local filesystem
+// backend, without touching the metadata store. A path that is not stored
+// locally is not an error.
+func (e *Engine) LocalRename(ctx context.Context, oldPath, newPath string) error {
+	if e.IsReadOnly(oldPath) || e.IsReadOnly(newPath) {
+		return backends.ErrReadOnly
+	}
+	return renameInBackend(ctx, e.localFSBackend, oldPath, newPath)
+}
+
+// RenameSubtree moves a file, or a directory and everything below it, to
+// newPath. The content is moved first on every instance and backend holding
+// part of it, then the metadata of the whole subtree is rewritten in one
+// step; content already moved is moved back if a later step fails. A
+// directory is marked as moving for the duration, so writes and creations
+// below it fail rather than land on content being moved. The parent of
+// newPath must be an existing directory. Single-use links keep pointing at
+// the old paths.
+func (e *Engine) RenameSubtree(ctx context.Context, oldPath, newPath string) error {
+	oldPath, newPath = filepath.Clean(oldPath), filepath.Clean(newPath)
+	switch {
+	case oldPath == "/":
+		return fmt.Errorf("%w: the root directory cannot be moved", ErrInvalidRename)
+	case newPath == "/" || newPath == oldPath:
+		return fmt.Errorf("%w: the destination is the source or the root directory", ErrInvalidRename)
+	case strings.HasPrefix(newPath, oldPath+"/"):
+		return fmt.Errorf("%w: a directory cannot be moved into itself", ErrInvalidRename)
+	}
+
+	md, err := e.metadataStore.Get(ctx, oldPath)
+	if err != nil {
+		return err
+	}
+
+	lockPrefix := "file:"
+	if md.Type == "directory" {
+		lockPrefix = "dir:"
+	}
+	lockKeys := []string{lockPrefix + oldPath, lockPrefix + newPath}
+	for _, lockKey := range lockKeys {
+		token, acquired, err := e.lockManager.Acquire(ctx, lockKey)
+		if err != nil {
+			return fmt.Errorf("failed to acquire lock: %w", err)
+		}
+		if !acquired {
+			return fmt.Errorf("failed to acquire lock for rename")
+		}
+		defer func() {
+			if err := e.lockManager.Release(context.Background(), lockKey, token); err != nil {
+				e.ctxLogger(ctx).Error("Failed to release lock", zap.String("lock_key", lockKey), zap.Error(err))
+			}
+		}()
+
+		var stopRenewal func()
+		ctx, stopRenewal = locks.KeepAlive(ctx, e.lockManager, lockKey, token, e.logger)
+		defer stopRenewal()
+	}
+
+	// Re-read under the lock; a writer may have replaced or removed the entry
+	if md, err = e.metadataStore.Get(ctx, oldPath); err != nil {
+		return err
+	}
+	if _, err := e.metadataStore.Get(ctx, newPath); err == nil {
+		return metadata.ErrAlreadyExists
+	} else if !errors.Is(err, metadata.ErrNotFound) {
+		return fmt.Errorf("failed to check destination: %w", err)
+	}
+	parent, err := e.metadataStore.Get(ctx, filepath.Dir(newPath))
+	if err != nil {
+		return err
+	}
+	if parent.Type != "directory" {
+		return fmt.Errorf("destination parent: %w", ErrNotDirectory)
+	}
+	// The move is itself a write below the parents of both paths
+	for _, entryPath := range []string{oldPath, newPath} {
+		if err := e.checkNotMoving(ctx, entryPath); err != nil {
+			return err
+		}
+	}
+	if md.Type == "directory" {
+		var unmark func()
+		ctx, unmark, err = e.markMoving(ctx, oldPath)
+		if err != nil {
+			return err
+		}
+		defer unmark()
+	}
+
+	peers, useS3, err := e.renameLocations(ctx, md)
+	if err != nil {
+		return err
+	}
+
+	// Move the content, remembering how to move it back. Rollback runs even
+	// if the request was canceled.
+	var undo []func() error
+	rollback := func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			if err := undo[i](); err != nil {
+				e.ctxLogger(ctx).Error("Failed to move content back after a failed rename",
+					zap.String("from", newPath), zap.String("to", oldPath), zap.Error(err))
+			}
+		}
+	}
+	move := func(rename func(ctx context.Context, from, to string) error) error {
+		if err := rename(ctx, oldPath, newPath); err != nil {
+			rollback()
+			return err
+		}
+		undo = append(undo, func() error { return rename(context.WithoutCancel(ctx), newPath, oldPath) })
+		return nil
+	}
+
+	if err := move(e.LocalRename); err != nil {
+		return fmt.Errorf("failed to move local content: %w", err)
+	}
+	for _, peer := range peers {
+		err := move(func(ctx context.Context, from, to string) error {
+			if e.internalProxyAdapter == nil {
+				return fmt.Errorf("%w: no peers are configured", internalproxy.ErrPeerUnavailable)
+			}
+			return e.internalProxyAdapter.RenameOnInstance(ctx, peer, from, to)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to move content on instance %s: %w", peer, err)
+		}
+	}
+	if useS3 {
+		err := move(func(ctx context.Context, from, to string) error {
+			return renameInBackend(ctx, e.s3Backend, from, to)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to move S3 content: %w", err)
+		}
+	}
+
+	if err := e.metadataStore.RenameSubtree(ctx, oldPath, newPath); err != nil {
+		rollback()
+		if errors.Is(err, metadata.ErrNotFound) || errors.Is(err, metadata.ErrAlreadyExists) {
+			return err
+		}
+		return fmt.Errorf("failed to rename metadata: %w", err)
+	}
+
+	e.invalidatePathAndParent(ctx, oldPath)
+	e.invalidatePathAndParent(ctx, newPath)
+	e.unindexInBackground(ctx, oldPath)
+	e.indexInBackground(ctx, newPath)
+	e.clearAccessStats(ctx, oldPath)
+	if md.Type == "file" {
+		e.dropPreviews(ctx, oldPath)
+	} else {
+		e.dropMovedPreviews(ctx, oldPath, newPath)
+	}
+
+	e.ctxLogger(ctx).Info("Renamed successfully",
+		zap.String("from", oldPath),
+		zap.String("to", newPath),
+		zap.String("type", md.Type),
+		zap.Strings("peers", peers),
+		zap.Bool("s3", useS3))
+
+	return nil
+}
+
+// moveMarkerPrefix namespaces the lock a directory move holds on the
+// directory moved, which writers below it check for (see checkNotMoving)
+const moveMarkerPrefix = "move:"
+
+// markMoving holds the move marker of dir until the returned function is
+// called, so writes and creations below dir fail from then on. The returned
+// context is canceled if the marker is lost. It fails without holding it if
+// an entry below dir is being written or created: those hold the lock of
+// their path while they check for the marker, so either they find it or the
+// move finds their lock.
+func (e *Engine) markMoving(ctx context.Context, dir string) (context.Context, func(), error) {
+	lockKey := moveMarkerPrefix + dir
+	token, acquired, err := e.lockManager.Acquire(ctx, lockKey)
+	if err != nil {
+		return ctx, nil, fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	if !acquired {
+		return ctx, nil, fmt.Errorf("failed to acquire lock for rename: %s is being moved", dir)
+	}
+	markCtx, stopRenewal := locks.KeepAlive(ctx, e.lockManager, lockKey, token, e.logger)
+	unmark := func() {
+		stopRenewal()
+		if err := e.lockManager.Release(context.Background(), lockKey, token); err != nil {
+			e.ctxLogger(ctx).Error("Failed to release lock", zap.String("lock_key", lockKey), zap.Error(err))
+		}
+	}
+
+	if err := e.checkNoWriters(ctx, dir); err != nil {
+		unmark()
+		return ctx, nil, err
+	}
+	return markCtx, unmark, nil
+}
+
+// checkNoWriters fails if an entry below dir is locked, which is an entry
+// being written or created
+func (e *Engine) checkNoWriters(ctx context.Context, dir string) error {
+	for _, prefix := range []string{"file:", "dir:"} {
+		held, err := e.lockManager.List(ctx, prefix+dir+"/")
+		if err != nil {
+			return fmt.Errorf("failed to list locks: %w", err)
+		}
+		if len(held) > 0 {
+			entryPath := strings.TrimPrefix(held[0].Key, prefix)
+			return fmt.Errorf("failed to acquire lock for rename: %s is being changed", entryPath)
+		}
+	}
+	return nil
+}
+
+// checkNotMoving fails if a directory above path is being moved. Writers
+// and creations call it holding the lock of path: a move that marked the
+// directory first fails the write, and one that marks it later finds the
+// lock of path held and fails itself, so nothing is written below a
+// directory while it moves away. Writers only read the markers, so they do
+// not hold each other up.
+func (e *Engine) checkNotMoving(ctx context.Context, path string) error {
+	var markers []string
+	for dir := filepath.Dir(path); dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		markers = append(markers, moveMarkerPrefix+dir)
+	}
+	if len(markers) == 0 {
+		return nil // The root is never moved
+	}
+	moving, err := e.lockManager.Held(ctx, markers...)
+	if err != nil {
+		return fmt.Errorf("failed to check for moves: %w", err)
+	}
+	if moving {
+		return fmt.Errorf("failed to change %s: a directory above it is being moved", path)
+	}
+	return nil
+}
+
+// renameLocations returns the peers and whether S3 hold content of the
+// subtree rooted at md, or ErrHeld if a file of the subtree is immutable
+func (e *Engine) renameLocations(ctx context.Context, md *metadata.Metadata) ([]string, bool, error) {
+	owners := make(map[string]bool)
+	useS3 := false
+	visit := func(item *metadata.Metadata) error {
+		if err := checkHold(item); err != nil {
+			return err
+		}
+		if item.ErasureCoded {
+			return nil // Shards keep their own paths
+		}
+		switch {
+		case item.BackendType == "s3":
+			useS3 = true
+		case item.BackendType == "localfs" && item.CallFSInstanceID != nil:
+			owners[*item.CallFSInstanceID] = true
+			if item.Type == "file" && e.replicaBackendFor(item) != nil {
+				useS3 = true
+			}
+		}
+		return nil
+	}
+	if err := visit(md); err != nil {
+		return nil, false, err
+	}
+	if md.Type == "directory" {
+		if err := e.walkDescendants(ctx, md.Path, visit); err != nil {
+			if errors.Is(err, ErrHeld) {
+				return nil, false, err
+			}
+			return nil, false, fmt.Errorf("failed to list directory %s: %w", md.Path, err)
+		}
+	}
+
+	peers := make([]string, 0, len(owners))
+	for owner := range owners {
+		if owner != e.currentInstanceID {
+			peers = append(peers, owner)
+		}
+	}
+	sort.Strings(peers)
+	if _, disabled := e.s3Backend.(*noop.NoopAdapter); disabled || e.s3Backend == nil {
+		useS3 = false
+	}
+	return peers, useS3, nil
+}
+
+// renameInBackend moves a path within storage. A path the backend does not
+// hold is not an error, as directories only exist where files were written.
+func renameInBackend(ctx context.Context, storage backends.Storage, oldPath, newPath string) error {
+	renamer, ok := storage.(backends.Renamer)
+	if !ok {
+		return fmt.Errorf("backend does not support renaming")
+	}
+	err := renamer.Rename(ctx, strings.TrimPrefix(oldPath, "/"), strings.TrimPrefix(newPath, "/"))
+	if errors.Is(err, metadata.ErrNotFound) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,30 @@
+package core
+
+import (
+	"context"
+
+	"github.com/ebogdum/callfs/backends"
+)
+
+// CacheFlushResult counts what a cache flush removed on one instance
+type CacheFlushResult struct {
+	InstanceID string `json:"instance_id"`
+	Path       string `json:"path,omitempty"` // Flushed path, or empty for the whole cache
+	Files      int    `json:"files"`
+	Bytes      int64  `json:"bytes"`
+}
+
+// FlushS3Cache drops path from this instance's S3 content cache, or empties
+// the cache when path is empty. It returns backends.ErrCacheDisabled when no
+// cache is configured.
+func (e *Engine) FlushS3Cache(ctx context.Context, path string) (*CacheFlushResult, error) {
+	cache, ok := e.s3Backend.(backends.ContentCache)
+	if !ok {
+		return nil, backends.ErrCacheDisabled
+	}
+	files, bytes, err := cache.FlushCache(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &CacheFlushResult{InstanceID: e.currentInstanceID, Path: path, Files: files, Bytes: bytes}, nil
+}
@@ -0,0 +1,337 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/backends"
+	"github.com/ebogdum/callfs/metadata"
+	"github.com/ebogdum/callfs/metrics"
+	"github.com/ebogdum/callfs/scan"
+)
+
+// Actions taken on an upload a scanner finds infected
+const (
+	ScanActionReject     = "reject"     // Fail the upload; nothing is stored
+	ScanActionQuarantine = "quarantine" // Fail the upload and move its content below the quarantine path
+	ScanActionTag        = "tag"        // Store the upload and set an extended attribute naming the threat
+)
+
+// errUploadAborted ends the scan of an upload the backend failed to store
+var errUploadAborted = errors.New("upload aborted")
+
+// ScanPolicy configures what happens to scanned uploads
+type ScanPolicy struct {
+	Action         string // ScanActionReject, ScanActionQuarantine or ScanActionTag
+	QuarantinePath string // Directory quarantined uploads are moved below, keeping their path
+	TagXAttr       string // Extended attribute set to the threat found when tagging
+	FailOpen       bool   // Store uploads the scanner fails on instead of rejecting them
+	SpoolDir       string // Directory updates are scanned into; the system temp directory when empty
+}
+
+// scanState holds the scanner of SetScanner
+type scanState struct {
+	scanner scan.Scanner
+	policy  ScanPolicy
+}
+
+// SetScanner makes create stream uploaded content through scanner as it is
+// stored, and update scan it into a spool before it is stored, and apply
+// policy to its verdict before any metadata is committed
+func (e *Engine) SetScanner(scanner scan.Scanner, policy ScanPolicy) {
+	e.scanning = &scanState{scanner: scanner, policy: policy}
+}
+
+// scanUpload starts scanning the upload of path read from reader. It returns
+// nil when scanning is disabled or the content is known to be empty, as
+// touch creates it; a size of 0 also stands for chunked uploads.
+func (e *Engine) scanUpload(ctx context.Context, path string, reader io.Reader) *scanningReader {
+	if e.scanning == nil {
+		return nil
+	}
+	if empty, ok := reader.(*bytes.Reader); ok && empty.Len() == 0 {
+		return nil
+	}
+	pipeReader, pipeWriter := io.Pipe()
+	s := &scanningReader{
+		reader:  reader,
+		pipe:    pipeWriter,
+		results: make(chan scanResult, 1),
+		state:   e.scanning,
+		path:    path,
+		start:   time.Now(),
+		logger:  e.ctxLogger(ctx),
+	}
+	go func() {
+		verdict, err := e.scanning.scanner.Scan(ctx, pipeReader)
+		// A scanner that stopped reading early fails later writes instead of
+		// blocking them
+		pipeReader.Close()
+		s.results <- scanResult{verdict: verdict, err: err}
+	}()
+	return s
+}
+
+// scanResult is what a scanner returned
+type scanResult struct {
+	verdict scan.Verdict
+	err     error
+}
+
+// scanningReader copies what is read from an upload to a scanner. When the
+// upload ends it waits for the verdict, and returns the error that rejects
+// the upload instead of io.EOF, so backends abort the write.
+type scanningReader struct {
+	reader  io.Reader
+	pipe    *io.PipeWriter // nil once the scanner stopped reading
+	results chan scanResult
+	state   *scanState
+	path    string
+	start   time.Time
+	logger  *zap.Logger
+	done    bool
+	verdict scan.Verdict
+	err     error // Rejects the upload
+}
+
+func (s *scanningReader) Read(p []byte) (int, error) {
+	n, err := s.reader.Read(p)
+	if n > 0 && s.pipe != nil {
+		if _, writeErr := s.pipe.Write(p[:n]); writeErr != nil {
+			s.pipe = nil // The scanner failed; finish reports why
+		}
+	}
+	if err == io.EOF {
+		if _, rejectErr := s.finish(); rejectErr != nil {
+			return n, rejectErr
+		}
+	}
+	return n, err
+}
+
+// finish ends the content sent to the scanner and returns its verdict, with
+// scan.ErrContentInfected when the policy rejects infected uploads or
+// scan.ErrScanFailed when the scan failed and the policy fails closed. A nil
+// reader returns a clean verdict.
+func (s *scanningReader) finish() (scan.Verdict, error) {
+	if s == nil {
+		return scan.Verdict{}, nil
+	}
+	if s.done {
+		return s.verdict, s.err
+	}
+	s.done = true
+	if s.pipe != nil {
+		s.pipe.Close()
+	}
+	result := <-s.results
+
+	name, policy := s.state.scanner.Name(), s.state.policy
+	outcome := "clean"
+	switch {
+	case result.err != nil:
+		outcome = "error"
+		s.logger.Warn("Failed to scan upload",
+			zap.String("path", s.path), zap.String("scanner", name), zap.Bool("fail_open", policy.FailOpen), zap.Error(result.err))
+		if !policy.FailOpen {
+			s.err = fmt.Errorf("%w: %v", scan.ErrScanFailed, result.err)
+		}
+	case result.verdict.Infected:
+		outcome = "infected"
+		s.verdict = result.verdict
+		metrics.ScanDetectionsTotal.WithLabelValues(policy.Action).Inc()
+		s.logger.Warn("Malware found in upload",
+			zap.String("path", s.path), zap.String("scanner", name),
+			zap.String("signature", result.verdict.Signature), zap.String("action", policy.Action))
+		if policy.Action == ScanActionReject {
+			s.err = fmt.Errorf("%w: %s", scan.ErrContentInfected, result.verdict.Signature)
+		}
+	}
+	metrics.ScanDuration.WithLabelValues(name, outcome).Observe(time.Since(s.start).Seconds())
+	return s.verdict, s.err
+}
+
+// failure returns the error a finished scan rejected the upload with, so
+// backend errors caused by it can be reported as such
+func (s *scanningReader) failure() error {
+	if s == nil || !s.done {
+		return nil
+	}
+	return s.err
+}
+
+// stop ends the scan of an upload that was not stored
+func (s *scanningReader) stop() {
+	if s == nil || s.done {
+		return
+	}
+	s.done = true
+	if s.pipe != nil {
+		s.pipe.CloseWithError(errUploadAborted)
+	}
+	<-s.results
+}
+
+// actOnInfected applies the scan policy to the infected content of path,
+// stored at the same path in storage with md. Tagged content is kept; a
+// quarantined upload is moved and fails with scan.ErrContentInfected.
+func (e *Engine) actOnInfected(ctx context.Context, storage backends.Storage, path string, md *metadata.Metadata, verdict scan.Verdict) error {
+	relativePath := strings.TrimPrefix(path, "/")
+	policy := e.scanning.policy
+	if policy.Action == ScanActionTag {
+		setter, ok := storage.(backends.XAttrSetter)
+		if !ok {
+			e.ctxLogger(ctx).Warn("Infected upload kept untagged; its backend has no extended attributes",
+				zap.String("path", path), zap.String("backend", md.BackendType))
+			return nil
+		}
+		if err := setter.SetXAttr(ctx, relativePath, policy.TagXAttr, verdict.Signature); err != nil {
+			e.ctxLogger(ctx).Warn("Failed to tag infected upload", zap.String("path", path), zap.Error(err))
+		}
+		return nil
+	}
+
+	infected := fmt.Errorf("%w: %s", scan.ErrContentInfected, verdict.Signature)
+	renamer, ok := storage.(backends.Renamer)
+	if policy.Action != ScanActionQuarantine || !ok {
+		if err := storage.Delete(ctx, relativePath); err != nil {
+			e.ctxLogger(ctx).Error("Failed to remove infected upload", zap.String("path", path), zap.Error(err))
+		}
+		return infected
+	}
+
+	quarantinePath := e.quarantinePath(path)
+	if err := e.ensureParentDirectories(ctx, quarantinePath, md.BackendType); err != nil {
+		return fmt.Errorf("failed to quarantine upload: %w", err)
+	}
+	if err := renamer.Rename(ctx, relativePath, strings.TrimPrefix(quarantinePath, "/")); err != nil {
+		return fmt.Errorf("failed to quarantine upload: %w", err)
+	}
+	if err := e.recordQuarantine(ctx, path, quarantinePath, md); err != nil {
+		return err
+	}
+	return infected
+}
+
+// quarantinePath returns a new path below the quarantine path for content
+// uploaded to path
+func (e *Engine) quarantinePath(path string) string {
+	return filepath.Join(e.scanning.policy.QuarantinePath, path) + "." + time.Now().UTC().Format("20060102T150405.000000000Z")
+}
+
+// recordQuarantine stores the metadata of the content uploaded to path, as
+// described by md, now at quarantinePath
+func (e *Engine) recordQuarantine(ctx context.Context, path, quarantinePath string, md *metadata.Metadata) error {
+	quarantined := *md
+	quarantined.ID = 0
+	quarantined.ParentID = nil
+	quarantined.Name = filepath.Base(quarantinePath)
+	quarantined.Path = quarantinePath
+	quarantined.CreatedAt = time.Now()
+	quarantined.UpdatedAt = time.Now()
+	if err := e.metadataStore.Create(ctx, &quarantined); err != nil {
+		return fmt.Errorf("failed to store quarantine metadata: %w", err)
+	}
+	e.invalidatePathAndParent(ctx, quarantinePath)
+	e.ctxLogger(ctx).Warn("Infected upload quarantined",
+		zap.String("path", path), zap.String("quarantine_path", quarantinePath))
+	return nil
+}
+
+// spooledUpload is an upload scanned into a temporary file before it is
+// stored. Closing it removes the file.
+type spooledUpload struct {
+	*os.File
+	size int64
+}
+
+func (s *spooledUpload) Close() error {
+	s.File.Close()
+	return os.Remove(s.Name())
+}
+
+// spoolUpload scans the upload of path read from reader while copying it to
+// a temporary file in the spool directory of the policy, so an update replaces the stored content only once the
+// verdict allows it. It returns the error the scan rejected the upload with,
+// and a nil upload when scanning is disabled or the content is empty.
+func (e *Engine) spoolUpload(ctx context.Context, path string, reader io.Reader) (*spooledUpload, scan.Verdict, error) {
+	scanned := e.scanUpload(ctx, path, reader)
+	if scanned == nil {
+		return nil, scan.Verdict{}, nil
+	}
+	defer scanned.stop()
+
+	file, err := os.CreateTemp(e.scanning.policy.SpoolDir, "callfs-scan-*")
+	if err != nil {
+		return nil, scan.Verdict{}, fmt.Errorf("failed to spool upload: %w", err)
+	}
+	spool := &spooledUpload{File: file}
+	if spool.size, err = io.Copy(file, scanned); err != nil {
+		spool.Close()
+		return nil, scan.Verdict{}, err
+	}
+	verdict, err := scanned.finish()
+	if err != nil {
+		spool.Close()
+		return nil, scan.Verdict{}, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		spool.Close()
+		return nil, scan.Verdict{}, fmt.Errorf("failed to spool upload: %w", err)
+	}
+	return spool, verdict, nil
+}
+
+// quarantineUpdate stores spool, an infected update of the file md that was
+// not written to it, below the quarantine path and fails with
+// scan.ErrContentInfected. The file keeps its previous content.
+func (e *Engine) quarantineUpdate(ctx context.Context, storage backends.Storage, md *metadata.Metadata, spool *spooledUpload, verdict scan.Verdict) error {
+	infected := fmt.Errorf("%w: %s", scan.ErrContentInfected, verdict.Signature)
+	quarantinePath := e.quarantinePath(md.Path)
+	if err := e.ensureParentDirectories(ctx, quarantinePath, md.BackendType); err != nil {
+		return fmt.Errorf("failed to quarantine upload: %w", err)
+	}
+	digest := NewContentDigest(spool, spool.size)
+	if err := storage.Create(ctx, strings.TrimPrefix(quarantinePath, "/"), digest, spool.size); err != nil {
+		return fmt.Errorf("failed to quarantine upload: %w", err)
+	}
+	quarantined := *md
+	quarantined.Size = spool.size
+	quarantined.Checksum = digest.Sum()
+	quarantined.MTime = time.Now()
+	if err := e.recordQuarantine(ctx, md.Path, quarantinePath, &quarantined); err != nil {
+		return err
+	}
+	return infected
+}
+
+// ScanContent scans content the caller buffered instead of storing it
+// through the engine, such as an erasure-coded upload. Infected content
+// returns scan.ErrContentInfected whatever the policy's action.
+func (e *Engine) ScanContent(ctx context.Context, path string, content io.Reader) error {
+	scanned := e.scanUpload(ctx, path, content)
+	if scanned == nil {
+		return nil
+	}
+	defer scanned.stop()
+	if _, err := io.Copy(io.Discard, scanned); err != nil {
+		return err
+	}
+	verdict, err := scanned.finish()
+	if err != nil {
+		return err
+	}
+	if verdict.Infected {
+		return fmt.Errorf("%w: %s", scan.ErrContentInfected, verdict.Signature)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	corelog "github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/metadata"
+	"github.com/ebogdum/callfs/metrics"
+)
+
+// SlowThresholds are the durations past which metadata queries and backend
+// operations are logged as slow. A zero threshold logs none.
+type SlowThresholds struct {
+	MetadataQuery time.Duration
+	BackendOp     time.Duration
+}
+
+// SetSlowThresholds sets when metadata queries and backend operations are
+// logged as slow
+func (e *Engine) SetSlowThresholds(thresholds SlowThresholds) {
+	e.slowBackendOp = thresholds.BackendOp
+	e.metadataStore.slow = thresholds.MetadataQuery
+}
+
+// observeBackendOp records an operation on backend that took duration, and
+// logs it when slow
+func (e *Engine) observeBackendOp(ctx context.Context, backend, operation, path string, duration time.Duration) {
+	metrics.BackendOpsTotal.WithLabelValues(backend, operation).Inc()
+	metrics.BackendOpDuration.WithLabelValues(backend, operation).Observe(duration.Seconds())
+	if e.slowBackendOp > 0 && duration >= e.slowBackendOp {
+		e.ctxLogger(ctx).Warn("Slow backend operation",
+			zap.String("operation", operation),
+			zap.String("backend", backend),
+			zap.String("path", corelog.SanitizePath(path)),
+			zap.Duration("duration", duration))
+	}
+}
+
+// instrumentedStore records the metrics of the queries the engine makes to
+// its metadata store, and logs the slow ones. Queries it does not wrap pass
+// through unmeasured.
+type instrumentedStore struct {
+	metadata.Store
+	slow   time.Duration // Zero logs none
+	logger *zap.Logger
+}
+
+func (s *instrumentedStore) observe(ctx context.Context, operation, path string, start time.Time) {
+	duration := time.Since(start)
+	metrics.MetadataDBQueriesTotal.WithLabelValues(operation).Inc()
+	metrics.MetadataDBQueryDuration.WithLabelValues(operation).Observe(duration.Seconds())
+	if s.slow > 0 && duration >= s.slow {
+		corelog.WithContext(ctx, s.logger).Warn("Slow metadata query",
+			zap.String("operation", operation),
+			zap.String("path", corelog.SanitizePath(path)),
+			zap.Duration("duration", duration))
+	}
+}
+
+func (s *instrumentedStore) Get(ctx context.Context, path string) (*metadata.Metadata, error) {
+	defer s.observe(ctx, "get", path, time.Now())
+	return s.Store.Get(ctx, path)
+}
+
+func (s *instrumentedStore) Create(ctx context.Context, md *metadata.Metadata) error {
+	defer s.observe(ctx, "create", md.Path, time.Now())
+	return s.Store.Create(ctx, md)
+}
+
+func (s *instrumentedStore) Update(ctx context.Context, md *metadata.Metadata) error {
+	defer s.observe(ctx, "update", md.Path, time.Now())
+	return s.Store.Update(ctx, md)
+}
+
+func (s *instrumentedStore) Delete(ctx context.Context, path string) error {
+	defer s.observe(ctx, "delete", path, time.Now())
+	return s.Store.Delete(ctx, path)
+}
+
+func (s *instrumentedStore) Batch(ctx context.Context, ops []metadata.BatchOp) error {
+	path := ""
+	if len(ops) > 0 {
+		path = ops[0].Path
+		if ops[0].Metadata != nil {
+			path = ops[0].Metadata.Path
+		}
+	}
+	defer s.observe(ctx, "batch", path, time.Now())
+	return s.Store.Batch(ctx, ops)
+}
+
+func (s *instrumentedStore) ListChildren(ctx context.Context, parentPath string) ([]*metadata.Metadata, error) {
+	defer s.observe(ctx, "list_children", parentPath, time.Now())
+	return s.Store.ListChildren(ctx, parentPath)
+}
+
+func (s *instrumentedStore) ListDescendants(ctx context.Context, prefix string, limit int, cursor string) ([]*metadata.Metadata, error) {
+	defer s.observe(ctx, "list_descendants", prefix, time.Now())
+	return s.Store.ListDescendants(ctx, prefix, limit, cursor)
+}
+
+func (s *instrumentedStore) RenameSubtree(ctx context.Context, oldPath, newPath string) error {
+	defer s.observe(ctx, "rename_subtree", oldPath, time.Now())
+	return s.Store.RenameSubtree(ctx, oldPath, newPath)
+}
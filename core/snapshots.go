@@ -0,0 +1,632 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/backends"
+	"github.com/ebogdum/callfs/backends/internalproxy"
+	"github.com/ebogdum/callfs/backends/noop"
+	"github.com/ebogdum/callfs/locks"
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// Snapshot errors
+var (
+	// ErrInvalidSnapshot is returned for a malformed snapshot request
+	ErrInvalidSnapshot = errors.New("invalid snapshot request")
+
+	// ErrSnapshotNotFound is returned when no snapshot has the name
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+
+	// ErrSnapshotExists is returned when a snapshot of the name was taken
+	ErrSnapshotExists = errors.New("snapshot already exists")
+)
+
+// Layout of a snapshot in the snapshot directory: <root>/<name>/snapshot.json
+// describes it and <root>/<name>/files holds the copy
+const (
+	snapshotManifest = "snapshot.json"
+	snapshotFiles    = "files"
+)
+
+var snapshotNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// Snapshot is a point-in-time copy of a directory
+type Snapshot struct {
+	Name        string    `json:"name"`
+	Source      string    `json:"source"` // Directory the snapshot was taken of
+	CreatedAt   time.Time `json:"created_at"`
+	Files       int       `json:"files"`
+	Directories int       `json:"directories"`
+	Bytes       int64     `json:"bytes"`
+}
+
+// RestoreRequest names what to restore from a snapshot, and where
+type RestoreRequest struct {
+	Path   string `json:"path,omitempty"`   // Within the snapshot; all of it when empty
+	Target string `json:"target,omitempty"` // Where to restore it; where it was taken from when empty
+}
+
+// RestoreResult counts what a restore wrote
+type RestoreResult struct {
+	Target      string `json:"target"`
+	Files       int    `json:"files"`
+	Directories int    `json:"directories"`
+	Bytes       int64  `json:"bytes"`
+}
+
+// SetSnapshotRoot sets the directory snapshots are kept in. Clients cannot
+// change anything below it. Snapshots are disabled when root is empty.
+func (e *Engine) SetSnapshotRoot(root string) {
+	if root != "" {
+		root = filepath.Clean("/" + root)
+	}
+	e.snapshotRoot = root
+}
+
+// SnapshotsEnabled reports whether snapshots can be taken
+func (e *Engine) SnapshotsEnabled() bool {
+	return e.snapshotRoot != ""
+}
+
+// IsSnapshotPath reports whether filePath is the snapshot directory or below
+// it
+func (e *Engine) IsSnapshotPath(filePath string) bool {
+	if e.snapshotRoot == "" {
+		return false
+	}
+	filePath = filepath.Clean("/" + filePath)
+	return filePath == e.snapshotRoot || strings.HasPrefix(filePath, e.snapshotRoot+"/")
+}
+
+// SnapshotFilesPath returns the path of the copy of filePath, relative to the
+// directory the snapshot was taken of, in snapshot name
+func (e *Engine) SnapshotFilesPath(name, filePath string) (string, error) {
+	if !snapshotNamePattern.MatchString(name) {
+		return "", fmt.Errorf("%w: invalid snapshot name %q", ErrInvalidSnapshot, name)
+	}
+	return filepath.Join(e.snapshotRoot, name, snapshotFiles, filepath.Clean("/"+filePath)), nil
+}
+
+// CreateSnapshot copies the directory source, and everything below it, to a
+// snapshot called name. The metadata is copied and the content shared where
+// the backend can: files on local filesystems are copied on the instance
+// holding them, copy-on-write where the filesystem supports it, and S3
+// objects are copied within the bucket. Erasure-coded files are copied to
+// this instance. Files written while the snapshot is taken may be copied
+// before or after the write.
+func (e *Engine) CreateSnapshot(ctx context.Context, name, source string) (*Snapshot, error) {
+	if !snapshotNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("%w: name must be 1 to 128 letters, digits, '.', '_' or '-', starting with a letter or digit", ErrInvalidSnapshot)
+	}
+	if !strings.HasPrefix(source, "/") {
+		return nil, fmt.Errorf("%w: path must be absolute", ErrInvalidSnapshot)
+	}
+	source = filepath.Clean(source)
+	if e.IsSnapshotPath(source) {
+		return nil, fmt.Errorf("%w: snapshots cannot be taken of snapshots", ErrInvalidSnapshot)
+	}
+
+	lockKey := "snapshot:" + name
+	token, acquired, err := e.lockManager.Acquire(ctx, lockKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	if !acquired {
+		return nil, fmt.Errorf("%w: %s is being taken", ErrSnapshotExists, name)
+	}
+	defer func() {
+		if err := e.lockManager.Release(context.Background(), lockKey, token); err != nil {
+			e.ctxLogger(ctx).Error("Failed to release lock", zap.String("lock_key", lockKey), zap.Error(err))
+		}
+	}()
+	ctx, stopRenewal := locks.KeepAlive(ctx, e.lockManager, lockKey, token, e.logger)
+	defer stopRenewal()
+
+	dir := filepath.Join(e.snapshotRoot, name)
+	if _, err := e.metadataStore.Get(ctx, dir); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotExists, name)
+	} else if !errors.Is(err, metadata.ErrNotFound) {
+		return nil, fmt.Errorf("failed to check snapshot: %w", err)
+	}
+	md, err := e.metadataStore.Get(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	if md.Type != "directory" {
+		return nil, fmt.Errorf("%w: %s is not a directory", ErrInvalidSnapshot, source)
+	}
+
+	snapshot := &Snapshot{Name: name, Source: source, CreatedAt: time.Now().UTC()}
+	filesPath := filepath.Join(dir, snapshotFiles)
+	err = e.ensureParentDirectories(ctx, filesPath, "")
+	if err == nil {
+		err = e.copySubtree(ctx, md, filesPath, snapshot)
+	}
+	if err == nil {
+		err = e.writeSnapshotManifest(ctx, dir, snapshot)
+	}
+	if err != nil {
+		// Remove what was copied, even if the request was canceled
+		if removeErr := e.removeSubtree(context.WithoutCancel(ctx), dir); removeErr != nil && !errors.Is(removeErr, metadata.ErrNotFound) {
+			e.ctxLogger(ctx).Error("Failed to remove incomplete snapshot", zap.String("snapshot", name), zap.Error(removeErr))
+		}
+		return nil, fmt.Errorf("failed to take snapshot %s: %w", name, err)
+	}
+
+	e.invalidateCachePrefix(ctx, dir)
+	e.ctxLogger(ctx).Info("Snapshot taken",
+		zap.String("snapshot", name),
+		zap.String("source", source),
+		zap.Int("files", snapshot.Files),
+		zap.Int("directories", snapshot.Directories),
+		zap.Int64("bytes", snapshot.Bytes))
+
+	return snapshot, nil
+}
+
+// Snapshots returns the snapshots taken, by name. Snapshots being taken are
+// left out.
+func (e *Engine) Snapshots(ctx context.Context) ([]Snapshot, error) {
+	children, err := e.metadataStore.ListChildren(ctx, e.snapshotRoot)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return []Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	snapshots := make([]Snapshot, 0, len(children))
+	for _, child := range children {
+		if child.Type != "directory" {
+			continue
+		}
+		snapshot, err := e.readSnapshotManifest(ctx, child.Path)
+		if errors.Is(err, metadata.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, *snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Name < snapshots[j].Name })
+	return snapshots, nil
+}
+
+// GetSnapshot returns the snapshot called name
+func (e *Engine) GetSnapshot(ctx context.Context, name string) (*Snapshot, error) {
+	if !snapshotNamePattern.MatchString(name) {
+		return nil, ErrSnapshotNotFound
+	}
+	snapshot, err := e.readSnapshotManifest(ctx, filepath.Join(e.snapshotRoot, name))
+	if errors.Is(err, metadata.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotNotFound, name)
+	}
+	return snapshot, err
+}
+
+// DeleteSnapshot deletes the snapshot called name and the copies it holds.
+// Content shared with files still in use stays with them.
+func (e *Engine) DeleteSnapshot(ctx context.Context, name string) error {
+	if !snapshotNamePattern.MatchString(name) {
+		return ErrSnapshotNotFound
+	}
+
+	lockKey := "snapshot:" + name
+	token, acquired, err := e.lockManager.Acquire(ctx, lockKey)
+	if err != nil {
+		return fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	if !acquired {
+		return fmt.Errorf("failed to acquire lock for snapshot deletion")
+	}
+	defer func() {
+		if err := e.lockManager.Release(context.Background(), lockKey, token); err != nil {
+			e.ctxLogger(ctx).Error("Failed to release lock", zap.String("lock_key", lockKey), zap.Error(err))
+		}
+	}()
+	ctx, stopRenewal := locks.KeepAlive(ctx, e.lockManager, lockKey, token, e.logger)
+	defer stopRenewal()
+
+	err = e.removeSubtree(ctx, filepath.Join(e.snapshotRoot, name))
+	if errors.Is(err, metadata.ErrNotFound) {
+		return fmt.Errorf("%w: %s", ErrSnapshotNotFound, name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete snapshot %s: %w", name, err)
+	}
+	e.ctxLogger(ctx).Info("Snapshot deleted", zap.String("snapshot", name))
+	return nil
+}
+
+// RestoreSnapshot writes the files of a snapshot back: all of them, or those
+// at or below req.Path, to where they were taken from or below req.Target.
+// Files are written like uploads and get the mode, owner and times they had;
+// files created since the snapshot was taken are left in place.
+func (e *Engine) RestoreSnapshot(ctx context.Context, name string, req RestoreRequest) (*RestoreResult, error) {
+	snapshot, err := e.GetSnapshot(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	within := filepath.Clean("/" + req.Path)
+	from, err := e.SnapshotFilesPath(name, within)
+	if err != nil {
+		return nil, err
+	}
+	target := filepath.Join(snapshot.Source, within)
+	if req.Target != "" {
+		if !strings.HasPrefix(req.Target, "/") {
+			return nil, fmt.Errorf("%w: target must be absolute", ErrInvalidSnapshot)
+		}
+		target = filepath.Clean(req.Target)
+	}
+	if e.IsSnapshotPath(target) {
+		return nil, fmt.Errorf("%w: snapshots cannot be restored into snapshots", ErrInvalidSnapshot)
+	}
+
+	md, err := e.metadataStore.Get(ctx, from)
+	if errors.Is(err, metadata.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %s is not in snapshot %s", metadata.ErrNotFound, within, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RestoreResult{Target: target}
+	restore := func(item *metadata.Metadata) error {
+		dst := rebasePath(item.Path, from, target)
+		if e.IsReadOnly(dst) {
+			return fmt.Errorf("%w: %s", backends.ErrReadOnly, dst)
+		}
+		if item.Type == "directory" {
+			result.Directories++
+			return e.restoreDirectory(ctx, item, dst)
+		}
+		result.Files++
+		result.Bytes += item.Size
+		return e.restoreFile(ctx, item, dst)
+	}
+	if err := restore(md); err != nil {
+		return result, err
+	}
+	if md.Type == "directory" {
+		if err := e.walkDescendants(ctx, from, restore); err != nil {
+			return result, err
+		}
+	}
+
+	e.ctxLogger(ctx).Info("Snapshot restored",
+		zap.String("snapshot", name),
+		zap.String("path", within),
+		zap.String("target", target),
+		zap.Int("files", result.Files),
+		zap.Int("directories", result.Directories))
+
+	return result, nil
+}
+
+// LocalClone copies a file on this instance's local filesystem backend,
+// without touching the metadata store
+func (e *Engine) LocalClone(ctx context.Context, oldPath, newPath string) error {
+	return cloneInBackend(ctx, e.localFSBackend, oldPath, newPath)
+}
+
+// copySubtree copies the metadata of md and everything below it to dst, and
+// the content of its files, a page at a time. The snapshot directory is left
+// out when it lies below md.
+func (e *Engine) copySubtree(ctx context.Context, md *metadata.Metadata, dst string, snapshot *Snapshot) error {
+	root := *md
+	root.Name = filepath.Base(dst)
+	page := []*metadata.Metadata{&root}
+	err := e.walkDescendants(ctx, md.Path, func(item *metadata.Metadata) error {
+		if e.IsSnapshotPath(item.Path) {
+			return nil
+		}
+		page = append(page, item)
+		if len(page) < descendantPageSize {
+			return nil
+		}
+		err := e.copyEntries(ctx, page, md.Path, dst, snapshot)
+		page = page[:0]
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return e.copyEntries(ctx, page, md.Path, dst, snapshot)
+}
+
+// copyEntries copies the content of the files among items, then commits the
+// metadata of all of them in one batch
+func (e *Engine) copyEntries(ctx context.Context, items []*metadata.Metadata, from, to string, snapshot *Snapshot) error {
+	now := time.Now()
+	ops := make([]metadata.BatchOp, 0, len(items))
+	peerFiles := make(map[string][]internalproxy.RenameRequest)
+	for _, item := range items {
+		copied := *item
+		copied.ID = 0
+		copied.ParentID = nil
+		copied.Path = rebasePath(item.Path, from, to)
+		copied.Immutable, copied.RetainUntil = false, nil // Holds stay with the file
+		copied.CreatedAt = now
+		copied.UpdatedAt = now
+		ops = append(ops, metadata.BatchOp{Type: metadata.BatchCreate, Metadata: &copied})
+
+		if item.Type == "directory" {
+			snapshot.Directories++
+			continue
+		}
+		if item.Type != "file" {
+			continue
+		}
+		snapshot.Files++
+		snapshot.Bytes += item.Size
+
+		switch {
+		case item.ErasureCoded:
+			// Shards are stored by content, so copies would share them
+			if err := e.copyContent(ctx, item, &copied); err != nil {
+				return err
+			}
+		case item.BackendType == "s3":
+			if err := cloneInBackend(ctx, e.s3Backend, item.Path, copied.Path); err != nil {
+				return fmt.Errorf("failed to copy %s in S3: %w", item.Path, err)
+			}
+		case item.CallFSInstanceID != nil && *item.CallFSInstanceID != e.currentInstanceID:
+			owner := *item.CallFSInstanceID
+			peerFiles[owner] = append(peerFiles[owner], internalproxy.RenameRequest{From: item.Path, To: copied.Path})
+		default:
+			if err := e.LocalClone(ctx, item.Path, copied.Path); err != nil {
+				return fmt.Errorf("failed to copy %s: %w", item.Path, err)
+			}
+		}
+
+		// Replicas in S3 are copied too, so the copy survives its owner
+		if item.BackendType == "localfs" && !item.ErasureCoded && e.replicaBackendFor(item) != nil {
+			err := cloneInBackend(ctx, e.s3Backend, item.Path, copied.Path)
+			if err != nil && !errors.Is(err, metadata.ErrNotFound) {
+				return fmt.Errorf("failed to copy the replica of %s: %w", item.Path, err)
+			}
+		}
+	}
+
+	for owner, files := range peerFiles {
+		if e.internalProxyAdapter == nil {
+			return fmt.Errorf("%w: no peers are configured", internalproxy.ErrPeerUnavailable)
+		}
+		if err := e.internalProxyAdapter.CloneOnInstance(ctx, owner, files); err != nil {
+			return fmt.Errorf("failed to copy content on instance %s: %w", owner, err)
+		}
+	}
+
+	if len(ops) == 0 {
+		return nil
+	}
+	if err := e.metadataStore.Batch(ctx, ops); err != nil {
+		return fmt.Errorf("failed to store snapshot metadata: %w", err)
+	}
+	return nil
+}
+
+// copyContent streams the content of item into a plain file at copied.Path
+// on this instance, and points copied at it
+func (e *Engine) copyContent(ctx context.Context, item, copied *metadata.Metadata) error {
+	reader, err := e.GetFile(ctx, item.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", item.Path, err)
+	}
+	defer reader.Close()
+
+	storage, backendType := e.plainBackend()
+	if err := storage.Update(ctx, strings.TrimPrefix(copied.Path, "/"), reader, item.Size); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", item.Path, err)
+	}
+	copied.ErasureCoded = false
+	copied.BackendType = backendType
+	copied.CallFSInstanceID = nil
+	if backendType == "localfs" {
+		copied.CallFSInstanceID = &e.currentInstanceID
+	}
+	return nil
+}
+
+// plainBackend returns the backend this instance stores files of its own in:
+// its local filesystem, or S3 when it has none
+func (e *Engine) plainBackend() (backends.Storage, string) {
+	if _, disabled := e.localFSBackend.(*noop.NoopAdapter); disabled && e.s3Backend != nil {
+		return e.s3Backend, "s3"
+	}
+	return e.localFSBackend, "localfs"
+}
+
+// writeSnapshotManifest stores the description of snapshot in dir. It is
+// written last, so snapshots without one are incomplete.
+func (e *Engine) writeSnapshotManifest(ctx context.Context, dir string, snapshot *Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	manifestPath := filepath.Join(dir, snapshotManifest)
+	storage, backendType := e.plainBackend()
+	if err := storage.Update(ctx, strings.TrimPrefix(manifestPath, "/"), bytes.NewReader(data), int64(len(data))); err != nil {
+		return fmt.Errorf("failed to store snapshot manifest: %w", err)
+	}
+
+	now := time.Now()
+	md := &metadata.Metadata{
+		Name:        snapshotManifest,
+		Path:        manifestPath,
+		Type:        "file",
+		Size:        int64(len(data)),
+		Mode:        "0444",
+		BackendType: backendType,
+		ATime:       now,
+		MTime:       now,
+		CTime:       now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if backendType == "localfs" {
+		md.CallFSInstanceID = &e.currentInstanceID
+	}
+	if err := e.metadataStore.Create(ctx, md); err != nil {
+		return fmt.Errorf("failed to store snapshot manifest metadata: %w", err)
+	}
+	return nil
+}
+
+// readSnapshotManifest reads the description of the snapshot in dir
+func (e *Engine) readSnapshotManifest(ctx context.Context, dir string) (*Snapshot, error) {
+	reader, err := e.GetFile(ctx, filepath.Join(dir, snapshotManifest))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var snapshot Snapshot
+	if err := json.NewDecoder(io.LimitReader(reader, 1<<20)).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s: %w", filepath.Base(dir), err)
+	}
+	return &snapshot, nil
+}
+
+// removeSubtree deletes dir and everything below it, deepest first
+func (e *Engine) removeSubtree(ctx context.Context, dir string) error {
+	md, err := e.metadataStore.Get(ctx, dir)
+	if err != nil {
+		return err
+	}
+	items := []*metadata.Metadata{md}
+	err = e.walkDescendants(ctx, dir, func(item *metadata.Metadata) error {
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		switch {
+		case item.Type == "directory":
+			// Backend directories only exist where files were written
+			if err := e.metadataStore.Delete(ctx, item.Path); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+				return fmt.Errorf("failed to delete %s: %w", item.Path, err)
+			}
+			_ = e.localFSBackend.Delete(ctx, strings.TrimPrefix(item.Path, "/"))
+			e.invalidatePathAndParent(ctx, item.Path)
+		case item.BackendType == "localfs" && item.CallFSInstanceID != nil && *item.CallFSInstanceID != e.currentInstanceID:
+			if err := e.DeleteFileOnInstance(ctx, *item.CallFSInstanceID, item.Path); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+				return fmt.Errorf("failed to delete %s: %w", item.Path, err)
+			}
+		default:
+			if err := e.DeleteFile(ctx, item.Path); err != nil && !errors.Is(err, metadata.ErrNotFound) {
+				return fmt.Errorf("failed to delete %s: %w", item.Path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// restoreDirectory creates dst, if missing, with the mode and owner of item
+func (e *Engine) restoreDirectory(ctx context.Context, item *metadata.Metadata, dst string) error {
+	dirMd := &metadata.Metadata{
+		Type:        "directory",
+		Mode:        item.Mode,
+		UID:         item.UID,
+		GID:         item.GID,
+		BackendType: item.BackendType,
+		ATime:       item.ATime,
+		MTime:       item.MTime,
+		CTime:       time.Now(),
+	}
+	if _, err := e.MakeDirectory(ctx, dst, true, dirMd); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", dst, err)
+	}
+	attrs := FileAttributes{UID: &item.UID, GID: &item.GID}
+	if mode, err := strconv.ParseUint(item.Mode, 8, 32); err == nil {
+		fileMode := os.FileMode(mode) & os.ModePerm
+		attrs.Mode = &fileMode
+	}
+	if _, err := e.SetAttributes(ctx, dst, attrs); err != nil {
+		return fmt.Errorf("failed to restore the attributes of %s: %w", dst, err)
+	}
+	return nil
+}
+
+// restoreFile writes the content of item to dst, then gives it the mode,
+// owner and times of item
+func (e *Engine) restoreFile(ctx context.Context, item *metadata.Metadata, dst string) error {
+	reader, err := e.GetFile(ctx, item.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", item.Path, err)
+	}
+	defer reader.Close()
+
+	existing, err := e.metadataStore.Get(ctx, dst)
+	switch {
+	case err == nil && existing.Type != "file":
+		return fmt.Errorf("%w: %s is not a file", ErrInvalidSnapshot, dst)
+	case err == nil:
+		err = e.UpdateFile(ctx, dst, reader, item.Size, existing)
+	case errors.Is(err, metadata.ErrNotFound):
+		err = e.CreateFile(ctx, dst, reader, item.Size, &metadata.Metadata{
+			Name:        filepath.Base(dst),
+			Type:        "file",
+			Mode:        item.Mode,
+			UID:         item.UID,
+			GID:         item.GID,
+			BackendType: item.BackendType,
+			ATime:       item.ATime,
+			MTime:       item.MTime,
+			CTime:       time.Now(),
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore %s: %w", dst, err)
+	}
+
+	attrs := FileAttributes{UID: &item.UID, GID: &item.GID, ATime: &item.ATime, MTime: &item.MTime}
+	if mode, err := strconv.ParseUint(item.Mode, 8, 32); err == nil {
+		fileMode := os.FileMode(mode) & os.ModePerm
+		attrs.Mode = &fileMode
+	}
+	if _, err := e.SetAttributes(ctx, dst, attrs); err != nil {
+		return fmt.Errorf("failed to restore the attributes of %s: %w", dst, err)
+	}
+	return nil
+}
+
+// cloneInBackend copies a file within storage
+func cloneInBackend(ctx context.Context, storage backends.Storage, oldPath, newPath string) error {
+	cloner, ok := storage.(backends.Cloner)
+	if !ok {
+		return fmt.Errorf("backend does not support copying")
+	}
+	return cloner.Clone(ctx, strings.TrimPrefix(oldPath, "/"), strings.TrimPrefix(newPath, "/"))
+}
+
+// rebasePath moves p, at or below from, to the same place below to
+func rebasePath(p, from, to string) string {
+	if from == "/" {
+		return filepath.Join(to, p)
+	}
+	return to + strings.TrimPrefix(p, from)
+}
@@ -0,0 +1,231 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/backends"
+	"github.com/ebogdum/callfs/backends/noop"
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// usageCacheTTL bounds how stale the metadata counts of StatFS may be, as
+// some metadata stores count by walking every entry
+const usageCacheTTL = 10 * time.Second
+
+// maxCachedDirectoryUsages bounds the directories whose usage is kept
+const maxCachedDirectoryUsages = 1024
+
+// BackendCapacity is the space of one backend, or for localfs of one
+// instance's local filesystem
+type BackendCapacity struct {
+	Backend     string `json:"backend"`               // localfs, s3 or erasure
+	InstanceID  string `json:"instance_id,omitempty"` // Instance of a localfs backend
+	TotalBytes  int64  `json:"total_bytes"`           // 0 when unknown or unlimited
+	UsedBytes   int64  `json:"used_bytes"`
+	FreeBytes   int64  `json:"free_bytes"`
+	StoredBytes int64  `json:"stored_bytes"`    // Size of the files the metadata places here
+	Files       int64  `json:"files"`           // Files the metadata places here
+	Error       string `json:"error,omitempty"` // Why the space could not be read
+}
+
+// InodeCounts counts the entries of the metadata store
+type InodeCounts struct {
+	Files       int64 `json:"files"`
+	Directories int64 `json:"directories"`
+	Total       int64 `json:"total"`
+}
+
+// FSStats is the capacity of every backend and the entry counts of the
+// filesystem, in the manner of statfs(2)
+type FSStats struct {
+	Backends []BackendCapacity `json:"backends"` // Ordered by backend and instance ID
+	Inodes   InodeCounts       `json:"inodes"`
+}
+
+// usageCache holds the last metadata usage counted for StatFS
+type usageCache struct {
+	mu        sync.Mutex
+	usage     *metadata.Usage
+	countedAt time.Time
+}
+
+// directoryUsageCache holds the usage last counted for each directory
+type directoryUsageCache struct {
+	mu     sync.Mutex
+	counts map[string]countedDirectoryUsage
+}
+
+type countedDirectoryUsage struct {
+	usage     *metadata.DirectoryUsage
+	countedAt time.Time
+}
+
+// SetS3Quota sets the capacity StatFS reports for the S3 backend. Buckets
+// have no size limit of their own, so 0 reports it as unlimited.
+func (e *Engine) SetS3Quota(bytes int64) {
+	e.s3QuotaBytes = bytes
+}
+
+// StatFS reports the total, used and free space of the local filesystem of
+// this instance and of each peer, and of S3, together with the files and
+// bytes the metadata places on each and the number of entries. Localfs space
+// is that of the underlying filesystem; S3 space is derived from the
+// configured quota and the bytes stored there. A location whose space cannot
+// be read is reported with an error rather than failing the call.
+func (e *Engine) StatFS(ctx context.Context) (*FSStats, error) {
+	usage, err := e.metadataUsage(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count metadata usage: %w", err)
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		capacity = make(map[[2]string]*BackendCapacity)
+	)
+	measure := func(backend, instanceID string, read func() (free, total int64, err error)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := &BackendCapacity{Backend: backend, InstanceID: instanceID}
+			free, total, err := read()
+			if err != nil {
+				e.ctxLogger(ctx).Warn("Failed to read backend capacity",
+					zap.String("backend", backend), zap.String("instance_id", instanceID), zap.Error(err))
+				c.Error = err.Error()
+			} else {
+				c.FreeBytes, c.TotalBytes, c.UsedBytes = free, total, total-free
+			}
+			mu.Lock()
+			capacity[[2]string{backend, instanceID}] = c
+			mu.Unlock()
+		}()
+	}
+
+	measure("localfs", e.currentInstanceID, func() (int64, int64, error) {
+		cr, ok := e.localFSBackend.(backends.CapacityReporter)
+		if !ok {
+			return 0, 0, fmt.Errorf("the local backend cannot report its capacity")
+		}
+		return cr.Capacity(ctx)
+	})
+	for _, peer := range e.PeerStatuses() {
+		measure("localfs", peer.InstanceID, func() (int64, int64, error) {
+			c, err := e.internalProxyAdapter.CapacityOnInstance(ctx, peer.InstanceID)
+			if err != nil {
+				return 0, 0, err
+			}
+			return c.FreeBytes, c.TotalBytes, nil
+		})
+	}
+	wg.Wait()
+
+	_, s3Disabled := e.s3Backend.(*noop.NoopAdapter)
+	if !s3Disabled && e.s3Backend != nil {
+		capacity[[2]string{"s3", ""}] = &BackendCapacity{Backend: "s3", TotalBytes: e.s3QuotaBytes}
+	}
+
+	for _, u := range usage.Backends {
+		c, ok := capacity[[2]string{u.BackendType, u.InstanceID}]
+		if !ok {
+			c = &BackendCapacity{Backend: u.BackendType, InstanceID: u.InstanceID}
+			switch u.BackendType {
+			case "localfs":
+				c.Error = "not a known instance"
+			case "s3":
+				c.Error = "the S3 backend is not enabled"
+			}
+			capacity[[2]string{u.BackendType, u.InstanceID}] = c
+		}
+		c.StoredBytes, c.Files = u.Bytes, u.Files
+	}
+
+	// S3 is only as full as what CallFS stored there
+	if c, ok := capacity[[2]string{"s3", ""}]; ok && c.Error == "" {
+		c.UsedBytes = c.StoredBytes
+		if c.TotalBytes > 0 {
+			c.FreeBytes = max(c.TotalBytes-c.UsedBytes, 0)
+		}
+	}
+
+	stats := &FSStats{
+		Backends: make([]BackendCapacity, 0, len(capacity)),
+		Inodes: InodeCounts{
+			Files:       usage.Files,
+			Directories: usage.Directories,
+			Total:       usage.Files + usage.Directories,
+		},
+	}
+	for _, c := range capacity {
+		stats.Backends = append(stats.Backends, *c)
+	}
+	sort.Slice(stats.Backends, func(i, j int) bool {
+		a, b := stats.Backends[i], stats.Backends[j]
+		if a.Backend != b.Backend {
+			return a.Backend < b.Backend
+		}
+		return a.InstanceID < b.InstanceID
+	})
+	return stats, nil
+}
+
+// metadataUsage returns the usage counted by the metadata store, reusing a
+// count younger than usageCacheTTL
+func (e *Engine) metadataUsage(ctx context.Context) (*metadata.Usage, error) {
+	e.usageCache.mu.Lock()
+	defer e.usageCache.mu.Unlock()
+	if e.usageCache.usage != nil && time.Since(e.usageCache.countedAt) < usageCacheTTL {
+		return e.usageCache.usage, nil
+	}
+
+	usage, err := e.metadataStore.Usage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	e.usageCache.usage = usage
+	e.usageCache.countedAt = time.Now()
+	return usage, nil
+}
+
+// DirectoryUsage counts the entries directly in the directory at path and
+// the bytes of the files below it, as the metadata store records them.
+// Stores that walk the subtree to count it make this costly for large
+// directories, so a count younger than usageCacheTTL is reused.
+func (e *Engine) DirectoryUsage(ctx context.Context, path string) (*metadata.DirectoryUsage, error) {
+	cache := &e.directoryUsageCache
+	cache.mu.Lock()
+	counted, ok := cache.counts[path]
+	cache.mu.Unlock()
+	if ok && time.Since(counted.countedAt) < usageCacheTTL {
+		return counted.usage, nil
+	}
+
+	usage, err := e.metadataStore.DirectoryUsage(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	cache.mu.Lock()
+	if cache.counts == nil {
+		cache.counts = make(map[string]countedDirectoryUsage)
+	}
+	if len(cache.counts) >= maxCachedDirectoryUsages {
+		for key, c := range cache.counts {
+			if now.Sub(c.countedAt) >= usageCacheTTL {
+				delete(cache.counts, key)
+			}
+		}
+		if len(cache.counts) >= maxCachedDirectoryUsages {
+			clear(cache.counts)
+		}
+	}
+	cache.counts[path] = countedDirectoryUsage{usage: usage, countedAt: now}
+	cache.mu.Unlock()
+	return usage, nil
+}
@@ -0,0 +1,258 @@
+package core
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ebogdum/callfs/backends"
+	"github.com/ebogdum/callfs/metadata"
+	"github.com/ebogdum/callfs/metrics"
+)
+
+// DefaultReadAheadBufferSize is the size of the buffers read ahead when
+// read-ahead is enabled without a buffer size
+const DefaultReadAheadBufferSize = 256 * 1024
+
+// StreamTuning configures how downloads read file content from backends
+type StreamTuning struct {
+	BufferSize int // Bytes requested from the backend per read; 0 reads as much as the client asks for
+	ReadAhead  int // Buffers a goroutine reads ahead of the client; 0 disables read-ahead
+}
+
+// streamState holds the tuning of SetStreamTuning
+type streamState struct {
+	tuning  StreamTuning
+	buffers sync.Pool // Of []byte of tuning.BufferSize
+}
+
+// SetStreamTuning sets the buffering of backend reads. Larger buffers turn
+// the many small reads of a client into few large ones, which suits backends
+// with a high per-read cost such as S3; read-ahead also overlaps backend
+// latency with sending to the client.
+func (e *Engine) SetStreamTuning(tuning StreamTuning) {
+	if tuning.ReadAhead > 0 && tuning.BufferSize <= 0 {
+		tuning.BufferSize = DefaultReadAheadBufferSize
+	}
+	size := tuning.BufferSize
+	e.stream = &streamState{tuning: tuning}
+	e.stream.buffers.New = func() any { return make([]byte, size) }
+}
+
+// transferBackend names the backend storage md's content is read from or
+// written to in metrics
+func (e *Engine) transferBackend(md *metadata.Metadata, storage backends.Storage) string {
+	if storage == e.internalProxyBackend {
+		return "peer"
+	}
+	return md.BackendType
+}
+
+// streamReadCloser measures the throughput of reader, the content of a
+// download from source, and buffers it as SetStreamTuning configured
+func (e *Engine) streamReadCloser(reader io.ReadCloser, source string) io.ReadCloser {
+	reader = &measuredReader{ReadCloser: reader, source: source}
+	if e.stream == nil || e.stream.tuning.BufferSize <= 0 {
+		return reader
+	}
+	if e.stream.tuning.ReadAhead > 0 {
+		return newReadAheadReader(reader, &e.stream.buffers, e.stream.tuning.ReadAhead)
+	}
+	return &chunkedReader{ReadCloser: reader, buf: make([]byte, e.stream.tuning.BufferSize)}
+}
+
+// measuredReader counts the bytes read from a backend and the time spent
+// waiting for them, and records the backend's throughput when closed
+type measuredReader struct {
+	io.ReadCloser
+	source  string
+	bytes   atomic.Int64
+	waiting atomic.Int64 // Nanoseconds spent in Read
+	closed  atomic.Bool
+}
+
+func (m *measuredReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := m.ReadCloser.Read(p)
+	m.waiting.Add(int64(time.Since(start)))
+	if n > 0 {
+		m.bytes.Add(int64(n))
+		metrics.BackendReadBytesTotal.WithLabelValues(m.source).Add(float64(n))
+	}
+	return n, err
+}
+
+func (m *measuredReader) Close() error {
+	err := m.ReadCloser.Close()
+	if m.closed.Swap(true) {
+		return err
+	}
+	bytes, waiting := m.bytes.Load(), time.Duration(m.waiting.Load())
+	if bytes > 0 && waiting > 0 {
+		metrics.BackendReadThroughput.WithLabelValues(m.source).Observe(float64(bytes) / waiting.Seconds())
+	}
+	return err
+}
+
+// measureUpload counts the bytes of reader, the content of an upload to
+// backend, as they are read, and the time spent waiting for them
+func measureUpload(reader io.Reader, backend string) *uploadReader {
+	return &uploadReader{Reader: reader, backend: backend}
+}
+
+type uploadReader struct {
+	io.Reader
+	backend string
+	waiting atomic.Int64 // Nanoseconds spent in Read
+}
+
+func (u *uploadReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := u.Reader.Read(p)
+	u.waiting.Add(int64(time.Since(start)))
+	if n > 0 {
+		metrics.BackendWriteBytesTotal.WithLabelValues(u.backend).Add(float64(n))
+	}
+	return n, err
+}
+
+// waited returns the time the backend spent waiting for the content, on the
+// client, throttling and scanning, rather than storing it
+func (u *uploadReader) waited() time.Duration {
+	return time.Duration(u.waiting.Load())
+}
+
+// chunkedReader reads its source a full buffer at a time
+type chunkedReader struct {
+	io.ReadCloser
+	buf     []byte
+	pending []byte
+	err     error
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if len(c.pending) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		var n int
+		n, c.err = readChunk(c.ReadCloser, c.buf)
+		c.pending = c.buf[:n]
+		if n == 0 {
+			return 0, c.err
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// readChunk fills buf from reader. It returns io.EOF with the last bytes of
+// the content rather than io.ErrUnexpectedEOF.
+func readChunk(reader io.Reader, buf []byte) (int, error) {
+	n, err := io.ReadFull(reader, buf)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// chunk is a buffer read ahead, with the error that ended it
+type chunk struct {
+	buf []byte
+	n   int
+	err error
+}
+
+// readAheadReader reads its source into pooled buffers in a goroutine, at
+// most ahead buffers in advance of its reader
+type readAheadReader struct {
+	source  io.ReadCloser
+	buffers *sync.Pool
+	chunks  chan chunk
+	done    chan struct{}
+	exited  chan struct{}
+	current chunk
+	offset  int
+	err     error
+	close   sync.Once
+}
+
+func newReadAheadReader(source io.ReadCloser, buffers *sync.Pool, ahead int) *readAheadReader {
+	r := &readAheadReader{
+		source:  source,
+		buffers: buffers,
+		chunks:  make(chan chunk, ahead),
+		done:    make(chan struct{}),
+		exited:  make(chan struct{}),
+	}
+	go r.fill()
+	return r
+}
+
+func (r *readAheadReader) fill() {
+	defer close(r.exited)
+	for {
+		buf := r.buffers.Get().([]byte)
+		n, err := readChunk(r.source, buf)
+		select {
+		case r.chunks <- chunk{buf: buf, n: n, err: err}:
+		case <-r.done:
+			r.buffers.Put(buf)
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (r *readAheadReader) Read(p []byte) (int, error) {
+	for r.offset == r.current.n {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.release()
+		select {
+		case next := <-r.chunks:
+			r.current, r.offset, r.err = next, 0, next.err
+		case <-r.done:
+			return 0, io.ErrClosedPipe
+		}
+	}
+	n := copy(p, r.current.buf[r.offset:r.current.n])
+	r.offset += n
+	return n, nil
+}
+
+// release returns the buffer being read to the pool
+func (r *readAheadReader) release() {
+	if r.current.buf != nil {
+		r.buffers.Put(r.current.buf)
+		r.current = chunk{}
+		r.offset = 0
+	}
+}
+
+// Close stops the goroutine and closes the source, which also ends a read
+// the goroutine is blocked in
+func (r *readAheadReader) Close() error {
+	var err error
+	r.close.Do(func() {
+		close(r.done)
+		err = r.source.Close()
+		<-r.exited
+		r.release()
+		for {
+			select {
+			case c := <-r.chunks:
+				r.buffers.Put(c.buf)
+			default:
+				return
+			}
+		}
+	})
+	return err
+}
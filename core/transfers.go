@@ -0,0 +1,224 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Transfer protocols
+const (
+	TransferHTTP      = "http"
+	TransferWebSocket = "websocket"
+)
+
+// Transfer states
+const (
+	TransferReceiving = "receiving" // Content is being read from the client
+	TransferStoring   = "storing"   // All content was read and is being committed
+	TransferCompleted = "completed"
+	TransferFailed    = "failed"
+)
+
+// finishedTransferRetention is how long finished transfers can be looked up
+const finishedTransferRetention = time.Hour
+
+// maxTransfers caps the transfers tracked at once by an instance, and
+// maxTransfersPerUser those of one user, so no user can take every slot.
+// Finished transfers give up their slots to new ones, the oldest first.
+const (
+	maxTransfers        = 10000
+	maxTransfersPerUser = 1000
+)
+
+// transferIDPattern is what clients may name their transfers
+var transferIDPattern = regexp.MustCompile(`^[A-Za-z0-9._~-]{1,128}$`)
+
+var (
+	// ErrInvalidTransfer is returned for transfer IDs that cannot be used
+	ErrInvalidTransfer = errors.New("invalid transfer ID")
+
+	// ErrTransferNotFound is returned for an unknown transfer ID
+	ErrTransferNotFound = errors.New("transfer not found")
+
+	// ErrTransferActive is returned when a transfer with the same ID is still running
+	ErrTransferActive = errors.New("a transfer with this ID is still running")
+
+	// ErrTooManyTransfers is returned when no more transfers can be tracked
+	ErrTooManyTransfers = errors.New("too many transfers are being tracked")
+)
+
+// TransferStatus reports the progress of an upload
+type TransferStatus struct {
+	ID            string     `json:"id"`
+	Path          string     `json:"path"`
+	Protocol      string     `json:"protocol"` // "http" or "websocket"
+	State         string     `json:"state"`
+	BytesReceived int64      `json:"bytes_received"`
+	ExpectedBytes *int64     `json:"expected_bytes,omitempty"` // Declared by the client, if at all
+	Error         string     `json:"error,omitempty"`          // Why the transfer failed
+	StartedAt     time.Time  `json:"started_at"`
+	LastActivity  time.Time  `json:"last_activity"` // When content was last received, or the state changed
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+}
+
+// Transfer is an upload tracked under an ID of the client's choosing
+type Transfer struct {
+	userID string
+
+	mu     sync.Mutex
+	status TransferStatus
+}
+
+func (t *Transfer) update(fn func(*TransferStatus)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fn(&t.status)
+	t.status.LastActivity = time.Now().UTC()
+}
+
+func (t *Transfer) snapshot() TransferStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status
+}
+
+// Received counts n more bytes read from the client
+func (t *Transfer) Received(n int) {
+	if n > 0 {
+		t.update(func(s *TransferStatus) { s.BytesReceived += int64(n) })
+	}
+}
+
+// Storing records that the whole content was read
+func (t *Transfer) Storing() {
+	t.update(func(s *TransferStatus) {
+		if s.State == TransferReceiving {
+			s.State = TransferStoring
+		}
+	})
+}
+
+// Finish ends the transfer, as failed when err is not nil
+func (t *Transfer) Finish(err error) {
+	t.update(func(s *TransferStatus) {
+		if s.FinishedAt != nil {
+			return
+		}
+		now := time.Now().UTC()
+		s.FinishedAt = &now
+		s.State = TransferCompleted
+		if err != nil {
+			s.State = TransferFailed
+			s.Error = err.Error()
+		}
+	})
+}
+
+// transferKey scopes transfer IDs to the user that chose them
+func transferKey(userID, id string) string {
+	return userID + "\x00" + id
+}
+
+// StartTransfer tracks an upload to path by userID under id. expected is the
+// length the client declared, or negative when unknown. A finished transfer
+// with the same ID is replaced, and when the instance or the user tracks as
+// many transfers as allowed, so is their oldest finished one.
+func (e *Engine) StartTransfer(userID, id, path, protocol string, expected int64) (*Transfer, error) {
+	if !transferIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("%w: use 1 to 128 letters, digits, '.', '_', '~' or '-'", ErrInvalidTransfer)
+	}
+
+	e.transfersMu.Lock()
+	defer e.transfersMu.Unlock()
+	if e.transfers == nil {
+		e.transfers = make(map[string]*Transfer)
+	}
+	key := transferKey(userID, id)
+	if t, ok := e.transfers[key]; ok && t.snapshot().FinishedAt == nil {
+		return nil, ErrTransferActive
+	}
+	delete(e.transfers, key)
+	if err := e.makeTransferRoom(userID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	t := &Transfer{userID: userID, status: TransferStatus{
+		ID:           id,
+		Path:         path,
+		Protocol:     protocol,
+		State:        TransferReceiving,
+		StartedAt:    now,
+		LastActivity: now,
+	}}
+	if expected >= 0 {
+		t.status.ExpectedBytes = &expected
+	}
+	e.transfers[key] = t
+	return t, nil
+}
+
+// makeTransferRoom frees a slot for a transfer of userID, forgetting expired
+// transfers and, if the instance or the user has no slot left, the oldest
+// finished one. It returns ErrTooManyTransfers if every slot is taken by a
+// running transfer. e.transfersMu must be held.
+func (e *Engine) makeTransferRoom(userID string) error {
+	var (
+		userTransfers          int
+		oldest, oldestOfUser   string
+		oldestAt, oldestUserAt time.Time
+	)
+	for k, t := range e.transfers {
+		s := t.snapshot()
+		if s.FinishedAt != nil && time.Since(*s.FinishedAt) > finishedTransferRetention {
+			delete(e.transfers, k)
+			continue
+		}
+		if t.userID == userID {
+			userTransfers++
+		}
+		if s.FinishedAt == nil {
+			continue
+		}
+		if oldest == "" || s.FinishedAt.Before(oldestAt) {
+			oldest, oldestAt = k, *s.FinishedAt
+		}
+		if t.userID == userID && (oldestOfUser == "" || s.FinishedAt.Before(oldestUserAt)) {
+			oldestOfUser, oldestUserAt = k, *s.FinishedAt
+		}
+	}
+
+	if userTransfers >= maxTransfersPerUser {
+		if oldestOfUser == "" {
+			return fmt.Errorf("%w: %d transfers of this user are running", ErrTooManyTransfers, userTransfers)
+		}
+		delete(e.transfers, oldestOfUser)
+		return nil
+	}
+	if len(e.transfers) >= maxTransfers {
+		if oldest == "" {
+			return ErrTooManyTransfers
+		}
+		delete(e.transfers, oldest)
+	}
+	return nil
+}
+
+// TransferStatus returns the status of a transfer userID started on this
+// instance
+func (e *Engine) TransferStatus(userID, id string) (TransferStatus, error) {
+	e.transfersMu.Lock()
+	t, ok := e.transfers[transferKey(userID, id)]
+	e.transfersMu.Unlock()
+	if !ok {
+		return TransferStatus{}, ErrTransferNotFound
+	}
+	s := t.snapshot()
+	if s.FinishedAt != nil && time.Since(*s.FinishedAt) > finishedTransferRetention {
+		return TransferStatus{}, ErrTransferNotFound
+	}
+	return s, nil
+}
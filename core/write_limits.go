@@ -0,0 +1,78 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ebogdum/callfs/metrics"
+)
+
+// ErrBackendBusy is returned when a backend has as many uploads in progress
+// as it allows and none finished within the queue timeout
+var ErrBackendBusy = errors.New("too many uploads to the backend in progress")
+
+// WriteLimits caps the uploads each backend stores at once. A zero limit
+// disables it.
+type WriteLimits struct {
+	LocalFS      int           // Uploads to this instance's local filesystem
+	S3           int           // Uploads to S3
+	Peer         int           // Uploads forwarded to other instances
+	QueueTimeout time.Duration // How long an upload waits for a slot; 0 rejects it at once
+}
+
+// writeLimitState holds the slots of SetWriteLimits
+type writeLimitState struct {
+	slots        map[string]chan struct{} // By backend name, as in metrics
+	queueTimeout time.Duration
+}
+
+// SetWriteLimits bounds the uploads in progress per backend, so a burst of
+// them cannot exhaust file descriptors or S3 connections
+func (e *Engine) SetWriteLimits(limits WriteLimits) {
+	e.writeLimits = writeLimitState{
+		slots:        make(map[string]chan struct{}),
+		queueTimeout: limits.QueueTimeout,
+	}
+	for backend, limit := range map[string]int{"localfs": limits.LocalFS, "s3": limits.S3, "peer": limits.Peer} {
+		if limit > 0 {
+			e.writeLimits.slots[backend] = make(chan struct{}, limit)
+		}
+	}
+}
+
+// acquireWrite waits for a slot to upload to backend and returns the
+// function releasing it
+func (e *Engine) acquireWrite(ctx context.Context, backend string) (func(), error) {
+	slots := e.writeLimits.slots[backend]
+	if slots == nil {
+		return func() {}, nil
+	}
+	release := func() { <-slots }
+
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if e.writeLimits.queueTimeout <= 0 {
+		metrics.BackendWritesRejectedTotal.WithLabelValues(backend).Inc()
+		return nil, fmt.Errorf("%w: %s", ErrBackendBusy, backend)
+	}
+
+	queued := metrics.BackendWriteQueueDepth.WithLabelValues(backend)
+	queued.Inc()
+	defer queued.Dec()
+	timer := time.NewTimer(e.writeLimits.queueTimeout)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		metrics.BackendWritesRejectedTotal.WithLabelValues(backend).Inc()
+		return nil, fmt.Errorf("%w: %s", ErrBackendBusy, backend)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,64 @@
+// Package delta implements the signatures and deltas of librsync, as the
+// rdiff tool writes them, so a changed file can be sent as the blocks that
+// differ from a copy the receiver already holds.
+//
+// The receiver sends a signature of its copy, the basis: a weak rolling
+// checksum and a strong BLAKE2b hash of each block. The sender finds the
+// blocks of the signature in its new file and sends a delta, copies of
+// basis blocks and literal data for the rest, which the receiver patches
+// the basis with. Signatures use the rsync rolling checksum with BLAKE2b
+// (rdiff signature -R rollsum -H blake2), readable by librsync 2.0 and
+// later.
+package delta
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+)
+
+// Magic numbers starting signatures and deltas
+const (
+	signatureMagic uint32 = 0x72730137 // BLAKE2b strong sums, rsync rolling checksums
+	deltaMagic     uint32 = 0x72730236
+)
+
+const (
+	// MaxStrongLen is the length of the BLAKE2b hash of a block, the
+	// longest strong sum a signature may keep
+	MaxStrongLen = 32
+
+	// MinBlockLen and MaxBlockLen bound the block length of signatures
+	MinBlockLen = 64
+	MaxBlockLen = 16 << 20
+)
+
+var (
+	// ErrInvalidSignature is returned for a signature that is malformed or
+	// of another format
+	ErrInvalidSignature = errors.New("invalid signature")
+
+	// ErrInvalidDelta is returned for a delta that is malformed or copies
+	// beyond the end of its basis
+	ErrInvalidDelta = errors.New("invalid delta")
+)
+
+// DefaultBlockLen returns the block length of the signature of a file of
+// size bytes: the square root of its size, in multiples of 128 bytes and
+// at least 256 bytes, so the signature grows with the square root too
+func DefaultBlockLen(size int64) int {
+	if size <= 256*256 {
+		return 256
+	}
+	blockLen := (int64(math.Sqrt(float64(size))) + 127) &^ 127
+	return int(min(blockLen, MaxBlockLen))
+}
+
+// DefaultStrongLen returns the strong sum length of the signature of a file
+// of size bytes in blocks of blockLen: enough bits that a block of a new
+// file of similar size is unlikely to be mistaken for one of the basis
+func DefaultStrongLen(size int64, blockLen int) int {
+	fileBits := bits.Len64(uint64(size) + 1<<24)
+	blockBits := bits.Len64(uint64(size/int64(blockLen)) + 1)
+	return min(2+(fileBits+blockBits+7)/8, MaxStrongLen)
+}
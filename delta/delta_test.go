@@ -0,0 +1,113 @@
+package delta
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestRollsumRotate(t *testing.T) {
+	data := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(data)
+	const window = 700
+
+	var sum rollsum
+	sum.update(data[:window])
+	for i := 1; i+window <= len(data); i++ {
+		sum.rotate(data[i-1], data[i+window-1])
+		if got, want := sum.digest(), weakSum(data[i:i+window]); got != want {
+			t.Fatalf("offset %d: rolled sum %#x, want %#x", i, got, want)
+		}
+	}
+	for i := len(data) - window + 1; i < len(data); i++ {
+		sum.rollout(data[i-1])
+		if got, want := sum.digest(), weakSum(data[i:]); got != want {
+			t.Fatalf("offset %d: shrunk sum %#x, want %#x", i, got, want)
+		}
+	}
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	basis := make([]byte, 300_000)
+	rng.Read(basis)
+
+	changed := append([]byte(nil), basis...)
+	copy(changed[1000:], "changed in place")
+	changed = append(changed[:50_000], append([]byte("inserted"), changed[50_000:]...)...)
+	changed = append(changed[:200_000], changed[201_234:]...)
+	changed = append(changed, "appended"...)
+
+	tests := map[string][]byte{
+		"unchanged": basis,
+		"changed":   changed,
+		"empty":     {},
+		"unrelated": bytes.Repeat([]byte("x"), 3*maxLiteral),
+	}
+	for name, target := range tests {
+		t.Run(name, func(t *testing.T) {
+			var sig bytes.Buffer
+			blockLen := DefaultBlockLen(int64(len(basis)))
+			if _, err := WriteSignature(&sig, bytes.NewReader(basis), blockLen, DefaultStrongLen(int64(len(basis)), blockLen)); err != nil {
+				t.Fatalf("WriteSignature: %v", err)
+			}
+			signature, err := ReadSignature(&sig, 1<<20)
+			if err != nil {
+				t.Fatalf("ReadSignature: %v", err)
+			}
+
+			var delta bytes.Buffer
+			if err := WriteDelta(&delta, signature, bytes.NewReader(target)); err != nil {
+				t.Fatalf("WriteDelta: %v", err)
+			}
+			var patched bytes.Buffer
+			stats, err := Patch(&patched, &delta, bytes.NewReader(basis))
+			if err != nil {
+				t.Fatalf("Patch: %v", err)
+			}
+			if !bytes.Equal(patched.Bytes(), target) {
+				t.Fatalf("patched %d bytes differ from the %d of the target", patched.Len(), len(target))
+			}
+			if stats.CopiedBytes+stats.LiteralBytes != int64(len(target)) {
+				t.Errorf("copied %d and sent %d bytes for %d", stats.CopiedBytes, stats.LiteralBytes, len(target))
+			}
+			if name == "changed" && stats.LiteralBytes > 8*int64(blockLen) {
+				t.Errorf("sent %d literal bytes for a few changes in blocks of %d", stats.LiteralBytes, blockLen)
+			}
+		})
+	}
+}
+
+func TestPatchCommands(t *testing.T) {
+	basis := []byte("0123456789")
+	delta := []byte{0x72, 0x73, 0x02, 0x36,
+		0x03, 'a', 'b', 'c', // Literal of 3 bytes
+		0x45, 0x02, 0x04, // Copy 4 bytes from offset 2
+		0x41, 0x02, 'x', 'y', // Literal with a 1-byte length
+		0x49, 0x00, 0x08, 0x02, // Copy with a 2-byte offset
+		0x00,
+	}
+	var out bytes.Buffer
+	if _, err := Patch(&out, bytes.NewReader(delta), bytes.NewReader(basis)); err != nil {
+		t.Fatalf("Patch: %v", err)
+	}
+	if got := out.String(); got != "abc2345xy89" {
+		t.Errorf("patched %q", got)
+	}
+}
+
+func TestPatchInvalid(t *testing.T) {
+	basis := bytes.NewReader([]byte("0123456789"))
+	for name, delta := range map[string][]byte{
+		"bad magic":     {0x72, 0x73, 0x01, 0x36, 0x00},
+		"no end":        {0x72, 0x73, 0x02, 0x36, 0x01, 'a'},
+		"short literal": {0x72, 0x73, 0x02, 0x36, 0x05, 'a'},
+		"past basis":    {0x72, 0x73, 0x02, 0x36, 0x45, 0x08, 0x04, 0x00},
+		"unknown":       {0x72, 0x73, 0x02, 0x36, 0x60, 0x00},
+	} {
+		if _, err := Patch(&bytes.Buffer{}, bytes.NewReader(delta), basis); !errors.Is(err, ErrInvalidDelta) {
+			t.Errorf("%s: got %v, want ErrInvalidDelta", name, err)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package delta
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// maxLiteral is about the longest literal WriteDelta buffers before sending
+// it
+const maxLiteral = 1 << 20
+
+// deltaWriter writes the commands of a delta, merging adjacent copies
+type deltaWriter struct {
+	out        *bufio.Writer
+	copyOffset int64
+	copyLength int64 // Of the copy not yet written
+}
+
+func (d *deltaWriter) literal(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if err := d.flushCopy(); err != nil {
+		return err
+	}
+	length := uint64(len(data))
+	if length <= opLiteralMax {
+		d.out.WriteByte(byte(length))
+	} else {
+		width := paramWidth(length)
+		d.out.WriteByte(opLiteralN1 + byte(widthIndex(width)))
+		writeParam(d.out, length, width)
+	}
+	_, err := d.out.Write(data)
+	return err
+}
+
+func (d *deltaWriter) copy(offset, length int64) error {
+	if d.copyLength > 0 && d.copyOffset+d.copyLength == offset {
+		d.copyLength += length
+		return nil
+	}
+	if err := d.flushCopy(); err != nil {
+		return err
+	}
+	d.copyOffset, d.copyLength = offset, length
+	return nil
+}
+
+func (d *deltaWriter) flushCopy() error {
+	if d.copyLength == 0 {
+		return nil
+	}
+	offsetWidth := paramWidth(uint64(d.copyOffset))
+	lengthWidth := paramWidth(uint64(d.copyLength))
+	d.out.WriteByte(opCopyN1N1 + byte(widthIndex(offsetWidth)*4+widthIndex(lengthWidth)))
+	writeParam(d.out, uint64(d.copyOffset), offsetWidth)
+	_, err := writeParam(d.out, uint64(d.copyLength), lengthWidth)
+	d.copyLength = 0
+	return err
+}
+
+// paramWidth returns the fewest bytes of 1, 2, 4 and 8 holding value
+func paramWidth(value uint64) int {
+	switch {
+	case value <= 0xff:
+		return 1
+	case value <= 0xffff:
+		return 2
+	case value <= 0xffffffff:
+		return 4
+	}
+	return 8
+}
+
+// widthIndex returns the position of width among 1, 2, 4 and 8
+func widthIndex(width int) int {
+	return bits.TrailingZeros(uint(width))
+}
+
+func writeParam(w io.Writer, value uint64, width int) (int, error) {
+	buf := binary.BigEndian.AppendUint64(nil, value)
+	return w.Write(buf[8-width:])
+}
+
+// WriteDelta writes the delta that makes the content of r of the basis
+// whose signature is sig
+func WriteDelta(w io.Writer, sig *Signature, r io.Reader) error {
+	d := &deltaWriter{out: bufio.NewWriter(w)}
+	if err := binary.Write(d.out, binary.BigEndian, deltaMagic); err != nil {
+		return err
+	}
+
+	blockLen := sig.BlockLen
+	// buf holds the literal not yet sent, from lit, and the window, from
+	// start, with what was read ahead of it
+	buf := make([]byte, 0, maxLiteral+2*blockLen)
+	lit, start := 0, 0
+	eof := false
+	var sum rollsum
+	summed := false
+
+	for {
+		// Keep a byte past the window read, to roll the sum with
+		for !eof && len(buf)-start <= blockLen {
+			if len(buf) == cap(buf) {
+				if err := d.literal(buf[lit:start]); err != nil {
+					return err
+				}
+				buf = append(buf[:0], buf[start:]...)
+				lit, start = 0, 0
+			}
+			n, err := r.Read(buf[len(buf):cap(buf)])
+			buf = buf[:len(buf)+n]
+			if errors.Is(err, io.EOF) {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+
+		end := min(start+blockLen, len(buf))
+		if end == start {
+			break
+		}
+		if !summed {
+			sum = rollsum{}
+			sum.update(buf[start:end])
+			summed = true
+		}
+
+		if block := sig.find(sum.digest(), buf[start:end]); block >= 0 {
+			if err := d.literal(buf[lit:start]); err != nil {
+				return err
+			}
+			if err := d.copy(int64(block)*int64(blockLen), int64(end-start)); err != nil {
+				return err
+			}
+			start, lit = end, end
+			summed = false
+			continue
+		}
+
+		// Slide the window a byte, or shrink it at the end of the file
+		if end < len(buf) && end-start == blockLen {
+			sum.rotate(buf[start], buf[end])
+		} else {
+			sum.rollout(buf[start])
+		}
+		start++
+	}
+
+	if err := d.literal(buf[lit:start]); err != nil {
+		return err
+	}
+	if err := d.flushCopy(); err != nil {
+		return err
+	}
+	d.out.WriteByte(opEnd)
+	return d.out.Flush()
+}
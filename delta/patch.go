@@ -0,0 +1,119 @@
+package delta
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Commands of a delta. A literal of 1 to 64 bytes has its length as its
+// command; longer literals and copies give their lengths, and copies their
+// offsets, in the 1, 2, 4 or 8 bytes the command picks.
+const (
+	opEnd        = 0x00
+	opLiteralMax = 0x40 // Literal of up to 64 bytes
+	opLiteralN1  = 0x41
+	opCopyN1N1   = 0x45
+	opCopyN8N8   = 0x54
+)
+
+// PatchStats counts the bytes a delta copied from its basis and sent
+// literally
+type PatchStats struct {
+	CopiedBytes  int64
+	LiteralBytes int64
+}
+
+// Patch writes to w the file the delta read from r makes of basis
+func Patch(w io.Writer, r io.Reader, basis io.ReaderAt) (PatchStats, error) {
+	var stats PatchStats
+	in := bufio.NewReader(r)
+	var magic [4]byte
+	if _, err := io.ReadFull(in, magic[:]); err != nil {
+		return stats, fmt.Errorf("%w: %v", ErrInvalidDelta, err)
+	}
+	if got := binary.BigEndian.Uint32(magic[:]); got != deltaMagic {
+		return stats, fmt.Errorf("%w: unsupported format %#x", ErrInvalidDelta, got)
+	}
+
+	buf := make([]byte, 64<<10)
+	for {
+		op, err := in.ReadByte()
+		if err != nil {
+			return stats, fmt.Errorf("%w: missing end: %v", ErrInvalidDelta, err)
+		}
+		switch {
+		case op == opEnd:
+			return stats, nil
+
+		case op < opCopyN1N1:
+			length := int64(op)
+			if op > opLiteralMax {
+				if length, err = readParam(in, 1<<(op-opLiteralN1)); err != nil {
+					return stats, err
+				}
+			}
+			n, err := io.CopyBuffer(w, io.LimitReader(in, length), buf)
+			stats.LiteralBytes += n
+			if err != nil {
+				return stats, err
+			}
+			if n < length {
+				return stats, fmt.Errorf("%w: literal cut short", ErrInvalidDelta)
+			}
+
+		case op <= opCopyN8N8:
+			widths := op - opCopyN1N1
+			offset, err := readParam(in, 1<<(widths/4))
+			if err != nil {
+				return stats, err
+			}
+			length, err := readParam(in, 1<<(widths%4))
+			if err != nil {
+				return stats, err
+			}
+			if err := copyBasis(w, basis, offset, length, buf); err != nil {
+				return stats, err
+			}
+			stats.CopiedBytes += length
+
+		default:
+			return stats, fmt.Errorf("%w: unknown command %#x", ErrInvalidDelta, op)
+		}
+	}
+}
+
+// readParam reads a big-endian parameter of width bytes
+func readParam(in io.Reader, width int) (int64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(in, buf[8-width:]); err != nil {
+		return 0, fmt.Errorf("%w: command cut short", ErrInvalidDelta)
+	}
+	value := binary.BigEndian.Uint64(buf[:])
+	if value > 1<<62 {
+		return 0, fmt.Errorf("%w: parameter %d out of range", ErrInvalidDelta, value)
+	}
+	return int64(value), nil
+}
+
+// copyBasis writes length bytes of basis from offset to w
+func copyBasis(w io.Writer, basis io.ReaderAt, offset, length int64, buf []byte) error {
+	for length > 0 {
+		chunk := buf[:min(int64(len(buf)), length)]
+		n, err := basis.ReadAt(chunk, offset)
+		if n < len(chunk) {
+			if err == nil || errors.Is(err, io.EOF) {
+				return fmt.Errorf("%w: copy beyond the end of the basis", ErrInvalidDelta)
+			}
+			return err
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+		offset += int64(n)
+		length -= int64(n)
+	}
+	return nil
+}
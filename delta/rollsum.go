@@ -0,0 +1,44 @@
+package delta
+
+// rollsumOffset is added to every byte summed, as librsync does
+const rollsumOffset = 31
+
+// rollsum is the rsync rolling checksum of a window of bytes, which slides
+// along a file a byte at a time
+type rollsum struct {
+	count  uint32
+	s1, s2 uint16
+}
+
+// update adds p to the end of the window
+func (r *rollsum) update(p []byte) {
+	for _, b := range p {
+		r.s1 += uint16(b) + rollsumOffset
+		r.s2 += r.s1
+	}
+	r.count += uint32(len(p))
+}
+
+// rotate slides the window by a byte, dropping out and adding in
+func (r *rollsum) rotate(out, in byte) {
+	r.s1 += uint16(in) - uint16(out)
+	r.s2 += r.s1 - uint16(r.count)*(uint16(out)+rollsumOffset)
+}
+
+// rollout drops out from the start of the window
+func (r *rollsum) rollout(out byte) {
+	r.s1 -= uint16(out) + rollsumOffset
+	r.s2 -= uint16(r.count) * (uint16(out) + rollsumOffset)
+	r.count--
+}
+
+func (r *rollsum) digest() uint32 {
+	return uint32(r.s2)<<16 | uint32(r.s1)
+}
+
+// weakSum returns the rolling checksum of block
+func weakSum(block []byte) uint32 {
+	var r rollsum
+	r.update(block)
+	return r.digest()
+}
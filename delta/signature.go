@@ -0,0 +1,132 @@
+package delta
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Signature holds the sums of the blocks of a basis, indexed by weak sum to
+// find them in a new file
+type Signature struct {
+	BlockLen  int
+	StrongLen int
+	Blocks    int    // Blocks of the basis; the last may be short
+	strong    []byte // StrongLen bytes per block
+	index     map[uint32][]int
+}
+
+// strongSum returns the first n bytes of the BLAKE2b hash of block
+func strongSum(block []byte, n int) []byte {
+	sum := blake2b.Sum256(block)
+	return sum[:n]
+}
+
+// WriteSignature writes the signature of the content of r in blocks of
+// blockLen bytes, keeping strongLen bytes of the hash of each. It returns
+// the bytes of r read.
+func WriteSignature(w io.Writer, r io.Reader, blockLen, strongLen int) (int64, error) {
+	if blockLen < MinBlockLen || blockLen > MaxBlockLen {
+		return 0, fmt.Errorf("%w: block length %d outside %d to %d", ErrInvalidSignature, blockLen, MinBlockLen, MaxBlockLen)
+	}
+	if strongLen < 1 || strongLen > MaxStrongLen {
+		return 0, fmt.Errorf("%w: strong sum length %d outside 1 to %d", ErrInvalidSignature, strongLen, MaxStrongLen)
+	}
+
+	out := bufio.NewWriter(w)
+	header := binary.BigEndian.AppendUint32(nil, signatureMagic)
+	header = binary.BigEndian.AppendUint32(header, uint32(blockLen))
+	header = binary.BigEndian.AppendUint32(header, uint32(strongLen))
+	if _, err := out.Write(header); err != nil {
+		return 0, err
+	}
+
+	block := make([]byte, blockLen)
+	var read int64
+	for {
+		n, err := io.ReadFull(r, block)
+		read += int64(n)
+		if n > 0 {
+			sums := binary.BigEndian.AppendUint32(make([]byte, 0, 4+strongLen), weakSum(block[:n]))
+			sums = append(sums, strongSum(block[:n], strongLen)...)
+			if _, err := out.Write(sums); err != nil {
+				return read, err
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return read, err
+		}
+	}
+	return read, out.Flush()
+}
+
+// ReadSignature reads a signature written by WriteSignature or by rdiff
+// with the same sums, of at most maxBlocks blocks
+func ReadSignature(r io.Reader, maxBlocks int) (*Signature, error) {
+	in := bufio.NewReader(r)
+	var header [12]byte
+	if _, err := io.ReadFull(in, header[:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	if magic := binary.BigEndian.Uint32(header[0:]); magic != signatureMagic {
+		return nil, fmt.Errorf("%w: unsupported format %#x", ErrInvalidSignature, magic)
+	}
+	sig := &Signature{
+		BlockLen:  int(binary.BigEndian.Uint32(header[4:])),
+		StrongLen: int(binary.BigEndian.Uint32(header[8:])),
+		index:     make(map[uint32][]int),
+	}
+	if sig.BlockLen < 1 || sig.BlockLen > MaxBlockLen || sig.StrongLen < 1 || sig.StrongLen > MaxStrongLen {
+		return nil, fmt.Errorf("%w: block length %d, strong sum length %d", ErrInvalidSignature, sig.BlockLen, sig.StrongLen)
+	}
+
+	entry := make([]byte, 4+sig.StrongLen)
+	for {
+		_, err := io.ReadFull(in, entry)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+		}
+		if sig.Blocks == maxBlocks {
+			return nil, fmt.Errorf("%w: more than %d blocks", ErrInvalidSignature, maxBlocks)
+		}
+		weak := binary.BigEndian.Uint32(entry)
+		sig.index[weak] = append(sig.index[weak], sig.Blocks)
+		sig.strong = append(sig.strong, entry[4:]...)
+		sig.Blocks++
+	}
+	return sig, nil
+}
+
+// find returns the block of the signature whose sums are those of block,
+// or -1. A signature does not record the length of the last block of the
+// basis, so blocks shorter than BlockLen, at the end of a file, are only
+// looked for among the last.
+func (s *Signature) find(weak uint32, block []byte) int {
+	candidates, ok := s.index[weak]
+	if !ok {
+		return -1
+	}
+	var strong []byte
+	for _, i := range candidates {
+		if len(block) < s.BlockLen && i != s.Blocks-1 {
+			continue
+		}
+		if strong == nil {
+			strong = strongSum(block, s.StrongLen)
+		}
+		if string(strong) == string(s.strong[i*s.StrongLen:(i+1)*s.StrongLen]) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,129 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// consulMetaInstanceID is the service meta key that overrides the instance ID
+const consulMetaInstanceID = "instance_id"
+
+// ConsulSource finds peers among the passing instances of a Consul service,
+// using blocking queries to learn about changes as they happen. An instance's
+// ID is its instance_id service meta value, or its service ID.
+type ConsulSource struct {
+	addr    string
+	service string
+	tag     string
+	token   string
+	scheme  string
+	client  *http.Client
+	index   uint64 // X-Consul-Index of the last answer, for blocking queries
+}
+
+type consulServiceEntry struct {
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		ID      string            `json:"ID"`
+		Address string            `json:"Address"`
+		Port    int               `json:"Port"`
+		Meta    map[string]string `json:"Meta"`
+	} `json:"Service"`
+}
+
+// NewConsulSource creates a source for service registered with the Consul
+// agent at addr. Discovered endpoints use scheme (http or https).
+func NewConsulSource(addr, service, tag, token, scheme string) *ConsulSource {
+	return &ConsulSource{
+		addr:    strings.TrimRight(addr, "/"),
+		service: service,
+		tag:     tag,
+		token:   token,
+		scheme:  scheme,
+		client:  &http.Client{},
+	}
+}
+
+// Name returns the source name
+func (s *ConsulSource) Name() string {
+	return "consul"
+}
+
+func (s *ConsulSource) watches() bool {
+	return true
+}
+
+// Peers returns the passing service instances. When wait is set and an
+// earlier answer is known, Consul holds the request until the instances
+// change or wait elapses.
+func (s *ConsulSource) Peers(ctx context.Context, wait time.Duration) (map[string]string, error) {
+	query := url.Values{"passing": {"true"}}
+	if s.tag != "" {
+		query.Set("tag", s.tag)
+	}
+	timeout := 10 * time.Second
+	if wait > 0 && s.index > 0 {
+		query.Set("index", strconv.FormatUint(s.index, 10))
+		query.Set("wait", fmt.Sprintf("%ds", max(1, int(wait.Seconds()))))
+		// Consul adds up to wait/16 of jitter to the hold time
+		timeout += wait + wait/16
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	reqURL := s.addr + "/v1/health/service/" + url.PathEscape(s.service) + "?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consul request: %w", err)
+	}
+	if s.token != "" {
+		req.Header.Set("X-Consul-Token", s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("consul request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("consul returned status %d", resp.StatusCode)
+	}
+
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("failed to decode consul response: %w", err)
+	}
+
+	// Consul documents that a reset index must restart blocking from zero
+	index, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if index < s.index {
+		index = 0
+	}
+	s.index = index
+
+	peers := make(map[string]string, len(entries))
+	for _, e := range entries {
+		instanceID := e.Service.Meta[consulMetaInstanceID]
+		if instanceID == "" {
+			instanceID = e.Service.ID
+		}
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		if instanceID == "" || host == "" || e.Service.Port == 0 {
+			continue
+		}
+		peers[instanceID] = s.scheme + "://" + net.JoinHostPort(host, strconv.Itoa(e.Service.Port))
+	}
+	return peers, nil
+}
@@ -0,0 +1,158 @@
+// Package discovery finds peer CallFS instances at runtime from DNS SRV
+// records, Consul or the Kubernetes API, so that autoscaled clusters do not
+// need a static list of peer endpoints.
+package discovery
+
+import (
+	"context"
+	"maps"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/metrics"
+)
+
+// Source looks up the peer instances of a cluster
+type Source interface {
+	// Name identifies the source in logs and metrics
+	Name() string
+
+	// Peers returns instance ID -> endpoint URL for every instance found.
+	// Sources that can watch for changes block until the set changes or wait
+	// elapses; a wait of 0 asks for an immediate answer.
+	Peers(ctx context.Context, wait time.Duration) (map[string]string, error)
+}
+
+// watchingSource is implemented by sources whose Peers call blocks, so the
+// watcher does not sleep between calls
+type watchingSource interface {
+	watches() bool
+}
+
+// initialLookupTimeout bounds the lookup made before the server starts
+const initialLookupTimeout = 10 * time.Second
+
+// Watcher keeps the peer set up to date and reports every change
+type Watcher struct {
+	source   Source
+	interval time.Duration
+	selfID   string
+	static   map[string]string
+	apply    func(map[string]string)
+	logger   *zap.Logger
+
+	current map[string]string
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// NewWatcher creates a watcher that calls apply with the merged peer set
+// whenever it changes. The instance selfID is never reported as a peer, and
+// static peers are always included, overriding discovered endpoints.
+func NewWatcher(source Source, interval time.Duration, selfID string, static map[string]string, apply func(map[string]string), logger *zap.Logger) *Watcher {
+	return &Watcher{
+		source:   source,
+		interval: interval,
+		selfID:   selfID,
+		static:   maps.Clone(static),
+		apply:    apply,
+		logger:   logger.With(zap.String("source", source.Name())),
+		current:  maps.Clone(static),
+	}
+}
+
+// Start looks peers up once and then keeps watching in the background until
+// Close is called. A failed first lookup is logged, not returned, so an
+// instance can start before the rest of its cluster is registered.
+func (w *Watcher) Start(ctx context.Context) {
+	lookupCtx, cancel := context.WithTimeout(ctx, initialLookupTimeout)
+	w.refresh(lookupCtx, 0)
+	cancel()
+
+	ctx, w.cancel = context.WithCancel(ctx)
+	w.done = make(chan struct{})
+	go w.run(ctx)
+}
+
+// Close stops watching
+func (w *Watcher) Close() {
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	<-w.done
+}
+
+func (w *Watcher) run(ctx context.Context) {
+	defer close(w.done)
+	ws, ok := w.source.(watchingSource)
+	blocking := ok && ws.watches()
+
+	for {
+		delay := w.interval
+		if w.refresh(ctx, w.interval) && blocking {
+			delay = 0
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if delay == 0 {
+			continue
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// refresh looks peers up and applies any change. It reports whether the
+// lookup succeeded.
+func (w *Watcher) refresh(ctx context.Context, wait time.Duration) bool {
+	found, err := w.source.Peers(ctx, wait)
+	if err != nil {
+		if ctx.Err() == nil {
+			metrics.DiscoveryRefreshesTotal.WithLabelValues(w.source.Name(), "error").Inc()
+			w.logger.Warn("Peer discovery failed; keeping the current peers", zap.Error(err))
+		}
+		return false
+	}
+	metrics.DiscoveryRefreshesTotal.WithLabelValues(w.source.Name(), "success").Inc()
+
+	peers := make(map[string]string, len(found)+len(w.static))
+	for id, endpoint := range found {
+		if id != w.selfID {
+			peers[id] = endpoint
+		}
+	}
+	maps.Copy(peers, w.static)
+	metrics.DiscoveredPeers.Set(float64(len(peers)))
+
+	if maps.Equal(peers, w.current) {
+		return true
+	}
+	var added, removed []string
+	for id, endpoint := range peers {
+		if w.current[id] != endpoint {
+			added = append(added, id)
+		}
+	}
+	for id := range w.current {
+		if _, ok := peers[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	w.logger.Info("Peer set changed",
+		zap.Strings("added", added),
+		zap.Strings("removed", removed),
+		zap.Int("peer_count", len(peers)))
+
+	w.current = peers
+	w.apply(maps.Clone(peers))
+	return true
+}
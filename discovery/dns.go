@@ -0,0 +1,54 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DNSSource finds peers from the SRV records of a name, such as the records
+// Kubernetes publishes for a headless service. The first label of each
+// target is used as its instance ID, so a StatefulSet pod
+// callfs-0.callfs.default.svc.cluster.local is instance callfs-0.
+type DNSSource struct {
+	name     string
+	scheme   string
+	resolver *net.Resolver
+}
+
+// NewDNSSource creates a source for the SRV records of name. Discovered
+// endpoints use scheme (http or https).
+func NewDNSSource(name, scheme string) *DNSSource {
+	return &DNSSource{
+		name:     strings.TrimSpace(name),
+		scheme:   scheme,
+		resolver: net.DefaultResolver,
+	}
+}
+
+// Name returns the source name
+func (s *DNSSource) Name() string {
+	return "dns"
+}
+
+// Peers resolves the SRV records. DNS cannot be watched, so wait is ignored.
+func (s *DNSSource) Peers(ctx context.Context, _ time.Duration) (map[string]string, error) {
+	_, records, err := s.resolver.LookupSRV(ctx, "", "", s.name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up SRV records for %s: %w", s.name, err)
+	}
+
+	peers := make(map[string]string, len(records))
+	for _, rec := range records {
+		host := strings.TrimSuffix(rec.Target, ".")
+		instanceID, _, _ := strings.Cut(host, ".")
+		if instanceID == "" {
+			continue
+		}
+		peers[instanceID] = s.scheme + "://" + net.JoinHostPort(host, strconv.Itoa(int(rec.Port)))
+	}
+	return peers, nil
+}
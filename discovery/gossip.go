@@ -0,0 +1,330 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"go.uber.org/zap"
+)
+
+// gossipLeaveTimeout bounds how long Close waits to announce a departure, and
+// gossipUpdateTimeout how long Advertise waits to spread a change
+const (
+	gossipLeaveTimeout  = 5 * time.Second
+	gossipUpdateTimeout = 5 * time.Second
+)
+
+// GossipOptions configures gossip membership
+type GossipOptions struct {
+	InstanceID    string
+	Endpoint      string   // API endpoint advertised to other instances
+	BindAddr      string   // host:port for gossip traffic (TCP and UDP)
+	AdvertiseAddr string   // host:port other instances reach this one at; BindAddr when empty
+	Join          []string // Gossip addresses of existing members
+	SecretKey     []byte   // 16, 24 or 32 bytes to encrypt gossip; none when empty
+}
+
+// Member is an instance known through gossip
+type Member struct {
+	InstanceID string `json:"instance_id"`
+	Endpoint   string `json:"endpoint"`
+	Address    string `json:"address"` // Gossip address
+	State      string `json:"state"`   // alive or departed
+	Advertisement
+}
+
+// Advertisement is what an instance tells the others about the files it
+// takes: the backends it stores them on and the paths it refuses writes
+// for. Other instances place new files accordingly.
+type Advertisement struct {
+	Backends         []string `json:"backends,omitempty"` // localfs and s3, as enabled
+	Draining         bool     `json:"draining,omitempty"`
+	ReadOnly         bool     `json:"read_only,omitempty"`
+	ReadOnlyPrefixes []string `json:"read_only_prefixes,omitempty"`
+}
+
+// Accepts reports whether an instance advertising a takes a new file at
+// path on backend. An instance that advertises no backends takes any.
+func (a Advertisement) Accepts(backend, filePath string) bool {
+	if a.Draining || a.ReadOnly {
+		return false
+	}
+	if len(a.Backends) > 0 && !slices.Contains(a.Backends, backend) {
+		return false
+	}
+	for _, prefix := range a.ReadOnlyPrefixes {
+		if filePath == prefix || prefix == "/" || strings.HasPrefix(filePath, prefix+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+// Membership reports the instances of a cluster and their liveness, and
+// spreads what this instance advertises
+type Membership interface {
+	Members() []Member
+	Advertise(a Advertisement) error
+}
+
+// gossipMeta is the node metadata each instance advertises
+type gossipMeta struct {
+	Endpoint string `json:"endpoint"`
+	Advertisement
+}
+
+// GossipSource finds peers by joining a gossip cluster of CallFS instances.
+// Every instance advertises its instance ID and API endpoint, and failed
+// instances are detected by the members themselves, so no external registry
+// is needed. Only one seed address has to be known to join.
+type GossipSource struct {
+	list     *memberlist.Memberlist
+	endpoint string
+	join     []string
+	logger   *zap.Logger
+
+	changed chan struct{}
+
+	mu       sync.Mutex
+	meta     []byte
+	departed map[string]Member // instances that failed or left, by instance ID
+}
+
+// NewGossipSource starts gossiping on opts.BindAddr and joins the cluster
+// through opts.Join. Failing to reach any seed is not an error: the first
+// instance of a cluster has nobody to join, and joining is retried.
+func NewGossipSource(opts GossipOptions, logger *zap.Logger) (*GossipSource, error) {
+	meta, err := encodeMeta(gossipMeta{Endpoint: opts.Endpoint})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &GossipSource{
+		meta:     meta,
+		endpoint: opts.Endpoint,
+		join:     opts.Join,
+		logger:   logger,
+		changed:  make(chan struct{}, 1),
+		departed: make(map[string]Member),
+	}
+
+	cfg := memberlist.DefaultLANConfig()
+	cfg.Name = opts.InstanceID
+	cfg.Delegate = s
+	cfg.Events = s
+	cfg.Logger = zap.NewStdLog(logger.Named("memberlist"))
+	if len(opts.SecretKey) > 0 {
+		cfg.SecretKey = opts.SecretKey
+	}
+	if cfg.BindAddr, cfg.BindPort, err = splitHostPort(opts.BindAddr); err != nil {
+		return nil, fmt.Errorf("invalid gossip bind address: %w", err)
+	}
+	cfg.AdvertisePort = cfg.BindPort
+	if opts.AdvertiseAddr != "" {
+		if cfg.AdvertiseAddr, cfg.AdvertisePort, err = splitHostPort(opts.AdvertiseAddr); err != nil {
+			return nil, fmt.Errorf("invalid gossip advertise address: %w", err)
+		}
+	}
+
+	s.list, err = memberlist.Create(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start gossip: %w", err)
+	}
+	s.tryJoin()
+	return s, nil
+}
+
+func encodeMeta(m gossipMeta) ([]byte, error) {
+	meta, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode gossip metadata: %w", err)
+	}
+	if len(meta) > memberlist.MetaMaxSize {
+		return nil, fmt.Errorf("gossip metadata is %d bytes, more than the %d allowed; use a shorter endpoint or fewer read-only prefixes", len(meta), memberlist.MetaMaxSize)
+	}
+	return meta, nil
+}
+
+func splitHostPort(addr string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port %q", portStr)
+	}
+	return host, port, nil
+}
+
+// tryJoin contacts the seed members while this instance knows no one else
+func (s *GossipSource) tryJoin() {
+	if len(s.join) == 0 || s.list.NumMembers() > 1 {
+		return
+	}
+	n, err := s.list.Join(s.join)
+	if err != nil && n == 0 {
+		s.logger.Warn("Failed to join gossip cluster; will retry", zap.Strings("seeds", s.join), zap.Error(err))
+		return
+	}
+	s.logger.Info("Joined gossip cluster", zap.Int("contacted", n))
+}
+
+// Name returns the source name
+func (s *GossipSource) Name() string {
+	return "gossip"
+}
+
+func (s *GossipSource) watches() bool {
+	return true
+}
+
+// Peers returns the live members. With wait set it first blocks until
+// membership changes or wait elapses.
+func (s *GossipSource) Peers(ctx context.Context, wait time.Duration) (map[string]string, error) {
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-s.changed:
+		case <-timer.C:
+			s.tryJoin()
+		case <-ctx.Done():
+		}
+		timer.Stop()
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	peers := make(map[string]string)
+	for _, node := range s.list.Members() {
+		if endpoint := decodeMeta(node.Meta).Endpoint; endpoint != "" {
+			peers[node.Name] = endpoint
+		}
+	}
+	return peers, nil
+}
+
+// Advertise spreads a as what this instance takes, replacing what it
+// advertised before
+func (s *GossipSource) Advertise(a Advertisement) error {
+	meta, err := encodeMeta(gossipMeta{Endpoint: s.endpoint, Advertisement: a})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.meta = meta
+	s.mu.Unlock()
+	return s.list.UpdateNode(gossipUpdateTimeout)
+}
+
+// Members lists every instance seen, including those that failed or left,
+// sorted by instance ID
+func (s *GossipSource) Members() []Member {
+	s.mu.Lock()
+	members := make(map[string]Member, len(s.departed))
+	for id, m := range s.departed {
+		members[id] = m
+	}
+	s.mu.Unlock()
+
+	for _, node := range s.list.Members() {
+		members[node.Name] = memberFromNode(node, "alive")
+	}
+
+	out := make([]Member, 0, len(members))
+	for _, m := range members {
+		out = append(out, m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].InstanceID < out[j].InstanceID })
+	return out
+}
+
+// Close announces that this instance is leaving and stops gossiping
+func (s *GossipSource) Close() error {
+	if err := s.list.Leave(gossipLeaveTimeout); err != nil {
+		s.logger.Warn("Failed to announce gossip departure", zap.Error(err))
+	}
+	return s.list.Shutdown()
+}
+
+func memberFromNode(node *memberlist.Node, state string) Member {
+	meta := decodeMeta(node.Meta)
+	return Member{
+		InstanceID:    node.Name,
+		Endpoint:      meta.Endpoint,
+		Address:       node.Address(),
+		State:         state,
+		Advertisement: meta.Advertisement,
+	}
+}
+
+func decodeMeta(meta []byte) gossipMeta {
+	var m gossipMeta
+	if json.Unmarshal(meta, &m) != nil {
+		return gossipMeta{}
+	}
+	return m
+}
+
+func (s *GossipSource) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// NodeMeta implements memberlist.Delegate
+func (s *GossipSource) NodeMeta(limit int) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.meta
+}
+
+// NotifyMsg implements memberlist.Delegate; no user messages are sent
+func (s *GossipSource) NotifyMsg([]byte) {}
+
+// GetBroadcasts implements memberlist.Delegate
+func (s *GossipSource) GetBroadcasts(overhead, limit int) [][]byte {
+	return nil
+}
+
+// LocalState implements memberlist.Delegate
+func (s *GossipSource) LocalState(join bool) []byte {
+	return nil
+}
+
+// MergeRemoteState implements memberlist.Delegate
+func (s *GossipSource) MergeRemoteState(buf []byte, join bool) {}
+
+// NotifyJoin implements memberlist.EventDelegate
+func (s *GossipSource) NotifyJoin(node *memberlist.Node) {
+	s.mu.Lock()
+	delete(s.departed, node.Name)
+	s.mu.Unlock()
+	s.logger.Info("Gossip member joined", zap.String("instance_id", node.Name), zap.String("address", node.Address()))
+	s.notify()
+}
+
+// NotifyLeave implements memberlist.EventDelegate
+func (s *GossipSource) NotifyLeave(node *memberlist.Node) {
+	s.mu.Lock()
+	s.departed[node.Name] = memberFromNode(node, "departed")
+	s.mu.Unlock()
+	s.logger.Warn("Gossip member departed", zap.String("instance_id", node.Name))
+	s.notify()
+}
+
+// NotifyUpdate implements memberlist.EventDelegate
+func (s *GossipSource) NotifyUpdate(node *memberlist.Node) {
+	s.notify()
+}
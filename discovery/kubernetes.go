@@ -0,0 +1,281 @@
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// In-cluster service account files
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	saTokenFile       = serviceAccountDir + "/token"
+	saCAFile          = serviceAccountDir + "/ca.crt"
+	saNamespaceFile   = serviceAccountDir + "/namespace"
+)
+
+// KubernetesSource finds peers from the Endpoints object of a Kubernetes
+// service and watches it for changes. It runs inside the cluster with the
+// pod's service account, which needs get, list and watch on endpoints. An
+// address's instance ID is the name of the pod behind it.
+type KubernetesSource struct {
+	apiServer string
+	namespace string
+	service   string
+	portName  string
+	scheme    string
+	tokenFile string
+	client    *http.Client
+
+	resourceVersion string // of the last Endpoints seen, to resume the watch
+	peers           map[string]string
+	missing         bool // The Endpoints did not exist when last read
+}
+
+type k8sEndpoints struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Subsets []struct {
+		Addresses []struct {
+			IP        string `json:"ip"`
+			Hostname  string `json:"hostname"`
+			TargetRef *struct {
+				Kind string `json:"kind"`
+				Name string `json:"name"`
+			} `json:"targetRef"`
+		} `json:"addresses"`
+		Ports []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"ports"`
+	} `json:"subsets"`
+}
+
+type k8sWatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// NewKubernetesSource creates a source for service in namespace, or in the
+// pod's own namespace when namespace is empty. portName selects the endpoint
+// port, the first one when empty. Discovered endpoints use scheme.
+func NewKubernetesSource(namespace, service, portName, scheme string) (*KubernetesSource, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("kubernetes discovery requires running inside a cluster (KUBERNETES_SERVICE_HOST is not set)")
+	}
+
+	if namespace == "" {
+		data, err := os.ReadFile(saNamespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pod namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+
+	caPEM, err := os.ReadFile(saCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", saCAFile)
+	}
+
+	return &KubernetesSource{
+		apiServer: "https://" + net.JoinHostPort(host, port),
+		namespace: namespace,
+		service:   service,
+		portName:  portName,
+		scheme:    scheme,
+		tokenFile: saTokenFile,
+		client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool},
+			},
+		},
+	}, nil
+}
+
+// Name returns the source name
+func (s *KubernetesSource) Name() string {
+	return "kubernetes"
+}
+
+func (s *KubernetesSource) watches() bool {
+	return true
+}
+
+// Peers returns the ready addresses of the service. When wait is set and the
+// Endpoints were read before, it watches them for up to wait and returns at
+// the first change. Endpoints that do not exist cannot be watched, so they
+// are listed again after wait.
+func (s *KubernetesSource) Peers(ctx context.Context, wait time.Duration) (map[string]string, error) {
+	if wait > 0 && s.missing {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if wait <= 0 || s.resourceVersion == "" {
+		return s.list(ctx)
+	}
+
+	peers, err := s.watch(ctx, wait)
+	if errors.Is(err, errWatchExpired) {
+		s.resourceVersion = ""
+		return s.list(ctx)
+	}
+	return peers, err
+}
+
+// errWatchExpired means the watched resource version is too old to resume from
+var errWatchExpired = errors.New("kubernetes watch expired")
+
+func (s *KubernetesSource) list(ctx context.Context) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	resp, err := s.get(ctx, fmt.Sprintf("/api/v1/namespaces/%s/endpoints/%s", url.PathEscape(s.namespace), url.PathEscape(s.service)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		// The service has not been created yet, or was deleted; there are
+		// no peers
+		s.resourceVersion = ""
+		s.peers = map[string]string{}
+		s.missing = true
+		return s.peers, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kubernetes API returned status %d for endpoints %s/%s", resp.StatusCode, s.namespace, s.service)
+	}
+
+	var ep k8sEndpoints
+	if err := json.NewDecoder(resp.Body).Decode(&ep); err != nil {
+		return nil, fmt.Errorf("failed to decode endpoints: %w", err)
+	}
+	s.resourceVersion = ep.Metadata.ResourceVersion
+	s.peers = s.peersFrom(&ep)
+	s.missing = false
+	return s.peers, nil
+}
+
+func (s *KubernetesSource) watch(ctx context.Context, wait time.Duration) (map[string]string, error) {
+	query := url.Values{
+		"watch":           {"1"},
+		"fieldSelector":   {"metadata.name=" + s.service},
+		"resourceVersion": {s.resourceVersion},
+		"timeoutSeconds":  {strconv.Itoa(max(1, int(wait.Seconds())))},
+	}
+	ctx, cancel := context.WithTimeout(ctx, wait+10*time.Second)
+	defer cancel()
+
+	resp, err := s.get(ctx, fmt.Sprintf("/api/v1/namespaces/%s/endpoints?%s", url.PathEscape(s.namespace), query.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		return nil, errWatchExpired
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kubernetes API returned status %d watching endpoints %s/%s", resp.StatusCode, s.namespace, s.service)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var event k8sWatchEvent
+		if err := dec.Decode(&event); err != nil {
+			if errors.Is(err, io.EOF) {
+				// The server ended the watch after timeoutSeconds with no change
+				return s.peers, nil
+			}
+			return nil, fmt.Errorf("failed to read endpoints watch: %w", err)
+		}
+
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			var ep k8sEndpoints
+			if err := json.Unmarshal(event.Object, &ep); err != nil {
+				return nil, fmt.Errorf("failed to decode endpoints: %w", err)
+			}
+			s.resourceVersion = ep.Metadata.ResourceVersion
+			s.peers = s.peersFrom(&ep)
+			return s.peers, nil
+		case "DELETED":
+			s.resourceVersion = ""
+			s.peers = map[string]string{}
+			s.missing = true
+			return s.peers, nil
+		case "ERROR":
+			// Typically 410 Gone: the resource version was compacted away
+			return nil, errWatchExpired
+		}
+		// BOOKMARK events carry nothing of interest
+	}
+}
+
+func (s *KubernetesSource) get(ctx context.Context, path string) (*http.Response, error) {
+	// The token is re-read on every request because bound tokens are rotated
+	token, err := os.ReadFile(s.tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account token: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.apiServer+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes API request failed: %w", err)
+	}
+	return resp, nil
+}
+
+// peersFrom maps each ready address to the selected port
+func (s *KubernetesSource) peersFrom(ep *k8sEndpoints) map[string]string {
+	peers := make(map[string]string)
+	for _, subset := range ep.Subsets {
+		port := 0
+		for _, p := range subset.Ports {
+			if s.portName == "" || p.Name == s.portName {
+				port = p.Port
+				break
+			}
+		}
+		if port == 0 {
+			continue
+		}
+		for _, addr := range subset.Addresses {
+			instanceID := addr.Hostname
+			if addr.TargetRef != nil && addr.TargetRef.Kind == "Pod" {
+				instanceID = addr.TargetRef.Name
+			}
+			if instanceID == "" {
+				instanceID = addr.IP
+			}
+			peers[instanceID] = s.scheme + "://" + net.JoinHostPort(addr.IP, strconv.Itoa(port))
+		}
+	}
+	return peers
+}
@@ -170,6 +170,10 @@ const docTemplate = `{
                             "type": "string"
                         },
                         "headers": {
+                            "X-CallFS-Checksum": {
+                                "type": "string",
+                                "description": "SHA-256 of the file content as sha256=<hex>, when known"
+                            },
                             "X-CallFS-GID": {
                                 "type": "string",
                                 "description": "Group ID"
@@ -298,11 +302,11 @@ const docTemplate = `{
                         "BearerAuth": []
                     }
                 ],
-                "description": "Creates a new file or directory. If the resource exists on another server, provides proper conflict resolution",
+                "description": "Creates a new file or directory. Existing resources are reported the same way whichever instance owns them",
                 "tags": [
                     "files"
                 ],
-                "summary": "Create or update file or directory with cross-server conflict detection",
+                "summary": "Create file or directory",
                 "parameters": [
                     {
                         "type": "string",
@@ -346,9 +350,9 @@ const docTemplate = `{
                         }
                     },
                     "409": {
-                        "description": "Conflict - resource exists on another server",
+                        "description": "Conflict - resource already exists",
                         "schema": {
-                            "$ref": "#/definitions/handlers.CrossServerConflictResponse"
+                            "$ref": "#/definitions/handlers.ErrorResponse"
                         }
                     },
                     "500": {
@@ -377,6 +381,12 @@ const docTemplate = `{
                         "name": "path",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "HTTP date; 412 when the entry was modified after it",
+                        "name": "If-Unmodified-Since",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -401,6 +411,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/handlers.ErrorResponse"
                         }
                     },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -439,6 +455,14 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "headers": {
+                            "Accept-Ranges": {
+                                "type": "string",
+                                "description": "bytes for files downloadable in ranges, none for erasure-coded files"
+                            },
+                            "X-CallFS-Checksum": {
+                                "type": "string",
+                                "description": "SHA-256 of the file content as sha256=<hex>, when known"
+                            },
                             "X-CallFS-GID": {
                                 "type": "string",
                                 "description": "Group ID"
@@ -455,10 +479,18 @@ const docTemplate = `{
                                 "type": "string",
                                 "description": "File mode (permissions)"
                             },
+                            "X-CallFS-NLink": {
+                                "type": "string",
+                                "description": "Hard links to the stored file (localfs)"
+                            },
                             "X-CallFS-Size": {
                                 "type": "string",
                                 "description": "File size in bytes"
                             },
+                            "X-CallFS-Symlink-Target": {
+                                "type": "string",
+                                "description": "Percent-encoded target of a symlink (localfs)"
+                            },
                             "X-CallFS-Type": {
                                 "type": "string",
                                 "description": "File type (file or directory)"
@@ -466,6 +498,10 @@ const docTemplate = `{
                             "X-CallFS-UID": {
                                 "type": "string",
                                 "description": "User ID"
+                            },
+                            "X-CallFS-XAttrs": {
+                                "type": "string",
+                                "description": "Configured extended attributes, form-encoded (localfs)"
                             }
                         }
                     },
@@ -677,29 +713,6 @@ const docTemplate = `{
         }
     },
     "definitions": {
-        "handlers.CrossServerConflictResponse": {
-            "type": "object",
-            "properties": {
-                "backend_type": {
-                    "type": "string"
-                },
-                "error": {
-                    "type": "string"
-                },
-                "existing_path": {
-                    "type": "string"
-                },
-                "instance_id": {
-                    "type": "string"
-                },
-                "suggestion": {
-                    "type": "string"
-                },
-                "update_url": {
-                    "type": "string"
-                }
-            }
-        },
         "handlers.DirectoryListingResponse": {
             "type": "object",
             "properties": {
@@ -14,8 +14,10 @@ import (
 
 	"go.uber.org/zap"
 
+	"github.com/ebogdum/callfs/auth"
 	"github.com/ebogdum/callfs/backends"
 	"github.com/ebogdum/callfs/config"
+	corelog "github.com/ebogdum/callfs/core/log"
 	"github.com/ebogdum/callfs/metadata"
 )
 
@@ -29,7 +31,7 @@ type Manager struct {
 	instanceID    string
 	selfEndpoint  string
 	peerEndpoints map[string]string
-	internalToken string
+	signer        *auth.RequestSigner // Authenticates requests to peers
 	httpClient    *http.Client
 	logger        *zap.Logger
 }
@@ -41,16 +43,16 @@ func NewManager(
 	cfg *config.ErasureConfig,
 	instanceID string,
 	peerEndpoints map[string]string,
-	internalToken string,
+	signer *auth.RequestSigner,
 	logger *zap.Logger,
 ) *Manager {
 	// Derive selfEndpoint from peerEndpoints (includes self when populated in cmd/main.go)
 	selfEndpoint := peerEndpoints[instanceID]
 
-	// Warn if any peer endpoint uses unencrypted HTTP (internal secret would be sent in plaintext)
+	// Warn if any peer endpoint uses unencrypted HTTP (shards would be sent in plaintext)
 	for id, ep := range peerEndpoints {
 		if strings.HasPrefix(ep, "http://") {
-			logger.Warn("Peer endpoint uses unencrypted HTTP - internal token will be sent in plaintext",
+			logger.Warn("Peer endpoint uses unencrypted HTTP - shards will be sent in plaintext",
 				zap.String("peer_id", id),
 				zap.String("endpoint", ep))
 		}
@@ -65,7 +67,7 @@ func NewManager(
 		instanceID:    instanceID,
 		selfEndpoint:  selfEndpoint,
 		peerEndpoints: peerEndpoints,
-		internalToken: internalToken,
+		signer:        signer,
 		httpClient:    &http.Client{Timeout: 30 * time.Second},
 		logger:        logger,
 	}
@@ -254,7 +256,7 @@ func (m *Manager) StoreFile(ctx context.Context, path string, data []byte, origi
 		return nil, fmt.Errorf("failed to store erasure metadata: %w", err)
 	}
 
-	m.logger.Info("Erasure-coded file stored",
+	corelog.WithContext(ctx, m.logger).Info("Erasure-coded file stored",
 		zap.String("path", path),
 		zap.Int("data_shards", dataShards),
 		zap.Int("parity_shards", parityShards),
@@ -298,7 +300,7 @@ func (m *Manager) RetrieveFile(ctx context.Context, path string) ([]byte, error)
 			mu.Lock()
 			defer mu.Unlock()
 			if fetchErr != nil {
-				m.logger.Warn("Failed to fetch shard",
+				corelog.WithContext(ctx, m.logger).Warn("Failed to fetch shard",
 					zap.Int("index", si.Index),
 					zap.String("instance", si.InstanceID),
 					zap.Error(fetchErr))
@@ -306,7 +308,7 @@ func (m *Manager) RetrieveFile(ctx context.Context, path string) ([]byte, error)
 			}
 
 			if ShardChecksum(data) != si.Checksum {
-				m.logger.Warn("Shard checksum mismatch",
+				corelog.WithContext(ctx, m.logger).Warn("Shard checksum mismatch",
 					zap.Int("index", si.Index),
 					zap.String("instance", si.InstanceID))
 				return
@@ -423,14 +425,14 @@ func (m *Manager) DeleteFile(ctx context.Context, path string) error {
 			defer wg.Done()
 			if si.InstanceID == m.instanceID {
 				if delErr := m.localBackend.Delete(ctx, si.Path); delErr != nil {
-					m.logger.Warn("Failed to delete local shard",
+					corelog.WithContext(ctx, m.logger).Warn("Failed to delete local shard",
 						zap.Int("index", si.Index),
 						zap.Error(delErr))
 				}
 			} else {
 				shardPrefix := extractShardPrefix(si.Path)
 				if delErr := m.deleteRemoteShard(ctx, si.InstanceID, shardPrefix, si.Index); delErr != nil {
-					m.logger.Warn("Failed to delete remote shard",
+					corelog.WithContext(ctx, m.logger).Warn("Failed to delete remote shard",
 						zap.Int("index", si.Index),
 						zap.String("instance", si.InstanceID),
 						zap.Error(delErr))
@@ -473,9 +475,12 @@ func (m *Manager) storeRemoteShard(ctx context.Context, instanceID, hashPrefix s
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Authorization", "Bearer "+m.internalToken)
+	corelog.PropagateRequestID(ctx, req)
 	req.Header.Set("Content-Type", "application/octet-stream")
 	req.ContentLength = int64(len(data))
+	if err := m.signer.Sign(req); err != nil {
+		return err
+	}
 
 	resp, err := m.httpClient.Do(req)
 	if err != nil {
@@ -503,7 +508,10 @@ func (m *Manager) fetchRemoteShard(ctx context.Context, instanceID, shardPrefix
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+m.internalToken)
+	if err := m.signer.Sign(req); err != nil {
+		return nil, err
+	}
+	corelog.PropagateRequestID(ctx, req)
 
 	resp, err := m.httpClient.Do(req)
 	if err != nil {
@@ -530,7 +538,10 @@ func (m *Manager) deleteRemoteShard(ctx context.Context, instanceID, shardPrefix
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Authorization", "Bearer "+m.internalToken)
+	if err := m.signer.Sign(req); err != nil {
+		return err
+	}
+	corelog.PropagateRequestID(ctx, req)
 
 	resp, err := m.httpClient.Do(req)
 	if err != nil {
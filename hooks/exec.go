@@ -0,0 +1,86 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// execStderrLimit is how much of a command's standard error is kept to
+// explain its failure
+const execStderrLimit = 1024
+
+// execHook runs a command with the content on its standard input. What it
+// writes to standard output replaces the content.
+type execHook struct {
+	command []string
+	env     []string // Names of the server's environment variables passed on
+}
+
+func newExecHook(command, env []string) (*execHook, error) {
+	if len(command) == 0 || command[0] == "" {
+		return nil, fmt.Errorf("exec hooks need a command")
+	}
+	return &execHook{command: command, env: env}, nil
+}
+
+// environ returns the environment of the command: PATH, the variables named
+// in env and those describing ev. The rest of the server's environment,
+// which holds its secrets, is not passed on.
+func (h *execHook) environ(ev Event) []string {
+	var env []string
+	for _, name := range append([]string{"PATH"}, h.env...) {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return append(env,
+		"CALLFS_HOOK_STAGE="+string(ev.Stage),
+		"CALLFS_PATH="+ev.Path,
+		"CALLFS_SIZE="+strconv.FormatInt(ev.Size, 10))
+}
+
+func (h *execHook) Process(ctx context.Context, ev Event, content io.Reader, out io.Writer) (bool, error) {
+	cmd := exec.CommandContext(ctx, h.command[0], h.command[1:]...)
+	cmd.Stdin = content
+	cmd.Stdout = out
+	stderr := &cappedBuffer{limit: execStderrLimit}
+	cmd.Stderr = stderr
+	cmd.Env = h.environ(ev)
+	// Don't wait on output pipes held open by children of a killed command
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && ctx.Err() == nil {
+		return false, fmt.Errorf("%w: %s exited with status %d: %s",
+			ErrContentRejected, h.command[0], exitErr.ExitCode(), strings.TrimSpace(stderr.String()))
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to run %s: %w", h.command[0], err)
+	}
+	return true, nil
+}
+
+// cappedBuffer keeps the first limit bytes written to it
+type cappedBuffer struct {
+	limit int
+	buf   []byte
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - len(b.buf); room > 0 {
+		b.buf = append(b.buf, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
+
+func (b *cappedBuffer) String() string {
+	return string(b.buf)
+}
@@ -0,0 +1,116 @@
+// Package hooks runs content processing hooks around file operations: Go
+// hooks compiled in with Register, external commands and HTTP services.
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stage is the point of a file operation a hook runs at
+type Stage string
+
+const (
+	StagePreWrite  Stage = "pre_write"  // Before an upload is stored; may rewrite or refuse it
+	StagePostWrite Stage = "post_write" // After an upload is stored, in the background
+	StagePreRead   Stage = "pre_read"   // Before a download is sent; may rewrite or refuse it
+)
+
+// Failure policies of a rule
+const (
+	OnFailureReject = "reject" // Fail the file operation
+	OnFailureIgnore = "ignore" // Log the failure and carry on with the content as it was
+)
+
+// DefaultTimeout bounds a hook run, unless its rule sets otherwise
+const DefaultTimeout = 30 * time.Second
+
+// ErrContentRejected is returned by hooks that refuse content, such as a
+// command exiting non-zero or a service answering 4xx
+var ErrContentRejected = errors.New("content rejected by hook")
+
+// ErrHookFailed is returned for hooks that could not process content
+var ErrHookFailed = errors.New("content hook failed")
+
+// Event describes the file a hook runs for
+type Event struct {
+	Stage Stage
+	Path  string
+	Size  int64 // Bytes of content the hook is given
+}
+
+// Hook processes the content of a file
+type Hook interface {
+	// Process reads content and may write content to replace it to out,
+	// reporting whether it did. Replacements are kept only when the rule
+	// transforms content. Errors wrapping ErrContentRejected refuse the
+	// content; any other error is a failure of the hook.
+	Process(ctx context.Context, ev Event, content io.Reader, out io.Writer) (bool, error)
+}
+
+// Factory creates a hook compiled into the server from the options of its
+// rule
+type Factory func(options map[string]string) (Hook, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a hook compiled into the server available as type kind in
+// hook rules. It is meant to be called from init functions, and panics when
+// kind is registered twice or names a built-in hook.
+func Register(kind string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if kind == "exec" || kind == "http" {
+		panic("hooks: Register of built-in hook type " + kind)
+	}
+	if _, dup := factories[kind]; dup {
+		panic("hooks: Register called twice for type " + kind)
+	}
+	factories[kind] = factory
+}
+
+// Registered returns the types of the hooks compiled in with Register
+func Registered() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	kinds := make([]string, 0, len(factories))
+	for kind := range factories {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Spec describes the hook of a rule
+type Spec struct {
+	Type    string            // "exec", "http" or a type passed to Register
+	Command []string          // Command line of exec hooks
+	Env     []string          // Environment variables of the server exec hooks also get
+	URL     string            // Endpoint of http hooks
+	Options map[string]string // Options of registered hooks
+}
+
+// New creates the hook described by spec
+func New(spec Spec) (Hook, error) {
+	switch spec.Type {
+	case "exec":
+		return newExecHook(spec.Command, spec.Env)
+	case "http":
+		return newHTTPHook(spec.URL)
+	}
+	factoriesMu.RLock()
+	factory, ok := factories[spec.Type]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown hook type %q", spec.Type)
+	}
+	return factory(spec.Options)
+}
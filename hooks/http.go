@@ -0,0 +1,62 @@
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// httpErrorLimit is how much of a failed response body explains the failure
+const httpErrorLimit = 1024
+
+// httpHook POSTs the content to a service. A 200 answer carries content to
+// replace it, a 204 keeps it, and a 4xx refuses it.
+type httpHook struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPHook(endpoint string) (*httpHook, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("http hooks need an http:// or https:// URL, got %q", endpoint)
+	}
+	return &httpHook{url: endpoint, client: &http.Client{}}, nil
+}
+
+func (h *httpHook) Process(ctx context.Context, ev Event, content io.Reader, out io.Writer) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, io.NopCloser(content))
+	if err != nil {
+		return false, err
+	}
+	req.ContentLength = ev.Size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-CallFS-Hook-Stage", string(ev.Stage))
+	req.Header.Set("X-CallFS-Path", ev.Path)
+	req.Header.Set("X-CallFS-Size", strconv.FormatInt(ev.Size, 10))
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		return false, nil
+	case resp.StatusCode == http.StatusOK:
+		if _, err := io.Copy(out, resp.Body); err != nil {
+			return false, fmt.Errorf("failed to read hook response: %w", err)
+		}
+		return true, nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, httpErrorLimit))
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return false, fmt.Errorf("%w: %s answered %s: %s", ErrContentRejected, h.url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return false, fmt.Errorf("%s answered %s: %s", h.url, resp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,251 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	corelog "github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/metrics"
+)
+
+// Rule binds a hook to the files below some path prefixes at one stage
+type Rule struct {
+	Name      string
+	Stage     Stage
+	Prefixes  []string // Paths the rule applies to; all paths when empty
+	Hook      Hook
+	Timeout   time.Duration // DefaultTimeout when 0
+	OnFailure string        // OnFailureReject when empty; post-write failures are only logged
+	Transform bool          // Keep the content the hook replaces
+}
+
+func (r *Rule) matches(path string) bool {
+	if len(r.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range r.Prefixes {
+		trimmed := strings.TrimSuffix(prefix, "/")
+		if trimmed == "" || path == trimmed || strings.HasPrefix(path, trimmed+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) timeout() time.Duration {
+	if r.Timeout > 0 {
+		return r.Timeout
+	}
+	return DefaultTimeout
+}
+
+// Content is content processed by hooks, spooled to a temporary file that
+// Close removes
+type Content struct {
+	*os.File
+	size int64
+}
+
+// Size returns the length of the content
+func (c *Content) Size() int64 {
+	return c.size
+}
+
+func (c *Content) Close() error {
+	err := c.File.Close()
+	_ = os.Remove(c.Name())
+	return err
+}
+
+// Runner runs the hooks of the rules matching each file operation, in the
+// order of the rules
+type Runner struct {
+	rules      []Rule
+	spoolDir   string
+	background chan struct{} // Bounds the post-write runs in progress
+	logger     *zap.Logger
+}
+
+// NewRunner returns a runner of rules that spools content in spoolDir, or
+// the system's temporary directory when empty, and runs at most
+// postWriteConcurrency post-write hooks at once
+func NewRunner(rules []Rule, spoolDir string, postWriteConcurrency int, logger *zap.Logger) *Runner {
+	return &Runner{
+		rules:      rules,
+		spoolDir:   spoolDir,
+		background: make(chan struct{}, max(postWriteConcurrency, 1)),
+		logger:     logger,
+	}
+}
+
+func (r *Runner) match(stage Stage, path string) []*Rule {
+	if r == nil {
+		return nil
+	}
+	var matched []*Rule
+	for i := range r.rules {
+		if r.rules[i].Stage == stage && r.rules[i].matches(path) {
+			matched = append(matched, &r.rules[i])
+		}
+	}
+	return matched
+}
+
+// Matches reports whether a rule of stage applies to path. A nil runner
+// has no rules.
+func (r *Runner) Matches(stage Stage, path string) bool {
+	return len(r.match(stage, path)) > 0
+}
+
+// Process runs the pre-write or pre-read hooks matching path over content,
+// and returns the content they leave, spooled so it can be sized and read
+// again. It returns nil when no rule matches. Content a hook refuses or fails
+// on returns an error wrapping ErrContentRejected or ErrHookFailed, unless
+// the hook's rule ignores failures.
+func (r *Runner) Process(ctx context.Context, stage Stage, path string, content io.Reader) (*Content, error) {
+	rules := r.match(stage, path)
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	current, err := r.newContent()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(current.File, content); err != nil {
+		current.Close()
+		return nil, err
+	}
+	if err := current.rewind(); err != nil {
+		current.Close()
+		return nil, err
+	}
+
+	for _, rule := range rules {
+		next, err := r.run(ctx, rule, path, current)
+		if err != nil {
+			current.Close()
+			return nil, err
+		}
+		if next != nil {
+			current.Close()
+			current = next
+		}
+		if err := current.rewind(); err != nil {
+			current.Close()
+			return nil, err
+		}
+	}
+	return current, nil
+}
+
+// run runs the hook of rule over current, and returns the content it
+// replaced current with, or nil when current stands
+func (r *Runner) run(ctx context.Context, rule *Rule, path string, current *Content) (*Content, error) {
+	var out io.Writer = io.Discard
+	var next *Content
+	if rule.Transform {
+		var err error
+		if next, err = r.newContent(); err != nil {
+			return nil, err
+		}
+		out = next.File
+	}
+
+	hookCtx, cancel := context.WithTimeout(ctx, rule.timeout())
+	defer cancel()
+	replaced, err := r.invoke(hookCtx, rule, Event{Stage: rule.Stage, Path: path, Size: current.size}, current.File, out)
+	if err == nil && replaced && next != nil {
+		if err = next.rewind(); err == nil {
+			return next, nil
+		}
+	}
+	if next != nil {
+		next.Close()
+	}
+	if err != nil && rule.OnFailure == OnFailureIgnore {
+		corelog.WithContext(ctx, r.logger).Warn("Content hook failed; continuing with the content as it was",
+			zap.String("hook", rule.Name), zap.String("stage", string(rule.Stage)), zap.String("path", path), zap.Error(err))
+		return nil, nil
+	}
+	return nil, err
+}
+
+// invoke calls the hook of rule and records how it went
+func (r *Runner) invoke(ctx context.Context, rule *Rule, ev Event, content io.Reader, out io.Writer) (bool, error) {
+	start := time.Now()
+	replaced, err := rule.Hook.Process(ctx, ev, content, out)
+	result := "ok"
+	switch {
+	case errors.Is(err, ErrContentRejected):
+		result = "rejected"
+		err = fmt.Errorf("hook %s: %w", rule.Name, err)
+	case err != nil:
+		result = "failed"
+		err = fmt.Errorf("%w: %s: %v", ErrHookFailed, rule.Name, err)
+	}
+	metrics.HookDuration.WithLabelValues(rule.Name, string(rule.Stage), result).Observe(time.Since(start).Seconds())
+	return replaced, err
+}
+
+// RunPostWrite runs the post-write hooks matching path in the background,
+// giving each the content open returns along with its size. Failures are
+// logged.
+func (r *Runner) RunPostWrite(ctx context.Context, path string, open func(context.Context) (io.ReadCloser, int64, error)) {
+	rules := r.match(StagePostWrite, path)
+	if len(rules) == 0 {
+		return
+	}
+	// Keep the request's logging fields, not its cancellation
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		r.background <- struct{}{}
+		defer func() { <-r.background }()
+		for _, rule := range rules {
+			r.notify(ctx, rule, path, open)
+		}
+	}()
+}
+
+func (r *Runner) notify(ctx context.Context, rule *Rule, path string, open func(context.Context) (io.ReadCloser, int64, error)) {
+	ctx, cancel := context.WithTimeout(ctx, rule.timeout())
+	defer cancel()
+	logger := corelog.WithContext(ctx, r.logger)
+
+	content, size, err := open(ctx)
+	if err != nil {
+		logger.Warn("Failed to open content for post-write hook",
+			zap.String("hook", rule.Name), zap.String("path", path), zap.Error(err))
+		return
+	}
+	defer content.Close()
+	if _, err := r.invoke(ctx, rule, Event{Stage: StagePostWrite, Path: path, Size: size}, content, io.Discard); err != nil {
+		logger.Warn("Post-write hook failed",
+			zap.String("hook", rule.Name), zap.String("path", path), zap.Error(err))
+	}
+}
+
+func (r *Runner) newContent() (*Content, error) {
+	file, err := os.CreateTemp(r.spoolDir, "callfs-hook-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create hook spool file: %w", err)
+	}
+	return &Content{File: file}, nil
+}
+
+// rewind records the size of content written and moves back to its start
+func (c *Content) rewind() error {
+	info, err := c.Stat()
+	if err != nil {
+		return err
+	}
+	c.size = info.Size()
+	_, err = c.Seek(0, io.SeekStart)
+	return err
+}
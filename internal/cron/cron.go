@@ -0,0 +1,204 @@
+// Package cron parses the schedules of background jobs: five-field cron
+// expressions, and the @every, @hourly, @daily, @weekly and @monthly
+// shorthands.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule tells when a job runs
+type Schedule interface {
+	// Next returns the first run after t, or the zero time if there is none
+	Next(t time.Time) time.Time
+}
+
+// shorthands are the cron expressions the @ names stand for
+var shorthands = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+var monthNames = map[string]uint{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dayNames = map[string]uint{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// bounds are the values a field accepts
+type bounds struct {
+	name     string
+	min, max uint
+	names    map[string]uint
+}
+
+var fields = [5]bounds{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: monthNames},
+	{name: "day of week", min: 0, max: 7, names: dayNames}, // 0 and 7 are Sunday
+}
+
+// Parse parses spec: a cron expression of minute, hour, day of month, month
+// and day of week, such as "*/15 2-6 * * mon-fri", a shorthand such as
+// "@daily", or "@every" and a duration, such as "@every 10m". Expressions are
+// evaluated in the time zone of the times passed to Next.
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if rest, ok := strings.CutPrefix(spec, "@every "); ok {
+		interval, err := time.ParseDuration(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("invalid @every interval: %w", err)
+		}
+		if interval < time.Second {
+			return nil, fmt.Errorf("@every interval must be at least 1s")
+		}
+		return every(interval), nil
+	}
+	if expr, ok := shorthands[spec]; ok {
+		spec = expr
+	}
+
+	parts := strings.Fields(spec)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields: minute, hour, day of month, month and day of week", spec)
+	}
+	var sets [5]uint64
+	for i, part := range parts {
+		set, err := parseField(strings.ToLower(part), fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %w", spec, err)
+		}
+		sets[i] = set
+	}
+	// Sunday is both 0 and 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] = sets[4]&^(1<<7) | 1
+	}
+
+	e := &expression{
+		minute:     sets[0],
+		hour:       sets[1],
+		dom:        sets[2],
+		month:      sets[3],
+		dow:        sets[4],
+		anyDay:     strings.HasPrefix(parts[2], "*"),
+		anyWeekday: strings.HasPrefix(parts[4], "*"),
+	}
+	if e.Next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return nil, fmt.Errorf("cron expression %q never matches", spec)
+	}
+	return e, nil
+}
+
+// parseField parses one field of a cron expression into the set of values it
+// matches, bit v standing for value v
+func parseField(field string, b bounds) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		span, stepText, hasStep := strings.Cut(item, "/")
+		step := uint64(1)
+		if hasStep {
+			var err error
+			step, err = strconv.ParseUint(stepText, 10, 8)
+			if err != nil || step == 0 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepText, b.name)
+			}
+		}
+
+		low, high := b.min, b.max
+		if span != "*" {
+			lowText, highText, isRange := strings.Cut(span, "-")
+			var err error
+			if low, err = parseValue(lowText, b); err != nil {
+				return 0, err
+			}
+			switch {
+			case isRange:
+				if high, err = parseValue(highText, b); err != nil {
+					return 0, err
+				}
+			case !hasStep:
+				high = low // "5/15" runs from 5 to the end, "5" only at 5
+			}
+			if low > high {
+				return 0, fmt.Errorf("invalid range %q in %s field", span, b.name)
+			}
+		}
+		for v := low; v <= high; v += uint(step) {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// parseValue parses a number or name within b
+func parseValue(text string, b bounds) (uint, error) {
+	if v, ok := b.names[text]; ok {
+		return v, nil
+	}
+	v, err := strconv.ParseUint(text, 10, 8)
+	if err != nil || uint(v) < b.min || uint(v) > b.max {
+		return 0, fmt.Errorf("invalid %s %q: must be %d to %d", b.name, text, b.min, b.max)
+	}
+	return uint(v), nil
+}
+
+// expression is a parsed cron expression
+type expression struct {
+	minute, hour, dom, month, dow uint64
+	// Fields starting with "*"; when both day fields are restricted, a day
+	// matching either runs, as in cron
+	anyDay, anyWeekday bool
+}
+
+// Next implements Schedule. Whole months, days and hours that cannot match
+// are skipped, and none matching within five years means there is none.
+func (e *expression) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case e.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !e.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case e.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case e.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches reports whether the day of t matches the day fields
+func (e *expression) dayMatches(t time.Time) bool {
+	dom := e.dom&(1<<uint(t.Day())) != 0
+	dow := e.dow&(1<<uint(t.Weekday())) != 0
+	if e.anyDay || e.anyWeekday {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// every runs at a fixed interval from the previous run
+type every time.Duration
+
+// Next implements Schedule
+func (d every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(d))
+}
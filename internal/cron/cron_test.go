@@ -0,0 +1,116 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2025, 1, 15, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		spec     string
+		expected time.Time
+	}{
+		{
+			name:     "every minute",
+			spec:     "* * * * *",
+			expected: time.Date(2025, 1, 15, 10, 8, 0, 0, time.UTC),
+		},
+		{
+			name:     "minute step",
+			spec:     "*/15 * * * *",
+			expected: time.Date(2025, 1, 15, 10, 15, 0, 0, time.UTC),
+		},
+		{
+			name:     "hour range",
+			spec:     "30 2-4 * * *",
+			expected: time.Date(2025, 1, 16, 2, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "list",
+			spec:     "0 9,12,18 * * *",
+			expected: time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "step from a start",
+			spec:     "5/20 * * * *",
+			expected: time.Date(2025, 1, 15, 10, 25, 0, 0, time.UTC),
+		},
+		{
+			name:     "weekday names",
+			spec:     "0 3 * * sat,sun",
+			expected: time.Date(2025, 1, 18, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "sunday as 7",
+			spec:     "0 3 * * 7",
+			expected: time.Date(2025, 1, 19, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "day of month or day of week",
+			spec:     "0 0 1 * fri",
+			expected: time.Date(2025, 1, 17, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "month name",
+			spec:     "0 0 1 mar *",
+			expected: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "leap day",
+			spec:     "0 0 29 2 *",
+			expected: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "daily",
+			spec:     "@daily",
+			expected: time.Date(2025, 1, 16, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "every interval",
+			spec:     "@every 90s",
+			expected: time.Date(2025, 1, 15, 10, 9, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %v", tt.spec, err)
+			}
+			if next := schedule.Next(from); !next.Equal(tt.expected) {
+				t.Errorf("Next() = %v, expected %v", next, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	specs := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"1, * * * *",
+		"* * * foo *",
+		"0 0 30 2 *",
+		"@every 10ms",
+		"@every soon",
+		"@yearly",
+	}
+
+	for _, spec := range specs {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded, expected an error", spec)
+		}
+	}
+}
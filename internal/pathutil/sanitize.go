@@ -115,6 +115,25 @@ func SafeJoin(root, rel string) (string, error) {
 	return resolved, nil
 }
 
+// SafeJoinNoFollow is SafeJoin for operating on a symlink itself: symlinks
+// in the parent directories are resolved and checked, but one in the last
+// component is not followed.
+func SafeJoinNoFollow(root, rel string) (string, error) {
+	cleanRel, err := Clean(rel)
+	if err != nil {
+		return "", err
+	}
+	if cleanRel == "/" {
+		return SafeJoin(root, rel)
+	}
+
+	dir, err := SafeJoin(root, strings.TrimPrefix(filepath.Dir(cleanRel), "/"))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(cleanRel)), nil
+}
+
 // ValidatePath performs comprehensive path validation for security.
 // It checks for common attack patterns and ensures the path is safe to use.
 func ValidatePath(path string) error {
@@ -1,6 +1,8 @@
 package pathutil
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/ebogdum/callfs/metadata"
@@ -145,6 +147,33 @@ func TestSafeJoin(t *testing.T) {
 	}
 }
 
+func TestSafeJoinNoFollow(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Symlink("/etc/passwd", filepath.Join(root, "link")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if err := os.Symlink("/etc", filepath.Join(root, "dir")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The link itself is named, not its target outside the root
+	result, err := SafeJoinNoFollow(root, "link")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := filepath.Join(root, "link"); result != want {
+		t.Errorf("got %q, want %q", result, want)
+	}
+	if _, err := SafeJoin(root, "link"); err == nil {
+		t.Errorf("SafeJoin followed a symlink out of the root")
+	}
+
+	// A symlinked parent is still resolved and rejected
+	if _, err := SafeJoinNoFollow(root, "dir/passwd"); err == nil {
+		t.Errorf("expected error for a parent symlink escaping the root")
+	}
+}
+
 func TestValidatePath(t *testing.T) {
 	tests := []struct {
 		name        string
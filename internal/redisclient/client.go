@@ -0,0 +1,154 @@
+// Package redisclient builds Redis clients for standalone, Sentinel and
+// Cluster deployments from a single set of options.
+package redisclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Deployment modes
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
+// Options describes how to reach a Redis deployment
+type Options struct {
+	Mode string // standalone (default), sentinel or cluster
+
+	// Addrs is the server address for standalone mode, the Sentinel
+	// addresses for sentinel mode, or cluster seed nodes for cluster mode
+	Addrs      []string
+	MasterName string // Sentinel master name
+
+	Username         string // ACL username (Redis 6+)
+	Password         string
+	SentinelPassword string
+	DB               int // Not supported in cluster mode
+
+	TLS           bool
+	TLSCAFile     string // PEM bundle to verify the server; system roots when empty
+	TLSSkipVerify bool
+
+	PoolSize     int
+	MinIdleConns int
+}
+
+// Validate reports configuration errors before any connection is attempted
+func (o Options) Validate() error {
+	if len(o.Addrs) == 0 {
+		return fmt.Errorf("at least one redis address is required")
+	}
+	switch strings.ToLower(o.Mode) {
+	case "", ModeStandalone:
+		if len(o.Addrs) > 1 {
+			return fmt.Errorf("standalone mode takes a single address; use sentinel or cluster mode for several")
+		}
+	case ModeSentinel:
+		if o.MasterName == "" {
+			return fmt.Errorf("sentinel mode requires a master name")
+		}
+	case ModeCluster:
+		if o.DB != 0 {
+			return fmt.Errorf("redis cluster only supports database 0")
+		}
+	default:
+		return fmt.Errorf("redis mode must be one of: standalone, sentinel, cluster")
+	}
+	return nil
+}
+
+// New returns a client for the configured deployment. The connection is not
+// checked; callers Ping it.
+func New(o Options) (redis.UniversalClient, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	var tlsConfig *tls.Config
+	if o.TLS {
+		tlsConfig = &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: o.TLSSkipVerify,
+		}
+		if o.TLSCAFile != "" {
+			pem, err := os.ReadFile(o.TLSCAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read redis CA file: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in redis CA file %s", o.TLSCAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+
+	switch strings.ToLower(o.Mode) {
+	case ModeSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       o.MasterName,
+			SentinelAddrs:    o.Addrs,
+			SentinelPassword: o.SentinelPassword,
+			Username:         o.Username,
+			Password:         o.Password,
+			DB:               o.DB,
+			TLSConfig:        tlsConfig,
+			PoolSize:         o.PoolSize,
+			MinIdleConns:     o.MinIdleConns,
+		}), nil
+	case ModeCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        o.Addrs,
+			Username:     o.Username,
+			Password:     o.Password,
+			TLSConfig:    tlsConfig,
+			PoolSize:     o.PoolSize,
+			MinIdleConns: o.MinIdleConns,
+		}), nil
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr:         o.Addrs[0],
+			Username:     o.Username,
+			Password:     o.Password,
+			DB:           o.DB,
+			TLSConfig:    tlsConfig,
+			PoolSize:     o.PoolSize,
+			MinIdleConns: o.MinIdleConns,
+		}), nil
+	}
+}
+
+// ScanKeys calls fn for every key matching pattern. In cluster mode each
+// master is scanned; fn is never called concurrently.
+func ScanKeys(ctx context.Context, client redis.UniversalClient, pattern string, fn func(key string) error) error {
+	scan := func(ctx context.Context, c redis.Cmdable) error {
+		iter := c.Scan(ctx, 0, pattern, 0).Iterator()
+		for iter.Next(ctx) {
+			if err := fn(iter.Val()); err != nil {
+				return err
+			}
+		}
+		return iter.Err()
+	}
+
+	cluster, ok := client.(*redis.ClusterClient)
+	if !ok {
+		return scan(ctx, client)
+	}
+	var mu sync.Mutex
+	return cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return scan(ctx, master)
+	})
+}
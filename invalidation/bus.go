@@ -0,0 +1,32 @@
+// Package invalidation broadcasts metadata cache invalidations between CallFS
+// instances so that a write on one node evicts stale entries on every node.
+package invalidation
+
+import (
+	"context"
+)
+
+// DefaultChannel is the Postgres NOTIFY / Redis pub/sub channel used when none is configured
+const DefaultChannel = "callfs_cache_invalidation"
+
+// Message describes a cache invalidation published by one instance
+type Message struct {
+	Origin string `json:"origin"`           // Instance ID of the publisher
+	Path   string `json:"path,omitempty"`   // Path to invalidate
+	Prefix bool   `json:"prefix,omitempty"` // Also invalidate everything below Path
+	All    bool   `json:"all,omitempty"`    // Invalidate the whole cache
+}
+
+// Bus defines the interface for cross-instance cache invalidation
+type Bus interface {
+	// Publish sends msg to every subscribed instance, including the publisher
+	Publish(ctx context.Context, msg Message) error
+
+	// Subscribe starts delivering messages to handler in a background
+	// goroutine until ctx is cancelled or the bus is closed. When delivery may
+	// have been interrupted, handler receives a Message with All set.
+	Subscribe(ctx context.Context, handler func(Message)) error
+
+	// Close stops the subscription and releases any resources
+	Close() error
+}
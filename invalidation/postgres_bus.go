@@ -0,0 +1,113 @@
+package invalidation
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// PostgresBus implements Bus using PostgreSQL LISTEN/NOTIFY
+type PostgresBus struct {
+	db       *sql.DB
+	listener *pq.Listener
+	channel  string
+	logger   *zap.Logger
+}
+
+// NewPostgresBus creates a Postgres-backed invalidation bus on channel
+func NewPostgresBus(dsn, channel string, logger *zap.Logger) (*PostgresBus, error) {
+	if channel == "" {
+		channel = DefaultChannel
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	db.SetMaxOpenConns(2)
+
+	if err := db.PingContext(context.Background()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.Warn("Cache invalidation listener event", zap.Int("event", int(event)), zap.Error(err))
+		}
+	})
+
+	return &PostgresBus{
+		db:       db,
+		listener: listener,
+		channel:  channel,
+		logger:   logger,
+	}, nil
+}
+
+// Publish sends msg with NOTIFY
+func (b *PostgresBus) Publish(ctx context.Context, msg Message) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode invalidation: %w", err)
+	}
+	if _, err := b.db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, b.channel, string(payload)); err != nil {
+		return fmt.Errorf("failed to publish invalidation: %w", err)
+	}
+	return nil
+}
+
+// Subscribe LISTENs on the channel and delivers notifications to handler
+func (b *PostgresBus) Subscribe(ctx context.Context, handler func(Message)) error {
+	if err := b.listener.Listen(b.channel); err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", b.channel, err)
+	}
+
+	go func() {
+		ping := time.NewTicker(90 * time.Second)
+		defer ping.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n, ok := <-b.listener.Notify:
+				if !ok {
+					return // listener closed
+				}
+				if n == nil {
+					// The connection was re-established; notifications sent in
+					// the meantime are lost
+					handler(Message{All: true})
+					continue
+				}
+				var msg Message
+				if err := json.Unmarshal([]byte(n.Extra), &msg); err != nil {
+					b.logger.Warn("Ignoring malformed cache invalidation", zap.Error(err))
+					continue
+				}
+				handler(msg)
+			case <-ping.C:
+				if err := b.listener.Ping(); err != nil {
+					b.logger.Debug("Cache invalidation listener ping failed", zap.Error(err))
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close stops listening and closes the database connections
+func (b *PostgresBus) Close() error {
+	listenErr := b.listener.Close()
+	if err := b.db.Close(); err != nil {
+		return err
+	}
+	return listenErr
+}
@@ -0,0 +1,101 @@
+package invalidation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/internal/redisclient"
+)
+
+// RedisBus implements Bus using Redis pub/sub
+type RedisBus struct {
+	client  redis.UniversalClient
+	pubsub  *redis.PubSub
+	channel string
+	logger  *zap.Logger
+}
+
+// NewRedisBus creates a Redis-backed invalidation bus on channel
+func NewRedisBus(conn redisclient.Options, channel string, logger *zap.Logger) (*RedisBus, error) {
+	if channel == "" {
+		channel = DefaultChannel
+	}
+
+	client, err := redisclient.New(conn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis invalidation configuration: %w", err)
+	}
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	return &RedisBus{client: client, channel: channel, logger: logger}, nil
+}
+
+// Publish sends msg with PUBLISH
+func (b *RedisBus) Publish(ctx context.Context, msg Message) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode invalidation: %w", err)
+	}
+	if err := b.client.Publish(ctx, b.channel, payload).Err(); err != nil {
+		return fmt.Errorf("failed to publish invalidation: %w", err)
+	}
+	return nil
+}
+
+// Subscribe SUBSCRIBEs to the channel and delivers messages to handler
+func (b *RedisBus) Subscribe(ctx context.Context, handler func(Message)) error {
+	b.pubsub = b.client.Subscribe(ctx, b.channel)
+	if _, err := b.pubsub.Receive(ctx); err != nil {
+		_ = b.pubsub.Close()
+		return fmt.Errorf("failed to subscribe to %s: %w", b.channel, err)
+	}
+
+	go func() {
+		// Subscription confirmations arrive along with the messages; after
+		// the first, received above, each one means the connection was
+		// re-established
+		ch := b.pubsub.ChannelWithSubscriptions(ctx, 100)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case received, ok := <-ch:
+				if !ok {
+					return // pubsub closed
+				}
+				switch m := received.(type) {
+				case *redis.Subscription:
+					if m.Kind == "subscribe" {
+						// Messages published in the meantime are lost
+						b.logger.Info("Cache invalidation subscription re-established; invalidating the whole cache")
+						handler(Message{All: true})
+					}
+				case *redis.Message:
+					var msg Message
+					if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil {
+						b.logger.Warn("Ignoring malformed cache invalidation", zap.Error(err))
+						continue
+					}
+					handler(msg)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close unsubscribes and closes the Redis client
+func (b *RedisBus) Close() error {
+	if b.pubsub != nil {
+		_ = b.pubsub.Close()
+	}
+	return b.client.Close()
+}
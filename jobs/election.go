@@ -0,0 +1,121 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/locks"
+)
+
+// leaderLockKey is the lease held by the instance running the leader-only jobs
+const leaderLockKey = "jobs:leader"
+
+// raftPollInterval is how often a raft node checks whether it leads. It is
+// below raft's leader lease timeout, so a deposed leader stops its jobs about
+// when another node can be elected.
+const raftPollInterval = 250 * time.Millisecond
+
+// Elector elects the instance that runs the leader-only jobs
+type Elector interface {
+	// Campaign waits until this instance is elected, or ctx is canceled. The
+	// context returned is canceled when the instance stops leading.
+	Campaign(ctx context.Context) (context.Context, error)
+}
+
+// LeaseElector elects the instance holding a lease in the lock manager. The
+// leader renews the lease while it lives; when it stops, the lease expires
+// within a lock TTL and another instance takes it.
+type LeaseElector struct {
+	lockManager locks.Manager
+	logger      *zap.Logger
+}
+
+// NewLeaseElector creates an elector taking its lease from lockManager, which
+// must be shared by the instances, as the redis and redlock ones are
+func NewLeaseElector(lockManager locks.Manager, logger *zap.Logger) *LeaseElector {
+	return &LeaseElector{lockManager: lockManager, logger: logger}
+}
+
+// Campaign implements Elector. The lease is released when ctx is canceled, so
+// another instance takes over at once on shutdown.
+func (e *LeaseElector) Campaign(ctx context.Context) (context.Context, error) {
+	retry := e.lockManager.TTL() / 3
+	for {
+		token, acquired, err := e.lockManager.Acquire(ctx, leaderLockKey)
+		if err != nil && ctx.Err() == nil {
+			e.logger.Warn("Failed to acquire jobs leader lease", zap.Error(err))
+		}
+		if acquired {
+			leaderCtx, stopRenewal := locks.KeepAlive(ctx, e.lockManager, leaderLockKey, token, e.logger)
+			go func() {
+				<-leaderCtx.Done()
+				stopRenewal()
+				if ctx.Err() == nil {
+					return // Lost to another instance
+				}
+				releaseCtx, cancel := context.WithTimeout(context.Background(), retry)
+				defer cancel()
+				if err := e.lockManager.Release(releaseCtx, leaderLockKey, token); err != nil {
+					e.logger.Warn("Failed to release jobs leader lease", zap.Error(err))
+				}
+			}()
+			return leaderCtx, nil
+		}
+
+		select {
+		case <-time.After(retry):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// raftLeader is implemented by the raft metadata store
+type raftLeader interface {
+	IsLeader() bool
+}
+
+// RaftElector elects the raft leader of the metadata store, so no lock
+// manager needs to be shared. Nodes that run no jobs must hand raft
+// leadership over when elected, as the raft store's RestrictLeadership does.
+type RaftElector struct {
+	store raftLeader
+}
+
+// NewRaftElector creates an elector following the leadership of store
+func NewRaftElector(store raftLeader) *RaftElector {
+	return &RaftElector{store: store}
+}
+
+// Campaign implements Elector
+func (e *RaftElector) Campaign(ctx context.Context) (context.Context, error) {
+	ticker := time.NewTicker(raftPollInterval)
+	defer ticker.Stop()
+	for !e.store.IsLeader() {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer cancel()
+		ticker := time.NewTicker(raftPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !e.store.IsLeader() {
+					return
+				}
+			case <-leaderCtx.Done():
+				return
+			}
+		}
+	}()
+	return leaderCtx, nil
+}
@@ -0,0 +1,251 @@
+// Package jobs runs background jobs, such as link cleanup and garbage
+// collection, on cron schedules.
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/internal/cron"
+	"github.com/ebogdum/callfs/metrics"
+)
+
+// Results of job runs
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+	ResultSkipped = "skipped" // A leader-only job on an instance that is not the leader
+)
+
+// Job is a background job run on a schedule
+type Job struct {
+	Name       string
+	Schedule   string        // Cron expression or shorthand, as accepted by cron.Parse
+	Jitter     time.Duration // Up to this random delay before each run, so instances don't all run at once
+	LeaderOnly bool          // Run only on the instance elected jobs leader
+	Timeout    time.Duration // Longest a run may take; 0 for no limit
+	Run        func(ctx context.Context) error
+}
+
+// RunResult is the outcome of one run of a job
+type RunResult struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Result     string    `json:"result"` // "success", "failure" or "skipped"
+	Error      string    `json:"error,omitempty"`
+}
+
+// Status is the state of a registered job on this instance
+type Status struct {
+	Name        string     `json:"name"`
+	Schedule    string     `json:"schedule"`
+	LeaderOnly  bool       `json:"leader_only"`
+	Running     bool       `json:"running"`
+	NextRun     *time.Time `json:"next_run,omitempty"`
+	LastRun     *RunResult `json:"last_run,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+}
+
+// Scheduler runs registered jobs on their schedules. Leader-only jobs run on
+// one instance at a time: the one its elector elects.
+type Scheduler struct {
+	elector Elector
+	logger  *zap.Logger
+
+	mu        sync.Mutex
+	jobs      []*scheduledJob
+	leaderCtx context.Context // Canceled once leadership is lost; nil on followers
+}
+
+// scheduledJob is a registered job and its state, guarded by Scheduler.mu
+type scheduledJob struct {
+	Job
+	schedule    cron.Schedule
+	running     bool
+	nextRun     time.Time
+	lastRun     *RunResult
+	lastSuccess time.Time
+}
+
+// NewScheduler creates a scheduler whose leader-only jobs run while elector
+// has elected this instance
+func NewScheduler(elector Elector, logger *zap.Logger) *Scheduler {
+	return &Scheduler{elector: elector, logger: logger}
+}
+
+// Register adds job, run once Start is called
+func (s *Scheduler) Register(job Job) error {
+	schedule, err := cron.Parse(job.Schedule)
+	if err != nil {
+		return fmt.Errorf("invalid schedule of job %s: %w", job.Name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, registered := range s.jobs {
+		if registered.Name == job.Name {
+			return fmt.Errorf("job %s is already registered", job.Name)
+		}
+	}
+	s.jobs = append(s.jobs, &scheduledJob{Job: job, schedule: schedule})
+	return nil
+}
+
+// Start runs the registered jobs until ctx is canceled
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	leaderOnly := false
+	for _, job := range s.jobs {
+		leaderOnly = leaderOnly || job.LeaderOnly
+		go s.loop(ctx, job)
+	}
+	if leaderOnly {
+		go s.lead(ctx)
+	}
+}
+
+// Leader reports whether this instance runs the leader-only jobs
+func (s *Scheduler) Leader() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.leaderCtx != nil && s.leaderCtx.Err() == nil
+}
+
+// Status returns the state of the registered jobs
+func (s *Scheduler) Status() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]Status, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		status := Status{
+			Name:       job.Name,
+			Schedule:   job.Job.Schedule,
+			LeaderOnly: job.LeaderOnly,
+			Running:    job.running,
+		}
+		if !job.nextRun.IsZero() {
+			nextRun := job.nextRun
+			status.NextRun = &nextRun
+		}
+		if job.lastRun != nil {
+			lastRun := *job.lastRun
+			status.LastRun = &lastRun
+		}
+		if !job.lastSuccess.IsZero() {
+			lastSuccess := job.lastSuccess
+			status.LastSuccess = &lastSuccess
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// loop runs job at each of its scheduled times until ctx is canceled
+func (s *Scheduler) loop(ctx context.Context, job *scheduledJob) {
+	s.logger.Info("Scheduling background job",
+		zap.String("job", job.Name),
+		zap.String("schedule", job.Job.Schedule),
+		zap.Duration("jitter", job.Jitter),
+		zap.Bool("leader_only", job.LeaderOnly))
+
+	for {
+		next := job.schedule.Next(time.Now())
+		if next.IsZero() {
+			return
+		}
+		s.mu.Lock()
+		job.nextRun = next.UTC()
+		s.mu.Unlock()
+
+		delay := time.Until(next)
+		if job.Jitter > 0 {
+			delay += rand.N(job.Jitter)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		s.run(ctx, job)
+	}
+}
+
+// run runs job once and records the outcome
+func (s *Scheduler) run(ctx context.Context, job *scheduledJob) {
+	logger := s.logger.With(zap.String("job", job.Name))
+	result := &RunResult{StartedAt: time.Now().UTC(), Result: ResultSuccess}
+
+	s.mu.Lock()
+	job.running = true
+	job.nextRun = time.Time{}
+	runCtx := ctx
+	if job.LeaderOnly {
+		// The run stops if leadership is lost, so two instances never run it
+		runCtx = s.leaderCtx
+	}
+	s.mu.Unlock()
+
+	if job.LeaderOnly && (runCtx == nil || runCtx.Err() != nil) {
+		result.Result = ResultSkipped
+		logger.Debug("Skipped leader-only job on a follower")
+	} else {
+		if job.Timeout > 0 {
+			var cancel context.CancelFunc
+			runCtx, cancel = context.WithTimeout(runCtx, job.Timeout)
+			defer cancel()
+		}
+		if err := job.Run(runCtx); err != nil {
+			result.Result = ResultFailure
+			result.Error = err.Error()
+			logger.Error("Background job failed", zap.Error(err))
+		}
+	}
+	result.FinishedAt = time.Now().UTC()
+
+	metrics.JobRunsTotal.WithLabelValues(job.Name, result.Result).Inc()
+	if result.Result != ResultSkipped {
+		metrics.JobDuration.WithLabelValues(job.Name).Observe(result.FinishedAt.Sub(result.StartedAt).Seconds())
+	}
+	if result.Result == ResultSuccess {
+		metrics.JobLastSuccess.WithLabelValues(job.Name).Set(float64(result.FinishedAt.Unix()))
+	}
+
+	s.mu.Lock()
+	job.running = false
+	job.lastRun = result
+	if result.Result == ResultSuccess {
+		job.lastSuccess = result.FinishedAt
+	}
+	s.mu.Unlock()
+}
+
+// lead campaigns for leadership, and again each time it is lost, until ctx
+// is canceled
+func (s *Scheduler) lead(ctx context.Context) {
+	for {
+		leaderCtx, err := s.elector.Campaign(ctx)
+		if err != nil {
+			return
+		}
+		s.logger.Info("Elected jobs leader; running leader-only background jobs")
+		s.mu.Lock()
+		s.leaderCtx = leaderCtx
+		s.mu.Unlock()
+
+		<-leaderCtx.Done()
+		if ctx.Err() != nil {
+			return
+		}
+		s.logger.Warn("Lost jobs leadership; leader-only background jobs stopped")
+	}
+}
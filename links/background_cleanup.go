@@ -2,6 +2,7 @@ package links
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -9,56 +10,23 @@ import (
 	"go.uber.org/zap"
 )
 
-// StartCleanupWorker starts a background goroutine that periodically cleans up
-// expired and used single-use links from the metadata store.
-func StartCleanupWorker(ctx context.Context, metadataStore metadata.Store, interval time.Duration, logger *zap.Logger) {
-	if metadataStore == nil {
-		logger.Error("Cannot start cleanup worker: metadata store is nil")
-		return
-	}
-
-	go func() {
-		logger.Info("Starting single-use link cleanup worker",
-			zap.Duration("interval", interval))
-
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				cleanupLinks(ctx, metadataStore, logger)
-			case <-ctx.Done():
-				logger.Info("Cleanup worker shutting down")
-				return
-			}
-		}
-	}()
-}
-
-// cleanupLinks removes expired and used single-use links from the metadata store.
-// Uses the parent context so shutdown signals are respected.
-func cleanupLinks(parentCtx context.Context, metadataStore metadata.Store, logger *zap.Logger) {
-	ctx, cancel := context.WithTimeout(parentCtx, 30*time.Second)
-	defer cancel()
-
+// CleanupLinks removes expired single-use links, and used ones older than a
+// day, from the metadata store. It is run by the scheduled link_cleanup job.
+func CleanupLinks(ctx context.Context, metadataStore metadata.Store, logger *zap.Logger) error {
 	// Clean up expired active links
-	expiredCount, err := cleanupExpiredLinks(ctx, metadataStore, logger)
-	if err != nil {
-		logger.Error("Failed to cleanup expired links", zap.Error(err))
-	} else if expiredCount > 0 {
+	expiredCount, expiredErr := cleanupExpiredLinks(ctx, metadataStore, logger)
+	if expiredCount > 0 {
 		logger.Info("Cleaned up expired single-use links",
 			zap.Int("count", expiredCount))
 	}
 
 	// Clean up used links older than 24 hours
-	usedCount, err := cleanupUsedLinks(ctx, metadataStore, logger)
-	if err != nil {
-		logger.Error("Failed to cleanup used links", zap.Error(err))
-	} else if usedCount > 0 {
+	usedCount, usedErr := cleanupUsedLinks(ctx, metadataStore, logger)
+	if usedCount > 0 {
 		logger.Info("Cleaned up used single-use links",
 			zap.Int("count", usedCount))
 	}
+	return errors.Join(expiredErr, usedErr)
 }
 
 // cleanupExpiredLinks removes active links that have expired.
@@ -26,11 +26,14 @@ var (
 type LinkManager struct {
 	metadataStore metadata.Store
 	secretKey     []byte
+	acceptedKeys  [][]byte // Also verify links, during a rotation of the secret
 	logger        *zap.Logger
 }
 
-// NewLinkManager creates a new LinkManager instance.
-func NewLinkManager(ms metadata.Store, secretKey string, logger *zap.Logger) (*LinkManager, error) {
+// NewLinkManager creates a new LinkManager instance. Links are signed with
+// secretKey and also verified with acceptedKeys, so links signed before the
+// secret was rotated stay valid while the previous secret is accepted.
+func NewLinkManager(ms metadata.Store, secretKey string, acceptedKeys []string, logger *zap.Logger) (*LinkManager, error) {
 	if ms == nil {
 		return nil, errors.New("metadata store cannot be nil")
 	}
@@ -41,14 +44,20 @@ func NewLinkManager(ms metadata.Store, secretKey string, logger *zap.Logger) (*L
 		return nil, errors.New("logger cannot be nil")
 	}
 
-	// Hash the secret key for HMAC
+	// Hash the secret keys for HMAC
 	h := sha256.Sum256([]byte(secretKey))
-
-	return &LinkManager{
+	lm := &LinkManager{
 		metadataStore: ms,
 		secretKey:     h[:],
 		logger:        logger,
-	}, nil
+	}
+	for _, key := range acceptedKeys {
+		if key != "" {
+			h := sha256.Sum256([]byte(key))
+			lm.acceptedKeys = append(lm.acceptedKeys, h[:])
+		}
+	}
+	return lm, nil
 }
 
 // GenerateLink creates a new single-use download link for the specified file.
@@ -194,11 +203,15 @@ func (lm *LinkManager) verifySignature(token, filePath string) bool {
 	tokenID := string(parts[:dotIndex])
 	providedSignature := string(parts[dotIndex+1:])
 
-	// Compute expected signature
-	mac := hmac.New(sha256.New, lm.secretKey)
-	mac.Write([]byte(tokenID + filePath))
-	expectedSignature := base64.URLEncoding.EncodeToString(mac.Sum(nil))
-
-	// Use constant-time comparison
-	return hmac.Equal([]byte(providedSignature), []byte(expectedSignature))
+	// Compute the expected signature under each key, comparing in constant time
+	valid := false
+	for _, key := range append([][]byte{lm.secretKey}, lm.acceptedKeys...) {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(tokenID + filePath))
+		expectedSignature := base64.URLEncoding.EncodeToString(mac.Sum(nil))
+		if hmac.Equal([]byte(providedSignature), []byte(expectedSignature)) {
+			valid = true
+		}
+	}
+	return valid
 }
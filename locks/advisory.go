@@ -0,0 +1,84 @@
+package locks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Advisory lock modes. Any number of read locks may overlap; a write lock
+// excludes every other lock on the bytes it covers.
+const (
+	AdvisoryRead  = "read"
+	AdvisoryWrite = "write"
+)
+
+var (
+	// ErrAdvisoryConflict is returned when a lock overlaps one held by another owner
+	ErrAdvisoryConflict = errors.New("conflicting advisory lock is held")
+
+	// ErrAdvisoryLockNotFound is returned for a lock that was released or expired
+	ErrAdvisoryLockNotFound = errors.New("advisory lock not found")
+)
+
+// AdvisoryLock is a lease on a file, or on a byte range of it, that
+// cooperating clients check before accessing it. Like fcntl locks they bind
+// only clients that ask for them.
+type AdvisoryLock struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`
+	Owner     string    `json:"owner"`
+	Mode      string    `json:"mode"`   // read or write
+	Start     int64     `json:"start"`  // First byte covered
+	Length    int64     `json:"length"` // Bytes covered; 0 covers up to any end of file
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// overlaps reports whether two locks cover a common byte
+func (l *AdvisoryLock) overlaps(o *AdvisoryLock) bool {
+	return (o.Length == 0 || l.Start < o.Start+o.Length) && (l.Length == 0 || o.Start < l.Start+l.Length)
+}
+
+// conflicts reports whether l cannot be held together with o
+func (l *AdvisoryLock) conflicts(o *AdvisoryLock) bool {
+	return l.Owner != o.Owner && (l.Mode == AdvisoryWrite || o.Mode == AdvisoryWrite) && l.overlaps(o)
+}
+
+// AdvisoryConflictError names the held lock that blocked an acquisition
+type AdvisoryConflictError struct {
+	Held AdvisoryLock
+}
+
+func (e *AdvisoryConflictError) Error() string {
+	end := "end of file"
+	if e.Held.Length > 0 {
+		end = fmt.Sprintf("byte %d", e.Held.Start+e.Held.Length-1)
+	}
+	return fmt.Sprintf("%s: %s lock held by %s from byte %d to %s until %s",
+		ErrAdvisoryConflict, e.Held.Mode, e.Held.Owner, e.Held.Start, end, e.Held.ExpiresAt.UTC().Format(time.RFC3339))
+}
+
+func (e *AdvisoryConflictError) Unwrap() error {
+	return ErrAdvisoryConflict
+}
+
+// AdvisoryLocker is implemented by lock managers that can hold advisory
+// byte-range locks for clients
+type AdvisoryLocker interface {
+	// LockRange takes lock until its ExpiresAt, or returns an
+	// *AdvisoryConflictError for a lock of another owner that conflicts
+	LockRange(ctx context.Context, lock AdvisoryLock) error
+
+	// AdvisoryLock returns a held lock
+	// Returns ErrAdvisoryLockNotFound if it was released or expired
+	AdvisoryLock(ctx context.Context, path, id string) (*AdvisoryLock, error)
+
+	// RefreshRange moves the expiry of a held lock
+	// Returns ErrAdvisoryLockNotFound if it was released or expired
+	RefreshRange(ctx context.Context, path, id string, expiresAt time.Time) (*AdvisoryLock, error)
+
+	// UnlockRange releases a held lock
+	// Returns ErrAdvisoryLockNotFound if it was released or expired
+	UnlockRange(ctx context.Context, path, id string) error
+}
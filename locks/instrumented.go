@@ -0,0 +1,98 @@
+package locks
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ebogdum/callfs/metrics"
+)
+
+// instrumentedManager records lock metrics around another Manager
+type instrumentedManager struct {
+	Manager
+
+	mu   sync.Mutex
+	held map[string]string // Tokens of the locks this instance holds, by key
+}
+
+// WithMetrics wraps m so acquisitions and releases update the lock operation
+// metrics and the active locks gauge. The gauge counts locks held by this
+// instance: it rises on a successful Acquire and falls once the lock is
+// known to be gone, when it is released, lost, force-released or, after a
+// failed release, has expired.
+func WithMetrics(m Manager) Manager {
+	return &instrumentedManager{Manager: m, held: make(map[string]string)}
+}
+
+// Acquire acquires the lock and records the outcome
+func (m *instrumentedManager) Acquire(ctx context.Context, key string) (string, bool, error) {
+	start := time.Now()
+	token, acquired, err := m.Manager.Acquire(ctx, key)
+	metrics.LockOperationDuration.WithLabelValues("acquire").Observe(time.Since(start).Seconds())
+
+	if err != nil || !acquired {
+		metrics.LockOperationsTotal.WithLabelValues("acquire", "failure").Inc()
+		return token, acquired, err
+	}
+	metrics.LockOperationsTotal.WithLabelValues("acquire", "success").Inc()
+
+	m.mu.Lock()
+	if _, ok := m.held[key]; !ok {
+		// An entry left for the key is of a lock that expired
+		metrics.ActiveLocks.Inc()
+	}
+	m.held[key] = token
+	m.mu.Unlock()
+	return token, true, nil
+}
+
+// Release releases the lock and records the outcome. A lock that failed to
+// be released is still counted until its TTL has run out.
+func (m *instrumentedManager) Release(ctx context.Context, key, token string) error {
+	start := time.Now()
+	err := m.Manager.Release(ctx, key, token)
+	metrics.LockOperationDuration.WithLabelValues("release").Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		metrics.LockOperationsTotal.WithLabelValues("release", "failure").Inc()
+		time.AfterFunc(m.TTL(), func() { m.forget(key, token) })
+		return err
+	}
+	metrics.LockOperationsTotal.WithLabelValues("release", "success").Inc()
+	m.forget(key, token)
+	return nil
+}
+
+// Renew renews the lock, no longer counting it once it is lost
+func (m *instrumentedManager) Renew(ctx context.Context, key, token string) (bool, error) {
+	held, err := m.Manager.Renew(ctx, key, token)
+	if err == nil && !held {
+		m.forget(key, token)
+	}
+	return held, err
+}
+
+// ForceRelease deletes the lock, no longer counting it if this instance held
+// it
+func (m *instrumentedManager) ForceRelease(ctx context.Context, key string) (bool, error) {
+	released, err := m.Manager.ForceRelease(ctx, key)
+	if err == nil {
+		m.mu.Lock()
+		token := m.held[key]
+		m.mu.Unlock()
+		m.forget(key, token)
+	}
+	return released, err
+}
+
+// forget stops counting the lock of key if it is still the acquisition that
+// returned token
+func (m *instrumentedManager) forget(key, token string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if held, ok := m.held[key]; ok && held == token {
+		delete(m.held, key)
+		metrics.ActiveLocks.Dec()
+	}
+}
@@ -5,33 +5,37 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
-const (
-	localLockTTL             = 30 * time.Second
-	localLockCleanupInterval = time.Minute
-)
+const localLockCleanupInterval = time.Minute
 
 type lockEntry struct {
-	expiry  time.Time
-	ownerID string
+	expiry time.Time
+	token  string
 }
 
 // LocalManager provides in-process lock management for local/single-node deployments.
-// Each lock tracks an ownerID to prevent releasing another holder's lock after TTL expiry.
+// Each lock tracks the token of its acquisition to prevent releasing another holder's lock after TTL expiry.
 type LocalManager struct {
 	mu         sync.Mutex
 	locks      map[string]lockEntry
+	advisory   map[string]map[string]AdvisoryLock // Advisory locks by path and ID
+	ttl        time.Duration
 	instanceID string
 	stopChan   chan struct{}
 }
 
-// NewLocalManager creates a new in-memory lock manager.
-func NewLocalManager() *LocalManager {
+// NewLocalManager creates a new in-memory lock manager whose locks expire
+// after ttl unless renewed.
+func NewLocalManager(ttl time.Duration) *LocalManager {
 	m := &LocalManager{
 		locks:      make(map[string]lockEntry),
+		advisory:   make(map[string]map[string]AdvisoryLock),
+		ttl:        ttl,
 		instanceID: mustGenerateID(),
 		stopChan:   make(chan struct{}),
 	}
@@ -40,10 +44,10 @@ func NewLocalManager() *LocalManager {
 }
 
 // Acquire acquires a lock if it is currently free or expired.
-func (m *LocalManager) Acquire(ctx context.Context, key string) (bool, error) {
+func (m *LocalManager) Acquire(ctx context.Context, key string) (string, bool, error) {
 	select {
 	case <-ctx.Done():
-		return false, ctx.Err()
+		return "", false, ctx.Err()
 	default:
 	}
 
@@ -52,39 +56,109 @@ func (m *LocalManager) Acquire(ctx context.Context, key string) (bool, error) {
 
 	if entry, exists := m.locks[key]; exists {
 		if time.Now().Before(entry.expiry) {
-			return false, nil // Lock is still held
+			return "", false, nil // Lock is still held
 		}
 		// Lock expired, allow re-acquisition
 	}
 
-	ownerID, err := generateOwnerID()
+	token, err := newLockToken(m.instanceID)
 	if err != nil {
-		return false, fmt.Errorf("failed to generate lock owner ID: %w", err)
+		return "", false, err
 	}
 
 	m.locks[key] = lockEntry{
-		expiry:  time.Now().Add(localLockTTL),
-		ownerID: ownerID,
+		expiry: time.Now().Add(m.ttl),
+		token:  token,
 	}
-	return true, nil
+	return token, true, nil
 }
 
-// Release releases a previously acquired lock only if it hasn't been re-acquired by another holder.
-func (m *LocalManager) Release(_ context.Context, key string) error {
+// Release releases the acquisition that returned token, unless the lock has
+// been re-acquired since.
+func (m *LocalManager) Release(_ context.Context, key, token string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	entry, exists := m.locks[key]
-	if !exists {
-		return nil // Already released or expired
+	if !exists || entry.token != token {
+		return nil // Already released, or expired and re-acquired
+	}
+
+	delete(m.locks, key)
+	return nil
+}
+
+// Renew extends the acquisition that returned token if it has not yet expired.
+func (m *LocalManager) Renew(_ context.Context, key, token string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, exists := m.locks[key]
+	if !exists || entry.token != token || time.Now().After(entry.expiry) {
+		return false, nil
+	}
+
+	entry.expiry = time.Now().Add(m.ttl)
+	m.locks[key] = entry
+	return true, nil
+}
+
+// TTL returns the lock expiry applied on acquire and renew.
+func (m *LocalManager) TTL() time.Duration {
+	return m.ttl
+}
+
+// List returns the unexpired locks whose keys start with prefix, sorted by
+// key.
+func (m *LocalManager) List(_ context.Context, prefix string) ([]LockInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	out := make([]LockInfo, 0, len(m.locks))
+	for key, entry := range m.locks {
+		if now.After(entry.expiry) || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		out = append(out, LockInfo{
+			Key:   key,
+			Owner: entry.token,
+			TTLMs: entry.expiry.Sub(now).Milliseconds(),
+		})
 	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
 
-	// If the lock has expired, another holder may have re-acquired it. Don't delete.
-	if time.Now().After(entry.expiry) {
-		return nil
+// Held reports whether any of keys is held and unexpired.
+func (m *LocalManager) Held(_ context.Context, keys ...string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, key := range keys {
+		if entry, exists := m.locks[key]; exists && now.Before(entry.expiry) {
+			return true, nil
+		}
 	}
+	return false, nil
+}
 
+// ForceRelease deletes a lock regardless of its owner.
+func (m *LocalManager) ForceRelease(_ context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, exists := m.locks[key]
+	if !exists {
+		return false, nil
+	}
 	delete(m.locks, key)
+	return time.Now().Before(entry.expiry), nil
+}
+
+// Ping always succeeds for the in-process lock manager.
+func (m *LocalManager) Ping(ctx context.Context) error {
 	return nil
 }
 
@@ -94,6 +168,73 @@ func (m *LocalManager) Close() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.locks = make(map[string]lockEntry)
+	m.advisory = make(map[string]map[string]AdvisoryLock)
+	return nil
+}
+
+// LockRange takes an advisory lock unless another owner holds a conflicting one.
+func (m *LocalManager) LockRange(_ context.Context, lock AdvisoryLock) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	held := m.advisory[lock.Path]
+	for id, other := range held {
+		if !now.Before(other.ExpiresAt) {
+			delete(held, id)
+			continue
+		}
+		if lock.conflicts(&other) {
+			return &AdvisoryConflictError{Held: other}
+		}
+	}
+	if held == nil {
+		held = make(map[string]AdvisoryLock)
+		m.advisory[lock.Path] = held
+	}
+	held[lock.ID] = lock
+	return nil
+}
+
+// AdvisoryLock returns an unexpired advisory lock.
+func (m *LocalManager) AdvisoryLock(_ context.Context, path, id string) (*AdvisoryLock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lock, exists := m.advisory[path][id]
+	if !exists || !time.Now().Before(lock.ExpiresAt) {
+		return nil, ErrAdvisoryLockNotFound
+	}
+	return &lock, nil
+}
+
+// RefreshRange moves the expiry of an unexpired advisory lock.
+func (m *LocalManager) RefreshRange(_ context.Context, path, id string, expiresAt time.Time) (*AdvisoryLock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lock, exists := m.advisory[path][id]
+	if !exists || !time.Now().Before(lock.ExpiresAt) {
+		return nil, ErrAdvisoryLockNotFound
+	}
+	lock.ExpiresAt = expiresAt
+	m.advisory[path][id] = lock
+	return &lock, nil
+}
+
+// UnlockRange releases an unexpired advisory lock.
+func (m *LocalManager) UnlockRange(_ context.Context, path, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lock, exists := m.advisory[path][id]
+	if !exists || !time.Now().Before(lock.ExpiresAt) {
+		return ErrAdvisoryLockNotFound
+	}
+	delete(m.advisory[path], id)
+	if len(m.advisory[path]) == 0 {
+		delete(m.advisory, path)
+	}
 	return nil
 }
 
@@ -111,6 +252,16 @@ func (m *LocalManager) cleanupLoop() {
 					delete(m.locks, key)
 				}
 			}
+			for path, held := range m.advisory {
+				for id, lock := range held {
+					if !now.Before(lock.ExpiresAt) {
+						delete(held, id)
+					}
+				}
+				if len(held) == 0 {
+					delete(m.advisory, path)
+				}
+			}
 			m.mu.Unlock()
 		case <-m.stopChan:
 			return
@@ -118,6 +269,17 @@ func (m *LocalManager) cleanupLoop() {
 	}
 }
 
+// newLockToken returns a token for one acquisition of a lock, made of the
+// owner ID of the lock manager, to tell instances apart in lock listings, and
+// random bytes
+func newLockToken(ownerID string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate lock token: %w", err)
+	}
+	return ownerID + ":" + hex.EncodeToString(b), nil
+}
+
 func generateOwnerID() (string, error) {
 	b := make([]byte, 8)
 	if _, err := rand.Read(b); err != nil {
@@ -2,17 +2,49 @@ package locks
 
 import (
 	"context"
+	"time"
 )
 
+// LockInfo describes a lock currently held in the backend
+type LockInfo struct {
+	Key   string `json:"key"`
+	Owner string `json:"owner"`
+	TTLMs int64  `json:"ttl_ms"`          // Remaining time before the lock expires
+	Nodes int    `json:"nodes,omitempty"` // Redlock nodes holding the key
+}
+
 // Manager defines the interface for distributed locking operations
 type Manager interface {
 	// Acquire attempts to acquire a distributed lock for the given key
-	// Returns true if the lock was acquired, false if it was already held by another process
-	Acquire(ctx context.Context, key string) (bool, error)
+	// Returns the token of the acquisition, which Release and Renew take, and
+	// false if the lock was already held by another holder
+	Acquire(ctx context.Context, key string) (string, bool, error)
+
+	// Release releases the acquisition of the lock that returned token
+	// A lock acquired again since, even by the same process, is left alone
+	Release(ctx context.Context, key, token string) error
+
+	// Renew extends the acquisition of the lock that returned token by the lock TTL
+	// Returns false if the lock expired or is now held by another holder
+	Renew(ctx context.Context, key, token string) (bool, error)
+
+	// TTL returns how long a lock is held without renewal
+	TTL() time.Duration
+
+	// List returns the locks currently held by any process whose keys start
+	// with prefix, or all of them for an empty prefix
+	List(ctx context.Context, prefix string) ([]LockInfo, error)
+
+	// Held reports whether any of keys is currently held by any holder,
+	// without acquiring it
+	Held(ctx context.Context, keys ...string) (bool, error)
+
+	// ForceRelease deletes a lock regardless of its owner
+	// Returns false if no such lock was held
+	ForceRelease(ctx context.Context, key string) (bool, error)
 
-	// Release releases a previously acquired lock for the given key
-	// Only the process that acquired the lock can release it
-	Release(ctx context.Context, key string) error
+	// Ping verifies that the lock manager backend is reachable
+	Ping(ctx context.Context) error
 
 	// Close closes the lock manager and releases any resources
 	Close() error
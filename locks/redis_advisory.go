@@ -0,0 +1,169 @@
+package locks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// advisoryKeyPrefix namespaces the hash of advisory locks held on each path
+const advisoryKeyPrefix = "callfs:advisory:"
+
+// advisoryRecord is an advisory lock as stored in Redis, with its expiry in
+// milliseconds so scripts can compare it
+type advisoryRecord struct {
+	AdvisoryLock
+	ExpiresMs int64 `json:"expires_ms"`
+}
+
+// Every advisory lock script drops expired entries and keeps the hash alive
+// until the last remaining lock expires.
+const advisoryPruneLua = `
+	local function prune(key, now)
+		local latest = 0
+		local entries = redis.call("HGETALL", key)
+		local live = {}
+		for i = 1, #entries, 2 do
+			local lock = cjson.decode(entries[i+1])
+			if lock.expires_ms <= now then
+				redis.call("HDEL", key, entries[i])
+			else
+				live[entries[i]] = {lock = lock, raw = entries[i+1]}
+				latest = math.max(latest, lock.expires_ms)
+			end
+		end
+		return live, latest
+	end
+	local function keep(key, latest)
+		if latest > 0 then
+			redis.call("PEXPIREAT", key, latest)
+		end
+	end
+	local function finish(lock)
+		if lock.length == 0 then
+			return math.huge
+		end
+		return lock.start + lock.length
+	end
+`
+
+const redisLockRangeScript = advisoryPruneLua + `
+	local now = tonumber(ARGV[1])
+	local lock = cjson.decode(ARGV[2])
+	local live, latest = prune(KEYS[1], now)
+	for _, held in pairs(live) do
+		local other = held.lock
+		if other.owner ~= lock.owner and (other.mode == "write" or lock.mode == "write")
+			and lock.start < finish(other) and other.start < finish(lock) then
+			return {"conflict", held.raw}
+		end
+	end
+	redis.call("HSET", KEYS[1], lock.id, ARGV[2])
+	keep(KEYS[1], math.max(latest, lock.expires_ms))
+	return {"ok"}
+`
+
+const redisRefreshRangeScript = advisoryPruneLua + `
+	local now, id, expires = tonumber(ARGV[1]), ARGV[2], tonumber(ARGV[3])
+	local live, latest = prune(KEYS[1], now)
+	local held = live[id]
+	if held == nil then
+		return redis.error_reply("not_found")
+	end
+	held.lock.expires_ms = expires
+	local raw = cjson.encode(held.lock)
+	redis.call("HSET", KEYS[1], id, raw)
+	keep(KEYS[1], math.max(latest, expires))
+	return raw
+`
+
+const redisUnlockRangeScript = advisoryPruneLua + `
+	local live, latest = prune(KEYS[1], tonumber(ARGV[1]))
+	if live[ARGV[2]] == nil then
+		return redis.error_reply("not_found")
+	end
+	redis.call("HDEL", KEYS[1], ARGV[2])
+	return "OK"
+`
+
+// LockRange takes an advisory lock unless another owner holds a conflicting
+// one. The check and the write run in one script.
+func (m *RedisManager) LockRange(ctx context.Context, lock AdvisoryLock) error {
+	raw, err := json.Marshal(advisoryRecord{AdvisoryLock: lock, ExpiresMs: lock.ExpiresAt.UnixMilli()})
+	if err != nil {
+		return fmt.Errorf("failed to encode advisory lock: %w", err)
+	}
+
+	result, err := m.client.Eval(ctx, redisLockRangeScript, []string{advisoryKeyPrefix + lock.Path},
+		time.Now().UnixMilli(), raw).StringSlice()
+	if err != nil {
+		return fmt.Errorf("failed to take advisory lock on %s: %w", lock.Path, err)
+	}
+	if len(result) == 2 && result[0] == "conflict" {
+		held, err := decodeAdvisoryRecord(result[1])
+		if err != nil {
+			return err
+		}
+		return &AdvisoryConflictError{Held: *held}
+	}
+	return nil
+}
+
+// AdvisoryLock returns an unexpired advisory lock
+func (m *RedisManager) AdvisoryLock(ctx context.Context, path, id string) (*AdvisoryLock, error) {
+	raw, err := m.client.HGet(ctx, advisoryKeyPrefix+path, id).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrAdvisoryLockNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read advisory lock on %s: %w", path, err)
+	}
+	lock, err := decodeAdvisoryRecord(raw)
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(lock.ExpiresAt) {
+		return nil, ErrAdvisoryLockNotFound
+	}
+	return lock, nil
+}
+
+// RefreshRange moves the expiry of an unexpired advisory lock
+func (m *RedisManager) RefreshRange(ctx context.Context, path, id string, expiresAt time.Time) (*AdvisoryLock, error) {
+	raw, err := m.client.Eval(ctx, redisRefreshRangeScript, []string{advisoryKeyPrefix + path},
+		time.Now().UnixMilli(), id, expiresAt.UnixMilli()).Text()
+	if err != nil {
+		if strings.Contains(err.Error(), "not_found") {
+			return nil, ErrAdvisoryLockNotFound
+		}
+		return nil, fmt.Errorf("failed to refresh advisory lock on %s: %w", path, err)
+	}
+	return decodeAdvisoryRecord(raw)
+}
+
+// UnlockRange releases an unexpired advisory lock
+func (m *RedisManager) UnlockRange(ctx context.Context, path, id string) error {
+	err := m.client.Eval(ctx, redisUnlockRangeScript, []string{advisoryKeyPrefix + path},
+		time.Now().UnixMilli(), id).Err()
+	if err != nil {
+		if strings.Contains(err.Error(), "not_found") {
+			return ErrAdvisoryLockNotFound
+		}
+		return fmt.Errorf("failed to release advisory lock on %s: %w", path, err)
+	}
+	return nil
+}
+
+func decodeAdvisoryRecord(raw string) (*AdvisoryLock, error) {
+	var record advisoryRecord
+	if err := json.Unmarshal([]byte(raw), &record); err != nil {
+		return nil, fmt.Errorf("failed to decode advisory lock: %w", err)
+	}
+	record.ExpiresAt = time.UnixMilli(record.ExpiresMs).UTC()
+	return &record.AdvisoryLock, nil
+}
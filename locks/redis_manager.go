@@ -4,35 +4,47 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"go.uber.org/zap"
+
+	corelog "github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/internal/redisclient"
 )
 
+// lockKeyPrefix namespaces lock keys in Redis
+const lockKeyPrefix = "callfs:lock:"
+
 // RedisManager implements distributed locking using Redis with single-node SET NX.
 // Note: this is NOT Redlock (which requires quorum across 3+ independent Redis nodes).
-// A single-node Redis lock is lost on Redis restart or failover.
+// A single-node Redis lock is lost on Redis restart or failover; use RedlockManager
+// when that matters.
 type RedisManager struct {
-	client  *redis.Client
+	client  redis.UniversalClient
 	logger  *zap.Logger
 	ttl     time.Duration
 	ownerID string // Unique identifier for this lock manager instance
 }
 
-// NewRedisManager creates a new Redis-based lock manager
-func NewRedisManager(redisAddr, redisPassword string, logger *zap.Logger) (*RedisManager, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:         redisAddr,
-		Password:     redisPassword,
-		DB:           0, // Default DB
-		PoolSize:     10,
-		MinIdleConns: 5,
-	})
+// NewRedisManager creates a new Redis-based lock manager. conn may describe a
+// standalone server, a Sentinel-managed master or a Redis Cluster. Locks
+// expire after ttl unless renewed.
+func NewRedisManager(conn redisclient.Options, ttl time.Duration, logger *zap.Logger) (*RedisManager, error) {
+	conn.PoolSize = 10
+	conn.MinIdleConns = 5
+	client, err := redisclient.New(conn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis lock manager configuration: %w", err)
+	}
 
 	// Test connection
 	if err := client.Ping(context.Background()).Err(); err != nil {
+		_ = client.Close()
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
@@ -46,38 +58,39 @@ func NewRedisManager(redisAddr, redisPassword string, logger *zap.Logger) (*Redi
 	return &RedisManager{
 		client:  client,
 		logger:  logger,
-		ttl:     30 * time.Second, // Lock TTL to prevent deadlocks
+		ttl:     ttl, // Lock TTL to prevent deadlocks
 		ownerID: ownerID,
 	}, nil
 }
 
 // Acquire attempts to acquire a distributed lock for the given key
-func (m *RedisManager) Acquire(ctx context.Context, key string) (bool, error) {
-	lockKey := fmt.Sprintf("callfs:lock:%s", key)
+func (m *RedisManager) Acquire(ctx context.Context, key string) (string, bool, error) {
+	lockKey := lockKeyPrefix + key
+	token, err := newLockToken(m.ownerID)
+	if err != nil {
+		return "", false, err
+	}
 
-	// Use SET with NX (only if not exists) and EX (expiration) with unique owner value
-	result := m.client.SetNX(ctx, lockKey, m.ownerID, m.ttl)
+	// Use SET with NX (only if not exists) and EX (expiration) with the token of this acquisition
+	result := m.client.SetNX(ctx, lockKey, token, m.ttl)
 	if err := result.Err(); err != nil {
-		return false, fmt.Errorf("failed to acquire lock for key %s: %w", key, err)
+		return "", false, fmt.Errorf("failed to acquire lock for key %s: %w", key, err)
 	}
 
-	acquired := result.Val()
-
-	if acquired {
-		m.logger.Debug("Lock acquired",
-			zap.String("key", key),
-			zap.String("owner", m.ownerID),
-			zap.Duration("ttl", m.ttl))
-	} else {
-		m.logger.Debug("Lock already held", zap.String("key", key))
+	if !result.Val() {
+		corelog.WithContext(ctx, m.logger).Debug("Lock already held", zap.String("key", key))
+		return "", false, nil
 	}
-
-	return acquired, nil
+	corelog.WithContext(ctx, m.logger).Debug("Lock acquired",
+		zap.String("key", key),
+		zap.String("owner", token),
+		zap.Duration("ttl", m.ttl))
+	return token, true, nil
 }
 
-// Release releases a previously acquired lock for the given key
-func (m *RedisManager) Release(ctx context.Context, key string) error {
-	lockKey := fmt.Sprintf("callfs:lock:%s", key)
+// Release releases the acquisition of the lock that returned token
+func (m *RedisManager) Release(ctx context.Context, key, token string) error {
+	lockKey := lockKeyPrefix + key
 
 	// Use Lua script to ensure atomicity (only delete if we own the lock)
 	luaScript := `
@@ -88,25 +101,154 @@ func (m *RedisManager) Release(ctx context.Context, key string) error {
 		end
 	`
 
-	result := m.client.Eval(ctx, luaScript, []string{lockKey}, m.ownerID)
+	result := m.client.Eval(ctx, luaScript, []string{lockKey}, token)
 	if err := result.Err(); err != nil {
 		return fmt.Errorf("failed to release lock for key %s: %w", key, err)
 	}
 
 	deleted := result.Val().(int64)
 	if deleted == 1 {
-		m.logger.Debug("Lock released",
+		corelog.WithContext(ctx, m.logger).Debug("Lock released",
 			zap.String("key", key),
-			zap.String("owner", m.ownerID))
+			zap.String("owner", token))
 	} else {
-		m.logger.Debug("Lock not owned or already released",
+		corelog.WithContext(ctx, m.logger).Debug("Lock not owned or already released",
 			zap.String("key", key),
-			zap.String("owner", m.ownerID))
+			zap.String("owner", token))
 	}
 
 	return nil
 }
 
+// Renew extends the lock TTL if the acquisition that returned token still holds it
+func (m *RedisManager) Renew(ctx context.Context, key, token string) (bool, error) {
+	lockKey := lockKeyPrefix + key
+
+	// Only extend the TTL if we still own the lock
+	luaScript := `
+		if redis.call("get", KEYS[1]) == ARGV[1] then
+			return redis.call("pexpire", KEYS[1], ARGV[2])
+		else
+			return 0
+		end
+	`
+
+	result := m.client.Eval(ctx, luaScript, []string{lockKey}, token, m.ttl.Milliseconds())
+	if err := result.Err(); err != nil {
+		return false, fmt.Errorf("failed to renew lock for key %s: %w", key, err)
+	}
+
+	return result.Val().(int64) == 1, nil
+}
+
+// TTL returns the lock expiry applied on acquire and renew
+func (m *RedisManager) TTL() time.Duration {
+	return m.ttl
+}
+
+// List returns the locks currently set in Redis whose keys start with
+// prefix, sorted by key
+func (m *RedisManager) List(ctx context.Context, prefix string) ([]LockInfo, error) {
+	var out []LockInfo
+	err := redisclient.ScanKeys(ctx, m.client, lockKeyPrefix+escapeGlob(prefix)+"*", func(lockKey string) error {
+		info, ok, err := readLock(ctx, m.client, lockKey)
+		if err != nil {
+			return err
+		}
+		if ok {
+			out = append(out, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list locks: %w", err)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
+
+// Held reports whether any of keys is set
+func (m *RedisManager) Held(ctx context.Context, keys ...string) (bool, error) {
+	held, err := keysExist(ctx, m.client, keys)
+	if err != nil {
+		return false, fmt.Errorf("failed to check locks: %w", err)
+	}
+	return held, nil
+}
+
+// keysExist reports whether any of the lock keys is set on client. Keys are
+// checked one by one in a pipeline, as a cluster serves them from different
+// slots.
+func keysExist(ctx context.Context, client redis.Cmdable, keys []string) (bool, error) {
+	cmds := make([]*redis.IntCmd, len(keys))
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Exists(ctx, lockKeyPrefix+key)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, cmd := range cmds {
+		if cmd.Val() > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// escapeGlob escapes the characters of s that a Redis SCAN MATCH pattern
+// gives a meaning to
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// ForceRelease deletes a lock regardless of its owner
+func (m *RedisManager) ForceRelease(ctx context.Context, key string) (bool, error) {
+	n, err := m.client.Del(ctx, lockKeyPrefix+key).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to force-release lock for key %s: %w", key, err)
+	}
+	return n == 1, nil
+}
+
+// readLock reads the owner and remaining TTL of a lock key. ok is false if
+// the key expired in the meantime.
+func readLock(ctx context.Context, client redis.Cmdable, lockKey string) (info LockInfo, ok bool, err error) {
+	var owner *redis.StringCmd
+	var ttl *redis.DurationCmd
+	_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		owner = pipe.Get(ctx, lockKey)
+		ttl = pipe.PTTL(ctx, lockKey)
+		return nil
+	})
+	if errors.Is(err, redis.Nil) {
+		return LockInfo{}, false, nil
+	}
+	if err != nil {
+		return LockInfo{}, false, fmt.Errorf("failed to read lock %s: %w", lockKey, err)
+	}
+	return LockInfo{
+		Key:   strings.TrimPrefix(lockKey, lockKeyPrefix),
+		Owner: owner.Val(),
+		TTLMs: ttl.Val().Milliseconds(),
+	}, true, nil
+}
+
+// Ping verifies the Redis connection is alive
+func (m *RedisManager) Ping(ctx context.Context) error {
+	return m.client.Ping(ctx).Err()
+}
+
 // Close closes the Redis client connection
 func (m *RedisManager) Close() error {
 	return m.client.Close()
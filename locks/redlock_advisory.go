@@ -0,0 +1,146 @@
+package locks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// LockRange takes an advisory lock on every node with the script of
+// RedisManager, and succeeds when a quorum took it. A partial acquisition is
+// rolled back; the conflict a node reported, if any, is returned.
+func (m *RedlockManager) LockRange(ctx context.Context, lock AdvisoryLock) error {
+	raw, err := json.Marshal(advisoryRecord{AdvisoryLock: lock, ExpiresMs: lock.ExpiresAt.UnixMilli()})
+	if err != nil {
+		return fmt.Errorf("failed to encode advisory lock: %w", err)
+	}
+	key := advisoryKeyPrefix + lock.Path
+
+	var mu sync.Mutex
+	var conflict string
+	locked, failed, firstErr := m.forEachNode(ctx, func(ctx context.Context, client redis.UniversalClient) (bool, error) {
+		result, err := client.Eval(ctx, redisLockRangeScript, []string{key}, time.Now().UnixMilli(), raw).StringSlice()
+		if err != nil {
+			return false, err
+		}
+		if len(result) == 2 && result[0] == "conflict" {
+			mu.Lock()
+			conflict = result[1]
+			mu.Unlock()
+			return false, nil
+		}
+		return true, nil
+	})
+	if locked >= m.quorum {
+		return nil
+	}
+
+	m.unlockRange(key, lock.ID)
+	if conflict != "" {
+		held, err := decodeAdvisoryRecord(conflict)
+		if err != nil {
+			return err
+		}
+		return &AdvisoryConflictError{Held: *held}
+	}
+	return fmt.Errorf("failed to take advisory lock on %s: only %d of %d redlock nodes reachable: %w",
+		lock.Path, len(m.clients)-failed, len(m.clients), firstErr)
+}
+
+// AdvisoryLock returns an advisory lock that a quorum of nodes hold unexpired
+func (m *RedlockManager) AdvisoryLock(ctx context.Context, path, id string) (*AdvisoryLock, error) {
+	var mu sync.Mutex
+	var found *AdvisoryLock
+	held, failed, firstErr := m.forEachNode(ctx, func(ctx context.Context, client redis.UniversalClient) (bool, error) {
+		raw, err := client.HGet(ctx, advisoryKeyPrefix+path, id).Result()
+		if errors.Is(err, redis.Nil) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		lock, err := decodeAdvisoryRecord(raw)
+		if err != nil || !time.Now().Before(lock.ExpiresAt) {
+			return false, err
+		}
+		mu.Lock()
+		if found == nil || lock.ExpiresAt.Before(found.ExpiresAt) {
+			found = lock
+		}
+		mu.Unlock()
+		return true, nil
+	})
+	if held >= m.quorum {
+		return found, nil
+	}
+	if len(m.clients)-failed < m.quorum {
+		return nil, fmt.Errorf("failed to read advisory lock on %s from a quorum of redlock nodes: %w", path, firstErr)
+	}
+	return nil, ErrAdvisoryLockNotFound
+}
+
+// RefreshRange moves the expiry of an advisory lock on every node holding
+// it. The lock stays held only if a quorum was refreshed.
+func (m *RedlockManager) RefreshRange(ctx context.Context, path, id string, expiresAt time.Time) (*AdvisoryLock, error) {
+	var mu sync.Mutex
+	var refreshed string
+	ok, failed, firstErr := m.forEachNode(ctx, func(ctx context.Context, client redis.UniversalClient) (bool, error) {
+		raw, err := client.Eval(ctx, redisRefreshRangeScript, []string{advisoryKeyPrefix + path},
+			time.Now().UnixMilli(), id, expiresAt.UnixMilli()).Text()
+		if err != nil {
+			if strings.Contains(err.Error(), "not_found") {
+				return false, nil
+			}
+			return false, err
+		}
+		mu.Lock()
+		refreshed = raw
+		mu.Unlock()
+		return true, nil
+	})
+	if ok >= m.quorum {
+		return decodeAdvisoryRecord(refreshed)
+	}
+	if len(m.clients)-failed < m.quorum {
+		return nil, fmt.Errorf("failed to refresh advisory lock on %s on a quorum of redlock nodes: %w", path, firstErr)
+	}
+	return nil, ErrAdvisoryLockNotFound
+}
+
+// UnlockRange releases an advisory lock from every node holding it
+func (m *RedlockManager) UnlockRange(ctx context.Context, path, id string) error {
+	released, failed, firstErr := m.forEachNode(ctx, func(ctx context.Context, client redis.UniversalClient) (bool, error) {
+		err := client.Eval(ctx, redisUnlockRangeScript, []string{advisoryKeyPrefix + path}, time.Now().UnixMilli(), id).Err()
+		if err != nil {
+			if strings.Contains(err.Error(), "not_found") {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	})
+	if len(m.clients)-failed < m.quorum {
+		return fmt.Errorf("failed to release advisory lock on %s on a quorum of redlock nodes: %w", path, firstErr)
+	}
+	if released == 0 {
+		return ErrAdvisoryLockNotFound
+	}
+	return nil
+}
+
+// unlockRange removes an advisory lock from whichever nodes took it, as
+// release does a lock
+func (m *RedlockManager) unlockRange(key, id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), redlockNodeTimeout)
+	defer cancel()
+	_, _, _ = m.forEachNode(ctx, func(ctx context.Context, client redis.UniversalClient) (bool, error) {
+		err := client.Eval(ctx, redisUnlockRangeScript, []string{key}, time.Now().UnixMilli(), id).Err()
+		return err == nil, err
+	})
+}
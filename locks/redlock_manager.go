@@ -0,0 +1,318 @@
+package locks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+
+	corelog "github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/internal/redisclient"
+)
+
+const (
+	// redlockClockDriftFactor is the fraction of the TTL reserved for clock
+	// drift between nodes when computing how long an acquired lock is valid
+	redlockClockDriftFactor = 0.01
+
+	// redlockNodeTimeout bounds each per-node call so a dead node cannot use
+	// up the lock validity time
+	redlockNodeTimeout = 200 * time.Millisecond
+)
+
+const (
+	redlockReleaseScript = `
+		if redis.call("get", KEYS[1]) == ARGV[1] then
+			return redis.call("del", KEYS[1])
+		else
+			return 0
+		end
+	`
+	redlockRenewScript = `
+		if redis.call("get", KEYS[1]) == ARGV[1] then
+			return redis.call("pexpire", KEYS[1], ARGV[2])
+		else
+			return 0
+		end
+	`
+)
+
+// RedlockManager implements the Redlock algorithm over N independent Redis
+// masters. A lock is held only while a majority of nodes agree, so losing a
+// minority of nodes neither blocks locking nor lets two holders in.
+type RedlockManager struct {
+	clients []redis.UniversalClient
+	quorum  int
+	logger  *zap.Logger
+	ttl     time.Duration
+	ownerID string // Unique identifier for this lock manager instance
+}
+
+// NewRedlockManager creates a Redlock manager over the given nodes, which must
+// be independent standalone masters (not replicas of each other). At least
+// three nodes are required; locks expire after ttl unless renewed.
+func NewRedlockManager(nodes []redisclient.Options, ttl time.Duration, logger *zap.Logger) (*RedlockManager, error) {
+	if len(nodes) < 3 {
+		return nil, fmt.Errorf("redlock requires at least 3 redis nodes, got %d", len(nodes))
+	}
+
+	m := &RedlockManager{
+		quorum: len(nodes)/2 + 1,
+		logger: logger,
+		ttl:    ttl,
+	}
+	for _, conn := range nodes {
+		conn.PoolSize = 10
+		conn.MinIdleConns = 2
+		client, err := redisclient.New(conn)
+		if err != nil {
+			_ = m.Close()
+			return nil, fmt.Errorf("invalid redlock node configuration: %w", err)
+		}
+		m.clients = append(m.clients, client)
+	}
+
+	// A quorum must be reachable at startup; the rest may come up later
+	if err := m.Ping(context.Background()); err != nil {
+		_ = m.Close()
+		return nil, err
+	}
+
+	ownerBytes := make([]byte, 16)
+	if _, err := rand.Read(ownerBytes); err != nil {
+		_ = m.Close()
+		return nil, fmt.Errorf("failed to generate owner ID: %w", err)
+	}
+	m.ownerID = hex.EncodeToString(ownerBytes)
+
+	return m, nil
+}
+
+// Acquire sets the lock on every node and succeeds when a quorum accepted it
+// within the lock validity time. A partial acquisition is rolled back.
+func (m *RedlockManager) Acquire(ctx context.Context, key string) (string, bool, error) {
+	lockKey := lockKeyPrefix + key
+	token, err := newLockToken(m.ownerID)
+	if err != nil {
+		return "", false, err
+	}
+
+	start := time.Now()
+	acquired, failed, firstErr := m.forEachNode(ctx, func(ctx context.Context, client redis.UniversalClient) (bool, error) {
+		return client.SetNX(ctx, lockKey, token, m.ttl).Result()
+	})
+
+	drift := time.Duration(float64(m.ttl)*redlockClockDriftFactor) + 2*time.Millisecond
+	validity := m.ttl - time.Since(start) - drift
+	if acquired >= m.quorum && validity > 0 {
+		corelog.WithContext(ctx, m.logger).Debug("Lock acquired",
+			zap.String("key", key),
+			zap.String("owner", token),
+			zap.Int("nodes", acquired),
+			zap.Duration("validity", validity))
+		return token, true, nil
+	}
+
+	// Roll back whatever was set so the key frees up before its TTL
+	m.release(lockKey, token)
+
+	if len(m.clients)-failed < m.quorum {
+		return "", false, fmt.Errorf("failed to acquire lock for key %s: only %d of %d redlock nodes reachable: %w",
+			key, len(m.clients)-failed, len(m.clients), firstErr)
+	}
+	corelog.WithContext(ctx, m.logger).Debug("Lock already held",
+		zap.String("key", key),
+		zap.Int("nodes", acquired))
+	return "", false, nil
+}
+
+// Release deletes the lock from every node that still holds it for the
+// acquisition that returned token
+func (m *RedlockManager) Release(ctx context.Context, key, token string) error {
+	lockKey := lockKeyPrefix + key
+
+	released, failed, firstErr := m.forEachNode(ctx, func(ctx context.Context, client redis.UniversalClient) (bool, error) {
+		n, err := client.Eval(ctx, redlockReleaseScript, []string{lockKey}, token).Int64()
+		return n == 1, err
+	})
+	if len(m.clients)-failed < m.quorum {
+		return fmt.Errorf("failed to release lock for key %s on a quorum of redlock nodes: %w", key, firstErr)
+	}
+
+	corelog.WithContext(ctx, m.logger).Debug("Lock released",
+		zap.String("key", key),
+		zap.String("owner", token),
+		zap.Int("nodes", released))
+	return nil
+}
+
+// Renew extends the lock TTL on every node the acquisition that returned
+// token still holds it on. The lock stays held only if a quorum was extended.
+func (m *RedlockManager) Renew(ctx context.Context, key, token string) (bool, error) {
+	lockKey := lockKeyPrefix + key
+
+	renewed, failed, firstErr := m.forEachNode(ctx, func(ctx context.Context, client redis.UniversalClient) (bool, error) {
+		n, err := client.Eval(ctx, redlockRenewScript, []string{lockKey}, token, m.ttl.Milliseconds()).Int64()
+		return n == 1, err
+	})
+	if renewed >= m.quorum {
+		return true, nil
+	}
+	if len(m.clients)-failed < m.quorum {
+		return false, fmt.Errorf("failed to renew lock for key %s on a quorum of redlock nodes: %w", key, firstErr)
+	}
+	return false, nil
+}
+
+// TTL returns the lock expiry applied on acquire and renew
+func (m *RedlockManager) TTL() time.Duration {
+	return m.ttl
+}
+
+// List merges the locks found on every reachable node. Owner is the owner on
+// the first node seen, TTL the shortest remaining, and Nodes how many nodes
+// hold the key; a lock on fewer than a quorum of nodes is not actually held.
+func (m *RedlockManager) List(ctx context.Context, prefix string) ([]LockInfo, error) {
+	merged := make(map[string]*LockInfo)
+	reachable := 0
+	var firstErr error
+	for _, client := range m.clients {
+		var found []LockInfo
+		err := redisclient.ScanKeys(ctx, client, lockKeyPrefix+escapeGlob(prefix)+"*", func(lockKey string) error {
+			info, ok, err := readLock(ctx, client, lockKey)
+			if ok {
+				found = append(found, info)
+			}
+			return err
+		})
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		reachable++
+		for _, info := range found {
+			existing, ok := merged[info.Key]
+			if !ok {
+				info.Nodes = 1
+				merged[info.Key] = &info
+				continue
+			}
+			existing.Nodes++
+			if info.TTLMs < existing.TTLMs {
+				existing.TTLMs = info.TTLMs
+			}
+		}
+	}
+	if reachable < m.quorum {
+		return nil, fmt.Errorf("failed to list locks: only %d of %d redlock nodes reachable: %w", reachable, len(m.clients), firstErr)
+	}
+
+	out := make([]LockInfo, 0, len(merged))
+	for _, info := range merged {
+		out = append(out, *info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
+
+// Held reports whether any of keys is set on a node. A lock is held on a
+// quorum of nodes, so asking a quorum finds it on at least one.
+func (m *RedlockManager) Held(ctx context.Context, keys ...string) (bool, error) {
+	held, failed, firstErr := m.forEachNode(ctx, func(ctx context.Context, client redis.UniversalClient) (bool, error) {
+		return keysExist(ctx, client, keys)
+	})
+	if len(m.clients)-failed < m.quorum {
+		return false, fmt.Errorf("failed to check locks on a quorum of redlock nodes: %w", firstErr)
+	}
+	return held > 0, nil
+}
+
+// ForceRelease deletes a lock from every node regardless of its owner
+func (m *RedlockManager) ForceRelease(ctx context.Context, key string) (bool, error) {
+	deleted, failed, firstErr := m.forEachNode(ctx, func(ctx context.Context, client redis.UniversalClient) (bool, error) {
+		n, err := client.Del(ctx, lockKeyPrefix+key).Result()
+		return n == 1, err
+	})
+	if len(m.clients)-failed < m.quorum {
+		return deleted > 0, fmt.Errorf("failed to force-release lock for key %s on a quorum of redlock nodes: %w", key, firstErr)
+	}
+	return deleted > 0, nil
+}
+
+// Ping succeeds while a quorum of nodes is reachable
+func (m *RedlockManager) Ping(ctx context.Context) error {
+	_, failed, firstErr := m.forEachNode(ctx, func(ctx context.Context, client redis.UniversalClient) (bool, error) {
+		return true, client.Ping(ctx).Err()
+	})
+	if len(m.clients)-failed < m.quorum {
+		return fmt.Errorf("only %d of %d redlock nodes reachable (quorum %d): %w",
+			len(m.clients)-failed, len(m.clients), m.quorum, firstErr)
+	}
+	return nil
+}
+
+// Close closes every node connection
+func (m *RedlockManager) Close() error {
+	var firstErr error
+	for _, client := range m.clients {
+		if err := client.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// release removes the lock set with token from all nodes, ignoring failures;
+// nodes that cannot be reached drop the key when its TTL expires
+func (m *RedlockManager) release(lockKey, token string) {
+	ctx, cancel := context.WithTimeout(context.Background(), redlockNodeTimeout)
+	defer cancel()
+	_, _, _ = m.forEachNode(ctx, func(ctx context.Context, client redis.UniversalClient) (bool, error) {
+		err := client.Eval(ctx, redlockReleaseScript, []string{lockKey}, token).Err()
+		return err == nil, err
+	})
+}
+
+// forEachNode runs op against every node concurrently, each bounded by
+// redlockNodeTimeout. It returns how many nodes reported true, how many
+// failed with an error, and the first error seen.
+func (m *RedlockManager) forEachNode(ctx context.Context, op func(context.Context, redis.UniversalClient) (bool, error)) (int, int, error) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		ok       int
+		failed   int
+		firstErr error
+	)
+	for _, client := range m.clients {
+		wg.Add(1)
+		go func(client redis.UniversalClient) {
+			defer wg.Done()
+			nodeCtx, cancel := context.WithTimeout(ctx, redlockNodeTimeout)
+			defer cancel()
+			result, err := op(nodeCtx, client)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				failed++
+				if firstErr == nil {
+					firstErr = err
+				}
+			case result:
+				ok++
+			}
+		}(client)
+	}
+	wg.Wait()
+	return ok, failed, firstErr
+}
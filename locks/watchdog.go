@@ -0,0 +1,76 @@
+package locks
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	corelog "github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/metrics"
+)
+
+// KeepAlive renews the acquisition of key that returned token every third of
+// its TTL until stop is called. The returned context is derived from ctx and
+// is canceled once the lock can no longer be guaranteed: when a renewal
+// reports the lock lost, or when renewals keep failing until the TTL has run
+// out. Work done under the lock should use the returned context so it stops
+// instead of racing a new holder. Operations that can outlast the TTL, such
+// as transfers of large files or calls to a slow backend, hold their locks
+// this way.
+func KeepAlive(ctx context.Context, m Manager, key, token string, logger *zap.Logger) (context.Context, func()) {
+	interval := m.TTL() / 3
+	lockCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		logger := corelog.WithContext(ctx, logger)
+		renewedAt := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case <-lockCtx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			renewCtx, renewCancel := context.WithTimeout(context.Background(), interval)
+			held, err := m.Renew(renewCtx, key, token)
+			renewCancel()
+
+			switch {
+			case err != nil:
+				metrics.LockRenewalsTotal.WithLabelValues("error").Inc()
+				if time.Since(renewedAt) < m.TTL() {
+					logger.Warn("Lock renewal failed, retrying", zap.String("lock_key", key), zap.Error(err))
+					continue
+				}
+				logger.Error("Lock expired after repeated renewal failures, aborting operation",
+					zap.String("lock_key", key), zap.Error(err))
+				cancel()
+				return
+			case !held:
+				metrics.LockRenewalsTotal.WithLabelValues("lost").Inc()
+				logger.Error("Lock lost before operation finished, aborting operation", zap.String("lock_key", key))
+				cancel()
+				return
+			default:
+				metrics.LockRenewalsTotal.WithLabelValues("success").Inc()
+				renewedAt = time.Now()
+			}
+		}
+	}()
+
+	stop := func() {
+		close(done)
+		<-stopped
+		cancel()
+	}
+	return lockCtx, stop
+}
@@ -0,0 +1,45 @@
+package metadata
+
+import (
+	"context"
+	"time"
+)
+
+// AccessStats counts the downloads of the file at Path
+type AccessStats struct {
+	Path          string    `json:"path"`
+	Downloads     int64     `json:"downloads"`
+	LinkDownloads int64     `json:"link_downloads"` // Of Downloads, those through single-use links
+	BytesServed   int64     `json:"bytes_served"`
+	LastAccess    time.Time `json:"last_access"`
+}
+
+// Add adds the counts of other to s and keeps the later last access
+func (s *AccessStats) Add(other *AccessStats) {
+	s.Downloads += other.Downloads
+	s.LinkDownloads += other.LinkDownloads
+	s.BytesServed += other.BytesServed
+	if other.LastAccess.After(s.LastAccess) {
+		s.LastAccess = other.LastAccess
+	}
+}
+
+// AccessStatsStore is implemented by metadata stores that can keep access
+// statistics
+type AccessStatsStore interface {
+	// AddAccessStats adds the counts of each entry to those stored for its
+	// path, keeping the later last access
+	AddAccessStats(ctx context.Context, stats []*AccessStats) error
+
+	// GetAccessStats returns the statistics of path, or ErrNotFound
+	GetAccessStats(ctx context.Context, path string) (*AccessStats, error)
+
+	// ListAccessStats returns the statistics of the paths below prefix ("/"
+	// for all), ordered by path. At most limit entries are returned, starting
+	// after the path cursor when it is not empty.
+	ListAccessStats(ctx context.Context, prefix string, limit int, cursor string) ([]*AccessStats, error)
+
+	// DeleteAccessStats removes the statistics of path and of every path
+	// below it
+	DeleteAccessStats(ctx context.Context, path string) error
+}
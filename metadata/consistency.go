@@ -0,0 +1,46 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ReadConsistency selects how fresh a metadata read must be. Only stores
+// that replicate asynchronously to followers (raft) act on it.
+type ReadConsistency string
+
+const (
+	// ConsistencyDefault defers to the store's configured level
+	ConsistencyDefault ReadConsistency = ""
+	// ConsistencyEventual reads local state, which may trail the leader
+	ConsistencyEventual ReadConsistency = "eventual"
+	// ConsistencyLinearizable waits until local state includes every write
+	// committed before the read started
+	ConsistencyLinearizable ReadConsistency = "linearizable"
+)
+
+type consistencyKey struct{}
+
+// ParseReadConsistency validates a consistency name; the empty string maps
+// to ConsistencyDefault
+func ParseReadConsistency(s string) (ReadConsistency, error) {
+	switch c := ReadConsistency(strings.ToLower(strings.TrimSpace(s))); c {
+	case ConsistencyDefault, ConsistencyEventual, ConsistencyLinearizable:
+		return c, nil
+	default:
+		return "", fmt.Errorf("invalid read consistency %q (must be eventual or linearizable)", s)
+	}
+}
+
+// WithReadConsistency returns a context requesting consistency c for reads
+func WithReadConsistency(ctx context.Context, c ReadConsistency) context.Context {
+	return context.WithValue(ctx, consistencyKey{}, c)
+}
+
+// ReadConsistencyFromContext returns the consistency requested on ctx, or
+// ConsistencyDefault
+func ReadConsistencyFromContext(ctx context.Context) ReadConsistency {
+	c, _ := ctx.Value(consistencyKey{}).(ReadConsistency)
+	return c
+}
@@ -0,0 +1,237 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+)
+
+// DumpVersion is the format version written in the dump header
+const DumpVersion = 1
+
+// dumpPageSize is how many entries are fetched per page while exporting
+const dumpPageSize = 1000
+
+// Dump record kinds
+const (
+	DumpKindHeader  = "header"
+	DumpKindInode   = "inode"
+	DumpKindLink    = "link"
+	DumpKindErasure = "erasure"
+)
+
+// DumpRecord is one line of a JSONL metadata dump. The first record is a
+// header; each inode precedes its erasure record, and parents precede children.
+type DumpRecord struct {
+	Kind    string           `json:"kind"`
+	Version int              `json:"version,omitempty"`
+	Inode   *Metadata        `json:"inode,omitempty"`
+	Link    *SingleUseLink   `json:"link,omitempty"`
+	Erasure *ErasureFileInfo `json:"erasure,omitempty"`
+}
+
+// DumpStats counts the records exported or imported
+type DumpStats struct {
+	Inodes  int `json:"inodes"`
+	Links   int `json:"links"`
+	Erasure int `json:"erasure"`
+}
+
+// Export writes every inode, single-use link and erasure profile in store to
+// w as JSONL. Erasure profiles are included when store implements
+// ErasureMetadataStore.
+func Export(ctx context.Context, store Store, w io.Writer) (DumpStats, error) {
+	var stats DumpStats
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(DumpRecord{Kind: DumpKindHeader, Version: DumpVersion}); err != nil {
+		return stats, fmt.Errorf("failed to write dump header: %w", err)
+	}
+
+	erasureStore, _ := store.(ErasureMetadataStore)
+	writeInode := func(md *Metadata) error {
+		if err := enc.Encode(DumpRecord{Kind: DumpKindInode, Inode: md}); err != nil {
+			return fmt.Errorf("failed to write inode %s: %w", md.Path, err)
+		}
+		stats.Inodes++
+		if erasureStore == nil || (!md.ErasureCoded && md.BackendType != "erasure") {
+			return nil
+		}
+		info, err := erasureStore.GetErasureInfo(ctx, md.Path)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read erasure info for %s: %w", md.Path, err)
+		}
+		if err := enc.Encode(DumpRecord{Kind: DumpKindErasure, Erasure: info}); err != nil {
+			return fmt.Errorf("failed to write erasure info for %s: %w", md.Path, err)
+		}
+		stats.Erasure++
+		return nil
+	}
+
+	// ListDescendants excludes the prefix itself, so the root is fetched first
+	root, err := store.Get(ctx, "/")
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return stats, fmt.Errorf("failed to read root inode: %w", err)
+	}
+	if root != nil {
+		if err := writeInode(root); err != nil {
+			return stats, err
+		}
+	}
+
+	cursor := ""
+	for {
+		page, err := store.ListDescendants(ctx, "/", dumpPageSize, cursor)
+		if err != nil {
+			return stats, fmt.Errorf("failed to list inodes: %w", err)
+		}
+		for _, md := range page {
+			if err := writeInode(md); err != nil {
+				return stats, err
+			}
+		}
+		// Only an empty page ends the listing: a store may return fewer
+		// entries than asked when some were removed while it read them
+		if len(page) == 0 {
+			break
+		}
+		cursor = page[len(page)-1].Path
+	}
+
+	cursor = ""
+	for {
+		page, err := store.ListSingleUseLinks(ctx, dumpPageSize, cursor)
+		if err != nil {
+			return stats, fmt.Errorf("failed to list single-use links: %w", err)
+		}
+		for _, link := range page {
+			if err := enc.Encode(DumpRecord{Kind: DumpKindLink, Link: link}); err != nil {
+				return stats, fmt.Errorf("failed to write link: %w", err)
+			}
+			stats.Links++
+		}
+		if len(page) == 0 {
+			break
+		}
+		cursor = page[len(page)-1].Token
+	}
+
+	return stats, nil
+}
+
+// Import restores a dump written by Export into store. Existing inodes and
+// erasure profiles are overwritten; links whose token already exists are left
+// alone. Parent IDs are remapped to the IDs assigned by the target store.
+func Import(ctx context.Context, store Store, r io.Reader) (DumpStats, error) {
+	var stats DumpStats
+	dec := json.NewDecoder(r)
+
+	var header DumpRecord
+	if err := dec.Decode(&header); err != nil {
+		return stats, fmt.Errorf("failed to read dump header: %w", err)
+	}
+	if header.Kind != DumpKindHeader {
+		return stats, fmt.Errorf("dump does not start with a header record")
+	}
+	if header.Version != DumpVersion {
+		return stats, fmt.Errorf("unsupported dump version %d", header.Version)
+	}
+
+	erasureStore, _ := store.(ErasureMetadataStore)
+	for line := 2; ; line++ {
+		var rec DumpRecord
+		if err := dec.Decode(&rec); err != nil {
+			if errors.Is(err, io.EOF) {
+				return stats, nil
+			}
+			return stats, fmt.Errorf("record %d: %w", line, err)
+		}
+
+		switch rec.Kind {
+		case DumpKindInode:
+			if rec.Inode == nil {
+				return stats, fmt.Errorf("record %d: inode record has no inode", line)
+			}
+			if err := importInode(ctx, store, rec.Inode); err != nil {
+				return stats, fmt.Errorf("record %d: %w", line, err)
+			}
+			stats.Inodes++
+		case DumpKindLink:
+			if rec.Link == nil {
+				return stats, fmt.Errorf("record %d: link record has no link", line)
+			}
+			if err := importLink(ctx, store, rec.Link); err != nil {
+				return stats, fmt.Errorf("record %d: %w", line, err)
+			}
+			stats.Links++
+		case DumpKindErasure:
+			if rec.Erasure == nil {
+				return stats, fmt.Errorf("record %d: erasure record has no erasure info", line)
+			}
+			if erasureStore == nil {
+				return stats, fmt.Errorf("record %d: target store does not support erasure metadata", line)
+			}
+			if err := erasureStore.DeleteErasureInfo(ctx, rec.Erasure.FilePath); err != nil && !errors.Is(err, ErrNotFound) {
+				return stats, fmt.Errorf("record %d: failed to replace erasure info for %s: %w", line, rec.Erasure.FilePath, err)
+			}
+			if err := erasureStore.CreateErasureInfo(ctx, rec.Erasure.FilePath, rec.Erasure); err != nil {
+				return stats, fmt.Errorf("record %d: failed to create erasure info for %s: %w", line, rec.Erasure.FilePath, err)
+			}
+			stats.Erasure++
+		default:
+			return stats, fmt.Errorf("record %d: unknown record kind %q", line, rec.Kind)
+		}
+	}
+}
+
+func importInode(ctx context.Context, store Store, md *Metadata) error {
+	md.ParentID = nil
+	if md.Path != "/" {
+		parent, err := store.Get(ctx, path.Dir(md.Path))
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("failed to resolve parent of %s: %w", md.Path, err)
+		}
+		if parent != nil {
+			md.ParentID = &parent.ID
+		}
+	}
+
+	err := store.Create(ctx, md)
+	if errors.Is(err, ErrAlreadyExists) {
+		err = store.Update(ctx, md)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to import inode %s: %w", md.Path, err)
+	}
+	return nil
+}
+
+// importLink creates link as active and then replays its status change, since
+// not every store persists usage fields on create
+func importLink(ctx context.Context, store Store, link *SingleUseLink) error {
+	if _, err := store.GetSingleUseLink(ctx, link.Token); err == nil {
+		return nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to check link %s: %w", link.Token, err)
+	}
+
+	created := *link
+	created.Status = "active"
+	created.UsedAt = nil
+	created.UsedByIP = nil
+	if err := store.CreateSingleUseLink(ctx, &created); err != nil {
+		return fmt.Errorf("failed to import link %s: %w", link.Token, err)
+	}
+	if link.Status == "active" {
+		return nil
+	}
+	if err := store.UpdateSingleUseLink(ctx, link.Token, link.Status, link.UsedAt, link.UsedByIP); err != nil {
+		return fmt.Errorf("failed to restore status of link %s: %w", link.Token, err)
+	}
+	return nil
+}
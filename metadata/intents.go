@@ -0,0 +1,39 @@
+package metadata
+
+import (
+	"context"
+	"time"
+)
+
+// Operations recorded in the intent journal
+const (
+	IntentCreate = "create"
+	IntentUpdate = "update"
+	IntentDelete = "delete"
+)
+
+// Intent records a file operation whose backend write and metadata write have
+// not both completed. It is recorded before the backend is touched and cleared
+// once the metadata is committed, so an intent left behind by a crash names
+// an operation to replay or roll back.
+type Intent struct {
+	ID          string    `json:"id"`
+	InstanceID  string    `json:"instance_id"` // Instance performing the operation
+	Op          string    `json:"op"`          // create, update or delete
+	Path        string    `json:"path"`
+	BackendType string    `json:"backend_type"`
+	Owner       string    `json:"owner,omitempty"` // Instance owning the content, if any
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// IntentJournal is implemented by metadata stores that can record intents
+type IntentJournal interface {
+	// RecordIntent stores intent
+	RecordIntent(ctx context.Context, intent *Intent) error
+
+	// ClearIntent removes intent; clearing one that is not recorded is not an error
+	ClearIntent(ctx context.Context, intent *Intent) error
+
+	// ListIntents returns the intents recorded by an instance, oldest first
+	ListIntents(ctx context.Context, instanceID string) ([]*Intent, error)
+}
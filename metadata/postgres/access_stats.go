@@ -0,0 +1,109 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// AddAccessStats adds the counts of each entry to those stored for its path.
+func (s *PostgresStore) AddAccessStats(ctx context.Context, stats []*metadata.AccessStats) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, st := range stats {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO access_stats (path, downloads, link_downloads, bytes_served, last_access)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (path) DO UPDATE SET
+			    downloads = access_stats.downloads + EXCLUDED.downloads,
+			    link_downloads = access_stats.link_downloads + EXCLUDED.link_downloads,
+			    bytes_served = access_stats.bytes_served + EXCLUDED.bytes_served,
+			    last_access = GREATEST(access_stats.last_access, EXCLUDED.last_access)`,
+			st.Path, st.Downloads, st.LinkDownloads, st.BytesServed, st.LastAccess)
+		if err != nil {
+			return fmt.Errorf("failed to add access statistics: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// GetAccessStats returns the access statistics of path.
+func (s *PostgresStore) GetAccessStats(ctx context.Context, path string) (*metadata.AccessStats, error) {
+	var st metadata.AccessStats
+	err := s.read(ctx, func(q dbExecutor) error {
+		return q.QueryRowContext(ctx, `
+			SELECT path, downloads, link_downloads, bytes_served, last_access
+			FROM access_stats WHERE path = $1`, path,
+		).Scan(&st.Path, &st.Downloads, &st.LinkDownloads, &st.BytesServed, &st.LastAccess)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, metadata.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access statistics: %w", err)
+	}
+	return &st, nil
+}
+
+// ListAccessStats returns the access statistics of the paths below prefix,
+// ordered by path.
+func (s *PostgresStore) ListAccessStats(ctx context.Context, prefix string, limit int, cursor string) ([]*metadata.AccessStats, error) {
+	query := `
+		SELECT path, downloads, link_downloads, bytes_served, last_access
+		FROM access_stats
+		WHERE path LIKE $1 ESCAPE '\' AND path > $2
+		ORDER BY path ASC
+		LIMIT $3`
+	pattern := escapeLikePattern(strings.TrimSuffix(prefix, "/")) + "/%"
+
+	var maxRows sql.NullInt64
+	if limit > 0 {
+		maxRows = sql.NullInt64{Int64: int64(limit), Valid: true}
+	}
+
+	var stats []*metadata.AccessStats
+	err := s.read(ctx, func(q dbExecutor) error {
+		rows, err := q.QueryContext(ctx, query, pattern, cursor, maxRows)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		stats = nil
+		for rows.Next() {
+			var st metadata.AccessStats
+			if err := rows.Scan(&st.Path, &st.Downloads, &st.LinkDownloads, &st.BytesServed, &st.LastAccess); err != nil {
+				return err
+			}
+			stats = append(stats, &st)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list access statistics: %w", err)
+	}
+	return stats, nil
+}
+
+// DeleteAccessStats removes the access statistics of path and of the paths
+// below it.
+func (s *PostgresStore) DeleteAccessStats(ctx context.Context, path string) error {
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM access_stats WHERE path = $1 OR path LIKE $2 ESCAPE '\'`,
+		path, escapeLikePattern(path)+"/%")
+	if err != nil {
+		return fmt.Errorf("failed to delete access statistics: %w", err)
+	}
+	return nil
+}
@@ -42,7 +42,7 @@ func (s *PostgresStore) CreateErasureInfo(ctx context.Context, filePath string,
 // GetErasureInfo retrieves erasure coding metadata for a file.
 func (s *PostgresStore) GetErasureInfo(ctx context.Context, filePath string) (*metadata.ErasureFileInfo, error) {
 	var info metadata.ErasureFileInfo
-	err := s.db.QueryRowContext(ctx,
+	err := s.q.QueryRowContext(ctx,
 		`SELECT file_path, data_shards, parity_shards, shard_size, original_size
 		 FROM erasure_profiles WHERE file_path = $1`, filePath,
 	).Scan(&info.FilePath, &info.DataShards, &info.ParityShards, &info.ShardSize, &info.OriginalSize)
@@ -53,7 +53,7 @@ func (s *PostgresStore) GetErasureInfo(ctx context.Context, filePath string) (*m
 		return nil, fmt.Errorf("failed to get erasure profile: %w", err)
 	}
 
-	rows, err := s.db.QueryContext(ctx,
+	rows, err := s.q.QueryContext(ctx,
 		`SELECT shard_index, instance_id, backend_type, shard_path, shard_size, checksum
 		 FROM erasure_shards WHERE file_path = $1 ORDER BY shard_index`, filePath,
 	)
@@ -4,7 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"path"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/lib/pq"
 
@@ -24,33 +26,39 @@ func (s *PostgresStore) Get(ctx context.Context, path string) (*metadata.Metadat
 	var parentID sql.NullInt64
 	var callfsInstanceID sql.NullString
 	var symlinkTarget sql.NullString
+	var retainUntil sql.NullTime
 
 	query := `
 		SELECT id, parent_id, name, path, type, size, mode, uid, gid, 
 		       atime, mtime, ctime, backend_type, callfs_instance_id,
-		       symlink_target, created_at, updated_at
+		       symlink_target, checksum, immutable, retain_until, created_at, updated_at
 		FROM inodes
 		WHERE path = $1`
 
-	err := s.db.QueryRowContext(ctx, query, path).Scan(
-		&md.ID,
-		&parentID,
-		&md.Name,
-		&md.Path,
-		&md.Type,
-		&md.Size,
-		&md.Mode,
-		&md.UID,
-		&md.GID,
-		&md.ATime,
-		&md.MTime,
-		&md.CTime,
-		&md.BackendType,
-		&callfsInstanceID,
-		&symlinkTarget,
-		&md.CreatedAt,
-		&md.UpdatedAt,
-	)
+	err := s.read(ctx, func(q dbExecutor) error {
+		return q.QueryRowContext(ctx, query, path).Scan(
+			&md.ID,
+			&parentID,
+			&md.Name,
+			&md.Path,
+			&md.Type,
+			&md.Size,
+			&md.Mode,
+			&md.UID,
+			&md.GID,
+			&md.ATime,
+			&md.MTime,
+			&md.CTime,
+			&md.BackendType,
+			&callfsInstanceID,
+			&symlinkTarget,
+			&md.Checksum,
+			&md.Immutable,
+			&retainUntil,
+			&md.CreatedAt,
+			&md.UpdatedAt,
+		)
+	})
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -69,12 +77,20 @@ func (s *PostgresStore) Get(ctx context.Context, path string) (*metadata.Metadat
 	if symlinkTarget.Valid {
 		md.SymlinkTarget = &symlinkTarget.String
 	}
+	if retainUntil.Valid {
+		md.RetainUntil = &retainUntil.Time
+	}
 
 	return &md, nil
 }
 
 // Create creates a new inode entry
 func (s *PostgresStore) Create(ctx context.Context, md *metadata.Metadata) error {
+	defer s.wrote()
+	return createInode(ctx, s.q, md)
+}
+
+func createInode(ctx context.Context, db dbExecutor, md *metadata.Metadata) error {
 	var parentID sql.NullInt64
 	var callfsInstanceID sql.NullString
 	var symlinkTarget sql.NullString
@@ -89,7 +105,7 @@ func (s *PostgresStore) Create(ctx context.Context, md *metadata.Metadata) error
 		symlinkTarget = sql.NullString{String: *md.SymlinkTarget, Valid: true}
 	}
 
-	err := s.db.QueryRowContext(ctx, _SQL_CREATE_INODE,
+	err := db.QueryRowContext(ctx, _SQL_CREATE_INODE,
 		parentID,
 		md.Name,
 		md.Path,
@@ -104,6 +120,9 @@ func (s *PostgresStore) Create(ctx context.Context, md *metadata.Metadata) error
 		md.BackendType,
 		callfsInstanceID,
 		symlinkTarget,
+		md.Checksum,
+		md.Immutable,
+		md.RetainUntil,
 	).Scan(&md.ID, &md.CreatedAt, &md.UpdatedAt)
 
 	if err != nil {
@@ -118,6 +137,11 @@ func (s *PostgresStore) Create(ctx context.Context, md *metadata.Metadata) error
 
 // Update updates an existing inode
 func (s *PostgresStore) Update(ctx context.Context, md *metadata.Metadata) error {
+	defer s.wrote()
+	return updateInode(ctx, s.q, md)
+}
+
+func updateInode(ctx context.Context, db dbExecutor, md *metadata.Metadata) error {
 	var callfsInstanceID sql.NullString
 	var symlinkTarget sql.NullString
 
@@ -128,7 +152,7 @@ func (s *PostgresStore) Update(ctx context.Context, md *metadata.Metadata) error
 		symlinkTarget = sql.NullString{String: *md.SymlinkTarget, Valid: true}
 	}
 
-	result, err := s.db.ExecContext(ctx, _SQL_UPDATE_INODE,
+	result, err := db.ExecContext(ctx, _SQL_UPDATE_INODE,
 		md.Size,
 		md.Mode,
 		md.UID,
@@ -139,6 +163,9 @@ func (s *PostgresStore) Update(ctx context.Context, md *metadata.Metadata) error
 		md.BackendType,
 		callfsInstanceID,
 		symlinkTarget,
+		md.Checksum,
+		md.Immutable,
+		md.RetainUntil,
 		md.Path,
 	)
 
@@ -159,9 +186,12 @@ func (s *PostgresStore) Update(ctx context.Context, md *metadata.Metadata) error
 
 // Delete removes an inode by path
 func (s *PostgresStore) Delete(ctx context.Context, path string) error {
-	query := `DELETE FROM inodes WHERE path = $1`
+	defer s.wrote()
+	return deleteInode(ctx, s.q, path)
+}
 
-	result, err := s.db.ExecContext(ctx, query, path)
+func deleteInode(ctx context.Context, db dbExecutor, path string) error {
+	result, err := db.ExecContext(ctx, _SQL_DELETE_INODE, path)
 	if err != nil {
 		return fmt.Errorf("failed to delete metadata: %w", err)
 	}
@@ -178,46 +208,237 @@ func (s *PostgresStore) Delete(ctx context.Context, path string) error {
 	return nil
 }
 
+// Batch applies ops in a single transaction
+func (s *PostgresStore) Batch(ctx context.Context, ops []metadata.BatchOp) error {
+	defer s.wrote()
+	if err := metadata.ValidateBatch(ops); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, op := range ops {
+		switch op.Type {
+		case metadata.BatchCreate:
+			err = createInode(ctx, tx, op.Metadata)
+		case metadata.BatchUpdate:
+			err = updateInode(ctx, tx, op.Metadata)
+		case metadata.BatchDelete:
+			err = deleteInode(ctx, tx, op.Path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
 // ListChildren lists all direct children of a directory
 func (s *PostgresStore) ListChildren(ctx context.Context, parentPath string) ([]*metadata.Metadata, error) {
 	query := `
 		SELECT id, parent_id, name, path, type, size, mode, uid, gid,
 		       atime, mtime, ctime, backend_type, callfs_instance_id,
-		       symlink_target, created_at, updated_at
+		       symlink_target, checksum, immutable, retain_until, created_at, updated_at
 		FROM inodes
 		WHERE path LIKE $1 || '/%' ESCAPE '\' AND path NOT LIKE $1 || '/%/%' ESCAPE '\'
 		ORDER BY type DESC, name ASC`
 
-	var (
-		rows *sql.Rows
-		err  error
-	)
+	rootQuery := `
+		SELECT id, parent_id, name, path, type, size, mode, uid, gid,
+		       atime, mtime, ctime, backend_type, callfs_instance_id,
+		       symlink_target, checksum, immutable, retain_until, created_at, updated_at
+		FROM inodes
+		WHERE path LIKE '/%' AND path NOT LIKE '/%/%' AND path != '/'
+		ORDER BY type DESC, name ASC`
 
-	if parentPath == "/" {
-		rootQuery := `
-			SELECT id, parent_id, name, path, type, size, mode, uid, gid,
-			       atime, mtime, ctime, backend_type, callfs_instance_id,
-			       symlink_target, created_at, updated_at
-			FROM inodes
-			WHERE path LIKE '/%' AND path NOT LIKE '/%/%' AND path != '/'
-			ORDER BY type DESC, name ASC`
-		rows, err = s.db.QueryContext(ctx, rootQuery)
-	} else {
-		// Escape LIKE metacharacters to prevent wildcard injection
-		escapedPath := escapeLikePattern(parentPath)
-		rows, err = s.db.QueryContext(ctx, query, escapedPath)
-	}
+	var items []*metadata.Metadata
+	err := s.read(ctx, func(q dbExecutor) error {
+		var (
+			rows *sql.Rows
+			err  error
+		)
+		if parentPath == "/" {
+			rows, err = q.QueryContext(ctx, rootQuery)
+		} else {
+			// Escape LIKE metacharacters to prevent wildcard injection
+			rows, err = q.QueryContext(ctx, query, escapeLikePattern(parentPath))
+		}
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		items, err = scanInodeRows(rows)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list children: %w", err)
 	}
-	defer rows.Close()
+	return items, nil
+}
 
-	var children []*metadata.Metadata
+// ListDescendants lists every entry below prefix in path order using a single
+// indexed prefix scan
+func (s *PostgresStore) ListDescendants(ctx context.Context, prefix string, limit int, cursor string) ([]*metadata.Metadata, error) {
+	query := `
+		SELECT id, parent_id, name, path, type, size, mode, uid, gid,
+		       atime, mtime, ctime, backend_type, callfs_instance_id,
+		       symlink_target, checksum, immutable, retain_until, created_at, updated_at
+		FROM inodes
+		WHERE path LIKE $1 ESCAPE '\' AND path != '/' AND path > $2
+		ORDER BY path ASC
+		LIMIT $3`
+
+	pattern := escapeLikePattern(strings.TrimSuffix(prefix, "/")) + "/%"
+
+	var maxRows sql.NullInt64
+	if limit > 0 {
+		maxRows = sql.NullInt64{Int64: int64(limit), Valid: true}
+	}
+
+	var items []*metadata.Metadata
+	err := s.read(ctx, func(q dbExecutor) error {
+		rows, err := q.QueryContext(ctx, query, pattern, cursor, maxRows)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		items, err = scanInodeRows(rows)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list descendants: %w", err)
+	}
+	return items, nil
+}
+
+// Usage counts inodes and file bytes with one grouped scan
+func (s *PostgresStore) Usage(ctx context.Context) (*metadata.Usage, error) {
+	query := `
+		SELECT type, backend_type, COALESCE(callfs_instance_id, ''), COUNT(*), COALESCE(SUM(size), 0)
+		FROM inodes
+		GROUP BY type, backend_type, callfs_instance_id`
+
+	var counter metadata.UsageCounter
+	err := s.read(ctx, func(q dbExecutor) error {
+		counter = metadata.UsageCounter{}
+		rows, err := q.QueryContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var entryType, backendType, instanceID string
+			var count, bytes int64
+			if err := rows.Scan(&entryType, &backendType, &instanceID, &count, &bytes); err != nil {
+				return err
+			}
+			counter.AddGroup(entryType, backendType, instanceID, count, bytes)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count inodes: %w", err)
+	}
+	return counter.Usage(), nil
+}
+
+// DirectoryUsage counts the children of path and the bytes below it with one
+// indexed prefix scan
+func (s *PostgresStore) DirectoryUsage(ctx context.Context, path string) (*metadata.DirectoryUsage, error) {
+	query := `
+		SELECT COUNT(*) FILTER (WHERE strpos(substr(path, $2), '/') = 0),
+		       COALESCE(SUM(size) FILTER (WHERE type = 'file'), 0)
+		FROM inodes
+		WHERE path LIKE $1 ESCAPE '\' AND path != '/'`
+
+	base := strings.TrimSuffix(path, "/")
+	var usage metadata.DirectoryUsage
+	err := s.read(ctx, func(q dbExecutor) error {
+		return q.QueryRowContext(ctx, query, escapeLikePattern(base)+"/%", len(base)+2).Scan(&usage.Children, &usage.Bytes)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count directory usage: %w", err)
+	}
+	return &usage, nil
+}
+
+// RenameSubtree rewrites the path prefix of oldPath's subtree, and of its
+// erasure coding rows, in one transaction
+func (s *PostgresStore) RenameSubtree(ctx context.Context, oldPath, newPath string) error {
+	defer s.wrote()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM inodes WHERE path = $1)`, newPath).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check rename target: %w", err)
+	}
+	if exists {
+		return metadata.ErrAlreadyExists
+	}
+
+	// substr counts characters, so the prefix is skipped by its rune count
+	from := utf8.RuneCountInString(oldPath) + 1
+	pattern := escapeLikePattern(oldPath) + "/%"
+	result, err := tx.ExecContext(ctx, `
+		UPDATE inodes
+		SET path = $1 || substr(path, $2),
+		    name = CASE WHEN path = $3 THEN $4 ELSE name END
+		WHERE path = $3 OR path LIKE $5 ESCAPE '\'`,
+		newPath, from, oldPath, path.Base(newPath), pattern)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return metadata.ErrAlreadyExists
+		}
+		return fmt.Errorf("failed to rename metadata: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	} else if n == 0 {
+		return metadata.ErrNotFound
+	}
+
+	for _, table := range []string{"erasure_profiles", "erasure_shards"} {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE `+table+`
+			SET file_path = $1 || substr(file_path, $2)
+			WHERE file_path = $3 OR file_path LIKE $4 ESCAPE '\'`,
+			newPath, from, oldPath, pattern)
+		if err != nil {
+			return fmt.Errorf("failed to rename erasure metadata: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// scanInodeRows scans inode rows selected with the standard column list
+func scanInodeRows(rows *sql.Rows) ([]*metadata.Metadata, error) {
+	var items []*metadata.Metadata
 	for rows.Next() {
 		var md metadata.Metadata
 		var parentID sql.NullInt64
 		var callfsInstanceID sql.NullString
 		var symlinkTarget sql.NullString
+		var retainUntil sql.NullTime
 
 		err := rows.Scan(
 			&md.ID,
@@ -235,6 +456,9 @@ func (s *PostgresStore) ListChildren(ctx context.Context, parentPath string) ([]
 			&md.BackendType,
 			&callfsInstanceID,
 			&symlinkTarget,
+			&md.Checksum,
+			&md.Immutable,
+			&retainUntil,
 			&md.CreatedAt,
 			&md.UpdatedAt,
 		)
@@ -252,13 +476,16 @@ func (s *PostgresStore) ListChildren(ctx context.Context, parentPath string) ([]
 		if symlinkTarget.Valid {
 			md.SymlinkTarget = &symlinkTarget.String
 		}
+		if retainUntil.Valid {
+			md.RetainUntil = &retainUntil.Time
+		}
 
-		children = append(children, &md)
+		items = append(items, &md)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("failed to iterate rows: %w", err)
 	}
 
-	return children, nil
+	return items, nil
 }
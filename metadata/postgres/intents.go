@@ -0,0 +1,55 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// RecordIntent stores an intent in the journal.
+func (s *PostgresStore) RecordIntent(ctx context.Context, intent *metadata.Intent) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO intents (id, instance_id, op, path, backend_type, owner, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		intent.ID, intent.InstanceID, intent.Op, intent.Path, intent.BackendType, intent.Owner, intent.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record intent: %w", err)
+	}
+	return nil
+}
+
+// ClearIntent removes an intent from the journal.
+func (s *PostgresStore) ClearIntent(ctx context.Context, intent *metadata.Intent) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM intents WHERE id = $1`, intent.ID); err != nil {
+		return fmt.Errorf("failed to clear intent: %w", err)
+	}
+	return nil
+}
+
+// ListIntents returns the intents recorded by an instance, oldest first. The
+// journal is always read from the primary.
+func (s *PostgresStore) ListIntents(ctx context.Context, instanceID string) ([]*metadata.Intent, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, instance_id, op, path, backend_type, owner, created_at
+		 FROM intents WHERE instance_id = $1 ORDER BY created_at, id`, instanceID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query intents: %w", err)
+	}
+	defer rows.Close()
+
+	var intents []*metadata.Intent
+	for rows.Next() {
+		var intent metadata.Intent
+		if err := rows.Scan(&intent.ID, &intent.InstanceID, &intent.Op, &intent.Path, &intent.BackendType, &intent.Owner, &intent.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan intent: %w", err)
+		}
+		intents = append(intents, &intent)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate intents: %w", err)
+	}
+	return intents, nil
+}
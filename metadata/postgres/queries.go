@@ -7,7 +7,7 @@ const (
 	_SQL_GET_INODE_BY_PATH = `
 		SELECT id, parent_id, name, path, type, size, mode, uid, gid, 
 		       atime, mtime, ctime, backend_type, callfs_instance_id, 
-		       symlink_target, created_at, updated_at
+		       symlink_target, checksum, immutable, retain_until, created_at, updated_at
 		FROM inodes 
 		WHERE path = $1`
 
@@ -15,16 +15,17 @@ const (
 	_SQL_CREATE_INODE = `
 		INSERT INTO inodes 
 		(parent_id, name, path, type, size, mode, uid, gid, atime, mtime, ctime, 
-		 backend_type, callfs_instance_id, symlink_target)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		 backend_type, callfs_instance_id, symlink_target, checksum, immutable, retain_until)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
 		RETURNING id, created_at, updated_at`
 
 	// _SQL_UPDATE_INODE updates an existing inode entry
 	_SQL_UPDATE_INODE = `
 		UPDATE inodes 
 		SET size = $1, mode = $2, uid = $3, gid = $4, atime = $5, mtime = $6, 
-		    ctime = $7, backend_type = $8, callfs_instance_id = $9, symlink_target = $10
-		WHERE path = $11`
+		    ctime = $7, backend_type = $8, callfs_instance_id = $9, symlink_target = $10,
+		    checksum = $11, immutable = $12, retain_until = $13
+		WHERE path = $14`
 
 	// _SQL_DELETE_INODE deletes an inode entry by path
 	_SQL_DELETE_INODE = `
@@ -35,7 +36,7 @@ const (
 	_SQL_LIST_CHILDREN = `
 		SELECT id, parent_id, name, path, type, size, mode, uid, gid, 
 		       atime, mtime, ctime, backend_type, callfs_instance_id, 
-		       symlink_target, created_at, updated_at
+		       symlink_target, checksum, immutable, retain_until, created_at, updated_at
 		FROM inodes 
 		WHERE path LIKE $1 || '%' AND path != $1 
 		  AND position('/' in substring(path from length($1) + 2)) = 0
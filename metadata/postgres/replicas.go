@@ -0,0 +1,219 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/metadata"
+	"github.com/ebogdum/callfs/metrics"
+)
+
+// replicaLagQuery reports replay lag in seconds. A replica that has replayed
+// everything it received counts as current even when the primary is idle,
+// and a server that is not in recovery reports NULL.
+const replicaLagQuery = `
+	SELECT CASE
+		WHEN NOT pg_is_in_recovery() THEN NULL
+		WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())
+	END`
+
+// replica is one read-only connection pool
+type replica struct {
+	name    string
+	db      *sql.DB
+	q       dbExecutor
+	stmts   *stmtCache
+	healthy atomic.Bool
+}
+
+// replicaSet spreads reads over healthy replicas and tracks their health
+type replicaSet struct {
+	replicas []*replica
+	next     atomic.Uint64
+	maxLag   time.Duration
+	logger   *zap.Logger
+	stop     chan struct{}
+	wg       sync.WaitGroup
+
+	// Reads within followWindow of a write by this instance go to the
+	// primary, as replicas may not have replayed it yet
+	lastWrite    atomic.Int64 // Unix nanoseconds
+	followWindow time.Duration
+}
+
+func newReplicaSet(dsns []string, opts Options, logger *zap.Logger) (*replicaSet, error) {
+	set := &replicaSet{
+		maxLag: opts.ReplicaMaxLag,
+		logger: logger,
+		stop:   make(chan struct{}),
+	}
+	for i, dsn := range dsns {
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			set.close()
+			return nil, fmt.Errorf("failed to open read replica %d: %w", i, err)
+		}
+		db.SetMaxOpenConns(opts.MaxOpenConns)
+		db.SetMaxIdleConns(opts.MaxIdleConns)
+		db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+		db.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
+
+		r := &replica{name: fmt.Sprintf("replica-%d", i), db: db, q: db}
+		if opts.StatementCache {
+			r.stmts = newStmtCache(db)
+			r.q = r.stmts
+		}
+		metrics.RegisterDBStats(db, "postgres_"+r.name)
+		metrics.MetadataReplicaHealthy.WithLabelValues(r.name).Set(0)
+		set.replicas = append(set.replicas, r)
+	}
+
+	// Start with a synchronous check so an unreachable replica is never used
+	set.checkAll()
+	interval := opts.ReplicaCheckInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	// A replica in rotation lags at most maxLag, plus what it fell behind
+	// since its last check
+	set.followWindow = set.maxLag + interval
+	set.wg.Add(1)
+	go set.monitor(interval)
+
+	return set, nil
+}
+
+// pick returns the next healthy replica in round-robin order, or nil
+func (rs *replicaSet) pick() *replica {
+	n := len(rs.replicas)
+	start := rs.next.Add(1)
+	for i := 0; i < n; i++ {
+		r := rs.replicas[(start+uint64(i))%uint64(n)]
+		if r.healthy.Load() {
+			return r
+		}
+	}
+	return nil
+}
+
+func (rs *replicaSet) monitor(interval time.Duration) {
+	defer rs.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rs.checkAll()
+		case <-rs.stop:
+			return
+		}
+	}
+}
+
+func (rs *replicaSet) checkAll() {
+	for _, r := range rs.replicas {
+		rs.setHealthy(r, rs.check(r))
+	}
+}
+
+// check pings a replica and verifies its replay lag is within bounds
+func (rs *replicaSet) check(r *replica) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var lag sql.NullFloat64
+	if err := r.db.QueryRowContext(ctx, replicaLagQuery).Scan(&lag); err != nil {
+		return err
+	}
+	if rs.maxLag > 0 && lag.Valid && time.Duration(lag.Float64*float64(time.Second)) > rs.maxLag {
+		return fmt.Errorf("replication lag %.1fs exceeds %s", lag.Float64, rs.maxLag)
+	}
+	return nil
+}
+
+// setHealthy records a health transition, logging only on change
+func (rs *replicaSet) setHealthy(r *replica, err error) {
+	healthy := err == nil
+	if r.healthy.Swap(healthy) == healthy {
+		return
+	}
+	if healthy {
+		rs.logger.Info("PostgreSQL read replica in rotation", zap.String("replica", r.name))
+		metrics.MetadataReplicaHealthy.WithLabelValues(r.name).Set(1)
+	} else {
+		rs.logger.Warn("PostgreSQL read replica removed from rotation", zap.String("replica", r.name), zap.Error(err))
+		metrics.MetadataReplicaHealthy.WithLabelValues(r.name).Set(0)
+	}
+}
+
+func (rs *replicaSet) close() {
+	select {
+	case <-rs.stop:
+	default:
+		close(rs.stop)
+	}
+	rs.wg.Wait()
+	for _, r := range rs.replicas {
+		if r.stmts != nil {
+			r.stmts.Close()
+		}
+		r.db.Close()
+	}
+}
+
+// wrote records a write by this instance, sending reads that follow it to
+// the primary
+func (s *PostgresStore) wrote() {
+	if s.replicas != nil {
+		s.replicas.lastWrite.Store(time.Now().UnixNano())
+	}
+}
+
+// followsWrite reports whether this instance wrote recently enough that a
+// replica may not have the write yet
+func (rs *replicaSet) followsWrite() bool {
+	return time.Since(time.Unix(0, rs.lastWrite.Load())) < rs.followWindow
+}
+
+// read runs fn against a healthy replica when one is configured, falling
+// back to the primary if none is available or the replica query fails.
+// Linearizable reads, and reads following a write by this instance, go to
+// the primary, which never lags. A path a replica does not hold is looked
+// up again on the primary, as it may have been created since.
+func (s *PostgresStore) read(ctx context.Context, fn func(q dbExecutor) error) error {
+	if s.replicas == nil || metadata.ReadConsistencyFromContext(ctx) == metadata.ConsistencyLinearizable {
+		return fn(s.q)
+	}
+
+	var r *replica
+	if !s.replicas.followsWrite() {
+		r = s.replicas.pick()
+	}
+	if r == nil {
+		metrics.MetadataReplicaReadsTotal.WithLabelValues("primary").Inc()
+		return fn(s.q)
+	}
+
+	err := fn(r.q)
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, metadata.ErrNotFound) {
+		metrics.MetadataReplicaReadsTotal.WithLabelValues("not_found").Inc()
+		return fn(s.q)
+	}
+	if err == nil || ctx.Err() != nil {
+		metrics.MetadataReplicaReadsTotal.WithLabelValues("replica").Inc()
+		return err
+	}
+
+	// Take the replica out of rotation until the monitor sees it healthy again
+	s.replicas.setHealthy(r, err)
+	metrics.MetadataReplicaReadsTotal.WithLabelValues("failover").Inc()
+	return fn(s.q)
+}
@@ -8,21 +8,68 @@ import (
 
 	"go.uber.org/zap"
 
+	corelog "github.com/ebogdum/callfs/core/log"
 	"github.com/ebogdum/callfs/metadata"
 )
 
 // GetSingleUseLink retrieves a single-use link by token
 func (s *PostgresStore) GetSingleUseLink(ctx context.Context, token string) (*metadata.SingleUseLink, error) {
-	var link metadata.SingleUseLink
-	var usedAt sql.NullTime
-	var usedByIP sql.NullString
-
 	query := `
 		SELECT token, file_path, created_at, expires_at, status, used_at, used_by_ip, hmac_signature
 		FROM single_use_links
 		WHERE token = $1`
 
-	err := s.db.QueryRowContext(ctx, query, token).Scan(
+	link, err := scanSingleUseLink(s.q.QueryRowContext(ctx, query, token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, metadata.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get single-use link: %w", err)
+	}
+
+	return link, nil
+}
+
+// ListSingleUseLinks returns links ordered by token, starting after cursor
+func (s *PostgresStore) ListSingleUseLinks(ctx context.Context, limit int, cursor string) ([]*metadata.SingleUseLink, error) {
+	query := `
+		SELECT token, file_path, created_at, expires_at, status, used_at, used_by_ip, hmac_signature
+		FROM single_use_links
+		WHERE token > $1
+		ORDER BY token`
+	args := []any{cursor}
+	if limit > 0 {
+		query += ` LIMIT $2`
+		args = append(args, limit)
+	}
+
+	rows, err := s.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list single-use links: %w", err)
+	}
+	defer rows.Close()
+
+	var links []*metadata.SingleUseLink
+	for rows.Next() {
+		link, err := scanSingleUseLink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan single-use link: %w", err)
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate single-use links: %w", err)
+	}
+
+	return links, nil
+}
+
+func scanSingleUseLink(row interface{ Scan(...any) error }) (*metadata.SingleUseLink, error) {
+	var link metadata.SingleUseLink
+	var usedAt sql.NullTime
+	var usedByIP sql.NullString
+
+	err := row.Scan(
 		&link.Token,
 		&link.FilePath,
 		&link.CreatedAt,
@@ -32,12 +79,8 @@ func (s *PostgresStore) GetSingleUseLink(ctx context.Context, token string) (*me
 		&usedByIP,
 		&link.HMACSignature,
 	)
-
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, metadata.ErrNotFound
-		}
-		return nil, fmt.Errorf("failed to get single-use link: %w", err)
+		return nil, err
 	}
 
 	// Handle nullable fields
@@ -57,7 +100,7 @@ func (s *PostgresStore) CreateSingleUseLink(ctx context.Context, link *metadata.
 		INSERT INTO single_use_links (token, file_path, created_at, expires_at, status, hmac_signature)
 		VALUES ($1, $2, $3, $4, $5, $6)`
 
-	_, err := s.db.ExecContext(ctx, query,
+	_, err := s.q.ExecContext(ctx, query,
 		link.Token,
 		link.FilePath,
 		link.CreatedAt,
@@ -85,7 +128,7 @@ func (s *PostgresStore) UpdateSingleUseLink(ctx context.Context, token string, s
 		usedByIPArg = sql.NullString{String: *usedByIP, Valid: true}
 	}
 
-	result, err := s.db.ExecContext(ctx, _SQL_UPDATE_SINGLE_USE_LINK, token, status, usedAtArg, usedByIPArg)
+	result, err := s.q.ExecContext(ctx, _SQL_UPDATE_SINGLE_USE_LINK, token, status, usedAtArg, usedByIPArg)
 	if err != nil {
 		return fmt.Errorf("failed to update single-use link: %w", err)
 	}
@@ -106,7 +149,7 @@ func (s *PostgresStore) UpdateSingleUseLink(ctx context.Context, token string, s
 func (s *PostgresStore) CleanupExpiredLinks(ctx context.Context, before time.Time) (int, error) {
 	query := `DELETE FROM single_use_links WHERE expires_at < $1`
 
-	result, err := s.db.ExecContext(ctx, query, before)
+	result, err := s.q.ExecContext(ctx, query, before)
 	if err != nil {
 		return 0, fmt.Errorf("failed to cleanup expired links: %w", err)
 	}
@@ -116,7 +159,7 @@ func (s *PostgresStore) CleanupExpiredLinks(ctx context.Context, before time.Tim
 		return 0, fmt.Errorf("failed to get rows affected: %w", err)
 	}
 
-	s.logger.Debug("Cleaned up expired single-use links",
+	corelog.WithContext(ctx, s.logger).Debug("Cleaned up expired single-use links",
 		zap.Int64("count", rowsAffected),
 		zap.Time("before", before))
 
@@ -127,7 +170,7 @@ func (s *PostgresStore) CleanupExpiredLinks(ctx context.Context, before time.Tim
 func (s *PostgresStore) CleanupUsedLinks(ctx context.Context, olderThan time.Time) (int, error) {
 	query := `DELETE FROM single_use_links WHERE status = 'used' AND used_at < $1`
 
-	result, err := s.db.ExecContext(ctx, query, olderThan)
+	result, err := s.q.ExecContext(ctx, query, olderThan)
 	if err != nil {
 		return 0, fmt.Errorf("failed to cleanup used links: %w", err)
 	}
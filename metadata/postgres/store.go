@@ -8,25 +8,54 @@ import (
 
 	_ "github.com/lib/pq"
 	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/metrics"
 )
 
 // PostgresStore implements the metadata.Store interface using PostgreSQL
 type PostgresStore struct {
-	db     *sql.DB
-	logger *zap.Logger
+	db       *sql.DB
+	q        dbExecutor // db, or a statement cache in front of it
+	stmts    *stmtCache
+	replicas *replicaSet // nil when no read replicas are configured
+	logger   *zap.Logger
+}
+
+// Options tunes the connection pool and statement handling of a PostgresStore
+type Options struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	StatementCache  bool
+
+	// ReplicaDSNs lists read replicas that serve Get, ListChildren and
+	// ListDescendants. Writes, links and erasure info always use the primary.
+	ReplicaDSNs          []string
+	ReplicaMaxLag        time.Duration // replicas lagging further are skipped (0 = no limit)
+	ReplicaCheckInterval time.Duration
+}
+
+// dbExecutor is satisfied by *sql.DB, *sql.Tx and stmtCache, so inode writes
+// can run standalone, through cached statements or inside a Batch transaction
+type dbExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
 
 // NewPostgresStore creates a new PostgreSQL metadata store
-func NewPostgresStore(dsn string, logger *zap.Logger) (*PostgresStore, error) {
+func NewPostgresStore(dsn string, opts Options, logger *zap.Logger) (*PostgresStore, error) {
 	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
 	// Configure connection pool
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(25)
-	db.SetConnMaxLifetime(5 * time.Minute)
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	db.SetMaxIdleConns(opts.MaxIdleConns)
+	db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
 
 	// Test connection
 	if err := db.PingContext(context.Background()); err != nil {
@@ -34,13 +63,49 @@ func NewPostgresStore(dsn string, logger *zap.Logger) (*PostgresStore, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	return &PostgresStore{
+	store := &PostgresStore{
 		db:     db,
+		q:      db,
 		logger: logger,
-	}, nil
+	}
+	if opts.StatementCache {
+		store.stmts = newStmtCache(db)
+		store.q = store.stmts
+	}
+
+	metrics.RegisterDBStats(db, "postgres")
+
+	if len(opts.ReplicaDSNs) > 0 {
+		replicas, err := newReplicaSet(opts.ReplicaDSNs, opts, logger)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		store.replicas = replicas
+	}
+
+	logger.Info("PostgreSQL metadata store connected",
+		zap.Int("max_open_conns", opts.MaxOpenConns),
+		zap.Int("max_idle_conns", opts.MaxIdleConns),
+		zap.Duration("conn_max_lifetime", opts.ConnMaxLifetime),
+		zap.Bool("statement_cache", opts.StatementCache),
+		zap.Int("read_replicas", len(opts.ReplicaDSNs)))
+
+	return store, nil
+}
+
+// Ping verifies the database connection is alive
+func (s *PostgresStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
 }
 
 // Close closes the database connection
 func (s *PostgresStore) Close() error {
+	if s.replicas != nil {
+		s.replicas.close()
+	}
+	if s.stmts != nil {
+		s.stmts.Close()
+	}
 	return s.db.Close()
 }
@@ -0,0 +1,101 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// AddAccessStats adds the counts of each entry to those stored for its path
+// via Raft consensus.
+func (s *Store) AddAccessStats(ctx context.Context, stats []*metadata.AccessStats) error {
+	_, err := s.applyCommand(ctx, Command{Op: "add_access_stats", AccessStats: stats})
+	return err
+}
+
+// GetAccessStats returns the access statistics of path from the local FSM
+// store.
+func (s *Store) GetAccessStats(ctx context.Context, path string) (*metadata.AccessStats, error) {
+	if err := s.readBarrier(ctx); err != nil {
+		return nil, err
+	}
+	var st metadata.AccessStats
+	if err := s.fsm.get(bucketAccess, path, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// ListAccessStats returns the access statistics of the paths below prefix
+// from the local FSM store, ordered by path.
+func (s *Store) ListAccessStats(ctx context.Context, prefix string, limit int, cursor string) ([]*metadata.AccessStats, error) {
+	if err := s.readBarrier(ctx); err != nil {
+		return nil, err
+	}
+	return s.fsm.listAccessStats(prefix, limit, cursor)
+}
+
+// DeleteAccessStats removes the access statistics of path and of the paths
+// below it via Raft consensus.
+func (s *Store) DeleteAccessStats(ctx context.Context, path string) error {
+	_, err := s.applyCommand(ctx, Command{Op: "delete_access_stats", Path: path})
+	return err
+}
+
+func (f *fsm) listAccessStats(prefix string, limit int, cursor string) ([]*metadata.AccessStats, error) {
+	base := []byte(strings.TrimSuffix(prefix, "/") + "/")
+	start := base
+	if cursor > string(base) {
+		start = []byte(cursor)
+	}
+
+	stats := make([]*metadata.AccessStats, 0)
+	err := f.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketAccess).Cursor()
+		for k, v := c.Seek(start); k != nil && bytes.HasPrefix(k, base); k, v = c.Next() {
+			if string(k) == cursor {
+				continue
+			}
+			var st metadata.AccessStats
+			if err := json.Unmarshal(v, &st); err != nil {
+				return err
+			}
+			stats = append(stats, &st)
+			if limit > 0 && len(stats) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// addAccessStats adds each entry of stats to the one stored for its path
+func addAccessStats(b *bolt.Bucket, stats []*metadata.AccessStats) CommandResult {
+	for _, delta := range stats {
+		st := metadata.AccessStats{Path: delta.Path}
+		if _, err := getJSON(b, delta.Path, &st); err != nil {
+			return errResult(err)
+		}
+		st.Add(delta)
+		if res := putResult(b, delta.Path, &st); res.Err != "" {
+			return res
+		}
+	}
+	return CommandResult{}
+}
+
+// deleteAccessStats removes the entries of path and of the paths below it
+func deleteAccessStats(b *bolt.Bucket, path string) CommandResult {
+	for _, k := range subtreeKeys(b, path) {
+		if err := b.Delete([]byte(k)); err != nil {
+			return errResult(err)
+		}
+	}
+	return CommandResult{}
+}
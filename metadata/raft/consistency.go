@@ -0,0 +1,126 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	corelog "github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// readIndexPollInterval is how often a linearizable read re-checks the
+// local applied index while catching up
+const readIndexPollInterval = 2 * time.Millisecond
+
+// ReadIndexResponse is returned by the leader's read-index endpoint
+type ReadIndexResponse struct {
+	Index    uint64 `json:"index"`
+	LeaderID string `json:"leader_id,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ReadIndex confirms leadership with a heartbeat round and returns the
+// commit index a linearizable read must observe. Only the leader can answer.
+func (s *Store) ReadIndex(ctx context.Context) (uint64, error) {
+	if !s.IsLeader() {
+		return 0, fmt.Errorf("not leader")
+	}
+	// Capture the commit index before verifying, so any write acknowledged
+	// before this read began is covered
+	index := s.raft.CommitIndex()
+	if err := s.raft.VerifyLeader().Error(); err != nil {
+		return 0, fmt.Errorf("failed to verify raft leadership: %w", err)
+	}
+	return index, nil
+}
+
+// readBarrier blocks until the local FSM is fresh enough for the consistency
+// requested on ctx. Eventual reads return immediately; linearizable reads
+// obtain a read index from the leader and wait for it to be applied locally.
+func (s *Store) readBarrier(ctx context.Context) error {
+	level := metadata.ReadConsistencyFromContext(ctx)
+	if level == metadata.ConsistencyDefault {
+		level = s.readConsistency
+	}
+	if level != metadata.ConsistencyLinearizable {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.applyTimeout)
+	defer cancel()
+
+	var index uint64
+	var err error
+	if s.IsLeader() {
+		index, err = s.ReadIndex(ctx)
+	} else {
+		index, err = s.fetchReadIndex(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	return s.waitApplied(ctx, index)
+}
+
+// waitApplied waits until the FSM store has applied index
+func (s *Store) waitApplied(ctx context.Context, index uint64) error {
+	ticker := time.NewTicker(readIndexPollInterval)
+	defer ticker.Stop()
+	for {
+		applied, err := s.fsm.appliedIndex()
+		if err != nil {
+			return fmt.Errorf("failed to read applied index: %w", err)
+		}
+		if applied >= index {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for raft index %d (applied %d): %w", index, applied, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetchReadIndex asks the leader for its read index over the internal API
+func (s *Store) fetchReadIndex(ctx context.Context) (uint64, error) {
+	_, leaderID := s.raft.LeaderWithID()
+	if leaderID == "" {
+		return 0, fmt.Errorf("no raft leader available")
+	}
+	leaderEndpoint, ok := s.APIPeerEndpoint(string(leaderID))
+	if !ok || strings.TrimSpace(leaderEndpoint) == "" {
+		return 0, fmt.Errorf("leader endpoint not configured for node id %s", leaderID)
+	}
+
+	url := strings.TrimRight(leaderEndpoint, "/") + "/v1/internal/raft/read-index"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create read index request: %w", err)
+	}
+	if err := s.internalSigner.Sign(req); err != nil {
+		return 0, fmt.Errorf("failed to sign read index request: %w", err)
+	}
+	corelog.PropagateRequestID(ctx, req)
+
+	resp, err := s.forwardClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to request read index from leader: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		payload, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("leader read index failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(payload)))
+	}
+
+	var out ReadIndexResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return 0, fmt.Errorf("failed to decode read index response: %w", err)
+	}
+	return out.Index, nil
+}
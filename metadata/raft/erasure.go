@@ -16,15 +16,16 @@ func (s *Store) CreateErasureInfo(ctx context.Context, filePath string, info *me
 	return err
 }
 
-// GetErasureInfo retrieves erasure coding metadata from in-memory state.
+// GetErasureInfo retrieves erasure coding metadata from the local FSM store.
 func (s *Store) GetErasureInfo(ctx context.Context, filePath string) (*metadata.ErasureFileInfo, error) {
-	s.fsm.mu.RLock()
-	defer s.fsm.mu.RUnlock()
-	info, ok := s.fsm.state.ErasureByPath[filePath]
-	if !ok {
-		return nil, metadata.ErrNotFound
+	if err := s.readBarrier(ctx); err != nil {
+		return nil, err
 	}
-	return cloneErasureFileInfo(info), nil
+	var info metadata.ErasureFileInfo
+	if err := s.fsm.get(bucketErasure, filePath, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
 }
 
 // DeleteErasureInfo removes erasure coding metadata via Raft consensus.
@@ -47,11 +48,3 @@ func cloneErasureFileInfo(in *metadata.ErasureFileInfo) *metadata.ErasureFileInf
 	}
 	return &out
 }
-
-func cloneErasureMap(in map[string]*metadata.ErasureFileInfo) map[string]*metadata.ErasureFileInfo {
-	out := make(map[string]*metadata.ErasureFileInfo, len(in))
-	for k, v := range in {
-		out[k] = cloneErasureFileInfo(v)
-	}
-	return out
-}
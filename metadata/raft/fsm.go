@@ -0,0 +1,692 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	hashiraft "github.com/hashicorp/raft"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// FSM state lives in a bbolt database next to the raft log. Inodes are keyed
+// by path, so bbolt's sorted keys double as the path index and no per-inode
+// state is held in memory.
+var (
+	bucketMetadata = []byte("metadata")
+	bucketLinks    = []byte("links")
+	bucketErasure  = []byte("erasure")
+	bucketIntents  = []byte("intents")
+	bucketAccess   = []byte("access_stats")
+	bucketNodes    = []byte("nodes")
+	bucketFSMInfo  = []byte("fsm")
+
+	keyAppliedIndex = []byte("applied_index")
+
+	fsmBuckets = [][]byte{bucketMetadata, bucketLinks, bucketErasure, bucketIntents, bucketAccess, bucketNodes}
+)
+
+// snapshotVersion identifies the streamed per-key snapshot format
+const snapshotVersion = 2
+
+// restoreBatchSize is the number of records written per transaction while
+// restoring a snapshot
+const restoreBatchSize = 1000
+
+type fsm struct {
+	db     *bolt.DB
+	logger *zap.Logger
+}
+
+// snapshotHeader is the first record of a snapshot stream. Snapshots written
+// before the disk-backed FSM are a single JSON object holding every map, so
+// the legacy fields are decoded here too.
+type snapshotHeader struct {
+	Version      int    `json:"version"`
+	AppliedIndex uint64 `json:"applied_index"`
+
+	MetadataByPath map[string]json.RawMessage `json:"metadata_by_path,omitempty"`
+	LinksByToken   map[string]json.RawMessage `json:"links_by_token,omitempty"`
+	ErasureByPath  map[string]json.RawMessage `json:"erasure_by_path,omitempty"`
+}
+
+// snapshotRecord is one key of one bucket in a snapshot stream
+type snapshotRecord struct {
+	Bucket string          `json:"bucket"`
+	Key    string          `json:"key"`
+	Value  json.RawMessage `json:"value"`
+}
+
+// fsmSnapshot streams a read transaction, which bbolt keeps consistent while
+// later log entries are applied
+type fsmSnapshot struct {
+	tx *bolt.Tx
+}
+
+func openFSM(path string, logger *zap.Logger) (*fsm, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open raft fsm store: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range append(fsmBuckets, bucketFSMInfo) {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialise raft fsm store: %w", err)
+	}
+	return &fsm{db: db, logger: logger}, nil
+}
+
+// appliedIndex returns the index of the last log entry persisted to disk
+func (f *fsm) appliedIndex() (uint64, error) {
+	var index uint64
+	err := f.db.View(func(tx *bolt.Tx) error {
+		index = readAppliedIndex(tx)
+		return nil
+	})
+	return index, err
+}
+
+func readAppliedIndex(tx *bolt.Tx) uint64 {
+	v := tx.Bucket(bucketFSMInfo).Get(keyAppliedIndex)
+	if len(v) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+func writeAppliedIndex(tx *bolt.Tx, index uint64) error {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], index)
+	return tx.Bucket(bucketFSMInfo).Put(keyAppliedIndex, buf[:])
+}
+
+func (f *fsm) Close() error {
+	return f.db.Close()
+}
+
+func (f *fsm) Apply(log *hashiraft.Log) interface{} {
+	return f.ApplyBatch([]*hashiraft.Log{log})[0]
+}
+
+// ApplyBatch applies several committed entries in one bbolt transaction, so
+// raft's batching amortises the fsync. Entries at or below the persisted
+// applied index were already applied before a restart and are skipped.
+func (f *fsm) ApplyBatch(logs []*hashiraft.Log) []interface{} {
+	results := make([]interface{}, len(logs))
+	err := f.db.Update(func(tx *bolt.Tx) error {
+		applied := readAppliedIndex(tx)
+		for i, log := range logs {
+			if log.Index <= applied {
+				results[i] = CommandResult{}
+				continue
+			}
+			results[i] = f.applyLog(tx, log)
+			applied = log.Index
+		}
+		return writeAppliedIndex(tx, applied)
+	})
+	if err != nil {
+		f.logger.Error("Failed to persist raft fsm state", zap.Error(err))
+		for i := range results {
+			results[i] = CommandResult{Err: fmt.Sprintf("fsm_store_failed:%v", err)}
+		}
+	}
+	return results
+}
+
+func (f *fsm) applyLog(tx *bolt.Tx, log *hashiraft.Log) CommandResult {
+	var cmd Command
+	if err := json.Unmarshal(log.Data, &cmd); err != nil {
+		return CommandResult{Err: fmt.Sprintf("invalid_command:%v", err)}
+	}
+
+	inodes := tx.Bucket(bucketMetadata)
+	links := tx.Bucket(bucketLinks)
+	erasure := tx.Bucket(bucketErasure)
+
+	switch cmd.Op {
+	case "create_metadata":
+		if cmd.Metadata == nil {
+			return CommandResult{Err: "metadata_required"}
+		}
+		if inodes.Get([]byte(cmd.Metadata.Path)) != nil {
+			return CommandResult{Err: "already_exists"}
+		}
+		return putResult(inodes, cmd.Metadata.Path, cmd.Metadata)
+	case "update_metadata":
+		if cmd.Metadata == nil {
+			return CommandResult{Err: "metadata_required"}
+		}
+		if inodes.Get([]byte(cmd.Metadata.Path)) == nil {
+			return CommandResult{Err: "not_found"}
+		}
+		return putResult(inodes, cmd.Metadata.Path, cmd.Metadata)
+	case "delete_metadata":
+		if inodes.Get([]byte(cmd.Path)) == nil {
+			return CommandResult{Err: "not_found"}
+		}
+		return errResult(inodes.Delete([]byte(cmd.Path)))
+	case "batch":
+		return applyBatch(inodes, cmd.Batch)
+	case "rename_subtree":
+		return renameSubtree(inodes, erasure, cmd.Path, cmd.NewPath)
+	case "create_link":
+		if cmd.Link == nil {
+			return CommandResult{Err: "link_required"}
+		}
+		if links.Get([]byte(cmd.Link.Token)) != nil {
+			return CommandResult{Err: "already_exists"}
+		}
+		return putResult(links, cmd.Link.Token, cmd.Link)
+	case "update_link":
+		var link metadata.SingleUseLink
+		found, err := getJSON(links, cmd.Token, &link)
+		if err != nil {
+			return errResult(err)
+		}
+		// Only allow transitions from "active" status to prevent replay/reactivation
+		if !found || link.Status != "active" {
+			return CommandResult{Err: "not_found"}
+		}
+		link.Status = cmd.Status
+		link.UsedAt = cloneTimePtr(cmd.UsedAt)
+		link.UsedByIP = cloneStringPtr(cmd.UsedByIP)
+		link.UpdatedAt = time.Now().UTC()
+		return putResult(links, cmd.Token, &link)
+	case "cleanup_expired_links":
+		if cmd.Before == nil {
+			return CommandResult{Err: "before_required"}
+		}
+		return cleanupLinks(links, func(link *metadata.SingleUseLink) bool {
+			return link.Status == "active" && link.ExpiresAt.Before(*cmd.Before)
+		})
+	case "cleanup_used_links":
+		if cmd.OlderThan == nil {
+			return CommandResult{Err: "older_than_required"}
+		}
+		return cleanupLinks(links, func(link *metadata.SingleUseLink) bool {
+			return link.Status == "used" && link.UsedAt != nil && link.UsedAt.Before(*cmd.OlderThan)
+		})
+	case "create_erasure_info":
+		if cmd.ErasureInfo == nil {
+			return CommandResult{Err: "erasure_info_required"}
+		}
+		if erasure.Get([]byte(cmd.Path)) != nil {
+			return CommandResult{Err: "already_exists"}
+		}
+		return putResult(erasure, cmd.Path, cmd.ErasureInfo)
+	case "delete_erasure_info":
+		return errResult(erasure.Delete([]byte(cmd.Path)))
+	case "record_intent":
+		if cmd.Intent == nil {
+			return CommandResult{Err: "intent_required"}
+		}
+		return putResult(tx.Bucket(bucketIntents), intentKey(cmd.Intent.InstanceID, cmd.Intent.ID), cmd.Intent)
+	case "clear_intent":
+		return errResult(tx.Bucket(bucketIntents).Delete([]byte(cmd.Path)))
+	case "add_access_stats":
+		return addAccessStats(tx.Bucket(bucketAccess), cmd.AccessStats)
+	case "delete_access_stats":
+		return deleteAccessStats(tx.Bucket(bucketAccess), cmd.Path)
+	case "set_node":
+		if cmd.Node == nil {
+			return CommandResult{Err: "node_required"}
+		}
+		return putResult(tx.Bucket(bucketNodes), cmd.Node.NodeID, cmd.Node)
+	default:
+		return CommandResult{Err: "unknown_operation"}
+	}
+}
+
+// applyBatch applies ops in order and restores every touched path if any op fails
+func applyBatch(inodes *bolt.Bucket, ops []metadata.BatchOp) CommandResult {
+	original := make(map[string][]byte)
+	rollback := func() {
+		for path, raw := range original {
+			if raw == nil {
+				_ = inodes.Delete([]byte(path))
+			} else {
+				_ = inodes.Put([]byte(path), raw)
+			}
+		}
+	}
+
+	for _, op := range ops {
+		path := op.Path
+		if op.Metadata != nil {
+			path = op.Metadata.Path
+		}
+		current := inodes.Get([]byte(path))
+		if _, saved := original[path]; !saved {
+			// bbolt values are only valid for the transaction's lifetime
+			// and may move on write, so keep a copy
+			original[path] = bytes.Clone(current)
+		}
+
+		var res CommandResult
+		switch op.Type {
+		case metadata.BatchCreate:
+			if current != nil {
+				res = CommandResult{Err: "already_exists"}
+			} else {
+				res = putResult(inodes, path, op.Metadata)
+			}
+		case metadata.BatchUpdate:
+			if current == nil {
+				res = CommandResult{Err: "not_found"}
+			} else {
+				res = putResult(inodes, path, op.Metadata)
+			}
+		case metadata.BatchDelete:
+			if current == nil {
+				res = CommandResult{Err: "not_found"}
+			} else {
+				res = errResult(inodes.Delete([]byte(path)))
+			}
+		default:
+			res = CommandResult{Err: "unknown_batch_op"}
+		}
+		if res.Err != "" {
+			rollback()
+			return res
+		}
+	}
+	return CommandResult{}
+}
+
+// renameSubtree moves oldPath and every key below it to newPath in the
+// metadata and erasure buckets. Preconditions are checked before any write.
+func renameSubtree(inodes, erasure *bolt.Bucket, oldPath, newPath string) CommandResult {
+	if inodes.Get([]byte(oldPath)) == nil {
+		return CommandResult{Err: "not_found"}
+	}
+	if inodes.Get([]byte(newPath)) != nil {
+		return CommandResult{Err: "already_exists"}
+	}
+
+	for _, b := range []*bolt.Bucket{inodes, erasure} {
+		keys := subtreeKeys(b, oldPath)
+		for _, k := range keys {
+			newKey := newPath + strings.TrimPrefix(k, oldPath)
+			var value any
+			if b == inodes {
+				var md metadata.Metadata
+				if _, err := getJSON(b, k, &md); err != nil {
+					return errResult(err)
+				}
+				md.Path = newKey
+				if k == oldPath {
+					md.Name = filepath.Base(newPath)
+				}
+				value = &md
+			} else {
+				var info metadata.ErasureFileInfo
+				if _, err := getJSON(b, k, &info); err != nil {
+					return errResult(err)
+				}
+				info.FilePath = newKey
+				value = &info
+			}
+			if err := b.Delete([]byte(k)); err != nil {
+				return errResult(err)
+			}
+			if res := putResult(b, newKey, value); res.Err != "" {
+				return res
+			}
+		}
+	}
+	return CommandResult{}
+}
+
+// subtreeKeys returns path and every key below it present in b
+func subtreeKeys(b *bolt.Bucket, path string) []string {
+	var keys []string
+	if b.Get([]byte(path)) != nil {
+		keys = append(keys, path)
+	}
+	base := []byte(path + "/")
+	c := b.Cursor()
+	for k, _ := c.Seek(base); k != nil && bytes.HasPrefix(k, base); k, _ = c.Next() {
+		keys = append(keys, string(k))
+	}
+	return keys
+}
+
+func cleanupLinks(links *bolt.Bucket, expired func(*metadata.SingleUseLink) bool) CommandResult {
+	var stale [][]byte
+	err := links.ForEach(func(k, v []byte) error {
+		var link metadata.SingleUseLink
+		if err := json.Unmarshal(v, &link); err != nil {
+			return err
+		}
+		if expired(&link) {
+			stale = append(stale, bytes.Clone(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return errResult(err)
+	}
+	for _, k := range stale {
+		if err := links.Delete(k); err != nil {
+			return errResult(err)
+		}
+	}
+	return CommandResult{CleanupCount: len(stale)}
+}
+
+func putResult(b *bolt.Bucket, key string, value any) CommandResult {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return errResult(err)
+	}
+	return errResult(b.Put([]byte(key), raw))
+}
+
+func errResult(err error) CommandResult {
+	if err != nil {
+		return CommandResult{Err: err.Error()}
+	}
+	return CommandResult{}
+}
+
+func getJSON(b *bolt.Bucket, key string, out any) (bool, error) {
+	raw := b.Get([]byte(key))
+	if raw == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, out)
+}
+
+// get decodes key from bucket into out, returning metadata.ErrNotFound if absent
+func (f *fsm) get(bucket []byte, key string, out any) error {
+	return f.db.View(func(tx *bolt.Tx) error {
+		found, err := getJSON(tx.Bucket(bucket), key, out)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return metadata.ErrNotFound
+		}
+		return nil
+	})
+}
+
+// listChildren returns the direct children of parentPath in path order.
+// Deeper descendants are skipped by seeking past each child's subtree.
+func (f *fsm) listChildren(parentPath string) ([]*metadata.Metadata, error) {
+	base := parentPath
+	if base != "/" {
+		base += "/"
+	}
+	prefix := []byte(base)
+
+	children := make([]*metadata.Metadata, 0)
+	err := f.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketMetadata).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); {
+			rest := k[len(prefix):]
+			if len(rest) == 0 {
+				k, v = c.Next()
+				continue
+			}
+			if i := bytes.IndexByte(rest, '/'); i >= 0 {
+				// Every key under base+name+"/" sorts before base+name+"0"
+				next := append(append([]byte{}, k[:len(prefix)+i]...), '0')
+				k, v = c.Seek(next)
+				continue
+			}
+			var md metadata.Metadata
+			if err := json.Unmarshal(v, &md); err != nil {
+				return err
+			}
+			children = append(children, &md)
+			k, v = c.Next()
+		}
+		return nil
+	})
+	return children, err
+}
+
+// listDescendants returns entries below prefix after cursor in path order
+func (f *fsm) listDescendants(prefix string, limit int, cursor string) ([]*metadata.Metadata, error) {
+	base := []byte(strings.TrimSuffix(prefix, "/") + "/")
+	start := base
+	if cursor != "" && cursor > string(base) {
+		start = []byte(cursor)
+	}
+
+	items := make([]*metadata.Metadata, 0)
+	err := f.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketMetadata).Cursor()
+		for k, v := c.Seek(start); k != nil && bytes.HasPrefix(k, base); k, v = c.Next() {
+			if string(k) == "/" || string(k) == cursor {
+				continue
+			}
+			var md metadata.Metadata
+			if err := json.Unmarshal(v, &md); err != nil {
+				return err
+			}
+			items = append(items, &md)
+			if limit > 0 && len(items) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+	return items, err
+}
+
+// usage counts every inode in one read transaction
+func (f *fsm) usage() (*metadata.Usage, error) {
+	var counter metadata.UsageCounter
+	err := f.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketMetadata).ForEach(func(_, v []byte) error {
+			var md metadata.Metadata
+			if err := json.Unmarshal(v, &md); err != nil {
+				return err
+			}
+			counter.Add(&md)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counter.Usage(), nil
+}
+
+// directoryUsage counts the entries below path in one read transaction
+func (f *fsm) directoryUsage(path string) (*metadata.DirectoryUsage, error) {
+	var usage metadata.DirectoryUsage
+	base := []byte(strings.TrimSuffix(path, "/") + "/")
+	err := f.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketMetadata).Cursor()
+		for k, v := c.Seek(base); k != nil && bytes.HasPrefix(k, base); k, v = c.Next() {
+			if string(k) == "/" {
+				continue
+			}
+			var md metadata.Metadata
+			if err := json.Unmarshal(v, &md); err != nil {
+				return err
+			}
+			usage.Count(path, &md)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// listLinks returns single-use links after cursor in token order
+func (f *fsm) listLinks(limit int, cursor string) ([]*metadata.SingleUseLink, error) {
+	links := make([]*metadata.SingleUseLink, 0)
+	err := f.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketLinks).Cursor()
+		for k, v := c.Seek([]byte(cursor)); k != nil; k, v = c.Next() {
+			if string(k) == cursor {
+				continue
+			}
+			var link metadata.SingleUseLink
+			if err := json.Unmarshal(v, &link); err != nil {
+				return err
+			}
+			links = append(links, &link)
+			if limit > 0 && len(links) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+	return links, err
+}
+
+func (f *fsm) Snapshot() (hashiraft.FSMSnapshot, error) {
+	tx, err := f.db.Begin(false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open raft fsm snapshot: %w", err)
+	}
+	return &fsmSnapshot{tx: tx}, nil
+}
+
+// Restore replaces the FSM contents with a snapshot stream. Legacy
+// single-object snapshots are accepted so existing clusters can upgrade.
+// The records are written in batches and the applied index only after the
+// last one, so a restore cut short leaves the store behind the snapshot and
+// raft restores it again on start.
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+	dec := json.NewDecoder(rc)
+
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return fmt.Errorf("failed to decode raft snapshot: %w", err)
+	}
+	if header.Version != 0 && header.Version != snapshotVersion {
+		return fmt.Errorf("unsupported raft snapshot version %d", header.Version)
+	}
+
+	err := f.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range fsmBuckets {
+			if err := tx.DeleteBucket(name); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		if header.Version != 0 {
+			return writeAppliedIndex(tx, 0) // Written again after the last record
+		}
+		for bucket, entries := range map[string]map[string]json.RawMessage{
+			string(bucketMetadata): header.MetadataByPath,
+			string(bucketLinks):    header.LinksByToken,
+			string(bucketErasure):  header.ErasureByPath,
+		} {
+			for k, v := range entries {
+				if err := tx.Bucket([]byte(bucket)).Put([]byte(k), v); err != nil {
+					return err
+				}
+			}
+		}
+		return writeAppliedIndex(tx, header.AppliedIndex)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reset raft fsm store: %w", err)
+	}
+	if header.Version == 0 {
+		return nil
+	}
+
+	for done := false; !done; {
+		err := f.db.Update(func(tx *bolt.Tx) error {
+			for i := 0; i < restoreBatchSize; i++ {
+				var rec snapshotRecord
+				if err := dec.Decode(&rec); err != nil {
+					if errors.Is(err, io.EOF) {
+						done = true
+						return nil
+					}
+					return err
+				}
+				b := tx.Bucket([]byte(rec.Bucket))
+				if b == nil {
+					return fmt.Errorf("unknown snapshot bucket %q", rec.Bucket)
+				}
+				if err := b.Put([]byte(rec.Key), rec.Value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to restore raft snapshot: %w", err)
+		}
+	}
+	if err := f.db.Update(func(tx *bolt.Tx) error {
+		return writeAppliedIndex(tx, header.AppliedIndex)
+	}); err != nil {
+		return fmt.Errorf("failed to restore raft snapshot: %w", err)
+	}
+	return nil
+}
+
+func (s *fsmSnapshot) Persist(sink hashiraft.SnapshotSink) error {
+	if err := s.write(sink); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *fsmSnapshot) write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(snapshotHeader{Version: snapshotVersion, AppliedIndex: readAppliedIndex(s.tx)}); err != nil {
+		return err
+	}
+	for _, name := range fsmBuckets {
+		err := s.tx.Bucket(name).ForEach(func(k, v []byte) error {
+			return enc.Encode(snapshotRecord{Bucket: string(name), Key: string(k), Value: v})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fsmSnapshot) Release() {
+	_ = s.tx.Rollback()
+}
+
+// fsmCurrent reports whether the FSM store already holds everything
+// up to the newest snapshot, in which case raft need not restore it on start
+func fsmCurrent(f *fsm, snapshots hashiraft.SnapshotStore) bool {
+	metas, err := snapshots.List()
+	if err != nil || len(metas) == 0 {
+		return true
+	}
+	applied, err := f.appliedIndex()
+	if err != nil {
+		return false
+	}
+	return applied >= metas[0].Index
+}
@@ -0,0 +1,67 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// intentKey keys intents by instance so each instance's intents are adjacent
+func intentKey(instanceID, id string) string {
+	return instanceID + "/" + id
+}
+
+// RecordIntent stores an intent in the journal via Raft consensus.
+func (s *Store) RecordIntent(ctx context.Context, intent *metadata.Intent) error {
+	recorded := *intent
+	_, err := s.applyCommand(ctx, Command{Op: "record_intent", Intent: &recorded})
+	return err
+}
+
+// ClearIntent removes an intent from the journal via Raft consensus.
+func (s *Store) ClearIntent(ctx context.Context, intent *metadata.Intent) error {
+	_, err := s.applyCommand(ctx, Command{Op: "clear_intent", Path: intentKey(intent.InstanceID, intent.ID)})
+	return err
+}
+
+// ListIntents returns the intents recorded by an instance from the local FSM
+// store, oldest first.
+func (s *Store) ListIntents(ctx context.Context, instanceID string) ([]*metadata.Intent, error) {
+	if err := s.readBarrier(ctx); err != nil {
+		return nil, err
+	}
+	intents, err := s.fsm.listIntents(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(intents, func(i, j int) bool {
+		if !intents[i].CreatedAt.Equal(intents[j].CreatedAt) {
+			return intents[i].CreatedAt.Before(intents[j].CreatedAt)
+		}
+		return intents[i].ID < intents[j].ID
+	})
+	return intents, nil
+}
+
+// listIntents decodes the intents stored under instanceID
+func (f *fsm) listIntents(instanceID string) ([]*metadata.Intent, error) {
+	var intents []*metadata.Intent
+	prefix := []byte(intentKey(instanceID, ""))
+	err := f.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketIntents).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var intent metadata.Intent
+			if err := json.Unmarshal(v, &intent); err != nil {
+				return err
+			}
+			intents = append(intents, &intent)
+		}
+		return nil
+	})
+	return intents, err
+}
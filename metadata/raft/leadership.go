@@ -0,0 +1,101 @@
+package raft
+
+import (
+	"context"
+	"time"
+
+	hashiraft "github.com/hashicorp/raft"
+	"go.uber.org/zap"
+)
+
+// leadershipCheckInterval is how often a node that must not lead checks
+// whether it was elected
+const leadershipCheckInterval = time.Second
+
+// NodeInfo is the record a node keeps of itself in the replicated state
+type NodeInfo struct {
+	NodeID         string `json:"node_id"`
+	LeaderEligible bool   `json:"leader_eligible"` // False for nodes that hand leadership over when elected
+}
+
+// RestrictLeadership records whether this node may lead the cluster and,
+// when it may not, hands leadership over to a voter that may each time it is
+// elected, until ctx is done. Raft elects any voter, so this keeps leadership
+// off nodes that cannot do the leader's work, such as API processes when the
+// raft leader runs the leader-only jobs. Voters that have not recorded
+// themselves are not handed leadership.
+func (s *Store) RestrictLeadership(ctx context.Context, eligible bool) {
+	go func() {
+		if !s.recordNode(ctx, eligible) || eligible {
+			return
+		}
+
+		ticker := time.NewTicker(leadershipCheckInterval)
+		defer ticker.Stop()
+		warned := false
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			if !s.IsLeader() {
+				warned = false
+				continue
+			}
+			target, ok := s.eligibleVoter()
+			if !ok {
+				if !warned {
+					s.logger.Warn("Leading the raft cluster though not eligible, as no eligible voter is known")
+					warned = true
+				}
+				continue
+			}
+			s.logger.Info("Handing raft leadership over to an eligible voter", zap.String("node_id", string(target.ID)))
+			if err := s.raft.LeadershipTransferToServer(target.ID, target.Address).Error(); err != nil {
+				s.logger.Warn("Failed to hand raft leadership over", zap.String("node_id", string(target.ID)), zap.Error(err))
+			}
+		}
+	}()
+}
+
+// recordNode stores the record of this node, retrying until a leader accepts
+// it. It reports false when ctx ended first.
+func (s *Store) recordNode(ctx context.Context, eligible bool) bool {
+	node := &NodeInfo{NodeID: s.nodeID, LeaderEligible: eligible}
+	for {
+		var recorded NodeInfo
+		if err := s.fsm.get(bucketNodes, s.nodeID, &recorded); err == nil && recorded == *node {
+			return true
+		}
+		_, err := s.applyCommand(ctx, Command{Op: "set_node", Node: node})
+		if err == nil {
+			return true
+		}
+		s.logger.Debug("Failed to record raft node, retrying", zap.Error(err))
+		select {
+		case <-time.After(leadershipCheckInterval):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// eligibleVoter returns a voter other than this node that recorded itself
+// eligible to lead
+func (s *Store) eligibleVoter() (hashiraft.Server, bool) {
+	configFuture := s.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return hashiraft.Server{}, false
+	}
+	for _, server := range configFuture.Configuration().Servers {
+		if server.Suffrage != hashiraft.Voter || string(server.ID) == s.nodeID {
+			continue
+		}
+		var node NodeInfo
+		if err := s.fsm.get(bucketNodes, string(server.ID), &node); err == nil && node.LeaderEligible {
+			return server, true
+		}
+	}
+	return hashiraft.Server{}, false
+}
@@ -0,0 +1,145 @@
+package raft
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	hashiraft "github.com/hashicorp/raft"
+)
+
+// Member describes one server in the raft configuration
+type Member struct {
+	NodeID      string `json:"node_id"`
+	RaftAddr    string `json:"raft_addr"`
+	APIEndpoint string `json:"api_endpoint,omitempty"`
+	Suffrage    string `json:"suffrage"` // "voter" or "learner"
+	Leader      bool   `json:"leader"`
+}
+
+// MembershipRequest names the node targeted by a remove or leadership
+// transfer. An empty NodeID on transfer lets raft pick the most up to date voter.
+type MembershipRequest struct {
+	NodeID   string `json:"node_id"`
+	RaftAddr string `json:"raft_addr,omitempty"`
+}
+
+// MembershipResponse is returned by the raft membership endpoints
+type MembershipResponse struct {
+	Status   string   `json:"status"`
+	LeaderID string   `json:"leader_id,omitempty"`
+	Members  []Member `json:"members,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Members lists the servers in the latest raft configuration. Any node can
+// answer, since every node replicates the configuration.
+func (s *Store) Members(ctx context.Context) ([]Member, error) {
+	configFuture := s.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return nil, fmt.Errorf("failed to get raft configuration: %w", err)
+	}
+
+	leaderID := s.LeaderID()
+	servers := configFuture.Configuration().Servers
+	members := make([]Member, 0, len(servers))
+	for _, server := range servers {
+		endpoint, _ := s.APIPeerEndpoint(string(server.ID))
+		suffrage := "voter"
+		if server.Suffrage != hashiraft.Voter {
+			suffrage = "learner"
+		}
+		members = append(members, Member{
+			NodeID:      string(server.ID),
+			RaftAddr:    string(server.Address),
+			APIEndpoint: endpoint,
+			Suffrage:    suffrage,
+			Leader:      string(server.ID) == leaderID,
+		})
+	}
+	return members, nil
+}
+
+// AddLearner adds a non-voting member. Learners replicate the log but do not
+// count towards quorum, so a new node can catch up before being promoted
+// with AddVoter.
+func (s *Store) AddLearner(ctx context.Context, nodeID, raftAddr, apiEndpoint string) error {
+	return s.addServer(ctx, nodeID, raftAddr, apiEndpoint, hashiraft.Nonvoter)
+}
+
+// RemoveNode removes a voter or learner from the cluster
+func (s *Store) RemoveNode(ctx context.Context, nodeID string) error {
+	nodeID = strings.TrimSpace(nodeID)
+	if nodeID == "" {
+		return fmt.Errorf("node_id is required")
+	}
+	if !s.IsLeader() {
+		return fmt.Errorf("not leader")
+	}
+
+	configFuture := s.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return fmt.Errorf("failed to get raft configuration: %w", err)
+	}
+	found := false
+	for _, server := range configFuture.Configuration().Servers {
+		if string(server.ID) == nodeID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("node %s is not a cluster member", nodeID)
+	}
+
+	removeFuture := s.raft.RemoveServer(hashiraft.ServerID(nodeID), 0, s.applyTimeout)
+	if err := removeFuture.Error(); err != nil {
+		return fmt.Errorf("failed to remove raft node %s: %w", nodeID, err)
+	}
+
+	s.apiPeerMu.Lock()
+	delete(s.apiPeerEndpoints, nodeID)
+	delete(s.discoveredPeers, nodeID)
+	s.apiPeerMu.Unlock()
+
+	return ctx.Err()
+}
+
+// TransferLeadership hands leadership to nodeID, or to the most up to date
+// voter when nodeID is empty
+func (s *Store) TransferLeadership(ctx context.Context, nodeID, raftAddr string) error {
+	nodeID = strings.TrimSpace(nodeID)
+	raftAddr = strings.TrimSpace(raftAddr)
+	if !s.IsLeader() {
+		return fmt.Errorf("not leader")
+	}
+
+	var future hashiraft.Future
+	if nodeID == "" {
+		future = s.raft.LeadershipTransfer()
+	} else {
+		if raftAddr == "" {
+			members, err := s.Members(ctx)
+			if err != nil {
+				return err
+			}
+			for _, member := range members {
+				if member.NodeID == nodeID {
+					if member.Suffrage != "voter" {
+						return fmt.Errorf("node %s is a learner and cannot become leader", nodeID)
+					}
+					raftAddr = member.RaftAddr
+				}
+			}
+			if raftAddr == "" {
+				return fmt.Errorf("node %s is not a cluster member", nodeID)
+			}
+		}
+		future = s.raft.LeadershipTransferToServer(hashiraft.ServerID(nodeID), hashiraft.ServerAddress(raftAddr))
+	}
+
+	if err := future.Error(); err != nil {
+		return fmt.Errorf("failed to transfer leadership: %w", err)
+	}
+	return nil
+}
@@ -10,7 +10,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"sort"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -19,6 +19,8 @@ import (
 	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
 	"go.uber.org/zap"
 
+	corelog "github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/auth"
 	"github.com/ebogdum/callfs/metadata"
 )
 
@@ -34,7 +36,8 @@ type Config struct {
 	SnapshotInterval    time.Duration
 	SnapshotThreshold   uint64
 	RetainSnapshotCount int
-	InternalAuthToken   string
+	InternalSigner      *auth.RequestSigner
+	ReadConsistency     metadata.ReadConsistency // default for reads that do not request one
 }
 
 type Command struct {
@@ -49,6 +52,11 @@ type Command struct {
 	Before      *time.Time               `json:"before,omitempty"`
 	OlderThan   *time.Time               `json:"older_than,omitempty"`
 	ErasureInfo *metadata.ErasureFileInfo `json:"erasure_info,omitempty"`
+	Batch       []metadata.BatchOp        `json:"batch,omitempty"`
+	NewPath     string                   `json:"new_path,omitempty"`
+	Intent      *metadata.Intent          `json:"intent,omitempty"`
+	AccessStats []*metadata.AccessStats   `json:"access_stats,omitempty"`
+	Node        *NodeInfo                 `json:"node,omitempty"`
 }
 
 type CommandResult struct {
@@ -69,6 +77,7 @@ type JoinRequest struct {
 	NodeID      string `json:"node_id"`
 	RaftAddr    string `json:"raft_addr"`
 	APIEndpoint string `json:"api_endpoint"`
+	Learner     bool   `json:"learner,omitempty"`
 }
 
 type JoinResponse struct {
@@ -78,32 +87,19 @@ type JoinResponse struct {
 }
 
 type Store struct {
-	raft              *hashiraft.Raft
-	fsm               *fsm
-	logStore          *raftboltdb.BoltStore
-	stableStore       *raftboltdb.BoltStore
-	nodeID            string
-	apiPeerMu         sync.RWMutex
-	apiPeerEndpoints  map[string]string
-	internalAuthToken string
-	forwardClient     *http.Client
-	applyTimeout      time.Duration
-	logger            *zap.Logger
-}
-
-type state struct {
-	MetadataByPath map[string]*metadata.Metadata       `json:"metadata_by_path"`
-	LinksByToken   map[string]*metadata.SingleUseLink  `json:"links_by_token"`
-	ErasureByPath  map[string]*metadata.ErasureFileInfo `json:"erasure_by_path"`
-}
-
-type fsm struct {
-	mu    sync.RWMutex
-	state state
-}
-
-type stateSnapshot struct {
-	state state
+	raft             *hashiraft.Raft
+	fsm              *fsm
+	logStore         *raftboltdb.BoltStore
+	stableStore      *raftboltdb.BoltStore
+	nodeID           string
+	apiPeerMu        sync.RWMutex
+	apiPeerEndpoints map[string]string
+	discoveredPeers  map[string]bool // Nodes whose endpoint discovery set
+	internalSigner   *auth.RequestSigner
+	forwardClient    *http.Client
+	applyTimeout     time.Duration
+	readConsistency  metadata.ReadConsistency
+	logger           *zap.Logger
 }
 
 func NewRaftStore(cfg Config, logger *zap.Logger) (*Store, error) {
@@ -131,16 +127,18 @@ func NewRaftStore(cfg Config, logger *zap.Logger) (*Store, error) {
 	if cfg.RetainSnapshotCount <= 0 {
 		cfg.RetainSnapshotCount = 2
 	}
+	if cfg.ReadConsistency == metadata.ConsistencyDefault {
+		cfg.ReadConsistency = metadata.ConsistencyEventual
+	}
 
 	if err := os.MkdirAll(cfg.DataDir, 0o755); err != nil {
 		return nil, fmt.Errorf("failed to create raft data dir: %w", err)
 	}
 
-	fsmInstance := &fsm{state: state{
-		MetadataByPath: map[string]*metadata.Metadata{},
-		LinksByToken:   map[string]*metadata.SingleUseLink{},
-		ErasureByPath:  map[string]*metadata.ErasureFileInfo{},
-	}}
+	fsmInstance, err := openFSM(filepath.Join(cfg.DataDir, "fsm.db"), logger)
+	if err != nil {
+		return nil, err
+	}
 
 	raftCfg := hashiraft.DefaultConfig()
 	raftCfg.LocalID = hashiraft.ServerID(cfg.NodeID)
@@ -165,19 +163,24 @@ func NewRaftStore(cfg Config, logger *zap.Logger) (*Store, error) {
 		return nil, fmt.Errorf("failed to create raft transport: %w", err)
 	}
 
+	// The FSM survives restarts on disk, so only restore the latest snapshot
+	// when the store is missing or older than it
+	raftCfg.NoSnapshotRestoreOnStart = fsmCurrent(fsmInstance, snapshotStore)
+
 	raftNode, err := hashiraft.NewRaft(raftCfg, fsmInstance, logStore, stableStore, snapshotStore, transport)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create raft node: %w", err)
 	}
 
 	store := &Store{
-		raft:              raftNode,
-		fsm:               fsmInstance,
-		logStore:          logStore,
-		stableStore:       stableStore,
-		nodeID:            cfg.NodeID,
-		apiPeerEndpoints:  copyStringMap(cfg.APIPeerEndpoints),
-		internalAuthToken: cfg.InternalAuthToken,
+		raft:             raftNode,
+		fsm:              fsmInstance,
+		logStore:         logStore,
+		stableStore:      stableStore,
+		nodeID:           cfg.NodeID,
+		apiPeerEndpoints: copyStringMap(cfg.APIPeerEndpoints),
+		discoveredPeers:  make(map[string]bool),
+		internalSigner:   cfg.InternalSigner,
 		forwardClient: &http.Client{
 			Timeout: cfg.ForwardTimeout,
 			Transport: &http.Transport{
@@ -187,8 +190,9 @@ func NewRaftStore(cfg Config, logger *zap.Logger) (*Store, error) {
 				IdleConnTimeout:     90 * time.Second,
 			},
 		},
-		applyTimeout: cfg.ApplyTimeout,
-		logger:       logger,
+		applyTimeout:    cfg.ApplyTimeout,
+		readConsistency: cfg.ReadConsistency,
+		logger:          logger,
 	}
 
 	if cfg.Bootstrap {
@@ -212,6 +216,15 @@ func (s *Store) LeaderID() string {
 	return string(leaderID)
 }
 
+// Ping reports an error when this node does not currently know a cluster leader,
+// since metadata writes cannot be committed without one.
+func (s *Store) Ping(ctx context.Context) error {
+	if s.LeaderID() == "" {
+		return fmt.Errorf("raft cluster has no known leader")
+	}
+	return nil
+}
+
 func (s *Store) SetAPIPeerEndpoint(nodeID, endpoint string) {
 	nodeID = strings.TrimSpace(nodeID)
 	endpoint = strings.TrimSpace(endpoint)
@@ -221,6 +234,35 @@ func (s *Store) SetAPIPeerEndpoint(nodeID, endpoint string) {
 	s.apiPeerMu.Lock()
 	defer s.apiPeerMu.Unlock()
 	s.apiPeerEndpoints[nodeID] = endpoint
+	delete(s.discoveredPeers, nodeID)
+}
+
+// SetDiscoveredAPIPeerEndpoint records the endpoint instance discovery found
+// for nodeID, unless it is not a raft member or has an endpoint configured
+// or given when it joined, which are kept
+func (s *Store) SetDiscoveredAPIPeerEndpoint(nodeID, endpoint string) {
+	nodeID = strings.TrimSpace(nodeID)
+	endpoint = strings.TrimSpace(endpoint)
+	if nodeID == "" || endpoint == "" {
+		return
+	}
+	configFuture := s.raft.GetConfiguration()
+	if configFuture.Error() != nil {
+		return
+	}
+	member := slices.ContainsFunc(configFuture.Configuration().Servers, func(server hashiraft.Server) bool {
+		return string(server.ID) == nodeID
+	})
+	if !member {
+		return
+	}
+	s.apiPeerMu.Lock()
+	defer s.apiPeerMu.Unlock()
+	if _, known := s.apiPeerEndpoints[nodeID]; known && !s.discoveredPeers[nodeID] {
+		return
+	}
+	s.apiPeerEndpoints[nodeID] = endpoint
+	s.discoveredPeers[nodeID] = true
 }
 
 func (s *Store) APIPeerEndpoint(nodeID string) (string, bool) {
@@ -230,7 +272,12 @@ func (s *Store) APIPeerEndpoint(nodeID string) (string, bool) {
 	return endpoint, ok
 }
 
+// AddVoter adds a voting member, or promotes an existing learner in place
 func (s *Store) AddVoter(ctx context.Context, nodeID, raftAddr, apiEndpoint string) error {
+	return s.addServer(ctx, nodeID, raftAddr, apiEndpoint, hashiraft.Voter)
+}
+
+func (s *Store) addServer(ctx context.Context, nodeID, raftAddr, apiEndpoint string, suffrage hashiraft.ServerSuffrage) error {
 	nodeID = strings.TrimSpace(nodeID)
 	raftAddr = strings.TrimSpace(raftAddr)
 	apiEndpoint = strings.TrimSpace(apiEndpoint)
@@ -255,10 +302,14 @@ func (s *Store) AddVoter(ctx context.Context, nodeID, raftAddr, apiEndpoint stri
 		serverAddr := string(server.Address)
 
 		if serverID == nodeID {
-			if serverAddr == raftAddr && server.Suffrage == hashiraft.Voter {
+			if serverAddr == raftAddr && server.Suffrage == suffrage {
 				s.SetAPIPeerEndpoint(nodeID, apiEndpoint)
 				return nil
 			}
+			if serverAddr == raftAddr && suffrage == hashiraft.Voter {
+				// Learner promotion: AddVoter below updates the suffrage in place
+				continue
+			}
 
 			removeFuture := s.raft.RemoveServer(server.ID, 0, s.applyTimeout)
 			if err := removeFuture.Error(); err != nil {
@@ -274,9 +325,14 @@ func (s *Store) AddVoter(ctx context.Context, nodeID, raftAddr, apiEndpoint stri
 		}
 	}
 
-	addFuture := s.raft.AddVoter(hashiraft.ServerID(nodeID), hashiraft.ServerAddress(raftAddr), 0, s.applyTimeout)
+	var addFuture hashiraft.IndexFuture
+	if suffrage == hashiraft.Voter {
+		addFuture = s.raft.AddVoter(hashiraft.ServerID(nodeID), hashiraft.ServerAddress(raftAddr), 0, s.applyTimeout)
+	} else {
+		addFuture = s.raft.AddNonvoter(hashiraft.ServerID(nodeID), hashiraft.ServerAddress(raftAddr), 0, s.applyTimeout)
+	}
 	if err := addFuture.Error(); err != nil {
-		return fmt.Errorf("failed to add raft voter %s: %w", nodeID, err)
+		return fmt.Errorf("failed to add raft member %s: %w", nodeID, err)
 	}
 
 	s.SetAPIPeerEndpoint(nodeID, apiEndpoint)
@@ -290,13 +346,14 @@ func (s *Store) AddVoter(ctx context.Context, nodeID, raftAddr, apiEndpoint stri
 }
 
 func (s *Store) Get(ctx context.Context, path string) (*metadata.Metadata, error) {
-	s.fsm.mu.RLock()
-	defer s.fsm.mu.RUnlock()
-	md, ok := s.fsm.state.MetadataByPath[path]
-	if !ok {
-		return nil, metadata.ErrNotFound
+	if err := s.readBarrier(ctx); err != nil {
+		return nil, err
 	}
-	return cloneMetadata(md), nil
+	var md metadata.Metadata
+	if err := s.fsm.get(bucketMetadata, path, &md); err != nil {
+		return nil, err
+	}
+	return &md, nil
 }
 
 func (s *Store) Create(ctx context.Context, md *metadata.Metadata) error {
@@ -320,30 +377,75 @@ func (s *Store) Delete(ctx context.Context, path string) error {
 	return err
 }
 
+// Batch applies ops as a single raft command, so followers see all of them or none
+func (s *Store) Batch(ctx context.Context, ops []metadata.BatchOp) error {
+	if err := metadata.ValidateBatch(ops); err != nil {
+		return err
+	}
+	batch := make([]metadata.BatchOp, len(ops))
+	for i, op := range ops {
+		batch[i] = metadata.BatchOp{Type: op.Type, Metadata: cloneMetadata(op.Metadata), Path: op.Path}
+	}
+	_, err := s.applyCommand(ctx, Command{Op: "batch", Batch: batch})
+	return err
+}
+
+// RenameSubtree moves a subtree as a single raft command
+func (s *Store) RenameSubtree(ctx context.Context, oldPath, newPath string) error {
+	_, err := s.applyCommand(ctx, Command{Op: "rename_subtree", Path: oldPath, NewPath: newPath})
+	return err
+}
+
 func (s *Store) ListChildren(ctx context.Context, parentPath string) ([]*metadata.Metadata, error) {
-	s.fsm.mu.RLock()
-	defer s.fsm.mu.RUnlock()
-	children := make([]*metadata.Metadata, 0)
-	for _, md := range s.fsm.state.MetadataByPath {
-		if md.Path == "/" {
-			continue
-		}
-		if pathDir(md.Path) == parentPath {
-			children = append(children, cloneMetadata(md))
-		}
+	if err := s.readBarrier(ctx); err != nil {
+		return nil, err
+	}
+	return s.fsm.listChildren(parentPath)
+}
+
+// ListDescendants lists every entry below prefix in path order from the local
+// replica, without issuing a raft command.
+func (s *Store) ListDescendants(ctx context.Context, prefix string, limit int, cursor string) ([]*metadata.Metadata, error) {
+	if err := s.readBarrier(ctx); err != nil {
+		return nil, err
+	}
+	return s.fsm.listDescendants(prefix, limit, cursor)
+}
+
+// Usage counts inodes and file bytes by scanning the local replica
+func (s *Store) Usage(ctx context.Context) (*metadata.Usage, error) {
+	if err := s.readBarrier(ctx); err != nil {
+		return nil, err
+	}
+	return s.fsm.usage()
+}
+
+// DirectoryUsage counts the children of path and the bytes below it by
+// scanning the local replica
+func (s *Store) DirectoryUsage(ctx context.Context, path string) (*metadata.DirectoryUsage, error) {
+	if err := s.readBarrier(ctx); err != nil {
+		return nil, err
 	}
-	sort.Slice(children, func(i, j int) bool { return children[i].Path < children[j].Path })
-	return children, nil
+	return s.fsm.directoryUsage(path)
 }
 
 func (s *Store) GetSingleUseLink(ctx context.Context, token string) (*metadata.SingleUseLink, error) {
-	s.fsm.mu.RLock()
-	defer s.fsm.mu.RUnlock()
-	link, ok := s.fsm.state.LinksByToken[token]
-	if !ok {
-		return nil, metadata.ErrNotFound
+	if err := s.readBarrier(ctx); err != nil {
+		return nil, err
+	}
+	var link metadata.SingleUseLink
+	if err := s.fsm.get(bucketLinks, token, &link); err != nil {
+		return nil, err
 	}
-	return cloneLink(link), nil
+	return &link, nil
+}
+
+// ListSingleUseLinks lists links in token order from the local replica
+func (s *Store) ListSingleUseLinks(ctx context.Context, limit int, cursor string) ([]*metadata.SingleUseLink, error) {
+	if err := s.readBarrier(ctx); err != nil {
+		return nil, err
+	}
+	return s.fsm.listLinks(limit, cursor)
 }
 
 func (s *Store) CreateSingleUseLink(ctx context.Context, link *metadata.SingleUseLink) error {
@@ -391,6 +493,9 @@ func (s *Store) Close() error {
 			firstErr = fmt.Errorf("failed to close raft stable store: %w", err)
 		}
 	}
+	if err := s.fsm.Close(); err != nil && firstErr == nil {
+		firstErr = fmt.Errorf("failed to close raft fsm store: %w", err)
+	}
 	return firstErr
 }
 
@@ -457,7 +562,10 @@ func (s *Store) forwardToLeader(ctx context.Context, cmd Command) (CommandResult
 		return CommandResult{}, fmt.Errorf("failed to create forward request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.internalAuthToken))
+	if err := s.internalSigner.Sign(req); err != nil {
+		return CommandResult{}, fmt.Errorf("failed to sign forward request: %w", err)
+	}
+	corelog.PropagateRequestID(ctx, req)
 
 	resp, err := s.forwardClient.Do(req)
 	if err != nil {
@@ -486,150 +594,6 @@ func (s *Store) forwardToLeader(ctx context.Context, cmd Command) (CommandResult
 	return CommandResult{CleanupCount: applyResp.CleanupCount}, nil
 }
 
-func (f *fsm) Apply(log *hashiraft.Log) interface{} {
-	var cmd Command
-	if err := json.Unmarshal(log.Data, &cmd); err != nil {
-		return CommandResult{Err: fmt.Sprintf("invalid_command:%v", err)}
-	}
-
-	f.mu.Lock()
-	defer f.mu.Unlock()
-
-	switch cmd.Op {
-	case "create_metadata":
-		if cmd.Metadata == nil {
-			return CommandResult{Err: "metadata_required"}
-		}
-		if _, exists := f.state.MetadataByPath[cmd.Metadata.Path]; exists {
-			return CommandResult{Err: "already_exists"}
-		}
-		f.state.MetadataByPath[cmd.Metadata.Path] = cloneMetadata(cmd.Metadata)
-		return CommandResult{}
-	case "update_metadata":
-		if cmd.Metadata == nil {
-			return CommandResult{Err: "metadata_required"}
-		}
-		if _, exists := f.state.MetadataByPath[cmd.Metadata.Path]; !exists {
-			return CommandResult{Err: "not_found"}
-		}
-		f.state.MetadataByPath[cmd.Metadata.Path] = cloneMetadata(cmd.Metadata)
-		return CommandResult{}
-	case "delete_metadata":
-		if _, exists := f.state.MetadataByPath[cmd.Path]; !exists {
-			return CommandResult{Err: "not_found"}
-		}
-		delete(f.state.MetadataByPath, cmd.Path)
-		return CommandResult{}
-	case "create_link":
-		if cmd.Link == nil {
-			return CommandResult{Err: "link_required"}
-		}
-		if _, exists := f.state.LinksByToken[cmd.Link.Token]; exists {
-			return CommandResult{Err: "already_exists"}
-		}
-		f.state.LinksByToken[cmd.Link.Token] = cloneLink(cmd.Link)
-		return CommandResult{}
-	case "update_link":
-		link, exists := f.state.LinksByToken[cmd.Token]
-		if !exists {
-			return CommandResult{Err: "not_found"}
-		}
-		// Only allow transitions from "active" status to prevent replay/reactivation
-		if link.Status != "active" {
-			return CommandResult{Err: "not_found"}
-		}
-		link.Status = cmd.Status
-		link.UsedAt = cloneTimePtr(cmd.UsedAt)
-		link.UsedByIP = cloneStringPtr(cmd.UsedByIP)
-		link.UpdatedAt = time.Now().UTC()
-		f.state.LinksByToken[cmd.Token] = link
-		return CommandResult{}
-	case "cleanup_expired_links":
-		if cmd.Before == nil {
-			return CommandResult{Err: "before_required"}
-		}
-		count := 0
-		for token, link := range f.state.LinksByToken {
-			if link.Status == "active" && link.ExpiresAt.Before(*cmd.Before) {
-				delete(f.state.LinksByToken, token)
-				count++
-			}
-		}
-		return CommandResult{CleanupCount: count}
-	case "cleanup_used_links":
-		if cmd.OlderThan == nil {
-			return CommandResult{Err: "older_than_required"}
-		}
-		count := 0
-		for token, link := range f.state.LinksByToken {
-			if link.Status == "used" && link.UsedAt != nil && link.UsedAt.Before(*cmd.OlderThan) {
-				delete(f.state.LinksByToken, token)
-				count++
-			}
-		}
-		return CommandResult{CleanupCount: count}
-	case "create_erasure_info":
-		if cmd.ErasureInfo == nil {
-			return CommandResult{Err: "erasure_info_required"}
-		}
-		if _, exists := f.state.ErasureByPath[cmd.Path]; exists {
-			return CommandResult{Err: "already_exists"}
-		}
-		f.state.ErasureByPath[cmd.Path] = cloneErasureFileInfo(cmd.ErasureInfo)
-		return CommandResult{}
-	case "delete_erasure_info":
-		delete(f.state.ErasureByPath, cmd.Path)
-		return CommandResult{}
-	default:
-		return CommandResult{Err: "unknown_operation"}
-	}
-}
-
-func (f *fsm) Snapshot() (hashiraft.FSMSnapshot, error) {
-	f.mu.RLock()
-	defer f.mu.RUnlock()
-	return &stateSnapshot{state: state{
-		MetadataByPath: cloneMetadataMap(f.state.MetadataByPath),
-		LinksByToken:   cloneLinkMap(f.state.LinksByToken),
-		ErasureByPath:  cloneErasureMap(f.state.ErasureByPath),
-	}}, nil
-}
-
-func (f *fsm) Restore(rc io.ReadCloser) error {
-	defer rc.Close()
-	var restored state
-	if err := json.NewDecoder(rc).Decode(&restored); err != nil {
-		return fmt.Errorf("failed to decode raft snapshot: %w", err)
-	}
-	if restored.MetadataByPath == nil {
-		restored.MetadataByPath = map[string]*metadata.Metadata{}
-	}
-	if restored.LinksByToken == nil {
-		restored.LinksByToken = map[string]*metadata.SingleUseLink{}
-	}
-	if restored.ErasureByPath == nil {
-		restored.ErasureByPath = map[string]*metadata.ErasureFileInfo{}
-	}
-	f.mu.Lock()
-	defer f.mu.Unlock()
-	f.state = state{
-		MetadataByPath: cloneMetadataMap(restored.MetadataByPath),
-		LinksByToken:   cloneLinkMap(restored.LinksByToken),
-		ErasureByPath:  cloneErasureMap(restored.ErasureByPath),
-	}
-	return nil
-}
-
-func (s *stateSnapshot) Persist(sink hashiraft.SnapshotSink) error {
-	if err := json.NewEncoder(sink).Encode(s.state); err != nil {
-		sink.Cancel()
-		return err
-	}
-	return sink.Close()
-}
-
-func (s *stateSnapshot) Release() {}
-
 func cloneMetadata(in *metadata.Metadata) *metadata.Metadata {
 	if in == nil {
 		return nil
@@ -651,22 +615,6 @@ func cloneLink(in *metadata.SingleUseLink) *metadata.SingleUseLink {
 	return &out
 }
 
-func cloneMetadataMap(in map[string]*metadata.Metadata) map[string]*metadata.Metadata {
-	out := make(map[string]*metadata.Metadata, len(in))
-	for k, v := range in {
-		out[k] = cloneMetadata(v)
-	}
-	return out
-}
-
-func cloneLinkMap(in map[string]*metadata.SingleUseLink) map[string]*metadata.SingleUseLink {
-	out := make(map[string]*metadata.SingleUseLink, len(in))
-	for k, v := range in {
-		out[k] = cloneLink(v)
-	}
-	return out
-}
-
 func cloneStringPtr(in *string) *string {
 	if in == nil {
 		return nil
@@ -0,0 +1,150 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// luaAddAccessStats adds to the counts of a path's hash and keeps the later
+// last access, in milliseconds
+const luaAddAccessStats = `
+	redis.call("HINCRBY", KEYS[1], "downloads", ARGV[1])
+	redis.call("HINCRBY", KEYS[1], "link_downloads", ARGV[2])
+	redis.call("HINCRBY", KEYS[1], "bytes_served", ARGV[3])
+	local last = tonumber(redis.call("HGET", KEYS[1], "last_access") or "0")
+	if tonumber(ARGV[4]) > last then
+		redis.call("HSET", KEYS[1], "last_access", ARGV[4])
+	end
+	redis.call("ZADD", KEYS[2], 0, ARGV[5])
+	return "OK"
+`
+
+// accessStatsKey is the hash of the access statistics of path
+func (s *RedisStore) accessStatsKey(path string) string {
+	return s.prefix + "access:" + normalizePath(path)
+}
+
+// accessStatsIndexKey holds every path with access statistics at score 0
+// for lexicographic prefix scans
+func (s *RedisStore) accessStatsIndexKey() string {
+	return s.prefix + "access:paths"
+}
+
+// AddAccessStats adds the counts of each entry to those stored for its path.
+func (s *RedisStore) AddAccessStats(ctx context.Context, stats []*metadata.AccessStats) error {
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, st := range stats {
+			path := normalizePath(st.Path)
+			pipe.Eval(ctx, luaAddAccessStats, []string{s.accessStatsKey(path), s.accessStatsIndexKey()},
+				st.Downloads, st.LinkDownloads, st.BytesServed, st.LastAccess.UnixMilli(), path)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add access statistics: %w", err)
+	}
+	return nil
+}
+
+// GetAccessStats returns the access statistics of path.
+func (s *RedisStore) GetAccessStats(ctx context.Context, path string) (*metadata.AccessStats, error) {
+	fields, err := s.client.HGetAll(ctx, s.accessStatsKey(path)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access statistics: %w", err)
+	}
+	if len(fields) == 0 {
+		return nil, metadata.ErrNotFound
+	}
+	return decodeAccessStats(normalizePath(path), fields), nil
+}
+
+// ListAccessStats returns the access statistics of the paths below prefix,
+// ordered by path, using the lexicographic path index. Statistics deleted
+// since the index was read are skipped and the page filled from further
+// along it.
+func (s *RedisStore) ListAccessStats(ctx context.Context, prefix string, limit int, cursor string) ([]*metadata.AccessStats, error) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	min := "(" + prefix + "/"
+	if cursor > prefix+"/" {
+		min = "(" + cursor
+	}
+
+	stats := []*metadata.AccessStats{}
+	for {
+		rangeBy := &redis.ZRangeBy{Min: min, Max: "(" + prefix + "0"}
+		if limit > 0 {
+			rangeBy.Count = int64(limit - len(stats))
+		}
+		paths, err := s.client.ZRangeByLex(ctx, s.accessStatsIndexKey(), rangeBy).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list access statistics: %w", err)
+		}
+		if len(paths) == 0 {
+			return stats, nil
+		}
+
+		cmds := make([]*redis.StringStringMapCmd, len(paths))
+		_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, path := range paths {
+				cmds[i] = pipe.HGetAll(ctx, s.accessStatsKey(path))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get access statistics: %w", err)
+		}
+		for i, cmd := range cmds {
+			// Deleted between the index scan and the read
+			if fields := cmd.Val(); len(fields) > 0 {
+				stats = append(stats, decodeAccessStats(paths[i], fields))
+			}
+		}
+		if limit <= 0 || len(stats) >= limit || int64(len(paths)) < rangeBy.Count {
+			return stats, nil
+		}
+		min = "(" + paths[len(paths)-1]
+	}
+}
+
+// DeleteAccessStats removes the access statistics of path and of the paths
+// below it.
+func (s *RedisStore) DeleteAccessStats(ctx context.Context, path string) error {
+	path = normalizePath(path)
+	descendants, err := s.client.ZRangeByLex(ctx, s.accessStatsIndexKey(), &redis.ZRangeBy{Min: "(" + path + "/", Max: "(" + path + "0"}).Result()
+	if err != nil {
+		return fmt.Errorf("failed to list access statistics: %w", err)
+	}
+	paths := append([]string{path}, descendants...)
+
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		members := make([]any, len(paths))
+		for i, p := range paths {
+			pipe.Del(ctx, s.accessStatsKey(p))
+			members[i] = p
+		}
+		pipe.ZRem(ctx, s.accessStatsIndexKey(), members...)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete access statistics: %w", err)
+	}
+	return nil
+}
+
+func decodeAccessStats(path string, fields map[string]string) *metadata.AccessStats {
+	st := &metadata.AccessStats{Path: path}
+	st.Downloads, _ = strconv.ParseInt(fields["downloads"], 10, 64)
+	st.LinkDownloads, _ = strconv.ParseInt(fields["link_downloads"], 10, 64)
+	st.BytesServed, _ = strconv.ParseInt(fields["bytes_served"], 10, 64)
+	if ms, err := strconv.ParseInt(fields["last_access"], 10, 64); err == nil {
+		st.LastAccess = time.UnixMilli(ms).UTC()
+	}
+	return st
+}
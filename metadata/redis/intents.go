@@ -0,0 +1,59 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// intentsKey is the hash of the intents recorded by an instance, keyed by ID
+func (s *RedisStore) intentsKey(instanceID string) string {
+	return s.prefix + "intents:" + instanceID
+}
+
+// RecordIntent stores an intent in the journal.
+func (s *RedisStore) RecordIntent(ctx context.Context, intent *metadata.Intent) error {
+	raw, err := json.Marshal(intent)
+	if err != nil {
+		return fmt.Errorf("failed to encode intent: %w", err)
+	}
+	if err := s.client.HSet(ctx, s.intentsKey(intent.InstanceID), intent.ID, raw).Err(); err != nil {
+		return fmt.Errorf("failed to record intent: %w", err)
+	}
+	return nil
+}
+
+// ClearIntent removes an intent from the journal.
+func (s *RedisStore) ClearIntent(ctx context.Context, intent *metadata.Intent) error {
+	if err := s.client.HDel(ctx, s.intentsKey(intent.InstanceID), intent.ID).Err(); err != nil {
+		return fmt.Errorf("failed to clear intent: %w", err)
+	}
+	return nil
+}
+
+// ListIntents returns the intents recorded by an instance, oldest first.
+func (s *RedisStore) ListIntents(ctx context.Context, instanceID string) ([]*metadata.Intent, error) {
+	entries, err := s.client.HGetAll(ctx, s.intentsKey(instanceID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list intents: %w", err)
+	}
+
+	intents := make([]*metadata.Intent, 0, len(entries))
+	for _, raw := range entries {
+		var intent metadata.Intent
+		if err := json.Unmarshal([]byte(raw), &intent); err != nil {
+			return nil, fmt.Errorf("failed to decode intent: %w", err)
+		}
+		intents = append(intents, &intent)
+	}
+	sort.Slice(intents, func(i, j int) bool {
+		if !intents[i].CreatedAt.Equal(intents[j].CreatedAt) {
+			return intents[i].CreatedAt.Before(intents[j].CreatedAt)
+		}
+		return intents[i].ID < intents[j].ID
+	})
+	return intents, nil
+}
@@ -0,0 +1,118 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/internal/redisclient"
+	"github.com/ebogdum/callfs/metadata"
+	"github.com/ebogdum/callfs/metadata/schema"
+)
+
+// migrations upgrade the Redis key layout in order. Append new steps; never
+// edit or reorder released ones. Redis has no transactions spanning a scan,
+// so every step must be safe to re-run if it is interrupted.
+var migrations = []schema.Migration[*RedisStore]{
+	{Version: 1, Description: "sorted path index for ListDescendants", Up: buildPathIndex},
+	{Version: 2, Description: "single-use link expiry, used and token indexes", Up: buildLinkIndexes},
+}
+
+// buildPathIndex adds every existing inode to the sorted path index used by
+// ListDescendants
+func buildPathIndex(ctx context.Context, s *RedisStore) error {
+	mdPrefix := s.prefix + "md:"
+	count := 0
+	err := redisclient.ScanKeys(ctx, s.client, mdPrefix+"*", func(key string) error {
+		path := strings.TrimPrefix(key, mdPrefix)
+		if err := s.client.ZAdd(ctx, s.pathIndexKey(), &redis.Z{Member: path}).Err(); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build metadata path index: %w", err)
+	}
+
+	if count > 0 {
+		s.logger.Info("Built metadata path index", zap.Int("entries", count))
+	}
+	return nil
+}
+
+// buildLinkIndexes adds every existing single-use link to the expiry, used
+// and token indexes read by cleanup and listing
+func buildLinkIndexes(ctx context.Context, s *RedisStore) error {
+	linkPrefix := s.linkKey("")
+	count := 0
+	err := redisclient.ScanKeys(ctx, s.client, linkPrefix+"*", func(key string) error {
+		raw, err := s.client.Get(ctx, key).Result()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read single-use link: %w", err)
+		}
+		var link metadata.SingleUseLink
+		if err := json.Unmarshal([]byte(raw), &link); err != nil {
+			s.logger.Warn("Skipping undecodable single-use link", zap.String("key", key), zap.Error(err))
+			return nil
+		}
+
+		token := strings.TrimPrefix(key, linkPrefix)
+		_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZAdd(ctx, s.linkExpiryIndexKey(), &redis.Z{Score: float64(link.ExpiresAt.UnixMilli()), Member: token})
+			pipe.ZAdd(ctx, s.linkTokenIndexKey(), &redis.Z{Member: token})
+			if link.Status == "used" && link.UsedAt != nil {
+				pipe.ZAdd(ctx, s.linkUsedIndexKey(), &redis.Z{Score: float64(link.UsedAt.UnixMilli()), Member: token})
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to index single-use link: %w", err)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to index single-use links: %w", err)
+	}
+
+	if count > 0 {
+		s.logger.Info("Built single-use link indexes", zap.Int("links", count))
+	}
+	return nil
+}
+
+// SchemaVersion returns the applied key layout version, or 0 if unset
+func (s *RedisStore) SchemaVersion(ctx context.Context) (int, error) {
+	raw, err := s.client.Get(ctx, s.schemaVersionKey()).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	version, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid schema version %q: %w", raw, err)
+	}
+	return version, nil
+}
+
+// RunMigration applies up and then records version
+func (s *RedisStore) RunMigration(ctx context.Context, version int, up func(context.Context, *RedisStore) error) error {
+	if err := up(ctx, s); err != nil {
+		return err
+	}
+	if err := s.client.Set(ctx, s.schemaVersionKey(), version, 0).Err(); err != nil {
+		return fmt.Errorf("failed to record schema version: %w", err)
+	}
+	return nil
+}
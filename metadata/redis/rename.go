@@ -0,0 +1,168 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// renameAttempts bounds how often RenameSubtree retries after the subtree
+// changed between reading and rewriting it
+const renameAttempts = 5
+
+// errRenameConflict means the subtree changed while it was being renamed
+var errRenameConflict = errors.New("subtree changed during rename")
+
+// luaRenameSubtree applies a rename prepared by renameSubtree. It first
+// checks that the target is free and that no entry of the subtree changed
+// since it was read, then moves every metadata key, children set, path index
+// member and erasure key.
+//
+// KEYS: path index, new root key, old parent children, new parent children,
+// then per entry: old key, new key, old children, new children, old erasure,
+// new erasure.
+// ARGV: old root, new root, descendant count, then per entry: old path, new
+// path, old value, new value, old erasure value, new erasure value.
+const luaRenameSubtree = `
+	if redis.call("EXISTS", KEYS[2]) == 1 then
+		return redis.error_reply("already_exists")
+	end
+	local oldRoot, newRoot = ARGV[1], ARGV[2]
+	local count = redis.call("ZLEXCOUNT", KEYS[1], "(" .. oldRoot .. "/", "(" .. oldRoot .. "0")
+	if count ~= tonumber(ARGV[3]) then
+		return redis.error_reply("conflict")
+	end
+
+	local n = (#KEYS - 4) / 6
+	for i = 0, n - 1 do
+		local k, a = 4 + i*6, 3 + i*6
+		if redis.call("GET", KEYS[k+1]) ~= ARGV[a+3] then
+			return redis.error_reply("conflict")
+		end
+		local erasure = redis.call("GET", KEYS[k+5])
+		if (erasure or "") ~= ARGV[a+5] then
+			return redis.error_reply("conflict")
+		end
+	end
+
+	for i = 0, n - 1 do
+		local k, a = 4 + i*6, 3 + i*6
+		redis.call("DEL", KEYS[k+1])
+		redis.call("SET", KEYS[k+2], ARGV[a+4])
+		redis.call("ZREM", KEYS[1], ARGV[a+1])
+		redis.call("ZADD", KEYS[1], 0, ARGV[a+2])
+		local members = redis.call("SMEMBERS", KEYS[k+3])
+		if #members > 0 then
+			redis.call("DEL", KEYS[k+3])
+			for _, member in ipairs(members) do
+				redis.call("SADD", KEYS[k+4], newRoot .. string.sub(member, #oldRoot + 1))
+			end
+		end
+		if ARGV[a+5] ~= "" then
+			redis.call("DEL", KEYS[k+5])
+			redis.call("SET", KEYS[k+6], ARGV[a+6])
+		end
+	end
+
+	redis.call("SREM", KEYS[3], oldRoot)
+	redis.call("SADD", KEYS[4], newRoot)
+	return "OK"
+`
+
+// RenameSubtree moves oldPath and every entry below it to newPath. The
+// subtree is read and rewritten here and applied by a single Lua script,
+// which refuses it if anything changed in between; the rename is then
+// retried a few times. On Redis Cluster the script's keys share the slot of
+// the hash-tagged key prefix.
+func (s *RedisStore) RenameSubtree(ctx context.Context, oldPath, newPath string) error {
+	for attempt := 0; attempt < renameAttempts; attempt++ {
+		err := s.renameSubtree(ctx, oldPath, newPath)
+		if !errors.Is(err, errRenameConflict) {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to rename metadata: %w", errRenameConflict)
+}
+
+func (s *RedisStore) renameSubtree(ctx context.Context, oldPath, newPath string) error {
+	descendants, err := s.client.ZRangeByLex(ctx, s.pathIndexKey(), &redis.ZRangeBy{Min: "(" + oldPath + "/", Max: "(" + oldPath + "0"}).Result()
+	if err != nil {
+		return fmt.Errorf("failed to list descendant paths: %w", err)
+	}
+	paths := append([]string{oldPath}, descendants...)
+
+	readKeys := make([]string, 0, len(paths)*2)
+	for _, path := range paths {
+		readKeys = append(readKeys, s.metadataKey(path), s.erasureKey(path))
+	}
+	values, err := s.client.MGet(ctx, readKeys...).Result()
+	if err != nil {
+		return fmt.Errorf("failed to get subtree metadata: %w", err)
+	}
+	if values[0] == nil {
+		return metadata.ErrNotFound
+	}
+
+	keys := []string{
+		s.pathIndexKey(),
+		s.metadataKey(newPath),
+		s.childrenKey(parentPath(oldPath)),
+		s.childrenKey(parentPath(newPath)),
+	}
+	args := []interface{}{oldPath, newPath, strconv.Itoa(len(descendants))}
+	for i, path := range paths {
+		raw, ok := values[i*2].(string)
+		if !ok {
+			return errRenameConflict // removed since the index was read
+		}
+		to := newPath + strings.TrimPrefix(path, oldPath)
+
+		var md metadata.Metadata
+		if err := json.Unmarshal([]byte(raw), &md); err != nil {
+			return fmt.Errorf("failed to decode metadata: %w", err)
+		}
+		md.Path = to
+		if path == oldPath {
+			md.Name = filepath.Base(newPath)
+		}
+		newRaw, err := json.Marshal(&md)
+		if err != nil {
+			return fmt.Errorf("failed to encode metadata: %w", err)
+		}
+
+		erasureRaw, _ := values[i*2+1].(string)
+		var newErasureRaw []byte
+		if erasureRaw != "" {
+			var info metadata.ErasureFileInfo
+			if err := json.Unmarshal([]byte(erasureRaw), &info); err != nil {
+				return fmt.Errorf("failed to decode erasure info: %w", err)
+			}
+			info.FilePath = to
+			if newErasureRaw, err = json.Marshal(&info); err != nil {
+				return fmt.Errorf("failed to encode erasure info: %w", err)
+			}
+		}
+
+		keys = append(keys, s.metadataKey(path), s.metadataKey(to), s.childrenKey(path), s.childrenKey(to), s.erasureKey(path), s.erasureKey(to))
+		args = append(args, path, to, raw, string(newRaw), erasureRaw, string(newErasureRaw))
+	}
+
+	if err := s.client.Eval(ctx, luaRenameSubtree, keys, args...).Err(); err != nil {
+		switch {
+		case strings.Contains(err.Error(), "already_exists"):
+			return metadata.ErrAlreadyExists
+		case strings.Contains(err.Error(), "conflict"):
+			return errRenameConflict
+		}
+		return fmt.Errorf("failed to rename metadata: %w", err)
+	}
+	return nil
+}
@@ -6,36 +6,62 @@ import (
 	"fmt"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"go.uber.org/zap"
 
+	"github.com/ebogdum/callfs/internal/redisclient"
 	"github.com/ebogdum/callfs/metadata"
+	"github.com/ebogdum/callfs/metadata/schema"
 )
 
+// linkCleanupBatchSize is how many links a cleanup pass deletes per pipeline
+const linkCleanupBatchSize = 500
+
+// Options tunes the Redis metadata store
+type Options struct {
+	// LinkTTL sets a Redis TTL on each single-use link key at its expires_at,
+	// so Redis evicts expired links without waiting for a cleanup pass
+	LinkTTL bool
+}
+
 type RedisStore struct {
-	client *redis.Client
-	prefix string
-	logger *zap.Logger
+	client  redis.UniversalClient
+	prefix  string
+	linkTTL bool
+	logger  *zap.Logger
 }
 
-func NewRedisStore(addr, password string, db int, prefix string, logger *zap.Logger) (*RedisStore, error) {
+func NewRedisStore(conn redisclient.Options, prefix string, opts Options, logger *zap.Logger) (*RedisStore, error) {
 	if prefix == "" {
 		prefix = "callfs:"
 	}
+	// Scripts and MGET touch several keys at once, which Redis Cluster only
+	// allows within one hash slot, so pin every key to the prefix's slot
+	if strings.EqualFold(conn.Mode, redisclient.ModeCluster) && !hashTagged(prefix) {
+		prefix = "{" + strings.Trim(strings.TrimSuffix(prefix, ":"), "{}") + "}:"
+		logger.Info("Using hash-tagged key prefix for Redis Cluster", zap.String("prefix", prefix))
+	}
 
-	client := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: password,
-		DB:       db,
-	})
+	client, err := redisclient.New(conn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis metadata store configuration: %w", err)
+	}
 	if err := client.Ping(context.Background()).Err(); err != nil {
+		_ = client.Close()
 		return nil, fmt.Errorf("failed to connect to redis metadata store: %w", err)
 	}
 
-	return &RedisStore{client: client, prefix: prefix, logger: logger}, nil
+	store := &RedisStore{client: client, prefix: prefix, linkTTL: opts.LinkTTL, logger: logger}
+	if err := schema.Migrate(context.Background(), "redis", store, migrations, logger); err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	return store, nil
 }
 
 func (s *RedisStore) Get(ctx context.Context, path string) (*metadata.Metadata, error) {
@@ -88,11 +114,12 @@ func (s *RedisStore) Create(ctx context.Context, md *metadata.Metadata) error {
 			return redis.error_reply("already_exists")
 		end
 		redis.call("SADD", KEYS[2], ARGV[2])
+		redis.call("ZADD", KEYS[3], 0, ARGV[2])
 		return "OK"
 	`
 	mdKey := s.metadataKey(md.Path)
 	childKey := s.childrenKey(parentPath(md.Path))
-	result := s.client.Eval(ctx, luaCreate, []string{mdKey, childKey}, raw, md.Path)
+	result := s.client.Eval(ctx, luaCreate, []string{mdKey, childKey, s.pathIndexKey()}, raw, md.Path)
 	if err := result.Err(); err != nil {
 		if strings.Contains(err.Error(), "already_exists") {
 			return metadata.ErrAlreadyExists
@@ -130,12 +157,13 @@ func (s *RedisStore) Delete(ctx context.Context, path string) error {
 		redis.call("DEL", KEYS[1])
 		redis.call("SREM", KEYS[2], ARGV[1])
 		redis.call("DEL", KEYS[3])
+		redis.call("ZREM", KEYS[4], ARGV[1])
 		return "OK"
 	`
 	mdKey := s.metadataKey(path)
 	parentChildKey := s.childrenKey(parentPath(path))
 	ownChildKey := s.childrenKey(path)
-	result := s.client.Eval(ctx, luaDelete, []string{mdKey, parentChildKey, ownChildKey}, path)
+	result := s.client.Eval(ctx, luaDelete, []string{mdKey, parentChildKey, ownChildKey, s.pathIndexKey()}, path)
 	if err := result.Err(); err != nil {
 		if strings.Contains(err.Error(), "not_found") {
 			return metadata.ErrNotFound
@@ -145,6 +173,112 @@ func (s *RedisStore) Delete(ctx context.Context, path string) error {
 	return nil
 }
 
+// Batch applies ops atomically in a single Lua script. Every op is checked
+// against the current keys (and earlier ops in the batch) before any write.
+func (s *RedisStore) Batch(ctx context.Context, ops []metadata.BatchOp) error {
+	if err := metadata.ValidateBatch(ops); err != nil {
+		return err
+	}
+
+	luaBatch := `
+		local present = {}
+		local function exists(key)
+			if present[key] ~= nil then
+				return present[key]
+			end
+			return redis.call("EXISTS", key) == 1
+		end
+
+		local n = #ARGV / 3
+		for i = 0, n - 1 do
+			local op, mdKey = ARGV[i*3+1], KEYS[i*3+2]
+			local found = exists(mdKey)
+			if op == "create" then
+				if found then
+					return redis.error_reply("already_exists")
+				end
+				present[mdKey] = true
+			else
+				if not found then
+					return redis.error_reply("not_found")
+				end
+				if op == "delete" then
+					present[mdKey] = false
+				end
+			end
+		end
+
+		for i = 0, n - 1 do
+			local op, path, raw = ARGV[i*3+1], ARGV[i*3+2], ARGV[i*3+3]
+			local mdKey, parentKey, ownKey = KEYS[i*3+2], KEYS[i*3+3], KEYS[i*3+4]
+			if op == "delete" then
+				redis.call("DEL", mdKey)
+				redis.call("SREM", parentKey, path)
+				redis.call("DEL", ownKey)
+				redis.call("ZREM", KEYS[1], path)
+			else
+				redis.call("SET", mdKey, raw)
+				if op == "create" then
+					redis.call("SADD", parentKey, path)
+					redis.call("ZADD", KEYS[1], 0, path)
+				end
+			end
+		end
+		return "OK"
+	`
+
+	keys := []string{s.pathIndexKey()}
+	args := make([]interface{}, 0, len(ops)*3)
+	now := time.Now().UTC()
+	for _, op := range ops {
+		path := op.Path
+		raw := ""
+		if op.Type != metadata.BatchDelete {
+			md := op.Metadata
+			path = md.Path
+			if op.Type == metadata.BatchCreate {
+				if md.ATime.IsZero() {
+					md.ATime = now
+				}
+				if md.MTime.IsZero() {
+					md.MTime = now
+				}
+				if md.CTime.IsZero() {
+					md.CTime = now
+				}
+				md.CreatedAt = now
+
+				id, err := s.client.Incr(ctx, s.sequenceKey("inode")).Result()
+				if err != nil {
+					return fmt.Errorf("failed to allocate metadata id: %w", err)
+				}
+				md.ID = id
+			}
+			md.UpdatedAt = now
+
+			encoded, err := json.Marshal(md)
+			if err != nil {
+				return fmt.Errorf("failed to encode metadata: %w", err)
+			}
+			raw = string(encoded)
+		}
+
+		keys = append(keys, s.metadataKey(path), s.childrenKey(parentPath(path)), s.childrenKey(path))
+		args = append(args, string(op.Type), path, raw)
+	}
+
+	if err := s.client.Eval(ctx, luaBatch, keys, args...).Err(); err != nil {
+		switch {
+		case strings.Contains(err.Error(), "already_exists"):
+			return metadata.ErrAlreadyExists
+		case strings.Contains(err.Error(), "not_found"):
+			return metadata.ErrNotFound
+		}
+		return fmt.Errorf("failed to apply metadata batch: %w", err)
+	}
+	return nil
+}
+
 func (s *RedisStore) ListChildren(ctx context.Context, parentPath string) ([]*metadata.Metadata, error) {
 	paths, err := s.client.SMembers(ctx, s.childrenKey(parentPath)).Result()
 	if err != nil {
@@ -173,6 +307,118 @@ func (s *RedisStore) ListChildren(ctx context.Context, parentPath string) ([]*me
 	return children, nil
 }
 
+// ListDescendants lists every entry below prefix in path order. Paths are kept
+// in a lexicographically sorted set, so the subtree is a ZRANGEBYLEX followed
+// by one MGET. Entries removed since the index was read are skipped and the
+// page filled from further along the index, so a short page is the last.
+func (s *RedisStore) ListDescendants(ctx context.Context, prefix string, limit int, cursor string) ([]*metadata.Metadata, error) {
+	base := strings.TrimSuffix(prefix, "/")
+	lower := base + "/"
+	if cursor > lower {
+		lower = cursor
+	}
+
+	items := []*metadata.Metadata{}
+	for {
+		rangeBy := &redis.ZRangeBy{Min: "(" + lower, Max: "(" + base + "0"}
+		if limit > 0 {
+			rangeBy.Count = int64(limit - len(items))
+		}
+		paths, err := s.client.ZRangeByLex(ctx, s.pathIndexKey(), rangeBy).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list descendant paths: %w", err)
+		}
+		if len(paths) == 0 {
+			return items, nil
+		}
+
+		keys := make([]string, len(paths))
+		for i, path := range paths {
+			keys[i] = s.metadataKey(path)
+		}
+		values, err := s.client.MGet(ctx, keys...).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get descendant metadata: %w", err)
+		}
+		for _, value := range values {
+			raw, ok := value.(string)
+			if !ok {
+				continue // removed since the index was read
+			}
+			var md metadata.Metadata
+			if err := json.Unmarshal([]byte(raw), &md); err != nil {
+				return nil, fmt.Errorf("failed to decode metadata: %w", err)
+			}
+			items = append(items, &md)
+		}
+		if limit <= 0 || len(items) >= limit || int64(len(paths)) < rangeBy.Count {
+			return items, nil
+		}
+		lower = paths[len(paths)-1]
+	}
+}
+
+// usagePageSize is the number of paths counted per Usage round trip
+const usagePageSize = 1000
+
+// Usage counts inodes and file bytes by paging through the path index. Pages
+// are read one after another, so the counts are not a single snapshot.
+func (s *RedisStore) Usage(ctx context.Context) (*metadata.Usage, error) {
+	var counter metadata.UsageCounter
+	for start := int64(0); ; start += usagePageSize {
+		paths, err := s.client.ZRange(ctx, s.pathIndexKey(), start, start+usagePageSize-1).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list paths: %w", err)
+		}
+		if len(paths) == 0 {
+			return counter.Usage(), nil
+		}
+
+		keys := make([]string, len(paths))
+		for i, path := range paths {
+			keys[i] = s.metadataKey(path)
+		}
+		values, err := s.client.MGet(ctx, keys...).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get metadata: %w", err)
+		}
+		for _, value := range values {
+			raw, ok := value.(string)
+			if !ok {
+				continue // removed since the index was read
+			}
+			var md metadata.Metadata
+			if err := json.Unmarshal([]byte(raw), &md); err != nil {
+				return nil, fmt.Errorf("failed to decode metadata: %w", err)
+			}
+			counter.Add(&md)
+		}
+		if len(paths) < usagePageSize {
+			return counter.Usage(), nil
+		}
+	}
+}
+
+// DirectoryUsage counts the children of path and the bytes below it by
+// paging through the part of the path index below path
+func (s *RedisStore) DirectoryUsage(ctx context.Context, path string) (*metadata.DirectoryUsage, error) {
+	var usage metadata.DirectoryUsage
+	cursor := ""
+	for {
+		items, err := s.ListDescendants(ctx, path, usagePageSize, cursor)
+		if err != nil {
+			return nil, err
+		}
+		for _, md := range items {
+			usage.Count(path, md)
+		}
+		if len(items) < usagePageSize {
+			return &usage, nil
+		}
+		cursor = items[len(items)-1].Path
+	}
+}
+
 func (s *RedisStore) GetSingleUseLink(ctx context.Context, token string) (*metadata.SingleUseLink, error) {
 	raw, err := s.client.Get(ctx, s.linkKey(token)).Result()
 	if err != nil {
@@ -189,6 +435,55 @@ func (s *RedisStore) GetSingleUseLink(ctx context.Context, token string) (*metad
 	return &link, nil
 }
 
+// ListSingleUseLinks returns links ordered by token, starting after cursor,
+// using the lexicographic token index. Links expired or removed since the
+// index was read are skipped and the page filled from further along it.
+func (s *RedisStore) ListSingleUseLinks(ctx context.Context, limit int, cursor string) ([]*metadata.SingleUseLink, error) {
+	min := "-"
+	if cursor != "" {
+		min = "(" + cursor
+	}
+
+	links := []*metadata.SingleUseLink{}
+	for {
+		rangeBy := &redis.ZRangeBy{Min: min, Max: "+"}
+		if limit > 0 {
+			rangeBy.Count = int64(limit - len(links))
+		}
+		tokens, err := s.client.ZRangeByLex(ctx, s.linkTokenIndexKey(), rangeBy).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list single-use links: %w", err)
+		}
+		if len(tokens) == 0 {
+			return links, nil
+		}
+
+		keys := make([]string, len(tokens))
+		for i, token := range tokens {
+			keys[i] = s.linkKey(token)
+		}
+		values, err := s.client.MGet(ctx, keys...).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get single-use links: %w", err)
+		}
+		for _, value := range values {
+			raw, ok := value.(string)
+			if !ok {
+				continue // expired or removed since the index was read
+			}
+			var link metadata.SingleUseLink
+			if err := json.Unmarshal([]byte(raw), &link); err != nil {
+				return nil, fmt.Errorf("failed to decode single-use link: %w", err)
+			}
+			links = append(links, &link)
+		}
+		if limit <= 0 || len(links) >= limit || int64(len(tokens)) < rangeBy.Count {
+			return links, nil
+		}
+		min = "(" + tokens[len(tokens)-1]
+	}
+}
+
 func (s *RedisStore) CreateSingleUseLink(ctx context.Context, link *metadata.SingleUseLink) error {
 	now := time.Now().UTC()
 	if link.CreatedAt.IsZero() {
@@ -207,52 +502,81 @@ func (s *RedisStore) CreateSingleUseLink(ctx context.Context, link *metadata.Sin
 		return fmt.Errorf("failed to encode single-use link: %w", err)
 	}
 
-	stored, err := s.client.SetNX(ctx, s.linkKey(link.Token), raw, 0).Result()
-	if err != nil {
-		return fmt.Errorf("failed to create single-use link: %w", err)
+	// Atomic create: SETNX plus the expiry and token index entries, and an
+	// optional key TTL at expires_at
+	luaCreate := `
+		if redis.call("SETNX", KEYS[1], ARGV[1]) == 0 then
+			return redis.error_reply("already_exists")
+		end
+		redis.call("ZADD", KEYS[2], ARGV[3], ARGV[2])
+		redis.call("ZADD", KEYS[3], 0, ARGV[2])
+		if ARGV[4] == "1" then
+			redis.call("PEXPIREAT", KEYS[1], ARGV[3])
+		end
+		return "OK"
+	`
+	ttl := "0"
+	if s.linkTTL {
+		ttl = "1"
 	}
-	if !stored {
-		return metadata.ErrAlreadyExists
+	result := s.client.Eval(ctx, luaCreate,
+		[]string{s.linkKey(link.Token), s.linkExpiryIndexKey(), s.linkTokenIndexKey()},
+		raw, link.Token, link.ExpiresAt.UnixMilli(), ttl)
+	if err := result.Err(); err != nil {
+		if strings.Contains(err.Error(), "already_exists") {
+			return metadata.ErrAlreadyExists
+		}
+		return fmt.Errorf("failed to create single-use link: %w", err)
 	}
 
 	return nil
 }
 
 func (s *RedisStore) UpdateSingleUseLink(ctx context.Context, token string, status string, usedAt *time.Time, usedByIP *string) error {
-	// Use a Lua script for atomic check-and-set to prevent double-spend
+	// Use a Lua script for atomic check-and-set to prevent double-spend. The
+	// key's remaining TTL, if any, is carried over the SET.
 	luaScript := `
 		local raw = redis.call("GET", KEYS[1])
 		if not raw then
 			return redis.error_reply("not_found")
 		end
 		local link = cjson.decode(raw)
-		if link.Status ~= "active" then
+		if link.status ~= "active" then
 			return redis.error_reply("not_active")
 		end
-		link.Status = ARGV[1]
+		link.status = ARGV[1]
 		if ARGV[2] ~= "" then
-			link.UsedAt = ARGV[2]
+			link.used_at = ARGV[2]
 		end
 		if ARGV[3] ~= "" then
-			link.UsedByIP = ARGV[3]
+			link.used_by_ip = ARGV[3]
 		end
-		link.UpdatedAt = ARGV[4]
+		link.updated_at = ARGV[4]
+		local ttl = redis.call("PTTL", KEYS[1])
 		redis.call("SET", KEYS[1], cjson.encode(link))
+		if ttl > 0 then
+			redis.call("PEXPIRE", KEYS[1], ttl)
+		end
+		if ARGV[1] == "used" and ARGV[5] ~= "" then
+			redis.call("ZADD", KEYS[2], ARGV[5], ARGV[6])
+		end
 		return "OK"
 	`
 
 	now := time.Now().UTC()
 	usedAtStr := ""
+	usedAtScore := ""
 	if usedAt != nil {
 		usedAtStr = usedAt.UTC().Format(time.RFC3339Nano)
+		usedAtScore = strconv.FormatInt(usedAt.UnixMilli(), 10)
 	}
 	usedByIPStr := ""
 	if usedByIP != nil {
 		usedByIPStr = *usedByIP
 	}
 
-	result := s.client.Eval(ctx, luaScript, []string{s.linkKey(token)},
-		status, usedAtStr, usedByIPStr, now.Format(time.RFC3339Nano))
+	result := s.client.Eval(ctx, luaScript, []string{s.linkKey(token), s.linkUsedIndexKey()},
+		status, usedAtStr, usedByIPStr, now.Format(time.RFC3339Nano), usedAtScore, token)
 
 	if err := result.Err(); err != nil {
 		errMsg := err.Error()
@@ -267,62 +591,89 @@ func (s *RedisStore) UpdateSingleUseLink(ctx context.Context, token string, stat
 	return nil
 }
 
+// CleanupExpiredLinks removes links whose expires_at is before the given time,
+// reading candidates from the expiry index
 func (s *RedisStore) CleanupExpiredLinks(ctx context.Context, before time.Time) (int, error) {
-	count := 0
-	iter := s.client.Scan(ctx, 0, s.linkKey("*"), 0).Iterator()
-	for iter.Next(ctx) {
-		raw, err := s.client.Get(ctx, iter.Val()).Result()
-		if err != nil {
-			continue
-		}
-
-		var link metadata.SingleUseLink
-		if err := json.Unmarshal([]byte(raw), &link); err != nil {
-			continue
-		}
-
-		if link.ExpiresAt.Before(before) {
-			if err := s.client.Del(ctx, iter.Val()).Err(); err == nil {
-				count++
-			}
-		}
-	}
-	if err := iter.Err(); err != nil {
+	count, err := s.cleanupLinks(ctx, s.linkExpiryIndexKey(), before)
+	if err != nil {
 		return count, fmt.Errorf("failed to cleanup expired links: %w", err)
 	}
 	return count, nil
 }
 
+// CleanupUsedLinks removes used links whose used_at is before the given time,
+// reading candidates from the used index
 func (s *RedisStore) CleanupUsedLinks(ctx context.Context, olderThan time.Time) (int, error) {
+	count, err := s.cleanupLinks(ctx, s.linkUsedIndexKey(), olderThan)
+	if err != nil {
+		return count, fmt.Errorf("failed to cleanup used links: %w", err)
+	}
+	return count, nil
+}
+
+// cleanupLinks deletes links scored before cutoff in index, in pipelined
+// batches, and drops them from every link index. Index entries whose key has
+// already expired through its TTL are removed without being counted.
+func (s *RedisStore) cleanupLinks(ctx context.Context, index string, cutoff time.Time) (int, error) {
+	max := "(" + strconv.FormatInt(cutoff.UnixMilli(), 10)
 	count := 0
-	iter := s.client.Scan(ctx, 0, s.linkKey("*"), 0).Iterator()
-	for iter.Next(ctx) {
-		raw, err := s.client.Get(ctx, iter.Val()).Result()
+	for {
+		tokens, err := s.client.ZRangeByScore(ctx, index, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   max,
+			Count: linkCleanupBatchSize,
+		}).Result()
 		if err != nil {
-			continue
+			return count, err
 		}
-
-		var link metadata.SingleUseLink
-		if err := json.Unmarshal([]byte(raw), &link); err != nil {
-			continue
+		if len(tokens) == 0 {
+			return count, nil
 		}
 
-		if link.Status == "used" && link.UsedAt != nil && link.UsedAt.Before(olderThan) {
-			if err := s.client.Del(ctx, iter.Val()).Err(); err == nil {
-				count++
+		members := make([]interface{}, len(tokens))
+		dels := make([]*redis.IntCmd, len(tokens))
+		_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, token := range tokens {
+				members[i] = token
+				dels[i] = pipe.Del(ctx, s.linkKey(token))
 			}
+			pipe.ZRem(ctx, s.linkExpiryIndexKey(), members...)
+			pipe.ZRem(ctx, s.linkUsedIndexKey(), members...)
+			pipe.ZRem(ctx, s.linkTokenIndexKey(), members...)
+			return nil
+		})
+		if err != nil {
+			return count, err
+		}
+		for _, del := range dels {
+			count += int(del.Val())
+		}
+		if len(tokens) < linkCleanupBatchSize {
+			return count, nil
 		}
 	}
-	if err := iter.Err(); err != nil {
-		return count, fmt.Errorf("failed to cleanup used links: %w", err)
-	}
-	return count, nil
+}
+
+func (s *RedisStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
 }
 
 func (s *RedisStore) Close() error {
 	return s.client.Close()
 }
 
+// hashTagged reports whether keys starting with prefix all hash to the same
+// Redis Cluster slot: the prefix holds a non-empty {tag}, the first '{' of a
+// key and the first '}' after it
+func hashTagged(prefix string) bool {
+	open := strings.Index(prefix, "{")
+	if open < 0 {
+		return false
+	}
+	end := strings.Index(prefix[open+1:], "}")
+	return end > 0
+}
+
 func (s *RedisStore) metadataKey(path string) string {
 	return s.prefix + "md:" + normalizePath(path)
 }
@@ -331,10 +682,33 @@ func (s *RedisStore) childrenKey(path string) string {
 	return s.prefix + "children:" + normalizePath(path)
 }
 
+func (s *RedisStore) pathIndexKey() string {
+	return s.prefix + "paths"
+}
+
 func (s *RedisStore) linkKey(token string) string {
 	return s.prefix + "sul:" + token
 }
 
+// linkExpiryIndexKey scores every link token by expires_at in milliseconds
+func (s *RedisStore) linkExpiryIndexKey() string {
+	return s.prefix + "links:expires"
+}
+
+// linkUsedIndexKey scores used link tokens by used_at in milliseconds
+func (s *RedisStore) linkUsedIndexKey() string {
+	return s.prefix + "links:used"
+}
+
+// linkTokenIndexKey holds every link token at score 0 for lexicographic paging
+func (s *RedisStore) linkTokenIndexKey() string {
+	return s.prefix + "links:tokens"
+}
+
+func (s *RedisStore) schemaVersionKey() string {
+	return s.prefix + "schema_version"
+}
+
 func (s *RedisStore) sequenceKey(name string) string {
 	return s.prefix + "seq:" + name
 }
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_inodes_path_prefix;
@@ -0,0 +1,3 @@
+-- Pattern-ops index so prefix (LIKE 'dir/%') scans over inode paths can use an
+-- index regardless of the database collation
+CREATE INDEX IF NOT EXISTS idx_inodes_path_prefix ON inodes (path text_pattern_ops);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_intents_instance;
+DROP TABLE IF EXISTS intents;
@@ -0,0 +1,13 @@
+-- Pending file operations, recorded before the backend is written and removed
+-- once the metadata is committed
+CREATE TABLE IF NOT EXISTS intents (
+    id           VARCHAR(64) PRIMARY KEY,
+    instance_id  VARCHAR(100) NOT NULL,
+    op           VARCHAR(16) NOT NULL,
+    path         TEXT NOT NULL,
+    backend_type VARCHAR(50) NOT NULL,
+    owner        VARCHAR(100) NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_intents_instance ON intents(instance_id, created_at);
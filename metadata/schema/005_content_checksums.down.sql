@@ -0,0 +1 @@
+ALTER TABLE inodes DROP COLUMN IF EXISTS checksum;
@@ -0,0 +1,2 @@
+-- SHA-256 of a file's content, "sha256=<hex>", or empty when not yet known
+ALTER TABLE inodes ADD COLUMN IF NOT EXISTS checksum TEXT NOT NULL DEFAULT '';
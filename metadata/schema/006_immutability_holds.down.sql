@@ -0,0 +1,2 @@
+ALTER TABLE inodes DROP COLUMN IF EXISTS retain_until;
+ALTER TABLE inodes DROP COLUMN IF EXISTS immutable;
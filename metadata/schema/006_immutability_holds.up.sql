@@ -0,0 +1,4 @@
+-- Immutable files refuse update, delete and rename until retain_until, or
+-- indefinitely when it is NULL
+ALTER TABLE inodes ADD COLUMN IF NOT EXISTS immutable BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE inodes ADD COLUMN IF NOT EXISTS retain_until TIMESTAMPTZ;
@@ -0,0 +1,8 @@
+-- Download counts of files, added to periodically by each instance
+CREATE TABLE IF NOT EXISTS access_stats (
+    path           TEXT PRIMARY KEY,
+    downloads      BIGINT NOT NULL DEFAULT 0,
+    link_downloads BIGINT NOT NULL DEFAULT 0,
+    bytes_served   BIGINT NOT NULL DEFAULT 0,
+    last_access    TIMESTAMPTZ NOT NULL
+);
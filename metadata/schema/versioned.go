@@ -0,0 +1,59 @@
+package schema
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// Migration is one numbered schema step for a store that does not use SQL
+// migration files. T is what the step operates on, such as a transaction.
+type Migration[T any] struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, exec T) error
+}
+
+// VersionedStore is implemented by stores that record their schema version
+// (a schema_version table or key) alongside their data
+type VersionedStore[T any] interface {
+	// SchemaVersion returns the applied version, or 0 for a store that has
+	// never been migrated
+	SchemaVersion(ctx context.Context) (int, error)
+
+	// RunMigration runs up and records version as applied. Stores that support
+	// transactions do both atomically; others require up to be idempotent.
+	RunMigration(ctx context.Context, version int, up func(context.Context, T) error) error
+}
+
+// Migrate applies every migration newer than the store's schema version, in
+// order. It refuses to run against a store written by a newer release.
+func Migrate[T any](ctx context.Context, storeName string, store VersionedStore[T], migrations []Migration[T], logger *zap.Logger) error {
+	latest := 0
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return fmt.Errorf("%s migration %d has version %d; versions must be sequential from 1", storeName, i, m.Version)
+		}
+		latest = m.Version
+	}
+
+	current, err := store.SchemaVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read %s schema version: %w", storeName, err)
+	}
+	if current > latest {
+		return fmt.Errorf("%s schema version %d is newer than this release supports (%d)", storeName, current, latest)
+	}
+
+	for _, m := range migrations[current:] {
+		logger.Info("Applying metadata schema migration",
+			zap.String("store", storeName),
+			zap.Int("version", m.Version),
+			zap.String("description", m.Description))
+		if err := store.RunMigration(ctx, m.Version, m.Up); err != nil {
+			return fmt.Errorf("%s migration %d (%s) failed: %w", storeName, m.Version, m.Description, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// accessTimeFormat is RFC 3339 with a fixed number of digits, so that later
+// times compare greater as text
+const accessTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+// AddAccessStats adds the counts of each entry to those stored for its path.
+func (s *SQLiteStore) AddAccessStats(ctx context.Context, stats []*metadata.AccessStats) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, st := range stats {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO access_stats (path, downloads, link_downloads, bytes_served, last_access)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(path) DO UPDATE SET
+			    downloads = downloads + excluded.downloads,
+			    link_downloads = link_downloads + excluded.link_downloads,
+			    bytes_served = bytes_served + excluded.bytes_served,
+			    last_access = MAX(last_access, excluded.last_access)`,
+			st.Path, st.Downloads, st.LinkDownloads, st.BytesServed, st.LastAccess.UTC().Format(accessTimeFormat))
+		if err != nil {
+			return fmt.Errorf("failed to add access statistics: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// GetAccessStats returns the access statistics of path.
+func (s *SQLiteStore) GetAccessStats(ctx context.Context, path string) (*metadata.AccessStats, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT path, downloads, link_downloads, bytes_served, last_access
+		FROM access_stats WHERE path = ?`, path)
+	st, err := scanAccessStats(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, metadata.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access statistics: %w", err)
+	}
+	return st, nil
+}
+
+// ListAccessStats returns the access statistics of the paths below prefix,
+// ordered by path.
+func (s *SQLiteStore) ListAccessStats(ctx context.Context, prefix string, limit int, cursor string) ([]*metadata.AccessStats, error) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	query := `
+		SELECT path, downloads, link_downloads, bytes_served, last_access
+		FROM access_stats
+		WHERE path > ? AND path < ? AND path > ?
+		ORDER BY path`
+	args := []any{prefix + "/", prefix + "0", cursor}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list access statistics: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []*metadata.AccessStats
+	for rows.Next() {
+		st, err := scanAccessStats(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan access statistics: %w", err)
+		}
+		stats = append(stats, st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+	return stats, nil
+}
+
+// DeleteAccessStats removes the access statistics of path and of the paths
+// below it.
+func (s *SQLiteStore) DeleteAccessStats(ctx context.Context, path string) error {
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM access_stats WHERE path = ? OR (path > ? AND path < ?)`,
+		path, path+"/", path+"0")
+	if err != nil {
+		return fmt.Errorf("failed to delete access statistics: %w", err)
+	}
+	return nil
+}
+
+func scanAccessStats(row interface{ Scan(...any) error }) (*metadata.AccessStats, error) {
+	var st metadata.AccessStats
+	var lastAccess string
+	if err := row.Scan(&st.Path, &st.Downloads, &st.LinkDownloads, &st.BytesServed, &lastAccess); err != nil {
+		return nil, err
+	}
+	st.LastAccess = parseTimestamp(lastAccess)
+	return &st, nil
+}
@@ -0,0 +1,98 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// BackupRequest asks for an online backup. Name is a file name inside the
+// configured backup directory; a timestamped name is used when it is empty.
+type BackupRequest struct {
+	Name       string `json:"name,omitempty"`
+	Checkpoint bool   `json:"checkpoint,omitempty"` // truncate the WAL after the backup
+}
+
+// CheckpointRequest asks for a WAL checkpoint in the given mode
+// (passive, full, restart or truncate; default truncate)
+type CheckpointRequest struct {
+	Mode string `json:"mode,omitempty"`
+}
+
+// CheckpointResult reports the outcome of PRAGMA wal_checkpoint
+type CheckpointResult struct {
+	Busy               bool `json:"busy"`
+	LogFrames          int  `json:"log_frames"`
+	CheckpointedFrames int  `json:"checkpointed_frames"`
+}
+
+// MaintenanceResponse is returned by the sqlite maintenance endpoints
+type MaintenanceResponse struct {
+	Status     string            `json:"status"`
+	Path       string            `json:"path,omitempty"`
+	SizeBytes  int64             `json:"size_bytes,omitempty"`
+	Checkpoint *CheckpointResult `json:"checkpoint,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// Backup writes a transactionally consistent copy of the database to
+// backupDir using VACUUM INTO, while readers and writers keep running. The
+// target must not already exist. It returns the path written.
+func (s *SQLiteStore) Backup(ctx context.Context, backupDir, name string) (string, error) {
+	if strings.TrimSpace(backupDir) == "" {
+		return "", fmt.Errorf("backup directory is not configured")
+	}
+	if name == "" {
+		name = fmt.Sprintf("callfs-%s.sqlite3", time.Now().UTC().Format("20060102T150405Z"))
+	}
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("backup name must be a plain file name")
+	}
+
+	if err := os.MkdirAll(backupDir, 0o750); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	target := filepath.Join(backupDir, name)
+	if _, err := os.Stat(target); err == nil {
+		return "", fmt.Errorf("backup target %s already exists", target)
+	}
+
+	start := time.Now()
+	if _, err := s.db.ExecContext(ctx, "VACUUM INTO ?", target); err != nil {
+		return "", fmt.Errorf("failed to back up sqlite database: %w", err)
+	}
+
+	s.logger.Info("SQLite metadata backup written",
+		zap.String("path", target),
+		zap.Duration("duration", time.Since(start)))
+	return target, nil
+}
+
+// Checkpoint copies WAL frames into the main database file. truncate also
+// resets the WAL to zero bytes, which keeps it from growing without bound.
+func (s *SQLiteStore) Checkpoint(ctx context.Context, mode string) (*CheckpointResult, error) {
+	mode = strings.ToUpper(strings.TrimSpace(mode))
+	if mode == "" {
+		mode = "TRUNCATE"
+	}
+	switch mode {
+	case "PASSIVE", "FULL", "RESTART", "TRUNCATE":
+	default:
+		return nil, fmt.Errorf("checkpoint mode must be one of: passive, full, restart, truncate")
+	}
+
+	var busy int
+	var result CheckpointResult
+	// mode is validated above; PRAGMA arguments cannot be bound
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf("PRAGMA wal_checkpoint(%s)", mode)).Scan(&busy, &result.LogFrames, &result.CheckpointedFrames)
+	if err != nil {
+		return nil, fmt.Errorf("failed to checkpoint sqlite WAL: %w", err)
+	}
+	result.Busy = busy != 0
+	return &result, nil
+}
@@ -8,39 +8,6 @@ import (
 	"github.com/ebogdum/callfs/metadata"
 )
 
-func (s *SQLiteStore) initErasureSchema() error {
-	schema := `
-CREATE TABLE IF NOT EXISTS erasure_profiles (
-    file_path     TEXT PRIMARY KEY,
-    data_shards   INTEGER NOT NULL,
-    parity_shards INTEGER NOT NULL,
-    shard_size    INTEGER NOT NULL,
-    original_size INTEGER NOT NULL,
-    created_at    TEXT NOT NULL DEFAULT (datetime('now'))
-);
-
-CREATE TABLE IF NOT EXISTS erasure_shards (
-    id            INTEGER PRIMARY KEY AUTOINCREMENT,
-    file_path     TEXT NOT NULL,
-    shard_index   INTEGER NOT NULL,
-    instance_id   TEXT NOT NULL,
-    backend_type  TEXT NOT NULL,
-    shard_path    TEXT NOT NULL,
-    shard_size    INTEGER NOT NULL,
-    checksum      TEXT NOT NULL,
-    created_at    TEXT NOT NULL DEFAULT (datetime('now')),
-    UNIQUE(file_path, shard_index)
-);
-
-CREATE INDEX IF NOT EXISTS idx_erasure_shards_file ON erasure_shards(file_path);
-CREATE INDEX IF NOT EXISTS idx_erasure_shards_instance ON erasure_shards(instance_id);
-`
-	if _, err := s.db.Exec(schema); err != nil {
-		return fmt.Errorf("failed to initialize erasure schema: %w", err)
-	}
-	return nil
-}
-
 // CreateErasureInfo stores erasure coding metadata for a file.
 func (s *SQLiteStore) CreateErasureInfo(ctx context.Context, filePath string, info *metadata.ErasureFileInfo) error {
 	tx, err := s.db.BeginTx(ctx, nil)
@@ -0,0 +1,165 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ebogdum/callfs/metadata/schema"
+)
+
+// migrations upgrade the SQLite schema in order. Append new steps; never edit
+// or reorder released ones. Databases created before versioning have the
+// version 1 and 2 tables already, which the IF NOT EXISTS clauses tolerate.
+var migrations = []schema.Migration[*sql.Tx]{
+	{Version: 1, Description: "inodes and single-use links", Up: execMigration(`
+CREATE TABLE IF NOT EXISTS inodes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    parent_id INTEGER,
+    name TEXT NOT NULL,
+    path TEXT NOT NULL UNIQUE,
+    type TEXT NOT NULL CHECK (type IN ('file', 'directory')),
+    size INTEGER NOT NULL DEFAULT 0,
+    mode TEXT NOT NULL,
+    uid INTEGER NOT NULL,
+    gid INTEGER NOT NULL,
+    atime TEXT NOT NULL,
+    mtime TEXT NOT NULL,
+    ctime TEXT NOT NULL,
+    backend_type TEXT NOT NULL,
+    callfs_instance_id TEXT,
+    symlink_target TEXT,
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_inodes_path ON inodes(path);
+CREATE INDEX IF NOT EXISTS idx_inodes_parent_id ON inodes(parent_id);
+
+CREATE TABLE IF NOT EXISTS single_use_links (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token TEXT NOT NULL UNIQUE,
+    file_path TEXT NOT NULL,
+    status TEXT NOT NULL,
+    expires_at TEXT NOT NULL,
+    used_at TEXT,
+    used_by_ip TEXT,
+    hmac_signature TEXT NOT NULL,
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_single_use_links_token ON single_use_links(token);
+CREATE INDEX IF NOT EXISTS idx_single_use_links_status ON single_use_links(status);
+CREATE INDEX IF NOT EXISTS idx_single_use_links_expires_at ON single_use_links(expires_at);
+`)},
+	{Version: 2, Description: "erasure coding profiles and shards", Up: execMigration(`
+CREATE TABLE IF NOT EXISTS erasure_profiles (
+    file_path     TEXT PRIMARY KEY,
+    data_shards   INTEGER NOT NULL,
+    parity_shards INTEGER NOT NULL,
+    shard_size    INTEGER NOT NULL,
+    original_size INTEGER NOT NULL,
+    created_at    TEXT NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE TABLE IF NOT EXISTS erasure_shards (
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    file_path     TEXT NOT NULL,
+    shard_index   INTEGER NOT NULL,
+    instance_id   TEXT NOT NULL,
+    backend_type  TEXT NOT NULL,
+    shard_path    TEXT NOT NULL,
+    shard_size    INTEGER NOT NULL,
+    checksum      TEXT NOT NULL,
+    created_at    TEXT NOT NULL DEFAULT (datetime('now')),
+    UNIQUE(file_path, shard_index)
+);
+
+CREATE INDEX IF NOT EXISTS idx_erasure_shards_file ON erasure_shards(file_path);
+CREATE INDEX IF NOT EXISTS idx_erasure_shards_instance ON erasure_shards(instance_id);
+`)},
+	{Version: 3, Description: "intent journal", Up: execMigration(`
+CREATE TABLE IF NOT EXISTS intents (
+    id           TEXT PRIMARY KEY,
+    instance_id  TEXT NOT NULL,
+    op           TEXT NOT NULL,
+    path         TEXT NOT NULL,
+    backend_type TEXT NOT NULL,
+    owner        TEXT NOT NULL DEFAULT '',
+    created_at   TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_intents_instance ON intents(instance_id, created_at);
+`)},
+	{Version: 4, Description: "content checksums", Up: execMigration(`
+ALTER TABLE inodes ADD COLUMN checksum TEXT NOT NULL DEFAULT '';
+`)},
+	{Version: 5, Description: "immutability holds", Up: execMigration(`
+ALTER TABLE inodes ADD COLUMN immutable INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE inodes ADD COLUMN retain_until TEXT;
+`)},
+	{Version: 6, Description: "access statistics", Up: execMigration(`
+CREATE TABLE IF NOT EXISTS access_stats (
+    path           TEXT PRIMARY KEY,
+    downloads      INTEGER NOT NULL DEFAULT 0,
+    link_downloads INTEGER NOT NULL DEFAULT 0,
+    bytes_served   INTEGER NOT NULL DEFAULT 0,
+    last_access    TEXT NOT NULL
+);
+`)},
+}
+
+func execMigration(stmts string) func(context.Context, *sql.Tx) error {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, stmts)
+		return err
+	}
+}
+
+// SchemaVersion returns the highest applied migration, creating the
+// schema_version table on first use
+func (s *SQLiteStore) SchemaVersion(ctx context.Context) (int, error) {
+	_, err := s.db.ExecContext(ctx, `
+CREATE TABLE IF NOT EXISTS schema_version (
+    version    INTEGER PRIMARY KEY,
+    applied_at TEXT NOT NULL
+)`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create schema_version table: %w", err)
+	}
+
+	var version int
+	if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// RunMigration applies up and records version in a single transaction. The
+// version row is claimed first, which takes the write lock, so an instance
+// starting concurrently on the same file skips a step the other has applied.
+func (s *SQLiteStore) RunMigration(ctx context.Context, version int, up func(context.Context, *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`INSERT OR IGNORE INTO schema_version (version, applied_at) VALUES (?, ?)`,
+		version, time.Now().UTC().Format(time.RFC3339Nano),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record schema version: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil // Applied by another instance
+	}
+
+	if err := up(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
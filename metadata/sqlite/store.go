@@ -5,14 +5,18 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"path/filepath"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	_ "modernc.org/sqlite"
 
 	"go.uber.org/zap"
 
+	corelog "github.com/ebogdum/callfs/core/log"
 	"github.com/ebogdum/callfs/metadata"
+	"github.com/ebogdum/callfs/metadata/schema"
 )
 
 type SQLiteStore struct {
@@ -20,6 +24,12 @@ type SQLiteStore struct {
 	logger *zap.Logger
 }
 
+// dbExecutor is satisfied by both *sql.DB and *sql.Tx, so inode writes can run
+// standalone or inside a Batch transaction
+type dbExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
 func NewSQLiteStore(dbPath string, logger *zap.Logger) (*SQLiteStore, error) {
 	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", dbPath)
 	db, err := sql.Open("sqlite", dsn)
@@ -33,11 +43,7 @@ func NewSQLiteStore(dbPath string, logger *zap.Logger) (*SQLiteStore, error) {
 	}
 
 	store := &SQLiteStore{db: db, logger: logger}
-	if err := store.initSchema(); err != nil {
-		_ = db.Close()
-		return nil, err
-	}
-	if err := store.initErasureSchema(); err != nil {
+	if err := schema.Migrate(context.Background(), "sqlite", store, migrations, logger); err != nil {
 		_ = db.Close()
 		return nil, err
 	}
@@ -45,67 +51,18 @@ func NewSQLiteStore(dbPath string, logger *zap.Logger) (*SQLiteStore, error) {
 	return store, nil
 }
 
-func (s *SQLiteStore) initSchema() error {
-	schema := `
-CREATE TABLE IF NOT EXISTS inodes (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    parent_id INTEGER,
-    name TEXT NOT NULL,
-    path TEXT NOT NULL UNIQUE,
-    type TEXT NOT NULL CHECK (type IN ('file', 'directory')),
-    size INTEGER NOT NULL DEFAULT 0,
-    mode TEXT NOT NULL,
-    uid INTEGER NOT NULL,
-    gid INTEGER NOT NULL,
-    atime TEXT NOT NULL,
-    mtime TEXT NOT NULL,
-    ctime TEXT NOT NULL,
-    backend_type TEXT NOT NULL,
-    callfs_instance_id TEXT,
-    symlink_target TEXT,
-    created_at TEXT NOT NULL,
-    updated_at TEXT NOT NULL
-);
-
-CREATE INDEX IF NOT EXISTS idx_inodes_path ON inodes(path);
-CREATE INDEX IF NOT EXISTS idx_inodes_parent_id ON inodes(parent_id);
-
-CREATE TABLE IF NOT EXISTS single_use_links (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    token TEXT NOT NULL UNIQUE,
-    file_path TEXT NOT NULL,
-    status TEXT NOT NULL,
-    expires_at TEXT NOT NULL,
-    used_at TEXT,
-    used_by_ip TEXT,
-    hmac_signature TEXT NOT NULL,
-    created_at TEXT NOT NULL,
-    updated_at TEXT NOT NULL
-);
-
-CREATE INDEX IF NOT EXISTS idx_single_use_links_token ON single_use_links(token);
-CREATE INDEX IF NOT EXISTS idx_single_use_links_status ON single_use_links(status);
-CREATE INDEX IF NOT EXISTS idx_single_use_links_expires_at ON single_use_links(expires_at);
-`
-
-	if _, err := s.db.Exec(schema); err != nil {
-		return fmt.Errorf("failed to initialize sqlite schema: %w", err)
-	}
-	return nil
-}
-
 func (s *SQLiteStore) Get(ctx context.Context, path string) (*metadata.Metadata, error) {
 	query := `
 		SELECT id, parent_id, name, path, type, size, mode, uid, gid,
 		       atime, mtime, ctime, backend_type, callfs_instance_id,
-		       symlink_target, created_at, updated_at
+		       symlink_target, checksum, immutable, retain_until, created_at, updated_at
 		FROM inodes
 		WHERE path = ?`
 
 	var md metadata.Metadata
 	var parentID sql.NullInt64
 	var callfsInstanceID sql.NullString
-	var symlinkTarget sql.NullString
+	var symlinkTarget, retainUntil sql.NullString
 	var aTime, mTime, cTime, createdAt, updatedAt string
 
 	err := s.db.QueryRowContext(ctx, query, path).Scan(
@@ -124,6 +81,9 @@ func (s *SQLiteStore) Get(ctx context.Context, path string) (*metadata.Metadata,
 		&md.BackendType,
 		&callfsInstanceID,
 		&symlinkTarget,
+		&md.Checksum,
+		&md.Immutable,
+		&retainUntil,
 		&createdAt,
 		&updatedAt,
 	)
@@ -143,6 +103,10 @@ func (s *SQLiteStore) Get(ctx context.Context, path string) (*metadata.Metadata,
 	if symlinkTarget.Valid {
 		md.SymlinkTarget = &symlinkTarget.String
 	}
+	if retainUntil.Valid {
+		retain := parseTimestamp(retainUntil.String)
+		md.RetainUntil = &retain
+	}
 
 	md.ATime = parseTimestamp(aTime)
 	md.MTime = parseTimestamp(mTime)
@@ -154,6 +118,10 @@ func (s *SQLiteStore) Get(ctx context.Context, path string) (*metadata.Metadata,
 }
 
 func (s *SQLiteStore) Create(ctx context.Context, md *metadata.Metadata) error {
+	return createInode(ctx, s.db, md)
+}
+
+func createInode(ctx context.Context, db dbExecutor, md *metadata.Metadata) error {
 	now := time.Now().UTC()
 	if md.ATime.IsZero() {
 		md.ATime = now
@@ -171,10 +139,10 @@ func (s *SQLiteStore) Create(ctx context.Context, md *metadata.Metadata) error {
 		INSERT INTO inodes (
 			parent_id, name, path, type, size, mode, uid, gid,
 			atime, mtime, ctime, backend_type, callfs_instance_id,
-			symlink_target, created_at, updated_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+			symlink_target, checksum, immutable, retain_until, created_at, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
-	result, err := s.db.ExecContext(
+	result, err := db.ExecContext(
 		ctx,
 		query,
 		nullInt64(md.ParentID),
@@ -191,6 +159,9 @@ func (s *SQLiteStore) Create(ctx context.Context, md *metadata.Metadata) error {
 		md.BackendType,
 		nullString(md.CallFSInstanceID),
 		nullString(md.SymlinkTarget),
+		md.Checksum,
+		md.Immutable,
+		nullStringTime(md.RetainUntil),
 		md.CreatedAt.UTC().Format(time.RFC3339Nano),
 		md.UpdatedAt.UTC().Format(time.RFC3339Nano),
 	)
@@ -210,14 +181,19 @@ func (s *SQLiteStore) Create(ctx context.Context, md *metadata.Metadata) error {
 }
 
 func (s *SQLiteStore) Update(ctx context.Context, md *metadata.Metadata) error {
+	return updateInode(ctx, s.db, md)
+}
+
+func updateInode(ctx context.Context, db dbExecutor, md *metadata.Metadata) error {
 	md.UpdatedAt = time.Now().UTC()
 	query := `
 		UPDATE inodes
 		SET size = ?, mode = ?, uid = ?, gid = ?, atime = ?, mtime = ?, ctime = ?,
-		    backend_type = ?, callfs_instance_id = ?, symlink_target = ?, updated_at = ?
+		    backend_type = ?, callfs_instance_id = ?, symlink_target = ?, checksum = ?,
+		    immutable = ?, retain_until = ?, updated_at = ?
 		WHERE path = ?`
 
-	result, err := s.db.ExecContext(
+	result, err := db.ExecContext(
 		ctx,
 		query,
 		md.Size,
@@ -230,6 +206,9 @@ func (s *SQLiteStore) Update(ctx context.Context, md *metadata.Metadata) error {
 		md.BackendType,
 		nullString(md.CallFSInstanceID),
 		nullString(md.SymlinkTarget),
+		md.Checksum,
+		md.Immutable,
+		nullStringTime(md.RetainUntil),
 		md.UpdatedAt.UTC().Format(time.RFC3339Nano),
 		md.Path,
 	)
@@ -248,7 +227,11 @@ func (s *SQLiteStore) Update(ctx context.Context, md *metadata.Metadata) error {
 }
 
 func (s *SQLiteStore) Delete(ctx context.Context, path string) error {
-	result, err := s.db.ExecContext(ctx, `DELETE FROM inodes WHERE path = ?`, path)
+	return deleteInode(ctx, s.db, path)
+}
+
+func deleteInode(ctx context.Context, db dbExecutor, path string) error {
+	result, err := db.ExecContext(ctx, `DELETE FROM inodes WHERE path = ?`, path)
 	if err != nil {
 		return fmt.Errorf("failed to delete metadata: %w", err)
 	}
@@ -262,6 +245,38 @@ func (s *SQLiteStore) Delete(ctx context.Context, path string) error {
 	return nil
 }
 
+// Batch applies ops in a single transaction
+func (s *SQLiteStore) Batch(ctx context.Context, ops []metadata.BatchOp) error {
+	if err := metadata.ValidateBatch(ops); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, op := range ops {
+		switch op.Type {
+		case metadata.BatchCreate:
+			err = createInode(ctx, tx, op.Metadata)
+		case metadata.BatchUpdate:
+			err = updateInode(ctx, tx, op.Metadata)
+		case metadata.BatchDelete:
+			err = deleteInode(ctx, tx, op.Path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
 func (s *SQLiteStore) ListChildren(ctx context.Context, parentPath string) ([]*metadata.Metadata, error) {
 	var (
 		rows *sql.Rows
@@ -272,7 +287,7 @@ func (s *SQLiteStore) ListChildren(ctx context.Context, parentPath string) ([]*m
 		query := `
 			SELECT id, parent_id, name, path, type, size, mode, uid, gid,
 			       atime, mtime, ctime, backend_type, callfs_instance_id,
-			       symlink_target, created_at, updated_at
+			       symlink_target, checksum, immutable, retain_until, created_at, updated_at
 			FROM inodes
 			WHERE path LIKE '/%' AND instr(substr(path, 2), '/') = 0 AND path != '/'
 			ORDER BY type DESC, name ASC`
@@ -281,7 +296,7 @@ func (s *SQLiteStore) ListChildren(ctx context.Context, parentPath string) ([]*m
 		query := `
 			SELECT id, parent_id, name, path, type, size, mode, uid, gid,
 			       atime, mtime, ctime, backend_type, callfs_instance_id,
-			       symlink_target, created_at, updated_at
+			       symlink_target, checksum, immutable, retain_until, created_at, updated_at
 			FROM inodes
 			WHERE path LIKE ? AND path NOT LIKE ?
 			ORDER BY type DESC, name ASC`
@@ -307,18 +322,201 @@ func (s *SQLiteStore) ListChildren(ctx context.Context, parentPath string) ([]*m
 	return children, nil
 }
 
+// ListDescendants lists every entry below prefix in path order. Paths are
+// compared bytewise, so the subtree is the open range (prefix+"/", prefix+"0")
+// and can be served from the path index.
+func (s *SQLiteStore) ListDescendants(ctx context.Context, prefix string, limit int, cursor string) ([]*metadata.Metadata, error) {
+	query := `
+		SELECT id, parent_id, name, path, type, size, mode, uid, gid,
+		       atime, mtime, ctime, backend_type, callfs_instance_id,
+		       symlink_target, checksum, immutable, retain_until, created_at, updated_at
+		FROM inodes
+		WHERE path > ? AND path < ?
+		ORDER BY path ASC
+		LIMIT ?`
+
+	base := strings.TrimSuffix(prefix, "/")
+	lower := base + "/"
+	if cursor > lower {
+		lower = cursor
+	}
+	if limit <= 0 {
+		limit = -1 // SQLite treats a negative LIMIT as unbounded
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, lower, base+"0", limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list descendants: %w", err)
+	}
+	defer rows.Close()
+
+	items := make([]*metadata.Metadata, 0)
+	for rows.Next() {
+		md, scanErr := scanMetadataRow(rows)
+		if scanErr != nil {
+			return nil, scanErr
+		}
+		items = append(items, md)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+	return items, nil
+}
+
+// Usage counts inodes and file bytes with one grouped scan
+func (s *SQLiteStore) Usage(ctx context.Context) (*metadata.Usage, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT type, backend_type, COALESCE(callfs_instance_id, ''), COUNT(*), COALESCE(SUM(size), 0)
+		FROM inodes
+		GROUP BY type, backend_type, callfs_instance_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count inodes: %w", err)
+	}
+	defer rows.Close()
+
+	var counter metadata.UsageCounter
+	for rows.Next() {
+		var entryType, backendType, instanceID string
+		var count, bytes int64
+		if err := rows.Scan(&entryType, &backendType, &instanceID, &count, &bytes); err != nil {
+			return nil, fmt.Errorf("failed to scan inode counts: %w", err)
+		}
+		counter.AddGroup(entryType, backendType, instanceID, count, bytes)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+	return counter.Usage(), nil
+}
+
+// DirectoryUsage counts the children of path and the bytes below it with one
+// scan of the path index
+func (s *SQLiteStore) DirectoryUsage(ctx context.Context, path string) (*metadata.DirectoryUsage, error) {
+	base := strings.TrimSuffix(path, "/")
+	var usage metadata.DirectoryUsage
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(instr(substr(path, ?), '/') = 0), 0),
+		       COALESCE(SUM(CASE WHEN type = 'file' THEN size ELSE 0 END), 0)
+		FROM inodes
+		WHERE path > ? AND path < ?`,
+		len(base)+2, base+"/", base+"0").Scan(&usage.Children, &usage.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count directory usage: %w", err)
+	}
+	return &usage, nil
+}
+
+// RenameSubtree rewrites the path prefix of oldPath's subtree, and of its
+// erasure coding rows, in one transaction
+func (s *SQLiteStore) RenameSubtree(ctx context.Context, oldPath, newPath string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM inodes WHERE path = ?`, newPath).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check rename target: %w", err)
+	}
+	if exists > 0 {
+		return metadata.ErrAlreadyExists
+	}
+
+	// substr counts characters, so the prefix is skipped by its rune count
+	from := utf8.RuneCountInString(oldPath) + 1
+	result, err := tx.ExecContext(ctx, `
+		UPDATE inodes
+		SET path = ? || substr(path, ?),
+		    name = CASE WHEN path = ? THEN ? ELSE name END
+		WHERE path = ? OR (path > ? AND path < ?)`,
+		newPath, from, oldPath, filepath.Base(newPath), oldPath, oldPath+"/", oldPath+"0")
+	if err != nil {
+		return fmt.Errorf("failed to rename metadata: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	} else if n == 0 {
+		return metadata.ErrNotFound
+	}
+
+	for _, table := range []string{"erasure_profiles", "erasure_shards"} {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE `+table+`
+			SET file_path = ? || substr(file_path, ?)
+			WHERE file_path = ? OR (file_path > ? AND file_path < ?)`,
+			newPath, from, oldPath, oldPath+"/", oldPath+"0")
+		if err != nil {
+			return fmt.Errorf("failed to rename erasure metadata: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+const singleUseLinkColumns = `id, token, file_path, status, expires_at, used_at, used_by_ip, hmac_signature, created_at, updated_at`
+
 func (s *SQLiteStore) GetSingleUseLink(ctx context.Context, token string) (*metadata.SingleUseLink, error) {
 	query := `
-		SELECT id, token, file_path, status, expires_at, used_at, used_by_ip, hmac_signature, created_at, updated_at
+		SELECT ` + singleUseLinkColumns + `
 		FROM single_use_links
 		WHERE token = ?`
 
+	link, err := scanSingleUseLink(s.db.QueryRowContext(ctx, query, token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, metadata.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get single-use link: %w", err)
+	}
+	return link, nil
+}
+
+// ListSingleUseLinks returns links ordered by token, starting after cursor
+func (s *SQLiteStore) ListSingleUseLinks(ctx context.Context, limit int, cursor string) ([]*metadata.SingleUseLink, error) {
+	query := `
+		SELECT ` + singleUseLinkColumns + `
+		FROM single_use_links
+		WHERE token > ?
+		ORDER BY token`
+	args := []any{cursor}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list single-use links: %w", err)
+	}
+	defer rows.Close()
+
+	var links []*metadata.SingleUseLink
+	for rows.Next() {
+		link, err := scanSingleUseLink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan single-use link: %w", err)
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+	return links, nil
+}
+
+func scanSingleUseLink(row interface{ Scan(...any) error }) (*metadata.SingleUseLink, error) {
 	var link metadata.SingleUseLink
 	var usedAt sql.NullString
 	var usedByIP sql.NullString
 	var expiresAt, createdAt, updatedAt string
 
-	err := s.db.QueryRowContext(ctx, query, token).Scan(
+	err := row.Scan(
 		&link.ID,
 		&link.Token,
 		&link.FilePath,
@@ -331,10 +529,7 @@ func (s *SQLiteStore) GetSingleUseLink(ctx context.Context, token string) (*meta
 		&updatedAt,
 	)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, metadata.ErrNotFound
-		}
-		return nil, fmt.Errorf("failed to get single-use link: %w", err)
+		return nil, err
 	}
 
 	link.ExpiresAt = parseTimestamp(expiresAt)
@@ -426,7 +621,7 @@ func (s *SQLiteStore) CleanupExpiredLinks(ctx context.Context, before time.Time)
 		return 0, fmt.Errorf("failed to get rows affected: %w", err)
 	}
 
-	s.logger.Debug("Cleaned up expired single-use links",
+	corelog.WithContext(ctx, s.logger).Debug("Cleaned up expired single-use links",
 		zap.Int64("count", rowsAffected),
 		zap.Time("before", before))
 
@@ -449,6 +644,10 @@ func (s *SQLiteStore) CleanupUsedLinks(ctx context.Context, olderThan time.Time)
 	return int(rowsAffected), nil
 }
 
+func (s *SQLiteStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
 func (s *SQLiteStore) Close() error {
 	return s.db.Close()
 }
@@ -457,7 +656,7 @@ func scanMetadataRow(rows *sql.Rows) (*metadata.Metadata, error) {
 	var md metadata.Metadata
 	var parentID sql.NullInt64
 	var callfsInstanceID sql.NullString
-	var symlinkTarget sql.NullString
+	var symlinkTarget, retainUntil sql.NullString
 	var aTime, mTime, cTime, createdAt, updatedAt string
 
 	err := rows.Scan(
@@ -476,6 +675,9 @@ func scanMetadataRow(rows *sql.Rows) (*metadata.Metadata, error) {
 		&md.BackendType,
 		&callfsInstanceID,
 		&symlinkTarget,
+		&md.Checksum,
+		&md.Immutable,
+		&retainUntil,
 		&createdAt,
 		&updatedAt,
 	)
@@ -492,6 +694,10 @@ func scanMetadataRow(rows *sql.Rows) (*metadata.Metadata, error) {
 	if symlinkTarget.Valid {
 		md.SymlinkTarget = &symlinkTarget.String
 	}
+	if retainUntil.Valid {
+		retain := parseTimestamp(retainUntil.String)
+		md.RetainUntil = &retain
+	}
 	md.ATime = parseTimestamp(aTime)
 	md.MTime = parseTimestamp(mTime)
 	md.CTime = parseTimestamp(cTime)
@@ -3,6 +3,7 @@ package metadata
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 )
 
@@ -11,28 +12,78 @@ var (
 	ErrNotFound      = errors.New("metadata not found")
 	ErrAlreadyExists = errors.New("metadata already exists")
 	ErrForbidden     = errors.New("access forbidden")
+	ErrModified      = errors.New("modified since the time given")
 )
 
 // Metadata represents filesystem metadata for an inode
 type Metadata struct {
-	ID               int64     `json:"id"`
-	ParentID         *int64    `json:"parent_id"`
-	Name             string    `json:"name"`
-	Path             string    `json:"path"`
-	Type             string    `json:"type"` // "file" or "directory"
-	Size             int64     `json:"size"`
-	Mode             string    `json:"mode"` // Unix permissions like "0644"
-	UID              int       `json:"uid"`
-	GID              int       `json:"gid"`
-	ATime            time.Time `json:"atime"`
-	MTime            time.Time `json:"mtime"`
-	CTime            time.Time `json:"ctime"`
-	BackendType      string    `json:"backend_type"`       // "localfs", "s3", or "erasure"
-	ErasureCoded     bool      `json:"erasure_coded"`      // true if file is erasure-coded
-	CallFSInstanceID *string   `json:"callfs_instance_id"` // Instance ID for the server that owns this file
-	SymlinkTarget    *string   `json:"symlink_target"`     // For future symlink support
-	CreatedAt        time.Time `json:"created_at"`
-	UpdatedAt        time.Time `json:"updated_at"`
+	ID               int64      `json:"id"`
+	ParentID         *int64     `json:"parent_id"`
+	Name             string     `json:"name"`
+	Path             string     `json:"path"`
+	Type             string     `json:"type"` // "file" or "directory"; "symlink" as reported by a backend
+	Size             int64      `json:"size"`
+	Mode             string     `json:"mode"` // Unix permissions like "0644"
+	UID              int        `json:"uid"`
+	GID              int        `json:"gid"`
+	ATime            time.Time  `json:"atime"`
+	MTime            time.Time  `json:"mtime"`
+	CTime            time.Time  `json:"ctime"`
+	BackendType      string     `json:"backend_type"`           // "localfs", "s3", or "erasure"
+	ErasureCoded     bool       `json:"erasure_coded"`          // true if file is erasure-coded
+	CallFSInstanceID *string    `json:"callfs_instance_id"`     // Instance ID for the server that owns this file
+	SymlinkTarget    *string    `json:"symlink_target"`         // Target of a symlink
+	Checksum         string     `json:"checksum,omitempty"`     // "sha256=<hex>" of the content; empty when unknown
+	Immutable        bool       `json:"immutable,omitempty"`    // Held against update, delete and rename
+	RetainUntil      *time.Time `json:"retain_until,omitempty"` // End of an immutable hold; held indefinitely when nil
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+
+	// Reported by the backend holding the file, and not kept by metadata stores
+	NLink  int               `json:"nlink,omitempty"`  // Hard links to the stored file
+	XAttrs map[string]string `json:"xattrs,omitempty"` // Selected extended attributes
+}
+
+// Held reports whether the entry is immutable at now: indefinitely, or until
+// its RetainUntil
+func (m *Metadata) Held(now time.Time) bool {
+	return m.Immutable && (m.RetainUntil == nil || now.Before(*m.RetainUntil))
+}
+
+// BatchOpType identifies the kind of mutation in a BatchOp
+type BatchOpType string
+
+// Batch operation types
+const (
+	BatchCreate BatchOpType = "create"
+	BatchUpdate BatchOpType = "update"
+	BatchDelete BatchOpType = "delete"
+)
+
+// BatchOp is a single inode mutation applied as part of Store.Batch
+type BatchOp struct {
+	Type     BatchOpType `json:"type"`
+	Metadata *Metadata   `json:"metadata,omitempty"` // Set for create and update
+	Path     string      `json:"path,omitempty"`     // Set for delete
+}
+
+// ValidateBatch checks that every op is well-formed before a store applies it
+func ValidateBatch(ops []BatchOp) error {
+	for i, op := range ops {
+		switch op.Type {
+		case BatchCreate, BatchUpdate:
+			if op.Metadata == nil {
+				return fmt.Errorf("batch op %d: metadata is required for %s", i, op.Type)
+			}
+		case BatchDelete:
+			if op.Path == "" {
+				return fmt.Errorf("batch op %d: path is required for delete", i)
+			}
+		default:
+			return fmt.Errorf("batch op %d: unknown operation %q", i, op.Type)
+		}
+	}
+	return nil
 }
 
 // SingleUseLink represents a secure, single-use download link
@@ -90,12 +141,42 @@ type Store interface {
 	// Delete removes an inode entry by path
 	Delete(ctx context.Context, path string) error
 
+	// Batch applies ops in order as a single atomic unit: either every op is
+	// applied or none is. Errors match those of the single-op methods.
+	Batch(ctx context.Context, ops []BatchOp) error
+
 	// ListChildren returns all children of a directory
 	ListChildren(ctx context.Context, parentPath string) ([]*Metadata, error)
 
+	// ListDescendants returns every entry below prefix, ordered by path and
+	// excluding prefix itself. At most limit entries are returned when limit
+	// is positive, and fewer only on the last page. cursor is the path of the
+	// last entry of the previous page, or "" to start from the beginning.
+	ListDescendants(ctx context.Context, prefix string, limit int, cursor string) ([]*Metadata, error)
+
+	// RenameSubtree moves the entry at oldPath and every entry below it to
+	// newPath in one atomic step, carrying their erasure coding metadata
+	// along. It returns ErrNotFound if oldPath does not exist and
+	// ErrAlreadyExists if newPath does.
+	RenameSubtree(ctx context.Context, oldPath, newPath string) error
+
+	// Usage counts the files and directories in the store and the bytes of
+	// file content on each backend
+	Usage(ctx context.Context) (*Usage, error)
+
+	// DirectoryUsage counts the entries directly in the directory at path
+	// and the bytes of the files below it at any depth
+	DirectoryUsage(ctx context.Context, path string) (*DirectoryUsage, error)
+
 	// GetSingleUseLink retrieves a single-use link by token
 	GetSingleUseLink(ctx context.Context, token string) (*SingleUseLink, error)
 
+	// ListSingleUseLinks returns links ordered by token. At most limit links
+	// are returned when limit is positive, and fewer only on the last page;
+	// cursor is the last token of the previous page, or "" to start from the
+	// beginning.
+	ListSingleUseLinks(ctx context.Context, limit int, cursor string) ([]*SingleUseLink, error)
+
 	// CreateSingleUseLink creates a new single-use link
 	CreateSingleUseLink(ctx context.Context, link *SingleUseLink) error
 
@@ -108,6 +189,9 @@ type Store interface {
 	// CleanupUsedLinks removes used single-use links older than the given time and returns count of removed links
 	CleanupUsedLinks(ctx context.Context, olderThan time.Time) (int, error)
 
+	// Ping verifies that the metadata store is reachable and able to serve requests
+	Ping(ctx context.Context) error
+
 	// Close closes the metadata store connection
 	Close() error
 }
@@ -0,0 +1,94 @@
+package metadata
+
+import (
+	"sort"
+	"strings"
+)
+
+// Usage summarizes the entries of a metadata store
+type Usage struct {
+	Files       int64          `json:"files"`
+	Directories int64          `json:"directories"`
+	Backends    []BackendUsage `json:"backends"` // Ordered by backend type and instance ID
+}
+
+// BackendUsage counts the files stored on one backend, or for localfs on one
+// instance's local filesystem
+type BackendUsage struct {
+	BackendType string `json:"backend_type"`
+	InstanceID  string `json:"instance_id,omitempty"`
+	Files       int64  `json:"files"`
+	Bytes       int64  `json:"bytes"`
+}
+
+// DirectoryUsage summarizes the entries below a directory
+type DirectoryUsage struct {
+	Children int64 `json:"children"` // Entries directly in the directory
+	Bytes    int64 `json:"bytes"`    // Of the files below it, at any depth
+}
+
+// Count adds the entry md below the directory at path
+func (u *DirectoryUsage) Count(path string, md *Metadata) {
+	base := strings.TrimSuffix(path, "/") + "/"
+	if !strings.Contains(strings.TrimPrefix(md.Path, base), "/") {
+		u.Children++
+	}
+	if md.Type == "file" {
+		u.Bytes += md.Size
+	}
+}
+
+// UsageCounter builds a Usage from entries or groups of entries
+type UsageCounter struct {
+	usage   Usage
+	backend map[[2]string]int
+}
+
+// Add counts one entry
+func (c *UsageCounter) Add(md *Metadata) {
+	instanceID := ""
+	if md.CallFSInstanceID != nil {
+		instanceID = *md.CallFSInstanceID
+	}
+	c.AddGroup(md.Type, md.BackendType, instanceID, 1, md.Size)
+}
+
+// AddGroup counts count entries of one type, backend and owner holding bytes
+// in total
+func (c *UsageCounter) AddGroup(entryType, backendType, instanceID string, count, bytes int64) {
+	if entryType == "directory" {
+		c.usage.Directories += count
+		return
+	}
+	c.usage.Files += count
+
+	if backendType != "localfs" {
+		instanceID = ""
+	}
+	if c.backend == nil {
+		c.backend = make(map[[2]string]int)
+	}
+	key := [2]string{backendType, instanceID}
+	i, ok := c.backend[key]
+	if !ok {
+		i = len(c.usage.Backends)
+		c.backend[key] = i
+		c.usage.Backends = append(c.usage.Backends, BackendUsage{BackendType: backendType, InstanceID: instanceID})
+	}
+	c.usage.Backends[i].Files += count
+	c.usage.Backends[i].Bytes += bytes
+}
+
+// Usage returns the counted usage
+func (c *UsageCounter) Usage() *Usage {
+	usage := c.usage
+	usage.Backends = append([]BackendUsage{}, c.usage.Backends...)
+	sort.Slice(usage.Backends, func(i, j int) bool {
+		a, b := usage.Backends[i], usage.Backends[j]
+		if a.BackendType != b.BackendType {
+			return a.BackendType < b.BackendType
+		}
+		return a.InstanceID < b.InstanceID
+	})
+	return &usage
+}
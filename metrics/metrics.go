@@ -2,7 +2,11 @@
 package metrics
 
 import (
+	"database/sql"
+	"errors"
+
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
@@ -25,6 +29,21 @@ var (
 		[]string{"method", "path"},
 	)
 
+	HTTPInFlightRequests = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "callfs_http_in_flight_requests",
+			Help: "Number of HTTP requests currently being served",
+		},
+	)
+
+	WebSocketTransfers = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "callfs_websocket_transfers",
+			Help: "Number of websocket file transfers currently open",
+		},
+		[]string{"mode"}, // "download", "upload"
+	)
+
 	// Backend operation metrics
 	BackendOpsTotal = promauto.NewCounterVec(
 		prometheus.CounterOpts{
@@ -43,6 +62,47 @@ var (
 		[]string{"backend_type", "operation"},
 	)
 
+	BackendReadBytesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "callfs_backend_read_bytes_total",
+			Help: "Total bytes of file content read from backends for downloads",
+		},
+		[]string{"backend"}, // localfs, s3 or peer
+	)
+
+	BackendWriteBytesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "callfs_backend_write_bytes_total",
+			Help: "Total bytes of file content written to backends for uploads",
+		},
+		[]string{"backend"}, // localfs, s3 or peer
+	)
+
+	BackendWriteQueueDepth = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "callfs_backend_write_queue_depth",
+			Help: "Number of uploads waiting for a slot under a backend's concurrent write limit",
+		},
+		[]string{"backend"},
+	)
+
+	BackendWritesRejectedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "callfs_backend_writes_rejected_total",
+			Help: "Total number of uploads rejected because a backend's concurrent write limit stayed full",
+		},
+		[]string{"backend"},
+	)
+
+	BackendReadThroughput = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "callfs_backend_read_throughput_bytes_per_second",
+			Help:    "Throughput of each download's reads from its backend, excluding time spent sending to the client",
+			Buckets: prometheus.ExponentialBuckets(64*1024, 4, 9), // 64 KiB/s to 4 GiB/s
+		},
+		[]string{"backend"},
+	)
+
 	// Metadata database metrics
 	MetadataDBQueriesTotal = promauto.NewCounterVec(
 		prometheus.CounterOpts{
@@ -95,6 +155,14 @@ var (
 		[]string{"operation"},
 	)
 
+	LockRenewalsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "callfs_lock_renewals_total",
+			Help: "Total number of lock renewals by the keep-alive watchdog",
+		},
+		[]string{"status"}, // "success", "error", "lost"
+	)
+
 	// Active locks gauge
 	ActiveLocks = promauto.NewGauge(
 		prometheus.GaugeOpts{
@@ -112,6 +180,231 @@ var (
 		[]string{"operation", "backend_type"}, // operation: "create", "read", "update", "delete"
 	)
 
+	// Rate limiting metrics
+	RateLimitedRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "callfs_rate_limited_requests_total",
+			Help: "Total number of requests rejected by rate or concurrency limits",
+		},
+		[]string{"scope"}, // "global", "ip", "key", "concurrent_uploads", "endpoint"
+	)
+
+	// Authentication metrics
+	AuthFailuresTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "callfs_auth_failures_total",
+			Help: "Total number of API requests that failed authentication",
+		},
+		[]string{"reason"}, // "missing_credentials", "invalid_credentials", "locked_out", "error"
+	)
+
+	AuthLockoutsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "callfs_auth_lockouts_total",
+			Help: "Total number of client IPs and keys locked out after repeated authentication failures",
+		},
+		[]string{"scope"}, // "ip", "key"
+	)
+
+	InFlightUploads = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "callfs_in_flight_uploads",
+			Help: "Number of uploads currently being processed",
+		},
+	)
+
+	// Metadata cache metrics
+	MetadataCacheRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "callfs_metadata_cache_requests_total",
+			Help: "Total number of metadata cache lookups by result",
+		},
+		[]string{"result"}, // "hit", "negative_hit", "miss"
+	)
+
+	MetadataCacheEvictionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "callfs_metadata_cache_evictions_total",
+			Help: "Total number of metadata cache entries evicted",
+		},
+		[]string{"reason"}, // "expired", "capacity"
+	)
+
+	MetadataCacheEntries = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "callfs_metadata_cache_entries",
+			Help: "Number of entries currently held in the metadata cache",
+		},
+	)
+
+	// S3 content cache metrics
+	S3CacheRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "callfs_s3_cache_requests_total",
+			Help: "Total number of S3 content cache lookups by result",
+		},
+		[]string{"result"}, // "hit", "miss", "stale"
+	)
+
+	S3CacheEvictionsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "callfs_s3_cache_evictions_total",
+			Help: "Total number of files evicted from the S3 content cache to stay within its size",
+		},
+	)
+
+	S3CacheBytes = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "callfs_s3_cache_bytes",
+			Help: "Bytes of file content currently held in the S3 content cache",
+		},
+	)
+
+	// Upload malware scanning metrics
+	ScanDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "callfs_scan_duration_seconds",
+			Help:    "Time from the start of an upload's malware scan to its verdict, which includes receiving the upload",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 15), // 5ms to 80s
+		},
+		[]string{"scanner", "result"}, // "clean", "infected", "error"
+	)
+
+	ScanDetectionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "callfs_scan_detections_total",
+			Help: "Total number of uploads found infected, by the action taken",
+		},
+		[]string{"action"}, // "reject", "quarantine", "tag"
+	)
+
+	// Content processing hook metrics
+	HookDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "callfs_hook_duration_seconds",
+			Help:    "Time taken by content processing hooks, by hook, stage and result",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 15), // 5ms to 80s
+		},
+		[]string{"hook", "stage", "result"}, // "ok", "rejected", "failed"
+	)
+
+	// Background job metrics
+	JobRunsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "callfs_job_runs_total",
+			Help: "Total number of scheduled background job runs, by job and result",
+		},
+		[]string{"job", "result"}, // "success", "failure", "skipped"
+	)
+
+	JobDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "callfs_job_duration_seconds",
+			Help:    "Time taken by scheduled background job runs",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 10), // 10ms to 45m
+		},
+		[]string{"job"},
+	)
+
+	JobLastSuccess = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "callfs_job_last_success_timestamp_seconds",
+			Help: "Unix time at which a scheduled background job last finished successfully",
+		},
+		[]string{"job"},
+	)
+
+	// Preview metrics
+	PreviewRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "callfs_preview_requests_total",
+			Help: "Total number of preview requests, by whether the preview was cached",
+		},
+		[]string{"result"}, // "hit", "rendered", "unsupported", "error"
+	)
+
+	PreviewRenderDuration = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "callfs_preview_render_duration_seconds",
+			Help:    "Time taken to read a file and render its preview",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 12), // 5ms to 10s
+		},
+	)
+
+	// Search metrics
+	SearchIndexOperationsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "callfs_search_index_operations_total",
+			Help: "Total number of documents written to or removed from the search index by outcome",
+		},
+		[]string{"operation", "result"}, // "put", "delete"; "success", "failure"
+	)
+
+	// Metadata read replica metrics
+	MetadataReplicaHealthy = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "callfs_metadata_replica_healthy",
+			Help: "Whether a PostgreSQL read replica is in rotation (1) or not (0)",
+		},
+		[]string{"replica"},
+	)
+
+	MetadataReplicaReadsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "callfs_metadata_replica_reads_total",
+			Help: "Total number of replica-eligible metadata reads by where they were served",
+		},
+		[]string{"target"}, // "replica", "primary", "failover"
+	)
+
+	// Peer instance metrics
+	PeerHealthy = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "callfs_peer_healthy",
+			Help: "Whether proxying to a peer instance is allowed (1) or its circuit is open (0)",
+		},
+		[]string{"peer"},
+	)
+
+	PeerRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "callfs_peer_requests_total",
+			Help: "Total number of requests proxied to peer instances by outcome",
+		},
+		[]string{"peer", "result"}, // "success", "failure", "rejected"
+	)
+
+	PeerCircuitTripsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "callfs_peer_circuit_trips_total",
+			Help: "Total number of times a peer's circuit opened after repeated failures",
+		},
+		[]string{"peer"},
+	)
+
+	DiscoveredPeers = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "callfs_discovered_peers",
+			Help: "Number of peer instances currently known from instance discovery",
+		},
+	)
+
+	DiscoveryRefreshesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "callfs_discovery_refreshes_total",
+			Help: "Total number of instance discovery lookups by source and status",
+		},
+		[]string{"source", "status"}, // "success", "error"
+	)
+
+	PlacementForwardsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "callfs_placement_forwards_total",
+			Help: "Total number of new files forwarded to the peer instance chosen to own them",
+		},
+		[]string{"peer"},
+	)
+
 	// Error metrics
 	ErrorsTotal = promauto.NewCounterVec(
 		prometheus.CounterOpts{
@@ -122,6 +415,16 @@ var (
 	)
 )
 
+// RegisterDBStats exports connection pool statistics for db as go_sql_*
+// metrics labelled with db_name. Registering the same name twice is a no-op.
+func RegisterDBStats(db *sql.DB, name string) {
+	err := prometheus.Register(collectors.NewDBStatsCollector(db, name))
+	var alreadyRegistered prometheus.AlreadyRegisteredError
+	if err != nil && !errors.As(err, &alreadyRegistered) {
+		panic(err)
+	}
+}
+
 // RegisterMetrics ensures all metrics are registered with Prometheus.
 // This function is idempotent and safe to call multiple times.
 func RegisterMetrics() {
@@ -0,0 +1,158 @@
+package nfs
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+)
+
+// MaxHandleSize is the longest file handle of NFSv3
+const MaxHandleSize = 64
+
+// Status is an NFSv3 status. A FileSystem returns one as the error of a call
+// to answer it with that status; any other error is answered with ErrIO.
+type Status uint32
+
+// The statuses of NFSv3 (RFC 1813) a FileSystem may return
+const (
+	ErrPerm        Status = 1
+	ErrNoEnt       Status = 2
+	ErrIO          Status = 5
+	ErrAccess      Status = 13
+	ErrExist       Status = 17
+	ErrXDev        Status = 18
+	ErrNotDir      Status = 20
+	ErrIsDir       Status = 21
+	ErrInval       Status = 22
+	ErrFBig        Status = 27
+	ErrNoSpc       Status = 28
+	ErrROFS        Status = 30
+	ErrNameTooLong Status = 63
+	ErrNotEmpty    Status = 66
+	ErrStale       Status = 70
+	ErrBadHandle   Status = 10001
+	ErrBadCookie   Status = 10003
+	ErrNotSupp     Status = 10004
+	ErrTooSmall    Status = 10005
+	ErrServerFault Status = 10006
+	ErrJukebox     Status = 10008 // The call may succeed if retried later
+)
+
+func (s Status) Error() string {
+	return "NFS3ERR " + strconv.FormatUint(uint64(s), 10)
+}
+
+// FileType is the type of a file in Attr
+type FileType uint32
+
+// File types of NFSv3
+const (
+	TypeRegular   FileType = 1
+	TypeDirectory FileType = 2
+	TypeSymlink   FileType = 5
+)
+
+// Attr are the attributes of a file
+type Attr struct {
+	Type   FileType
+	Mode   uint32 // Permission bits
+	NLink  uint32
+	UID    uint32
+	GID    uint32
+	Size   uint64
+	FileID uint64 // Unique among the files of the file system
+	ATime  time.Time
+	MTime  time.Time
+	CTime  time.Time
+}
+
+// SetAttrs are the attributes a client changes. Nil fields are left
+// unchanged.
+type SetAttrs struct {
+	Mode  *uint32
+	UID   *uint32
+	GID   *uint32
+	Size  *uint64
+	ATime *time.Time
+	MTime *time.Time
+	// Whether the times set are the server's current time, as utimes(2)
+	// sets them without explicit times
+	ServerTimes bool
+}
+
+// IsZero reports whether no attribute is set
+func (a SetAttrs) IsZero() bool {
+	return a.Mode == nil && a.UID == nil && a.GID == nil && a.Size == nil && a.ATime == nil && a.MTime == nil
+}
+
+// DirEntry is an entry of a directory listing
+type DirEntry struct {
+	Name   string
+	Handle []byte
+	Attr   *Attr
+}
+
+// FSStat is the capacity of a file system
+type FSStat struct {
+	TotalBytes uint64
+	FreeBytes  uint64
+	AvailBytes uint64 // Free to the caller
+	TotalFiles uint64
+	FreeFiles  uint64
+	AvailFiles uint64
+}
+
+// FileSystem is the file system a Server exports. Files are identified by
+// the handles it issues, of at most MaxHandleSize bytes, which should stay
+// valid across restarts of the server. Calls carry the client's
+// Credentials in their context.
+type FileSystem interface {
+	// Mount returns the handle of the directory at path, which a client
+	// mounts
+	Mount(ctx context.Context, path string) ([]byte, error)
+	GetAttr(ctx context.Context, fh []byte) (*Attr, error)
+	SetAttr(ctx context.Context, fh []byte, attrs SetAttrs) error
+	// Lookup returns the handle of the entry name of the directory dir
+	Lookup(ctx context.Context, dir []byte, name string) ([]byte, error)
+	// Access returns which of the ACCESS3 bits of mask the caller holds
+	Access(ctx context.Context, fh []byte, mask uint32) (uint32, error)
+	ReadLink(ctx context.Context, fh []byte) (string, error)
+	// Read returns up to count bytes from offset, and whether they reach
+	// the end of the file
+	Read(ctx context.Context, fh []byte, offset uint64, count uint32) ([]byte, bool, error)
+	// Write stores data at offset. Unless stable, it may be held until
+	// Commit.
+	Write(ctx context.Context, fh []byte, offset uint64, data []byte, stable bool) error
+	// Commit stores the data written to fh that is not yet stable
+	Commit(ctx context.Context, fh []byte) error
+	// Create creates the file name in dir. An existing file is an error
+	// when exclusive, and otherwise is given attrs.
+	Create(ctx context.Context, dir []byte, name string, exclusive bool, attrs SetAttrs) ([]byte, error)
+	Mkdir(ctx context.Context, dir []byte, name string, attrs SetAttrs) ([]byte, error)
+	Remove(ctx context.Context, dir []byte, name string) error
+	Rmdir(ctx context.Context, dir []byte, name string) error
+	Rename(ctx context.Context, fromDir []byte, fromName string, toDir []byte, toName string) error
+	// ReadDir returns the entries of dir, without "." and "..". Their Attr
+	// may be nil.
+	ReadDir(ctx context.Context, dir []byte) ([]DirEntry, error)
+	FSStat(ctx context.Context, fh []byte) (*FSStat, error)
+}
+
+// Credentials identify the client of a call, as its AUTH_SYS credentials
+// state them. Nothing proves them.
+type Credentials struct {
+	Addr    net.Addr
+	Machine string
+	UID     uint32
+	GID     uint32
+	GIDs    []uint32
+}
+
+type credentialsKey struct{}
+
+// CredentialsFromContext returns the credentials of the call ctx belongs to
+func CredentialsFromContext(ctx context.Context) (Credentials, bool) {
+	creds, ok := ctx.Value(credentialsKey{}).(Credentials)
+	return creds, ok
+}
@@ -0,0 +1,92 @@
+package nfs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"slices"
+	"sync"
+	"time"
+)
+
+// A directory listed with READDIR is paged through in several calls. Its
+// listing is kept between them under a cookie verifier, so each call does not
+// list the directory again.
+const (
+	listingTTL  = 30 * time.Second // Longest a listing is reused
+	maxListings = 64               // Listings kept at once
+)
+
+// listing is the entries of a directory, "." and ".." included, as one
+// client listed it
+type listing struct {
+	dir     string
+	owner   Credentials
+	entries []DirEntry
+	expires time.Time
+}
+
+// listingCache holds the listings being paged through, by cookie verifier
+type listingCache struct {
+	mu       sync.Mutex
+	listings map[uint64]*listing
+}
+
+// get returns the listing of verifier, if dir is what it lists for the
+// credentials of ctx and it has not expired
+func (c *listingCache) get(ctx context.Context, verifier uint64, dir []byte) []DirEntry {
+	creds, _ := CredentialsFromContext(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.listings[verifier]
+	if !ok || l.dir != string(dir) || !sameOwner(l.owner, creds) || time.Now().After(l.expires) {
+		return nil
+	}
+	return l.entries
+}
+
+// put keeps entries as the listing of dir for the credentials of ctx and
+// returns its verifier. The oldest listing makes room when the cache is full.
+func (c *listingCache) put(ctx context.Context, dir []byte, entries []DirEntry) uint64 {
+	creds, _ := CredentialsFromContext(ctx)
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	verifier := binary.BigEndian.Uint64(b[:])
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.listings == nil {
+		c.listings = make(map[uint64]*listing)
+	}
+	for v, l := range c.listings {
+		if now.After(l.expires) {
+			delete(c.listings, v)
+		}
+	}
+	if len(c.listings) >= maxListings {
+		var oldest uint64
+		var oldestExpiry time.Time
+		for v, l := range c.listings {
+			if oldestExpiry.IsZero() || l.expires.Before(oldestExpiry) {
+				oldest, oldestExpiry = v, l.expires
+			}
+		}
+		delete(c.listings, oldest)
+	}
+	c.listings[verifier] = &listing{dir: string(dir), owner: creds, entries: entries, expires: now.Add(listingTTL)}
+	return verifier
+}
+
+// remove drops the listing of verifier once a client has read all of it
+func (c *listingCache) remove(verifier uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.listings, verifier)
+}
+
+// sameOwner reports whether a and b are the same user, so that a listing
+// filtered by permissions is not shown to another
+func sameOwner(a, b Credentials) bool {
+	return a.UID == b.UID && a.GID == b.GID && slices.Equal(a.GIDs, b.GIDs)
+}
@@ -0,0 +1,111 @@
+package nfs
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+)
+
+// Procedures of MOUNT v3 (RFC 1813 appendix I)
+const (
+	mountProcNull    = 0
+	mountProcMnt     = 1
+	mountProcDump    = 2
+	mountProcUmnt    = 3
+	mountProcUmntAll = 4
+	mountProcExport  = 5
+)
+
+// exportPath is the single export, the root of the file system; clients may
+// mount any directory below it
+const exportPath = "/"
+
+// maxMountPath is the longest path a MOUNT call names
+const maxMountPath = 1024
+
+// mountStatuses are the statuses of MNT replies; other errors are reported
+// as ErrIO
+var mountStatuses = map[Status]bool{
+	ErrPerm: true, ErrNoEnt: true, ErrIO: true, ErrAccess: true, ErrNotDir: true,
+	ErrInval: true, ErrNameTooLong: true, ErrNotSupp: true, ErrServerFault: true,
+}
+
+func (s *Server) mount3Procedures() map[uint32]procedure {
+	return map[uint32]procedure{
+		mountProcNull:    func(context.Context, *xdrReader, *xdrWriter) error { return nil },
+		mountProcMnt:     s.mountMnt,
+		mountProcDump:    s.mountDump,
+		mountProcUmnt:    s.mountUmnt,
+		mountProcUmntAll: func(context.Context, *xdrReader, *xdrWriter) error { return nil },
+		mountProcExport:  s.mountExport,
+	}
+}
+
+// mountMnt returns the handle of the directory a client mounts
+func (s *Server) mountMnt(ctx context.Context, args *xdrReader, res *xdrWriter) error {
+	path := args.string(maxMountPath)
+	if args.err != nil {
+		return errGarbageArgs
+	}
+	fh, err := s.fs.Mount(ctx, path)
+	if err != nil {
+		status := s.status(err)
+		if !mountStatuses[status] {
+			status = ErrIO
+		}
+		res.uint32(uint32(status))
+		return nil
+	}
+
+	creds, _ := CredentialsFromContext(ctx)
+	s.logger.Info("NFS client mounted",
+		zap.String("path", path),
+		zap.String("remote_addr", creds.Addr.String()),
+		zap.String("machine", creds.Machine))
+	res.uint32(0)
+	res.opaque(fh)
+	res.uint32(1) // Flavors: AUTH_SYS
+	res.uint32(authSys)
+	return nil
+}
+
+// mountDump lists no mounts, as the server does not track them
+func (s *Server) mountDump(_ context.Context, _ *xdrReader, res *xdrWriter) error {
+	res.bool(false)
+	return nil
+}
+
+func (s *Server) mountUmnt(ctx context.Context, args *xdrReader, _ *xdrWriter) error {
+	path := args.string(maxMountPath)
+	if args.err != nil {
+		return errGarbageArgs
+	}
+	creds, _ := CredentialsFromContext(ctx)
+	s.logger.Info("NFS client unmounted",
+		zap.String("path", path),
+		zap.String("remote_addr", creds.Addr.String()))
+	return nil
+}
+
+// mountExport lists the export, open to every client allowed to connect
+func (s *Server) mountExport(_ context.Context, _ *xdrReader, res *xdrWriter) error {
+	res.bool(true)
+	res.string(exportPath)
+	res.bool(false) // No groups
+	res.bool(false)
+	return nil
+}
+
+// status returns the status err answers a call with
+func (s *Server) status(err error) Status {
+	if err == nil {
+		return 0
+	}
+	var status Status
+	if errors.As(err, &status) {
+		return status
+	}
+	s.logger.Warn("NFS call failed", zap.Error(err))
+	return ErrIO
+}
@@ -0,0 +1,621 @@
+package nfs
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+)
+
+// Procedures of NFSv3 (RFC 1813)
+const (
+	procNull        = 0
+	procGetAttr     = 1
+	procSetAttr     = 2
+	procLookup      = 3
+	procAccess      = 4
+	procReadLink    = 5
+	procRead        = 6
+	procWrite       = 7
+	procCreate      = 8
+	procMkdir       = 9
+	procSymlink     = 10
+	procMknod       = 11
+	procRemove      = 12
+	procRmdir       = 13
+	procRename      = 14
+	procLink        = 15
+	procReadDir     = 16
+	procReadDirPlus = 17
+	procFSStat      = 18
+	procFSInfo      = 19
+	procPathConf    = 20
+	procCommit      = 21
+)
+
+// maxIOSize is the most data a READ returns or a WRITE carries
+const maxIOSize = 1 << 20
+
+// maxName is the longest file name
+const maxName = 255
+
+// fsid identifies the file system in attributes
+const fsid = 0x43616c6c46530001
+
+// errNotSync answers a SETATTR whose guard does not match the ctime
+const errNotSync Status = 10002
+
+// How WRITE and CREATE calls store data and files
+const (
+	writeUnstable = 0
+	writeFileSync = 2
+
+	createUnchecked = 0
+	createGuarded   = 1
+	createExclusive = 2
+)
+
+// Properties of FSINFO replies
+const (
+	fsfHomogeneous = 0x0008
+	fsfCanSetTime  = 0x0010
+)
+
+func (s *Server) nfs3Procedures() map[uint32]procedure {
+	return map[uint32]procedure{
+		procNull:        func(context.Context, *xdrReader, *xdrWriter) error { return nil },
+		procGetAttr:     s.nfsGetAttr,
+		procSetAttr:     s.nfsSetAttr,
+		procLookup:      s.nfsLookup,
+		procAccess:      s.nfsAccess,
+		procReadLink:    s.nfsReadLink,
+		procRead:        s.nfsRead,
+		procWrite:       s.nfsWrite,
+		procCreate:      s.nfsCreate,
+		procMkdir:       s.nfsMkdir,
+		procSymlink:     s.nfsSymlink,
+		procMknod:       s.nfsMknod,
+		procRemove:      s.nfsRemove,
+		procRmdir:       s.nfsRmdir,
+		procRename:      s.nfsRename,
+		procLink:        s.nfsLink,
+		procReadDir:     s.nfsReadDir,
+		procReadDirPlus: s.nfsReadDirPlus,
+		procFSStat:      s.nfsFSStat,
+		procFSInfo:      s.nfsFSInfo,
+		procPathConf:    s.nfsPathConf,
+		procCommit:      s.nfsCommit,
+	}
+}
+
+func (s *Server) nfsGetAttr(ctx context.Context, args *xdrReader, res *xdrWriter) error {
+	fh := args.opaque(MaxHandleSize)
+	if args.err != nil {
+		return errGarbageArgs
+	}
+	attr, err := s.fs.GetAttr(ctx, fh)
+	res.uint32(uint32(s.status(err)))
+	if err == nil {
+		writeAttr(res, attr)
+	}
+	return nil
+}
+
+func (s *Server) nfsSetAttr(ctx context.Context, args *xdrReader, res *xdrWriter) error {
+	fh := args.opaque(MaxHandleSize)
+	attrs := readSetAttrs(args)
+	var guard *time.Time
+	if args.bool() {
+		ctime := args.time()
+		guard = &ctime
+	}
+	if args.err != nil {
+		return errGarbageArgs
+	}
+
+	var err error
+	if guard != nil {
+		var attr *Attr
+		if attr, err = s.fs.GetAttr(ctx, fh); err == nil && !attr.CTime.Equal(*guard) {
+			err = errNotSync
+		}
+	}
+	if err == nil {
+		err = s.fs.SetAttr(ctx, fh, attrs)
+	}
+	res.uint32(uint32(s.status(err)))
+	s.writeWcc(ctx, res, fh)
+	return nil
+}
+
+func (s *Server) nfsLookup(ctx context.Context, args *xdrReader, res *xdrWriter) error {
+	dir, name := args.opaque(MaxHandleSize), args.string(maxName)
+	if args.err != nil {
+		return errGarbageArgs
+	}
+	fh, err := s.fs.Lookup(ctx, dir, name)
+	res.uint32(uint32(s.status(err)))
+	if err == nil {
+		res.opaque(fh)
+		s.writePostOpAttr(ctx, res, fh)
+	}
+	s.writePostOpAttr(ctx, res, dir)
+	return nil
+}
+
+func (s *Server) nfsAccess(ctx context.Context, args *xdrReader, res *xdrWriter) error {
+	fh, mask := args.opaque(MaxHandleSize), args.uint32()
+	if args.err != nil {
+		return errGarbageArgs
+	}
+	granted, err := s.fs.Access(ctx, fh, mask)
+	res.uint32(uint32(s.status(err)))
+	s.writePostOpAttr(ctx, res, fh)
+	if err == nil {
+		res.uint32(granted & mask)
+	}
+	return nil
+}
+
+func (s *Server) nfsReadLink(ctx context.Context, args *xdrReader, res *xdrWriter) error {
+	fh := args.opaque(MaxHandleSize)
+	if args.err != nil {
+		return errGarbageArgs
+	}
+	target, err := s.fs.ReadLink(ctx, fh)
+	res.uint32(uint32(s.status(err)))
+	s.writePostOpAttr(ctx, res, fh)
+	if err == nil {
+		res.string(target)
+	}
+	return nil
+}
+
+func (s *Server) nfsRead(ctx context.Context, args *xdrReader, res *xdrWriter) error {
+	fh, offset, count := args.opaque(MaxHandleSize), args.uint64(), args.uint32()
+	if args.err != nil {
+		return errGarbageArgs
+	}
+	data, eof, err := s.fs.Read(ctx, fh, offset, min(count, maxIOSize))
+	res.uint32(uint32(s.status(err)))
+	s.writePostOpAttr(ctx, res, fh)
+	if err == nil {
+		res.uint32(uint32(len(data)))
+		res.bool(eof)
+		res.opaque(data)
+	}
+	return nil
+}
+
+func (s *Server) nfsWrite(ctx context.Context, args *xdrReader, res *xdrWriter) error {
+	fh, offset, count, stable := args.opaque(MaxHandleSize), args.uint64(), args.uint32(), args.uint32()
+	data := args.opaque(maxIOSize)
+	if args.err != nil {
+		return errGarbageArgs
+	}
+	if int(count) < len(data) {
+		data = data[:count]
+	}
+
+	var err error
+	if offset > math.MaxInt64-uint64(len(data)) {
+		err = ErrFBig
+	} else {
+		err = s.fs.Write(ctx, fh, offset, data, stable != writeUnstable)
+	}
+	res.uint32(uint32(s.status(err)))
+	s.writeWcc(ctx, res, fh)
+	if err == nil {
+		res.uint32(uint32(len(data)))
+		if stable == writeUnstable {
+			res.uint32(writeUnstable)
+		} else {
+			res.uint32(writeFileSync)
+		}
+		res.fixed(s.verifier[:])
+	}
+	return nil
+}
+
+func (s *Server) nfsCreate(ctx context.Context, args *xdrReader, res *xdrWriter) error {
+	dir, name, how := args.opaque(MaxHandleSize), args.string(maxName), args.uint32()
+	var attrs SetAttrs
+	var verifier []byte
+	switch how {
+	case createUnchecked, createGuarded:
+		attrs = readSetAttrs(args)
+	case createExclusive:
+		// The verifier is kept in the times, for a retransmitted call to
+		// find; the client sets the real times next
+		verifier = args.fixed(8)
+		if args.err == nil {
+			atime := time.Unix(int64(binary.BigEndian.Uint32(verifier[:4])), 0)
+			mtime := time.Unix(int64(binary.BigEndian.Uint32(verifier[4:])), 0)
+			attrs = SetAttrs{ATime: &atime, MTime: &mtime}
+		}
+	default:
+		return errGarbageArgs
+	}
+	if args.err != nil {
+		return errGarbageArgs
+	}
+
+	fh, err := s.fs.Create(ctx, dir, name, how != createUnchecked, attrs)
+	if how == createExclusive && errors.Is(err, ErrExist) {
+		// A retransmission of a create that succeeded finds its verifier
+		if existing, lookupErr := s.fs.Lookup(ctx, dir, name); lookupErr == nil {
+			if attr, attrErr := s.fs.GetAttr(ctx, existing); attrErr == nil &&
+				attr.ATime.Unix() == attrs.ATime.Unix() && attr.MTime.Unix() == attrs.MTime.Unix() {
+				fh, err = existing, nil
+			}
+		}
+	}
+	s.writeCreated(ctx, res, dir, fh, err)
+	return nil
+}
+
+func (s *Server) nfsMkdir(ctx context.Context, args *xdrReader, res *xdrWriter) error {
+	dir, name := args.opaque(MaxHandleSize), args.string(maxName)
+	attrs := readSetAttrs(args)
+	if args.err != nil {
+		return errGarbageArgs
+	}
+	fh, err := s.fs.Mkdir(ctx, dir, name, attrs)
+	s.writeCreated(ctx, res, dir, fh, err)
+	return nil
+}
+
+// nfsSymlink refuses symbolic links, which the file system cannot create
+func (s *Server) nfsSymlink(ctx context.Context, args *xdrReader, res *xdrWriter) error {
+	dir := args.opaque(MaxHandleSize)
+	args.string(maxName)
+	readSetAttrs(args)
+	args.string(maxMountPath)
+	if args.err != nil {
+		return errGarbageArgs
+	}
+	s.writeCreated(ctx, res, dir, nil, ErrNotSupp)
+	return nil
+}
+
+// nfsMknod refuses devices, sockets and FIFOs
+func (s *Server) nfsMknod(ctx context.Context, args *xdrReader, res *xdrWriter) error {
+	dir := args.opaque(MaxHandleSize)
+	args.string(maxName)
+	if args.err != nil {
+		return errGarbageArgs
+	}
+	s.writeCreated(ctx, res, dir, nil, ErrNotSupp)
+	return nil
+}
+
+func (s *Server) nfsRemove(ctx context.Context, args *xdrReader, res *xdrWriter) error {
+	dir, name := args.opaque(MaxHandleSize), args.string(maxName)
+	if args.err != nil {
+		return errGarbageArgs
+	}
+	err := s.fs.Remove(ctx, dir, name)
+	res.uint32(uint32(s.status(err)))
+	s.writeWcc(ctx, res, dir)
+	return nil
+}
+
+func (s *Server) nfsRmdir(ctx context.Context, args *xdrReader, res *xdrWriter) error {
+	dir, name := args.opaque(MaxHandleSize), args.string(maxName)
+	if args.err != nil {
+		return errGarbageArgs
+	}
+	err := s.fs.Rmdir(ctx, dir, name)
+	res.uint32(uint32(s.status(err)))
+	s.writeWcc(ctx, res, dir)
+	return nil
+}
+
+func (s *Server) nfsRename(ctx context.Context, args *xdrReader, res *xdrWriter) error {
+	fromDir, fromName := args.opaque(MaxHandleSize), args.string(maxName)
+	toDir, toName := args.opaque(MaxHandleSize), args.string(maxName)
+	if args.err != nil {
+		return errGarbageArgs
+	}
+	err := s.fs.Rename(ctx, fromDir, fromName, toDir, toName)
+	res.uint32(uint32(s.status(err)))
+	s.writeWcc(ctx, res, fromDir)
+	s.writeWcc(ctx, res, toDir)
+	return nil
+}
+
+// nfsLink refuses hard links
+func (s *Server) nfsLink(ctx context.Context, args *xdrReader, res *xdrWriter) error {
+	fh, dir := args.opaque(MaxHandleSize), args.opaque(MaxHandleSize)
+	args.string(maxName)
+	if args.err != nil {
+		return errGarbageArgs
+	}
+	res.uint32(uint32(ErrNotSupp))
+	s.writePostOpAttr(ctx, res, fh)
+	s.writeWcc(ctx, res, dir)
+	return nil
+}
+
+func (s *Server) nfsReadDir(ctx context.Context, args *xdrReader, res *xdrWriter) error {
+	dir, cookie, verifier := args.opaque(MaxHandleSize), args.uint64(), args.uint64()
+	count := args.uint32()
+	if args.err != nil {
+		return errGarbageArgs
+	}
+	return s.readDir(ctx, res, dir, cookie, verifier, count, count, false)
+}
+
+func (s *Server) nfsReadDirPlus(ctx context.Context, args *xdrReader, res *xdrWriter) error {
+	dir, cookie, verifier := args.opaque(MaxHandleSize), args.uint64(), args.uint64()
+	dirCount, maxCount := args.uint32(), args.uint32()
+	if args.err != nil {
+		return errGarbageArgs
+	}
+	return s.readDir(ctx, res, dir, cookie, verifier, dirCount, maxCount, true)
+}
+
+// readDir answers READDIR, and with plus READDIRPLUS, with the entries of dir
+// after cookie that fit maxCount bytes of reply, and for READDIRPLUS
+// dirCount bytes of names. The cookie of an entry is its position in the
+// listing, "." and ".." included. The listing is made when cookie is 0, or
+// when verifier no longer names it, and kept under a new verifier for the
+// calls reading the rest of it.
+func (s *Server) readDir(ctx context.Context, res *xdrWriter, dir []byte, cookie, verifier uint64, dirCount, maxCount uint32, plus bool) error {
+	dirAttr, err := s.fs.GetAttr(ctx, dir)
+	if err == nil && dirAttr.Type != TypeDirectory {
+		err = ErrNotDir
+	}
+	var entries []DirEntry
+	if err == nil && cookie != 0 {
+		entries = s.listings.get(ctx, verifier, dir)
+	}
+	if err == nil && entries == nil {
+		entries, err = s.listDir(ctx, dir, dirAttr)
+		if err == nil {
+			verifier = s.listings.put(ctx, dir, entries)
+		}
+	}
+	if err != nil {
+		res.uint32(uint32(s.status(err)))
+		s.writePostOpAttr(ctx, res, dir)
+		return nil
+	}
+
+	list := &xdrWriter{}
+	// The status, directory attributes, verifier and the end of the list
+	size, names := 4+4+attrSize+8+4+4, 0
+	eof := true
+	for i := cookie; i < uint64(len(entries)); i++ {
+		entry := entries[i]
+		fileID := uint64(0)
+		if entry.Attr != nil {
+			fileID = entry.Attr.FileID
+		} else if entry.Name == ".." {
+			fileID = dirAttr.FileID
+		}
+		encoded := &xdrWriter{}
+		encoded.bool(true)
+		encoded.uint64(fileID)
+		encoded.string(entry.Name)
+		encoded.uint64(i + 1)
+		nameSize := len(encoded.buf)
+		if plus {
+			if entry.Attr != nil {
+				encoded.bool(true)
+				writeAttr(encoded, entry.Attr)
+			} else {
+				encoded.bool(false)
+			}
+			if entry.Handle != nil {
+				encoded.bool(true)
+				encoded.opaque(entry.Handle)
+			} else {
+				encoded.bool(false)
+			}
+		}
+		if size+len(encoded.buf) > int(maxCount) || (plus && names+nameSize > int(dirCount)) {
+			eof = false
+			break
+		}
+		size += len(encoded.buf)
+		names += nameSize
+		list.buf = append(list.buf, encoded.buf...)
+	}
+	if !eof && len(list.buf) == 0 {
+		res.uint32(uint32(ErrTooSmall))
+		s.writePostOpAttr(ctx, res, dir)
+		return nil
+	}
+
+	if eof {
+		s.listings.remove(verifier)
+	}
+
+	res.uint32(0)
+	res.bool(true)
+	writeAttr(res, dirAttr)
+	res.uint64(verifier)
+	res.buf = append(res.buf, list.buf...)
+	res.bool(false)
+	res.bool(eof)
+	return nil
+}
+
+// listDir returns the entries of dir, whose attributes are dirAttr, after "."
+// and ".."
+func (s *Server) listDir(ctx context.Context, dir []byte, dirAttr *Attr) ([]DirEntry, error) {
+	entries, err := s.fs.ReadDir(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	dot := DirEntry{Name: ".", Handle: dir, Attr: dirAttr}
+	dotDot := DirEntry{Name: ".."}
+	if parent, err := s.fs.Lookup(ctx, dir, ".."); err == nil {
+		if attr, err := s.fs.GetAttr(ctx, parent); err == nil {
+			dotDot.Handle, dotDot.Attr = parent, attr
+		}
+	}
+	return append([]DirEntry{dot, dotDot}, entries...), nil
+}
+
+func (s *Server) nfsFSStat(ctx context.Context, args *xdrReader, res *xdrWriter) error {
+	fh := args.opaque(MaxHandleSize)
+	if args.err != nil {
+		return errGarbageArgs
+	}
+	stat, err := s.fs.FSStat(ctx, fh)
+	res.uint32(uint32(s.status(err)))
+	s.writePostOpAttr(ctx, res, fh)
+	if err == nil {
+		res.uint64(stat.TotalBytes)
+		res.uint64(stat.FreeBytes)
+		res.uint64(stat.AvailBytes)
+		res.uint64(stat.TotalFiles)
+		res.uint64(stat.FreeFiles)
+		res.uint64(stat.AvailFiles)
+		res.uint32(0) // Invariant for no time
+	}
+	return nil
+}
+
+func (s *Server) nfsFSInfo(ctx context.Context, args *xdrReader, res *xdrWriter) error {
+	fh := args.opaque(MaxHandleSize)
+	if args.err != nil {
+		return errGarbageArgs
+	}
+	_, err := s.fs.GetAttr(ctx, fh)
+	res.uint32(uint32(s.status(err)))
+	s.writePostOpAttr(ctx, res, fh)
+	if err == nil {
+		res.uint32(maxIOSize) // rtmax
+		res.uint32(maxIOSize) // rtpref
+		res.uint32(4096)      // rtmult
+		res.uint32(maxIOSize) // wtmax
+		res.uint32(maxIOSize) // wtpref
+		res.uint32(4096)      // wtmult
+		res.uint32(64 * 1024) // dtpref
+		res.uint64(math.MaxInt64)
+		res.uint32(0) // time_delta: microseconds
+		res.uint32(1000)
+		res.uint32(fsfHomogeneous | fsfCanSetTime)
+	}
+	return nil
+}
+
+func (s *Server) nfsPathConf(ctx context.Context, args *xdrReader, res *xdrWriter) error {
+	fh := args.opaque(MaxHandleSize)
+	if args.err != nil {
+		return errGarbageArgs
+	}
+	_, err := s.fs.GetAttr(ctx, fh)
+	res.uint32(uint32(s.status(err)))
+	s.writePostOpAttr(ctx, res, fh)
+	if err == nil {
+		res.uint32(1)       // linkmax
+		res.uint32(maxName) // name_max
+		res.bool(true)      // no_trunc
+		res.bool(true)      // chown_restricted
+		res.bool(false)     // case_insensitive
+		res.bool(true)      // case_preserving
+	}
+	return nil
+}
+
+func (s *Server) nfsCommit(ctx context.Context, args *xdrReader, res *xdrWriter) error {
+	fh := args.opaque(MaxHandleSize)
+	args.uint64() // The whole file is committed, whatever the range
+	args.uint32()
+	if args.err != nil {
+		return errGarbageArgs
+	}
+	err := s.fs.Commit(ctx, fh)
+	res.uint32(uint32(s.status(err)))
+	s.writeWcc(ctx, res, fh)
+	if err == nil {
+		res.fixed(s.verifier[:])
+	}
+	return nil
+}
+
+// readSetAttrs decodes an sattr3
+func readSetAttrs(args *xdrReader) SetAttrs {
+	var attrs SetAttrs
+	for _, field := range []**uint32{&attrs.Mode, &attrs.UID, &attrs.GID} {
+		if args.bool() {
+			v := args.uint32()
+			*field = &v
+		}
+	}
+	if args.bool() {
+		size := args.uint64()
+		attrs.Size = &size
+	}
+	serverTimes, clientTimes := false, false
+	for _, field := range []**time.Time{&attrs.ATime, &attrs.MTime} {
+		switch args.uint32() {
+		case 1: // SET_TO_SERVER_TIME
+			now := time.Now()
+			*field = &now
+			serverTimes = true
+		case 2: // SET_TO_CLIENT_TIME
+			t := args.time()
+			*field = &t
+			clientTimes = true
+		}
+	}
+	attrs.ServerTimes = serverTimes && !clientTimes
+	return attrs
+}
+
+// attrSize is the length of an encoded fattr3
+const attrSize = 84
+
+// writeAttr encodes attr as an fattr3
+func writeAttr(res *xdrWriter, attr *Attr) {
+	res.uint32(uint32(attr.Type))
+	res.uint32(attr.Mode)
+	res.uint32(attr.NLink)
+	res.uint32(attr.UID)
+	res.uint32(attr.GID)
+	res.uint64(attr.Size)
+	res.uint64(attr.Size) // Used
+	res.uint32(0)         // rdev
+	res.uint32(0)
+	res.uint64(fsid)
+	res.uint64(attr.FileID)
+	res.time(attr.ATime)
+	res.time(attr.MTime)
+	res.time(attr.CTime)
+}
+
+// writePostOpAttr encodes the attributes of fh, if they can be read, as a
+// post_op_attr
+func (s *Server) writePostOpAttr(ctx context.Context, res *xdrWriter, fh []byte) {
+	attr, err := s.fs.GetAttr(ctx, fh)
+	if err != nil {
+		res.bool(false)
+		return
+	}
+	res.bool(true)
+	writeAttr(res, attr)
+}
+
+// writeWcc encodes the wcc_data of fh after a change. The attributes before
+// it are not kept.
+func (s *Server) writeWcc(ctx context.Context, res *xdrWriter, fh []byte) {
+	res.bool(false)
+	s.writePostOpAttr(ctx, res, fh)
+}
+
+// writeCreated encodes the result of CREATE, MKDIR, SYMLINK and MKNOD
+func (s *Server) writeCreated(ctx context.Context, res *xdrWriter, dir, fh []byte, err error) {
+	res.uint32(uint32(s.status(err)))
+	if err == nil {
+		res.bool(true)
+		res.opaque(fh)
+		s.writePostOpAttr(ctx, res, fh)
+	}
+	s.writeWcc(ctx, res, dir)
+}
@@ -0,0 +1,194 @@
+package nfs
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// memFS is a directory of files, the root, with handle "/"
+type memFS struct {
+	FileSystem // Calls the tests do not make panic
+	names      []string
+	content    []byte
+	readDirs   int
+}
+
+func (m *memFS) GetAttr(_ context.Context, fh []byte) (*Attr, error) {
+	if string(fh) == "/" {
+		return &Attr{Type: TypeDirectory, Mode: 0o755, FileID: 1}, nil
+	}
+	return &Attr{Type: TypeRegular, Mode: 0o644, Size: uint64(len(m.content)), FileID: 2}, nil
+}
+
+func (m *memFS) Lookup(_ context.Context, dir []byte, name string) ([]byte, error) {
+	if name == ".." {
+		return []byte("/"), nil
+	}
+	return []byte("/" + name), nil
+}
+
+func (m *memFS) ReadDir(context.Context, []byte) ([]DirEntry, error) {
+	m.readDirs++
+	entries := make([]DirEntry, len(m.names))
+	for i, name := range m.names {
+		entries[i] = DirEntry{Name: name, Handle: []byte("/" + name), Attr: &Attr{Type: TypeRegular, FileID: uint64(10 + i)}}
+	}
+	return entries, nil
+}
+
+func (m *memFS) Read(_ context.Context, fh []byte, offset uint64, count uint32) ([]byte, bool, error) {
+	if string(fh) == "/" {
+		return nil, false, ErrIsDir
+	}
+	if offset >= uint64(len(m.content)) {
+		return nil, true, nil
+	}
+	end := min(offset+uint64(count), uint64(len(m.content)))
+	return m.content[offset:end], end == uint64(len(m.content)), nil
+}
+
+// call serves proc with args as uid 1000 and returns its accept status and
+// results
+func call(t *testing.T, s *Server, proc uint32, args *xdrWriter) (uint32, *xdrReader) {
+	t.Helper()
+	accept, denied, res := replyStatus(t, s.handleCall(testAddr, rpcCall(progNFS, 3, proc, authSys, authSysCred(1000, 1000, 0), args.buf)))
+	if denied {
+		t.Fatalf("procedure %d denied", proc)
+	}
+	return accept, res
+}
+
+// skipPostOpAttr reads past post-op attributes
+func skipPostOpAttr(r *xdrReader) {
+	if r.bool() {
+		r.fixed(attrSize)
+	}
+}
+
+func TestNFSRead(t *testing.T) {
+	s := NewServer(&memFS{content: []byte("hello world")}, nil, zap.NewNop())
+
+	args := &xdrWriter{}
+	args.opaque([]byte("/f"))
+	args.uint64(6)
+	args.uint32(100)
+	accept, res := call(t, s, procRead, args)
+	if accept != acceptSuccess {
+		t.Fatalf("accept status %d", accept)
+	}
+	status := res.uint32()
+	skipPostOpAttr(res)
+	count, eof, data := res.uint32(), res.bool(), res.opaque(maxIOSize)
+	if status != 0 || count != 5 || !eof || string(data) != "world" || res.err != nil {
+		t.Errorf("read status %d: %d bytes %q, eof %v, err %v", status, count, data, eof, res.err)
+	}
+
+	args = &xdrWriter{}
+	args.opaque([]byte("/"))
+	args.uint64(0)
+	args.uint32(100)
+	if _, res := call(t, s, procRead, args); res.uint32() != uint32(ErrIsDir) {
+		t.Error("directory read without NFS3ERR_ISDIR")
+	}
+}
+
+func TestNFSArgumentsMalformed(t *testing.T) {
+	s := NewServer(&memFS{}, nil, zap.NewNop())
+
+	longHandle := &xdrWriter{}
+	longHandle.opaque(make([]byte, MaxHandleSize+1))
+	noOffset := &xdrWriter{}
+	noOffset.opaque([]byte("/f"))
+	longName := &xdrWriter{}
+	longName.opaque([]byte("/"))
+	longName.string(string(make([]byte, maxName+1)))
+	oversizedWrite := &xdrWriter{}
+	oversizedWrite.opaque([]byte("/f"))
+	oversizedWrite.uint64(0)
+	oversizedWrite.uint32(1)
+	oversizedWrite.uint32(writeUnstable)
+	oversizedWrite.uint32(maxIOSize + 1)
+	noVerifier := &xdrWriter{}
+	noVerifier.opaque([]byte("/"))
+	noVerifier.uint64(0)
+
+	for name, tt := range map[string]struct {
+		proc uint32
+		args *xdrWriter
+	}{
+		"GETATTR handle over 64 bytes": {procGetAttr, longHandle},
+		"READ without offset":          {procRead, noOffset},
+		"LOOKUP name over 255 bytes":   {procLookup, longName},
+		"WRITE over the I/O size":      {procWrite, oversizedWrite},
+		"READDIR without verifier":     {procReadDir, noVerifier},
+	} {
+		if accept, _ := call(t, s, tt.proc, tt.args); accept != acceptGarbageArgs {
+			t.Errorf("%s: accept status %d, want GARBAGE_ARGS", name, accept)
+		}
+	}
+}
+
+// readDirPage reads a page of the root after cookie, and returns the names
+// on it, the cookie after them, the verifier and whether it is the last
+func readDirPage(t *testing.T, s *Server, cookie, verifier uint64, count uint32) ([]string, uint64, uint64, bool) {
+	t.Helper()
+	args := &xdrWriter{}
+	args.opaque([]byte("/"))
+	args.uint64(cookie)
+	args.uint64(verifier)
+	args.uint32(count)
+	accept, res := call(t, s, procReadDir, args)
+	if status := res.uint32(); accept != acceptSuccess || status != 0 {
+		t.Fatalf("READDIR: accept status %d, status %d", accept, status)
+	}
+	skipPostOpAttr(res)
+	verifier = res.uint64()
+	var names []string
+	for res.bool() {
+		res.uint64()
+		names = append(names, res.string(maxName))
+		cookie = res.uint64()
+	}
+	eof := res.bool()
+	if res.err != nil {
+		t.Fatalf("READDIR reply: %v", res.err)
+	}
+	return names, cookie, verifier, eof
+}
+
+func TestNFSReadDirPages(t *testing.T) {
+	fs := &memFS{}
+	for i := range 200 {
+		fs.names = append(fs.names, fmt.Sprintf("file-%03d", i))
+	}
+	s := NewServer(fs, nil, zap.NewNop())
+
+	var all []string
+	var cookie, verifier uint64
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatal("listing does not end")
+		}
+		names, next, v, eof := readDirPage(t, s, cookie, verifier, 1024)
+		all = append(all, names...)
+		cookie, verifier = next, v
+		if eof {
+			break
+		}
+	}
+	if len(all) != 202 || all[0] != "." || all[1] != ".." || all[201] != "file-199" {
+		t.Errorf("listed %d entries, from %v", len(all), all[:min(3, len(all))])
+	}
+	if fs.readDirs != 1 {
+		t.Errorf("directory listed %d times for one pass", fs.readDirs)
+	}
+
+	// A verifier the server no longer knows lists the directory again
+	names, _, v, _ := readDirPage(t, s, 10, verifier^1, 1024)
+	if fs.readDirs != 2 || len(names) == 0 || names[0] != "file-008" || v == verifier^1 {
+		t.Errorf("stale verifier: %d listings, first entry %v, verifier %#x", fs.readDirs, names[:min(1, len(names))], v)
+	}
+}
@@ -0,0 +1,215 @@
+package nfs
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime/debug"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ONC RPC (RFC 5531) constants of the calls served
+const (
+	rpcVersion = 2
+
+	msgCall  = 0
+	msgReply = 1
+
+	replyAccepted = 0
+	replyDenied   = 1
+
+	acceptSuccess      = 0
+	acceptProgUnavail  = 1
+	acceptProgMismatch = 2
+	acceptProcUnavail  = 3
+	acceptGarbageArgs  = 4
+	acceptSystemErr    = 5
+
+	rejectRPCMismatch = 0
+	rejectAuthError   = 1
+
+	authNone = 0
+	authSys  = 1
+
+	authBadCred = 1
+
+	progNFS   = 100003
+	progMount = 100005
+)
+
+// maxRecordSize bounds a call, which holds at most maxIOSize bytes of data
+const maxRecordSize = maxIOSize + 64*1024
+
+// maxConcurrentCalls bounds the calls of one connection served at once
+const maxConcurrentCalls = 32
+
+// errProcUnavail is returned by a program for a procedure it does not have
+var errProcUnavail = errors.New("procedure unavailable")
+
+// procedure serves a call, decoding its arguments from args and encoding its
+// results to res. It returns errGarbageArgs for arguments it cannot decode.
+type procedure func(ctx context.Context, args *xdrReader, res *xdrWriter) error
+
+// readRecord reads the fragments of one record (RFC 5531 section 11)
+func readRecord(r *bufio.Reader) ([]byte, error) {
+	var record []byte
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, err
+		}
+		marker := binary.BigEndian.Uint32(header[:])
+		size := int(marker & 0x7fffffff)
+		if len(record)+size > maxRecordSize {
+			return nil, fmt.Errorf("record exceeds %d bytes", maxRecordSize)
+		}
+		start := len(record)
+		record = append(record, make([]byte, size)...)
+		if _, err := io.ReadFull(r, record[start:]); err != nil {
+			return nil, err
+		}
+		if marker&0x80000000 != 0 {
+			return record, nil
+		}
+	}
+}
+
+// writeRecord writes reply as a single-fragment record
+func writeRecord(w io.Writer, reply []byte) error {
+	record := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(reply)), uint32(len(reply))|0x80000000)
+	_, err := w.Write(append(record, reply...))
+	return err
+}
+
+// handleCall serves the call in record from addr and returns the reply to
+// send, or nil when there is none
+func (s *Server) handleCall(addr net.Addr, record []byte) []byte {
+	args := &xdrReader{buf: record}
+	xid := args.uint32()
+	if args.uint32() != msgCall || args.err != nil {
+		return nil
+	}
+	reply := &xdrWriter{}
+	reply.uint32(xid)
+	reply.uint32(msgReply)
+
+	version, prog, vers, proc := args.uint32(), args.uint32(), args.uint32(), args.uint32()
+	flavor, cred := args.uint32(), args.opaque(400)
+	args.uint32() // The verifier of AUTH_NONE and AUTH_SYS calls is empty
+	args.opaque(400)
+	if args.err != nil {
+		return nil
+	}
+	if version != rpcVersion {
+		reply.uint32(replyDenied)
+		reply.uint32(rejectRPCMismatch)
+		reply.uint32(rpcVersion)
+		reply.uint32(rpcVersion)
+		return reply.buf
+	}
+
+	creds, ok := parseCredentials(flavor, cred)
+	if !ok {
+		reply.uint32(replyDenied)
+		reply.uint32(rejectAuthError)
+		reply.uint32(authBadCred)
+		return reply.buf
+	}
+	creds.Addr = addr
+
+	reply.uint32(replyAccepted)
+	reply.uint32(authNone)
+	reply.uint32(0)
+
+	var procs map[uint32]procedure
+	var name string
+	switch {
+	case prog == progNFS && vers == 3:
+		procs, name = s.nfsProcedures, "NFS"
+	case prog == progMount && vers == 3:
+		procs, name = s.mountProcedures, "MOUNT"
+	case prog == progNFS || prog == progMount:
+		reply.uint32(acceptProgMismatch)
+		reply.uint32(3)
+		reply.uint32(3)
+		return reply.buf
+	default:
+		reply.uint32(acceptProgUnavail)
+		return reply.buf
+	}
+	serve, ok := procs[proc]
+	if !ok {
+		reply.uint32(acceptProcUnavail)
+		return reply.buf
+	}
+
+	base := context.Background()
+	if s.BaseContext != nil {
+		base = s.BaseContext()
+	}
+	ctx := context.WithValue(base, credentialsKey{}, creds)
+	start := time.Now()
+	res := &xdrWriter{}
+	err := s.serveProcedure(ctx, name, proc, serve, args, res)
+	switch {
+	case errors.Is(err, errGarbageArgs):
+		reply.uint32(acceptGarbageArgs)
+	case errors.Is(err, errProcUnavail):
+		reply.uint32(acceptProcUnavail)
+	case err != nil:
+		reply.uint32(acceptSystemErr)
+	default:
+		reply.uint32(acceptSuccess)
+		reply.buf = append(reply.buf, res.buf...)
+	}
+	s.logger.Debug("NFS call served",
+		zap.String("program", name),
+		zap.Uint32("procedure", proc),
+		zap.Uint32("uid", creds.UID),
+		zap.String("remote_addr", addr.String()),
+		zap.Duration("duration", time.Since(start)),
+		zap.Error(err))
+	return reply.buf
+}
+
+// serveProcedure runs serve, answering a panic with a system error
+func (s *Server) serveProcedure(ctx context.Context, program string, proc uint32, serve procedure, args *xdrReader, res *xdrWriter) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			s.logger.Error("NFS procedure panicked",
+				zap.String("program", program),
+				zap.Uint32("procedure", proc),
+				zap.Any("panic", recovered),
+				zap.ByteString("stack", debug.Stack()))
+			err = fmt.Errorf("procedure panicked: %v", recovered)
+		}
+	}()
+	return serve(ctx, args, res)
+}
+
+// parseCredentials decodes the AUTH_NONE or AUTH_SYS credentials of a call
+func parseCredentials(flavor uint32, body []byte) (Credentials, bool) {
+	switch flavor {
+	case authNone:
+		return Credentials{UID: 65534, GID: 65534}, true
+	case authSys:
+		r := &xdrReader{buf: body}
+		r.uint32() // Stamp
+		creds := Credentials{Machine: r.string(255), UID: r.uint32(), GID: r.uint32()}
+		count := r.uint32()
+		if count > 16 {
+			return Credentials{}, false
+		}
+		for range count {
+			creds.GIDs = append(creds.GIDs, r.uint32())
+		}
+		return creds, r.err == nil
+	}
+	return Credentials{}, false
+}
@@ -0,0 +1,164 @@
+package nfs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+var testAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 700}
+
+// authSysCred encodes AUTH_SYS credentials with gids supplementary groups
+func authSysCred(uid, gid uint32, gids int) []byte {
+	w := &xdrWriter{}
+	w.uint32(0)
+	w.string("client")
+	w.uint32(uid)
+	w.uint32(gid)
+	w.uint32(uint32(gids))
+	for i := range gids {
+		w.uint32(uint32(100 + i))
+	}
+	return w.buf
+}
+
+// rpcCall encodes a call of proc with the credentials cred of flavor,
+// followed by args
+func rpcCall(prog, vers, proc, flavor uint32, cred, args []byte) []byte {
+	w := &xdrWriter{}
+	w.uint32(0x1234)
+	w.uint32(msgCall)
+	w.uint32(rpcVersion)
+	w.uint32(prog)
+	w.uint32(vers)
+	w.uint32(proc)
+	w.uint32(flavor)
+	w.opaque(cred)
+	w.uint32(authNone)
+	w.opaque(nil)
+	w.buf = append(w.buf, args...)
+	return w.buf
+}
+
+// replyStatus decodes a reply up to its accept status, and returns it with
+// the results that follow. denied is set for a rejected call.
+func replyStatus(t *testing.T, reply []byte) (accept uint32, denied bool, results *xdrReader) {
+	t.Helper()
+	r := &xdrReader{buf: reply}
+	if xid := r.uint32(); xid != 0x1234 {
+		t.Fatalf("xid %#x", xid)
+	}
+	if r.uint32() != msgReply {
+		t.Fatal("not a reply")
+	}
+	if r.uint32() == replyDenied {
+		return r.uint32(), true, r
+	}
+	r.uint32()
+	r.opaque(400)
+	accept = r.uint32()
+	if r.err != nil {
+		t.Fatalf("truncated reply: %v", r.err)
+	}
+	return accept, false, r
+}
+
+func TestReadRecord(t *testing.T) {
+	fragment := func(last bool, data []byte) []byte {
+		marker := uint32(len(data))
+		if last {
+			marker |= 0x80000000
+		}
+		return append(binary.BigEndian.AppendUint32(nil, marker), data...)
+	}
+
+	stream := append(fragment(false, []byte("ab")), fragment(true, []byte("cd"))...)
+	record, err := readRecord(bufio.NewReader(bytes.NewReader(stream)))
+	if err != nil || string(record) != "abcd" {
+		t.Errorf("fragments read as %q, %v", record, err)
+	}
+
+	truncated := fragment(true, []byte("abcd"))[:6]
+	if _, err := readRecord(bufio.NewReader(bytes.NewReader(truncated))); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncated record: err = %v", err)
+	}
+
+	// The size is refused before anything is allocated for it
+	oversized := binary.BigEndian.AppendUint32(nil, 0x80000000|uint32(maxRecordSize+1))
+	if _, err := readRecord(bufio.NewReader(bytes.NewReader(oversized))); err == nil {
+		t.Error("oversized record accepted")
+	}
+	split := append(fragment(false, make([]byte, maxRecordSize)), binary.BigEndian.AppendUint32(nil, 0x80000001)...)
+	if _, err := readRecord(bufio.NewReader(bytes.NewReader(split))); err == nil {
+		t.Error("record oversized across fragments accepted")
+	}
+}
+
+func TestHandleCallRejects(t *testing.T) {
+	s := NewServer(nil, nil, zap.NewNop())
+	getAttr := &xdrWriter{}
+	getAttr.opaque([]byte{1})
+
+	tests := []struct {
+		name   string
+		record []byte
+		accept uint32
+		denied bool
+	}{
+		{"unknown program", rpcCall(100099, 1, 0, authNone, nil, nil), acceptProgUnavail, false},
+		{"NFS version 4", rpcCall(progNFS, 4, 0, authNone, nil, nil), acceptProgMismatch, false},
+		{"unknown procedure", rpcCall(progNFS, 3, 99, authNone, nil, nil), acceptProcUnavail, false},
+		{"truncated arguments", rpcCall(progNFS, 3, procGetAttr, authNone, nil, getAttr.buf[:2]), acceptGarbageArgs, false},
+		{"unknown flavor", rpcCall(progNFS, 3, procNull, 6, nil, nil), rejectAuthError, true},
+		{"truncated AUTH_SYS", rpcCall(progNFS, 3, procNull, authSys, authSysCred(1, 1, 0)[:10], nil), rejectAuthError, true},
+		{"too many groups", rpcCall(progNFS, 3, procNull, authSys, authSysCred(1, 1, 17), nil), rejectAuthError, true},
+		{"null", rpcCall(progNFS, 3, procNull, authSys, authSysCred(1, 1, 16), nil), acceptSuccess, false},
+	}
+	for _, tt := range tests {
+		reply := s.handleCall(testAddr, tt.record)
+		if reply == nil {
+			t.Errorf("%s: no reply", tt.name)
+			continue
+		}
+		accept, denied, _ := replyStatus(t, reply)
+		if accept != tt.accept || denied != tt.denied {
+			t.Errorf("%s: status %d (denied %v), want %d (denied %v)", tt.name, accept, denied, tt.accept, tt.denied)
+		}
+	}
+
+	mismatch := rpcCall(progNFS, 3, procNull, authNone, nil, nil)
+	binary.BigEndian.PutUint32(mismatch[8:], 3)
+	if accept, denied, _ := replyStatus(t, s.handleCall(testAddr, mismatch)); !denied || accept != rejectRPCMismatch {
+		t.Errorf("RPC version 3: status %d (denied %v)", accept, denied)
+	}
+
+	// Records that are not calls, or cut short in the header, get no reply
+	reply := rpcCall(progNFS, 3, procNull, authNone, nil, nil)
+	binary.BigEndian.PutUint32(reply[4:], msgReply)
+	for name, record := range map[string][]byte{
+		"reply":          reply,
+		"short header":   rpcCall(progNFS, 3, procNull, authNone, nil, nil)[:20],
+		"oversized cred": rpcCall(progNFS, 3, procNull, authSys, make([]byte, 404), nil),
+		"empty":          nil,
+	} {
+		if got := s.handleCall(testAddr, record); got != nil {
+			t.Errorf("%s: replied %d bytes", name, len(got))
+		}
+	}
+}
+
+func TestParseCredentials(t *testing.T) {
+	creds, ok := parseCredentials(authSys, authSysCred(1001, 1002, 2))
+	if !ok || creds.UID != 1001 || creds.GID != 1002 || creds.Machine != "client" || len(creds.GIDs) != 2 || creds.GIDs[1] != 101 {
+		t.Errorf("AUTH_SYS parsed as %+v, %v", creds, ok)
+	}
+	if creds, ok := parseCredentials(authNone, nil); !ok || creds.UID != 65534 {
+		t.Errorf("AUTH_NONE parsed as %+v, %v", creds, ok)
+	}
+}
@@ -0,0 +1,224 @@
+// Package nfs serves a FileSystem over NFSv3 (RFC 1813) and its MOUNT
+// protocol, on a single TCP port. Clients mount it without the portmapper,
+// with options such as
+//
+//	mount -t nfs -o vers=3,proto=tcp,port=2049,mountport=2049,nolock server:/ /mnt
+//
+// Network Lock Manager calls are not served, so locks stay local to each
+// client.
+package nfs
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrServerClosed is returned by Serve once Shutdown is called
+var ErrServerClosed = errors.New("nfs: server closed")
+
+// writeTimeout bounds how long a reply may take to send
+const writeTimeout = 30 * time.Second
+
+// Flusher is a FileSystem holding writes until they are committed. Shutdown
+// flushes them once the calls in progress are served.
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// Server serves a FileSystem to the clients of a set of networks
+type Server struct {
+	// BaseContext, when set, returns the context each call is served with,
+	// as for http.Server
+	BaseContext func() context.Context
+
+	fs       FileSystem
+	allowed  []*net.IPNet
+	logger   *zap.Logger
+	verifier [8]byte // Changes with each start, for clients to resend unstable writes
+	listings listingCache
+
+	nfsProcedures   map[uint32]procedure
+	mountProcedures map[uint32]procedure
+
+	mu        sync.Mutex
+	closing   bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	active    sync.WaitGroup // Connections being served
+}
+
+// NewServer returns a server of fs. Only clients in the allowed networks
+// may connect; none may when it is empty.
+func NewServer(fs FileSystem, allowed []*net.IPNet, logger *zap.Logger) *Server {
+	s := &Server{
+		fs:        fs,
+		allowed:   allowed,
+		logger:    logger,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+	_, _ = rand.Read(s.verifier[:])
+	s.nfsProcedures = s.nfs3Procedures()
+	s.mountProcedures = s.mount3Procedures()
+	return s
+}
+
+// Serve accepts connections on l until Shutdown is called or accepting
+// fails
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closing := s.closing
+			s.mu.Unlock()
+			if closing {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		if !s.permitted(conn.RemoteAddr()) {
+			s.logger.Warn("NFS connection rejected by allowlist", zap.String("remote_addr", conn.RemoteAddr().String()))
+			_ = conn.Close()
+			continue
+		}
+
+		s.mu.Lock()
+		if s.closing {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.active.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Shutdown stops accepting connections and calls, waits for the calls in
+// progress and flushes the writes the file system holds. Connections still
+// busy when ctx is done are closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	for l := range s.listeners {
+		_ = l.Close()
+	}
+	// Connections stop reading calls, and close once theirs are answered
+	for conn := range s.conns {
+		_ = conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.active.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		s.mu.Lock()
+		for conn := range s.conns {
+			_ = conn.Close()
+		}
+		s.mu.Unlock()
+	}
+
+	if flusher, ok := s.fs.(Flusher); ok {
+		if flushErr := flusher.Flush(ctx); flushErr != nil && err == nil {
+			err = flushErr
+		}
+	}
+	return err
+}
+
+// serveConn serves the calls of conn, several at a time, until it is closed
+func (s *Server) serveConn(conn net.Conn) {
+	var calls sync.WaitGroup
+	var writeMu sync.Mutex
+	defer func() {
+		calls.Wait()
+		_ = conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.active.Done()
+	}()
+
+	addr := conn.RemoteAddr()
+	reader := bufio.NewReaderSize(conn, 64*1024)
+	slots := make(chan struct{}, maxConcurrentCalls)
+	for {
+		record, err := readRecord(reader)
+		if err != nil {
+			var netErr net.Error
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && !(errors.As(err, &netErr) && netErr.Timeout()) {
+				s.logger.Debug("NFS connection closed", zap.String("remote_addr", addr.String()), zap.Error(err))
+			}
+			return
+		}
+
+		slots <- struct{}{}
+		calls.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				calls.Done()
+			}()
+			reply := s.handleCall(addr, record)
+			if reply == nil {
+				return
+			}
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := writeRecord(conn, reply); err != nil {
+				s.logger.Debug("Failed to send NFS reply", zap.String("remote_addr", addr.String()), zap.Error(err))
+			}
+		}()
+	}
+}
+
+// permitted reports whether clients at addr may connect
+func (s *Server) permitted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range s.allowed {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
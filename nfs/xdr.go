@@ -0,0 +1,120 @@
+package nfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// errGarbageArgs is returned for arguments that cannot be decoded
+var errGarbageArgs = errors.New("malformed XDR arguments")
+
+// xdrReader decodes the XDR (RFC 4506) arguments of a call
+type xdrReader struct {
+	buf []byte
+	err error
+}
+
+func (r *xdrReader) uint32() uint32 {
+	if r.err != nil || len(r.buf) < 4 {
+		r.err = errGarbageArgs
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.buf)
+	r.buf = r.buf[4:]
+	return v
+}
+
+func (r *xdrReader) uint64() uint64 {
+	return uint64(r.uint32())<<32 | uint64(r.uint32())
+}
+
+func (r *xdrReader) bool() bool {
+	return r.uint32() != 0
+}
+
+// fixed reads n bytes of fixed-length opaque data
+func (r *xdrReader) fixed(n int) []byte {
+	padded := (n + 3) &^ 3
+	if r.err != nil || len(r.buf) < padded {
+		r.err = errGarbageArgs
+		return nil
+	}
+	v := r.buf[:n:n]
+	r.buf = r.buf[padded:]
+	return v
+}
+
+// opaque reads variable-length opaque data of at most limit bytes
+func (r *xdrReader) opaque(limit int) []byte {
+	n := r.uint32()
+	if r.err == nil && n > uint32(limit) {
+		r.err = errGarbageArgs
+	}
+	return r.fixed(int(n))
+}
+
+func (r *xdrReader) string(limit int) string {
+	return string(r.opaque(limit))
+}
+
+// time reads an nfstime3
+func (r *xdrReader) time() time.Time {
+	seconds, nanoseconds := r.uint32(), r.uint32()
+	return time.Unix(int64(seconds), int64(nanoseconds))
+}
+
+// xdrWriter encodes the XDR results of a call
+type xdrWriter struct {
+	buf []byte
+}
+
+func (w *xdrWriter) uint32(v uint32) {
+	w.buf = binary.BigEndian.AppendUint32(w.buf, v)
+}
+
+func (w *xdrWriter) uint64(v uint64) {
+	w.buf = binary.BigEndian.AppendUint64(w.buf, v)
+}
+
+func (w *xdrWriter) bool(v bool) {
+	if v {
+		w.uint32(1)
+	} else {
+		w.uint32(0)
+	}
+}
+
+// fixed writes fixed-length opaque data
+func (w *xdrWriter) fixed(v []byte) {
+	w.buf = append(w.buf, v...)
+	for len(w.buf)%4 != 0 {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+// opaque writes variable-length opaque data
+func (w *xdrWriter) opaque(v []byte) {
+	w.uint32(uint32(len(v)))
+	w.fixed(v)
+}
+
+func (w *xdrWriter) string(v string) {
+	w.opaque([]byte(v))
+}
+
+// time writes an nfstime3. Times before 1970 or past 2106 are clamped.
+func (w *xdrWriter) time(t time.Time) {
+	seconds := t.Unix()
+	switch {
+	case seconds < 0:
+		w.uint32(0)
+		w.uint32(0)
+	case seconds > 1<<32-1:
+		w.uint32(1<<32 - 1)
+		w.uint32(0)
+	default:
+		w.uint32(uint32(seconds))
+		w.uint32(uint32(t.Nanosecond()))
+	}
+}
@@ -0,0 +1,91 @@
+package nfs
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestXDRRoundTrip(t *testing.T) {
+	w := &xdrWriter{}
+	w.uint32(7)
+	w.uint64(1<<40 + 3)
+	w.bool(true)
+	w.opaque([]byte{1, 2, 3, 4, 5})
+	w.string("name")
+	w.fixed([]byte{9})
+	w.time(time.Unix(1700000000, 42))
+	if len(w.buf)%4 != 0 {
+		t.Fatalf("encoded %d bytes, not a multiple of 4", len(w.buf))
+	}
+
+	r := &xdrReader{buf: w.buf}
+	if v := r.uint32(); v != 7 {
+		t.Errorf("uint32 = %d", v)
+	}
+	if v := r.uint64(); v != 1<<40+3 {
+		t.Errorf("uint64 = %d", v)
+	}
+	if !r.bool() {
+		t.Error("bool = false")
+	}
+	if v := r.opaque(8); !bytes.Equal(v, []byte{1, 2, 3, 4, 5}) {
+		t.Errorf("opaque = %v", v)
+	}
+	if v := r.string(maxName); v != "name" {
+		t.Errorf("string = %q", v)
+	}
+	if v := r.fixed(1); !bytes.Equal(v, []byte{9}) {
+		t.Errorf("fixed = %v", v)
+	}
+	if v := r.time(); !v.Equal(time.Unix(1700000000, 42)) {
+		t.Errorf("time = %v", v)
+	}
+	if r.err != nil || len(r.buf) != 0 {
+		t.Errorf("err %v, %d bytes left", r.err, len(r.buf))
+	}
+}
+
+func TestXDRReaderMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		buf  []byte
+		read func(r *xdrReader)
+	}{
+		{"short uint32", []byte{0, 0, 1}, func(r *xdrReader) { r.uint32() }},
+		{"short uint64", []byte{0, 0, 0, 0, 0, 0, 1}, func(r *xdrReader) { r.uint64() }},
+		{"opaque over limit", []byte{0, 0, 0, 9, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 0, 0}, func(r *xdrReader) { r.opaque(8) }},
+		{"opaque past end", []byte{0, 0, 0, 4, 1, 2}, func(r *xdrReader) { r.opaque(8) }},
+		{"opaque missing padding", []byte{0, 0, 0, 1, 1}, func(r *xdrReader) { r.opaque(8) }},
+		{"huge length", []byte{0xff, 0xff, 0xff, 0xff}, func(r *xdrReader) { r.opaque(MaxHandleSize) }},
+	}
+	for _, tt := range tests {
+		r := &xdrReader{buf: tt.buf}
+		tt.read(r)
+		if !errors.Is(r.err, errGarbageArgs) {
+			t.Errorf("%s: err = %v, want errGarbageArgs", tt.name, r.err)
+		}
+		// Reads after an error keep failing
+		if r.uint32() != 0 || r.err == nil {
+			t.Errorf("%s: read after error succeeded", tt.name)
+		}
+	}
+}
+
+func TestXDRWriterTimeClamped(t *testing.T) {
+	for _, tt := range []struct {
+		t       time.Time
+		seconds uint32
+	}{
+		{time.Unix(-5, 0), 0},
+		{time.Unix(1<<33, 0), 1<<32 - 1},
+	} {
+		w := &xdrWriter{}
+		w.time(tt.t)
+		r := &xdrReader{buf: w.buf}
+		if seconds := r.uint32(); seconds != tt.seconds {
+			t.Errorf("%v: seconds %d, want %d", tt.t, seconds, tt.seconds)
+		}
+	}
+}
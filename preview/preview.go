@@ -0,0 +1,162 @@
+// Package preview renders thumbnails of images, and of the first page of PDF
+// documents through an external command.
+package preview
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // Register the GIF decoder
+	"image/jpeg"
+	_ "image/png" // Register the PNG decoder
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ContentType is the media type of rendered previews
+const ContentType = "image/jpeg"
+
+// ErrUnsupported is returned for files no preview can be rendered of
+var ErrUnsupported = errors.New("no preview available for this file type")
+
+// ErrTooLarge is returned for files too large to render a preview of
+var ErrTooLarge = errors.New("file is too large to preview")
+
+// imageExtensions are the file types decoded as images
+var imageExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true}
+
+// Options configures a Renderer
+type Options struct {
+	MaxSourceBytes int64         // Largest file read to render a preview
+	MaxPixels      int64         // Largest image decoded, in pixels
+	Quality        int           // JPEG quality of previews, 1 to 100
+	PDFCommand     []string      // Renders the first page of a PDF on stdin as an image on stdout; PDFs are unsupported when empty
+	PDFTimeout     time.Duration // Bound on PDFCommand
+}
+
+// Renderer renders previews
+type Renderer struct {
+	opts Options
+}
+
+// NewRenderer returns a renderer with opts
+func NewRenderer(opts Options) *Renderer {
+	return &Renderer{opts: opts}
+}
+
+// Supports reports whether a preview can be rendered of the file named name
+func (r *Renderer) Supports(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return imageExtensions[ext] || (ext == ".pdf" && len(r.opts.PDFCommand) > 0)
+}
+
+// Render reads content, the file named name, and returns a JPEG preview that
+// fits within width by height pixels, keeping its aspect ratio. Images
+// smaller than that are not enlarged.
+func (r *Renderer) Render(ctx context.Context, name string, content io.Reader, width, height int) ([]byte, error) {
+	if !r.Supports(name) {
+		return nil, ErrUnsupported
+	}
+	data, err := io.ReadAll(io.LimitReader(content, r.opts.MaxSourceBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if int64(len(data)) > r.opts.MaxSourceBytes {
+		return nil, ErrTooLarge
+	}
+	if strings.EqualFold(filepath.Ext(name), ".pdf") {
+		if data, err = r.renderPDF(ctx, data); err != nil {
+			return nil, err
+		}
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	if int64(config.Width)*int64(config.Height) > r.opts.MaxPixels {
+		return nil, ErrTooLarge
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, thumbnail(src, width, height), &jpeg.Options{Quality: r.opts.Quality}); err != nil {
+		return nil, fmt.Errorf("failed to encode preview: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// renderPDF renders the first page of pdf with the configured command
+func (r *Renderer) renderPDF(ctx context.Context, pdf []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.opts.PDFTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, r.opts.PDFCommand[0], r.opts.PDFCommand[1:]...)
+	cmd.Stdin = bytes.NewReader(pdf)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > 512 {
+			msg = msg[:512]
+		}
+		return nil, fmt.Errorf("failed to render PDF page: %w: %s", err, msg)
+	}
+	return stdout.Bytes(), nil
+}
+
+// thumbnail scales src down to fit within width by height, averaging the
+// source pixels each preview pixel covers, over a white background
+func thumbnail(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	dw, dh := sw, sh
+	if dw > width {
+		dw, dh = width, max(1, sh*width/sw)
+	}
+	if dh > height {
+		dw, dh = max(1, sw*height/sh), height
+	}
+
+	// Flatten onto white first; JPEG has no transparency
+	flat := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), src, bounds.Min, draw.Over)
+	if dw == sw && dh == sh {
+		return flat
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+			var r, g, b, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := flat.Pix[sy*flat.Stride+x0*4 : sy*flat.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					r += uint64(row[i])
+					g += uint64(row[i+1])
+					b += uint64(row[i+2])
+					n++
+				}
+			}
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+	return dst
+}
@@ -0,0 +1,128 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamdChunkSize is the largest chunk of content sent per INSTREAM frame
+const clamdChunkSize = 64 * 1024
+
+// clamdScanner streams content to clamd with the INSTREAM command
+type clamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// newClamdScanner returns a scanner for the clamd at address: a Unix socket
+// as "unix:///run/clamav/clamd.ctl" or a path, or a TCP address as
+// "tcp://host:3310" or "host:3310"
+func newClamdScanner(address string, timeout time.Duration) (*clamdScanner, error) {
+	s := &clamdScanner{timeout: timeout}
+	switch {
+	case strings.HasPrefix(address, "unix://"):
+		s.network, s.address = "unix", strings.TrimPrefix(address, "unix://")
+	case strings.HasPrefix(address, "tcp://"):
+		s.network, s.address = "tcp", strings.TrimPrefix(address, "tcp://")
+	case strings.HasPrefix(address, "/"):
+		s.network, s.address = "unix", address
+	default:
+		s.network, s.address = "tcp", address
+	}
+	if s.address == "" {
+		return nil, fmt.Errorf("clamd address is required")
+	}
+	return s, nil
+}
+
+func (s *clamdScanner) Name() string {
+	return "clamd"
+}
+
+func (s *clamdScanner) Scan(ctx context.Context, content io.Reader) (Verdict, error) {
+	dialer := net.Dialer{Timeout: s.timeout}
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	sendErr := s.send(conn, content)
+	var readErr contentReadError
+	if errors.As(sendErr, &readErr) {
+		// The stream was cut short; clamd would wait for the rest of it
+		return Verdict{}, readErr.err
+	}
+	// clamd answers, then closes, when it rejects the stream, such as one
+	// over its StreamMaxLength; its reply explains the failed send
+	_ = conn.SetReadDeadline(time.Now().Add(s.timeout))
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		if sendErr != nil {
+			return Verdict{}, sendErr
+		}
+		return Verdict{}, fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// send writes content as INSTREAM frames followed by the end frame
+func (s *clamdScanner) send(conn net.Conn, content io.Reader) error {
+	write := func(b []byte) error {
+		_ = conn.SetWriteDeadline(time.Now().Add(s.timeout))
+		_, err := conn.Write(b)
+		return err
+	}
+	if err := write([]byte("zINSTREAM\x00")); err != nil {
+		return fmt.Errorf("failed to send to clamd: %w", err)
+	}
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, err := content.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if werr := write(buf[:4+n]); werr != nil {
+				return fmt.Errorf("failed to send to clamd: %w", werr)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return contentReadError{err}
+		}
+	}
+	if err := write([]byte{0, 0, 0, 0}); err != nil {
+		return fmt.Errorf("failed to send to clamd: %w", err)
+	}
+	return nil
+}
+
+// contentReadError is a failure to read the content being scanned, rather
+// than to send it
+type contentReadError struct{ err error }
+
+func (e contentReadError) Error() string { return e.err.Error() }
+
+// parseClamdReply reads a reply such as "stream: OK" or
+// "stream: Eicar-Test-Signature FOUND"
+func parseClamdReply(reply string) (Verdict, error) {
+	result := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case result == "OK":
+		return Verdict{}, nil
+	case strings.HasSuffix(result, " FOUND"):
+		return Verdict{Infected: true, Signature: strings.TrimSuffix(result, " FOUND")}, nil
+	}
+	return Verdict{}, fmt.Errorf("clamd: %s", strings.TrimSuffix(result, " ERROR"))
+}
@@ -0,0 +1,140 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// icapDefaultPort is the port of ICAP servers whose address names none
+const icapDefaultPort = "1344"
+
+// icapResponseHeader is the HTTP response the scanned content is sent in
+const icapResponseHeader = "HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\n\r\n"
+
+// icapInfectionHeaders report malware in an ICAP response, by vendor
+var icapInfectionHeaders = []string{"X-Infection-Found", "X-Violations-Found", "X-Virus-Id"}
+
+// icapScanner sends content to an ICAP service as a RESPMOD request
+type icapScanner struct {
+	url     *url.URL
+	timeout time.Duration
+}
+
+// newICAPScanner returns a scanner for the service at address, such as
+// "icap://av.example.com:1344/avscan"
+func newICAPScanner(address string, timeout time.Duration) (*icapScanner, error) {
+	u, err := url.Parse(address)
+	if err != nil || u.Scheme != "icap" || u.Hostname() == "" {
+		return nil, fmt.Errorf("ICAP address must be an icap:// URL, got %q", address)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), icapDefaultPort)
+	}
+	return &icapScanner{url: u, timeout: timeout}, nil
+}
+
+func (s *icapScanner) Name() string {
+	return "icap"
+}
+
+func (s *icapScanner) Scan(ctx context.Context, content io.Reader) (Verdict, error) {
+	dialer := net.Dialer{Timeout: s.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.url.Host)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("failed to connect to ICAP server: %w", err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := s.send(conn, content); err != nil {
+		return Verdict{}, err
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(s.timeout))
+	reader := textproto.NewReader(bufio.NewReader(conn))
+	statusLine, err := reader.ReadLine()
+	if err != nil {
+		return Verdict{}, fmt.Errorf("failed to read ICAP response: %w", err)
+	}
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		return Verdict{}, fmt.Errorf("failed to read ICAP response: %w", err)
+	}
+	return parseICAPResponse(statusLine, header)
+}
+
+// send writes the RESPMOD request with content as its chunked body
+func (s *icapScanner) send(conn net.Conn, content io.Reader) error {
+	write := func(b []byte) error {
+		_ = conn.SetWriteDeadline(time.Now().Add(s.timeout))
+		if _, err := conn.Write(b); err != nil {
+			return fmt.Errorf("failed to send to ICAP server: %w", err)
+		}
+		return nil
+	}
+	request := fmt.Sprintf("RESPMOD %s ICAP/1.0\r\nHost: %s\r\nAllow: 204\r\nEncapsulated: res-hdr=0, res-body=%d\r\n\r\n%s",
+		s.url.String(), s.url.Host, len(icapResponseHeader), icapResponseHeader)
+	if err := write([]byte(request)); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := content.Read(buf)
+		if n > 0 {
+			chunk := append([]byte(strconv.FormatInt(int64(n), 16)+"\r\n"), buf[:n]...)
+			if werr := write(append(chunk, '\r', '\n')); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return write([]byte("0\r\n\r\n"))
+}
+
+// parseICAPResponse reads the verdict of a RESPMOD response: 204 leaves the
+// content unchanged, and a 200 carrying an infection header reports malware
+func parseICAPResponse(statusLine string, header textproto.MIMEHeader) (Verdict, error) {
+	proto, rest, _ := strings.Cut(statusLine, " ")
+	code, _, _ := strings.Cut(rest, " ")
+	if !strings.HasPrefix(proto, "ICAP/") {
+		return Verdict{}, fmt.Errorf("unexpected ICAP response %q", statusLine)
+	}
+	switch code {
+	case "204":
+		return Verdict{}, nil
+	case "200":
+		for _, name := range icapInfectionHeaders {
+			if value := header.Get(name); value != "" {
+				return Verdict{Infected: true, Signature: icapThreat(value)}, nil
+			}
+		}
+		return Verdict{}, nil
+	}
+	return Verdict{}, fmt.Errorf("ICAP server answered %q", statusLine)
+}
+
+// icapThreat extracts the threat name of an infection header such as
+// "Type=0; Resolution=2; Threat=EICAR;", or returns the header as is
+func icapThreat(value string) string {
+	for _, field := range strings.Split(value, ";") {
+		if name, threat, ok := strings.Cut(strings.TrimSpace(field), "="); ok && strings.EqualFold(name, "Threat") {
+			return strings.TrimSpace(threat)
+		}
+	}
+	return strings.TrimSpace(value)
+}
@@ -0,0 +1,54 @@
+// Package scan checks uploaded content for malware with an external scanner:
+// a ClamAV daemon or an ICAP server.
+package scan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrContentInfected is returned for content a scanner found malware in
+var ErrContentInfected = errors.New("content is infected")
+
+// ErrScanFailed is returned for content that could not be scanned
+var ErrScanFailed = errors.New("content could not be scanned")
+
+// DefaultTimeout bounds each exchange with a scanner, unless configured
+// otherwise
+const DefaultTimeout = 30 * time.Second
+
+// Verdict is the outcome of a scan
+type Verdict struct {
+	Infected  bool
+	Signature string // What was found, when infected
+}
+
+// Scanner checks content for malware
+type Scanner interface {
+	// Scan reads content to its end, streaming it to the scanner, and returns
+	// the verdict. Content the scanner could not check returns an error
+	// rather than a clean verdict.
+	Scan(ctx context.Context, content io.Reader) (Verdict, error)
+
+	// Name identifies the scanner in metrics and logs
+	Name() string
+}
+
+// New returns the scanner of kind "clamd" or "icap" at address. timeout
+// bounds connecting, each write of content and the wait for the verdict, so
+// a slow upload is not cut short as long as it keeps moving.
+func New(kind, address string, timeout time.Duration) (Scanner, error) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	switch kind {
+	case "clamd":
+		return newClamdScanner(address, timeout)
+	case "icap":
+		return newICAPScanner(address, timeout)
+	}
+	return nil, fmt.Errorf("unsupported scanner type %q", kind)
+}
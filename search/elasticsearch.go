@@ -0,0 +1,241 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// errorBodyLimit is how much of a failed response body explains the failure
+const errorBodyLimit = 1024
+
+// indexMapping keeps paths whole, for prefix filters, and analyzes names and
+// content as text
+const indexMapping = `{
+  "mappings": {
+    "properties": {
+      "path":         {"type": "keyword", "fields": {"text": {"type": "text"}}},
+      "name":         {"type": "text", "fields": {"keyword": {"type": "keyword"}}},
+      "size":         {"type": "long"},
+      "mode":         {"type": "keyword"},
+      "uid":          {"type": "integer"},
+      "gid":          {"type": "integer"},
+      "mtime":        {"type": "date"},
+      "backend_type": {"type": "keyword"},
+      "checksum":     {"type": "keyword"},
+      "content":      {"type": "text"}
+    }
+  }
+}`
+
+// elasticsearch is an Index kept in an Elasticsearch or OpenSearch cluster
+// through its REST API
+type elasticsearch struct {
+	base     string // URL of the index
+	username string
+	password string
+	client   *http.Client
+}
+
+func newElasticsearch(opts Options) (*elasticsearch, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("search index needs an http:// or https:// URL, got %q", opts.URL)
+	}
+	if opts.Index == "" || strings.ContainsAny(opts.Index, "/ ") {
+		return nil, fmt.Errorf("invalid search index name %q", opts.Index)
+	}
+	return &elasticsearch{
+		base:     strings.TrimSuffix(opts.URL, "/") + "/" + url.PathEscape(opts.Index),
+		username: opts.Username,
+		password: opts.Password,
+		client:   &http.Client{Timeout: opts.Timeout},
+	}, nil
+}
+
+// documentID names the document of path. Paths may be longer than the 512
+// bytes IDs are limited to.
+func documentID(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(sum[:])
+}
+
+func (es *elasticsearch) Ping(ctx context.Context) error {
+	resp, err := es.do(ctx, http.MethodHead, "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("search index answered %s", resp.Status)
+	}
+
+	resp, err = es.do(ctx, http.MethodPut, "", strings.NewReader(indexMapping))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, errorBodyLimit))
+	// Another instance may have created it meanwhile
+	if resp.StatusCode >= 300 && !strings.Contains(string(body), "resource_already_exists_exception") {
+		return fmt.Errorf("failed to create search index: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (es *elasticsearch) Put(ctx context.Context, doc Document) error {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return es.expect(ctx, http.MethodPut, "/_doc/"+documentID(doc.Path), body)
+}
+
+func (es *elasticsearch) Delete(ctx context.Context, path string) error {
+	query := map[string]any{"query": pathQuery(path)}
+	body, err := json.Marshal(query)
+	if err != nil {
+		return err
+	}
+	return es.expect(ctx, http.MethodPost, "/_delete_by_query?conflicts=proceed&refresh=false", body)
+}
+
+func (es *elasticsearch) Search(ctx context.Context, q Query) (Result, error) {
+	if strings.TrimSpace(q.Text) == "" {
+		return Result{}, fmt.Errorf("%w: the query text is empty", ErrInvalidQuery)
+	}
+	request := map[string]any{
+		"from": q.Offset,
+		"size": q.Limit,
+		"query": map[string]any{
+			"bool": map[string]any{
+				"must": map[string]any{
+					"multi_match": map[string]any{
+						"query":  q.Text,
+						"fields": []string{"name^3", "path.text^2", "content"},
+					},
+				},
+				"filter": prefixFilter(q.Prefix),
+			},
+		},
+		"highlight": map[string]any{
+			"fields": map[string]any{"content": map[string]any{"number_of_fragments": 3}},
+		},
+		"_source": []string{"path", "name", "size", "mtime"},
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return Result{}, err
+	}
+	resp, err := es.do(ctx, http.MethodPost, "/_search", bytes.NewReader(body))
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusBadRequest {
+		return Result{}, fmt.Errorf("%w: %w", ErrInvalidQuery, responseError(resp))
+	}
+	if resp.StatusCode >= 300 {
+		return Result{}, responseError(resp)
+	}
+
+	var answer struct {
+		Hits struct {
+			Total struct {
+				Value int64 `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				Score     float64             `json:"_score"`
+				Source    Hit                 `json:"_source"`
+				Highlight map[string][]string `json:"highlight"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return Result{}, fmt.Errorf("failed to decode search response: %w", err)
+	}
+	result := Result{Total: answer.Hits.Total.Value, Hits: make([]Hit, 0, len(answer.Hits.Hits))}
+	for _, h := range answer.Hits.Hits {
+		hit := h.Source
+		hit.Score = h.Score
+		hit.Highlights = h.Highlight["content"]
+		result.Hits = append(result.Hits, hit)
+	}
+	return result, nil
+}
+
+// pathQuery matches the document of path and those below it
+func pathQuery(path string) map[string]any {
+	path = strings.TrimSuffix(path, "/")
+	if path == "" {
+		return map[string]any{"match_all": map[string]any{}}
+	}
+	return map[string]any{
+		"bool": map[string]any{
+			"should": []any{
+				map[string]any{"term": map[string]any{"path": path}},
+				map[string]any{"prefix": map[string]any{"path": path + "/"}},
+			},
+			"minimum_should_match": 1,
+		},
+	}
+}
+
+// prefixFilter restricts a search to the files below prefix
+func prefixFilter(prefix string) []any {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return []any{}
+	}
+	return []any{map[string]any{"prefix": map[string]any{"path": prefix + "/"}}}
+}
+
+// expect sends a request and fails unless it succeeded
+func (es *elasticsearch) expect(ctx context.Context, method, path string, body []byte) error {
+	resp, err := es.do(ctx, method, path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return responseError(resp)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (es *elasticsearch) do(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, es.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if es.username != "" {
+		req.SetBasicAuth(es.username, es.password)
+	}
+	resp, err := es.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	return resp, nil
+}
+
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, errorBodyLimit))
+	if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+		return fmt.Errorf("%w: answered %s: %s", ErrUnavailable, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return fmt.Errorf("search index answered %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
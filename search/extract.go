@@ -0,0 +1,117 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// textExtensions are the file types indexed as plain text, whatever their
+// content looks like
+var textExtensions = map[string]bool{
+	".txt": true, ".md": true, ".csv": true, ".tsv": true, ".log": true, ".json": true,
+	".xml": true, ".yaml": true, ".yml": true, ".toml": true, ".ini": true, ".conf": true,
+	".html": true, ".htm": true, ".rst": true, ".tex": true, ".go": true, ".py": true,
+	".js": true, ".ts": true, ".java": true, ".c": true, ".h": true, ".sql": true, ".sh": true,
+}
+
+// markupTag matches the tags of HTML and XML documents
+var markupTag = regexp.MustCompile(`<[^>]*>`)
+
+// ExtractorOptions configures an Extractor
+type ExtractorOptions struct {
+	MaxBytes   int64         // Text kept of each file, and bytes read of text files
+	PDFCommand []string      // Writes the text of a PDF on stdin to stdout; PDFs are not extracted when empty
+	PDFTimeout time.Duration // Bound on PDFCommand
+}
+
+// Extractor extracts the text of files for indexing
+type Extractor struct {
+	opts ExtractorOptions
+}
+
+// NewExtractor returns an extractor with opts
+func NewExtractor(opts ExtractorOptions) *Extractor {
+	return &Extractor{opts: opts}
+}
+
+// Extract returns the text of content, the file named name, cut to MaxBytes.
+// Files of the known text types are read as text, PDFs through PDFCommand,
+// and others when their content looks like text. Other content has no text
+// and returns "".
+func (x *Extractor) Extract(ctx context.Context, name string, content io.Reader) (string, error) {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == ".pdf" {
+		if len(x.opts.PDFCommand) == 0 {
+			return "", nil
+		}
+		return x.extractPDF(ctx, content)
+	}
+
+	// Files of other types are only read on when they start like text
+	content = io.LimitReader(content, x.opts.MaxBytes)
+	head := make([]byte, 512)
+	n, err := io.ReadFull(content, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	head = head[:n]
+	if !textExtensions[ext] && !strings.HasPrefix(http.DetectContentType(head), "text/") {
+		return "", nil
+	}
+	rest, err := io.ReadAll(content)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	data := append(head, rest...)
+	text := string(data)
+	if ext == ".html" || ext == ".htm" || ext == ".xml" {
+		text = markupTag.ReplaceAllString(text, " ")
+	}
+	return x.clean(text), nil
+}
+
+// extractPDF runs the configured command over a PDF
+func (x *Extractor) extractPDF(ctx context.Context, content io.Reader) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, x.opts.PDFTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, x.opts.PDFCommand[0], x.opts.PDFCommand[1:]...)
+	cmd.Stdin = content
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &limitedBuffer{buf: &stdout, limit: x.opts.MaxBytes}
+	cmd.Stderr = &limitedBuffer{buf: &stderr, limit: 512}
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to extract PDF text: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return x.clean(stdout.String()), nil
+}
+
+// clean cuts text to MaxBytes and replaces invalid UTF-8, including a
+// character cut in two
+func (x *Extractor) clean(text string) string {
+	if int64(len(text)) > x.opts.MaxBytes {
+		text = text[:x.opts.MaxBytes]
+	}
+	return strings.ToValidUTF8(text, "\uFFFD")
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards the
+// rest, so a command is never blocked on its output
+type limitedBuffer struct {
+	buf   *bytes.Buffer
+	limit int64
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - int64(b.buf.Len()); room > 0 {
+		b.buf.Write(p[:min(int64(len(p)), room)])
+	}
+	return len(p), nil
+}
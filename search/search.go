@@ -0,0 +1,97 @@
+// Package search indexes the metadata and text of stored files in an
+// Elasticsearch or OpenSearch cluster, and queries it.
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidQuery is returned for a search that cannot be run
+var ErrInvalidQuery = errors.New("invalid search query")
+
+// ErrUnavailable is returned when the search cluster cannot be reached or
+// fails the request
+var ErrUnavailable = errors.New("search index unavailable")
+
+// DefaultTimeout bounds each request to the search cluster, unless
+// configured otherwise
+const DefaultTimeout = 10 * time.Second
+
+// Document is what is indexed of a file
+type Document struct {
+	Path        string    `json:"path"`
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	Mode        string    `json:"mode"`
+	UID         int       `json:"uid"`
+	GID         int       `json:"gid"`
+	MTime       time.Time `json:"mtime"`
+	BackendType string    `json:"backend_type"`
+	Checksum    string    `json:"checksum,omitempty"`
+	Content     string    `json:"content,omitempty"` // Extracted text; empty for files of other types
+}
+
+// Query selects indexed files
+type Query struct {
+	Text   string // Matched against the name, path and content
+	Prefix string // Directory the files are below; all files when empty or "/"
+	Limit  int
+	Offset int
+}
+
+// Hit is a file matching a query
+type Hit struct {
+	Path       string    `json:"path"`
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	MTime      time.Time `json:"mtime"`
+	Score      float64   `json:"score"`
+	Highlights []string  `json:"highlights,omitempty"` // Passages of the content matching the query
+}
+
+// Result is the page of hits of a query
+type Result struct {
+	Total int64 `json:"total"` // Files matching the query, of which Hits are a page
+	Hits  []Hit `json:"hits"`
+}
+
+// Index stores documents and answers queries over them
+type Index interface {
+	// Put adds or replaces the document of its path
+	Put(ctx context.Context, doc Document) error
+
+	// Delete removes the document of path and of every file below it. A
+	// path without documents is not an error.
+	Delete(ctx context.Context, path string) error
+
+	// Search returns the documents matching q, best first
+	Search(ctx context.Context, q Query) (Result, error)
+
+	// Ping checks that the index is reachable, creating it if needed
+	Ping(ctx context.Context) error
+}
+
+// Options configures the connection to a search cluster
+type Options struct {
+	URL      string // Base URL of the cluster, such as http://localhost:9200
+	Index    string // Name of the index documents are kept in
+	Username string // Basic authentication; none when empty
+	Password string
+	Timeout  time.Duration // Bound on each request; DefaultTimeout when 0
+}
+
+// New returns the index of kind "elasticsearch" or "opensearch" described
+// by opts. Both speak the same API for what is used here.
+func New(kind string, opts Options) (Index, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	switch kind {
+	case "elasticsearch", "opensearch":
+		return newElasticsearch(opts)
+	}
+	return nil, fmt.Errorf("unsupported search index type %q", kind)
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"runtime/debug"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	callfsv1 "github.com/ebogdum/callfs/api/callfs/v1"
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/config"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/links"
+	"github.com/ebogdum/callfs/server/handlers"
+	authMiddleware "github.com/ebogdum/callfs/server/middleware"
+)
+
+// NewGRPCServer creates the gRPC API server, served over TLS with the
+// certificate and settings of the HTTPS listener. authFailures and
+// requestLimiter are those given to NewRouter.
+func NewGRPCServer(
+	engine *core.Engine,
+	authenticator auth.Authenticator,
+	authorizer auth.Authorizer,
+	linkManager *links.LinkManager,
+	serverConfig *config.ServerConfig,
+	backendConfig *config.BackendConfig,
+	authFailures *authMiddleware.AuthFailures,
+	requestLimiter *authMiddleware.RequestLimiter,
+	apiHost string,
+	logger *zap.Logger,
+) (*grpc.Server, error) {
+	certificate, err := tls.LoadX509KeyPair(serverConfig.CertFile, serverConfig.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load gRPC certificate: %w", err)
+	}
+	tlsConfig := serverConfig.TLS.ServerTLS()
+	tlsConfig.Certificates = []tls.Certificate{certificate}
+
+	// Failures and rates count together with those of the /v1 API
+	authUnary, authStream := authMiddleware.GRPCAuthInterceptors(authenticator, authFailures, requestLimiter, logger)
+	srv := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(tlsConfig)),
+		grpc.ChainUnaryInterceptor(grpcRecoverUnary(logger), authUnary),
+		grpc.ChainStreamInterceptor(grpcRecoverStream(logger), authStream),
+	)
+	callfsv1.RegisterCallFSServer(srv, handlers.NewGRPCService(engine, authorizer, linkManager, backendConfig, serverConfig, apiHost, logger))
+	return srv, nil
+}
+
+// grpcRecoverUnary answers a call whose handler panics with an internal
+// error, as middleware.Recoverer does requests
+func grpcRecoverUnary(logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				err = grpcPanic(logger, info.FullMethod, recovered)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// grpcRecoverStream is grpcRecoverUnary for streaming calls
+func grpcRecoverStream(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				err = grpcPanic(logger, info.FullMethod, recovered)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
+
+func grpcPanic(logger *zap.Logger, method string, recovered any) error {
+	logger.Error("gRPC handler panicked",
+		zap.String("method", method),
+		zap.Any("panic", recovered),
+		zap.ByteString("stack", debug.Stack()))
+	return status.Error(codes.Internal, "an internal error occurred")
+}
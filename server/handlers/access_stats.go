@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/server/middleware"
+)
+
+// V1GetAccessStats handles GET /v1/stats/files/{path}/access
+// @Summary Get file access statistics
+// @Description Returns how many times the file was downloaded, through the API and through single-use links, the bytes served and the time of the last download. Counts are written to the metadata store periodically by every instance; those of the instance answering are included before they are written, those of other instances after.
+// @Tags stats
+// @Produce json
+// @Security BearerAuth
+// @Param path path string true "File path"
+// @Success 200 {object} metadata.AccessStats "Access statistics"
+// @Failure 400 {object} ErrorResponse "Invalid path"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Not Found"
+// @Router /v1/stats/files/{path}/access [get]
+func V1GetAccessStats(engine *core.Engine, authorizer auth.Authorizer, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		param, ok := strings.CutSuffix(chi.URLParam(r, "*"), "/access")
+		if !ok {
+			SendErrorResponse(w, logger, &customError{message: "not found"}, http.StatusNotFound)
+			return
+		}
+		pathInfo := ParseFilePath(param)
+		if pathInfo.IsInvalid || pathInfo.IsDirectory {
+			SendErrorResponse(w, logger, &customError{message: "invalid path"}, http.StatusBadRequest)
+			return
+		}
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			SendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
+			return
+		}
+
+		if err := authorizer.Authorize(r.Context(), userID, pathInfo.FullPath, auth.ReadPerm); err != nil {
+			SendErrorResponse(w, logger, err, http.StatusForbidden)
+			return
+		}
+		if _, err := engine.GetMetadata(r.Context(), pathInfo.FullPath); err != nil {
+			SendErrorResponse(w, logger, err, http.StatusNotFound)
+			return
+		}
+
+		stats, err := engine.AccessStats(r.Context(), pathInfo.FullPath)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+		SendJSONResponse(w, stats)
+	}
+}
+
+// V1GetLinkStats handles GET /v1/stats/links
+// @Summary Get link consumption statistics
+// @Description Aggregates the written download statistics of the files below a directory: all downloads, those through single-use links and the bytes served, with the files most downloaded through links that the caller may read. Also counts the single-use links to those files not yet used or expired. Restricted to admin API keys, as it reads the statistics of every file below the directory.
+// @Tags stats
+// @Produce json
+// @Security BearerAuth
+// @Param path query string false "Directory to aggregate (default /)"
+// @Success 200 {object} core.LinkStats "Link statistics"
+// @Failure 400 {object} ErrorResponse "Invalid path"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Not Found"
+// @Router /v1/stats/links [get]
+func V1GetLinkStats(engine *core.Engine, authorizer auth.Authorizer, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		pathInfo := ParseFilePath(r.URL.Query().Get("path"))
+		if pathInfo.IsInvalid {
+			SendErrorResponse(w, logger, &customError{message: "invalid path"}, http.StatusBadRequest)
+			return
+		}
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			SendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
+			return
+		}
+		enginePath := pathInfo.FullPath
+		if enginePath != "/" {
+			enginePath = strings.TrimSuffix(enginePath, "/")
+		}
+
+		if err := authorizer.Authorize(r.Context(), userID, enginePath, auth.ReadPerm); err != nil {
+			SendErrorResponse(w, logger, err, http.StatusForbidden)
+			return
+		}
+		if _, err := engine.GetMetadata(r.Context(), enginePath); err != nil {
+			SendErrorResponse(w, logger, err, http.StatusNotFound)
+			return
+		}
+
+		stats, err := engine.LinkStats(r.Context(), enginePath, func(path string) bool {
+			return authorizer.Authorize(r.Context(), userID, path, auth.ReadPerm) == nil
+		})
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+		SendJSONResponse(w, stats)
+	}
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/server/middleware"
+)
+
+// V1AdminFlushS3Cache handles DELETE /v1/admin/cache/s3
+// @Summary Flush the S3 content cache
+// @Description Removes the files this instance's local S3 content cache holds, or only the file of one path. Other instances keep their own caches.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param path query string false "Path of a single file to drop (default: the whole cache)"
+// @Success 200 {object} core.CacheFlushResult "Files and bytes removed"
+// @Failure 400 {object} ErrorResponse "Invalid path"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 409 {object} ErrorResponse "No S3 cache is configured"
+// @Router /v1/admin/cache/s3 [delete]
+func V1AdminFlushS3Cache(engine *core.Engine, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		path := r.URL.Query().Get("path")
+		if path != "" {
+			pathInfo := ParseFilePath(path)
+			if pathInfo.IsInvalid || pathInfo.IsDirectory {
+				SendErrorResponse(w, logger, &customError{message: "path must be a file path"}, http.StatusBadRequest)
+				return
+			}
+			path = pathInfo.FullPath
+		}
+
+		result, err := engine.FlushS3Cache(r.Context(), path)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+
+		userID, _ := middleware.GetUserID(r.Context())
+		logger.Info("S3 cache flushed by admin",
+			zap.String("path", path),
+			zap.String("user_id", userID),
+			zap.Int("files", result.Files))
+		SendJSONResponse(w, result)
+	}
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/server/middleware"
+)
+
+// DrainStatus is the drain mode of an instance
+type DrainStatus struct {
+	InstanceID string `json:"instance_id"`
+	Draining   bool   `json:"draining"`
+}
+
+// V1AdminDrain handles POST /v1/admin/drain
+// @Summary Drain this instance
+// @Description Stops accepting uploads, deletes and moves, fails /readyz and tells peers the instance is unavailable, while requests in progress finish. A raft leader first hands leadership to another voter unless handoff_leadership=false.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param handoff_leadership query bool false "Hand off raft leadership (default true)"
+// @Success 200 {object} DrainStatus "Instance draining"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 500 {object} ErrorResponse "Leadership could not be handed off; the instance drains anyway"
+// @Router /v1/admin/drain [post]
+func V1AdminDrain(engine *core.Engine, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		userID, _ := middleware.GetUserID(r.Context())
+		logger.Warn("Drain requested", zap.String("user_id", userID))
+
+		handOff := r.URL.Query().Get("handoff_leadership") != "false"
+		if err := engine.Drain(r.Context(), handOff); err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+		SendJSONResponse(w, DrainStatus{InstanceID: engine.GetCurrentInstanceID(), Draining: true})
+	}
+}
+
+// V1AdminDrainStatus handles GET /v1/admin/drain
+// @Summary Drain mode of this instance
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} DrainStatus "Drain mode"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Router /v1/admin/drain [get]
+func V1AdminDrainStatus(engine *core.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		SendJSONResponse(w, DrainStatus{InstanceID: engine.GetCurrentInstanceID(), Draining: engine.Draining()})
+	}
+}
+
+// V1AdminUndrain handles DELETE /v1/admin/drain
+// @Summary Resume serving writes
+// @Description Takes the instance out of drain mode. Raft leadership handed off stays with the new leader.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} DrainStatus "Instance serving"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Router /v1/admin/drain [delete]
+func V1AdminUndrain(engine *core.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		engine.Undrain()
+		SendJSONResponse(w, DrainStatus{InstanceID: engine.GetCurrentInstanceID(), Draining: false})
+	}
+}
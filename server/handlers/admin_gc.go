@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/server/middleware"
+)
+
+// V1AdminCollectGarbage handles POST /v1/admin/gc
+// @Summary Collect orphaned backend objects
+// @Description Finds the files of this instance's local filesystem and of S3 that have no metadata, leftover temporary uploads and incomplete S3 multipart uploads older than the grace period. Only reports them unless dry_run=false, which removes them.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param dry_run query bool false "Report without removing (default true)"
+// @Param grace_period query string false "Minimum age of an orphan, as a Go duration (default from gc.grace_period)"
+// @Success 200 {object} core.GCReport "Orphans found"
+// @Failure 400 {object} ErrorResponse "Invalid grace period"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 409 {object} ErrorResponse "A collection is already running"
+// @Router /v1/admin/gc [post]
+func V1AdminCollectGarbage(engine *core.Engine, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		dryRun := r.URL.Query().Get("dry_run") != "false"
+		gracePeriod := engine.GCGracePeriod()
+		if value := r.URL.Query().Get("grace_period"); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed <= 0 {
+				SendErrorResponse(w, logger, &customError{message: "grace_period must be a positive duration"}, http.StatusBadRequest)
+				return
+			}
+			gracePeriod = parsed
+		}
+
+		userID, _ := middleware.GetUserID(r.Context())
+		if !dryRun {
+			logger.Warn("Garbage collection started",
+				zap.Duration("grace_period", gracePeriod),
+				zap.String("user_id", userID))
+		}
+
+		report, err := engine.CollectGarbage(r.Context(), gracePeriod, dryRun)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+		SendJSONResponse(w, report)
+	}
+}
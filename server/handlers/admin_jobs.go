@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/jobs"
+)
+
+// JobsResponse lists the background jobs of an instance
+type JobsResponse struct {
+	InstanceID string        `json:"instance_id"`
+	Leader     bool          `json:"leader"` // Whether this instance runs the leader-only jobs
+	Jobs       []jobs.Status `json:"jobs"`
+}
+
+// V1AdminListJobs handles GET /v1/admin/jobs
+// @Summary List background jobs
+// @Description Lists the background jobs scheduled on this instance with their schedules, next runs and the outcome of their last runs. Instances with server.role=api run none.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} JobsResponse "Scheduled jobs"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Router /v1/admin/jobs [get]
+func V1AdminListJobs(engine *core.Engine, scheduler *jobs.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		SendJSONResponse(w, JobsResponse{
+			InstanceID: engine.GetCurrentInstanceID(),
+			Leader:     scheduler.Leader(),
+			Jobs:       scheduler.Status(),
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/server/middleware"
+)
+
+// LifecycleRulesResponse represents the response for the lifecycle rules endpoint
+type LifecycleRulesResponse struct {
+	Count int                  `json:"count"`
+	Rules []core.LifecycleRule `json:"rules"`
+}
+
+// V1AdminLifecycleRules handles GET /v1/admin/lifecycle
+// @Summary List lifecycle rules
+// @Description Lists the configured lifecycle rules, in the order they are applied
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} LifecycleRulesResponse "Lifecycle rules"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Router /v1/admin/lifecycle [get]
+func V1AdminLifecycleRules(engine *core.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rules := engine.LifecycleRules()
+		SendJSONResponse(w, LifecycleRulesResponse{Count: len(rules), Rules: rules})
+	}
+}
+
+// V1AdminApplyLifecycle handles POST /v1/admin/lifecycle
+// @Summary Apply the lifecycle rules
+// @Description Applies the configured lifecycle rules once and reports the entries they match. Only reports them unless dry_run=false, which deletes or moves them.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param dry_run query bool false "Report without acting (default true)"
+// @Success 200 {object} core.LifecycleReport "Entries matched"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 409 {object} ErrorResponse "The rules are already being applied"
+// @Router /v1/admin/lifecycle [post]
+func V1AdminApplyLifecycle(engine *core.Engine, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		dryRun := r.URL.Query().Get("dry_run") != "false"
+		userID, _ := middleware.GetUserID(r.Context())
+		if !dryRun {
+			logger.Warn("Lifecycle rules applied on demand", zap.String("user_id", userID))
+		}
+
+		report, err := engine.ApplyLifecycle(r.Context(), dryRun)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+		SendJSONResponse(w, report)
+	}
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
+)
+
+// V1AdminMergedListing handles GET /v1/admin/listing/{path}
+// @Summary List a directory across instances and backends
+// @Description Lists a directory from the metadata store, the local filesystem of every instance and S3, and reports entries that disagree: untracked, missing, stray copies and size or type mismatches
+// @Tags admin
+// @Security BearerAuth
+// @Param path path string true "Directory path"
+// @Success 200 {object} core.MergedListing "Merged listing"
+// @Failure 400 {object} ErrorResponse "Not a directory"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Not Found"
+// @Router /v1/admin/listing/{path} [get]
+func V1AdminMergedListing(engine *core.Engine, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		pathInfo := ParseFilePath(chi.URLParam(r, "*"))
+		if pathInfo.IsInvalid {
+			SendErrorResponse(w, logger, &customError{message: "invalid path"}, http.StatusBadRequest)
+			return
+		}
+		dir := pathInfo.FullPath
+		if dir != "/" {
+			dir = strings.TrimSuffix(dir, "/")
+		}
+
+		listing, err := engine.ListDirectoryMerged(r.Context(), dir)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+		SendJSONResponse(w, listing)
+	}
+}
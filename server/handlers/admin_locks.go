@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/locks"
+	"github.com/ebogdum/callfs/server/middleware"
+)
+
+// LockListResponse represents the response for the lock listing endpoint
+type LockListResponse struct {
+	Count int              `json:"count"`
+	Locks []locks.LockInfo `json:"locks"`
+}
+
+// LockReleaseResponse represents the response for a forced lock release
+type LockReleaseResponse struct {
+	Key      string `json:"key"`
+	Released bool   `json:"released"`
+}
+
+// V1AdminListLocks handles GET /v1/admin/locks
+// @Summary List held locks
+// @Description Lists the distributed locks currently held, with owner and remaining TTL
+// @Tags admin
+// @Security BearerAuth
+// @Success 200 {object} LockListResponse "Held locks"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Router /v1/admin/locks [get]
+func V1AdminListLocks(lockManager locks.Manager, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+
+		held, err := lockManager.List(ctx, "")
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+		if held == nil {
+			held = []locks.LockInfo{}
+		}
+		SendJSONResponse(w, LockListResponse{Count: len(held), Locks: held})
+	}
+}
+
+// V1AdminReleaseLock handles DELETE /v1/admin/locks/{key}
+// @Summary Force-release a lock
+// @Description Deletes a lock regardless of its owner, e.g. one left by a crashed instance
+// @Tags admin
+// @Security BearerAuth
+// @Param key path string true "Lock key as returned by the listing, e.g. file:/data/a.bin"
+// @Success 200 {object} LockReleaseResponse "Lock released"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Lock not held"
+// @Router /v1/admin/locks/{key} [delete]
+func V1AdminReleaseLock(lockManager locks.Manager, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		key := chi.URLParam(r, "*")
+		if key == "" {
+			SendErrorResponse(w, logger, fmt.Errorf("missing lock key"), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+
+		released, err := lockManager.ForceRelease(ctx, key)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+		if !released {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			if err := json.NewEncoder(w).Encode(ErrorResponse{Code: CodeLockNotFound, Message: "lock is not held"}); err != nil {
+				logger.Error("Failed to encode error response", zap.Error(err))
+			}
+			return
+		}
+
+		userID, _ := middleware.GetUserID(r.Context())
+		logger.Warn("Lock force-released", zap.String("lock_key", key), zap.String("user_id", userID))
+		SendJSONResponse(w, LockReleaseResponse{Key: key, Released: true})
+	}
+}
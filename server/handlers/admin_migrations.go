@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/server/middleware"
+)
+
+// MigrationListResponse represents the response for the migration listing endpoint
+type MigrationListResponse struct {
+	Count      int                    `json:"count"`
+	Migrations []core.MigrationStatus `json:"migrations"`
+}
+
+// V1AdminStartMigration handles POST /v1/admin/migrations
+// @Summary Migrate an instance's files
+// @Description Moves every localfs file owned by source_instance to this instance ("local") or to S3, in the background. Running it again resumes an interrupted migration.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body core.MigrationRequest true "Source instance and target"
+// @Success 202 {object} core.MigrationStatus "Migration started"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 409 {object} ErrorResponse "A migration of the instance is already running"
+// @Router /v1/admin/migrations [post]
+func V1AdminStartMigration(engine *core.Engine, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		var req core.MigrationRequest
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1 MiB
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendErrorResponse(w, logger, fmt.Errorf("%w: %v", core.ErrInvalidMigration, err), http.StatusBadRequest)
+			return
+		}
+
+		status, err := engine.StartMigration(req)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+
+		userID, _ := middleware.GetUserID(r.Context())
+		logger.Warn("Migration started",
+			zap.String("migration_id", status.ID),
+			zap.String("source_instance", status.SourceInstance),
+			zap.String("target", status.Target),
+			zap.String("user_id", userID))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			logger.Error("Failed to encode migration status", zap.Error(err))
+		}
+	}
+}
+
+// V1AdminListMigrations handles GET /v1/admin/migrations
+// @Summary List migrations
+// @Description Lists the migrations started on this instance, newest first
+// @Tags admin
+// @Security BearerAuth
+// @Success 200 {object} MigrationListResponse "Migrations"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Router /v1/admin/migrations [get]
+func V1AdminListMigrations(engine *core.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		migrations := engine.Migrations()
+		SendJSONResponse(w, MigrationListResponse{Count: len(migrations), Migrations: migrations})
+	}
+}
+
+// V1AdminGetMigration handles GET /v1/admin/migrations/{id}
+// @Summary Get migration progress
+// @Tags admin
+// @Security BearerAuth
+// @Param id path string true "Migration ID"
+// @Success 200 {object} core.MigrationStatus "Migration progress"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Migration not found"
+// @Router /v1/admin/migrations/{id} [get]
+func V1AdminGetMigration(engine *core.Engine, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, err := engine.Migration(chi.URLParam(r, "id"))
+		if err != nil {
+			SendErrorResponse(w, log.WithContext(r.Context(), logger), err, http.StatusInternalServerError)
+			return
+		}
+		SendJSONResponse(w, status)
+	}
+}
+
+// V1AdminCancelMigration handles DELETE /v1/admin/migrations/{id}
+// @Summary Cancel a migration
+// @Description Stops a running migration after the entry in progress; entries already moved stay moved
+// @Tags admin
+// @Security BearerAuth
+// @Param id path string true "Migration ID"
+// @Success 200 {object} core.MigrationStatus "Migration status"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Migration not found"
+// @Router /v1/admin/migrations/{id} [delete]
+func V1AdminCancelMigration(engine *core.Engine, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		status, err := engine.CancelMigration(chi.URLParam(r, "id"))
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+		userID, _ := middleware.GetUserID(r.Context())
+		logger.Warn("Migration cancel requested", zap.String("migration_id", status.ID), zap.String("user_id", userID))
+		SendJSONResponse(w, status)
+	}
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/ebogdum/callfs/backends/internalproxy"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/discovery"
+)
+
+// PeerListResponse represents the response for the peer status endpoint
+type PeerListResponse struct {
+	InstanceID string                     `json:"instance_id"`
+	Count      int                        `json:"count"`
+	Peers      []internalproxy.PeerStatus `json:"peers"`
+}
+
+// MemberListResponse represents the response for the gossip membership endpoint
+type MemberListResponse struct {
+	InstanceID string             `json:"instance_id"`
+	Count      int                `json:"count"`
+	Members    []discovery.Member `json:"members"`
+}
+
+// V1AdminListPeers handles GET /v1/admin/peers
+// @Summary List peer instance health
+// @Description Reports each peer's circuit state and recent failures as seen by this instance
+// @Tags admin
+// @Security BearerAuth
+// @Success 200 {object} PeerListResponse "Peer health"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Router /v1/admin/peers [get]
+func V1AdminListPeers(engine *core.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		peers := engine.PeerStatuses()
+		if peers == nil {
+			peers = []internalproxy.PeerStatus{}
+		}
+		SendJSONResponse(w, PeerListResponse{
+			InstanceID: engine.GetCurrentInstanceID(),
+			Count:      len(peers),
+			Peers:      peers,
+		})
+	}
+}
+
+// V1AdminListMembers handles GET /v1/admin/members
+// @Summary List gossip cluster members
+// @Description Lists the instances known through gossip, with the endpoint each advertises and its liveness. Empty unless gossip discovery is enabled.
+// @Tags admin
+// @Security BearerAuth
+// @Success 200 {object} MemberListResponse "Cluster members"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Router /v1/admin/members [get]
+func V1AdminListMembers(engine *core.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		members := engine.Members()
+		if members == nil {
+			members = []discovery.Member{}
+		}
+		SendJSONResponse(w, MemberListResponse{
+			InstanceID: engine.GetCurrentInstanceID(),
+			Count:      len(members),
+			Members:    members,
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/server/middleware"
+)
+
+// ReadOnlyStatus is the read-only mode of an instance
+type ReadOnlyStatus struct {
+	InstanceID string `json:"instance_id"`
+	core.ReadOnlyMode
+}
+
+// V1AdminReadOnlyStatus handles GET /v1/admin/read-only
+// @Summary Read-only mode of this instance
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} ReadOnlyStatus "Read-only mode"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Router /v1/admin/read-only [get]
+func V1AdminReadOnlyStatus(engine *core.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		SendJSONResponse(w, ReadOnlyStatus{InstanceID: engine.GetCurrentInstanceID(), ReadOnlyMode: engine.ReadOnlyMode()})
+	}
+}
+
+// V1AdminSetReadOnly handles PUT /v1/admin/read-only
+// @Summary Put this instance, or path prefixes, in read-only mode
+// @Description Replaces the read-only mode until the next change or restart. Uploads, deletes, moves and attribute changes of the paths covered are refused with 403 READ_ONLY; reads are served.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body core.ReadOnlyMode true "Whole instance, or absolute path prefixes"
+// @Success 200 {object} ReadOnlyStatus "Read-only mode set"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Router /v1/admin/read-only [put]
+func V1AdminSetReadOnly(engine *core.Engine, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		var mode core.ReadOnlyMode
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1 MiB
+		if err := json.NewDecoder(r.Body).Decode(&mode); err != nil {
+			SendErrorResponse(w, logger, fmt.Errorf("invalid read-only mode: %w", err), http.StatusBadRequest)
+			return
+		}
+		for _, prefix := range mode.Prefixes {
+			if !strings.HasPrefix(prefix, "/") {
+				SendErrorResponse(w, logger, fmt.Errorf("invalid read-only prefix %q: must be an absolute path", prefix), http.StatusBadRequest)
+				return
+			}
+		}
+
+		userID, _ := middleware.GetUserID(r.Context())
+		logger.Warn("Read-only mode changed",
+			zap.Bool("instance", mode.Instance),
+			zap.Strings("prefixes", mode.Prefixes),
+			zap.String("user_id", userID))
+
+		engine.SetReadOnly(mode)
+		SendJSONResponse(w, ReadOnlyStatus{InstanceID: engine.GetCurrentInstanceID(), ReadOnlyMode: engine.ReadOnlyMode()})
+	}
+}
+
+// V1AdminClearReadOnly handles DELETE /v1/admin/read-only
+// @Summary Resume accepting changes
+// @Description Takes the instance and every path prefix out of read-only mode, including those set in the configuration, until the next restart
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} ReadOnlyStatus "Instance writable"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Router /v1/admin/read-only [delete]
+func V1AdminClearReadOnly(engine *core.Engine, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		userID, _ := middleware.GetUserID(r.Context())
+		logger.Warn("Read-only mode cleared", zap.String("user_id", userID))
+
+		engine.SetReadOnly(core.ReadOnlyMode{})
+		SendJSONResponse(w, ReadOnlyStatus{InstanceID: engine.GetCurrentInstanceID(), ReadOnlyMode: engine.ReadOnlyMode()})
+	}
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/locks"
+	"github.com/ebogdum/callfs/server/middleware"
+)
+
+// LockTokenHeader carries the ID of an advisory lock to refresh or release
+const LockTokenHeader = "X-CallFS-Lock-Token"
+
+// lockFileRequest is the body of a LOCK request
+type lockFileRequest struct {
+	core.AdvisoryLockRequest
+	// Owner distinguishes lock holders sharing an API key, like a process ID
+	Owner string `json:"owner"`
+}
+
+// V1LockFile handles LOCK /files/{path} requests. Without a lock token it
+// takes an advisory read or write lock on the file or a byte range of it;
+// with one it refreshes that lock's lease. The lock is returned as JSON and
+// its ID in the X-CallFS-Lock-Token header. A conflicting lock is answered
+// with 423 Locked. OpenAPI has no LOCK method, so the endpoint is only
+// described in the API reference.
+func V1LockFile(engine *core.Engine, authorizer auth.Authorizer, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		path, userID, ok := advisoryLockTarget(w, r, logger)
+		if !ok {
+			return
+		}
+
+		var req lockFileRequest
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1 MiB
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			SendErrorResponse(w, logger, &customError{message: "invalid lock request"}, http.StatusBadRequest)
+			return
+		}
+
+		var lock *locks.AdvisoryLock
+		var err error
+		if token := r.Header.Get(LockTokenHeader); token != "" {
+			// A refresh needs the access of the lock's own mode
+			if !authorizeAdvisoryLock(w, r, engine, authorizer, logger, userID, path, token) {
+				return
+			}
+			lock, err = engine.RefreshRangeLock(r.Context(), path, token, req.TTLSeconds)
+		} else {
+			if err := authorizer.Authorize(r.Context(), userID, path, advisoryLockPerm(req.Mode)); err != nil {
+				SendErrorResponse(w, logger, err, http.StatusForbidden)
+				return
+			}
+			owner := userID
+			if req.Owner != "" {
+				owner = userID + "/" + req.Owner
+			}
+			lock, err = engine.LockRange(r.Context(), path, owner, req.AdvisoryLockRequest)
+		}
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set(LockTokenHeader, lock.ID)
+		SendJSONResponse(w, lock)
+	}
+}
+
+// V1UnlockFile handles UNLOCK /files/{path} requests, releasing the advisory
+// lock named by the X-CallFS-Lock-Token header
+func V1UnlockFile(engine *core.Engine, authorizer auth.Authorizer, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		path, userID, ok := advisoryLockTarget(w, r, logger)
+		if !ok {
+			return
+		}
+		token := r.Header.Get(LockTokenHeader)
+		if token == "" {
+			SendErrorResponse(w, logger, &customError{message: "missing " + LockTokenHeader + " header"}, http.StatusBadRequest)
+			return
+		}
+		if !authorizeAdvisoryLock(w, r, engine, authorizer, logger, userID, path, token) {
+			return
+		}
+
+		if err := engine.UnlockRange(r.Context(), path, token); err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// advisoryLockPerm is the access a lock of mode needs: reading a file needs
+// read access and writing it write access, as with open(2) before fcntl
+func advisoryLockPerm(mode string) auth.PermissionType {
+	if mode == locks.AdvisoryWrite {
+		return auth.WritePerm
+	}
+	return auth.ReadPerm
+}
+
+// authorizeAdvisoryLock checks that userID has the access the held lock id
+// on path needs, answering the request itself when it does not
+func authorizeAdvisoryLock(w http.ResponseWriter, r *http.Request, engine *core.Engine, authorizer auth.Authorizer, logger *zap.Logger, userID, path, id string) bool {
+	held, err := engine.RangeLock(r.Context(), path, id)
+	if err != nil {
+		SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+		return false
+	}
+	if err := authorizer.Authorize(r.Context(), userID, path, advisoryLockPerm(held.Mode)); err != nil {
+		SendErrorResponse(w, logger, err, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// advisoryLockTarget returns the path and caller of a LOCK or UNLOCK request,
+// answering the request itself when either is missing
+func advisoryLockTarget(w http.ResponseWriter, r *http.Request, logger *zap.Logger) (string, string, bool) {
+	pathInfo := ParseFilePath(chi.URLParam(r, "*"))
+	if pathInfo.IsInvalid {
+		SendErrorResponse(w, logger, &customError{message: "invalid path"}, http.StatusBadRequest)
+		return "", "", false
+	}
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		SendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
+		return "", "", false
+	}
+
+	path := pathInfo.FullPath
+	if pathInfo.IsDirectory && path != "/" {
+		path = strings.TrimSuffix(path, "/")
+	}
+	return path, userID, true
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/ebogdum/callfs/core"
+)
+
+const (
+	// checksumHeader carries "<algorithm>=<digest>", either as a request header
+	// or as a trailer announced via "Trailer: X-CallFS-Checksum".
+	checksumHeader = "X-Callfs-Checksum"
+
+	amzChecksumPrefix = "X-Amz-Checksum-"
+	amzTrailerHeader  = "X-Amz-Trailer"
+)
+
+// amzChecksumPreference is the order in which AWS-style checksum headers are
+// used when a request carries several, strongest first
+var amzChecksumPreference = []string{"Sha256", "Sha1", "Crc32c", "Crc32"}
+
+// wrapChecksumBody returns body wrapped in a core.ChecksumReader when the
+// client supplied an upload checksum, or body unchanged otherwise. Supported
+// forms are:
+//
+//	X-CallFS-Checksum: sha256=<hex or base64>        (header or trailer)
+//	x-amz-checksum-<algorithm>: <base64>            (header)
+//	x-amz-trailer: x-amz-checksum-<algorithm>       (digest sent as trailer)
+func wrapChecksumBody(r *http.Request, body io.Reader) (io.Reader, error) {
+	// Custom CallFS header sent up front
+	if value := r.Header.Get(checksumHeader); value != "" {
+		algorithm, digest, ok := strings.Cut(value, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s must be in the form <algorithm>=<digest>", checksumHeader)
+		}
+		return core.NewChecksumReader(body, algorithm, func() string { return digest })
+	}
+
+	// Custom CallFS header announced as a trailer
+	if _, declared := r.Trailer[checksumHeader]; declared {
+		// The hash must be chosen before the trailer arrives, so default to
+		// sha256 and let an explicit algorithm header override it
+		algorithm := r.Header.Get("X-Callfs-Checksum-Algorithm")
+		if algorithm == "" {
+			algorithm = "sha256"
+		}
+		return core.NewChecksumReader(body, algorithm, func() string {
+			value := r.Trailer.Get(checksumHeader)
+			if alg, digest, ok := strings.Cut(value, "="); ok && strings.EqualFold(alg, algorithm) {
+				return digest
+			}
+			return value
+		})
+	}
+
+	// AWS-style trailing checksum
+	if trailer := http.CanonicalHeaderKey(strings.TrimSpace(r.Header.Get(amzTrailerHeader))); strings.HasPrefix(trailer, amzChecksumPrefix) {
+		algorithm := strings.TrimPrefix(trailer, amzChecksumPrefix)
+		return core.NewChecksumReader(body, algorithm, func() string { return r.Trailer.Get(trailer) })
+	}
+
+	// AWS-style checksum header, the same one whatever order the headers
+	// came in
+	for _, algorithm := range amzChecksumPreference {
+		if digest := r.Header.Get(amzChecksumPrefix + algorithm); digest != "" {
+			return core.NewChecksumReader(body, algorithm, func() string { return digest })
+		}
+	}
+	// Any other algorithm is refused, the first by name
+	var names []string
+	for name, values := range r.Header {
+		if strings.HasPrefix(name, amzChecksumPrefix) && name != "X-Amz-Checksum-Algorithm" && len(values) > 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		name := slices.Min(names)
+		digest := r.Header.Get(name)
+		return core.NewChecksumReader(body, strings.TrimPrefix(name, amzChecksumPrefix), func() string { return digest })
+	}
+
+	return body, nil
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ebogdum/callfs/core"
+)
+
+func TestWrapChecksumBodyPrefersStrongest(t *testing.T) {
+	for range 20 {
+		r := httptest.NewRequest("PUT", "/v1/files/a", strings.NewReader("x"))
+		r.Header.Set("X-Amz-Checksum-Crc32", "AAAAAA==")
+		r.Header.Set("X-Amz-Checksum-Sha1", "c2hhMQ==")
+		r.Header.Set("X-Amz-Checksum-Sha256", "c2hhMjU2")
+		body, err := wrapChecksumBody(r, r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := body.(*core.ChecksumReader).Announced(); got != "sha256=c2hhMjU2" {
+			t.Fatalf("checksum %q, want the sha256 header", got)
+		}
+	}
+
+	r := httptest.NewRequest("PUT", "/v1/files/a", strings.NewReader("x"))
+	r.Header.Set("X-Amz-Checksum-Crc64nvme", "AAAAAAAAAAA=")
+	if _, err := wrapChecksumBody(r, r.Body); err == nil {
+		t.Error("unsupported checksum algorithm accepted")
+	}
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// createModeHeader selects what POST and PUT do when the path already exists
+const createModeHeader = "X-CallFS-Create-Mode"
+
+// Create modes, after the open(2) flags they mirror
+const (
+	createExclusive    = "exclusive"     // O_CREAT|O_EXCL: fail if the path exists
+	createOverwrite    = "overwrite"     // O_CREAT|O_TRUNC: replace an existing file
+	createIgnoreExists = "ignore-exists" // O_CREAT: leave an existing path untouched
+)
+
+// ErrInvalidCreateMode is returned for an unknown X-CallFS-Create-Mode value
+var ErrInvalidCreateMode = errors.New("invalid create mode")
+
+// parseCreateMode returns the create mode requested by r, or defaultMode when
+// the header is absent
+func parseCreateMode(r *http.Request, defaultMode string) (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(r.Header.Get(createModeHeader)))
+	switch mode {
+	case "":
+		return defaultMode, nil
+	case createExclusive, createOverwrite, createIgnoreExists:
+		return mode, nil
+	}
+	return "", fmt.Errorf("%w: %s must be %s, %s or %s", ErrInvalidCreateMode,
+		createModeHeader, createExclusive, createOverwrite, createIgnoreExists)
+}
@@ -1,15 +1,22 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 
 	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/backends/internalproxy"
 	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
 	"github.com/ebogdum/callfs/metadata"
 	"github.com/ebogdum/callfs/server/middleware"
 )
@@ -20,15 +27,19 @@ import (
 // @Tags files
 // @Security BearerAuth
 // @Param path path string true "File or directory path"
+// @Param If-Unmodified-Since header string false "HTTP date; 412 when the entry was modified after it"
 // @Success 204 "No Content"
 // @Failure 401 {object} ErrorResponse "Unauthorized"
 // @Failure 403 {object} ErrorResponse "Forbidden"
 // @Failure 404 {object} ErrorResponse "Not Found"
+// @Failure 412 {object} ErrorResponse "Precondition Failed"
 // @Failure 500 {object} ErrorResponse "Internal Server Error"
 // @Failure 502 {object} ErrorResponse "Bad Gateway (cross-server proxy error)"
 // @Router /v1/files/{path} [delete]
 func V1DeleteFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, logger *zap.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
 		// Extract and parse path from URL
 		urlPath := chi.URLParam(r, "*")
 		pathInfo := ParseFilePath(urlPath)
@@ -65,10 +76,21 @@ func V1DeleteFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, logge
 
 		currentInstanceID := engine.GetCurrentInstanceID()
 
+		// If-Unmodified-Since is checked again under the lock of the path,
+		// to the second; a date that does not parse is ignored
+		var unmodifiedSince time.Time
+		if since, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil {
+			unmodifiedSince = since.Add(time.Second - time.Nanosecond)
+			if md.MTime.After(unmodifiedSince) {
+				SendErrorResponse(w, logger, metadata.ErrModified, http.StatusPreconditionFailed)
+				return
+			}
+		}
+
 		// Check if file/directory is on this instance or needs cross-server proxy
 		if md.CallFSInstanceID != nil && *md.CallFSInstanceID != currentInstanceID {
 			// Resource is on another server - proxy the request
-			if err := engine.DeleteFileOnInstance(r.Context(), *md.CallFSInstanceID, enginePath); err != nil {
+			if err := deleteOnInstance(r.Context(), engine, *md.CallFSInstanceID, enginePath, unmodifiedSince); err != nil {
 				logger.Error("Failed to proxy DELETE request",
 					zap.String("instance_id", *md.CallFSInstanceID),
 					zap.String("path", enginePath),
@@ -88,7 +110,7 @@ func V1DeleteFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, logge
 		}
 
 		// Resource exists on this instance - delete locally
-		if err := engine.DeleteFile(r.Context(), enginePath); err != nil {
+		if err := deleteLocally(r.Context(), engine, enginePath, unmodifiedSince); err != nil {
 			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
 			return
 		}
@@ -101,6 +123,24 @@ func V1DeleteFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, logge
 	}
 }
 
+// deleteLocally deletes the entry at path on this instance, unless it was
+// modified after unmodifiedSince when that is set
+func deleteLocally(ctx context.Context, engine *core.Engine, path string, unmodifiedSince time.Time) error {
+	if unmodifiedSince.IsZero() {
+		return engine.DeleteFile(ctx, path)
+	}
+	return engine.DeleteFileIfUnmodified(ctx, path, unmodifiedSince)
+}
+
+// deleteOnInstance deletes the entry at path on the instance storing it,
+// unless it was modified after unmodifiedSince when that is set
+func deleteOnInstance(ctx context.Context, engine *core.Engine, instanceID, path string, unmodifiedSince time.Time) error {
+	if unmodifiedSince.IsZero() {
+		return engine.DeleteFileOnInstance(ctx, instanceID, path)
+	}
+	return engine.DeleteFileOnInstanceIfUnmodified(ctx, instanceID, path, unmodifiedSince)
+}
+
 // V1HeadFileEnhanced handles HEAD /files/{path} requests with cross-server support
 // @Summary Get file metadata with cross-server support
 // @Description Returns file metadata headers, automatically routing to the correct server
@@ -115,6 +155,15 @@ func V1DeleteFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, logge
 // @Header 200 {string} X-CallFS-GID "Group ID"
 // @Header 200 {string} X-CallFS-MTime "Last modified time"
 // @Header 200 {string} X-CallFS-Instance-ID "Instance ID where file is located"
+// @Header 200 {string} Accept-Ranges "bytes for files downloadable in ranges, none for erasure-coded files"
+// @Header 200 {string} X-CallFS-Checksum "SHA-256 of the file content as sha256=<hex>, when known"
+// @Header 200 {string} X-CallFS-Immutable "true while the file is under an immutability hold"
+// @Header 200 {string} X-CallFS-Retain-Until "End of the hold of the file, when it has one"
+// @Header 200 {string} X-CallFS-NLink "Hard links to the stored file (localfs)"
+// @Header 200 {string} X-CallFS-Symlink-Target "Percent-encoded target of a symlink (localfs)"
+// @Header 200 {string} X-CallFS-XAttrs "Configured extended attributes, form-encoded (localfs)"
+// @Header 200 {string} X-CallFS-Child-Count "Entries directly in a directory"
+// @Header 200 {string} X-CallFS-Recursive-Size "Bytes of the files below a directory, at any depth"
 // @Failure 401 {object} ErrorResponse "Unauthorized"
 // @Failure 403 {object} ErrorResponse "Forbidden"
 // @Failure 404 {object} ErrorResponse "Not Found"
@@ -123,6 +172,8 @@ func V1DeleteFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, logge
 // @Router /v1/files/{path} [head]
 func V1HeadFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, logger *zap.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
 		// Extract and parse path from URL
 		urlPath := chi.URLParam(r, "*")
 		pathInfo := ParseFilePath(urlPath)
@@ -163,6 +214,13 @@ func V1HeadFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, logger
 		if md.CallFSInstanceID != nil && *md.CallFSInstanceID != currentInstanceID {
 			// Resource is on another server - proxy the request to get metadata
 			remoteMd, err := engine.StatFileOnInstance(r.Context(), *md.CallFSInstanceID, enginePath)
+			if errors.Is(err, internalproxy.ErrPeerUnavailable) {
+				// The metadata store already knows the file; answer from it
+				logger.Warn("Owning instance unavailable, answering HEAD from metadata store",
+					zap.String("instance_id", *md.CallFSInstanceID),
+					zap.String("path", enginePath))
+				remoteMd, err = md, nil
+			}
 			if err != nil {
 				logger.Error("Failed to proxy HEAD request",
 					zap.String("instance_id", *md.CallFSInstanceID),
@@ -174,6 +232,7 @@ func V1HeadFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, logger
 
 			// Set headers from remote metadata and add instance info
 			setMetadataHeaders(w, remoteMd)
+			setDirectoryUsageHeaders(w, r, engine, md, logger)
 			w.Header().Set("X-CallFS-Instance-ID", *md.CallFSInstanceID)
 			w.WriteHeader(http.StatusOK)
 
@@ -184,8 +243,10 @@ func V1HeadFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, logger
 			return
 		}
 
-		// Resource exists on this instance - return metadata headers
-		setMetadataHeaders(w, md)
+		// Resource exists on this instance - return metadata headers, completed
+		// with what the local filesystem reports
+		setMetadataHeaders(w, engine.WithStoredAttributes(r.Context(), md))
+		setDirectoryUsageHeaders(w, r, engine, md, logger)
 		w.WriteHeader(http.StatusOK)
 
 		logger.Info("File metadata retrieved locally",
@@ -195,6 +256,23 @@ func V1HeadFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, logger
 	}
 }
 
+// setDirectoryUsageHeaders sets the number of entries in a directory and the
+// size of the files below it when the request asks for them with
+// usage=true, so clients can tell how large a listing is before fetching
+// it. The headers are left out if they cannot be counted.
+func setDirectoryUsageHeaders(w http.ResponseWriter, r *http.Request, engine *core.Engine, md *metadata.Metadata, logger *zap.Logger) {
+	if md.Type != "directory" || r.URL.Query().Get("usage") != "true" {
+		return
+	}
+	usage, err := engine.DirectoryUsage(r.Context(), md.Path)
+	if err != nil {
+		logger.Warn("Failed to count directory usage", zap.String("path", md.Path), zap.Error(err))
+		return
+	}
+	w.Header().Set("X-CallFS-Child-Count", strconv.FormatInt(usage.Children, 10))
+	w.Header().Set("X-CallFS-Recursive-Size", strconv.FormatInt(usage.Bytes, 10))
+}
+
 // setMetadataHeaders sets standard metadata headers for responses
 func setMetadataHeaders(w http.ResponseWriter, md *metadata.Metadata) {
 	w.Header().Set("X-CallFS-Type", md.Type)
@@ -203,8 +281,49 @@ func setMetadataHeaders(w http.ResponseWriter, md *metadata.Metadata) {
 	w.Header().Set("X-CallFS-UID", fmt.Sprintf("%d", md.UID))
 	w.Header().Set("X-CallFS-GID", fmt.Sprintf("%d", md.GID))
 	w.Header().Set("X-CallFS-MTime", md.MTime.Format("2006-01-02T15:04:05Z07:00"))
+	if md.Type == "file" {
+		if md.ErasureCoded {
+			w.Header().Set("Accept-Ranges", "none")
+		} else {
+			w.Header().Set("Accept-Ranges", "bytes")
+		}
+		if md.Checksum != "" {
+			w.Header().Set("X-CallFS-Checksum", md.Checksum)
+		}
+		if md.Held(time.Now()) {
+			w.Header().Set("X-CallFS-Immutable", "true")
+			if md.RetainUntil != nil {
+				w.Header().Set("X-CallFS-Retain-Until", md.RetainUntil.UTC().Format(time.RFC3339))
+			}
+		}
+	}
+	if md.NLink > 0 {
+		w.Header().Set("X-CallFS-NLink", fmt.Sprintf("%d", md.NLink))
+	}
+	if md.SymlinkTarget != nil {
+		w.Header().Set("X-CallFS-Symlink-Target", url.PathEscape(*md.SymlinkTarget))
+	}
+	if len(md.XAttrs) > 0 {
+		xattrs := url.Values{}
+		for name, value := range md.XAttrs {
+			xattrs.Set(name, value)
+		}
+		w.Header().Set("X-CallFS-XAttrs", xattrs.Encode())
+	}
 
 	if md.CallFSInstanceID != nil {
 		w.Header().Set("X-CallFS-Instance-ID", *md.CallFSInstanceID)
 	}
 }
+
+// clearProxyDeadlines lifts the server read and write timeouts for a request
+// proxied by another instance. The file body may be of any size, and the
+// instance the client talks to already enforces its own timeouts.
+func clearProxyDeadlines(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsPeerRequest(r.Context()) {
+		return
+	}
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+}
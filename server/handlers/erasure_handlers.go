@@ -3,6 +3,7 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,6 +14,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/core/log"
 	"github.com/ebogdum/callfs/erasure"
 	"github.com/ebogdum/callfs/server/middleware"
 )
@@ -20,6 +22,8 @@ import (
 // V1GetShard handles GET /v1/shards/{path}/{index} - public shard download (authenticated).
 func V1GetShard(em *erasure.Manager, authorizer auth.Authorizer, logger *zap.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
 		urlPath := chi.URLParam(r, "*")
 		if urlPath == "" {
 			SendErrorResponse(w, logger, fmt.Errorf("missing path"), http.StatusBadRequest)
@@ -63,7 +67,7 @@ func V1GetShard(em *erasure.Manager, authorizer auth.Authorizer, logger *zap.Log
 		data, err := em.GetShard(r.Context(), filePath, index)
 		if err != nil {
 			statusCode := http.StatusInternalServerError
-			if err == erasure.ErrShardNotFound {
+			if errors.Is(err, erasure.ErrShardNotFound) {
 				statusCode = http.StatusNotFound
 			}
 			SendErrorResponse(w, logger, err, statusCode)
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/backends"
+	"github.com/ebogdum/callfs/backends/internalproxy"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/delta"
+	"github.com/ebogdum/callfs/hooks"
+	"github.com/ebogdum/callfs/locks"
+	"github.com/ebogdum/callfs/metadata"
+	"github.com/ebogdum/callfs/preview"
+	"github.com/ebogdum/callfs/scan"
+	"github.com/ebogdum/callfs/search"
+)
+
+// Error codes of ErrorResponse. Clients tell errors apart by their code; the
+// message is for people and may change.
+const (
+	// Request errors
+	CodeBadRequest          = "BAD_REQUEST"
+	CodeInvalidCreateMode   = "INVALID_CREATE_MODE"
+	CodeInvalidAttributes   = "INVALID_ATTRIBUTES"
+	CodeInvalidRename       = "INVALID_RENAME"
+	CodeInvalidLock         = "INVALID_LOCK"
+	CodeInvalidMigration    = "INVALID_MIGRATION"
+	CodeInvalidScope        = "INVALID_SCOPE"
+	CodeInvalidSnapshot     = "INVALID_SNAPSHOT"
+	CodeInvalidHold         = "INVALID_HOLD"
+	CodeInvalidQuery        = "INVALID_QUERY"
+	CodeInvalidTransfer     = "INVALID_TRANSFER"
+	CodeInvalidDelta        = "INVALID_DELTA"
+	CodeChecksumMismatch    = "CHECKSUM_MISMATCH"
+	CodeRangeNotSatisfiable = "RANGE_NOT_SATISFIABLE"
+	CodeFileTooLarge        = "FILE_TOO_LARGE"
+	CodePreconditionFailed  = "PRECONDITION_FAILED"
+
+	// Authentication and authorization
+	CodeAuthenticationFailed = "AUTHENTICATION_FAILED"
+	CodePermissionDenied     = "PERMISSION_DENIED"
+	CodeReadOnly             = "READ_ONLY"
+	CodeImmutable            = "IMMUTABLE"
+
+	// State of paths
+	CodeFileNotFound      = "FILE_NOT_FOUND"
+	CodeFileAlreadyExists = "FILE_ALREADY_EXISTS"
+	CodeNotADirectory     = "NOT_A_DIRECTORY"
+	CodeDirectoryNotEmpty = "DIRECTORY_NOT_EMPTY"
+	CodeNotFound          = "NOT_FOUND" // Something other than a path, such as a link
+	CodeConflict          = "CONFLICT"
+	CodeGone              = "GONE"
+	CodeLockConflict      = "LOCK_CONFLICT"
+	CodeLockNotFound      = "LOCK_NOT_FOUND"
+	CodeSnapshotNotFound  = "SNAPSHOT_NOT_FOUND"
+	CodeSnapshotExists    = "SNAPSHOT_EXISTS"
+
+	// Content
+	CodeContentInfected    = "CONTENT_INFECTED"
+	CodeContentRejected    = "CONTENT_REJECTED"
+	CodePreviewUnsupported = "PREVIEW_UNSUPPORTED"
+	CodePreviewTooLarge    = "PREVIEW_TOO_LARGE"
+
+	// Operations already running or not configured
+	CodeMigrationInProgress = "MIGRATION_IN_PROGRESS"
+	CodeMigrationNotFound   = "MIGRATION_NOT_FOUND"
+	CodeTransferInProgress  = "TRANSFER_IN_PROGRESS"
+	CodeTransferNotFound    = "TRANSFER_NOT_FOUND"
+	CodeGCInProgress        = "GC_IN_PROGRESS"
+	CodeLifecycleInProgress = "LIFECYCLE_IN_PROGRESS"
+	CodeCacheDisabled       = "CACHE_DISABLED"
+	CodeNotImplemented      = "NOT_IMPLEMENTED"
+
+	// Capacity and availability
+	CodeInsufficientStorage = "INSUFFICIENT_STORAGE"
+	CodeScanFailed          = "SCAN_FAILED"
+	CodeHookFailed          = "HOOK_FAILED"
+	CodePeerUnavailable     = "PEER_UNAVAILABLE"
+	CodeBackendUnavailable  = "BACKEND_UNAVAILABLE"
+	CodeBackendBusy         = "BACKEND_BUSY"
+	CodeSearchUnavailable   = "SEARCH_UNAVAILABLE"
+	CodeTooManyTransfers    = "TOO_MANY_TRANSFERS"
+	CodeTimeout             = "TIMEOUT"
+	CodeInternalError       = "INTERNAL_ERROR"
+)
+
+// errorCodes maps the errors of the engine, its backends and the handlers to
+// a status and a code, matched with errors.Is in order
+var errorCodes = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{ErrFileTooLarge, http.StatusRequestEntityTooLarge, CodeFileTooLarge},
+	{errRangeNotSatisfiable, http.StatusRequestedRangeNotSatisfiable, CodeRangeNotSatisfiable},
+	{ErrInvalidCreateMode, http.StatusBadRequest, CodeInvalidCreateMode},
+	{errInvalidWrite, http.StatusBadRequest, CodeBadRequest},
+	{errBasisChanged, http.StatusPreconditionFailed, CodePreconditionFailed},
+	{delta.ErrInvalidDelta, http.StatusBadRequest, CodeInvalidDelta},
+	{auth.ErrAuthenticationFailed, http.StatusUnauthorized, CodeAuthenticationFailed},
+	{auth.ErrPermissionDenied, http.StatusForbidden, CodePermissionDenied},
+	{auth.ErrInvalidScope, http.StatusBadRequest, CodeInvalidScope},
+	{metadata.ErrNotFound, http.StatusNotFound, CodeFileNotFound},
+	{metadata.ErrAlreadyExists, http.StatusConflict, CodeFileAlreadyExists},
+	{metadata.ErrModified, http.StatusPreconditionFailed, CodePreconditionFailed},
+	{core.ErrDirectoryNotEmpty, http.StatusConflict, CodeDirectoryNotEmpty},
+	{core.ErrNotDirectory, http.StatusBadRequest, CodeNotADirectory},
+	{core.ErrChecksumMismatch, http.StatusBadRequest, CodeChecksumMismatch},
+	{core.ErrInvalidAttributes, http.StatusBadRequest, CodeInvalidAttributes},
+	{core.ErrInvalidRename, http.StatusBadRequest, CodeInvalidRename},
+	{core.ErrInvalidAdvisoryLock, http.StatusBadRequest, CodeInvalidLock},
+	{core.ErrAdvisoryLocksUnsupported, http.StatusNotImplemented, CodeNotImplemented},
+	{core.ErrInvalidMigration, http.StatusBadRequest, CodeInvalidMigration},
+	{core.ErrMigrationRunning, http.StatusConflict, CodeMigrationInProgress},
+	{core.ErrMigrationNotFound, http.StatusNotFound, CodeMigrationNotFound},
+	{core.ErrInvalidTransfer, http.StatusBadRequest, CodeInvalidTransfer},
+	{core.ErrTransferActive, http.StatusConflict, CodeTransferInProgress},
+	{core.ErrTransferNotFound, http.StatusNotFound, CodeTransferNotFound},
+	{core.ErrTooManyTransfers, http.StatusServiceUnavailable, CodeTooManyTransfers},
+	{core.ErrGCRunning, http.StatusConflict, CodeGCInProgress},
+	{core.ErrLifecycleRunning, http.StatusConflict, CodeLifecycleInProgress},
+	{core.ErrInvalidSnapshot, http.StatusBadRequest, CodeInvalidSnapshot},
+	{core.ErrSnapshotNotFound, http.StatusNotFound, CodeSnapshotNotFound},
+	{core.ErrSnapshotExists, http.StatusConflict, CodeSnapshotExists},
+	{core.ErrInvalidHold, http.StatusBadRequest, CodeInvalidHold},
+	{core.ErrHeld, http.StatusForbidden, CodeImmutable},
+	{core.ErrHoldNotAllowed, http.StatusForbidden, CodePermissionDenied},
+	{locks.ErrAdvisoryConflict, http.StatusLocked, CodeLockConflict},
+	{locks.ErrAdvisoryLockNotFound, http.StatusNotFound, CodeLockNotFound},
+	{scan.ErrContentInfected, http.StatusUnprocessableEntity, CodeContentInfected},
+	{scan.ErrScanFailed, http.StatusServiceUnavailable, CodeScanFailed},
+	{hooks.ErrContentRejected, http.StatusUnprocessableEntity, CodeContentRejected},
+	{hooks.ErrHookFailed, http.StatusServiceUnavailable, CodeHookFailed},
+	{search.ErrInvalidQuery, http.StatusBadRequest, CodeInvalidQuery},
+	{search.ErrUnavailable, http.StatusServiceUnavailable, CodeSearchUnavailable},
+	{preview.ErrUnsupported, http.StatusUnsupportedMediaType, CodePreviewUnsupported},
+	{preview.ErrTooLarge, http.StatusRequestEntityTooLarge, CodePreviewTooLarge},
+	{backends.ErrReadOnly, http.StatusForbidden, CodeReadOnly},
+	{backends.ErrInsufficientStorage, http.StatusInsufficientStorage, CodeInsufficientStorage},
+	{backends.ErrCacheDisabled, http.StatusConflict, CodeCacheDisabled},
+	// The owning instance is down and no replica could serve the request
+	{internalproxy.ErrPeerUnavailable, http.StatusServiceUnavailable, CodePeerUnavailable},
+	{backends.ErrBackendUnavailable, http.StatusServiceUnavailable, CodeBackendUnavailable},
+	{core.ErrBackendBusy, http.StatusServiceUnavailable, CodeBackendBusy},
+	{context.DeadlineExceeded, http.StatusGatewayTimeout, CodeTimeout},
+}
+
+// statusCodes are the codes of errors errorCodes does not map, by the status
+// their handler chose
+var statusCodes = map[int]string{
+	http.StatusBadRequest:   CodeBadRequest,
+	http.StatusUnauthorized: CodeAuthenticationFailed,
+	http.StatusForbidden:    CodePermissionDenied,
+	http.StatusNotFound:     CodeNotFound,
+	http.StatusConflict:     CodeConflict,
+	http.StatusGone:         CodeGone,
+}
+
+// classifyError returns the status and code of err, answered with
+// defaultStatus when it is none of the errors errorCodes maps
+func classifyError(err error, defaultStatus int) (int, string) {
+	for _, mapping := range errorCodes {
+		if errors.Is(err, mapping.err) {
+			return mapping.status, mapping.code
+		}
+	}
+	if code, ok := statusCodes[defaultStatus]; ok {
+		return defaultStatus, code
+	}
+	return defaultStatus, CodeInternalError
+}
@@ -2,13 +2,11 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
 	"go.uber.org/zap"
-
-	"github.com/ebogdum/callfs/auth"
-	"github.com/ebogdum/callfs/metadata"
 )
 
 // ErrorResponse represents a standardized error response
@@ -17,6 +15,9 @@ type ErrorResponse struct {
 	Message string `json:"message"`
 }
 
+// ErrFileTooLarge is returned when an upload exceeds the configured maximum file size
+var ErrFileTooLarge = errors.New("file exceeds the maximum allowed size")
+
 // customError is a simple error type for custom error messages
 type customError struct {
 	message string
@@ -30,32 +31,21 @@ func (e *customError) Error() string {
 func SendErrorResponse(w http.ResponseWriter, logger *zap.Logger, err error, defaultStatusCode int) {
 	w.Header().Set("Content-Type", "application/json")
 
-	var statusCode int
-	var errorCode string
-
-	// Map specific errors to HTTP status codes and error codes
-	switch err {
-	case metadata.ErrNotFound:
-		statusCode = http.StatusNotFound
-		errorCode = "FILE_NOT_FOUND"
-	case metadata.ErrAlreadyExists:
-		statusCode = http.StatusConflict
-		errorCode = "FILE_ALREADY_EXISTS"
-	case auth.ErrAuthenticationFailed:
-		statusCode = http.StatusUnauthorized
-		errorCode = "AUTHENTICATION_FAILED"
-	case auth.ErrPermissionDenied:
-		statusCode = http.StatusForbidden
-		errorCode = "PERMISSION_DENIED"
-	default:
-		statusCode = defaultStatusCode
-		errorCode = "INTERNAL_ERROR"
+	// Bodies cut off by http.MaxBytesReader surface wrapped from the backend
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		err = ErrFileTooLarge
 	}
 
+	statusCode, errorCode := classifyError(err, defaultStatusCode)
+	if errorCode == CodeBackendBusy {
+		w.Header().Set("Retry-After", "1")
+	}
 	w.WriteHeader(statusCode)
 
+	// Unmapped server errors may carry internal details
 	message := err.Error()
-	if errorCode == "INTERNAL_ERROR" {
+	if errorCode == CodeInternalError {
 		message = "an internal error occurred"
 	}
 
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/backends"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/metadata"
+)
+
+func TestSendErrorResponseStatus(t *testing.T) {
+	tests := []struct {
+		err           error
+		defaultStatus int
+		wantStatus    int
+		wantCode      string
+	}{
+		{metadata.ErrNotFound, http.StatusInternalServerError, http.StatusNotFound, CodeFileNotFound},
+		{fmt.Errorf("failed to get metadata: %w", metadata.ErrNotFound), http.StatusInternalServerError, http.StatusNotFound, CodeFileNotFound},
+		{fmt.Errorf("failed to open file: %w", metadata.ErrAlreadyExists), http.StatusInternalServerError, http.StatusConflict, CodeFileAlreadyExists},
+		{core.ErrDirectoryNotEmpty, http.StatusInternalServerError, http.StatusConflict, CodeDirectoryNotEmpty},
+		{fmt.Errorf("%w: timeout", backends.ErrBackendUnavailable), http.StatusInternalServerError, http.StatusServiceUnavailable, CodeBackendUnavailable},
+		{errors.New("invalid path"), http.StatusBadRequest, http.StatusBadRequest, CodeBadRequest},
+		{errors.New("disk exploded"), http.StatusInternalServerError, http.StatusInternalServerError, CodeInternalError},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		SendErrorResponse(rec, zap.NewNop(), tt.err, tt.defaultStatus)
+
+		var resp ErrorResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("%v: failed to decode response: %v", tt.err, err)
+		}
+		if rec.Code != tt.wantStatus || resp.Code != tt.wantCode {
+			t.Errorf("%v: got %d %s, want %d %s", tt.err, rec.Code, resp.Code, tt.wantStatus, tt.wantCode)
+		}
+		if resp.Code == CodeInternalError && resp.Message != "an internal error occurred" {
+			t.Errorf("%v: internal error message leaked: %q", tt.err, resp.Message)
+		}
+	}
+}
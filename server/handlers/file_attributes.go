@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/config"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// parseFileAttributes reads the attributes a client wants stored with an
+// upload from the X-CallFS-Mode, X-CallFS-UID, X-CallFS-GID, X-CallFS-ATime,
+// X-CallFS-MTime and X-CallFS-XAttrs headers, the same headers HEAD and GET
+// return. Times are RFC 3339 or Unix seconds. Only the extended attributes in
+// xattrNames, those HEAD reports, may be set.
+func parseFileAttributes(r *http.Request, xattrNames []string) (core.FileAttributes, error) {
+	var attrs core.FileAttributes
+
+	if v := strings.TrimSpace(r.Header.Get("X-CallFS-Mode")); v != "" {
+		mode, err := strconv.ParseUint(strings.TrimPrefix(v, "0o"), 8, 32)
+		if err != nil || mode > 0o777 {
+			return attrs, fmt.Errorf("%w: X-CallFS-Mode must be octal permission bits such as 0644", core.ErrInvalidAttributes)
+		}
+		fileMode := os.FileMode(mode)
+		attrs.Mode = &fileMode
+	}
+
+	for header, field := range map[string]**int{"X-CallFS-UID": &attrs.UID, "X-CallFS-GID": &attrs.GID} {
+		if v := strings.TrimSpace(r.Header.Get(header)); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil || id < 0 || id > math.MaxInt32 {
+				return attrs, fmt.Errorf("%w: %s must be a non-negative integer", core.ErrInvalidAttributes, header)
+			}
+			*field = &id
+		}
+	}
+
+	for header, field := range map[string]**time.Time{"X-CallFS-ATime": &attrs.ATime, "X-CallFS-MTime": &attrs.MTime} {
+		if v := strings.TrimSpace(r.Header.Get(header)); v != "" {
+			t, err := parseAttributeTime(v)
+			if err != nil {
+				return attrs, fmt.Errorf("%w: %s must be an RFC 3339 time or Unix seconds", core.ErrInvalidAttributes, header)
+			}
+			*field = &t
+		}
+	}
+
+	if v := strings.TrimSpace(r.Header.Get("X-CallFS-XAttrs")); v != "" {
+		values, err := url.ParseQuery(v)
+		if err != nil {
+			return attrs, fmt.Errorf("%w: X-CallFS-XAttrs must be form-encoded, such as user.a=1&user.b=2", core.ErrInvalidAttributes)
+		}
+		attrs.XAttrs = make(map[string]string, len(values))
+		for name, value := range values {
+			if !slices.Contains(xattrNames, name) {
+				return attrs, fmt.Errorf("%w: extended attribute %s is not listed in backend.localfs_xattrs", core.ErrInvalidAttributes, name)
+			}
+			attrs.XAttrs[name] = value[0]
+		}
+	}
+	return attrs, nil
+}
+
+func parseAttributeTime(v string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// authorizeFileAttributes checks that userID may store attrs on the existing
+// path, as chown(2) and chmod(2) would
+func authorizeFileAttributes(ctx context.Context, authorizer auth.Authorizer, userID, path string, attrs core.FileAttributes, existing *metadata.Metadata) error {
+	if attrs.ChangesOwner(existing) {
+		if err := authorizer.Authorize(ctx, userID, path, auth.ChownPerm); err != nil {
+			return err
+		}
+	}
+	if attrs.ChangesMode(existing) {
+		if err := authorizer.Authorize(ctx, userID, path, auth.ChmodPerm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authorizeNewFileAttributes checks that userID may store attrs on a path it
+// is creating with the owner of defaults. Its creator owns it, and so may
+// give it any mode and times.
+func authorizeNewFileAttributes(ctx context.Context, authorizer auth.Authorizer, userID, path string, attrs core.FileAttributes, defaults config.InodeDefaults) error {
+	if attrs.ChangesOwner(&metadata.Metadata{UID: defaults.UID, GID: defaults.GID}) {
+		return authorizer.Authorize(ctx, userID, path, auth.ChownPerm)
+	}
+	return nil
+}
+
+// inodeDefaults returns the owner and modes of the paths userID creates.
+// They are owned by the identity the authorizer maps the user to, if any,
+// unless server.inode_defaults.users gives the user another owner.
+func inodeDefaults(ctx context.Context, authorizer auth.Authorizer, cfg *config.ServerConfig, userID string) (config.InodeDefaults, error) {
+	inodes := cfg.InodeDefaults
+	if provider, ok := authorizer.(auth.IdentityProvider); ok {
+		id, found, err := provider.Identity(ctx, userID)
+		if err != nil {
+			return config.InodeDefaults{}, err
+		}
+		if found {
+			inodes.UID, inodes.GID = id.UID, id.GID()
+		}
+	}
+	return inodes.ForUser(userID), nil
+}
+
+// newInodeMetadata gives md, a file or directory being created, the owner
+// and mode of defaults, and clears the umask of defaults from the mode attrs
+// asks for, as open(2) and mkdir(2) do. attrs may be nil.
+func newInodeMetadata(defaults config.InodeDefaults, md *metadata.Metadata, attrs *core.FileAttributes) *metadata.Metadata {
+	md.UID, md.GID = defaults.UID, defaults.GID
+	mode := defaults.FileMode
+	if md.Type == "directory" {
+		mode = defaults.DirMode
+	}
+	md.Mode = fmt.Sprintf("%04o", mode)
+
+	if attrs != nil && attrs.Mode != nil {
+		masked := *attrs.Mode &^ defaults.Umask
+		attrs.Mode = &masked
+	}
+	return md
+}
+
+// applyFileAttributes stores the attributes sent with an upload once its
+// content is written, overriding the times the write set
+func applyFileAttributes(ctx context.Context, engine *core.Engine, path string, attrs core.FileAttributes) error {
+	if attrs.IsZero() {
+		return nil
+	}
+	_, err := engine.SetAttributes(ctx, path, attrs)
+	return err
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/config"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/delta"
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// Media types of the librsync signatures and deltas of files
+const (
+	signatureContentType = "application/x-rdiff-signature"
+	deltaContentType     = "application/x-rdiff-delta"
+)
+
+// errBasisChanged is returned for a delta made against content the file no
+// longer has
+var errBasisChanged = errors.New("the file changed since its signature was taken")
+
+// errHookedContent is returned for signatures and deltas of files whose
+// content hooks rewrite, since the stored content is not what clients see
+var errHookedContent = errors.New("signatures and deltas are not available for files rewritten by hooks")
+
+// DeltaResult describes a file patched with a delta
+type DeltaResult struct {
+	Path         string `json:"path"`
+	Size         int64  `json:"size"`
+	CopiedBytes  int64  `json:"copied_bytes"`  // Reused from the stored file
+	LiteralBytes int64  `json:"literal_bytes"` // Sent in the delta
+}
+
+// contentETag returns the ETag of the content of md, which a delta upload
+// sends in If-Match
+func contentETag(md *metadata.Metadata) string {
+	return `"` + core.ContentVersion(md) + `"`
+}
+
+// isDelta reports whether the body of r is a delta
+func isDelta(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == deltaContentType
+}
+
+// sendSignature sends the librsync signature of the stored content of md,
+// in blocks of the block_size query parameter, with strong sums of
+// strong_size bytes
+func sendSignature(w http.ResponseWriter, r *http.Request, engine *core.Engine, md *metadata.Metadata, logger *zap.Logger) {
+	if engine.TransformsContent(r.Context(), md.Path) {
+		SendErrorResponse(w, logger, errHookedContent, http.StatusConflict)
+		return
+	}
+	blockLen := delta.DefaultBlockLen(md.Size)
+	if value := r.URL.Query().Get("block_size"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < delta.MinBlockLen || n > delta.MaxBlockLen {
+			SendErrorResponse(w, logger, &customError{message: fmt.Sprintf("block_size must be a number of bytes from %d to %d", delta.MinBlockLen, delta.MaxBlockLen)}, http.StatusBadRequest)
+			return
+		}
+		blockLen = n
+	}
+	strongLen := delta.DefaultStrongLen(md.Size, blockLen)
+	if value := r.URL.Query().Get("strong_size"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > delta.MaxStrongLen {
+			SendErrorResponse(w, logger, &customError{message: fmt.Sprintf("strong_size must be a number of bytes from 1 to %d", delta.MaxStrongLen)}, http.StatusBadRequest)
+			return
+		}
+		strongLen = n
+	}
+
+	// The signature is of the same stored content a delta is applied to
+	basis, closeBasis, err := openBasis(r.Context(), engine, md)
+	if err != nil {
+		SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+		return
+	}
+	defer closeBasis()
+
+	w.Header().Set("Content-Type", signatureContentType)
+	w.Header().Set("ETag", contentETag(md))
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(http.StatusOK)
+	read, err := delta.WriteSignature(w, io.NewSectionReader(basis, 0, md.Size), blockLen, strongLen)
+	if err == nil && read != md.Size {
+		err = fmt.Errorf("read %d bytes of %d", read, md.Size)
+	}
+	if err != nil {
+		abortDownload(logger, r, md.Path, read, err)
+	}
+	logger.Debug("File signature sent",
+		zap.String("path", md.Path),
+		zap.Int("block_size", blockLen),
+		zap.Int("strong_size", strongLen))
+}
+
+// patchFile replaces the content of the file at enginePath with the result
+// of applying the delta in the body of r to it. The delta is applied to a
+// spool file in the spool_dir of cfg, which is stored as PUT stores an upload
+// once it is complete.
+func patchFile(w http.ResponseWriter, r *http.Request, engine *core.Engine, authorizer auth.Authorizer, cfg *config.ServerConfig, enginePath, userID string, logger *zap.Logger) {
+	ctx := r.Context()
+	// Copies read the stored content into the new one
+	for _, perm := range []auth.PermissionType{auth.ReadPerm, auth.WritePerm} {
+		if err := authorizer.Authorize(ctx, userID, enginePath, perm); err != nil {
+			SendErrorResponse(w, logger, err, http.StatusForbidden)
+			return
+		}
+	}
+	md, err := engine.GetMetadata(ctx, enginePath)
+	if err != nil {
+		SendErrorResponse(w, logger, err, http.StatusNotFound)
+		return
+	}
+	if md.Type != "file" {
+		SendErrorResponse(w, logger, &customError{message: "a delta can only be applied to a file"}, http.StatusBadRequest)
+		return
+	}
+	if engine.TransformsContent(ctx, enginePath) {
+		SendErrorResponse(w, logger, errHookedContent, http.StatusConflict)
+		return
+	}
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !etagMatches(ifMatch, contentETag(md)) {
+		SendErrorResponse(w, logger, errBasisChanged, http.StatusPreconditionFailed)
+		return
+	}
+
+	limit := maxFileSizeForPath(cfg, enginePath)
+	if !limitUploadBody(w, r, limit, logger) {
+		return
+	}
+	spool, err := os.CreateTemp(cfg.SpoolDir, "callfs-delta-*")
+	if err != nil {
+		SendErrorResponse(w, logger, fmt.Errorf("failed to create delta spool file: %w", err), http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = spool.Close()
+		_ = os.Remove(spool.Name())
+	}()
+
+	basis, closeBasis, err := openBasis(ctx, engine, md)
+	if err != nil {
+		SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+		return
+	}
+	defer closeBasis()
+
+	out := &limitedWriter{w: spool, limit: limit}
+	stats, err := delta.Patch(out, r.Body, basis)
+	if err != nil {
+		SendErrorResponse(w, logger, err, http.StatusBadRequest)
+		return
+	}
+	size := out.written
+
+	// A checksum given is of the patched file
+	body, err := wrapChecksumBody(r, io.NewSectionReader(spool, 0, size))
+	if err != nil {
+		SendErrorResponse(w, logger, &customError{message: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	// The content patched must still be that of the file when it is stored,
+	// which the engine checks under the lock of the path
+	if err := updateFileContent(ctx, engine, md, body, size, core.ContentVersion(md)); err != nil {
+		if errors.Is(err, metadata.ErrModified) {
+			err = errBasisChanged
+		}
+		SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("File patched with a delta",
+		zap.String("path", enginePath),
+		zap.String("user_id", userID),
+		zap.Int64("size", size),
+		zap.Int64("copied_bytes", stats.CopiedBytes),
+		zap.Int64("literal_bytes", stats.LiteralBytes))
+	SendJSONResponse(w, DeltaResult{
+		Path:         enginePath,
+		Size:         size,
+		CopiedBytes:  stats.CopiedBytes,
+		LiteralBytes: stats.LiteralBytes,
+	})
+}
+
+// openBasis opens the stored content of md for reading at offsets: the local
+// file when this instance holds it, or range reads of the engine otherwise.
+// The function returned closes it.
+func openBasis(ctx context.Context, engine *core.Engine, md *metadata.Metadata) (io.ReaderAt, func(), error) {
+	local, err := engine.OpenLocalFile(ctx, md)
+	if err != nil {
+		return nil, nil, err
+	}
+	if local != nil {
+		return local, func() { _ = local.Close() }, nil
+	}
+	ranges := &rangeReaderAt{ctx: ctx, engine: engine, path: md.Path, size: md.Size}
+	return ranges, ranges.close, nil
+}
+
+// limitedWriter fails with ErrFileTooLarge once more than limit bytes are
+// written to it
+type limitedWriter struct {
+	w       io.Writer
+	limit   int64
+	written int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.written+int64(len(p)) > l.limit {
+		return 0, ErrFileTooLarge
+	}
+	n, err := l.w.Write(p)
+	l.written += int64(n)
+	return n, err
+}
+
+// rangeReaderAt reads a file stored elsewhere at offsets, with range reads
+// of the engine. Reads continuing where the last one ended go on with the
+// same range, so a copy costs one request however it is read.
+type rangeReaderAt struct {
+	ctx    context.Context
+	engine *core.Engine
+	path   string
+	size   int64
+
+	reader   io.ReadCloser
+	position int64 // Of reader
+}
+
+func (r *rangeReaderAt) ReadAt(p []byte, offset int64) (int, error) {
+	if offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.reader == nil || offset != r.position {
+		r.close()
+		reader, err := r.engine.GetFileRange(r.ctx, r.path, offset, r.size-offset)
+		if err != nil {
+			return 0, err
+		}
+		r.reader, r.position = reader, offset
+	}
+	n, err := io.ReadFull(r.reader, p[:min(int64(len(p)), r.size-offset)])
+	r.position += int64(n)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (r *rangeReaderAt) close() {
+	if r.reader != nil {
+		_ = r.reader.Close()
+		r.reader = nil
+	}
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/backends/localfs"
+	"github.com/ebogdum/callfs/backends/noop"
+	"github.com/ebogdum/callfs/config"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/delta"
+	"github.com/ebogdum/callfs/locks"
+	"github.com/ebogdum/callfs/metadata"
+	"github.com/ebogdum/callfs/metadata/sqlite"
+)
+
+type allowAll struct{}
+
+func (allowAll) Authorize(context.Context, string, string, auth.PermissionType) error { return nil }
+
+// racingBody calls write once the delta it holds is first read, as a PUT
+// landing while the delta is applied would
+type racingBody struct {
+	io.Reader
+	write func()
+}
+
+func (b *racingBody) Read(p []byte) (int, error) {
+	if b.write != nil {
+		b.write()
+		b.write = nil
+	}
+	return b.Reader.Read(p)
+}
+
+func TestPatchFileRacingWrite(t *testing.T) {
+	dir := t.TempDir()
+	logger := zap.NewNop()
+	store, err := sqlite.NewSQLiteStore(filepath.Join(dir, "metadata.db"), logger)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer store.Close()
+	local, err := localfs.NewLocalFSAdapter(config.BackendConfig{LocalFSRootPath: filepath.Join(dir, "files")})
+	if err != nil {
+		t.Fatalf("NewLocalFSAdapter: %v", err)
+	}
+	engine := core.NewEngine(store, local, nil, noop.NewNoopAdapter(), nil, locks.NewLocalManager(time.Minute),
+		"test", nil, false, "", false, core.CacheOptions{Enabled: true, TTL: time.Minute, MaxEntries: 100}, logger)
+	defer engine.Close()
+
+	ctx := context.Background()
+	basis := bytes.Repeat([]byte("basis "), 4096)
+	now := time.Now()
+	md := &metadata.Metadata{Name: "f", Type: "file", Mode: "0644", BackendType: "localfs", ATime: now, MTime: now, CTime: now}
+	if err := engine.CreateFile(ctx, "/f", bytes.NewReader(basis), int64(len(basis)), md); err != nil {
+		t.Fatalf("CreateFile: %v", err)
+	}
+
+	var sig bytes.Buffer
+	blockLen := delta.DefaultBlockLen(int64(len(basis)))
+	if _, err := delta.WriteSignature(&sig, bytes.NewReader(basis), blockLen, delta.DefaultStrongLen(int64(len(basis)), blockLen)); err != nil {
+		t.Fatalf("WriteSignature: %v", err)
+	}
+	signature, err := delta.ReadSignature(&sig, 1<<20)
+	if err != nil {
+		t.Fatalf("ReadSignature: %v", err)
+	}
+	var patch bytes.Buffer
+	if err := delta.WriteDelta(&patch, signature, bytes.NewReader(append(basis, "patched"...))); err != nil {
+		t.Fatalf("WriteDelta: %v", err)
+	}
+
+	written := []byte("written meanwhile")
+	body := &racingBody{Reader: &patch, write: func() {
+		current, err := engine.GetMetadata(ctx, "/f")
+		if err != nil {
+			t.Errorf("GetMetadata: %v", err)
+			return
+		}
+		if err := engine.UpdateFile(ctx, "/f", bytes.NewReader(written), int64(len(written)), current); err != nil {
+			t.Errorf("UpdateFile: %v", err)
+		}
+	}}
+	r := httptest.NewRequest(http.MethodPatch, "/v1/files/f", body)
+	r.Header.Set("Content-Type", deltaContentType)
+	w := httptest.NewRecorder()
+	patchFile(w, r, engine, allowAll{}, &config.ServerConfig{SpoolDir: dir}, "/f", "user", logger)
+
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("status %d, want %d: %s", w.Code, http.StatusPreconditionFailed, w.Body)
+	}
+	var resp ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Code != CodePreconditionFailed {
+		t.Errorf("response %+v, %v, want code %s", resp, err, CodePreconditionFailed)
+	}
+	reader, err := engine.GetFile(ctx, "/f")
+	if err != nil {
+		t.Fatalf("GetFile: %v", err)
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("reading /f: %v", err)
+	}
+	if !bytes.Equal(content, written) {
+		t.Errorf("content %q, want the write %q kept", strings.TrimSpace(string(content[:min(len(content), 32)])), written)
+	}
+}
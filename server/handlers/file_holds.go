@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/config"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/server/middleware"
+)
+
+// HoldResponse is the hold of a file after a change
+type HoldResponse struct {
+	Path string `json:"path"`
+	core.Hold
+}
+
+// V1PatchFile handles PATCH /v1/files/{path}
+// @Summary Set the immutability hold of a file, or apply a delta to it
+// @Description Makes a file immutable, indefinitely or until retain_until, or lifts the hold. An immutable file cannot be updated, deleted or renamed. Only the owner of the file or an admin API key holds it. Owners place retention up to backend.hold_max_owner_retention; indefinite holds, and retention of files S3 locks in COMPLIANCE mode, are placed by admin API keys only. Retention can only be extended until it ends, and an indefinite hold only changed by admin API keys. Files in S3 are also locked with S3 Object Lock when backend.s3_object_lock_mode is set.
+// @Description With Content-Type: application/x-rdiff-delta, the body is an rdiff delta made against the signature GET /v1/files/{path} sends with Accept: application/x-rdiff-signature, and the file's content is replaced by the delta applied to it. This takes read and write permission.
+// @Tags files
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param path path string true "File path"
+// @Param request body core.Hold true "Hold to place"
+// @Param If-Match header string false "ETag of the signature the delta was made against; 412 when the file changed since"
+// @Param X-CallFS-Checksum header string false "Checksum of the patched file, as <algorithm>=<digest>"
+// @Success 200 {object} HoldResponse "Hold placed"
+// @Success 200 {object} DeltaResult "Delta applied"
+// @Failure 400 {object} ErrorResponse "Invalid hold or delta"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden, or the current hold does not allow the change"
+// @Failure 404 {object} ErrorResponse "File not found"
+// @Failure 412 {object} ErrorResponse "The file changed since the signature of the delta was taken"
+// @Failure 413 {object} ErrorResponse "The patched file is over the size limit"
+// @Router /v1/files/{path} [patch]
+func V1PatchFile(engine *core.Engine, authorizer auth.Authorizer, cfg *config.ServerConfig, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		pathInfo := ParseFilePath(chi.URLParam(r, "*"))
+		if pathInfo.IsInvalid || pathInfo.IsDirectory {
+			SendErrorResponse(w, logger, fmt.Errorf("%w: the path must name a file", core.ErrInvalidHold), http.StatusBadRequest)
+			return
+		}
+		enginePath := strings.TrimSuffix(pathInfo.FullPath, "/")
+
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			SendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
+			return
+		}
+		if isDelta(r) {
+			patchFile(w, r, engine, authorizer, cfg, enginePath, userID, logger)
+			return
+		}
+
+		var hold core.Hold
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1 MiB
+		if err := json.NewDecoder(r.Body).Decode(&hold); err != nil {
+			SendErrorResponse(w, logger, fmt.Errorf("%w: %v", core.ErrInvalidHold, err), http.StatusBadRequest)
+			return
+		}
+
+		// Like chmod, only the owner or an admin holds a file
+		if err := authorizer.Authorize(r.Context(), userID, enginePath, auth.WritePerm); err != nil {
+			SendErrorResponse(w, logger, err, http.StatusForbidden)
+			return
+		}
+		if err := authorizer.Authorize(r.Context(), userID, enginePath, auth.ChmodPerm); err != nil {
+			SendErrorResponse(w, logger, err, http.StatusForbidden)
+			return
+		}
+
+		md, err := engine.SetHold(r.Context(), enginePath, hold, auth.IsAdmin(userID))
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+
+		logger.Info("File hold set",
+			zap.String("path", enginePath),
+			zap.String("user_id", userID),
+			zap.Bool("immutable", md.Immutable))
+		SendJSONResponse(w, HoldResponse{Path: md.Path, Hold: core.Hold{Immutable: md.Immutable, RetainUntil: md.RetainUntil}})
+	}
+}
@@ -2,20 +2,20 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
 	"go.uber.org/zap"
 
 	"github.com/ebogdum/callfs/auth"
 	"github.com/ebogdum/callfs/config"
 	"github.com/ebogdum/callfs/core"
 	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/metadata"
 	"github.com/ebogdum/callfs/metrics"
 	"github.com/ebogdum/callfs/server/middleware"
 )
@@ -38,13 +38,22 @@ type FileInfo struct {
 // @Tags files
 // @Security BearerAuth
 // @Param path path string true "File or directory path"
+// @Param fields query string false "Comma-separated fields of each directory entry to return, e.g. name,type,size (default all)"
+// @Param Accept header string false "application/vnd.callfs+json for a file of up to 1 MiB as JSON, with its content base64-encoded and its attributes; application/x-rdiff-signature for the librsync signature of a file"
+// @Param block_size query int false "Block size of a signature, in bytes (default from the file size)"
+// @Param strong_size query int false "Bytes of the strong sum of each block of a signature, up to 32 (default from the file size)"
+// @Param format query string false "Directory listing format: json (default), or ndjson for one entry per line; without it, ndjson is sent when Accept lists application/x-ndjson"
 // @Success 200 {object} []FileInfo "Directory listing (if path is directory)"
 // @Success 200 {string} binary "File content (if path is file)"
+// @Success 200 {object} InlineFile "File content and attributes (with Accept: application/vnd.callfs+json)"
+// @Success 200 {string} binary "Signature of the file, to make a delta against (with Accept: application/x-rdiff-signature)"
+// @Success 304 "Directory listing unchanged since the ETag in If-None-Match"
 // @Header 200 {string} X-CallFS-Size "File size in bytes"
 // @Header 200 {string} X-CallFS-Mode "File mode (permissions)"
 // @Header 200 {string} X-CallFS-UID "User ID"
 // @Header 200 {string} X-CallFS-GID "Group ID"
 // @Header 200 {string} X-CallFS-MTime "Last modified time"
+// @Header 200 {string} X-CallFS-Checksum "SHA-256 of the file content as sha256=<hex>, when known"
 // @Failure 401 {object} ErrorResponse "Unauthorized"
 // @Failure 403 {object} ErrorResponse "Forbidden"
 // @Failure 404 {object} ErrorResponse "Not Found"
@@ -52,13 +61,7 @@ type FileInfo struct {
 // @Router /v1/files/{path} [get]
 func V1GetFile(engine *core.Engine, authorizer auth.Authorizer, cfg *config.ServerConfig, logger *zap.Logger) http.HandlerFunc { //nolint:gocognit
 	return func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-
-		// Track HTTP metrics
-		defer func() {
-			duration := time.Since(start)
-			metrics.HTTPRequestDuration.WithLabelValues(r.Method, "/files/*").Observe(duration.Seconds())
-		}()
+		logger := log.WithContext(r.Context(), logger)
 
 		// Create context with timeout for metadata operations
 		metadataCtx, metadataCancel := context.WithTimeout(r.Context(), cfg.MetadataOpTimeout)
@@ -72,7 +75,6 @@ func V1GetFile(engine *core.Engine, authorizer auth.Authorizer, cfg *config.Serv
 		urlPath := chi.URLParam(r, "*")
 		pathInfo := ParseFilePath(urlPath)
 		if pathInfo.IsInvalid {
-			metrics.HTTPRequestsTotal.WithLabelValues(r.Method, "/files/*", "400").Inc()
 			SendErrorResponse(w, logger, fmt.Errorf("invalid path"), http.StatusBadRequest)
 			return
 		}
@@ -80,10 +82,14 @@ func V1GetFile(engine *core.Engine, authorizer auth.Authorizer, cfg *config.Serv
 		// Get user ID from context
 		userID, ok := middleware.GetUserID(r.Context())
 		if !ok {
-			metrics.HTTPRequestsTotal.WithLabelValues(r.Method, "/files/*", "401").Inc()
 			SendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
 			return
 		}
+		clearProxyDeadlines(w, r)
+		// The instance that proxied the request runs the pre-read hooks
+		if auth.IsPeerRequest(r.Context()) {
+			fileCtx = core.WithHooksApplied(fileCtx)
+		}
 
 		// Normalize path for engine calls (remove trailing slash for directories)
 		enginePath := pathInfo.FullPath
@@ -93,7 +99,6 @@ func V1GetFile(engine *core.Engine, authorizer auth.Authorizer, cfg *config.Serv
 
 		// SECURITY FIX: Authorize BEFORE checking existence to prevent timing attacks
 		if err := authorizer.Authorize(metadataCtx, userID, enginePath, auth.ReadPerm); err != nil {
-			metrics.HTTPRequestsTotal.WithLabelValues(r.Method, "/files/*", "403").Inc()
 			SendErrorResponse(w, logger, err, http.StatusForbidden)
 			return
 		}
@@ -101,98 +106,147 @@ func V1GetFile(engine *core.Engine, authorizer auth.Authorizer, cfg *config.Serv
 		// Now check if file/directory exists
 		md, err := engine.GetMetadata(metadataCtx, enginePath)
 		if err != nil {
-			metrics.HTTPRequestsTotal.WithLabelValues(r.Method, "/files/*", "404").Inc()
 			SendErrorResponse(w, logger, err, http.StatusNotFound)
 			return
 		}
 
 		if md.Type == "file" {
+			if accepts(r, signatureContentType) {
+				sendSignature(w, r, engine, md, logger)
+				return
+			}
+			if accepts(r, inlineFileContentType) && r.URL.Query().Get("manifest") != "true" {
+				sendInlineFile(w, r, engine, md, userID, logger)
+				return
+			}
+			w.Header().Add("Vary", "Accept")
+
+			// Content rewritten by pre-read hooks is served from its spool file
+			if r.URL.Query().Get("manifest") != "true" {
+				processed, err := engine.OpenProcessed(fileCtx, md)
+				if err != nil {
+					SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+					return
+				}
+				if processed != nil {
+					defer processed.Close()
+
+					served := *md
+					served.Size = processed.Size()
+					served.Checksum = "" // Of the stored content
+					setFileHeaders(w, &served)
+					ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+					http.ServeContent(ww, r, md.Name, md.MTime, processed)
+					logDownload(engine, logger, r, ww.Status(), pathInfo.FullPath, userID, &served, ww.BytesWritten())
+					return
+				}
+			}
+
 			// Handle erasure-coded files
 			if md.ErasureCoded {
 				em := engine.GetErasureManager()
 				if em != nil {
 					if r.URL.Query().Get("manifest") == "true" {
 						HandleErasureManifest(w, r, em, enginePath, logger)
-						metrics.HTTPRequestsTotal.WithLabelValues(r.Method, "/files/*", "200").Inc()
 						return
 					}
-					HandleErasureDownload(w, r, em, enginePath, md.Size, logger)
-					metrics.HTTPRequestsTotal.WithLabelValues(r.Method, "/files/*", "200").Inc()
+					ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+					HandleErasureDownload(ww, r, em, enginePath, md.Size, logger)
 					metrics.FileOperationsTotal.WithLabelValues("read", "erasure").Inc()
+					recordAccess(engine, r, ww.Status(), enginePath, int64(ww.BytesWritten()))
 					return
 				}
 			}
 
+			// A single byte range is served from the backend directly, so
+			// proxied files honor Range the same way as local ones
+			byteRange, partial, err := parseRange(r.Header.Get("Range"), md.Size)
+			if err != nil {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", md.Size))
+				SendErrorResponse(w, logger, err, http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+
+			// Files on this instance's local filesystem are sent by the kernel,
+			// and honor conditional requests against their modification time
+			file, err := engine.OpenLocalFile(fileCtx, md)
+			if err != nil {
+				SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+				return
+			}
+			if file != nil {
+				defer file.Close()
+
+				setFileHeaders(w, md)
+				if !partial {
+					// Ranges parseRange ignores are ignored here too
+					r.Header.Del("Range")
+				}
+				ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+				http.ServeContent(ww, r, md.Name, md.MTime, file)
+				// Counted here, as the kernel sends the file without core reading it
+				metrics.BackendReadBytesTotal.WithLabelValues(md.BackendType).Add(float64(ww.BytesWritten()))
+				logDownload(engine, logger, r, ww.Status(), pathInfo.FullPath, userID, md, ww.BytesWritten())
+				return
+			}
+
 			// Stream file content using file operation timeout
-			reader, err := engine.GetFile(fileCtx, enginePath)
+			var reader io.ReadCloser
+			if partial {
+				reader, err = engine.GetFileRange(fileCtx, enginePath, byteRange.offset, byteRange.length)
+			} else {
+				reader, err = engine.GetFile(fileCtx, enginePath)
+			}
 			if err != nil {
-				metrics.HTTPRequestsTotal.WithLabelValues(r.Method, "/files/*", "500").Inc()
 				SendErrorResponse(w, logger, err, http.StatusInternalServerError)
 				return
 			}
 			defer reader.Close()
 
 			// Set headers
-			w.Header().Set("Content-Type", "application/octet-stream")
-			w.Header().Set("Content-Length", fmt.Sprintf("%d", md.Size))
-			w.Header().Set("X-CallFS-Type", "file")
-			w.Header().Set("X-CallFS-Size", fmt.Sprintf("%d", md.Size))
-			w.Header().Set("X-CallFS-Mode", md.Mode)
-			w.Header().Set("X-CallFS-UID", fmt.Sprintf("%d", md.UID))
-			w.Header().Set("X-CallFS-GID", fmt.Sprintf("%d", md.GID))
-			w.Header().Set("X-CallFS-MTime", md.MTime.Format("2006-01-02T15:04:05Z07:00"))
-
-			// Stream content
-			if _, err := io.Copy(w, reader); err != nil {
-				logger.Error("Failed to stream file content", zap.Error(err))
+			w.Header().Set("Accept-Ranges", "bytes")
+			if partial {
+				w.Header().Set("Content-Length", fmt.Sprintf("%d", byteRange.length))
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d",
+					byteRange.offset, byteRange.offset+byteRange.length-1, md.Size))
+			} else {
+				w.Header().Set("Content-Length", fmt.Sprintf("%d", md.Size))
 			}
+			setFileHeaders(w, md)
 
-			// Track successful file operation
-			metrics.HTTPRequestsTotal.WithLabelValues(r.Method, "/files/*", "200").Inc()
-			metrics.FileOperationsTotal.WithLabelValues("read", md.BackendType).Inc()
-
-			// Use secure logging with sanitized data
-			logFields := log.LogFields{
-				Path:      pathInfo.FullPath,
-				UserID:    userID,
-				Backend:   md.BackendType,
-				Size:      md.Size,
-				Operation: "download",
-			}.Sanitize()
+			status := http.StatusOK
+			if partial {
+				status = http.StatusPartialContent
+			}
+			w.WriteHeader(status)
 
-			logger.Info("File downloaded",
-				zap.String("path", logFields.Path),
-				zap.String("user_id", logFields.UserID),
-				zap.String("backend", logFields.Backend),
-				zap.Int64("size", logFields.Size))
+			// Stream content
+			sent, err := io.Copy(w, reader)
+			if err != nil {
+				abortDownload(logger, r, pathInfo.FullPath, sent, err)
+			}
+			logDownload(engine, logger, r, status, pathInfo.FullPath, userID, md, int(sent))
 
 		} else if md.Type == "directory" {
+			fields, err := parseFields(r, FileInfo{})
+			if err != nil {
+				SendErrorResponse(w, logger, err, http.StatusBadRequest)
+				return
+			}
+			format, err := listingFormat(w, r)
+			if err != nil {
+				SendErrorResponse(w, logger, err, http.StatusBadRequest)
+				return
+			}
+
 			// List directory contents using metadata timeout
 			children, err := engine.ListDirectory(metadataCtx, enginePath)
 			if err != nil {
-				metrics.HTTPRequestsTotal.WithLabelValues(r.Method, "/files/*", "500").Inc()
 				SendErrorResponse(w, logger, err, http.StatusInternalServerError)
 				return
 			}
 
-			// Convert to response format
-			var fileInfos []FileInfo
-			for _, child := range children {
-				fileInfo := FileInfo{
-					Name:  child.Name,
-					Path:  child.Path,
-					Type:  child.Type,
-					Size:  child.Size,
-					Mode:  child.Mode,
-					UID:   child.UID,
-					GID:   child.GID,
-					MTime: child.MTime.Format("2006-01-02T15:04:05Z07:00"),
-				}
-				fileInfos = append(fileInfos, fileInfo)
-			}
-
 			// Set headers
-			w.Header().Set("Content-Type", "application/json")
 			w.Header().Set("X-CallFS-Type", "directory")
 			w.Header().Set("X-CallFS-Size", "0")
 			w.Header().Set("X-CallFS-Mode", md.Mode)
@@ -200,15 +254,35 @@ func V1GetFile(engine *core.Engine, authorizer auth.Authorizer, cfg *config.Serv
 			w.Header().Set("X-CallFS-GID", fmt.Sprintf("%d", md.GID))
 			w.Header().Set("X-CallFS-MTime", md.MTime.Format("2006-01-02T15:04:05Z07:00"))
 
-			// Send JSON response
-			if err := json.NewEncoder(w).Encode(fileInfos); err != nil {
-				SendErrorResponse(w, logger, err, http.StatusInternalServerError)
-				return
+			if format == formatNDJSON {
+				out := newNDJSONWriter(w, fields)
+				for _, child := range children {
+					if err = out.write(newFileInfo(child)); err != nil {
+						break
+					}
+				}
+				out.finish(logger, enginePath, err)
+				if err != nil {
+					return
+				}
+			} else {
+				// Convert to response format
+				fileInfos := make([]FileInfo, 0, len(children))
+				for _, child := range children {
+					fileInfos = append(fileInfos, newFileInfo(child))
+				}
+				if fields == nil {
+					sendListing(w, r, logger, fileInfos)
+				} else {
+					items, err := fields.encodeAll(fileInfos)
+					if err != nil {
+						SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+						return
+					}
+					sendListing(w, r, logger, items)
+				}
 			}
 
-			// Track successful directory listing
-			metrics.HTTPRequestsTotal.WithLabelValues(r.Method, "/files/*", "200").Inc()
-
 			// Use secure logging with sanitized data
 			logFields := log.LogFields{
 				Path:      pathInfo.FullPath,
@@ -223,3 +297,67 @@ func V1GetFile(engine *core.Engine, authorizer auth.Authorizer, cfg *config.Serv
 		}
 	}
 }
+
+// setFileHeaders sets the content type and the X-CallFS headers of a file
+// download
+func setFileHeaders(w http.ResponseWriter, md *metadata.Metadata) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-CallFS-Type", "file")
+	w.Header().Set("X-CallFS-Size", fmt.Sprintf("%d", md.Size))
+	w.Header().Set("X-CallFS-Mode", md.Mode)
+	w.Header().Set("X-CallFS-UID", fmt.Sprintf("%d", md.UID))
+	w.Header().Set("X-CallFS-GID", fmt.Sprintf("%d", md.GID))
+	w.Header().Set("X-CallFS-MTime", md.MTime.Format("2006-01-02T15:04:05Z07:00"))
+	if md.Checksum != "" {
+		w.Header().Set("X-CallFS-Checksum", md.Checksum)
+	}
+}
+
+// abortDownload logs a download that failed after its headers were sent
+// and closes the connection, or resets the HTTP/2 stream, instead of ending
+// the body. The client sees a truncated transfer rather than a complete short
+// file, and can resume it with a range request.
+func abortDownload(logger *zap.Logger, r *http.Request, path string, sent int64, err error) {
+	if r.Context().Err() != nil {
+		logger.Warn("File download interrupted by the client",
+			zap.String("path", path), zap.Int64("bytes_sent", sent), zap.Error(err))
+	} else {
+		logger.Error("File download failed while streaming",
+			zap.String("path", path), zap.Int64("bytes_sent", sent), zap.Error(err))
+	}
+	panic(http.ErrAbortHandler)
+}
+
+// logDownload records a file download of sent bytes answered with status
+func logDownload(engine *core.Engine, logger *zap.Logger, r *http.Request, status int, path, userID string, md *metadata.Metadata, sent int) {
+	if status >= http.StatusBadRequest || status == http.StatusNotModified {
+		return
+	}
+	metrics.FileOperationsTotal.WithLabelValues("read", md.BackendType).Inc()
+	recordAccess(engine, r, status, path, int64(sent))
+
+	// Use secure logging with sanitized data
+	logFields := log.LogFields{
+		Path:      path,
+		UserID:    userID,
+		Backend:   md.BackendType,
+		Size:      md.Size,
+		Operation: "download",
+	}.Sanitize()
+
+	logger.Info("File downloaded",
+		zap.String("path", logFields.Path),
+		zap.String("user_id", logFields.UserID),
+		zap.String("backend", logFields.Backend),
+		zap.Int64("size", logFields.Size))
+}
+
+// recordAccess counts a download answered with status in the access
+// statistics of path. Downloads proxied from another instance are counted
+// by that instance.
+func recordAccess(engine *core.Engine, r *http.Request, status int, path string, sent int64) {
+	if status >= http.StatusBadRequest || status == http.StatusNotModified || auth.IsPeerRequest(r.Context()) {
+		return
+	}
+	engine.RecordAccess(path, sent, false)
+}
@@ -0,0 +1,554 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	callfsv1 "github.com/ebogdum/callfs/api/callfs/v1"
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/config"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/links"
+	"github.com/ebogdum/callfs/metadata"
+	"github.com/ebogdum/callfs/metrics"
+	"github.com/ebogdum/callfs/server/middleware"
+)
+
+// grpcChunkSize is the most content sent in one ReadResponse
+const grpcChunkSize = 64 * 1024
+
+// grpcErrorDomain is the domain of the ErrorInfo details of gRPC errors,
+// whose reason is the code an ErrorResponse would carry
+const grpcErrorDomain = "callfs"
+
+// errInvalidWrite is returned for the content of a Write that does not
+// match its header
+var errInvalidWrite = errors.New("invalid write")
+
+// grpcCodes are the gRPC codes of errors, by the HTTP status the REST API
+// answers them with
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:                   codes.InvalidArgument,
+	http.StatusUnauthorized:                 codes.Unauthenticated,
+	http.StatusForbidden:                    codes.PermissionDenied,
+	http.StatusNotFound:                     codes.NotFound,
+	http.StatusConflict:                     codes.FailedPrecondition,
+	http.StatusGone:                         codes.NotFound,
+	http.StatusRequestEntityTooLarge:        codes.InvalidArgument,
+	http.StatusUnsupportedMediaType:         codes.InvalidArgument,
+	http.StatusRequestedRangeNotSatisfiable: codes.OutOfRange,
+	http.StatusUnprocessableEntity:          codes.FailedPrecondition,
+	http.StatusLocked:                       codes.FailedPrecondition,
+	http.StatusNotImplemented:               codes.Unimplemented,
+	http.StatusBadGateway:                   codes.Unavailable,
+	http.StatusServiceUnavailable:           codes.Unavailable,
+	http.StatusGatewayTimeout:               codes.DeadlineExceeded,
+	http.StatusInsufficientStorage:          codes.ResourceExhausted,
+}
+
+// GRPCService serves the CallFS gRPC API with the engine, and the
+// permissions, of the REST API. Its calls are authenticated by
+// middleware.GRPCAuthInterceptors.
+type GRPCService struct {
+	callfsv1.UnimplementedCallFSServer
+
+	engine        *core.Engine
+	authorizer    auth.Authorizer
+	linkManager   *links.LinkManager
+	backendConfig *config.BackendConfig
+	serverConfig  *config.ServerConfig
+	apiHost       string
+	logger        *zap.Logger
+}
+
+// NewGRPCService returns the gRPC API of engine. Links are created for
+// apiHost, as POST /v1/links/generate does.
+func NewGRPCService(engine *core.Engine, authorizer auth.Authorizer, linkManager *links.LinkManager, backendConfig *config.BackendConfig, serverConfig *config.ServerConfig, apiHost string, logger *zap.Logger) *GRPCService {
+	return &GRPCService{
+		engine:        engine,
+		authorizer:    authorizer,
+		linkManager:   linkManager,
+		backendConfig: backendConfig,
+		serverConfig:  serverConfig,
+		apiHost:       apiHost,
+		logger:        logger,
+	}
+}
+
+// Stat returns the metadata of a file or directory, as GET /v1/stat does
+func (s *GRPCService) Stat(ctx context.Context, req *callfsv1.StatRequest) (*callfsv1.Entry, error) {
+	logger := log.WithContext(ctx, s.logger)
+	userID, path, err := s.authorize(ctx, req.GetPath(), auth.ReadPerm)
+	if err != nil {
+		return nil, grpcError(logger, err, http.StatusForbidden)
+	}
+
+	md, err := s.engine.Stat(ctx, path, req.GetFollowSymlinks())
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, metadata.ErrForbidden) {
+			status = http.StatusForbidden
+		}
+		return nil, grpcError(logger, err, status)
+	}
+	// A followed symlink may lead where the caller cannot read
+	if md.Path != path {
+		if err := s.authorizer.Authorize(ctx, userID, md.Path, auth.ReadPerm); err != nil {
+			return nil, grpcError(logger, err, http.StatusForbidden)
+		}
+	}
+	return grpcEntry(md), nil
+}
+
+// List sends the entries of a directory as they are read, as NDJSON
+// listings do
+func (s *GRPCService) List(req *callfsv1.ListRequest, stream callfsv1.CallFS_ListServer) error {
+	ctx := stream.Context()
+	logger := log.WithContext(ctx, s.logger)
+	_, path, err := s.authorize(ctx, req.GetPath(), auth.ReadPerm)
+	if err != nil {
+		return grpcError(logger, err, http.StatusForbidden)
+	}
+
+	maxDepth := int(req.GetMaxDepth())
+	switch {
+	case maxDepth < 0:
+		return grpcError(logger, &customError{message: "max_depth must not be negative"}, http.StatusBadRequest)
+	case maxDepth == 0:
+		maxDepth = 100
+	case maxDepth > 1000:
+		maxDepth = 1000
+	}
+
+	md, err := s.engine.GetMetadata(ctx, path)
+	if err != nil {
+		return grpcError(logger, err, http.StatusNotFound)
+	}
+	if md.Type != "directory" {
+		return grpcError(logger, core.ErrNotDirectory, http.StatusBadRequest)
+	}
+
+	send := func(item *metadata.Metadata) error {
+		return stream.Send(grpcEntry(item))
+	}
+	if req.GetRecursive() {
+		err = s.engine.WalkDirectoryRecursive(ctx, path, maxDepth, send)
+	} else {
+		var children []*metadata.Metadata
+		children, err = s.engine.ListDirectory(ctx, path)
+		for i := 0; err == nil && i < len(children); i++ {
+			err = send(children[i])
+		}
+	}
+	if err != nil {
+		return grpcError(logger, err, http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// Read sends the content of a file, or of a range of it, in chunks
+func (s *GRPCService) Read(req *callfsv1.ReadRequest, stream callfsv1.CallFS_ReadServer) error {
+	ctx := stream.Context()
+	logger := log.WithContext(ctx, s.logger)
+	userID, path, err := s.authorize(ctx, req.GetPath(), auth.ReadPerm)
+	if err != nil {
+		return grpcError(logger, err, http.StatusForbidden)
+	}
+
+	md, err := s.engine.GetMetadata(ctx, path)
+	if err != nil {
+		return grpcError(logger, err, http.StatusNotFound)
+	}
+	if md.Type != "file" {
+		return grpcError(logger, &customError{message: "path is not a file"}, http.StatusBadRequest)
+	}
+
+	offset, length := req.GetOffset(), req.GetLength()
+	if offset < 0 || length < 0 {
+		return grpcError(logger, &customError{message: "offset and length must not be negative"}, http.StatusBadRequest)
+	}
+	if offset > md.Size || (offset == md.Size && md.Size > 0) {
+		return grpcError(logger, errRangeNotSatisfiable, http.StatusRequestedRangeNotSatisfiable)
+	}
+	var reader io.ReadCloser
+	if offset == 0 && (length == 0 || length >= md.Size) {
+		reader, err = s.engine.GetFile(ctx, path)
+	} else {
+		if length == 0 || length > md.Size-offset {
+			length = md.Size - offset
+		}
+		reader, err = s.engine.GetFileRange(ctx, path, offset, length)
+	}
+	if err != nil {
+		return grpcError(logger, err, http.StatusInternalServerError)
+	}
+	defer reader.Close()
+
+	var sent int64
+	buf := make([]byte, grpcChunkSize)
+	for {
+		n, readErr := reader.Read(buf)
+		if n > 0 {
+			if err := stream.Send(&callfsv1.ReadResponse{Data: buf[:n]}); err != nil {
+				logger.Warn("gRPC read interrupted by the client",
+					zap.String("path", path), zap.Int64("bytes_sent", sent), zap.Error(err))
+				return err
+			}
+			sent += int64(n)
+		}
+		if errors.Is(readErr, io.EOF) {
+			break
+		}
+		if readErr != nil {
+			logger.Error("gRPC read failed while streaming",
+				zap.String("path", path), zap.Int64("bytes_sent", sent), zap.Error(readErr))
+			return grpcError(logger, readErr, http.StatusInternalServerError)
+		}
+	}
+
+	metrics.FileOperationsTotal.WithLabelValues("read", md.BackendType).Inc()
+	s.engine.RecordAccess(path, sent, false)
+	logger.Info("File read over gRPC",
+		zap.String("path", path),
+		zap.String("user_id", userID),
+		zap.Int64("bytes_sent", sent))
+	return nil
+}
+
+// Write creates or replaces a file with the content following its header,
+// as PUT /v1/files does
+func (s *GRPCService) Write(stream callfsv1.CallFS_WriteServer) error {
+	ctx := stream.Context()
+	logger := log.WithContext(ctx, s.logger)
+
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	header := first.GetHeader()
+	if header == nil {
+		return grpcError(logger, &customError{message: "the first message of a write must be its header"}, http.StatusBadRequest)
+	}
+	userID, path, err := s.authorize(ctx, header.GetPath(), auth.WritePerm)
+	if err != nil {
+		return grpcError(logger, err, http.StatusForbidden)
+	}
+	if path == "/" {
+		return grpcError(logger, &customError{message: "cannot write to the root directory"}, http.StatusBadRequest)
+	}
+	if err := s.checkWritable(path); err != nil {
+		return err
+	}
+
+	limit := maxFileSizeForPath(s.serverConfig, path)
+	size := int64(-1)
+	if header.Size != nil {
+		size = header.GetSize()
+		if size < 0 {
+			return grpcError(logger, &customError{message: "size must not be negative"}, http.StatusBadRequest)
+		}
+		if size > limit {
+			return grpcError(logger, ErrFileTooLarge, http.StatusRequestEntityTooLarge)
+		}
+	}
+	content := &grpcWriteReader{stream: stream, limit: limit, size: size}
+	var body io.Reader = content
+	if value := header.GetChecksum(); value != "" {
+		algorithm, digest, ok := strings.Cut(value, "=")
+		if !ok {
+			return grpcError(logger, &customError{message: "checksum must be in the form <algorithm>=<digest>"}, http.StatusBadRequest)
+		}
+		if body, err = core.NewChecksumReader(body, algorithm, func() string { return digest }); err != nil {
+			return grpcError(logger, &customError{message: err.Error()}, http.StatusBadRequest)
+		}
+	}
+
+	// Content of unknown length is stored with its size corrected once read
+	storeSize := max(size, 0)
+	existingMd, err := s.engine.GetMetadata(ctx, path)
+	created, remote := false, false
+	switch {
+	case errors.Is(err, metadata.ErrNotFound):
+		created = true
+		defaults, err := inodeDefaults(ctx, s.authorizer, s.serverConfig, userID)
+		if err != nil {
+			return grpcError(logger, err, http.StatusInternalServerError)
+		}
+		existingMd = newInodeMetadata(defaults, &metadata.Metadata{
+			Name:        path[strings.LastIndex(path, "/")+1:],
+			Type:        "file",
+			BackendType: s.backendConfig.DefaultBackend,
+			ATime:       time.Now(),
+			MTime:       time.Now(),
+			CTime:       time.Now(),
+		}, nil)
+		processed, err := s.engine.ProcessUpload(ctx, path, body)
+		if err != nil {
+			return grpcError(logger, err, http.StatusInternalServerError)
+		}
+		if processed != nil {
+			defer processed.Close()
+			body, storeSize, size = processed, processed.Size(), processed.Size()
+		}
+		if err := s.engine.CreateFile(ctx, path, body, storeSize, existingMd); err != nil {
+			return grpcError(logger, err, http.StatusInternalServerError)
+		}
+	case err != nil:
+		return grpcError(logger, err, http.StatusInternalServerError)
+	default:
+		if existingMd.Type != "file" {
+			return grpcError(logger, &customError{message: "cannot update directory with file content"}, http.StatusBadRequest)
+		}
+		if header.GetExclusive() {
+			return grpcError(logger, metadata.ErrAlreadyExists, http.StatusConflict)
+		}
+		processed, err := s.engine.ProcessUpload(ctx, path, body)
+		if err != nil {
+			return grpcError(logger, err, http.StatusInternalServerError)
+		}
+		if processed != nil {
+			defer processed.Close()
+			body, storeSize, size = processed, processed.Size(), processed.Size()
+		}
+		remote = existingMd.CallFSInstanceID != nil && *existingMd.CallFSInstanceID != s.engine.GetCurrentInstanceID()
+		if remote {
+			digest := core.NewContentDigest(body, storeSize)
+			if err := s.engine.UpdateFileOnInstance(ctx, *existingMd.CallFSInstanceID, path, digest, storeSize); err != nil {
+				return grpcError(logger, fmt.Errorf("failed to update file on remote server: %w", err), http.StatusBadGateway)
+			}
+			existingMd.Checksum = digest.Sum()
+		} else if err := s.engine.UpdateFile(ctx, path, body, storeSize, existingMd); err != nil {
+			return grpcError(logger, err, http.StatusInternalServerError)
+		}
+	}
+
+	// Correct the size of content whose length was not declared, and keep
+	// the metadata of a file written by another instance in step
+	if size < 0 || remote {
+		if size < 0 {
+			size = content.received
+		}
+		existingMd.Size = size
+		existingMd.MTime = time.Now()
+		existingMd.UpdatedAt = time.Now()
+		if err := s.engine.UpdateMetadataOnly(ctx, existingMd); err != nil {
+			logger.Warn("Failed to correct metadata size after gRPC write",
+				zap.String("path", path), zap.Int64("size", size), zap.Error(err))
+		}
+	}
+
+	logger.Info("File written over gRPC",
+		zap.String("path", path),
+		zap.String("user_id", userID),
+		zap.Int64("size", size),
+		zap.Bool("created", created))
+	return stream.SendAndClose(&callfsv1.WriteResponse{Path: path, Size: size, Created: created})
+}
+
+// Remove deletes a file or an empty directory, as DELETE /v1/files does
+func (s *GRPCService) Remove(ctx context.Context, req *callfsv1.RemoveRequest) (*callfsv1.RemoveResponse, error) {
+	logger := log.WithContext(ctx, s.logger)
+	userID, path, err := s.authorize(ctx, req.GetPath(), auth.DeletePerm)
+	if err != nil {
+		return nil, grpcError(logger, err, http.StatusForbidden)
+	}
+	if err := s.checkWritable(path); err != nil {
+		return nil, err
+	}
+
+	md, err := s.engine.GetMetadata(ctx, path)
+	if err != nil {
+		return nil, grpcError(logger, err, http.StatusNotFound)
+	}
+	if md.CallFSInstanceID != nil && *md.CallFSInstanceID != s.engine.GetCurrentInstanceID() {
+		if err := s.engine.DeleteFileOnInstance(ctx, *md.CallFSInstanceID, path); err != nil {
+			return nil, grpcError(logger, fmt.Errorf("failed to proxy request to owning server: %w", err), http.StatusBadGateway)
+		}
+	} else if err := s.engine.DeleteFile(ctx, path); err != nil {
+		return nil, grpcError(logger, err, http.StatusInternalServerError)
+	}
+
+	logger.Info("File/directory removed over gRPC",
+		zap.String("path", path),
+		zap.String("user_id", userID),
+		zap.String("type", md.Type))
+	return &callfsv1.RemoveResponse{}, nil
+}
+
+// CreateLink returns a single-use download link, as POST /v1/links/generate
+// does
+func (s *GRPCService) CreateLink(ctx context.Context, req *callfsv1.CreateLinkRequest) (*callfsv1.Link, error) {
+	logger := log.WithContext(ctx, s.logger)
+	userID, path, err := s.authorize(ctx, req.GetPath(), auth.ReadPerm)
+	if err != nil {
+		return nil, grpcError(logger, err, http.StatusForbidden)
+	}
+	if req.GetExpirySeconds() <= 0 || req.GetExpirySeconds() > 86400 {
+		return nil, grpcError(logger, &customError{message: "expiry must be between 1 and 86400 seconds"}, http.StatusBadRequest)
+	}
+	host := strings.TrimSpace(s.apiHost)
+	if strings.Contains(host, "/") {
+		return nil, grpcError(logger, errors.New("server misconfiguration: invalid external URL"), http.StatusInternalServerError)
+	}
+
+	expiry := time.Duration(req.GetExpirySeconds()) * time.Second
+	token, err := s.linkManager.GenerateLink(ctx, path, expiry)
+	if err != nil {
+		logger.Error("Failed to generate single-use link", zap.String("path", path), zap.Error(err))
+		return nil, grpcError(logger, errors.New("failed to generate download link"), http.StatusInternalServerError)
+	}
+
+	logger.Info("Generated single-use download link over gRPC",
+		zap.String("path", path),
+		zap.String("user_id", userID),
+		zap.String("token", links.TruncateToken(token)),
+		zap.Duration("expiry", expiry))
+	return &callfsv1.Link{
+		Url:     fmt.Sprintf("https://%s/download/%s", host, token),
+		Token:   token,
+		Expires: timestamppb.New(time.Now().Add(expiry)),
+	}, nil
+}
+
+// authorize returns the caller and the engine path of requestPath, once the
+// caller is allowed perm on it
+func (s *GRPCService) authorize(ctx context.Context, requestPath string, perm auth.PermissionType) (string, string, error) {
+	userID, ok := middleware.GetUserID(ctx)
+	if !ok {
+		return "", "", auth.ErrAuthenticationFailed
+	}
+	pathInfo := ParseFilePath(requestPath)
+	if requestPath == "" || pathInfo.IsInvalid {
+		return "", "", &customError{message: "invalid path"}
+	}
+	path := pathInfo.FullPath
+	if path != "/" {
+		path = strings.TrimSuffix(path, "/")
+	}
+	if err := s.authorizer.Authorize(ctx, userID, path, perm); err != nil {
+		return "", "", err
+	}
+	return userID, path, nil
+}
+
+// checkWritable refuses changes to path while the instance drains or the
+// path is in a snapshot, as the write middleware of the REST API does. The
+// engine refuses changes to read-only paths itself.
+func (s *GRPCService) checkWritable(path string) error {
+	if s.engine.Draining() {
+		return grpcStatus(codes.Unavailable, "DRAINING", "Instance is draining and accepts no new writes")
+	}
+	if s.engine.IsSnapshotPath(path) {
+		return grpcStatus(codes.PermissionDenied, CodeReadOnly, "Path is read-only")
+	}
+	return nil
+}
+
+// grpcWriteReader reads the content of a Write from its data messages
+type grpcWriteReader struct {
+	stream   callfsv1.CallFS_WriteServer
+	limit    int64 // Largest content accepted
+	size     int64 // Declared length, or negative when unknown
+	received int64
+	pending  []byte
+}
+
+func (r *grpcWriteReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		msg, err := r.stream.Recv()
+		if errors.Is(err, io.EOF) {
+			if r.size >= 0 && r.received != r.size {
+				return 0, fmt.Errorf("%w: received %d bytes, the header declared %d", errInvalidWrite, r.received, r.size)
+			}
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		if msg.GetHeader() != nil {
+			return 0, fmt.Errorf("%w: a write has a single header", errInvalidWrite)
+		}
+		r.pending = msg.GetData()
+		r.received += int64(len(r.pending))
+		if r.received > r.limit || (r.size >= 0 && r.received > r.size) {
+			if r.received > r.limit {
+				return 0, ErrFileTooLarge
+			}
+			return 0, fmt.Errorf("%w: received more than the %d bytes the header declared", errInvalidWrite, r.size)
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// grpcEntry returns the Entry describing md
+func grpcEntry(md *metadata.Metadata) *callfsv1.Entry {
+	entry := &callfsv1.Entry{
+		Name:        md.Name,
+		Path:        md.Path,
+		Type:        md.Type,
+		Size:        md.Size,
+		Mode:        md.Mode,
+		Uid:         int32(md.UID),
+		Gid:         int32(md.GID),
+		Atime:       timestamppb.New(md.ATime),
+		Mtime:       timestamppb.New(md.MTime),
+		Ctime:       timestamppb.New(md.CTime),
+		BackendType: md.BackendType,
+		Checksum:    md.Checksum,
+		Immutable:   md.Held(time.Now()),
+	}
+	if md.SymlinkTarget != nil {
+		entry.SymlinkTarget = *md.SymlinkTarget
+	}
+	return entry
+}
+
+// grpcError returns the status of err, with the code and message the REST
+// API would answer it with, as SendErrorResponse does
+func grpcError(logger *zap.Logger, err error, defaultStatusCode int) error {
+	if errors.Is(err, context.Canceled) {
+		return status.Error(codes.Canceled, err.Error())
+	}
+	statusCode, errorCode := classifyError(err, defaultStatusCode)
+	code, ok := grpcCodes[statusCode]
+	if !ok {
+		code = codes.Internal
+	}
+	if errorCode == CodeFileAlreadyExists {
+		code = codes.AlreadyExists
+	}
+
+	// Unmapped server errors may carry internal details
+	message := err.Error()
+	if errorCode == CodeInternalError {
+		message = "an internal error occurred"
+	}
+	logger.Info("gRPC error sent",
+		zap.String("error_code", errorCode),
+		zap.String("grpc_code", code.String()),
+		zap.Error(err))
+	return grpcStatus(code, errorCode, message)
+}
+
+// grpcStatus returns a status error whose ErrorInfo carries reason
+func grpcStatus(code codes.Code, reason, message string) error {
+	st := status.New(code, message)
+	if detailed, err := st.WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: grpcErrorDomain}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/ebogdum/callfs/metadata"
+)
+
+func TestGRPCErrorStatus(t *testing.T) {
+	tests := []struct {
+		err           error
+		defaultStatus int
+		wantCode      codes.Code
+		wantReason    string
+	}{
+		{fmt.Errorf("failed to get metadata: %w", metadata.ErrNotFound), http.StatusInternalServerError, codes.NotFound, CodeFileNotFound},
+		{metadata.ErrAlreadyExists, http.StatusConflict, codes.AlreadyExists, CodeFileAlreadyExists},
+		{errRangeNotSatisfiable, http.StatusInternalServerError, codes.OutOfRange, CodeRangeNotSatisfiable},
+		{fmt.Errorf("%w: short", errInvalidWrite), http.StatusInternalServerError, codes.InvalidArgument, CodeBadRequest},
+		{errors.New("disk exploded"), http.StatusInternalServerError, codes.Internal, CodeInternalError},
+	}
+
+	for _, tt := range tests {
+		st := status.Convert(grpcError(zap.NewNop(), tt.err, tt.defaultStatus))
+		reason := ""
+		for _, detail := range st.Details() {
+			if info, ok := detail.(*errdetails.ErrorInfo); ok {
+				reason = info.GetReason()
+			}
+		}
+		if st.Code() != tt.wantCode || reason != tt.wantReason {
+			t.Errorf("%v: got %s %s, want %s %s", tt.err, st.Code(), reason, tt.wantCode, tt.wantReason)
+		}
+		if reason == CodeInternalError && st.Message() != "an internal error occurred" {
+			t.Errorf("%v: internal error message leaked: %q", tt.err, st.Message())
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/backends/internalproxy"
+	"github.com/ebogdum/callfs/core"
+)
+
+// HealthResponse is returned by the liveness and readiness endpoints
+type HealthResponse struct {
+	Status     string                 `json:"status"` // "ok", "degraded" or "draining"
+	InstanceID string                 `json:"instance_id,omitempty"`
+	Components []core.ComponentHealth `json:"components,omitempty"`
+}
+
+// V1Liveness handles GET /healthz.
+// It only reports that the process is up and serving HTTP; dependencies are
+// deliberately not checked so orchestrators don't restart healthy processes
+// during a database outage. A draining instance stays live, so its requests
+// in progress can finish, but tells the peers probing it with a header.
+func V1Liveness(engine *core.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if engine.Draining() {
+			w.Header().Set(internalproxy.DrainingHeader, "true")
+			SendJSONResponse(w, HealthResponse{Status: "draining"})
+			return
+		}
+		SendJSONResponse(w, HealthResponse{Status: "ok"})
+	}
+}
+
+// V1Readiness handles GET /readyz.
+// It pings the metadata store, lock manager and backends and responds with
+// 503 Service Unavailable if any of them fails within timeout, or if the
+// instance is draining.
+func V1Readiness(engine *core.Engine, timeout time.Duration, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		components := engine.CheckHealth(r.Context(), timeout)
+
+		response := HealthResponse{
+			Status:     "ok",
+			InstanceID: engine.GetCurrentInstanceID(),
+			Components: components,
+		}
+		statusCode := http.StatusOK
+		for _, component := range components {
+			if !component.Healthy() {
+				response.Status = "degraded"
+				statusCode = http.StatusServiceUnavailable
+				break
+			}
+		}
+		if engine.Draining() {
+			response.Status = "draining"
+			statusCode = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			logger.Error("Failed to encode readiness response", zap.Error(err))
+		}
+	}
+}
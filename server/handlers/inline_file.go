@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// inlineFileContentType is the media type of InlineFile documents
+const inlineFileContentType = "application/vnd.callfs+json"
+
+// maxInlineFileSize caps the content of InlineFile documents, which are held
+// in memory whole
+const maxInlineFileSize int64 = 1 << 20 // 1 MiB
+
+// InlineFile is a small file with its attributes, sent and received in one
+// JSON document as application/vnd.callfs+json
+type InlineFile struct {
+	Path     string            `json:"path,omitempty"` // Set in responses
+	Size     int64             `json:"size"`           // Set in responses
+	Content  []byte            `json:"content"`        // Base64-encoded
+	Mode     string            `json:"mode,omitempty"`
+	UID      *int              `json:"uid,omitempty"`
+	GID      *int              `json:"gid,omitempty"`
+	ATime    string            `json:"atime,omitempty"` // RFC 3339, or Unix seconds in requests
+	MTime    string            `json:"mtime,omitempty"`
+	Checksum string            `json:"checksum,omitempty"`
+	XAttrs   map[string]string `json:"xattrs,omitempty"`
+}
+
+// isInlineFile reports whether the body of r is an InlineFile
+func isInlineFile(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == inlineFileContentType
+}
+
+// decodeInlineFile turns an upload of an InlineFile into the upload of its
+// content, with its attributes in the headers an upload takes them from. On
+// failure it sends the error response and returns false.
+func decodeInlineFile(w http.ResponseWriter, r *http.Request, logger *zap.Logger) bool {
+	// Base64 takes 4 bytes for 3, and the attributes are small
+	r.Body = http.MaxBytesReader(w, r.Body, int64(base64.StdEncoding.EncodedLen(int(maxInlineFileSize)))+64<<10)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	var file InlineFile
+	if err := decoder.Decode(&file); err != nil {
+		SendErrorResponse(w, logger, inlineFileError(err), http.StatusBadRequest)
+		return false
+	}
+	if int64(len(file.Content)) > maxInlineFileSize {
+		SendErrorResponse(w, logger, ErrFileTooLarge, http.StatusRequestEntityTooLarge)
+		return false
+	}
+
+	headers := map[string]string{
+		"X-CallFS-Mode":     file.Mode,
+		"X-CallFS-ATime":    file.ATime,
+		"X-CallFS-MTime":    file.MTime,
+		"X-CallFS-Checksum": file.Checksum,
+	}
+	if file.UID != nil {
+		headers["X-CallFS-UID"] = strconv.Itoa(*file.UID)
+	}
+	if file.GID != nil {
+		headers["X-CallFS-GID"] = strconv.Itoa(*file.GID)
+	}
+	if len(file.XAttrs) > 0 {
+		xattrs := url.Values{}
+		for name, value := range file.XAttrs {
+			xattrs.Set(name, value)
+		}
+		headers["X-CallFS-XAttrs"] = xattrs.Encode()
+	}
+	for header, value := range headers {
+		if value != "" {
+			r.Header.Set(header, value)
+		}
+	}
+
+	r.Header.Set("Content-Type", "application/octet-stream")
+	r.Body = io.NopCloser(bytes.NewReader(file.Content))
+	r.ContentLength = int64(len(file.Content))
+	return true
+}
+
+// inlineFileError describes why an InlineFile could not be decoded
+func inlineFileError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return ErrFileTooLarge
+	}
+	return fmt.Errorf("%w: invalid %s document: %v", core.ErrInvalidAttributes, inlineFileContentType, err)
+}
+
+// sendInlineFile sends the file md describes as an InlineFile
+func sendInlineFile(w http.ResponseWriter, r *http.Request, engine *core.Engine, md *metadata.Metadata, userID string, logger *zap.Logger) {
+	if md.Size > maxInlineFileSize {
+		SendErrorResponse(w, logger, ErrFileTooLarge, http.StatusRequestEntityTooLarge)
+		return
+	}
+	// Extended attributes are read from the copy of the file
+	stat, err := engine.Stat(r.Context(), md.Path, false)
+	if err != nil {
+		SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Content rewritten by pre-read hooks may be larger, and has another checksum
+	checksum := stat.Checksum
+	processed, err := engine.OpenProcessed(r.Context(), md)
+	if err != nil {
+		SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+		return
+	}
+	var reader io.ReadCloser = processed
+	if processed != nil {
+		checksum = ""
+	} else if reader, err = engine.GetFile(r.Context(), md.Path); err != nil {
+		SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(io.LimitReader(reader, maxInlineFileSize+1))
+	if err != nil {
+		SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+		return
+	}
+	if int64(len(content)) > maxInlineFileSize {
+		SendErrorResponse(w, logger, ErrFileTooLarge, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	file := InlineFile{
+		Path:     stat.Path,
+		Size:     int64(len(content)),
+		Content:  content,
+		Mode:     stat.Mode,
+		UID:      &stat.UID,
+		GID:      &stat.GID,
+		ATime:    stat.ATime.UTC().Format("2006-01-02T15:04:05Z07:00"),
+		MTime:    stat.MTime.UTC().Format("2006-01-02T15:04:05Z07:00"),
+		Checksum: checksum,
+		XAttrs:   stat.XAttrs,
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", inlineFileContentType)
+	w.Header().Add("Vary", "Accept")
+	_, _ = w.Write(data)
+	logDownload(engine, logger, r, http.StatusOK, md.Path, userID, md, len(content))
+}
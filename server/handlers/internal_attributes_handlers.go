@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/backends"
+	"github.com/ebogdum/callfs/backends/internalproxy"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// InternalAttributesHandler handles POST /v1/internal/attributes
+// Applies a client-supplied mode, times or extended attributes to this node's
+// copy of a path after another node stored them in the metadata.
+func InternalAttributesHandler(engine *core.Engine, verifier *auth.RequestVerifier, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorizeInternal(r, verifier, logger) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req internalproxy.AttributesRequest
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1 MiB
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		if !strings.HasPrefix(req.Path, "/") {
+			http.Error(w, "path must be absolute", http.StatusBadRequest)
+			return
+		}
+		req.Path = path.Clean(req.Path)
+
+		err := engine.LocalSetAttributes(r.Context(), req)
+		switch {
+		case err == nil:
+			w.WriteHeader(http.StatusNoContent)
+		case errors.Is(err, metadata.ErrForbidden):
+			http.Error(w, "invalid path", http.StatusBadRequest)
+		case errors.Is(err, core.ErrInvalidAttributes):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, metadata.ErrNotFound):
+			http.Error(w, "not found", http.StatusNotFound)
+		case errors.Is(err, backends.ErrReadOnly):
+			http.Error(w, "read-only", http.StatusForbidden)
+		default:
+			logger.Error("Failed to set local attributes", zap.String("path", req.Path), zap.Error(err))
+			http.Error(w, "failed to set attributes", http.StatusInternalServerError)
+		}
+	}
+}
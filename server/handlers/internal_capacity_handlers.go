@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/backends"
+	"github.com/ebogdum/callfs/backends/internalproxy"
+	"github.com/ebogdum/callfs/core/log"
+)
+
+// InternalCapacityHandler handles GET /v1/internal/capacity
+// Reports the free and total space of this node's local filesystem backend,
+// which peers use for capacity-weighted placement.
+func InternalCapacityHandler(localBackend backends.CapacityReporter, instanceID string, verifier *auth.RequestVerifier, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorizeInternal(r, verifier, logger) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		free, total, err := localBackend.Capacity(r.Context())
+		if err != nil {
+			logger.Error("Failed to read local capacity", zap.Error(err))
+			http.Error(w, "failed to read capacity", http.StatusInternalServerError)
+			return
+		}
+		SendJSONResponse(w, internalproxy.Capacity{InstanceID: instanceID, FreeBytes: free, TotalBytes: total})
+	}
+}
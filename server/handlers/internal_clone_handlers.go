@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/backends/internalproxy"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// InternalCloneHandler handles POST /v1/internal/clone
+// Copies files on this node's local filesystem backend while another node
+// takes a snapshot of a subtree whose content this node holds.
+func InternalCloneHandler(engine *core.Engine, verifier *auth.RequestVerifier, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorizeInternal(r, verifier, logger) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req internalproxy.CloneRequest
+		r.Body = http.MaxBytesReader(w, r.Body, 16<<20) // 16 MiB
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		for _, file := range req.Files {
+			if !strings.HasPrefix(file.From, "/") || !strings.HasPrefix(file.To, "/") {
+				http.Error(w, "paths must be absolute", http.StatusBadRequest)
+				return
+			}
+		}
+
+		for _, file := range req.Files {
+			err := engine.LocalClone(r.Context(), path.Clean(file.From), path.Clean(file.To))
+			switch {
+			case err == nil:
+				continue
+			case errors.Is(err, metadata.ErrForbidden):
+				http.Error(w, "invalid path", http.StatusBadRequest)
+			case errors.Is(err, metadata.ErrNotFound):
+				http.Error(w, "not found", http.StatusNotFound)
+			default:
+				logger.Error("Failed to clone local file", zap.String("from", file.From), zap.String("to", file.To), zap.Error(err))
+				http.Error(w, "failed to clone", http.StatusInternalServerError)
+			}
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// InternalListingHandler handles GET /v1/internal/listing?path=/dir
+// Lists what this node's local filesystem backend holds in a directory, which
+// peers compare against the metadata store when merging listings.
+func InternalListingHandler(engine *core.Engine, verifier *auth.RequestVerifier, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorizeInternal(r, verifier, logger) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		dir := r.URL.Query().Get("path")
+		if !strings.HasPrefix(dir, "/") {
+			http.Error(w, "path must be absolute", http.StatusBadRequest)
+			return
+		}
+
+		listing, err := engine.LocalListing(r.Context(), path.Clean(dir))
+		if err != nil {
+			if errors.Is(err, metadata.ErrForbidden) {
+				http.Error(w, "invalid path", http.StatusBadRequest)
+				return
+			}
+			logger.Error("Failed to list local directory", zap.String("path", dir), zap.Error(err))
+			http.Error(w, "failed to list directory", http.StatusInternalServerError)
+			return
+		}
+		SendJSONResponse(w, listing)
+	}
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/core/log"
+	metadataraft "github.com/ebogdum/callfs/metadata/raft"
+)
+
+// InternalRaftMembersHandler handles GET /v1/internal/raft/members
+// Lists voters and learners in the raft configuration.
+func InternalRaftMembersHandler(store *metadataraft.Store, verifier *auth.RequestVerifier, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorizeInternal(r, verifier, logger) {
+			writeMembershipResponse(w, logger, http.StatusUnauthorized, metadataraft.MembershipResponse{Status: "error", Error: "unauthorized"})
+			return
+		}
+
+		members, err := store.Members(r.Context())
+		if err != nil {
+			writeMembershipResponse(w, logger, http.StatusBadGateway, metadataraft.MembershipResponse{Status: "error", Error: err.Error()})
+			return
+		}
+		writeMembershipResponse(w, logger, http.StatusOK, metadataraft.MembershipResponse{Status: "ok", LeaderID: store.LeaderID(), Members: members})
+	}
+}
+
+// InternalRaftReadIndexHandler handles GET /v1/internal/raft/read-index
+// Followers call it on the leader to serve linearizable reads.
+func InternalRaftReadIndexHandler(store *metadataraft.Store, verifier *auth.RequestVerifier, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !authorizeInternal(r, verifier, logger) {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(metadataraft.ReadIndexResponse{Error: "unauthorized"})
+			return
+		}
+
+		index, err := store.ReadIndex(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			_ = json.NewEncoder(w).Encode(metadataraft.ReadIndexResponse{Error: err.Error(), LeaderID: store.LeaderID()})
+			return
+		}
+		if err := json.NewEncoder(w).Encode(metadataraft.ReadIndexResponse{Index: index, LeaderID: store.LeaderID()}); err != nil {
+			logger.Error("Failed to encode raft read index response", zap.Error(err))
+		}
+	}
+}
+
+// InternalRaftRemoveHandler handles POST /v1/internal/raft/remove
+// Removes a voter or learner; must be sent to the leader.
+func InternalRaftRemoveHandler(store *metadataraft.Store, verifier *auth.RequestVerifier, logger *zap.Logger) http.HandlerFunc {
+	return raftMembershipChange(store, verifier, logger, "removed", func(ctx context.Context, req metadataraft.MembershipRequest) error {
+		return store.RemoveNode(ctx, req.NodeID)
+	})
+}
+
+// InternalRaftTransferLeadershipHandler handles POST /v1/internal/raft/transfer-leadership
+// Hands leadership to the named voter, or to any up to date voter when none is given.
+func InternalRaftTransferLeadershipHandler(store *metadataraft.Store, verifier *auth.RequestVerifier, logger *zap.Logger) http.HandlerFunc {
+	return raftMembershipChange(store, verifier, logger, "transferred", func(ctx context.Context, req metadataraft.MembershipRequest) error {
+		return store.TransferLeadership(ctx, req.NodeID, req.RaftAddr)
+	})
+}
+
+// raftMembershipChange wraps the shared auth, leader check and decoding of
+// membership mutations
+func raftMembershipChange(store *metadataraft.Store, verifier *auth.RequestVerifier, logger *zap.Logger, okStatus string, apply func(context.Context, metadataraft.MembershipRequest) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorizeInternal(r, verifier, logger) {
+			writeMembershipResponse(w, logger, http.StatusUnauthorized, metadataraft.MembershipResponse{Status: "error", Error: "unauthorized"})
+			return
+		}
+		if !store.IsLeader() {
+			writeMembershipResponse(w, logger, http.StatusBadGateway, metadataraft.MembershipResponse{Status: "error", Error: "not leader", LeaderID: store.LeaderID()})
+			return
+		}
+
+		var req metadataraft.MembershipRequest
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1 MiB
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeMembershipResponse(w, logger, http.StatusBadRequest, metadataraft.MembershipResponse{Status: "error", Error: fmt.Sprintf("invalid request: %v", err)})
+			return
+		}
+
+		if err := apply(r.Context(), req); err != nil {
+			status := http.StatusBadGateway
+			msg := strings.ToLower(err.Error())
+			if strings.Contains(msg, "required") || strings.Contains(msg, "not a cluster member") || strings.Contains(msg, "learner") {
+				status = http.StatusBadRequest
+			}
+			logger.Warn("Raft membership change failed", zap.String("node_id", req.NodeID), zap.Error(err))
+			writeMembershipResponse(w, logger, status, metadataraft.MembershipResponse{Status: "error", Error: err.Error(), LeaderID: store.LeaderID()})
+			return
+		}
+
+		writeMembershipResponse(w, logger, http.StatusOK, metadataraft.MembershipResponse{Status: okStatus, LeaderID: store.LeaderID()})
+	}
+}
+
+func writeMembershipResponse(w http.ResponseWriter, logger *zap.Logger, status int, resp metadataraft.MembershipResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Error("Failed to encode raft membership response", zap.Error(err))
+	}
+}
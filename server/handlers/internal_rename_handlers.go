@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/backends"
+	"github.com/ebogdum/callfs/backends/internalproxy"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// InternalRenameHandler handles POST /v1/internal/rename
+// Moves a path on this node's local filesystem backend while another node
+// renames a subtree whose content this node holds.
+func InternalRenameHandler(engine *core.Engine, verifier *auth.RequestVerifier, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorizeInternal(r, verifier, logger) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req internalproxy.RenameRequest
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1 MiB
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		if !strings.HasPrefix(req.From, "/") || !strings.HasPrefix(req.To, "/") {
+			http.Error(w, "paths must be absolute", http.StatusBadRequest)
+			return
+		}
+
+		err := engine.LocalRename(r.Context(), path.Clean(req.From), path.Clean(req.To))
+		switch {
+		case err == nil:
+			w.WriteHeader(http.StatusNoContent)
+		case errors.Is(err, metadata.ErrForbidden):
+			http.Error(w, "invalid path", http.StatusBadRequest)
+		case errors.Is(err, metadata.ErrAlreadyExists):
+			http.Error(w, "destination exists", http.StatusConflict)
+		case errors.Is(err, backends.ErrReadOnly):
+			http.Error(w, "read-only", http.StatusForbidden)
+		default:
+			logger.Error("Failed to rename local path", zap.String("from", req.From), zap.String("to", req.To), zap.Error(err))
+			http.Error(w, "failed to rename", http.StatusInternalServerError)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package handlers
 
 import (
 	"bytes"
-	"crypto/subtle"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,15 +10,19 @@ import (
 
 	"go.uber.org/zap"
 
+	"github.com/ebogdum/callfs/auth"
 	"github.com/ebogdum/callfs/backends"
+	"github.com/ebogdum/callfs/core/log"
 	"github.com/ebogdum/callfs/internal/pathutil"
 )
 
 // InternalStoreShardHandler handles PUT /v1/internal/shards/{path}/{index}
-// Stores a shard on this node (authenticated via InternalProxySecret).
-func InternalStoreShardHandler(localBackend backends.Storage, internalSecret string, logger *zap.Logger) http.HandlerFunc {
+// Stores a shard on this node (authenticated by a peer's signature).
+func InternalStoreShardHandler(localBackend backends.Storage, verifier *auth.RequestVerifier, logger *zap.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !authorizeInternal(r, internalSecret) {
+		logger := log.WithContext(r.Context(), logger)
+
+		if !authorizeInternal(r, verifier, logger) {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
@@ -55,9 +58,11 @@ func InternalStoreShardHandler(localBackend backends.Storage, internalSecret str
 
 // InternalGetShardHandler handles GET /v1/internal/shards/{path}/{index}
 // Retrieves a shard from this node.
-func InternalGetShardHandler(localBackend backends.Storage, internalSecret string, logger *zap.Logger) http.HandlerFunc {
+func InternalGetShardHandler(localBackend backends.Storage, verifier *auth.RequestVerifier, logger *zap.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !authorizeInternal(r, internalSecret) {
+		logger := log.WithContext(r.Context(), logger)
+
+		if !authorizeInternal(r, verifier, logger) {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
@@ -84,9 +89,11 @@ func InternalGetShardHandler(localBackend backends.Storage, internalSecret strin
 
 // InternalDeleteShardHandler handles DELETE /v1/internal/shards/{path}/{index}
 // Deletes a shard from this node.
-func InternalDeleteShardHandler(localBackend backends.Storage, internalSecret string, logger *zap.Logger) http.HandlerFunc {
+func InternalDeleteShardHandler(localBackend backends.Storage, verifier *auth.RequestVerifier, logger *zap.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !authorizeInternal(r, internalSecret) {
+		logger := log.WithContext(r.Context(), logger)
+
+		if !authorizeInternal(r, verifier, logger) {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
@@ -105,13 +112,18 @@ func InternalDeleteShardHandler(localBackend backends.Storage, internalSecret st
 	}
 }
 
-func authorizeInternal(r *http.Request, secret string) bool {
-	if secret == "" {
-		return false // Reject all requests if no internal secret is configured
+// authorizeInternal reports whether r was sent by a peer, signed with one of
+// the internal proxy secrets, and logs why it was not. The body of r must be
+// signed too.
+func authorizeInternal(r *http.Request, verifier *auth.RequestVerifier, logger *zap.Logger) bool {
+	if err := verifier.Verify(r); err != nil {
+		logger.Warn("Internal request rejected",
+			zap.String("remote_addr", r.RemoteAddr),
+			zap.String("path", r.URL.Path),
+			zap.Error(err))
+		return false
 	}
-	auth := r.Header.Get("Authorization")
-	token := strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
-	return subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+	return true
 }
 
 // parseShardPath extracts the shard storage path and index from a URL like
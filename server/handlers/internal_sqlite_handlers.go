@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/core/log"
+	metadatasqlite "github.com/ebogdum/callfs/metadata/sqlite"
+)
+
+// InternalSQLiteBackupHandler handles POST /v1/internal/sqlite/backup
+// Writes an online backup of the SQLite metadata database into backupDir.
+func InternalSQLiteBackupHandler(store *metadatasqlite.SQLiteStore, backupDir string, verifier *auth.RequestVerifier, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorizeInternal(r, verifier, logger) {
+			writeMaintenanceResponse(w, logger, http.StatusUnauthorized, metadatasqlite.MaintenanceResponse{Status: "error", Error: "unauthorized"})
+			return
+		}
+
+		var req metadatasqlite.BackupRequest
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1 MiB
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeMaintenanceResponse(w, logger, http.StatusBadRequest, metadatasqlite.MaintenanceResponse{Status: "error", Error: fmt.Sprintf("invalid request: %v", err)})
+			return
+		}
+
+		path, err := store.Backup(r.Context(), backupDir, req.Name)
+		if err != nil {
+			logger.Error("SQLite backup failed", zap.Error(err))
+			writeMaintenanceResponse(w, logger, http.StatusInternalServerError, metadatasqlite.MaintenanceResponse{Status: "error", Error: err.Error()})
+			return
+		}
+
+		resp := metadatasqlite.MaintenanceResponse{Status: "backed_up", Path: path}
+		if info, err := os.Stat(path); err == nil {
+			resp.SizeBytes = info.Size()
+		}
+		if req.Checkpoint {
+			result, err := store.Checkpoint(r.Context(), "truncate")
+			if err != nil {
+				logger.Warn("WAL checkpoint after backup failed", zap.Error(err))
+			}
+			resp.Checkpoint = result
+		}
+		writeMaintenanceResponse(w, logger, http.StatusOK, resp)
+	}
+}
+
+// InternalSQLiteCheckpointHandler handles POST /v1/internal/sqlite/checkpoint
+// Runs a WAL checkpoint on the SQLite metadata database.
+func InternalSQLiteCheckpointHandler(store *metadatasqlite.SQLiteStore, verifier *auth.RequestVerifier, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorizeInternal(r, verifier, logger) {
+			writeMaintenanceResponse(w, logger, http.StatusUnauthorized, metadatasqlite.MaintenanceResponse{Status: "error", Error: "unauthorized"})
+			return
+		}
+
+		var req metadatasqlite.CheckpointRequest
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1 MiB
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeMaintenanceResponse(w, logger, http.StatusBadRequest, metadatasqlite.MaintenanceResponse{Status: "error", Error: fmt.Sprintf("invalid request: %v", err)})
+			return
+		}
+
+		result, err := store.Checkpoint(r.Context(), req.Mode)
+		if err != nil {
+			writeMaintenanceResponse(w, logger, http.StatusBadRequest, metadatasqlite.MaintenanceResponse{Status: "error", Error: err.Error()})
+			return
+		}
+		writeMaintenanceResponse(w, logger, http.StatusOK, metadatasqlite.MaintenanceResponse{Status: "checkpointed", Checkpoint: result})
+	}
+}
+
+func writeMaintenanceResponse(w http.ResponseWriter, logger *zap.Logger, status int, resp metadatasqlite.MaintenanceResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Error("Failed to encode sqlite maintenance response", zap.Error(err))
+	}
+}
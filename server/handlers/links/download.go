@@ -7,11 +7,13 @@ import (
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 
 	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
 	"github.com/ebogdum/callfs/internal/pathutil"
 	"github.com/ebogdum/callfs/links"
 	"github.com/ebogdum/callfs/server/handlers"
@@ -31,6 +33,8 @@ import (
 // @Router /download/{token} [get]
 func V1DownloadLinkHandler(engine *core.Engine, manager *links.LinkManager, logger *zap.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
 		ctx := r.Context()
 
 		// Extract token from URL path
@@ -65,8 +69,9 @@ func V1DownloadLinkHandler(engine *core.Engine, manager *links.LinkManager, logg
 			return
 		}
 
-		// Defense-in-depth: re-validate the stored path before using it
-		if err := pathutil.ValidatePath(filePath); err != nil {
+		// Defense-in-depth: re-validate the stored path before using it. Stored
+		// paths are absolute; validate them relative to the root like request paths.
+		if err := pathutil.ValidatePath(strings.TrimPrefix(filePath, "/")); err != nil {
 			logger.Error("Stored link path failed validation",
 				zap.String("file_path", filePath),
 				zap.Error(err))
@@ -97,7 +102,8 @@ func V1DownloadLinkHandler(engine *core.Engine, manager *links.LinkManager, logg
 		w.Header().Set("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(filename))
 
 		// Stream the file content
-		_, err = io.Copy(w, reader)
+		sent, err := io.Copy(w, reader)
+		engine.RecordAccess(filePath, sent, true)
 		if err != nil {
 			logger.Error("Failed to stream file content for single-use link",
 				zap.String("token", links.TruncateToken(token)),
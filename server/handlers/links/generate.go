@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/core/log"
 	"github.com/ebogdum/callfs/links"
 	"github.com/ebogdum/callfs/server/handlers"
 	"github.com/ebogdum/callfs/server/middleware"
@@ -43,6 +44,8 @@ type GenerateLinkResponse struct {
 // @Router /v1/links/generate [post]
 func V1GenerateLinkHandler(manager *links.LinkManager, authorizer auth.Authorizer, apiHost string, logger *zap.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
 		ctx := r.Context()
 
 		userID, ok := middleware.GetUserID(ctx)
@@ -16,7 +16,6 @@ import (
 	"github.com/ebogdum/callfs/core"
 	"github.com/ebogdum/callfs/core/log"
 	"github.com/ebogdum/callfs/metadata"
-	"github.com/ebogdum/callfs/metrics"
 	"github.com/ebogdum/callfs/server/middleware"
 )
 
@@ -30,15 +29,25 @@ type DirectoryListingResponse struct {
 	Items     []FileInfo `json:"items"`
 }
 
+// projectedListingResponse is a DirectoryListingResponse whose items have
+// the fields selected with fields= only
+type projectedListingResponse struct {
+	DirectoryListingResponse
+	Items []json.RawMessage `json:"items"`
+}
+
 // ListDirectory handles GET /api/directories/{path} requests
 // @Summary List directory contents
-// @Description Lists directory contents with optional recursive traversal
+// @Description Lists directory contents with optional recursive traversal. JSON listings carry an ETag for conditional requests. With format=ndjson, or Accept: application/x-ndjson, each item is sent on a line of its own as it is read from the metadata store, without the envelope, so listings of any size are sent in constant memory.
 // @Tags directories
 // @Security BearerAuth
 // @Param path path string true "Directory path"
 // @Param recursive query bool false "Recursively list subdirectories"
 // @Param max_depth query int false "Maximum recursion depth (default: 100, max: 1000)"
+// @Param fields query string false "Comma-separated fields of each item to return, e.g. name,type,size (default all)"
+// @Param format query string false "json (default), or ndjson for one item per line, streamed as it is read; without it, ndjson is sent when Accept lists application/x-ndjson"
 // @Success 200 {object} DirectoryListingResponse "Directory listing"
+// @Success 304 "Listing unchanged since the ETag in If-None-Match"
 // @Failure 401 {object} ErrorResponse "Unauthorized"
 // @Failure 403 {object} ErrorResponse "Forbidden"
 // @Failure 404 {object} ErrorResponse "Not Found"
@@ -46,11 +55,7 @@ type DirectoryListingResponse struct {
 // @Router /v1/directories/{path} [get]
 func V1ListDirectory(engine *core.Engine, authorizer auth.Authorizer, logger *zap.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Start timing
-		start := time.Now()
-		defer func() {
-			metrics.HTTPRequestDuration.WithLabelValues(r.Method, "/api/directories/*").Observe(time.Since(start).Seconds())
-		}()
+		logger := log.WithContext(r.Context(), logger)
 
 		// Create contexts with timeouts
 		metadataCtx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
@@ -67,7 +72,6 @@ func V1ListDirectory(engine *core.Engine, authorizer auth.Authorizer, logger *za
 		// Clean and validate path
 		pathInfo := ParseFilePath(pathParam)
 		if pathInfo.IsInvalid {
-			metrics.HTTPRequestsTotal.WithLabelValues(r.Method, "/api/directories/*", "400").Inc()
 			SendErrorResponse(w, logger, fmt.Errorf("invalid path"), http.StatusBadRequest)
 			return
 		}
@@ -75,7 +79,6 @@ func V1ListDirectory(engine *core.Engine, authorizer auth.Authorizer, logger *za
 		// Get user ID from context
 		userID, ok := middleware.GetUserID(r.Context())
 		if !ok {
-			metrics.HTTPRequestsTotal.WithLabelValues(r.Method, "/api/directories/*", "401").Inc()
 			SendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
 			return
 		}
@@ -88,7 +91,6 @@ func V1ListDirectory(engine *core.Engine, authorizer auth.Authorizer, logger *za
 
 		// Authorize access
 		if err := authorizer.Authorize(metadataCtx, userID, enginePath, auth.ReadPerm); err != nil {
-			metrics.HTTPRequestsTotal.WithLabelValues(r.Method, "/api/directories/*", "403").Inc()
 			SendErrorResponse(w, logger, err, http.StatusForbidden)
 			return
 		}
@@ -96,13 +98,11 @@ func V1ListDirectory(engine *core.Engine, authorizer auth.Authorizer, logger *za
 		// Check if path exists and is a directory
 		md, err := engine.GetMetadata(metadataCtx, enginePath)
 		if err != nil {
-			metrics.HTTPRequestsTotal.WithLabelValues(r.Method, "/api/directories/*", "404").Inc()
 			SendErrorResponse(w, logger, err, http.StatusNotFound)
 			return
 		}
 
 		if md.Type != "directory" {
-			metrics.HTTPRequestsTotal.WithLabelValues(r.Method, "/api/directories/*", "400").Inc()
 			SendErrorResponse(w, logger, fmt.Errorf("path is not a directory"), http.StatusBadRequest)
 			return
 		}
@@ -122,62 +122,86 @@ func V1ListDirectory(engine *core.Engine, authorizer auth.Authorizer, logger *za
 			}
 		}
 
-		var children []*metadata.Metadata
-		if recursive {
-			children, err = engine.ListDirectoryRecursive(metadataCtx, enginePath, maxDepth)
-		} else {
-			children, err = engine.ListDirectory(metadataCtx, enginePath)
-		}
-
+		fields, err := parseFields(r, FileInfo{})
 		if err != nil {
-			metrics.HTTPRequestsTotal.WithLabelValues(r.Method, "/api/directories/*", "500").Inc()
-			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			SendErrorResponse(w, logger, err, http.StatusBadRequest)
 			return
 		}
-
-		// Convert to response format
-		var fileInfos []FileInfo
-		for _, child := range children {
-			fileInfo := FileInfo{
-				Name:  child.Name,
-				Path:  child.Path,
-				Type:  child.Type,
-				Size:  child.Size,
-				Mode:  child.Mode,
-				UID:   child.UID,
-				GID:   child.GID,
-				MTime: child.MTime.Format("2006-01-02T15:04:05Z07:00"),
-			}
-			fileInfos = append(fileInfos, fileInfo)
-		}
-
-		// Create response
-		response := DirectoryListingResponse{
-			Path:      enginePath,
-			Type:      "directory",
-			Recursive: recursive,
-			Count:     len(fileInfos),
-			Items:     fileInfos,
-		}
-
-		if recursive {
-			response.MaxDepth = maxDepth
+		format, err := listingFormat(w, r)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusBadRequest)
+			return
 		}
 
-		// Set headers
-		w.Header().Set("Content-Type", "application/json")
 		w.Header().Set("X-CallFS-Type", "directory")
-		w.Header().Set("X-CallFS-Count", fmt.Sprintf("%d", len(fileInfos)))
 		w.Header().Set("X-CallFS-Recursive", fmt.Sprintf("%t", recursive))
 
-		// Send JSON response
-		if err := json.NewEncoder(w).Encode(response); err != nil {
-			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
-			return
-		}
+		count := 0
+		if format == formatNDJSON {
+			// Streamed listings may take longer than the metadata timeout
+			out := newNDJSONWriter(w, fields)
+			send := func(child *metadata.Metadata) error {
+				count++
+				return out.write(newFileInfo(child))
+			}
+			if recursive {
+				err = engine.WalkDirectoryRecursive(r.Context(), enginePath, maxDepth, send)
+			} else {
+				var children []*metadata.Metadata
+				children, err = engine.ListDirectory(r.Context(), enginePath)
+				for _, child := range children {
+					if err = send(child); err != nil {
+						break
+					}
+				}
+			}
+			out.finish(logger, enginePath, err)
+			if err != nil {
+				return
+			}
+		} else {
+			var children []*metadata.Metadata
+			if recursive {
+				children, err = engine.ListDirectoryRecursive(metadataCtx, enginePath, maxDepth)
+			} else {
+				children, err = engine.ListDirectory(metadataCtx, enginePath)
+			}
+			if err != nil {
+				SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+				return
+			}
 
-		// Track successful directory listing
-		metrics.HTTPRequestsTotal.WithLabelValues(r.Method, "/api/directories/*", "200").Inc()
+			// Convert to response format
+			fileInfos := make([]FileInfo, 0, len(children))
+			for _, child := range children {
+				fileInfos = append(fileInfos, newFileInfo(child))
+			}
+			count = len(fileInfos)
+
+			// Create response
+			response := DirectoryListingResponse{
+				Path:      enginePath,
+				Type:      "directory",
+				Recursive: recursive,
+				Count:     count,
+				Items:     fileInfos,
+			}
+			if recursive {
+				response.MaxDepth = maxDepth
+			}
+
+			w.Header().Set("X-CallFS-Count", fmt.Sprintf("%d", count))
+			if fields == nil {
+				sendListing(w, r, logger, response)
+			} else {
+				items, err := fields.encodeAll(fileInfos)
+				if err != nil {
+					SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+					return
+				}
+				sendListing(w, r, logger, projectedListingResponse{DirectoryListingResponse: response, Items: items})
+			}
+		}
 
 		// Use secure logging with sanitized data
 		logFields := log.LogFields{
@@ -191,6 +215,6 @@ func V1ListDirectory(engine *core.Engine, authorizer auth.Authorizer, logger *za
 			zap.String("user_id", logFields.UserID),
 			zap.Bool("recursive", recursive),
 			zap.Int("max_depth", maxDepth),
-			zap.Int("items_count", len(children)))
+			zap.Int("items_count", count))
 	}
 }
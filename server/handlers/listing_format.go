@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// Formats of listings, chosen with format= or the Accept header
+const (
+	formatJSON   = "json"
+	formatNDJSON = "ndjson" // One entry per line, sent as entries are read
+)
+
+// ndjsonContentType is the media type of NDJSON listings
+const ndjsonContentType = "application/x-ndjson"
+
+// ndjsonFlushEvery is how many NDJSON entries are written between flushes
+const ndjsonFlushEvery = 1000
+
+// newFileInfo returns the listing entry of md
+func newFileInfo(md *metadata.Metadata) FileInfo {
+	return FileInfo{
+		Name:  md.Name,
+		Path:  md.Path,
+		Type:  md.Type,
+		Size:  md.Size,
+		Mode:  md.Mode,
+		UID:   md.UID,
+		GID:   md.GID,
+		MTime: md.MTime.Format("2006-01-02T15:04:05Z07:00"),
+	}
+}
+
+// listingFormat parses the format= parameter of a listing. Without it, NDJSON
+// is sent to clients that accept it.
+func listingFormat(w http.ResponseWriter, r *http.Request) (string, error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "":
+		w.Header().Add("Vary", "Accept")
+		if accepts(r, ndjsonContentType) {
+			return formatNDJSON, nil
+		}
+		return formatJSON, nil
+	case formatJSON:
+		return formatJSON, nil
+	case formatNDJSON:
+		return formatNDJSON, nil
+	default:
+		return "", &customError{message: fmt.Sprintf("format must be %s or %s", formatJSON, formatNDJSON)}
+	}
+}
+
+// accepts reports whether the Accept headers of r list mediaType
+func accepts(r *http.Request, mediaType string) bool {
+	for _, header := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(header, ",") {
+			accepted, params, err := mime.ParseMediaType(mediaRange)
+			if err != nil || accepted != mediaType {
+				continue
+			}
+			if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+				continue // Refused
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// fieldSelection is the fields= parameter of listing and stat endpoints: the
+// JSON fields kept of each entry, in order. Nil keeps them all.
+type fieldSelection []string
+
+// parseFields parses the fields= parameter of r, which may name the JSON
+// fields of the struct entry
+func parseFields(r *http.Request, entry any) (fieldSelection, error) {
+	value := r.URL.Query().Get("fields")
+	if value == "" {
+		return nil, nil
+	}
+	known := jsonFieldNames(reflect.TypeOf(entry))
+	var fields fieldSelection
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(known, name) {
+			return nil, &customError{message: fmt.Sprintf("unknown field %q, expected some of %s", name, strings.Join(known, ","))}
+		}
+		if !slices.Contains(fields, name) {
+			fields = append(fields, name)
+		}
+	}
+	return fields, nil
+}
+
+// jsonFieldNames returns the names the fields of struct type t are encoded
+// with
+func jsonFieldNames(t reflect.Type) []string {
+	names := make([]string, 0, t.NumField())
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// encode returns the JSON of entry with the selected fields only. Fields
+// entry leaves out when empty stay out.
+func (f fieldSelection) encode(entry any) (json.RawMessage, error) {
+	data, err := json.Marshal(entry)
+	if err != nil || f == nil {
+		return data, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for _, name := range f {
+		value, ok := all[name]
+		if !ok {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// encodeAll returns the JSON of each entry with the selected fields only
+func (f fieldSelection) encodeAll(entries []FileInfo) ([]json.RawMessage, error) {
+	encoded := make([]json.RawMessage, 0, len(entries))
+	for _, entry := range entries {
+		data, err := f.encode(entry)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, data)
+	}
+	return encoded, nil
+}
+
+// sendListing sends the JSON body of a listing, with an ETag of it so an
+// unchanged listing can be revalidated with If-None-Match
+func sendListing(w http.ResponseWriter, r *http.Request, logger *zap.Logger, body any) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+		return
+	}
+	data = append(data, '\n')
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+// ndjsonWriter sends a listing one entry per line. The response starts with
+// the first entry, so an error before it is still sent as an error response.
+// Entries found are sent while the rest are still being read, so neither the
+// server nor the client holds the whole listing.
+type ndjsonWriter struct {
+	w       http.ResponseWriter
+	fields  fieldSelection
+	started bool
+	written int
+}
+
+func newNDJSONWriter(w http.ResponseWriter, fields fieldSelection) *ndjsonWriter {
+	return &ndjsonWriter{w: w, fields: fields}
+}
+
+// write sends the line of entry
+func (n *ndjsonWriter) write(entry any) error {
+	line, err := n.fields.encode(entry)
+	if err != nil {
+		return err
+	}
+	n.start()
+	if _, err := n.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	n.written++
+	if n.written%ndjsonFlushEvery == 0 {
+		_ = http.NewResponseController(n.w).Flush()
+	}
+	return nil
+}
+
+func (n *ndjsonWriter) start() {
+	if !n.started {
+		n.started = true
+		n.w.Header().Set("Content-Type", ndjsonContentType)
+		n.w.WriteHeader(http.StatusOK)
+	}
+}
+
+// finish ends the listing after err, if any. A failure once entries were
+// sent closes the connection, so the client sees the listing is incomplete
+// rather than a shorter one.
+func (n *ndjsonWriter) finish(logger *zap.Logger, path string, err error) {
+	if err == nil {
+		n.start()
+		return
+	}
+	if !n.started {
+		SendErrorResponse(n.w, logger, err, http.StatusInternalServerError)
+		return
+	}
+	logger.Error("Directory listing failed after it started",
+		zap.String("path", path),
+		zap.Int("entries_sent", n.written),
+		zap.Error(err))
+	panic(http.ErrAbortHandler)
+}
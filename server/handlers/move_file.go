@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/server/middleware"
+)
+
+// filesRoutePrefix is stripped from Destination headers naming a full API URL
+const filesRoutePrefix = "/v1/files"
+
+// V1MoveFile handles MOVE /files/{path} requests. The Destination header
+// names the new path, either as a path or as the URL of the destination on
+// this API. Moving a directory moves everything below it, wherever its
+// content is stored. OpenAPI has no MOVE method, so the endpoint is only
+// described in the API reference.
+func V1MoveFile(engine *core.Engine, authorizer auth.Authorizer, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		source := ParseFilePath(chi.URLParam(r, "*"))
+		if source.IsInvalid {
+			SendErrorResponse(w, logger, &customError{message: "invalid path"}, http.StatusBadRequest)
+			return
+		}
+		destination, ok := destinationPath(r.Header.Get("Destination"))
+		if !ok {
+			SendErrorResponse(w, logger, &customError{message: "invalid destination"}, http.StatusBadRequest)
+			return
+		}
+
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			SendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
+			return
+		}
+
+		from := strings.TrimSuffix(source.FullPath, "/")
+		to := strings.TrimSuffix(destination.FullPath, "/")
+		if from == "" || to == "" {
+			SendErrorResponse(w, logger, &customError{message: "the root directory cannot be moved or replaced"}, http.StatusBadRequest)
+			return
+		}
+
+		// Moving removes the source and creates the destination
+		if err := authorizer.Authorize(r.Context(), userID, from, auth.DeletePerm); err != nil {
+			SendErrorResponse(w, logger, err, http.StatusForbidden)
+			return
+		}
+		if err := authorizer.Authorize(r.Context(), userID, to, auth.WritePerm); err != nil {
+			SendErrorResponse(w, logger, err, http.StatusForbidden)
+			return
+		}
+
+		if err := engine.RenameSubtree(r.Context(), from, to); err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		logger.Info("File/directory moved",
+			zap.String("from", from),
+			zap.String("to", to),
+			zap.String("user_id", userID))
+	}
+}
+
+// destinationPath parses a Destination header, accepting a path or an
+// absolute URL, with or without the /v1/files prefix
+func destinationPath(header string) (PathInfo, bool) {
+	if header == "" {
+		return PathInfo{}, false
+	}
+	u, err := url.Parse(header)
+	if err != nil {
+		return PathInfo{}, false
+	}
+	p := u.Path
+	if rest, found := strings.CutPrefix(p, filesRoutePrefix+"/"); found {
+		p = rest
+	}
+	info := ParseFilePath(p)
+	return info, !info.IsInvalid
+}
@@ -0,0 +1,868 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/config"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/metadata"
+	"github.com/ebogdum/callfs/metrics"
+	"github.com/ebogdum/callfs/nfs"
+)
+
+// ACCESS3 bits of NFSv3 ACCESS calls
+const (
+	nfsAccessRead    = 0x01
+	nfsAccessLookup  = 0x02
+	nfsAccessModify  = 0x04
+	nfsAccessExtend  = 0x08
+	nfsAccessDelete  = 0x10
+	nfsAccessExecute = 0x20
+)
+
+// nfsStatuses are the NFS statuses of errors, by the code an ErrorResponse
+// would carry. Errors of other codes are answered with NFS3ERR_IO.
+var nfsStatuses = map[string]nfs.Status{
+	CodeFileNotFound:         nfs.ErrNoEnt,
+	CodeAuthenticationFailed: nfs.ErrAccess,
+	CodePermissionDenied:     nfs.ErrAccess,
+	CodeImmutable:            nfs.ErrPerm,
+	CodeReadOnly:             nfs.ErrROFS,
+	CodeFileAlreadyExists:    nfs.ErrExist,
+	CodeDirectoryNotEmpty:    nfs.ErrNotEmpty,
+	CodeNotADirectory:        nfs.ErrNotDir,
+	CodeFileTooLarge:         nfs.ErrFBig,
+	CodeInsufficientStorage:  nfs.ErrNoSpc,
+	CodeBadRequest:           nfs.ErrInval,
+	CodeInvalidAttributes:    nfs.ErrInval,
+	CodeInvalidRename:        nfs.ErrInval,
+	CodeNotImplemented:       nfs.ErrNotSupp,
+	// Clients retry these later
+	CodePeerUnavailable:    nfs.ErrJukebox,
+	CodeBackendUnavailable: nfs.ErrJukebox,
+	CodeBackendBusy:        nfs.ErrJukebox,
+	CodeScanFailed:         nfs.ErrJukebox,
+	CodeHookFailed:         nfs.ErrJukebox,
+	CodeTimeout:            nfs.ErrJukebox,
+}
+
+// NFSFileSystem exports the namespace of the engine over NFS. Every client
+// acts as the configured CallFS user, with its permissions, whatever user
+// its AUTH_SYS credentials name. File handles carry metadata IDs.
+//
+// Files are stored whole, so writes are held in a spool file until the
+// client commits them or stops writing for the flush delay, and a read or
+// write of another client through the REST API meanwhile sees the stored
+// content.
+type NFSFileSystem struct {
+	engine        *core.Engine
+	authorizer    auth.Authorizer
+	backendConfig *config.BackendConfig
+	serverConfig  *config.ServerConfig
+	config        config.NFSConfig
+	logger        *zap.Logger
+
+	handles *nfsHandles
+	mu      sync.Mutex
+	pending map[int64]*nfsPendingWrite
+}
+
+// NewNFSFileSystem returns the NFS export of engine
+func NewNFSFileSystem(engine *core.Engine, authorizer auth.Authorizer, backendConfig *config.BackendConfig, serverConfig *config.ServerConfig, cfg config.NFSConfig, logger *zap.Logger) *NFSFileSystem {
+	return &NFSFileSystem{
+		engine:        engine,
+		authorizer:    authorizer,
+		backendConfig: backendConfig,
+		serverConfig:  serverConfig,
+		config:        cfg,
+		logger:        logger,
+		handles:       &nfsHandles{paths: make(map[int64]string)},
+		pending:       make(map[int64]*nfsPendingWrite),
+	}
+}
+
+// Mount returns the handle of the directory at mountPath
+func (f *NFSFileSystem) Mount(ctx context.Context, mountPath string) ([]byte, error) {
+	pathInfo := ParseFilePath(mountPath)
+	if pathInfo.IsInvalid {
+		return nil, nfs.ErrNoEnt
+	}
+	dirPath := pathInfo.FullPath
+	if dirPath != "/" {
+		dirPath = strings.TrimSuffix(dirPath, "/")
+	}
+	md, err := f.engine.GetMetadata(ctx, dirPath)
+	if err != nil {
+		return nil, f.nfsError(err)
+	}
+	if md.Type != "directory" {
+		return nil, nfs.ErrNotDir
+	}
+	if err := f.authorize(ctx, dirPath, auth.SearchPerm); err != nil {
+		return nil, err
+	}
+	return f.handles.record(md), nil
+}
+
+// GetAttr returns the attributes of a file, with the size of the writes
+// not yet stored
+func (f *NFSFileSystem) GetAttr(ctx context.Context, fh []byte) (*nfs.Attr, error) {
+	md, err := f.resolve(ctx, fh)
+	if err != nil {
+		return nil, err
+	}
+	return f.attr(md), nil
+}
+
+// SetAttr changes the mode, owner, times or size of a file, with the
+// permissions chmod, chown, utimes and truncate need
+func (f *NFSFileSystem) SetAttr(ctx context.Context, fh []byte, attrs nfs.SetAttrs) error {
+	md, err := f.resolve(ctx, fh)
+	if err != nil {
+		return err
+	}
+	if attrs.IsZero() {
+		return nil
+	}
+	if err := f.checkWritable(md.Path); err != nil {
+		return err
+	}
+
+	if attrs.Size != nil {
+		if err := f.truncate(ctx, md, int64(*attrs.Size)); err != nil {
+			return err
+		}
+	}
+
+	fileAttrs := nfsFileAttributes(attrs)
+	if fileAttrs.IsZero() {
+		return nil
+	}
+	// Like utimes(2) without times, setting the current time needs only
+	// write access
+	if attrs.ServerTimes && fileAttrs.Mode == nil && !fileAttrs.ChangesOwner(md) {
+		err = f.authorize(ctx, md.Path, auth.WritePerm)
+	} else {
+		err = authorizeFileAttributes(ctx, f.authorizer, f.config.User, md.Path, fileAttrs, md)
+	}
+	if err != nil {
+		return f.nfsError(err)
+	}
+	// Storing held writes afterwards would move the times set
+	if err := f.settle(ctx, nfsID(md)); err != nil {
+		return f.nfsError(err)
+	}
+	if _, err := f.engine.SetAttributes(ctx, md.Path, fileAttrs); err != nil {
+		return f.nfsError(err)
+	}
+	return nil
+}
+
+// truncate sets the size of a file, storing it at once
+func (f *NFSFileSystem) truncate(ctx context.Context, md *metadata.Metadata, size int64) error {
+	if md.Type != "file" {
+		return nfs.ErrIsDir
+	}
+	if err := f.authorize(ctx, md.Path, auth.WritePerm); err != nil {
+		return err
+	}
+	if md.Held(time.Now()) {
+		return f.nfsError(core.ErrHeld)
+	}
+	if size < 0 || size > maxFileSizeForPath(f.serverConfig, md.Path) {
+		return nfs.ErrFBig
+	}
+	p, err := f.pendingWrite(ctx, md, size > 0)
+	if err != nil {
+		return f.nfsError(err)
+	}
+	defer p.mu.Unlock()
+	if err := p.file.Truncate(size); err != nil {
+		return f.nfsError(err)
+	}
+	p.size, p.mtime, p.dirty = size, time.Now(), true
+	return f.nfsError(f.flush(ctx, p))
+}
+
+// Lookup returns the handle of the entry name of dir, which the caller
+// must be allowed to search
+func (f *NFSFileSystem) Lookup(ctx context.Context, dir []byte, name string) ([]byte, error) {
+	dirMd, err := f.resolveDir(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.authorize(ctx, dirMd.Path, auth.SearchPerm); err != nil {
+		return nil, err
+	}
+	entryPath := dirMd.Path
+	switch name {
+	case ".":
+	case "..":
+		entryPath = path.Dir(dirMd.Path)
+	default:
+		if entryPath, err = childPath(dirMd, name); err != nil {
+			return nil, err
+		}
+	}
+	md, err := f.engine.GetMetadata(ctx, entryPath)
+	if err != nil {
+		return nil, f.nfsError(err)
+	}
+	return f.handles.record(md), nil
+}
+
+// Access returns the ACCESS3 bits of mask the configured user holds on a
+// file, as the authorizer checks them
+func (f *NFSFileSystem) Access(ctx context.Context, fh []byte, mask uint32) (uint32, error) {
+	md, err := f.resolve(ctx, fh)
+	if err != nil {
+		return 0, err
+	}
+	perms := map[uint32]auth.PermissionType{
+		nfsAccessRead:    auth.ReadPerm,
+		nfsAccessModify:  auth.WritePerm,
+		nfsAccessExtend:  auth.WritePerm,
+		nfsAccessExecute: auth.SearchPerm,
+	}
+	if md.Type == "directory" {
+		perms[nfsAccessLookup] = auth.SearchPerm
+		perms[nfsAccessDelete] = auth.WritePerm
+		delete(perms, nfsAccessExecute)
+	}
+	writable := f.checkWritable(md.Path) == nil && !f.engine.IsReadOnly(md.Path)
+
+	var granted uint32
+	allowed := make(map[auth.PermissionType]bool)
+	for bit, perm := range perms {
+		if mask&bit == 0 {
+			continue
+		}
+		if perm == auth.WritePerm && !writable {
+			continue
+		}
+		ok, checked := allowed[perm]
+		if !checked {
+			ok = f.authorizer.Authorize(ctx, f.config.User, md.Path, perm) == nil
+			allowed[perm] = ok
+		}
+		if ok {
+			granted |= bit
+		}
+	}
+	return granted, nil
+}
+
+// ReadLink returns the target of a symlink
+func (f *NFSFileSystem) ReadLink(ctx context.Context, fh []byte) (string, error) {
+	md, err := f.resolve(ctx, fh)
+	if err != nil {
+		return "", err
+	}
+	if md.Type != "symlink" || md.SymlinkTarget == nil {
+		return "", nfs.ErrInval
+	}
+	return *md.SymlinkTarget, nil
+}
+
+// Read returns up to count bytes of a file from offset, including the
+// writes not yet stored
+func (f *NFSFileSystem) Read(ctx context.Context, fh []byte, offset uint64, count uint32) ([]byte, bool, error) {
+	md, err := f.resolve(ctx, fh)
+	if err != nil {
+		return nil, false, err
+	}
+	if md.Type == "directory" {
+		return nil, false, nfs.ErrIsDir
+	}
+	if err := f.authorize(ctx, md.Path, auth.ReadPerm); err != nil {
+		return nil, false, err
+	}
+
+	if p := f.existingWrite(nfsID(md)); p != nil {
+		defer p.mu.Unlock()
+		return f.readSpooled(p.file, p.size, offset, count)
+	}
+
+	// Content rewritten by pre-read hooks has a size of its own, not that of
+	// the stored file
+	processed, err := f.engine.OpenProcessed(ctx, md)
+	if err != nil {
+		return nil, false, f.nfsError(err)
+	}
+	var data []byte
+	var eof bool
+	if processed != nil {
+		defer processed.Close()
+		data, eof, err = f.readSpooled(processed, processed.Size(), offset, count)
+	} else {
+		data, eof, err = f.readStored(ctx, md, offset, count)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	// A read from the start counts as a download
+	if offset == 0 {
+		metrics.FileOperationsTotal.WithLabelValues("read", md.BackendType).Inc()
+		f.engine.RecordAccess(md.Path, md.Size, false)
+	}
+	return data, eof, nil
+}
+
+// readStored returns up to count bytes from offset of the stored content of
+// the file of md
+func (f *NFSFileSystem) readStored(ctx context.Context, md *metadata.Metadata, offset uint64, count uint32) ([]byte, bool, error) {
+	if offset >= uint64(md.Size) {
+		return nil, true, nil
+	}
+	length := min(int64(count), md.Size-int64(offset))
+	// Every read goes through the same path, so a whole file and its pieces
+	// are read alike
+	reader, err := f.engine.GetFileRange(ctx, md.Path, int64(offset), length)
+	if err != nil {
+		return nil, false, f.nfsError(err)
+	}
+	defer reader.Close()
+	data := make([]byte, length)
+	n, err := io.ReadFull(reader, data)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, false, f.nfsError(err)
+	}
+	return data[:n], int64(offset)+int64(n) >= md.Size, nil
+}
+
+// readSpooled returns up to count bytes from offset of content of size bytes
+func (f *NFSFileSystem) readSpooled(content io.ReaderAt, size int64, offset uint64, count uint32) ([]byte, bool, error) {
+	if offset >= uint64(size) {
+		return nil, true, nil
+	}
+	data := make([]byte, min(int64(count), size-int64(offset)))
+	n, err := content.ReadAt(data, int64(offset))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, false, f.nfsError(err)
+	}
+	return data[:n], int64(offset)+int64(n) >= size, nil
+}
+
+// Write changes a file in its spool file, storing it at once when stable
+func (f *NFSFileSystem) Write(ctx context.Context, fh []byte, offset uint64, data []byte, stable bool) error {
+	md, err := f.resolve(ctx, fh)
+	if err != nil {
+		return err
+	}
+	if md.Type != "file" {
+		return nfs.ErrIsDir
+	}
+	if err := f.authorize(ctx, md.Path, auth.WritePerm); err != nil {
+		return err
+	}
+	if err := f.checkWritable(md.Path); err != nil {
+		return err
+	}
+	if md.Held(time.Now()) {
+		return f.nfsError(core.ErrHeld)
+	}
+	end := int64(offset) + int64(len(data))
+	if end > maxFileSizeForPath(f.serverConfig, md.Path) {
+		return nfs.ErrFBig
+	}
+
+	p, err := f.pendingWrite(ctx, md, true)
+	if err != nil {
+		return f.nfsError(err)
+	}
+	defer p.mu.Unlock()
+	if _, err := p.file.WriteAt(data, int64(offset)); err != nil {
+		return f.nfsError(err)
+	}
+	p.size, p.mtime, p.dirty = max(p.size, end), time.Now(), true
+	p.timer.Reset(f.config.FlushDelay)
+	if stable {
+		return f.nfsError(f.flush(ctx, p))
+	}
+	return nil
+}
+
+// Commit stores the writes to a file held in its spool file
+func (f *NFSFileSystem) Commit(ctx context.Context, fh []byte) error {
+	md, err := f.resolve(ctx, fh)
+	if err != nil {
+		return err
+	}
+	p := f.existingWrite(nfsID(md))
+	if p == nil {
+		return nil
+	}
+	defer p.mu.Unlock()
+	return f.nfsError(f.flush(ctx, p))
+}
+
+// Create creates an empty file, as POST /v1/files does, or gives an
+// existing one attrs
+func (f *NFSFileSystem) Create(ctx context.Context, dir []byte, name string, exclusive bool, attrs nfs.SetAttrs) ([]byte, error) {
+	dirMd, err := f.resolveDir(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	filePath, err := childPath(dirMd, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.authorize(ctx, filePath, auth.WritePerm); err != nil {
+		return nil, err
+	}
+	if err := f.checkWritable(filePath); err != nil {
+		return nil, err
+	}
+
+	existing, err := f.engine.GetMetadata(ctx, filePath)
+	switch {
+	case err == nil:
+		if exclusive {
+			return nil, nfs.ErrExist
+		}
+		if existing.Type != "file" {
+			return nil, nfs.ErrIsDir
+		}
+		fh := f.handles.record(existing)
+		return fh, f.SetAttr(ctx, fh, attrs)
+	case !errors.Is(err, metadata.ErrNotFound):
+		return nil, f.nfsError(err)
+	}
+
+	fileAttrs := nfsFileAttributes(attrs)
+	md, err := f.newInode(ctx, filePath, "file", &fileAttrs)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.engine.CreateFile(ctx, filePath, bytes.NewReader(nil), 0, md); err != nil {
+		return nil, f.nfsError(err)
+	}
+	if err := applyFileAttributes(ctx, f.engine, filePath, fileAttrs); err != nil {
+		return nil, f.nfsError(err)
+	}
+	created, err := f.engine.GetMetadata(ctx, filePath)
+	if err != nil {
+		return nil, f.nfsError(err)
+	}
+
+	f.logger.Info("File created over NFS",
+		zap.String("path", filePath),
+		zap.String("user_id", f.config.User))
+	return f.handles.record(created), nil
+}
+
+// Mkdir creates a directory, as POST /v1/directories does
+func (f *NFSFileSystem) Mkdir(ctx context.Context, dir []byte, name string, attrs nfs.SetAttrs) ([]byte, error) {
+	dirMd, err := f.resolveDir(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	dirPath, err := childPath(dirMd, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.authorize(ctx, dirPath, auth.WritePerm); err != nil {
+		return nil, err
+	}
+	if err := f.checkWritable(dirPath); err != nil {
+		return nil, err
+	}
+
+	fileAttrs := nfsFileAttributes(attrs)
+	md, err := f.newInode(ctx, dirPath, "directory", &fileAttrs)
+	if err != nil {
+		return nil, err
+	}
+	created, err := f.engine.MakeDirectory(ctx, dirPath, false, md)
+	if err != nil {
+		return nil, f.nfsError(err)
+	}
+	if len(created) == 0 {
+		return nil, nfs.ErrExist
+	}
+	if err := applyFileAttributes(ctx, f.engine, dirPath, fileAttrs); err != nil {
+		return nil, f.nfsError(err)
+	}
+	if md, err = f.engine.GetMetadata(ctx, dirPath); err != nil {
+		return nil, f.nfsError(err)
+	}
+
+	f.logger.Info("Directory created over NFS",
+		zap.String("path", dirPath),
+		zap.String("user_id", f.config.User))
+	return f.handles.record(md), nil
+}
+
+// newInode returns the metadata of a file or directory the configured user
+// creates at inodePath, once it may give it attrs
+func (f *NFSFileSystem) newInode(ctx context.Context, inodePath, inodeType string, attrs *core.FileAttributes) (*metadata.Metadata, error) {
+	defaults, err := inodeDefaults(ctx, f.authorizer, f.serverConfig, f.config.User)
+	if err != nil {
+		return nil, f.nfsError(err)
+	}
+	if err := authorizeNewFileAttributes(ctx, f.authorizer, f.config.User, inodePath, *attrs, defaults); err != nil {
+		return nil, f.nfsError(err)
+	}
+	now := time.Now()
+	return newInodeMetadata(defaults, &metadata.Metadata{
+		Name:        path.Base(inodePath),
+		Type:        inodeType,
+		BackendType: f.backendConfig.DefaultBackend,
+		ATime:       now,
+		MTime:       now,
+		CTime:       now,
+	}, attrs), nil
+}
+
+// Remove deletes a file, as DELETE /v1/files does
+func (f *NFSFileSystem) Remove(ctx context.Context, dir []byte, name string) error {
+	return f.delete(ctx, dir, name, false)
+}
+
+// Rmdir deletes an empty directory
+func (f *NFSFileSystem) Rmdir(ctx context.Context, dir []byte, name string) error {
+	return f.delete(ctx, dir, name, true)
+}
+
+func (f *NFSFileSystem) delete(ctx context.Context, dir []byte, name string, directory bool) error {
+	dirMd, err := f.resolveDir(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if directory && (name == "." || name == "..") {
+		return nfs.ErrInval
+	}
+	entryPath, err := childPath(dirMd, name)
+	if err != nil {
+		return err
+	}
+	md, err := f.engine.GetMetadata(ctx, entryPath)
+	if err != nil {
+		return f.nfsError(err)
+	}
+	switch {
+	case directory && md.Type != "directory":
+		return nfs.ErrNotDir
+	case !directory && md.Type == "directory":
+		return nfs.ErrIsDir
+	}
+	return f.deleteEntry(ctx, md)
+}
+
+// deleteEntry deletes md, on the instance that owns it
+func (f *NFSFileSystem) deleteEntry(ctx context.Context, md *metadata.Metadata) error {
+	if err := f.authorize(ctx, md.Path, auth.DeletePerm); err != nil {
+		return err
+	}
+	if err := f.checkWritable(md.Path); err != nil {
+		return err
+	}
+
+	id := nfsID(md)
+	f.discard(id)
+	if md.CallFSInstanceID != nil && *md.CallFSInstanceID != f.engine.GetCurrentInstanceID() {
+		if err := f.engine.DeleteFileOnInstance(ctx, *md.CallFSInstanceID, md.Path); err != nil {
+			return f.nfsError(fmt.Errorf("failed to proxy request to owning server: %w", err))
+		}
+	} else if err := f.engine.DeleteFile(ctx, md.Path); err != nil {
+		return f.nfsError(err)
+	}
+	f.handles.forget(id)
+
+	f.logger.Info("File/directory removed over NFS",
+		zap.String("path", md.Path),
+		zap.String("user_id", f.config.User),
+		zap.String("type", md.Type))
+	return nil
+}
+
+// Rename moves an entry, as MOVE /v1/files does, replacing a file or empty
+// directory at the destination as rename(2) does. The destination is
+// removed first, so the replacement is not atomic.
+func (f *NFSFileSystem) Rename(ctx context.Context, fromDir []byte, fromName string, toDir []byte, toName string) error {
+	fromDirMd, err := f.resolveDir(ctx, fromDir)
+	if err != nil {
+		return err
+	}
+	toDirMd, err := f.resolveDir(ctx, toDir)
+	if err != nil {
+		return err
+	}
+	from, err := childPath(fromDirMd, fromName)
+	if err != nil {
+		return err
+	}
+	to, err := childPath(toDirMd, toName)
+	if err != nil {
+		return err
+	}
+	if from == to {
+		return nil
+	}
+
+	md, err := f.engine.GetMetadata(ctx, from)
+	if err != nil {
+		return f.nfsError(err)
+	}
+	// Moving removes the source and creates the destination
+	if err := f.authorize(ctx, from, auth.DeletePerm); err != nil {
+		return err
+	}
+	if err := f.authorize(ctx, to, auth.WritePerm); err != nil {
+		return err
+	}
+	if err := f.checkWritable(from); err != nil {
+		return err
+	}
+	if err := f.checkWritable(to); err != nil {
+		return err
+	}
+
+	target, err := f.engine.GetMetadata(ctx, to)
+	switch {
+	case err == nil:
+		if nfsID(target) == nfsID(md) {
+			return nil
+		}
+		if md.Type == "directory" && target.Type != "directory" {
+			return nfs.ErrNotDir
+		}
+		if md.Type != "directory" && target.Type == "directory" {
+			return nfs.ErrIsDir
+		}
+		if err := f.deleteEntry(ctx, target); err != nil {
+			return err
+		}
+	case !errors.Is(err, metadata.ErrNotFound):
+		return f.nfsError(err)
+	}
+
+	// Held writes are stored under the old path
+	if err := f.settle(ctx, nfsID(md)); err != nil {
+		return f.nfsError(err)
+	}
+	if err := f.engine.RenameSubtree(ctx, from, to); err != nil {
+		return f.nfsError(err)
+	}
+	f.handles.rename(from, to)
+
+	f.logger.Info("File/directory moved over NFS",
+		zap.String("from", from),
+		zap.String("to", to),
+		zap.String("user_id", f.config.User))
+	return nil
+}
+
+// ReadDir returns the entries of a directory the caller may read
+func (f *NFSFileSystem) ReadDir(ctx context.Context, dir []byte) ([]nfs.DirEntry, error) {
+	dirMd, err := f.resolveDir(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.authorize(ctx, dirMd.Path, auth.ReadPerm); err != nil {
+		return nil, err
+	}
+	children, err := f.engine.ListDirectory(ctx, dirMd.Path)
+	if err != nil {
+		return nil, f.nfsError(err)
+	}
+	entries := make([]nfs.DirEntry, 0, len(children))
+	for _, child := range children {
+		entries = append(entries, nfs.DirEntry{
+			Name:   child.Name,
+			Handle: f.handles.record(child),
+			Attr:   f.attr(child),
+		})
+	}
+	return entries, nil
+}
+
+// FSStat reports the space of the backends, as GET /v1/statfs does. Space
+// that is unknown or unlimited is reported as 1 PiB free.
+func (f *NFSFileSystem) FSStat(ctx context.Context, fh []byte) (*nfs.FSStat, error) {
+	if _, err := f.resolve(ctx, fh); err != nil {
+		return nil, err
+	}
+	stats, err := f.engine.StatFS(ctx)
+	if err != nil {
+		return nil, f.nfsError(err)
+	}
+	const unlimited = 1 << 50
+	var total, free int64
+	for _, backend := range stats.Backends {
+		if backend.TotalBytes > 0 {
+			total += backend.TotalBytes
+			free += backend.FreeBytes
+		}
+	}
+	if total == 0 {
+		total, free = unlimited, unlimited
+	}
+	files := uint64(stats.Inodes.Total)
+	return &nfs.FSStat{
+		TotalBytes: uint64(total),
+		FreeBytes:  uint64(free),
+		AvailBytes: uint64(free),
+		TotalFiles: files + unlimited,
+		FreeFiles:  unlimited,
+		AvailFiles: unlimited,
+	}, nil
+}
+
+// resolve returns the metadata of the entry of a file handle, or
+// NFS3ERR_STALE once the entry is gone
+func (f *NFSFileSystem) resolve(ctx context.Context, fh []byte) (*metadata.Metadata, error) {
+	id, ok := parseNFSHandle(fh)
+	if !ok {
+		return nil, nfs.ErrBadHandle
+	}
+	entryPath, ok := f.handles.path(id)
+	if !ok {
+		f.rebuildHandles(ctx)
+		if entryPath, ok = f.handles.path(id); !ok {
+			return nil, nfs.ErrStale
+		}
+	}
+	md, err := f.engine.GetMetadata(ctx, entryPath)
+	if errors.Is(err, metadata.ErrNotFound) || (err == nil && (id > 0) != (md.ID > 0)) || (err == nil && id > 0 && md.ID != id) {
+		f.handles.forget(id)
+		return nil, nfs.ErrStale
+	}
+	if err != nil {
+		return nil, f.nfsError(err)
+	}
+	return md, nil
+}
+
+// resolveDir is resolve for handles that must be of a directory
+func (f *NFSFileSystem) resolveDir(ctx context.Context, fh []byte) (*metadata.Metadata, error) {
+	md, err := f.resolve(ctx, fh)
+	if err != nil {
+		return nil, err
+	}
+	if md.Type != "directory" {
+		return nil, nfs.ErrNotDir
+	}
+	return md, nil
+}
+
+// authorize checks that the configured user is allowed perm on entryPath
+func (f *NFSFileSystem) authorize(ctx context.Context, entryPath string, perm auth.PermissionType) error {
+	if err := f.authorizer.Authorize(ctx, f.config.User, entryPath, perm); err != nil {
+		return f.nfsError(err)
+	}
+	return nil
+}
+
+// checkWritable refuses changes to entryPath while the instance drains or
+// the path is in a snapshot, as the write middleware of the REST API does.
+// Clients retry later while the instance drains. The engine refuses changes
+// to read-only paths itself.
+func (f *NFSFileSystem) checkWritable(entryPath string) error {
+	if f.engine.Draining() {
+		return nfs.ErrJukebox
+	}
+	if f.engine.IsSnapshotPath(entryPath) {
+		return nfs.ErrROFS
+	}
+	return nil
+}
+
+// attr returns the NFS attributes of md, with the size and mtime of the
+// writes to it not yet stored
+func (f *NFSFileSystem) attr(md *metadata.Metadata) *nfs.Attr {
+	mode, _ := strconv.ParseUint(md.Mode, 8, 32)
+	attr := &nfs.Attr{
+		Type:   nfs.TypeRegular,
+		Mode:   uint32(mode) & 0o7777,
+		NLink:  uint32(max(md.NLink, 1)),
+		UID:    uint32(md.UID),
+		GID:    uint32(md.GID),
+		Size:   uint64(max(md.Size, 0)),
+		FileID: uint64(nfsID(md)),
+		ATime:  md.ATime,
+		MTime:  md.MTime,
+		CTime:  md.CTime,
+	}
+	switch md.Type {
+	case "directory":
+		attr.Type, attr.NLink = nfs.TypeDirectory, 2
+	case "symlink":
+		attr.Type = nfs.TypeSymlink
+		if md.SymlinkTarget != nil {
+			attr.Size = uint64(len(*md.SymlinkTarget))
+		}
+	case "file":
+		if p := f.existingWrite(nfsID(md)); p != nil {
+			attr.Size, attr.MTime = uint64(p.size), p.mtime
+			p.mu.Unlock()
+		}
+	}
+	return attr
+}
+
+// nfsError returns the NFS status of err
+func (f *NFSFileSystem) nfsError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var status nfs.Status
+	if errors.As(err, &status) {
+		return status
+	}
+	_, code := classifyError(err, http.StatusInternalServerError)
+	if status, ok := nfsStatuses[code]; ok {
+		return status
+	}
+	f.logger.Error("NFS operation failed", zap.Error(err))
+	return nfs.ErrIO
+}
+
+// childPath returns the path of the entry name of the directory dirMd
+func childPath(dirMd *metadata.Metadata, name string) (string, error) {
+	switch {
+	case len(name) > 255:
+		return "", nfs.ErrNameTooLong
+	case name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\x00"):
+		return "", nfs.ErrInval
+	}
+	pathInfo := ParseFilePath(path.Join(dirMd.Path, name))
+	if pathInfo.IsInvalid {
+		return "", nfs.ErrInval
+	}
+	return strings.TrimSuffix(pathInfo.FullPath, "/"), nil
+}
+
+// nfsFileAttributes returns the mode, owner and times of attrs
+func nfsFileAttributes(attrs nfs.SetAttrs) core.FileAttributes {
+	var fileAttrs core.FileAttributes
+	if attrs.Mode != nil {
+		mode := os.FileMode(*attrs.Mode & 0o777)
+		fileAttrs.Mode = &mode
+	}
+	if attrs.UID != nil {
+		uid := int(*attrs.UID)
+		fileAttrs.UID = &uid
+	}
+	if attrs.GID != nil {
+		gid := int(*attrs.GID)
+		fileAttrs.GID = &gid
+	}
+	fileAttrs.ATime, fileAttrs.MTime = attrs.ATime, attrs.MTime
+	return fileAttrs
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/metadata"
+	"github.com/ebogdum/callfs/nfs"
+)
+
+func TestNFSHandleRoundTrip(t *testing.T) {
+	for _, md := range []*metadata.Metadata{{ID: 42, Path: "/a"}, {Path: "/raft/entry"}} {
+		fh := nfsHandle(nfsID(md))
+		if len(fh) > nfs.MaxHandleSize {
+			t.Fatalf("%s: handle of %d bytes", md.Path, len(fh))
+		}
+		id, ok := parseNFSHandle(fh)
+		if !ok || id != nfsID(md) {
+			t.Errorf("%s: parsed %d, %v, want %d", md.Path, id, ok, nfsID(md))
+		}
+	}
+	if id := nfsID(&metadata.Metadata{Path: "/raft/entry"}); id >= 0 {
+		t.Errorf("path-derived ID %d is not negative", id)
+	}
+	if _, ok := parseNFSHandle([]byte{2, 0, 0, 0, 0, 0, 0, 0, 1}); ok {
+		t.Error("handle of another version parsed")
+	}
+}
+
+func TestNFSHandlesRename(t *testing.T) {
+	h := &nfsHandles{paths: map[int64]string{1: "/a", 2: "/a/b", 3: "/ab", 4: "/a/b/c"}}
+	h.rename("/a", "/x/a")
+	want := map[int64]string{1: "/x/a", 2: "/x/a/b", 3: "/ab", 4: "/x/a/b/c"}
+	for id, path := range want {
+		if got, _ := h.path(id); got != path {
+			t.Errorf("entry %d at %q, want %q", id, got, path)
+		}
+	}
+}
+
+func TestNFSChildPath(t *testing.T) {
+	dir := &metadata.Metadata{Path: "/docs"}
+	tests := []struct {
+		name string
+		want string
+		err  error
+	}{
+		{"report.txt", "/docs/report.txt", nil},
+		{"", "", nfs.ErrInval},
+		{"..", "", nfs.ErrInval},
+		{"a/b", "", nfs.ErrInval},
+		{string(make([]byte, 256)), "", nfs.ErrNameTooLong},
+	}
+	for _, tt := range tests {
+		got, err := childPath(dir, tt.name)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("childPath(%q) = %q, %v, want %q, %v", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestNFSError(t *testing.T) {
+	f := &NFSFileSystem{logger: zap.NewNop()}
+	tests := []struct {
+		err  error
+		want nfs.Status
+	}{
+		{fmt.Errorf("failed to get metadata: %w", metadata.ErrNotFound), nfs.ErrNoEnt},
+		{metadata.ErrAlreadyExists, nfs.ErrExist},
+		{core.ErrDirectoryNotEmpty, nfs.ErrNotEmpty},
+		{core.ErrHeld, nfs.ErrPerm},
+		{nfs.ErrStale, nfs.ErrStale},
+		{errors.New("disk exploded"), nfs.ErrIO},
+	}
+	for _, tt := range tests {
+		if got := f.nfsError(tt.err); got != tt.want {
+			t.Errorf("nfsError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
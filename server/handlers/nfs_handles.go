@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// nfsHandleVersion starts every NFS file handle, for the layout to change
+const nfsHandleVersion byte = 1
+
+// nfsRebuildInterval is how often at most the handle table is rebuilt for
+// a handle it does not know, as after a restart
+const nfsRebuildInterval = time.Minute
+
+// nfsID returns the number of the NFS handle of md: its metadata ID, or for
+// stores that do not number entries a negative hash of its path
+func nfsID(md *metadata.Metadata) int64 {
+	if md.ID > 0 {
+		return md.ID
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(md.Path))
+	return -int64(h.Sum64()>>1) - 1
+}
+
+// nfsHandle returns the NFS file handle of an entry numbered id
+func nfsHandle(id int64) []byte {
+	return binary.BigEndian.AppendUint64([]byte{nfsHandleVersion}, uint64(id))
+}
+
+// parseNFSHandle returns the number of an NFS file handle
+func parseNFSHandle(fh []byte) (int64, bool) {
+	if len(fh) != 9 || fh[0] != nfsHandleVersion {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(fh[1:])), true
+}
+
+// nfsHandles maps the numbers of the NFS handles given to clients to the
+// paths of their entries. Entries are recorded as clients look them up, and
+// the table is rebuilt from the whole namespace for a handle issued before a
+// restart or by another instance.
+type nfsHandles struct {
+	mu      sync.Mutex
+	paths   map[int64]string
+	rebuilt time.Time
+}
+
+// record remembers the path of md and returns its handle
+func (h *nfsHandles) record(md *metadata.Metadata) []byte {
+	id := nfsID(md)
+	h.mu.Lock()
+	h.paths[id] = md.Path
+	h.mu.Unlock()
+	return nfsHandle(id)
+}
+
+func (h *nfsHandles) path(id int64) (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	path, ok := h.paths[id]
+	return path, ok
+}
+
+func (h *nfsHandles) forget(id int64) {
+	h.mu.Lock()
+	delete(h.paths, id)
+	h.mu.Unlock()
+}
+
+// rename moves the entries at or below from to the same place below to
+func (h *nfsHandles) rename(from, to string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, path := range h.paths {
+		if path == from {
+			h.paths[id] = to
+		} else if rest, ok := strings.CutPrefix(path, from+"/"); ok {
+			h.paths[id] = to + "/" + rest
+		}
+	}
+}
+
+// rebuildHandles records every entry of the namespace, unless the table was
+// rebuilt within nfsRebuildInterval
+func (f *NFSFileSystem) rebuildHandles(ctx context.Context) {
+	f.handles.mu.Lock()
+	if time.Since(f.handles.rebuilt) < nfsRebuildInterval {
+		f.handles.mu.Unlock()
+		return
+	}
+	f.handles.rebuilt = time.Now()
+	f.handles.mu.Unlock()
+
+	start := time.Now()
+	count := 0
+	record := func(md *metadata.Metadata) error {
+		f.handles.record(md)
+		count++
+		return nil
+	}
+	root, err := f.engine.GetMetadata(ctx, "/")
+	if err == nil {
+		_ = record(root)
+		err = f.engine.WalkDirectoryRecursive(ctx, "/", 1000, record)
+	}
+	if err != nil {
+		f.logger.Warn("Failed to rebuild the NFS handle table", zap.Int("entries", count), zap.Error(err))
+		return
+	}
+	f.logger.Info("NFS handle table rebuilt", zap.Int("entries", count), zap.Duration("duration", time.Since(start)))
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/metadata"
+	"github.com/ebogdum/callfs/nfs"
+)
+
+// nfsPendingWrite is the content of a file being written over NFS, held in
+// a spool file until it is committed or left idle, as files are stored
+// whole
+type nfsPendingWrite struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	size   int64
+	mtime  time.Time
+	dirty  bool // Holds changes not yet stored
+	closed bool // Released; a new write starts another
+	timer  *time.Timer
+}
+
+// pendingWrite returns, locked, the write in progress to md, starting one
+// with its content when load is set or empty otherwise
+func (f *NFSFileSystem) pendingWrite(ctx context.Context, md *metadata.Metadata, load bool) (*nfsPendingWrite, error) {
+	id := nfsID(md)
+	for {
+		f.mu.Lock()
+		p, ok := f.pending[id]
+		if !ok {
+			p = &nfsPendingWrite{path: md.Path, mtime: md.MTime}
+			p.mu.Lock()
+			f.pending[id] = p
+			f.mu.Unlock()
+			if err := f.openPendingWrite(ctx, p, md, load); err != nil {
+				f.release(id, p)
+				p.mu.Unlock()
+				return nil, err
+			}
+			p.timer = time.AfterFunc(f.config.FlushDelay, func() { f.expire(id, p) })
+			return p, nil
+		}
+		f.mu.Unlock()
+
+		p.mu.Lock()
+		if !p.closed {
+			return p, nil
+		}
+		p.mu.Unlock()
+	}
+}
+
+// existingWrite returns, locked, the write in progress to the entry
+// numbered id, or nil
+func (f *NFSFileSystem) existingWrite(id int64) *nfsPendingWrite {
+	f.mu.Lock()
+	p := f.pending[id]
+	f.mu.Unlock()
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	return p
+}
+
+func (f *NFSFileSystem) openPendingWrite(ctx context.Context, p *nfsPendingWrite, md *metadata.Metadata, load bool) error {
+	file, err := os.CreateTemp(f.config.SpoolDir, "callfs-nfs-*")
+	if err != nil {
+		return fmt.Errorf("failed to create NFS spool file: %w", err)
+	}
+	p.file = file
+	if !load || md.Size == 0 {
+		return nil
+	}
+	reader, err := f.engine.GetFile(ctx, md.Path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if p.size, err = io.Copy(file, reader); err != nil {
+		return fmt.Errorf("failed to spool %s for NFS writes: %w", md.Path, err)
+	}
+	return nil
+}
+
+// flush stores the content of p if it changed
+func (f *NFSFileSystem) flush(ctx context.Context, p *nfsPendingWrite) error {
+	if !p.dirty {
+		return nil
+	}
+	if err := f.storeContent(ctx, p.path, io.NewSectionReader(p.file, 0, p.size), p.size); err != nil {
+		return err
+	}
+	p.dirty = false
+	f.logger.Info("File written over NFS",
+		zap.String("path", p.path),
+		zap.String("user_id", f.config.User),
+		zap.Int64("size", p.size))
+	return nil
+}
+
+// storeContent replaces the content of the file at path, as PUT /v1/files
+// does
+func (f *NFSFileSystem) storeContent(ctx context.Context, path string, body io.Reader, size int64) error {
+	md, err := f.engine.GetMetadata(ctx, path)
+	if err != nil {
+		return err
+	}
+	if md.Type != "file" {
+		return nfs.ErrIsDir
+	}
+	return updateFileContent(ctx, f.engine, md, body, size, "")
+}
+
+// expire stores and releases a write left idle. A write that cannot be
+// stored is kept, for its commit to report the failure.
+func (f *NFSFileSystem) expire(id int64, p *nfsPendingWrite) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	if err := f.flush(auth.WithUserID(context.Background(), f.config.User), p); err != nil {
+		f.logger.Error("Failed to store idle NFS write", zap.String("path", p.path), zap.Error(err))
+		p.timer.Reset(f.config.FlushDelay)
+		return
+	}
+	f.release(id, p)
+}
+
+// release drops p, whose lock the caller holds, without storing it
+func (f *NFSFileSystem) release(id int64, p *nfsPendingWrite) {
+	f.mu.Lock()
+	if f.pending[id] == p {
+		delete(f.pending, id)
+	}
+	f.mu.Unlock()
+	p.closed = true
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	if p.file != nil {
+		_ = p.file.Close()
+		_ = os.Remove(p.file.Name())
+	}
+}
+
+// settle stores and releases the write in progress to the entry numbered
+// id, if any, before the entry is renamed
+func (f *NFSFileSystem) settle(ctx context.Context, id int64) error {
+	p := f.existingWrite(id)
+	if p == nil {
+		return nil
+	}
+	defer p.mu.Unlock()
+	if err := f.flush(ctx, p); err != nil {
+		return err
+	}
+	f.release(id, p)
+	return nil
+}
+
+// discard drops the write in progress to the entry numbered id, which is
+// being deleted
+func (f *NFSFileSystem) discard(id int64) {
+	if p := f.existingWrite(id); p != nil {
+		f.release(id, p)
+		p.mu.Unlock()
+	}
+}
+
+// Flush stores the writes in progress and releases them, as the server
+// stops
+func (f *NFSFileSystem) Flush(ctx context.Context) error {
+	f.mu.Lock()
+	ids := make([]int64, 0, len(f.pending))
+	for id := range f.pending {
+		ids = append(ids, id)
+	}
+	f.mu.Unlock()
+
+	var errs []error
+	for _, id := range ids {
+		if err := f.settle(ctx, id); err != nil {
+			f.logger.Error("Failed to store NFS write at shutdown", zap.Error(err))
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/core/log"
+)
+
+// OIDCLoginConfig tells the web file browser how to sign in with the OIDC
+// provider
+type OIDCLoginConfig struct {
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	ClientID              string   `json:"client_id"`
+	Scopes                []string `json:"scopes"`
+}
+
+// OIDCTokenRequest is an authorization code to redeem, obtained with PKCE
+type OIDCTokenRequest struct {
+	Code         string `json:"code"`
+	CodeVerifier string `json:"code_verifier"`
+	RedirectURI  string `json:"redirect_uri"`
+}
+
+// OIDCTokenResponse is the access token of a signed-in user
+type OIDCTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in,omitempty"`
+}
+
+// V1OIDCLoginConfig handles GET /ui/oidc/config
+// @Summary Describe OIDC sign-in
+// @Description Returns the provider's authorization endpoint, the client ID and the scopes the web file browser signs in with. Only served when OIDC is configured.
+// @Tags ui
+// @Produce json
+// @Success 200 {object} OIDCLoginConfig "Sign-in settings"
+// @Failure 503 {object} ErrorResponse "The provider could not be reached"
+// @Router /ui/oidc/config [get]
+func V1OIDCLoginConfig(oidc *auth.OIDCAuthenticator, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		authorization, _, err := oidc.LoginEndpoints(r.Context())
+		if err != nil {
+			logger.Warn("OIDC discovery failed", zap.Error(err))
+			SendErrorResponse(w, logger, err, http.StatusServiceUnavailable)
+			return
+		}
+		SendJSONResponse(w, OIDCLoginConfig{
+			AuthorizationEndpoint: authorization,
+			ClientID:              oidc.ClientID(),
+			Scopes:                oidc.Scopes(),
+		})
+	}
+}
+
+// V1OIDCLoginToken handles POST /ui/oidc/token
+// @Summary Redeem an OIDC authorization code
+// @Description Exchanges the authorization code the provider redirected the web file browser with for an access token, which is then used as the bearer token of /v1 requests. Only served when OIDC is configured.
+// @Tags ui
+// @Accept json
+// @Produce json
+// @Param request body OIDCTokenRequest true "Authorization code, PKCE verifier and redirect URI"
+// @Success 200 {object} OIDCTokenResponse "Access token"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "The code was not redeemed or the token is not accepted"
+// @Failure 429 {object} ErrorResponse "Rate limit exceeded"
+// @Router /ui/oidc/token [post]
+func V1OIDCLoginToken(oidc *auth.OIDCAuthenticator, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		var req OIDCTokenRequest
+		r.Body = http.MaxBytesReader(w, r.Body, 64<<10) // 64 KiB
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" || req.CodeVerifier == "" || req.RedirectURI == "" {
+			SendErrorResponse(w, logger, &customError{message: "code, code_verifier and redirect_uri are required"}, http.StatusBadRequest)
+			return
+		}
+
+		accessToken, expiresIn, err := oidc.ExchangeCode(r.Context(), req.Code, req.CodeVerifier, req.RedirectURI)
+		if err != nil {
+			// The provider's reasons stay in the log
+			logger.Warn("OIDC sign-in failed", zap.Error(err))
+			SendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
+			return
+		}
+		SendJSONResponse(w, OIDCTokenResponse{AccessToken: accessToken, ExpiresIn: expiresIn})
+	}
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"bytes"
 	"io"
 	"net/http"
 	"strconv"
@@ -13,6 +14,7 @@ import (
 	"github.com/ebogdum/callfs/auth"
 	"github.com/ebogdum/callfs/config"
 	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
 	"github.com/ebogdum/callfs/erasure"
 	"github.com/ebogdum/callfs/metadata"
 	"github.com/ebogdum/callfs/server/middleware"
@@ -50,33 +52,37 @@ func parseErasureOptions(r *http.Request) *erasure.StoreOptions {
 	return opts
 }
 
-// CrossServerConflictResponse represents a response when a file exists on another server
-type CrossServerConflictResponse struct {
-	Error        string `json:"error"`
-	ExistingPath string `json:"existing_path"`
-	InstanceID   string `json:"instance_id"`
-	BackendType  string `json:"backend_type"`
-	Suggestion   string `json:"suggestion"`
-	UpdateURL    string `json:"update_url,omitempty"`
-}
-
-// V1PostFileEnhanced handles POST /files/{path} requests with enhanced cross-server conflict detection
-// @Summary Create or update file or directory with cross-server conflict detection
-// @Description Creates a new file or directory. If the resource exists on another server, provides proper conflict resolution
+// V1PostFileEnhanced handles POST /files/{path} requests
+// @Summary Create file or directory
+// @Description Creates a new file or directory. Existing resources are reported the same way whichever instance owns them.
+// @Description X-CallFS-Create-Mode picks what happens when the path exists: exclusive (default, 409 for files), overwrite (replace the file as PUT does) or ignore-exists (200, left untouched)
 // @Tags files
 // @Security BearerAuth
 // @Param path path string true "File or directory path"
 // @Param file body string false "File content (for files) or directory creation request"
+// @Param op query string false "touch to create an empty file or update its times, mkdir to create a directory"
+// @Param parents query bool false "With op=mkdir, create missing parent directories too"
+// @Param X-CallFS-Create-Mode header string false "exclusive, overwrite or ignore-exists"
+// @Param X-CallFS-Mode header string false "Permission bits of the new file, such as 0640"
+// @Param X-CallFS-UID header integer false "Owning UID; changing it requires an admin key"
+// @Param X-CallFS-GID header integer false "Owning GID; changing it requires an admin key"
+// @Param X-CallFS-MTime header string false "Modification time, RFC 3339 or Unix seconds"
+// @Param X-CallFS-ATime header string false "Access time, RFC 3339 or Unix seconds"
+// @Param X-CallFS-Transfer-Id header string false "Track the upload under this ID; see GET /v1/transfers/{id}"
+// @Param X-CallFS-XAttrs header string false "Extended attributes listed in backend.localfs_xattrs, form-encoded (user.a=1&user.b=2); localfs only"
+// @Param Content-Type header string false "application/vnd.callfs+json to send a file of up to 1 MiB as an InlineFile: base64 content and attributes in one JSON document"
 // @Success 201 "Created"
-// @Success 200 "OK (directory already exists)"
+// @Success 200 "OK (path already exists and was kept or overwritten)"
 // @Failure 400 {object} ErrorResponse "Bad Request"
 // @Failure 401 {object} ErrorResponse "Unauthorized"
 // @Failure 403 {object} ErrorResponse "Forbidden"
-// @Failure 409 {object} CrossServerConflictResponse "Conflict - resource exists on another server"
+// @Failure 409 {object} ErrorResponse "Conflict - resource already exists"
 // @Failure 500 {object} ErrorResponse "Internal Server Error"
 // @Router /v1/files/{path} [post]
 func V1PostFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, backendConfig *config.BackendConfig, cfg *config.ServerConfig, logger *zap.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
 		// Extract and parse path from URL
 		urlPath := chi.URLParam(r, "*")
 		pathInfo := ParseFilePath(urlPath)
@@ -85,12 +91,35 @@ func V1PostFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, backend
 			return
 		}
 
+		switch op := r.URL.Query().Get("op"); op {
+		case "":
+		case "touch":
+			V1TouchFile(engine, authorizer, backendConfig, cfg, logger)(w, r)
+			return
+		case "mkdir":
+			V1MakeDirectory(engine, authorizer, backendConfig, cfg, logger)(w, r)
+			return
+		default:
+			SendErrorResponse(w, logger, &customError{message: "unknown op " + op}, http.StatusBadRequest)
+			return
+		}
+
+		if !pathInfo.IsDirectory && isInlineFile(r) && !decodeInlineFile(w, r, logger) {
+			return
+		}
+
 		// Get user ID from context
 		userID, ok := middleware.GetUserID(r.Context())
 		if !ok {
 			SendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
 			return
 		}
+		// Another instance already chose this one to own the new file, and
+		// ran the upload through its hooks
+		if auth.IsPeerRequest(r.Context()) {
+			r = r.WithContext(core.WithHooksApplied(core.WithLocalPlacement(r.Context())))
+		}
+		clearProxyDeadlines(w, r)
 
 		// Normalize path for engine calls
 		enginePath := pathInfo.FullPath
@@ -104,53 +133,36 @@ func V1PostFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, backend
 			return
 		}
 
-		// Check if file/directory already exists (with cross-server detection)
+		// Existing files are a conflict unless the client asks otherwise. An
+		// existing directory is only one when exclusive creation is explicit,
+		// as mkdir -p treats it.
+		createMode, err := parseCreateMode(r, "")
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusBadRequest)
+			return
+		}
+		attrs, err := parseFileAttributes(r, backendConfig.LocalFSXAttrs)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusBadRequest)
+			return
+		}
+
+		// Check if file/directory already exists on any instance
 		existingMd, err := engine.GetMetadata(r.Context(), enginePath)
 		fileExists := (err == nil)
 
 		if fileExists {
-			// Check if the existing resource is on this instance
-			currentInstanceID := engine.GetCurrentInstanceID()
-
-			if existingMd.CallFSInstanceID != nil && *existingMd.CallFSInstanceID != currentInstanceID {
-				// Resource exists on another server - provide conflict response
-				conflictResponse := CrossServerConflictResponse{
-					Error:        "Resource exists on another server",
-					ExistingPath: existingMd.Path,
-					InstanceID:   *existingMd.CallFSInstanceID,
-					BackendType:  existingMd.BackendType,
-				}
-
-				if pathInfo.IsDirectory {
-					if existingMd.Type != "directory" {
-						conflictResponse.Suggestion = "Path exists as file on another server, cannot create directory"
-					} else {
-						conflictResponse.Suggestion = "Directory already exists on another server. Use GET to access it."
-					}
-				} else {
-					if existingMd.Type != "file" {
-						conflictResponse.Suggestion = "Path exists as directory on another server, cannot create file"
-					} else {
-						conflictResponse.Suggestion = "File already exists on another server. Use PUT to update it."
-						// Provide the update URL for cross-server PUT
-						if peerEndpoint := engine.GetPeerEndpoint(*existingMd.CallFSInstanceID); peerEndpoint != "" {
-							conflictResponse.UpdateURL = peerEndpoint + "/v1/files" + enginePath
-						}
-					}
-				}
-
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusConflict)
-				SendJSONResponse(w, conflictResponse)
-				return
-			}
-
-			// Resource exists on this instance - handle normally
+			// Answer the same way wherever the resource is stored, so clients
+			// never need to know which instance owns it
 			if pathInfo.IsDirectory {
 				if existingMd.Type != "directory" {
 					SendErrorResponse(w, logger, &customError{message: "path exists as file, cannot create directory"}, http.StatusConflict)
 					return
 				}
+				if createMode == createExclusive {
+					SendErrorResponse(w, logger, metadata.ErrAlreadyExists, http.StatusConflict)
+					return
+				}
 				// Directory already exists - return OK
 				w.WriteHeader(http.StatusOK)
 				return
@@ -159,30 +171,49 @@ func V1PostFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, backend
 					SendErrorResponse(w, logger, &customError{message: "path exists as directory, cannot create file"}, http.StatusConflict)
 					return
 				}
-				// File already exists on this instance - return conflict for POST
-				SendErrorResponse(w, logger, &customError{message: "file already exists, use PUT to update"}, http.StatusConflict)
+				switch createMode {
+				case createOverwrite:
+					// Replace the content exactly as PUT would, wherever it is stored
+					V1PutFileEnhanced(engine, authorizer, backendConfig, cfg, logger)(w, r)
+				case createIgnoreExists:
+					w.WriteHeader(http.StatusOK)
+				default:
+					// File already exists - return conflict unless asked to overwrite
+					SendErrorResponse(w, logger, metadata.ErrAlreadyExists, http.StatusConflict)
+				}
 				return
 			}
 		}
 
+		defaults, err := inodeDefaults(r.Context(), authorizer, cfg, userID)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+		if err := authorizeNewFileAttributes(r.Context(), authorizer, userID, enginePath, attrs, defaults); err != nil {
+			SendErrorResponse(w, logger, err, http.StatusForbidden)
+			return
+		}
+
 		if pathInfo.IsDirectory {
 			// Create new directory
-			md := &metadata.Metadata{
+			md := newInodeMetadata(defaults, &metadata.Metadata{
 				Name:        pathInfo.Name,
 				Type:        "directory",
-				Mode:        "0755",
-				UID:         1000,
-				GID:         1000,
 				BackendType: backendConfig.DefaultBackend,
 				ATime:       time.Now(),
 				MTime:       time.Now(),
 				CTime:       time.Now(),
-			}
+			}, &attrs)
 
 			if err := engine.CreateDirectory(r.Context(), enginePath, md); err != nil {
 				SendErrorResponse(w, logger, err, http.StatusInternalServerError)
 				return
 			}
+			if err := applyFileAttributes(r.Context(), engine, enginePath, attrs); err != nil {
+				SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+				return
+			}
 
 			w.WriteHeader(http.StatusCreated)
 			logger.Info("Directory created",
@@ -201,17 +232,41 @@ func V1PostFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, backend
 			erasureRequested := r.Header.Get("X-CallFS-Erasure") == "true" || r.URL.Query().Get("erasure") == "true"
 			em := engine.GetErasureManager()
 
+			uploadLimit := maxFileSizeForPath(cfg, enginePath)
+
 			if erasureRequested && em != nil {
-				// Limit erasure upload body to 1 GB
-				const maxErasureUpload = 1 << 30
-				r.Body = http.MaxBytesReader(w, r.Body, maxErasureUpload)
-				data, readErr := io.ReadAll(r.Body)
+				// Erasure uploads are buffered in memory, so cap them at 1 GB
+				const maxErasureUpload int64 = 1 << 30
+				if !limitUploadBody(w, r, min(uploadLimit, maxErasureUpload), logger) {
+					return
+				}
+				body, checksumErr := wrapChecksumBody(r, r.Body)
+				if checksumErr != nil {
+					SendErrorResponse(w, logger, &customError{message: checksumErr.Error()}, http.StatusBadRequest)
+					return
+				}
+				processed, err := engine.ProcessUpload(r.Context(), enginePath, body)
+				if err != nil {
+					SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+					return
+				}
+				if processed != nil {
+					defer processed.Close()
+					body = processed
+				}
+				digest := core.NewContentDigest(body, -1)
+				data, readErr := io.ReadAll(digest)
 				if readErr != nil {
 					SendErrorResponse(w, logger, readErr, http.StatusInternalServerError)
 					return
 				}
 				actualSize := int64(len(data))
 
+				if err := engine.ScanContent(r.Context(), enginePath, bytes.NewReader(data)); err != nil {
+					SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+					return
+				}
+
 				opts := parseErasureOptions(r)
 
 				if _, storeErr := em.StoreFile(r.Context(), enginePath, data, actualSize, opts); storeErr != nil {
@@ -220,24 +275,26 @@ func V1PostFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, backend
 				}
 
 				// Store metadata with erasure flag
-				md := &metadata.Metadata{
+				md := newInodeMetadata(defaults, &metadata.Metadata{
 					Name:         pathInfo.Name,
 					Type:         "file",
 					Size:         actualSize,
-					Mode:         "0644",
-					UID:          1000,
-					GID:          1000,
 					BackendType:  "erasure",
 					ErasureCoded: true,
+					Checksum:     digest.Sum(),
 					ATime:        time.Now(),
 					MTime:        time.Now(),
 					CTime:        time.Now(),
-				}
+				}, &attrs)
 
 				if err := engine.CreateErasureMetadata(r.Context(), enginePath, md); err != nil {
 					SendErrorResponse(w, logger, err, http.StatusInternalServerError)
 					return
 				}
+				if err := applyFileAttributes(r.Context(), engine, enginePath, attrs); err != nil {
+					SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+					return
+				}
 
 				w.WriteHeader(http.StatusCreated)
 				logger.Info("Erasure-coded file created",
@@ -247,9 +304,9 @@ func V1PostFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, backend
 				return
 			}
 
-			// Limit normal upload body to 10 GiB
-			const maxUploadBytes = 10 << 30
-			r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+			if !limitUploadBody(w, r, uploadLimit, logger) {
+				return
+			}
 
 			// Wrap body with counting reader for chunked uploads to determine actual size
 			var countReader *CountingReader
@@ -258,20 +315,36 @@ func V1PostFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, backend
 				r.Body = io.NopCloser(countReader)
 			}
 
-			md := &metadata.Metadata{
+			// Verify the client-supplied checksum, if any, before metadata is committed
+			body, err := wrapChecksumBody(r, r.Body)
+			if err != nil {
+				SendErrorResponse(w, logger, &customError{message: err.Error()}, http.StatusBadRequest)
+				return
+			}
+
+			// Content rewritten by hooks has a known size, so chunked uploads
+			// need no correction
+			processed, err := engine.ProcessUpload(r.Context(), enginePath, body)
+			if err != nil {
+				SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+				return
+			}
+			if processed != nil {
+				defer processed.Close()
+				body, size, countReader = processed, processed.Size(), nil
+			}
+
+			md := newInodeMetadata(defaults, &metadata.Metadata{
 				Name:        pathInfo.Name,
 				Type:        "file",
-				Mode:        "0644",
-				UID:         1000,
-				GID:         1000,
 				BackendType: backendConfig.DefaultBackend,
 				ATime:       time.Now(),
 				MTime:       time.Now(),
 				CTime:       time.Now(),
-			}
+			}, &attrs)
 
 			// Create new file
-			if err := engine.CreateFile(r.Context(), enginePath, r.Body, size, md); err != nil {
+			if err := engine.CreateFile(r.Context(), enginePath, body, size, md); err != nil {
 				SendErrorResponse(w, logger, err, http.StatusInternalServerError)
 				return
 			}
@@ -293,6 +366,11 @@ func V1PostFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, backend
 				}
 			}
 
+			if err := applyFileAttributes(r.Context(), engine, enginePath, attrs); err != nil {
+				SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+				return
+			}
+
 			w.WriteHeader(http.StatusCreated)
 			logger.Info("File created",
 				zap.String("path", pathInfo.FullPath),
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/config"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/preview"
+	"github.com/ebogdum/callfs/server/middleware"
+)
+
+// V1GetPreview handles GET /v1/preview/{path}
+// @Summary Get a file preview
+// @Description Returns a JPEG thumbnail of an image (JPEG, PNG or GIF), or of the first page of a PDF when a PDF renderer is configured, fitting within w by h pixels with its aspect ratio kept. Images are never enlarged. Previews are rendered once per version of the file and cached, and carry an ETag for conditional requests.
+// @Tags files
+// @Security BearerAuth
+// @Produce jpeg
+// @Param path path string true "File path"
+// @Param w query int false "Maximum width in pixels (default preview.default_size)"
+// @Param h query int false "Maximum height in pixels (default preview.default_size)"
+// @Success 200 {string} binary "JPEG preview"
+// @Success 304 "Preview unchanged since the ETag in If-None-Match"
+// @Failure 400 {object} ErrorResponse "Invalid path or size"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Not Found"
+// @Failure 413 {object} ErrorResponse "File too large to preview"
+// @Failure 415 {object} ErrorResponse "No preview available for this file type"
+// @Failure 500 {object} ErrorResponse "Internal Server Error"
+// @Router /v1/preview/{path} [get]
+func V1GetPreview(engine *core.Engine, authorizer auth.Authorizer, cfg *config.PreviewConfig, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		pathInfo := ParseFilePath(chi.URLParam(r, "*"))
+		if pathInfo.IsInvalid || pathInfo.IsDirectory {
+			SendErrorResponse(w, logger, &customError{message: "invalid path"}, http.StatusBadRequest)
+			return
+		}
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			SendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
+			return
+		}
+		enginePath := pathInfo.FullPath
+
+		width, err := previewDimension(r, "w", cfg)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusBadRequest)
+			return
+		}
+		height, err := previewDimension(r, "h", cfg)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusBadRequest)
+			return
+		}
+
+		if err := authorizer.Authorize(r.Context(), userID, enginePath, auth.ReadPerm); err != nil {
+			SendErrorResponse(w, logger, err, http.StatusForbidden)
+			return
+		}
+		md, err := engine.GetMetadata(r.Context(), enginePath)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusNotFound)
+			return
+		}
+		// Previews of cached previews would only fill the cache
+		if strings.HasPrefix(enginePath, core.PreviewNamespace+"/") {
+			SendErrorResponse(w, logger, preview.ErrUnsupported, http.StatusUnsupportedMediaType)
+			return
+		}
+
+		etag := `"` + core.PreviewTag(md, width, height) + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "private, no-cache")
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		reader, size, err := engine.Preview(r.Context(), md, width, height)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+		defer reader.Close()
+
+		w.Header().Set("Content-Type", preview.ContentType)
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusOK)
+		if _, err := io.Copy(w, reader); err != nil {
+			logger.Warn("Failed to send preview", zap.String("path", enginePath), zap.Error(err))
+		}
+	}
+}
+
+// previewDimension parses the size query parameter name, which defaults to
+// the configured default size
+func previewDimension(r *http.Request, name string, cfg *config.PreviewConfig) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return cfg.DefaultSize, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > cfg.MaxSize {
+		return 0, &customError{message: fmt.Sprintf("%s must be a number of pixels from 1 to %d", name, cfg.MaxSize)}
+	}
+	return n, nil
+}
+
+// etagMatches reports whether an If-None-Match header lists etag
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
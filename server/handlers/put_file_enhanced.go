@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,30 +13,45 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/backends/internalproxy"
 	"github.com/ebogdum/callfs/config"
 	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
 	"github.com/ebogdum/callfs/metadata"
 	"github.com/ebogdum/callfs/server/middleware"
 )
 
 // V1PutFileEnhanced handles PUT /files/{path} requests with cross-server support
 // @Summary Update file with cross-server support
-// @Description Updates an existing file with new binary content, automatically routing to the correct server
+// @Description Updates an existing file with new binary content, automatically routing to the correct server.
+// @Description X-CallFS-Create-Mode picks what happens when the file exists: overwrite (default), exclusive (409) or ignore-exists (200, left untouched)
 // @Tags files
 // @Security BearerAuth
 // @Param path path string true "File path (no trailing slash)"
 // @Param file body string true "File content (application/octet-stream)"
+// @Param X-CallFS-Create-Mode header string false "exclusive, overwrite or ignore-exists"
+// @Param X-CallFS-Mode header string false "Permission bits, such as 0640; changing them requires owning the file"
+// @Param X-CallFS-UID header integer false "Owning UID; changing it requires an admin key"
+// @Param X-CallFS-GID header integer false "Owning GID; changing it requires an admin key"
+// @Param X-CallFS-MTime header string false "Modification time, RFC 3339 or Unix seconds; requires owning the file"
+// @Param X-CallFS-ATime header string false "Access time, RFC 3339 or Unix seconds; requires owning the file"
+// @Param X-CallFS-Transfer-Id header string false "Track the upload under this ID; see GET /v1/transfers/{id}"
+// @Param X-CallFS-XAttrs header string false "Extended attributes listed in backend.localfs_xattrs, form-encoded (user.a=1&user.b=2); localfs only"
+// @Param Content-Type header string false "application/vnd.callfs+json to send a file of up to 1 MiB as an InlineFile: base64 content and attributes in one JSON document"
 // @Success 200 "OK"
 // @Success 201 "Created"
 // @Failure 400 {object} ErrorResponse "Bad Request"
 // @Failure 401 {object} ErrorResponse "Unauthorized"
 // @Failure 403 {object} ErrorResponse "Forbidden"
 // @Failure 404 {object} ErrorResponse "Not Found"
+// @Failure 409 {object} ErrorResponse "Conflict - file exists and exclusive creation was requested"
 // @Failure 500 {object} ErrorResponse "Internal Server Error"
 // @Failure 502 {object} ErrorResponse "Bad Gateway (cross-server proxy error)"
 // @Router /v1/files/{path} [put]
 func V1PutFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, backendConfig *config.BackendConfig, cfg *config.ServerConfig, logger *zap.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
 		// Extract and parse path from URL
 		urlPath := chi.URLParam(r, "*")
 		pathInfo := ParseFilePath(urlPath)
@@ -51,12 +68,36 @@ func V1PutFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, backendC
 			return
 		}
 
+		if isInlineFile(r) && !decodeInlineFile(w, r, logger) {
+			return
+		}
+
+		createMode, err := parseCreateMode(r, createOverwrite)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusBadRequest)
+			return
+		}
+		attrs, err := parseFileAttributes(r, backendConfig.LocalFSXAttrs)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusBadRequest)
+			return
+		}
+
 		// Get user ID from context
 		userID, ok := middleware.GetUserID(r.Context())
 		if !ok {
 			SendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
 			return
 		}
+		// Another instance already chose this one to own the new file, and
+		// ran the upload through its hooks. A delta it applied to the file is
+		// only stored while the file still has the content it patched.
+		var ifVersion string
+		if auth.IsPeerRequest(r.Context()) {
+			r = r.WithContext(core.WithHooksApplied(core.WithLocalPlacement(r.Context())))
+			ifVersion = r.Header.Get(internalproxy.IfVersionHeader)
+		}
+		clearProxyDeadlines(w, r)
 
 		// Normalize path for engine calls
 		enginePath := pathInfo.FullPath
@@ -64,9 +105,9 @@ func V1PutFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, backendC
 			enginePath = strings.TrimSuffix(enginePath, "/")
 		}
 
-		// Limit upload body to 10 GiB
-		const maxUploadBytes int64 = 10 << 30
-		r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+		if !limitUploadBody(w, r, maxFileSizeForPath(cfg, enginePath), logger) {
+			return
+		}
 
 		size := r.ContentLength
 		isChunked := size < 0
@@ -82,6 +123,13 @@ func V1PutFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, backendC
 			r.Body = io.NopCloser(countReader)
 		}
 
+		// Verify the client-supplied checksum, if any, before metadata is committed
+		body, err := wrapChecksumBody(r, r.Body)
+		if err != nil {
+			SendErrorResponse(w, logger, &customError{message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+
 		// Authorize write access FIRST
 		if err := authorizer.Authorize(r.Context(), userID, enginePath, auth.WritePerm); err != nil {
 			SendErrorResponse(w, logger, err, http.StatusForbidden)
@@ -94,23 +142,45 @@ func V1PutFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, backendC
 		currentInstanceID := engine.GetCurrentInstanceID()
 
 		if err != nil {
-			if err == metadata.ErrNotFound {
+			if errors.Is(err, metadata.ErrNotFound) && ifVersion != "" {
+				SendErrorResponse(w, logger, metadata.ErrModified, http.StatusPreconditionFailed)
+				return
+			}
+			if errors.Is(err, metadata.ErrNotFound) {
 				// File doesn't exist, we'll create it locally
 				statusCode = http.StatusCreated
-				existingMd = &metadata.Metadata{
+				defaults, err := inodeDefaults(r.Context(), authorizer, cfg, userID)
+				if err != nil {
+					SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+					return
+				}
+				existingMd = newInodeMetadata(defaults, &metadata.Metadata{
 					Name:        pathInfo.Name,
 					Type:        "file",
-					Mode:        "0644",
-					UID:         1000,
-					GID:         1000,
 					BackendType: backendConfig.DefaultBackend,
 					ATime:       time.Now(),
 					MTime:       time.Now(),
 					CTime:       time.Now(),
+				}, &attrs)
+				if err := authorizeNewFileAttributes(r.Context(), authorizer, userID, enginePath, attrs, defaults); err != nil {
+					SendErrorResponse(w, logger, err, http.StatusForbidden)
+					return
+				}
+
+				// Content rewritten by hooks has a known size, so chunked
+				// uploads need no correction
+				processed, err := engine.ProcessUpload(r.Context(), enginePath, body)
+				if err != nil {
+					SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+					return
+				}
+				if processed != nil {
+					defer processed.Close()
+					body, size, countReader = processed, processed.Size(), nil
 				}
 
 				// Create the file locally
-				if err := engine.CreateFile(r.Context(), enginePath, r.Body, size, existingMd); err != nil {
+				if err := engine.CreateFile(r.Context(), enginePath, body, size, existingMd); err != nil {
 					SendErrorResponse(w, logger, err, http.StatusInternalServerError)
 					return
 				}
@@ -126,10 +196,32 @@ func V1PutFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, backendC
 					http.StatusBadRequest)
 				return
 			}
+			switch createMode {
+			case createExclusive:
+				SendErrorResponse(w, logger, metadata.ErrAlreadyExists, http.StatusConflict)
+				return
+			case createIgnoreExists:
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			if err := authorizeFileAttributes(r.Context(), authorizer, userID, enginePath, attrs, existingMd); err != nil {
+				SendErrorResponse(w, logger, err, http.StatusForbidden)
+				return
+			}
+			processed, err := engine.ProcessUpload(r.Context(), enginePath, body)
+			if err != nil {
+				SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+				return
+			}
+			if processed != nil {
+				defer processed.Close()
+				body, size, countReader = processed, processed.Size(), nil
+			}
 			// Check if file is on this instance or needs cross-server proxy
 			if existingMd.CallFSInstanceID != nil && *existingMd.CallFSInstanceID != currentInstanceID {
 				// File is on another server - use the internal proxy backend
-				if err := engine.UpdateFileOnInstance(r.Context(), *existingMd.CallFSInstanceID, enginePath, r.Body, size); err != nil {
+				digest := core.NewContentDigest(body, size)
+				if err := engine.UpdateFileOnInstanceIfVersion(r.Context(), *existingMd.CallFSInstanceID, enginePath, digest, size, ifVersion); err != nil {
 					logger.Error("Failed to update file via cross-server proxy",
 						zap.String("instance_id", *existingMd.CallFSInstanceID),
 						zap.String("path", enginePath),
@@ -140,6 +232,7 @@ func V1PutFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, backendC
 
 				// Update local metadata to reflect the new size/mtime after proxy write
 				existingMd.Size = size
+				existingMd.Checksum = digest.Sum()
 				existingMd.MTime = time.Now()
 				existingMd.UpdatedAt = time.Now()
 				if updateErr := engine.UpdateMetadataOnly(r.Context(), existingMd); updateErr != nil {
@@ -147,6 +240,10 @@ func V1PutFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, backendC
 						zap.String("path", enginePath),
 						zap.Error(updateErr))
 				}
+				if err := applyFileAttributes(r.Context(), engine, enginePath, attrs); err != nil {
+					SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+					return
+				}
 
 				w.WriteHeader(http.StatusOK)
 				logger.Info("File updated via cross-server proxy",
@@ -158,7 +255,7 @@ func V1PutFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, backendC
 			}
 
 			// File exists on this instance - update locally
-			if err := engine.UpdateFile(r.Context(), enginePath, r.Body, size, existingMd); err != nil {
+			if err := engine.UpdateFileIfVersion(r.Context(), enginePath, body, size, existingMd, ifVersion); err != nil {
 				SendErrorResponse(w, logger, err, http.StatusInternalServerError)
 				return
 			}
@@ -181,6 +278,11 @@ func V1PutFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, backendC
 			}
 		}
 
+		if err := applyFileAttributes(r.Context(), engine, enginePath, attrs); err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+
 		w.WriteHeader(statusCode)
 		logger.Info("File updated locally",
 			zap.String("path", pathInfo.FullPath),
@@ -189,3 +291,32 @@ func V1PutFileEnhanced(engine *core.Engine, authorizer auth.Authorizer, backendC
 			zap.Int("status_code", statusCode))
 	}
 }
+
+// updateFileContent replaces the content of the existing file md with size
+// bytes of body, run through the pre-write hooks, on the instance that owns
+// it. A version set is the ContentVersion the file must still have, checked
+// under the lock of the path.
+func updateFileContent(ctx context.Context, engine *core.Engine, md *metadata.Metadata, body io.Reader, size int64, version string) error {
+	processed, err := engine.ProcessUpload(ctx, md.Path, body)
+	if err != nil {
+		return err
+	}
+	if processed != nil {
+		defer processed.Close()
+		body, size = processed, processed.Size()
+	}
+
+	if md.CallFSInstanceID == nil || *md.CallFSInstanceID == engine.GetCurrentInstanceID() {
+		return engine.UpdateFileIfVersion(ctx, md.Path, body, size, md, version)
+	}
+	// Keep the metadata of a file stored by another instance in step
+	digest := core.NewContentDigest(body, size)
+	if err := engine.UpdateFileOnInstanceIfVersion(ctx, *md.CallFSInstanceID, md.Path, digest, size, version); err != nil {
+		return fmt.Errorf("failed to update file on remote server: %w", err)
+	}
+	md.Checksum = digest.Sum()
+	md.Size = size
+	md.MTime = time.Now()
+	md.UpdatedAt = time.Now()
+	return engine.UpdateMetadataOnly(ctx, md)
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// errRangeNotSatisfiable is returned for a Range header that lies entirely
+// outside the file
+var errRangeNotSatisfiable = errors.New("requested range not satisfiable")
+
+// byteRange is a single resolved byte range within a file
+type byteRange struct {
+	offset int64
+	length int64
+}
+
+// parseRange resolves a Range header against a file of the given size. Only a
+// single bytes range is honored; ok is false when the header is absent,
+// malformed or asks for several ranges, in which case the whole file is sent.
+func parseRange(header string, size int64) (r byteRange, ok bool, err error) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return byteRange{}, false, nil
+	}
+	startStr, endStr, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return byteRange{}, false, nil
+	}
+
+	if startStr == "" {
+		// Suffix range: the last n bytes
+		n, err := parseRangeInt(endStr)
+		if err != nil {
+			return byteRange{}, false, nil
+		}
+		if n == 0 || size == 0 {
+			return byteRange{}, false, errRangeNotSatisfiable
+		}
+		n = min(n, size)
+		return byteRange{offset: size - n, length: n}, true, nil
+	}
+
+	start, err := parseRangeInt(startStr)
+	if err != nil {
+		return byteRange{}, false, nil
+	}
+	end := size - 1
+	if endStr != "" {
+		end, err = parseRangeInt(endStr)
+		if err != nil || end < start {
+			return byteRange{}, false, nil
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return byteRange{}, false, errRangeNotSatisfiable
+	}
+	return byteRange{offset: start, length: end - start + 1}, true, nil
+}
+
+// parseRangeInt parses a position of a Range header, which is only digits:
+// strconv would also take a sign
+func parseRangeInt(s string) (int64, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, strconv.ErrSyntax
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
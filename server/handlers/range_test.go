@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	const size = 100
+	tests := []struct {
+		name   string
+		header string
+		want   byteRange
+		ok     bool
+		err    error
+	}{
+		{"absent", "", byteRange{}, false, nil},
+		{"closed", "bytes=10-19", byteRange{offset: 10, length: 10}, true, nil},
+		{"open", "bytes=90-", byteRange{offset: 90, length: 10}, true, nil},
+		{"end past size", "bytes=90-500", byteRange{offset: 90, length: 10}, true, nil},
+		{"single byte", "bytes=0-0", byteRange{offset: 0, length: 1}, true, nil},
+		{"suffix", "bytes=-30", byteRange{offset: 70, length: 30}, true, nil},
+		{"suffix past size", "bytes=-500", byteRange{offset: 0, length: size}, true, nil},
+		{"spaces", " bytes= 5-9 ", byteRange{offset: 5, length: 5}, true, nil},
+		{"overlapping ranges", "bytes=0-50,25-75", byteRange{}, false, nil},
+		{"several ranges", "bytes=0-1,-5", byteRange{}, false, nil},
+		{"start past size", "bytes=100-", byteRange{}, false, errRangeNotSatisfiable},
+		{"start past size closed", "bytes=200-300", byteRange{}, false, errRangeNotSatisfiable},
+		{"empty suffix", "bytes=-0", byteRange{}, false, errRangeNotSatisfiable},
+		{"other unit", "items=0-1", byteRange{}, false, nil},
+		{"no dash", "bytes=5", byteRange{}, false, nil},
+		{"end before start", "bytes=9-5", byteRange{}, false, nil},
+		{"negative suffix", "bytes=--5", byteRange{}, false, nil},
+		{"signed start", "bytes=+5-9", byteRange{}, false, nil},
+		{"signed suffix", "bytes=-+5", byteRange{}, false, nil},
+		{"not a number", "bytes=a-b", byteRange{}, false, nil},
+		{"dash only", "bytes=-", byteRange{}, false, nil},
+		{"overflow", "bytes=0-99999999999999999999", byteRange{}, false, nil},
+	}
+	for _, tt := range tests {
+		got, ok, err := parseRange(tt.header, size)
+		if got != tt.want || ok != tt.ok || !errors.Is(err, tt.err) {
+			t.Errorf("%s: parseRange(%q) = %+v, %v, %v; want %+v, %v, %v", tt.name, tt.header, got, ok, err, tt.want, tt.ok, tt.err)
+		}
+	}
+
+	if _, ok, err := parseRange("bytes=-5", 0); ok || !errors.Is(err, errRangeNotSatisfiable) {
+		t.Errorf("suffix of an empty file: %v, %v", ok, err)
+	}
+}
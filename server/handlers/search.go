@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/search"
+	"github.com/ebogdum/callfs/server/middleware"
+)
+
+// Page sizes of content searches
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// SearchResponse represents the response for the content search endpoint
+type SearchResponse struct {
+	Hits       []search.Hit `json:"hits"`
+	NextOffset int          `json:"next_offset,omitempty"` // Offset of the next page; absent on the last page
+}
+
+// V1SearchContent handles GET /v1/search/content
+// @Summary Search files by name and content
+// @Description Searches the index of file names, paths and extracted text, best match first. Hits are the files of the page the caller may read, so a page may hold fewer than limit hits before the last one; next_offset is where the next page starts. The index is updated in the background after writes, so a file may take a moment to be found.
+// @Tags search
+// @Produce json
+// @Security BearerAuth
+// @Param q query string true "Words to find"
+// @Param path query string false "Directory to search below (default /)"
+// @Param limit query int false "Hits per page, at most 100 (default 20)"
+// @Param offset query int false "Hits to skip (default 0)"
+// @Success 200 {object} SearchResponse "Matching files"
+// @Failure 400 {object} ErrorResponse "Invalid query"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 503 {object} ErrorResponse "Search index unavailable"
+// @Router /v1/search/content [get]
+func V1SearchContent(engine *core.Engine, authorizer auth.Authorizer, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			SendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
+			return
+		}
+
+		query := r.URL.Query()
+		pathInfo := ParseFilePath(query.Get("path"))
+		if pathInfo.IsInvalid {
+			SendErrorResponse(w, logger, &customError{message: "invalid path"}, http.StatusBadRequest)
+			return
+		}
+		limit, err := searchPageParam(query.Get("limit"), "limit", defaultSearchLimit, 1, maxSearchLimit)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusBadRequest)
+			return
+		}
+		offset, err := searchPageParam(query.Get("offset"), "offset", 0, 0, 10000-limit)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusBadRequest)
+			return
+		}
+
+		if err := authorizer.Authorize(r.Context(), userID, pathInfo.FullPath, auth.ReadPerm); err != nil {
+			SendErrorResponse(w, logger, err, http.StatusForbidden)
+			return
+		}
+
+		result, err := engine.SearchContent(r.Context(), search.Query{
+			Text:   query.Get("q"),
+			Prefix: pathInfo.FullPath,
+			Limit:  limit,
+			Offset: offset,
+		})
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+
+		// Permissions may be narrower below the directory searched. The
+		// index's total counts files the caller may not read, so it is left
+		// out; paging follows the index's pages instead.
+		response := SearchResponse{Hits: make([]search.Hit, 0, len(result.Hits))}
+		for _, hit := range result.Hits {
+			if authorizer.Authorize(r.Context(), userID, hit.Path, auth.ReadPerm) == nil {
+				response.Hits = append(response.Hits, hit)
+			}
+		}
+		if int64(offset+limit) < result.Total && offset+limit <= 10000-limit {
+			response.NextOffset = offset + limit
+		}
+		SendJSONResponse(w, response)
+	}
+}
+
+// V1AdminReindex handles POST /v1/admin/search/reindex
+// @Summary Reindex files for search
+// @Description Writes the search documents of the file at path, or of every file below the directory at path, again. Repairs an index that missed updates while it was unreachable.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param path query string false "File or directory to reindex (default /)"
+// @Success 200 {object} core.ReindexReport "Files reindexed"
+// @Failure 400 {object} ErrorResponse "Invalid path"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Not Found"
+// @Failure 503 {object} ErrorResponse "Search index unavailable"
+// @Router /v1/admin/search/reindex [post]
+func V1AdminReindex(engine *core.Engine, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		pathInfo := ParseFilePath(r.URL.Query().Get("path"))
+		if pathInfo.IsInvalid {
+			SendErrorResponse(w, logger, &customError{message: "invalid path"}, http.StatusBadRequest)
+			return
+		}
+
+		report, err := engine.Reindex(r.Context(), pathInfo.FullPath)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Search index rebuilt",
+			zap.String("path", report.Path),
+			zap.Int("indexed", report.Indexed),
+			zap.Int("failed", report.Failed))
+		SendJSONResponse(w, report)
+	}
+}
+
+// searchPageParam parses the paging parameter name, which defaults to def
+func searchPageParam(value, name string, def, minimum, maximum int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < minimum || n > maximum {
+		return 0, &customError{message: fmt.Sprintf("%s must be a number from %d to %d", name, minimum, maximum)}
+	}
+	return n, nil
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/server/middleware"
+)
+
+// CreateSnapshotRequest names a snapshot and the directory to take it of
+type CreateSnapshotRequest struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// SnapshotListResponse represents the response for the snapshot listing endpoint
+type SnapshotListResponse struct {
+	Count     int             `json:"count"`
+	Snapshots []core.Snapshot `json:"snapshots"`
+}
+
+// V1CreateSnapshot handles POST /v1/snapshots
+// @Summary Take a snapshot of a directory
+// @Description Copies the directory and everything below it to a read-only snapshot. Content is shared with the files where the backend allows: reflinks on local filesystems that support them, server-side copies in S3. Requires write permission on the directory, or an admin API key.
+// @Tags snapshots
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body CreateSnapshotRequest true "Snapshot name and directory"
+// @Success 201 {object} core.Snapshot "Snapshot taken"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Directory not found"
+// @Failure 409 {object} ErrorResponse "A snapshot of the name exists"
+// @Router /v1/snapshots [post]
+func V1CreateSnapshot(engine *core.Engine, authorizer auth.Authorizer, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		var req CreateSnapshotRequest
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1 MiB
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendErrorResponse(w, logger, fmt.Errorf("%w: %v", core.ErrInvalidSnapshot, err), http.StatusBadRequest)
+			return
+		}
+		if !strings.HasPrefix(req.Path, "/") {
+			SendErrorResponse(w, logger, fmt.Errorf("%w: path must be absolute", core.ErrInvalidSnapshot), http.StatusBadRequest)
+			return
+		}
+
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			SendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
+			return
+		}
+		// A snapshot keeps copies of the directory's files that its writers
+		// cannot delete, so only they and admins may take one
+		if !auth.IsAdmin(userID) {
+			if err := authorizer.Authorize(r.Context(), userID, req.Path, auth.WritePerm); err != nil {
+				SendErrorResponse(w, logger, err, http.StatusForbidden)
+				return
+			}
+		}
+
+		snapshot, err := engine.CreateSnapshot(r.Context(), req.Name, req.Path)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+			logger.Error("Failed to encode snapshot", zap.Error(err))
+		}
+	}
+}
+
+// V1ListSnapshots handles GET /v1/snapshots
+// @Summary List snapshots
+// @Tags snapshots
+// @Produce json
+// @Security BearerAuth
+// @Description Lists the snapshots of directories the caller may read; admin API keys see all of them.
+// @Success 200 {object} SnapshotListResponse "Snapshots, by name"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Router /v1/snapshots [get]
+func V1ListSnapshots(engine *core.Engine, authorizer auth.Authorizer, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			SendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
+			return
+		}
+		snapshots, err := engine.Snapshots(r.Context())
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+
+		readable := make([]core.Snapshot, 0, len(snapshots))
+		for _, snapshot := range snapshots {
+			if snapshotReadable(r.Context(), authorizer, userID, &snapshot) {
+				readable = append(readable, snapshot)
+			}
+		}
+		SendJSONResponse(w, SnapshotListResponse{Count: len(readable), Snapshots: readable})
+	}
+}
+
+// V1GetSnapshot handles GET /v1/snapshots/{name}
+// @Summary Get a snapshot
+// @Tags snapshots
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Snapshot name"
+// @Success 200 {object} core.Snapshot "Snapshot"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 404 {object} ErrorResponse "Snapshot not found, or of a directory the caller may not read"
+// @Router /v1/snapshots/{name} [get]
+func V1GetSnapshot(engine *core.Engine, authorizer auth.Authorizer, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			SendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
+			return
+		}
+		name := chi.URLParam(r, "name")
+		snapshot, err := engine.GetSnapshot(r.Context(), name)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+		// Snapshots of unreadable directories are not disclosed
+		if !snapshotReadable(r.Context(), authorizer, userID, snapshot) {
+			SendErrorResponse(w, logger, fmt.Errorf("%w: %s", core.ErrSnapshotNotFound, name), http.StatusNotFound)
+			return
+		}
+		SendJSONResponse(w, snapshot)
+	}
+}
+
+// snapshotReadable reports whether userID may see snapshot: admins see every
+// snapshot, other users those of directories they may read
+func snapshotReadable(ctx context.Context, authorizer auth.Authorizer, userID string, snapshot *core.Snapshot) bool {
+	return auth.IsAdmin(userID) || authorizer.Authorize(ctx, userID, snapshot.Source, auth.ReadPerm) == nil
+}
+
+// V1DeleteSnapshot handles DELETE /v1/snapshots/{name}
+// @Summary Delete a snapshot
+// @Description Deletes the snapshot and its copies. Admin API keys only.
+// @Tags snapshots
+// @Security BearerAuth
+// @Param name path string true "Snapshot name"
+// @Success 204 "Snapshot deleted"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Snapshot not found"
+// @Router /v1/snapshots/{name} [delete]
+func V1DeleteSnapshot(engine *core.Engine, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		name := chi.URLParam(r, "name")
+		if err := engine.DeleteSnapshot(r.Context(), name); err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+
+		userID, _ := middleware.GetUserID(r.Context())
+		logger.Warn("Snapshot deleted", zap.String("snapshot", name), zap.String("user_id", userID))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// V1RestoreSnapshot handles POST /v1/snapshots/{name}/restore
+// @Summary Restore files from a snapshot
+// @Description Writes the files of the snapshot, or those at or below path, back to where they were taken from, or below target. Files created since are kept. Admin API keys only.
+// @Tags snapshots
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Snapshot name"
+// @Param request body core.RestoreRequest false "Part of the snapshot and where to restore it"
+// @Success 200 {object} core.RestoreResult "Files restored"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden"
+// @Failure 404 {object} ErrorResponse "Snapshot or path not found"
+// @Router /v1/snapshots/{name}/restore [post]
+func V1RestoreSnapshot(engine *core.Engine, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		var req core.RestoreRequest
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1 MiB
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				SendErrorResponse(w, logger, fmt.Errorf("%w: %v", core.ErrInvalidSnapshot, err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		name := chi.URLParam(r, "name")
+		userID, _ := middleware.GetUserID(r.Context())
+		logger.Warn("Snapshot restore started",
+			zap.String("snapshot", name),
+			zap.String("path", req.Path),
+			zap.String("target", req.Target),
+			zap.String("user_id", userID))
+
+		result, err := engine.RestoreSnapshot(r.Context(), name, req)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+		SendJSONResponse(w, result)
+	}
+}
+
+// V1GetSnapshotFile handles GET /v1/snapshots/{name}/files/*, and HEAD when
+// given the HEAD handler of /v1/files, serving the copy of a file or
+// directory listing as next serves the original
+func V1GetSnapshotFile(engine *core.Engine, next http.Handler, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rest := chi.URLParam(r, "*")
+		filesPath, err := engine.SnapshotFilesPath(chi.URLParam(r, "name"), rest)
+		if err != nil {
+			SendErrorResponse(w, log.WithContext(r.Context(), logger), err, http.StatusNotFound)
+			return
+		}
+		if strings.HasSuffix(rest, "/") && !strings.HasSuffix(filesPath, "/") {
+			filesPath += "/"
+		}
+
+		// The handlers of /v1/files read the path from the wildcard, the
+		// last value of which wins
+		chi.RouteContext(r.Context()).URLParams.Add("*", strings.TrimPrefix(filesPath, "/"))
+		next.ServeHTTP(w, r)
+	}
+}
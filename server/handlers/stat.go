@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/metadata"
+	"github.com/ebogdum/callfs/server/middleware"
+)
+
+// V1GetStat handles GET /v1/stat/{path}
+// @Summary Get file metadata as JSON
+// @Description Returns the full metadata of a file or directory as JSON: the fields HEAD /v1/files/{path} sends as X-CallFS-* headers, and the IDs, backend, owning instance, checksum and timestamps kept by the metadata store. Entries on another instance are described by that instance. With follow_symlinks=true, a symlink is resolved and the entry it points to is returned, with its own path; reading it takes read permission on that path too.
+// @Tags files
+// @Produce json
+// @Security BearerAuth
+// @Param path path string true "File or directory path"
+// @Param follow_symlinks query bool false "Describe the target of a symlink rather than the symlink (default false)"
+// @Param fields query string false "Comma-separated fields to return, e.g. name,type,size,checksum (default all)"
+// @Success 200 {object} metadata.Metadata "Metadata"
+// @Failure 400 {object} ErrorResponse "Invalid path or parameter"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "Forbidden, or a symlink pointing outside the root"
+// @Failure 404 {object} ErrorResponse "Not Found, or a symlink whose target does not exist"
+// @Failure 500 {object} ErrorResponse "Internal Server Error"
+// @Router /v1/stat/{path} [get]
+func V1GetStat(engine *core.Engine, authorizer auth.Authorizer, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		pathInfo := ParseFilePath(chi.URLParam(r, "*"))
+		if pathInfo.IsInvalid {
+			SendErrorResponse(w, logger, &customError{message: "invalid path"}, http.StatusBadRequest)
+			return
+		}
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			SendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
+			return
+		}
+		followSymlinks := false
+		if value := r.URL.Query().Get("follow_symlinks"); value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				SendErrorResponse(w, logger, &customError{message: "follow_symlinks must be true or false"}, http.StatusBadRequest)
+				return
+			}
+			followSymlinks = parsed
+		}
+		fields, err := parseFields(r, metadata.Metadata{})
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusBadRequest)
+			return
+		}
+
+		enginePath := pathInfo.FullPath
+		if pathInfo.IsDirectory && enginePath != "/" {
+			enginePath = strings.TrimSuffix(enginePath, "/")
+		}
+
+		if err := authorizer.Authorize(r.Context(), userID, enginePath, auth.ReadPerm); err != nil {
+			SendErrorResponse(w, logger, err, http.StatusForbidden)
+			return
+		}
+
+		md, err := engine.Stat(r.Context(), enginePath, followSymlinks)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, metadata.ErrForbidden) {
+				status = http.StatusForbidden
+			}
+			SendErrorResponse(w, logger, err, status)
+			return
+		}
+		// A followed symlink may lead where the caller cannot read
+		if md.Path != enginePath {
+			if err := authorizer.Authorize(r.Context(), userID, md.Path, auth.ReadPerm); err != nil {
+				SendErrorResponse(w, logger, err, http.StatusForbidden)
+				return
+			}
+		}
+		if fields == nil {
+			SendJSONResponse(w, md)
+			return
+		}
+		selected, err := fields.encode(md)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+		SendJSONResponse(w, selected)
+	}
+}
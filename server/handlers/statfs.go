@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
+)
+
+// V1StatFS handles GET /v1/statfs
+// @Summary Report filesystem capacity
+// @Description Reports the total, used and free space of the local filesystem of every instance and of S3, the files and bytes stored on each, and the number of files and directories. Locations whose space cannot be read carry an error.
+// @Tags filesystem
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} core.FSStats "Capacity and entry counts"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 500 {object} ErrorResponse "Internal Server Error"
+// @Router /v1/statfs [get]
+func V1StatFS(engine *core.Engine, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		stats, err := engine.StatFS(r.Context())
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+		SendJSONResponse(w, stats)
+	}
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/server/middleware"
+)
+
+// defaultScopedTokenTTL is the lifetime of tokens requested without one
+const defaultScopedTokenTTL = time.Hour
+
+// IssueTokenRequest asks for a token restricted to a path and verbs
+type IssueTokenRequest struct {
+	Path          string   `json:"path" example:"/builds/42"`
+	Verbs         []string `json:"verbs" example:"read,write"`
+	ExpirySeconds int      `json:"expiry_seconds" example:"900"`
+}
+
+// IssueTokenResponse is a minted scoped token
+type IssueTokenResponse struct {
+	Token   string    `json:"token"`
+	Path    string    `json:"path"`
+	Verbs   []string  `json:"verbs"`
+	Expires time.Time `json:"expires"`
+}
+
+// V1IssueToken handles POST /v1/tokens
+// @Summary Mint a scoped token
+// @Description Mints a short-lived bearer token that acts as the caller, restricted to the paths below path and the verbs read, write and delete (read when omitted). A request made with a scoped token needs both the caller's permissions and the token's scope, and can never reach the admin API. A scoped token can mint only tokens within its own scope.
+// @Tags tokens
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body IssueTokenRequest true "Path, verbs and lifetime"
+// @Success 201 {object} IssueTokenResponse "Token minted"
+// @Failure 400 {object} ErrorResponse "Invalid request"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 403 {object} ErrorResponse "The scope exceeds the caller's token"
+// @Router /v1/tokens [post]
+func V1IssueToken(tokens *auth.ScopedTokens, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			SendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
+			return
+		}
+
+		var req IssueTokenRequest
+		r.Body = http.MaxBytesReader(w, r.Body, 4096)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendErrorResponse(w, logger, errors.New("invalid JSON in request body"), http.StatusBadRequest)
+			return
+		}
+		pathInfo := ParseFilePath(strings.TrimPrefix(req.Path, "/"))
+		if req.Path == "" || pathInfo.IsInvalid {
+			SendErrorResponse(w, logger, &customError{message: "path must be a valid path"}, http.StatusBadRequest)
+			return
+		}
+		maxTTL := tokens.MaxTTL()
+		ttl := min(defaultScopedTokenTTL, maxTTL)
+		if req.ExpirySeconds != 0 {
+			ttl = time.Duration(req.ExpirySeconds) * time.Second
+			if ttl <= 0 || ttl > maxTTL {
+				SendErrorResponse(w, logger, fmt.Errorf("%w: expiry_seconds must be between 1 and %d", auth.ErrInvalidScope, int(maxTTL.Seconds())), http.StatusBadRequest)
+				return
+			}
+		}
+		if len(req.Verbs) == 0 {
+			req.Verbs = []string{auth.VerbRead}
+		}
+		scope := auth.TokenScope{
+			Path:    strings.TrimSuffix(pathInfo.FullPath, "/"),
+			Verbs:   req.Verbs,
+			Expires: time.Now().Add(ttl).Truncate(time.Second),
+		}
+		if scope.Path == "" {
+			scope.Path = "/"
+		}
+
+		// A scoped token delegates only what it was given
+		if parent, ok := auth.TokenScopeFromContext(r.Context()); ok {
+			if req.ExpirySeconds == 0 && scope.Expires.After(parent.Expires) {
+				scope.Expires = parent.Expires
+			}
+			if !scope.Within(parent) {
+				SendErrorResponse(w, logger, auth.ErrPermissionDenied, http.StatusForbidden)
+				return
+			}
+		}
+
+		token, err := tokens.Issue(userID, scope)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusBadRequest)
+			return
+		}
+		logger.Info("Issued scoped token",
+			zap.String("user_id", userID),
+			zap.String("path", scope.Path),
+			zap.Strings("verbs", scope.Verbs),
+			zap.Time("expires", scope.Expires))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(IssueTokenResponse{Token: token, Path: scope.Path, Verbs: scope.Verbs, Expires: scope.Expires}); err != nil {
+			logger.Error("Failed to encode token response", zap.Error(err))
+		}
+	}
+}
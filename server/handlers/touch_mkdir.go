@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/config"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/metadata"
+	"github.com/ebogdum/callfs/server/middleware"
+)
+
+// MakeDirectoryResponse lists the directories a mkdir request created
+type MakeDirectoryResponse struct {
+	Path    string   `json:"path"`
+	Created []string `json:"created"` // Outermost first; empty if the directory existed
+}
+
+// V1TouchFile handles POST /files/{path}?op=touch requests. It creates an
+// empty file, or sets the times of an existing file or directory to now
+// (or to X-CallFS-ATime/X-CallFS-MTime), without a request body. Returns 201
+// for a new file and 200 otherwise.
+func V1TouchFile(engine *core.Engine, authorizer auth.Authorizer, backendConfig *config.BackendConfig, cfg *config.ServerConfig, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		pathInfo := ParseFilePath(chi.URLParam(r, "*"))
+		if pathInfo.IsInvalid {
+			SendErrorResponse(w, logger, &customError{message: "invalid path"}, http.StatusBadRequest)
+			return
+		}
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			SendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
+			return
+		}
+		enginePath := pathInfo.FullPath
+		if pathInfo.IsDirectory && enginePath != "/" {
+			enginePath = strings.TrimSuffix(enginePath, "/")
+		}
+
+		attrs, err := parseFileAttributes(r, backendConfig.LocalFSXAttrs)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusBadRequest)
+			return
+		}
+
+		// Like utimes(2) with the current time, write access is enough
+		if err := authorizer.Authorize(r.Context(), userID, enginePath, auth.WritePerm); err != nil {
+			SendErrorResponse(w, logger, err, http.StatusForbidden)
+			return
+		}
+		existingMd, err := engine.GetMetadata(r.Context(), enginePath)
+		switch {
+		case errors.Is(err, metadata.ErrNotFound) && pathInfo.IsDirectory:
+			// touch only creates files; op=mkdir creates directories
+			SendErrorResponse(w, logger, err, http.StatusNotFound)
+			return
+		case errors.Is(err, metadata.ErrNotFound):
+			existingMd = nil
+		case err != nil:
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+		defaults, err := inodeDefaults(r.Context(), authorizer, cfg, userID)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+		if existingMd != nil {
+			err = authorizeFileAttributes(r.Context(), authorizer, userID, enginePath, attrs, existingMd)
+		} else {
+			err = authorizeNewFileAttributes(r.Context(), authorizer, userID, enginePath, attrs, defaults)
+		}
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusForbidden)
+			return
+		}
+
+		// The umask only applies to the mode of a file being created
+		createAttrs := &attrs
+		if existingMd != nil {
+			createAttrs = nil
+		}
+		md := newInodeMetadata(defaults, &metadata.Metadata{
+			Name:        pathInfo.Name,
+			Type:        "file",
+			BackendType: backendConfig.DefaultBackend,
+			ATime:       time.Now(),
+			MTime:       time.Now(),
+			CTime:       time.Now(),
+		}, createAttrs)
+		created, err := engine.Touch(r.Context(), enginePath, md, attrs)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+
+		if created {
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+		logger.Info("File touched",
+			zap.String("path", enginePath),
+			zap.String("user_id", userID),
+			zap.Bool("created", created))
+	}
+}
+
+// V1MakeDirectory handles POST /files/{path}?op=mkdir requests. It creates
+// the directory like mkdir; with parents=true missing ancestors are created
+// too and an existing directory is not an error, like mkdir -p. The
+// directories created are returned, with 201 if there are any and 200
+// otherwise.
+func V1MakeDirectory(engine *core.Engine, authorizer auth.Authorizer, backendConfig *config.BackendConfig, cfg *config.ServerConfig, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		pathInfo := ParseFilePath(chi.URLParam(r, "*"))
+		if pathInfo.IsInvalid {
+			SendErrorResponse(w, logger, &customError{message: "invalid path"}, http.StatusBadRequest)
+			return
+		}
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			SendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
+			return
+		}
+		enginePath := pathInfo.FullPath
+		if enginePath != "/" {
+			enginePath = strings.TrimSuffix(enginePath, "/")
+		}
+		parents := r.URL.Query().Get("parents") == "true"
+
+		attrs, err := parseFileAttributes(r, backendConfig.LocalFSXAttrs)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusBadRequest)
+			return
+		}
+
+		// New directories need write access to the deepest existing ancestor;
+		// without parents, the authorizer reports a missing parent
+		target, err := firstMissingDirectory(r, engine, enginePath, parents)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+		if err := authorizer.Authorize(r.Context(), userID, target, auth.WritePerm); err != nil {
+			SendErrorResponse(w, logger, err, http.StatusForbidden)
+			return
+		}
+		defaults, err := inodeDefaults(r.Context(), authorizer, cfg, userID)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+		if err := authorizeNewFileAttributes(r.Context(), authorizer, userID, enginePath, attrs, defaults); err != nil {
+			SendErrorResponse(w, logger, err, http.StatusForbidden)
+			return
+		}
+
+		md := newInodeMetadata(defaults, &metadata.Metadata{
+			Type:        "directory",
+			BackendType: backendConfig.DefaultBackend,
+			ATime:       time.Now(),
+			MTime:       time.Now(),
+			CTime:       time.Now(),
+		}, &attrs)
+		created, err := engine.MakeDirectory(r.Context(), enginePath, parents, md)
+		if err != nil {
+			if len(created) > 0 {
+				logger.Warn("Directory chain partly created",
+					zap.String("path", enginePath), zap.Strings("created", created), zap.Error(err))
+			}
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+
+		// Like mkdir -m, attributes apply to the requested directory only
+		if len(created) > 0 && created[len(created)-1] == enginePath {
+			if err := applyFileAttributes(r.Context(), engine, enginePath, attrs); err != nil {
+				SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+				return
+			}
+		}
+
+		statusCode := http.StatusOK
+		if len(created) > 0 {
+			statusCode = http.StatusCreated
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(MakeDirectoryResponse{Path: enginePath, Created: created}); err != nil {
+			logger.Error("Failed to encode response", zap.Error(err))
+			return
+		}
+		logger.Info("Directories created",
+			zap.String("path", enginePath),
+			zap.String("user_id", userID),
+			zap.Strings("created", created))
+	}
+}
+
+// firstMissingDirectory returns the outermost missing directory on the way
+// to path when parents are created, and path itself otherwise
+func firstMissingDirectory(r *http.Request, engine *core.Engine, path string, parents bool) (string, error) {
+	if !parents || path == "/" {
+		return path, nil
+	}
+	current := ""
+	for _, element := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		current += "/" + element
+		if _, err := engine.GetMetadata(r.Context(), current); errors.Is(err, metadata.ErrNotFound) {
+			return current, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return path, nil
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/server/middleware"
+)
+
+// TransferIDHeader names the transfer an upload is tracked under
+const TransferIDHeader = "X-CallFS-Transfer-Id"
+
+// transferBody counts the content read from the client towards a transfer
+type transferBody struct {
+	io.ReadCloser
+	transfer *core.Transfer
+	err      error // The first read failure other than EOF
+}
+
+func (b *transferBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.transfer.Received(n)
+	switch {
+	case errors.Is(err, io.EOF):
+		b.transfer.Storing()
+	case err != nil && b.err == nil:
+		b.err = err
+	}
+	return n, err
+}
+
+// TrackTransfer tracks uploads sent with X-CallFS-Transfer-Id, so their
+// progress can be read from GET /v1/transfers/{id} while next handles them
+func TrackTransfer(engine *core.Engine, logger *zap.Logger, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(TransferIDHeader)
+		userID, ok := middleware.GetUserID(r.Context())
+		if id == "" || !ok || auth.IsPeerRequest(r.Context()) {
+			next(w, r)
+			return
+		}
+		logger := log.WithContext(r.Context(), logger)
+
+		path := "/" + strings.TrimPrefix(chi.URLParam(r, "*"), "/")
+		transfer, err := engine.StartTransfer(userID, id, path, core.TransferHTTP, r.ContentLength)
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+		body := &transferBody{ReadCloser: r.Body, transfer: transfer}
+		r.Body = body
+
+		// Keep error responses to report why the transfer failed
+		ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		var response bytes.Buffer
+		ww.Tee(&response)
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				transfer.Finish(errors.New("upload aborted"))
+				panic(recovered)
+			}
+			transfer.Finish(transferError(ww.Status(), response.Bytes(), body.err))
+		}()
+		next(ww, r)
+	}
+}
+
+// transferError returns why an upload answered with status and body failed,
+// or nil if it did not
+func transferError(status int, body []byte, readErr error) error {
+	if status == 0 || status < http.StatusBadRequest {
+		return nil
+	}
+	var resp ErrorResponse
+	if err := json.Unmarshal(body, &resp); err == nil && resp.Message != "" {
+		return errors.New(resp.Message)
+	}
+	if readErr != nil {
+		return readErr
+	}
+	return errors.New(http.StatusText(status))
+}
+
+// V1GetTransfer handles GET /v1/transfers/{id}
+// @Summary Get upload progress
+// @Description Reports an upload sent with X-CallFS-Transfer-Id, over HTTP or websocket: its state (receiving, storing, completed or failed), the bytes received so far, the length declared by the client and why it failed. Transfers are tracked by the instance receiving the upload, are visible to the user that sent it only, and can be looked up for an hour after they finish.
+// @Tags files
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Transfer ID"
+// @Success 200 {object} core.TransferStatus "Transfer status"
+// @Failure 401 {object} ErrorResponse "Unauthorized"
+// @Failure 404 {object} ErrorResponse "Not Found"
+// @Router /v1/transfers/{id} [get]
+func V1GetTransfer(engine *core.Engine, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			SendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
+			return
+		}
+		status, err := engine.TransferStatus(userID, chi.URLParam(r, "id"))
+		if err != nil {
+			SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+			return
+		}
+		SendJSONResponse(w, status)
+	}
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/config"
+)
+
+// defaultMaxFileSize is used when server.max_file_size is not configured
+const defaultMaxFileSize int64 = 10 << 30 // 10 GiB
+
+// maxFileSizeForPath returns the upload size limit that applies to path.
+// The longest matching entry in server.max_file_size_by_prefix wins; otherwise
+// the global server.max_file_size applies.
+func maxFileSizeForPath(cfg *config.ServerConfig, path string) int64 {
+	limit := defaultMaxFileSize
+	if cfg == nil {
+		return limit
+	}
+	if cfg.MaxFileSize > 0 {
+		limit = cfg.MaxFileSize
+	}
+
+	bestLen := -1
+	for prefix, prefixLimit := range cfg.MaxFileSizeByPrefix {
+		if prefixLimit <= 0 {
+			continue
+		}
+		trimmed := strings.TrimSuffix(prefix, "/")
+		if trimmed == "" {
+			trimmed = "/"
+		}
+		matches := trimmed == "/" || path == trimmed || strings.HasPrefix(path, trimmed+"/")
+		if matches && len(trimmed) > bestLen {
+			bestLen = len(trimmed)
+			limit = prefixLimit
+		}
+	}
+
+	return limit
+}
+
+// limitUploadBody caps the request body at limit bytes. If the declared
+// Content-Length already exceeds the limit, it writes a 413 response and
+// returns false so the handler can stop before touching any backend.
+func limitUploadBody(w http.ResponseWriter, r *http.Request, limit int64, logger *zap.Logger) bool {
+	if r.ContentLength > limit {
+		logger.Info("Upload rejected: declared size exceeds limit",
+			zap.Int64("content_length", r.ContentLength),
+			zap.Int64("limit", limit))
+		SendErrorResponse(w, logger, ErrFileTooLarge, http.StatusRequestEntityTooLarge)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	return true
+}
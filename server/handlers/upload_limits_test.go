@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/ebogdum/callfs/config"
+)
+
+func TestMaxFileSizeForPath(t *testing.T) {
+	cfg := &config.ServerConfig{
+		MaxFileSize: 1000,
+		MaxFileSizeByPrefix: map[string]int64{
+			"/uploads":       100,
+			"/uploads/large": 5000,
+			"/tmp/":          10,
+		},
+	}
+
+	tests := []struct {
+		path string
+		want int64
+	}{
+		{"/other/file.txt", 1000},
+		{"/uploads/file.txt", 100},
+		{"/uploads", 100},
+		{"/uploads/large/video.mp4", 5000},
+		{"/uploadsx/file.txt", 1000},
+		{"/tmp/scratch", 10},
+	}
+
+	for _, tt := range tests {
+		if got := maxFileSizeForPath(cfg, tt.path); got != tt.want {
+			t.Errorf("maxFileSizeForPath(%q) = %d, want %d", tt.path, got, tt.want)
+		}
+	}
+
+	if got := maxFileSizeForPath(&config.ServerConfig{}, "/any"); got != defaultMaxFileSize {
+		t.Errorf("expected default limit %d, got %d", defaultMaxFileSize, got)
+	}
+}
@@ -16,7 +16,9 @@ import (
 	"github.com/ebogdum/callfs/auth"
 	"github.com/ebogdum/callfs/config"
 	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/core/log"
 	"github.com/ebogdum/callfs/metadata"
+	"github.com/ebogdum/callfs/metrics"
 	"github.com/ebogdum/callfs/server/middleware"
 )
 
@@ -46,8 +48,12 @@ var wsUpgrader = websocket.Upgrader{
 
 // V1WebSocketTransfer handles websocket file transfers on /v1/files/ws/{path}.
 // Query param mode=download|upload controls transfer direction.
-func V1WebSocketTransfer(engine *core.Engine, authorizer auth.Authorizer, backendConfig *config.BackendConfig, logger *zap.Logger) http.HandlerFunc {
+// Uploads with a transfer_id query param or X-CallFS-Transfer-Id header are
+// tracked for GET /v1/transfers/{id}.
+func V1WebSocketTransfer(engine *core.Engine, authorizer auth.Authorizer, backendConfig *config.BackendConfig, serverConfig *config.ServerConfig, logger *zap.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		logger := log.WithContext(r.Context(), logger)
+
 		urlPath := chi.URLParam(r, "*")
 		pathInfo := ParseFilePath(urlPath)
 		if pathInfo.IsInvalid {
@@ -75,15 +81,47 @@ func V1WebSocketTransfer(engine *core.Engine, authorizer auth.Authorizer, backen
 			return
 		}
 
+		// Uploads are tracked from before the upgrade, so a transfer ID that
+		// cannot be used is refused with an ordinary error response
+		var transfer *core.Transfer
+		if id := websocketTransferID(r); id != "" && mode == "upload" && !auth.IsPeerRequest(r.Context()) {
+			var err error
+			transfer, err = engine.StartTransfer(userID, id, pathInfo.FullPath, core.TransferWebSocket, -1)
+			if err != nil {
+				SendErrorResponse(w, logger, err, http.StatusInternalServerError)
+				return
+			}
+		}
+
 		conn, err := wsUpgrader.Upgrade(w, r, nil)
 		if err != nil {
 			logger.Warn("Failed to upgrade websocket", zap.Error(err))
+			if transfer != nil {
+				transfer.Finish(err)
+			}
 			return
 		}
 		defer conn.Close()
+		metrics.WebSocketTransfers.WithLabelValues(mode).Inc()
+		defer metrics.WebSocketTransfers.WithLabelValues(mode).Dec()
 
 		enginePath := pathInfo.FullPath
 
+		// closeUpload ends an upload with a close message, and its transfer
+		// with it
+		closeUpload := func(code int, reason string) {
+			if transfer != nil {
+				if code == websocket.CloseNormalClosure {
+					transfer.Finish(nil)
+				} else {
+					transfer.Finish(errors.New(reason))
+				}
+			}
+			_ = conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(code, reason),
+				time.Now().Add(5*time.Second))
+		}
+
 		switch mode {
 		case "download":
 			if err := authorizer.Authorize(r.Context(), userID, enginePath, auth.ReadPerm); err != nil {
@@ -128,14 +166,17 @@ func V1WebSocketTransfer(engine *core.Engine, authorizer auth.Authorizer, backen
 			}
 		case "upload":
 			if err := authorizer.Authorize(r.Context(), userID, enginePath, auth.WritePerm); err != nil {
-				_ = conn.WriteControl(websocket.CloseMessage,
-					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "write access denied"),
-					time.Now().Add(5*time.Second))
+				closeUpload(websocket.ClosePolicyViolation, "write access denied")
 				return
 			}
+			if transfer != nil {
+				// Connections dropped without a close message end here
+				defer transfer.Finish(errors.New("upload aborted"))
+			}
 
 			var payload bytes.Buffer
-			const maxWSUpload = 100 << 20 // 100 MB max for WebSocket uploads (memory-buffered)
+			const maxWSBuffer int64 = 100 << 20 // 100 MB max for WebSocket uploads (memory-buffered)
+			maxWSUpload := min(maxFileSizeForPath(serverConfig, enginePath), maxWSBuffer)
 			for {
 				messageType, data, readErr := conn.ReadMessage()
 				if readErr != nil {
@@ -144,6 +185,9 @@ func V1WebSocketTransfer(engine *core.Engine, authorizer auth.Authorizer, backen
 					}
 
 					logger.Warn("Failed reading websocket upload message", zap.Error(readErr))
+					if transfer != nil {
+						transfer.Finish(fmt.Errorf("connection lost: %w", readErr))
+					}
 					return
 				}
 
@@ -152,56 +196,67 @@ func V1WebSocketTransfer(engine *core.Engine, authorizer auth.Authorizer, backen
 				}
 
 				if int64(payload.Len())+int64(len(data)) > maxWSUpload {
-					_ = conn.WriteControl(websocket.CloseMessage,
-						websocket.FormatCloseMessage(websocket.CloseMessageTooBig, "upload too large"),
-						time.Now().Add(5*time.Second))
+					closeUpload(websocket.CloseMessageTooBig, "upload too large")
 					return
 				}
 
+				if transfer != nil {
+					transfer.Received(len(data))
+				}
 				if _, err := payload.Write(data); err != nil {
 					logger.Warn("Failed buffering websocket upload payload", zap.Error(err))
 					return
 				}
 			}
 
+			if transfer != nil {
+				transfer.Storing()
+			}
+
+			var content io.Reader = bytes.NewReader(payload.Bytes())
 			size := int64(payload.Len())
+			processed, err := engine.ProcessUpload(r.Context(), enginePath, content)
+			if err != nil {
+				logger.Warn("Websocket upload refused by hooks", zap.String("path", enginePath), zap.Error(err))
+				closeUpload(websocket.CloseUnsupportedData, "upload rejected")
+				return
+			}
+			if processed != nil {
+				defer processed.Close()
+				content, size = processed, processed.Size()
+			}
+
 			existingMd, err := engine.GetMetadata(r.Context(), enginePath)
 			if err != nil {
 				if !errors.Is(err, metadata.ErrNotFound) {
-					_ = conn.WriteControl(websocket.CloseMessage,
-						websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "metadata lookup failed"),
-						time.Now().Add(5*time.Second))
+					closeUpload(websocket.CloseInternalServerErr, "metadata lookup failed")
 					return
 				}
 
-				createMd := &metadata.Metadata{
+				defaults, err := inodeDefaults(r.Context(), authorizer, serverConfig, userID)
+				if err != nil {
+					closeUpload(websocket.CloseInternalServerErr, "identity lookup failed")
+					return
+				}
+				createMd := newInodeMetadata(defaults, &metadata.Metadata{
 					Name:        pathInfo.Name,
 					Type:        "file",
-					Mode:        "0644",
-					UID:         1000,
-					GID:         1000,
 					BackendType: backendConfig.DefaultBackend,
 					ATime:       time.Now(),
 					MTime:       time.Now(),
 					CTime:       time.Now(),
-				}
-				if err := engine.CreateFile(r.Context(), enginePath, bytes.NewReader(payload.Bytes()), size, createMd); err != nil {
-					_ = conn.WriteControl(websocket.CloseMessage,
-						websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "file create failed"),
-						time.Now().Add(5*time.Second))
+				}, nil)
+				if err := engine.CreateFile(r.Context(), enginePath, content, size, createMd); err != nil {
+					closeUpload(websocket.CloseInternalServerErr, "file create failed")
 					return
 				}
 			} else {
 				if existingMd.Type != "file" {
-					_ = conn.WriteControl(websocket.CloseMessage,
-						websocket.FormatCloseMessage(websocket.CloseUnsupportedData, "target path is not a file"),
-						time.Now().Add(5*time.Second))
+					closeUpload(websocket.CloseUnsupportedData, "target path is not a file")
 					return
 				}
-				if err := engine.UpdateFile(r.Context(), enginePath, bytes.NewReader(payload.Bytes()), size, existingMd); err != nil {
-					_ = conn.WriteControl(websocket.CloseMessage,
-						websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "file update failed"),
-						time.Now().Add(5*time.Second))
+				if err := engine.UpdateFile(r.Context(), enginePath, content, size, existingMd); err != nil {
+					closeUpload(websocket.CloseInternalServerErr, "file update failed")
 					return
 				}
 			}
@@ -209,9 +264,16 @@ func V1WebSocketTransfer(engine *core.Engine, authorizer auth.Authorizer, backen
 			if err := conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("ok:%d", size))); err != nil {
 				logger.Warn("Failed writing websocket upload ack", zap.Error(err))
 			}
-			_ = conn.WriteControl(websocket.CloseMessage,
-				websocket.FormatCloseMessage(websocket.CloseNormalClosure, "upload complete"),
-				time.Now().Add(5*time.Second))
+			closeUpload(websocket.CloseNormalClosure, "upload complete")
 		}
 	}
 }
+
+// websocketTransferID returns the transfer ID of a websocket upload. Browsers
+// cannot set headers on websocket requests, so it may be a query parameter.
+func websocketTransferID(r *http.Request) string {
+	if id := r.Header.Get(TransferIDHeader); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("transfer_id")
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	corelog "github.com/ebogdum/callfs/core/log"
+)
+
+type accessLogUserKey struct{}
+
+// accessLogUser is a mutable holder placed in the request context by
+// AccessLogMiddleware so that the authentication middleware further down the
+// chain can report the authenticated user back to the access log.
+type accessLogUser struct {
+	mu     sync.Mutex
+	userID string
+}
+
+// setAccessLogUser records the authenticated user for the access log, if enabled
+func setAccessLogUser(ctx context.Context, userID string) {
+	if holder, ok := ctx.Value(accessLogUserKey{}).(*accessLogUser); ok {
+		holder.mu.Lock()
+		holder.userID = userID
+		holder.mu.Unlock()
+	}
+}
+
+// AccessLogMiddleware writes one line per request to out in Apache combined log
+// format, followed by the request ID:
+//
+//	host - user [time] "METHOD uri PROTO" status bytes "referer" "user-agent" request_id
+//
+// Single-use download tokens are redacted from the logged URI, and the user
+// is escaped with escapeLogToken.
+func AccessLogMiddleware(out io.Writer) func(http.Handler) http.Handler {
+	var mu sync.Mutex
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			holder := &accessLogUser{}
+			r = r.WithContext(context.WithValue(r.Context(), accessLogUserKey{}, holder))
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r)
+
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+			holder.mu.Lock()
+			user := holder.userID
+			holder.mu.Unlock()
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			line := fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %d %q %q %s\n",
+				host,
+				orDash(escapeLogToken(user)),
+				start.Format("02/Jan/2006:15:04:05 -0700"),
+				r.Method,
+				redactRequestURI(r.URL.RequestURI()),
+				r.Proto,
+				status,
+				ww.BytesWritten(),
+				orDash(r.Referer()),
+				orDash(r.UserAgent()),
+				orDash(ww.Header().Get(corelog.RequestIDHeader)))
+
+			mu.Lock()
+			_, _ = io.WriteString(out, line)
+			mu.Unlock()
+		})
+	}
+}
+
+// redactRequestURI hides single-use link tokens so the access log cannot be
+// used to replay downloads.
+func redactRequestURI(uri string) string {
+	if strings.HasPrefix(uri, "/download/") {
+		return "/download/[redacted]"
+	}
+	return uri
+}
+
+// escapeLogToken escapes whitespace, quotes, backslashes and control
+// characters as \xhh or \uhhhh, so a user name from an identity provider
+// cannot add fields or lines to the access log
+func escapeLogToken(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\' || r < 0x20 || r == 0x7f || r == ' ':
+			fmt.Fprintf(&b, "\\x%02x", r)
+		case unicode.IsSpace(r) || unicode.IsControl(r) || r == utf8.RuneError:
+			fmt.Fprintf(&b, "\\u%04x", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
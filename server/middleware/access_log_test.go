@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAccessLogEscapesUser(t *testing.T) {
+	var out bytes.Buffer
+	user := "oidc:eve\" 200 0\n10.0.0.1 - oidc:admin"
+	handler := AccessLogMiddleware(&out)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setAccessLogUser(r.Context(), user)
+	}))
+	r := httptest.NewRequest("GET", "/v1/files/a", nil)
+	r.RemoteAddr = "198.51.100.7:4000"
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	line := out.String()
+	if strings.Count(line, "\n") != 1 || !strings.HasSuffix(line, "\n") {
+		t.Fatalf("access log %q is not one line", line)
+	}
+	fields := strings.SplitN(line, " ", 4)
+	if want := `oidc:eve\x22\x20200\x200\x0a10.0.0.1\x20-\x20oidc:admin`; fields[2] != want {
+		t.Errorf("user field %q, want %q", fields[2], want)
+	}
+}
+
+func TestEscapeLogToken(t *testing.T) {
+	tests := map[string]string{
+		"":                 "",
+		"oidc:alice":       "oidc:alice",
+		"oidc:\u00e9lodie": "oidc:\u00e9lodie",
+		`a\b`:              `a\x5cb`,
+		"tab\tend":         `tab\x09end`,
+		"line\u2028break":  `line\u2028break`,
+		"nul\x00":          `nul\x00`,
+		"bad\xffbyte":      `bad\ufffdbyte`,
+	}
+	for in, want := range tests {
+		if got := escapeLogToken(in); got != want {
+			t.Errorf("escapeLogToken(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -5,64 +5,197 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/ebogdum/callfs/auth"
+	corelog "github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/metrics"
 )
 
-// userIDKey is the context key for storing user ID
 type contextKey string
 
-const (
-	userIDKey    contextKey = "userID"
-	RequestIDKey contextKey = "request_id"
-)
+// RequestIDKey is the context key for storing the request ID
+const RequestIDKey contextKey = "request_id"
 
-// V1AuthMiddleware creates middleware for API key authentication
-func V1AuthMiddleware(authenticator auth.Authenticator, logger *zap.Logger) func(http.Handler) http.Handler {
+// V1AuthMiddleware creates middleware for API key authentication. Requests
+// signed by a peer are verified with the peer verifier and act as the user
+// the peer names in X-CallFS-On-Behalf-Of, or as the internal proxy. When a
+// failure tracker is given, clients that fail to authenticate too often are
+// locked out.
+func V1AuthMiddleware(authenticator auth.Authenticator, peers *auth.RequestVerifier, failures *AuthFailures, logger *zap.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger := corelog.WithContext(r.Context(), logger)
+
 			// Extract Authorization header
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
 				logger.Debug("Missing Authorization header")
+				metrics.AuthFailuresTotal.WithLabelValues("missing_credentials").Inc()
 				sendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
 				return
 			}
 
-			// Authenticate the token
-			userID, err := authenticator.Authenticate(r.Context(), authHeader)
+			// Locked-out clients are turned away before their key is checked,
+			// so guessing gains nothing until the lockout ends
+			client := failures.client(r, authHeader)
+			if failures != nil {
+				if scope, retryAfter := failures.lockedOut(client); scope != "" {
+					logger.Debug("Authentication locked out",
+						zap.String("scope", scope),
+						zap.String("remote_ip", client.ip),
+						zap.String("key_fingerprint", client.key))
+					metrics.AuthFailuresTotal.WithLabelValues("locked_out").Inc()
+					sendLockedOut(w, logger, retryAfter)
+					return
+				}
+			}
+
+			// Authenticate the token, recording the identity it carries
+			ctx := auth.WithIdentityClaims(r.Context())
+			var userID string
+			var scope *auth.TokenScope
+			var err error
+			if peers != nil && auth.IsSignedRequest(r) {
+				userID, err = auth.InternalProxyUserID, peers.Verify(r)
+			} else if scoped, ok := authenticator.(auth.ScopedAuthenticator); ok {
+				userID, scope, err = scoped.AuthenticateScoped(ctx, authHeader)
+			} else {
+				userID, err = authenticator.Authenticate(ctx, authHeader)
+			}
 			if err != nil {
-				logger.Debug("Authentication failed", zap.Error(err))
+				// Only rejected credentials count toward a lockout, not an
+				// identity provider that could not be reached
+				if !errors.Is(err, auth.ErrAuthenticationFailed) && !errors.Is(err, auth.ErrInvalidToken) {
+					logger.Warn("Authentication could not be completed", zap.Error(err))
+					metrics.AuthFailuresTotal.WithLabelValues("error").Inc()
+					sendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
+					return
+				}
+				metrics.AuthFailuresTotal.WithLabelValues("invalid_credentials").Inc()
+				securityEvent(logger, r, client, "auth_failure", zap.Error(err))
+				if failures != nil {
+					for _, lockout := range failures.recordFailure(client) {
+						metrics.AuthLockoutsTotal.WithLabelValues(lockout.scope).Inc()
+						securityEvent(logger, r, client, "auth_lockout",
+							zap.String("scope", lockout.scope),
+							zap.Duration("duration", lockout.duration))
+					}
+				}
 				sendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
 				return
 			}
+			if failures != nil {
+				failures.recordSuccess(client)
+			}
 
-			// Store user ID in context
-			ctx := context.WithValue(r.Context(), userIDKey, userID)
+			// Store user ID, and the restrictions of a scoped token, in context.
+			// A peer names the client it forwards the request for, who was
+			// authenticated by that instance, with the identity its
+			// credentials carried.
+			if userID == auth.InternalProxyUserID {
+				ctx = auth.WithPeerRequest(ctx)
+				if onBehalfOf := r.Header.Get(auth.OnBehalfOfHeader); onBehalfOf != "" {
+					if !validUserID(onBehalfOf) {
+						sendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
+						return
+					}
+					userID = onBehalfOf
+					if value := r.Header.Get(auth.OnBehalfOfIdentityHeader); value != "" {
+						id, err := auth.ParseIdentity(value)
+						if err != nil {
+							sendErrorResponse(w, logger, auth.ErrAuthenticationFailed, http.StatusUnauthorized)
+							return
+						}
+						auth.SetClaimedIdentity(ctx, userID, id, true)
+					}
+				}
+			}
+			ctx = auth.WithUserID(ctx, userID)
+			if scope != nil {
+				ctx = auth.WithTokenScope(ctx, scope)
+			}
 			r = r.WithContext(ctx)
 
 			logger.Debug("User authenticated", zap.String("user_id", userID))
+			setAccessLogUser(ctx, userID)
 
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
-// V1RequestIDMiddleware adds a unique request ID to each request context
+// securityEvent logs a security-relevant event, named by event, with the
+// client it concerns
+func securityEvent(logger *zap.Logger, r *http.Request, client authClient, event string, fields ...zap.Field) {
+	logger.Warn("Security event", append([]zap.Field{
+		zap.String("event", event),
+		zap.String("remote_ip", client.ip),
+		zap.String("key_fingerprint", client.key),
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.Path),
+		zap.String("user_agent", r.UserAgent()),
+	}, fields...)...)
+}
+
+// sendLockedOut rejects a locked-out client with 429 and when to retry
+func sendLockedOut(w http.ResponseWriter, logger *zap.Logger, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(retryAfter.Seconds())))))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	if _, err := w.Write([]byte(`{"code":"AUTH_LOCKED_OUT","message":"Too many failed authentication attempts"}`)); err != nil {
+		logger.Error("Failed to write lockout response", zap.Error(err))
+	}
+}
+
+// V1AdminMiddleware rejects callers that did not authenticate with an admin
+// API key, or did with a scoped token. It must run after V1AuthMiddleware.
+func V1AdminMiddleware(logger *zap.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Scoped tokens reach only paths, never the admin API
+			userID, ok := GetUserID(r.Context())
+			_, scoped := auth.TokenScopeFromContext(r.Context())
+			if !ok || !auth.IsAdmin(userID) || scoped {
+				logger := corelog.WithContext(r.Context(), logger)
+				logger.Warn("Admin API access denied", zap.String("user_id", userID))
+				sendErrorResponse(w, logger, auth.ErrPermissionDenied, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// V1RequestIDMiddleware adds a unique request ID to each request context. A
+// request that already has one, from an outer handler, keeps it.
 func V1RequestIDMiddleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Generate a random request ID
-			requestID := generateRequestID()
+			if _, ok := r.Context().Value(RequestIDKey).(string); ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Reuse a well-formed incoming request ID (e.g. from a peer instance
+			// or load balancer) so logs correlate across hops; otherwise generate one
+			requestID := r.Header.Get(corelog.RequestIDHeader)
+			if !validRequestID(requestID) {
+				requestID = generateRequestID()
+			}
 
 			// Add request ID to response header
-			w.Header().Set("X-Request-ID", requestID)
+			w.Header().Set(corelog.RequestIDHeader, requestID)
 
 			// Add request ID to context
 			ctx := context.WithValue(r.Context(), RequestIDKey, requestID)
+			ctx = corelog.WithRequestID(ctx, requestID)
 			r = r.WithContext(ctx)
 
 			next.ServeHTTP(w, r)
@@ -80,10 +213,41 @@ func generateRequestID() string {
 	return hex.EncodeToString(b)
 }
 
+// validRequestID reports whether an externally supplied request ID is safe to
+// reuse. Only short IDs made of alphanumerics, '-', '_' and '.' are accepted so
+// that client input cannot inject content into log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 // GetUserID extracts the user ID from request context
 func GetUserID(ctx context.Context) (string, bool) {
-	userID, ok := ctx.Value(userIDKey).(string)
-	return userID, ok
+	return auth.UserIDFromContext(ctx)
+}
+
+// validUserID reports whether a user ID named by a peer is one this instance
+// could have authenticated: not empty, and without control characters
+func validUserID(id string) bool {
+	if id == "" || len(id) > 256 {
+		return false
+	}
+	for _, c := range id {
+		if c < ' ' || c == 0x7f {
+			return false
+		}
+	}
+	return true
 }
 
 // sendErrorResponse sends a JSON error response
@@ -91,11 +255,12 @@ func sendErrorResponse(w http.ResponseWriter, logger *zap.Logger, err error, sta
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 
+	// The codes of handlers.ErrorResponse, which this package cannot import
 	var errorCode string
-	switch err {
-	case auth.ErrAuthenticationFailed:
+	switch {
+	case errors.Is(err, auth.ErrAuthenticationFailed):
 		errorCode = "AUTHENTICATION_FAILED"
-	case auth.ErrPermissionDenied:
+	case errors.Is(err, auth.ErrPermissionDenied):
 		errorCode = "PERMISSION_DENIED"
 	default:
 		errorCode = "INTERNAL_ERROR"
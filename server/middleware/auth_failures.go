@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ebogdum/callfs/config"
+)
+
+// authFailureSweepInterval is how often idle failure records are dropped
+const authFailureSweepInterval = time.Minute
+
+// AuthFailures tracks failed authentications by client IP and by the key
+// presented, and locks out an IP or key after too many within a window.
+// Each lockout of the same IP or key lasts twice as long as the last, until
+// it has been quiet for the longest lockout.
+type AuthFailures struct {
+	limit      int
+	window     time.Duration
+	lockout    time.Duration
+	maxLockout time.Duration
+	proxies    trustedProxies
+
+	mu        sync.Mutex
+	entries   map[string]*authFailureEntry // By "ip:<addr>" or "key:<fingerprint>"
+	lastSweep time.Time
+}
+
+type authFailureEntry struct {
+	failures    int // Within the window starting at windowStart
+	windowStart time.Time
+	lockouts    int // Lockouts so far, doubling the next one
+	lockedUntil time.Time
+	lastFailure time.Time
+}
+
+// NewAuthFailures returns the tracker configured by cfg, or nil when
+// auth_failure_limit is zero
+func NewAuthFailures(cfg config.RateLimitConfig) *AuthFailures {
+	if cfg.AuthFailureLimit <= 0 {
+		return nil
+	}
+	return &AuthFailures{
+		limit:      cfg.AuthFailureLimit,
+		window:     cfg.AuthFailureWindow,
+		lockout:    cfg.AuthLockout,
+		maxLockout: cfg.AuthLockoutMax,
+		proxies:    newTrustedProxies(cfg.TrustedProxies),
+		entries:    make(map[string]*authFailureEntry),
+	}
+}
+
+// authClient identifies the sender of a request for failure tracking
+type authClient struct {
+	ip  string
+	key string // Fingerprint of the presented credential, "" without one
+}
+
+// client identifies the sender of r, by the address X-Forwarded-For names
+// when r came through a trusted proxy. f may be nil.
+func (f *AuthFailures) client(r *http.Request, authHeader string) authClient {
+	var proxies trustedProxies
+	if f != nil {
+		proxies = f.proxies
+	}
+	return authClient{ip: proxies.clientIP(r), key: keyFingerprint(authHeader)}
+}
+
+// keyFingerprint identifies a credential in logs and failure records
+// without revealing it
+func keyFingerprint(authHeader string) string {
+	token := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (c authClient) recordKeys() []string {
+	if c.key == "" {
+		return []string{"ip:" + c.ip}
+	}
+	return []string{"ip:" + c.ip, "key:" + c.key}
+}
+
+// lockedOut returns the scope ("ip" or "key") of a lockout c is under and
+// how long it has left
+func (f *AuthFailures) lockedOut(c authClient) (string, time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	for _, key := range c.recordKeys() {
+		if entry, ok := f.entries[key]; ok && now.Before(entry.lockedUntil) {
+			scope, _, _ := strings.Cut(key, ":")
+			return scope, entry.lockedUntil.Sub(now)
+		}
+	}
+	return "", 0
+}
+
+// authLockout is a lockout started by a failure
+type authLockout struct {
+	scope    string // "ip" or "key"
+	duration time.Duration
+}
+
+// recordFailure counts a failed authentication by c, returning the lockouts
+// it started
+func (f *AuthFailures) recordFailure(c authClient) []authLockout {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	f.sweep(now)
+
+	var lockouts []authLockout
+	for _, key := range c.recordKeys() {
+		entry, ok := f.entries[key]
+		if !ok {
+			if len(f.entries) >= rateLimiterMaxEntries {
+				continue // Full of active records; the limit still applies to those
+			}
+			entry = &authFailureEntry{}
+			f.entries[key] = entry
+		}
+		if now.Sub(entry.windowStart) > f.window {
+			entry.failures, entry.windowStart = 0, now
+		}
+		entry.failures++
+		entry.lastFailure = now
+		if entry.failures < f.limit {
+			continue
+		}
+
+		duration := f.lockout
+		for i := 0; i < entry.lockouts && duration < f.maxLockout; i++ {
+			duration *= 2
+		}
+		duration = min(duration, f.maxLockout)
+		entry.lockouts++
+		entry.failures = 0
+		entry.lockedUntil = now.Add(duration)
+		scope, _, _ := strings.Cut(key, ":")
+		lockouts = append(lockouts, authLockout{scope: scope, duration: duration})
+	}
+	return lockouts
+}
+
+// recordSuccess forgets the failures of c's IP, which has shown it holds a
+// valid key, but not its lockouts, which keep doubling
+func (f *AuthFailures) recordSuccess(c authClient) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if entry, ok := f.entries["ip:"+c.ip]; ok {
+		entry.failures = 0
+	}
+}
+
+// sweep drops records quiet for longer than the window and the longest
+// lockout (caller must hold the lock)
+func (f *AuthFailures) sweep(now time.Time) {
+	if now.Sub(f.lastSweep) < authFailureSweepInterval {
+		return
+	}
+	f.lastSweep = now
+	idle := max(f.window, f.maxLockout)
+	for key, entry := range f.entries {
+		if now.After(entry.lockedUntil) && now.Sub(entry.lastFailure) > idle {
+			delete(f.entries, key)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// trustedProxies are the reverse proxies whose X-Forwarded-For header names
+// the client a request came from
+type trustedProxies []*net.IPNet
+
+// newTrustedProxies parses rate_limit.trusted_proxies or
+// metrics.allowed_cidrs, CIDRs or bare IPs already checked by the config
+// loader
+func newTrustedProxies(cidrs []string) trustedProxies {
+	var proxies trustedProxies
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if ip := net.ParseIP(cidr); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			proxies = append(proxies, network)
+		}
+	}
+	return proxies
+}
+
+func (p trustedProxies) trusts(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP address of the client of r. Behind trusted proxies
+// it is the last address in X-Forwarded-For not of a trusted proxy, which a
+// client cannot forge; otherwise it is the remote address.
+func (p trustedProxies) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return p.forwardedFor(ip, r.Header.Values("X-Forwarded-For"))
+}
+
+// forwardedFor returns the client a request from remote forwarded for, by
+// the X-Forwarded-For values header
+func (p trustedProxies) forwardedFor(remote string, header []string) string {
+	if len(p) == 0 || !p.trusts(remote) {
+		return remote
+	}
+	var hops []string
+	for _, value := range header {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		client = hop
+		if !p.trusts(hop) {
+			break
+		}
+	}
+	return client
+}
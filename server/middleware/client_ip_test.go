@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	proxies := newTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"direct client", "198.51.100.7:4000", nil, "198.51.100.7"},
+		{"direct client forging the header", "198.51.100.7:4000", []string{"203.0.113.9"}, "198.51.100.7"},
+		{"through a proxy", "10.1.2.3:4000", []string{"203.0.113.9"}, "203.0.113.9"},
+		{"through two proxies", "192.0.2.1:4000", []string{"203.0.113.9, 10.4.4.4"}, "203.0.113.9"},
+		{"client prepending a forged hop", "10.1.2.3:4000", []string{"1.1.1.1, 203.0.113.9"}, "203.0.113.9"},
+		{"header split across lines", "10.1.2.3:4000", []string{"1.1.1.1", "203.0.113.9"}, "203.0.113.9"},
+		{"proxy without the header", "10.1.2.3:4000", nil, "10.1.2.3"},
+		{"malformed hop", "10.1.2.3:4000", []string{"203.0.113.9, junk"}, "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/v1/files/a", nil)
+			r.RemoteAddr = tt.remote
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := proxies.clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	var none trustedProxies
+	r := httptest.NewRequest("GET", "/v1/files/a", nil)
+	r.RemoteAddr = "10.1.2.3:4000"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9")
+	if got := none.clientIP(r); got != "10.1.2.3" {
+		t.Errorf("clientIP without trusted proxies = %q", got)
+	}
+}
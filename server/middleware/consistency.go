@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/ebogdum/callfs/metadata"
+)
+
+// ConsistencyHeader lets a client choose the read consistency of a request
+const ConsistencyHeader = "X-CallFS-Consistency"
+
+// V1ReadConsistencyMiddleware copies the X-CallFS-Consistency header into the
+// request context, where the raft metadata store reads it. Requests without
+// the header use raft.read_consistency.
+func V1ReadConsistencyMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			value := r.Header.Get(ConsistencyHeader)
+			if value == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			level, err := metadata.ParseReadConsistency(value)
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"code":"INVALID_CONSISTENCY","message":"X-CallFS-Consistency must be eventual or linearizable"}`))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(metadata.WithReadConsistency(r.Context(), level)))
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// progressCopyChunk is how much of a response ReadFrom sends per write deadline
+const progressCopyChunk = 1 << 20
+
+// ProgressDeadlineMiddleware bounds how long a request may go without making
+// progress, rather than how long it may take, so that transfers of any size
+// complete over a working connection. Reading the request body must not
+// stall for longer than readTimeout, nor writing the response for longer than
+// writeTimeout, which starts over as the body arrives. A handler that sets
+// its own deadlines with http.ResponseController takes them over. Zero
+// disables a timeout.
+func ProgressDeadlineMiddleware(readTimeout, writeTimeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if readTimeout <= 0 && writeTimeout <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d := &progressDeadlines{rc: http.NewResponseController(w), readTimeout: readTimeout, writeTimeout: writeTimeout}
+			if !d.start() {
+				// The connection has no deadlines, as with HTTP/3
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = &progressBody{ReadCloser: r.Body, d: d}
+			}
+			next.ServeHTTP(&progressWriter{ResponseWriter: w, d: d}, r)
+		})
+	}
+}
+
+// progressDeadlines moves the deadlines of a request forward as it makes
+// progress, until its handler sets them itself
+type progressDeadlines struct {
+	rc           *http.ResponseController
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	readOwned    atomic.Bool // The handler set the read deadline
+	writeOwned   atomic.Bool // The handler set the write deadline
+}
+
+func (d *progressDeadlines) start() bool {
+	now := time.Now()
+	if d.readTimeout > 0 && d.rc.SetReadDeadline(now.Add(d.readTimeout)) != nil {
+		return false
+	}
+	if d.writeTimeout > 0 && d.rc.SetWriteDeadline(now.Add(d.writeTimeout)) != nil {
+		return false
+	}
+	return true
+}
+
+// extendRead renews the read deadline, and the write deadline with it, as
+// no response is due while the body is still arriving
+func (d *progressDeadlines) extendRead() {
+	now := time.Now()
+	if d.readTimeout > 0 && !d.readOwned.Load() {
+		_ = d.rc.SetReadDeadline(now.Add(d.readTimeout))
+	}
+	d.extendWrite(now)
+}
+
+func (d *progressDeadlines) extendWrite(now time.Time) {
+	if d.writeTimeout > 0 && !d.writeOwned.Load() {
+		_ = d.rc.SetWriteDeadline(now.Add(d.writeTimeout))
+	}
+}
+
+// progressBody renews the deadlines after every read that returns data
+type progressBody struct {
+	io.ReadCloser
+	d *progressDeadlines
+}
+
+func (b *progressBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.d.extendRead()
+	}
+	return n, err
+}
+
+// progressWriter gives every write of the response writeTimeout to complete
+type progressWriter struct {
+	http.ResponseWriter
+	d *progressDeadlines
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.d.extendWrite(time.Now())
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *progressWriter) Flush() {
+	w.d.extendWrite(time.Now())
+	_ = w.d.rc.Flush()
+}
+
+// ReadFrom keeps the underlying writer's ReadFrom, which can send files with
+// sendfile, renewing the write deadline for every chunk
+func (w *progressWriter) ReadFrom(src io.Reader) (int64, error) {
+	rf, ok := w.ResponseWriter.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(struct{ io.Writer }{w}, src)
+	}
+	var total int64
+	for {
+		w.d.extendWrite(time.Now())
+		n, err := rf.ReadFrom(io.LimitReader(src, progressCopyChunk))
+		total += n
+		if err != nil || n < progressCopyChunk {
+			return total, err
+		}
+	}
+}
+
+// SetReadDeadline lets the handler take over the read deadline, as
+// http.ResponseController finds it before the underlying writer's
+func (w *progressWriter) SetReadDeadline(deadline time.Time) error {
+	w.d.readOwned.Store(true)
+	return w.d.rc.SetReadDeadline(deadline)
+}
+
+// SetWriteDeadline lets the handler take over the write deadline
+func (w *progressWriter) SetWriteDeadline(deadline time.Time) error {
+	w.d.writeOwned.Store(true)
+	return w.d.rc.SetWriteDeadline(deadline)
+}
+
+// Hijack hands the connection over, as to a websocket, without the deadlines
+// of the request: the new owner sets its own
+func (w *progressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.d.readOwned.Store(true)
+	w.d.writeOwned.Store(true)
+	conn, rw, err := w.d.rc.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, rw, nil
+}
+
+func (w *progressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
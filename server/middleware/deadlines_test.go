@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestProgressDeadlineWebSocketUpgrade(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	handler := ProgressDeadlineMiddleware(50*time.Millisecond, 50*time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(messageType, data); err != nil {
+				return
+			}
+		}
+	}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("upgrade through the middleware failed: %v", err)
+	}
+	defer conn.Close()
+
+	// The request deadlines must not apply to the hijacked connection
+	time.Sleep(100 * time.Millisecond)
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read after the request deadlines passed: %v", err)
+	}
+	if string(data) != "ping" {
+		t.Errorf("echoed %q", data)
+	}
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// V1DrainMiddleware rejects requests changing files with 503 while draining
+// reports true, so an instance about to stop takes no new writes. Reads and
+// admin requests are still served.
+func V1DrainMiddleware(draining func() bool, logger *zap.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if draining() && isWriteRequest(r) {
+				w.Header().Set("Retry-After", "1")
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				if _, err := w.Write([]byte(`{"code":"DRAINING","message":"Instance is draining and accepts no new writes"}`)); err != nil {
+					logger.Error("Failed to write draining response", zap.Error(err))
+				}
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isWriteRequest reports whether r uploads, deletes, moves or holds a file
+func isWriteRequest(r *http.Request) bool {
+	if isUploadRequest(r) {
+		return true
+	}
+	if !strings.HasPrefix(r.URL.Path, "/v1/files/") {
+		return false
+	}
+	return r.Method == http.MethodDelete || r.Method == "MOVE" || r.Method == http.MethodPatch
+}
@@ -0,0 +1,213 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcmetadata "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	callfsv1 "github.com/ebogdum/callfs/api/callfs/v1"
+	"github.com/ebogdum/callfs/auth"
+	corelog "github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/metrics"
+)
+
+// GRPCAuthInterceptors authenticate gRPC calls as V1AuthMiddleware does
+// requests, with the token of their "authorization" metadata, and give each
+// call a request ID. Given the AuthFailures and RequestLimiter of the /v1 API,
+// failed guesses and rate limits count across both. Peers do not sign gRPC
+// calls, so calls presenting an internal proxy secret are refused rather than
+// acting as the internal proxy.
+func GRPCAuthInterceptors(authenticator auth.Authenticator, failures *AuthFailures, limiter *RequestLimiter, logger *zap.Logger) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	a := &grpcAuthenticator{authenticator: authenticator, failures: failures, limiter: limiter, logger: logger}
+	unary := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := a.authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+	stream := func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authenticate(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		// Writes count toward the upload concurrency limits, as uploads do
+		if a.limiter != nil && info.FullMethod == callfsv1.CallFS_Write_FullMethodName {
+			userID, _ := GetUserID(ctx)
+			if !a.limiter.acquireUpload(userID) {
+				return grpcRateLimited(ctx, a.logger, info.FullMethod, "concurrent_uploads", time.Second)
+			}
+			defer a.limiter.releaseUpload(userID)
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+	return unary, stream
+}
+
+// authenticatedStream is a stream whose context holds its caller
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+type grpcAuthenticator struct {
+	authenticator auth.Authenticator
+	failures      *AuthFailures
+	limiter       *RequestLimiter
+	logger        *zap.Logger
+}
+
+// authenticate returns ctx with the caller of the call to method, or the
+// status error refusing it
+func (a *grpcAuthenticator) authenticate(ctx context.Context, method string) (context.Context, error) {
+	requestID := generateRequestID()
+	ctx = context.WithValue(ctx, RequestIDKey, requestID)
+	ctx = corelog.WithRequestID(ctx, requestID)
+	logger := corelog.WithContext(ctx, a.logger)
+
+	ip := a.clientIP(ctx)
+	if a.limiter != nil {
+		if scope, retryAfter := a.limiter.preAuthLimit(ip); scope != "" {
+			return nil, grpcRateLimited(ctx, a.logger, method, scope, retryAfter)
+		}
+	}
+
+	var authHeader string
+	if md, ok := grpcmetadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			authHeader = values[0]
+		}
+	}
+	if authHeader == "" {
+		logger.Debug("Missing authorization metadata", zap.String("method", method))
+		metrics.AuthFailuresTotal.WithLabelValues("missing_credentials").Inc()
+		return nil, status.Error(codes.Unauthenticated, auth.ErrAuthenticationFailed.Error())
+	}
+
+	client := authClient{ip: ip, key: keyFingerprint(authHeader)}
+	if a.failures != nil {
+		if scope, retryAfter := a.failures.lockedOut(client); scope != "" {
+			logger.Debug("Authentication locked out",
+				zap.String("scope", scope),
+				zap.String("remote_ip", client.ip),
+				zap.String("key_fingerprint", client.key))
+			metrics.AuthFailuresTotal.WithLabelValues("locked_out").Inc()
+			retry := strconv.Itoa(max(1, int(math.Ceil(retryAfter.Seconds()))))
+			return nil, status.Error(codes.ResourceExhausted, "too many failed authentication attempts, retry in "+retry+"s")
+		}
+	}
+
+	// Record the identity the token carries
+	ctx = auth.WithIdentityClaims(ctx)
+	var userID string
+	var scope *auth.TokenScope
+	var err error
+	if scoped, ok := a.authenticator.(auth.ScopedAuthenticator); ok {
+		userID, scope, err = scoped.AuthenticateScoped(ctx, authHeader)
+	} else {
+		userID, err = a.authenticator.Authenticate(ctx, authHeader)
+	}
+	if err != nil {
+		if !errors.Is(err, auth.ErrAuthenticationFailed) && !errors.Is(err, auth.ErrInvalidToken) {
+			logger.Warn("Authentication could not be completed", zap.Error(err))
+			metrics.AuthFailuresTotal.WithLabelValues("error").Inc()
+			return nil, status.Error(codes.Unauthenticated, auth.ErrAuthenticationFailed.Error())
+		}
+		metrics.AuthFailuresTotal.WithLabelValues("invalid_credentials").Inc()
+		grpcSecurityEvent(logger, method, client, "auth_failure", zap.Error(err))
+		if a.failures != nil {
+			for _, lockout := range a.failures.recordFailure(client) {
+				metrics.AuthLockoutsTotal.WithLabelValues(lockout.scope).Inc()
+				grpcSecurityEvent(logger, method, client, "auth_lockout",
+					zap.String("scope", lockout.scope),
+					zap.Duration("duration", lockout.duration))
+			}
+		}
+		return nil, status.Error(codes.Unauthenticated, auth.ErrAuthenticationFailed.Error())
+	}
+	if a.failures != nil {
+		a.failures.recordSuccess(client)
+	}
+	if userID == auth.InternalProxyUserID {
+		grpcSecurityEvent(logger, method, client, "internal_secret_refused")
+		return nil, status.Error(codes.Unauthenticated, auth.ErrAuthenticationFailed.Error())
+	}
+	if a.limiter != nil {
+		if scope, retryAfter := a.limiter.keyLimit(userID); scope != "" {
+			return nil, grpcRateLimited(ctx, a.logger, method, scope, retryAfter)
+		}
+	}
+
+	ctx = auth.WithUserID(ctx, userID)
+	if scope != nil {
+		ctx = auth.WithTokenScope(ctx, scope)
+	}
+	logger.Debug("User authenticated", zap.String("user_id", userID), zap.String("method", method))
+	return ctx, nil
+}
+
+// clientIP returns the IP address the call in ctx came from, or the one its
+// X-Forwarded-For metadata names when it came through a trusted proxy
+func (a *grpcAuthenticator) clientIP(ctx context.Context) string {
+	ip := grpcPeerIP(ctx)
+	md, ok := grpcmetadata.FromIncomingContext(ctx)
+	switch {
+	case !ok:
+		return ip
+	case a.limiter != nil:
+		return a.limiter.proxies.forwardedFor(ip, md.Get("x-forwarded-for"))
+	case a.failures != nil:
+		return a.failures.proxies.forwardedFor(ip, md.Get("x-forwarded-for"))
+	}
+	return ip
+}
+
+// grpcPeerIP returns the IP address the call in ctx came from
+func grpcPeerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	addr := p.Addr.String()
+	if ip, _, err := net.SplitHostPort(addr); err == nil {
+		return ip
+	}
+	return addr
+}
+
+// grpcRateLimited records a call refused by the limit of scope, as
+// sendRateLimited does a request, and returns its status
+func grpcRateLimited(ctx context.Context, logger *zap.Logger, method, scope string, retryAfter time.Duration) error {
+	metrics.RateLimitedRequestsTotal.WithLabelValues(scope).Inc()
+	corelog.WithContext(ctx, logger).Warn("Request rate limited",
+		zap.String("scope", scope),
+		zap.String("method", method),
+		zap.String("remote_addr", grpcPeerIP(ctx)))
+	retry := strconv.Itoa(max(1, int(math.Ceil(retryAfter.Seconds()))))
+	return status.Error(codes.ResourceExhausted, "rate limit exceeded, retry in "+retry+"s")
+}
+
+// grpcSecurityEvent logs a security-relevant event of a gRPC call, as
+// securityEvent does of requests
+func grpcSecurityEvent(logger *zap.Logger, method string, client authClient, event string, fields ...zap.Field) {
+	logger.Warn("Security event", append([]zap.Field{
+		zap.String("event", event),
+		zap.String("remote_ip", client.ip),
+		zap.String("key_fingerprint", client.key),
+		zap.String("method", method),
+	}, fields...)...)
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/config"
+)
+
+// MetricsAccessMiddleware restricts access to the dedicated metrics listener.
+// When cfg.AllowedCIDRs is non-empty, only clients whose remote address falls
+// in one of the networks are accepted; they are CIDRs or bare IPs, parsed as
+// rate_limit.trusted_proxies are. When cfg.BasicAuthUsername is set, HTTP
+// basic auth credentials are required as well.
+func MetricsAccessMiddleware(cfg config.MetricsConfig, logger *zap.Logger) func(http.Handler) http.Handler {
+	allowed := newTrustedProxies(cfg.AllowedCIDRs)
+
+	username := cfg.BasicAuthUsername
+	password := cfg.BasicAuthPassword
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(cfg.AllowedCIDRs) > 0 {
+				host, _, err := net.SplitHostPort(r.RemoteAddr)
+				if err != nil {
+					host = r.RemoteAddr
+				}
+				if !allowed.trusts(host) {
+					logger.Warn("Metrics request rejected by allowlist", zap.String("remote_addr", r.RemoteAddr))
+					http.Error(w, "forbidden", http.StatusForbidden)
+					return
+				}
+			}
+
+			if username != "" {
+				user, pass, ok := r.BasicAuth()
+				userMatch := subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1
+				passMatch := subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+				if !ok || !userMatch || !passMatch {
+					w.Header().Set("WWW-Authenticate", `Basic realm="callfs-metrics"`)
+					http.Error(w, "unauthorized", http.StatusUnauthorized)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
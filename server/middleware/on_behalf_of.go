@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	corelog "github.com/ebogdum/callfs/core/log"
+)
+
+// OnBehalfOfHeader names the user a trusted service sends a request for
+const OnBehalfOfHeader = auth.OnBehalfOfHeader
+
+// V1OnBehalfOfMiddleware lets trusted services act on behalf of end users:
+// a request with the X-CallFS-On-Behalf-Of header continues as the user it
+// names, when delegations allow the caller to act for that user, and is
+// rejected otherwise. It must run after V1AuthMiddleware, and after the
+// per-key limits, which stay with the service's key.
+func V1OnBehalfOfMiddleware(delegations auth.Delegations, logger *zap.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// A peer's request already acts as the user it names
+			onBehalfOf := r.Header.Get(OnBehalfOfHeader)
+			if onBehalfOf == "" || auth.IsPeerRequest(r.Context()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			logger := corelog.WithContext(r.Context(), logger)
+
+			serviceID, _ := GetUserID(r.Context())
+			if !delegations.Allows(serviceID, onBehalfOf) {
+				logger.Warn("Acting on behalf of user denied",
+					zap.String("user_id", serviceID),
+					zap.String("on_behalf_of", onBehalfOf))
+				sendErrorResponse(w, logger, auth.ErrPermissionDenied, http.StatusForbidden)
+				return
+			}
+
+			ctx := auth.WithUserID(r.Context(), onBehalfOf)
+			r = r.WithContext(ctx)
+
+			logger.Debug("Acting on behalf of user",
+				zap.String("user_id", onBehalfOf),
+				zap.String("service_id", serviceID))
+			setAccessLogUser(ctx, onBehalfOf)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -1,13 +1,17 @@
 package middleware
 
 import (
-	"net"
+	"math"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
 	"go.uber.org/zap"
 	"golang.org/x/time/rate"
+
+	corelog "github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/metrics"
 )
 
 const (
@@ -21,8 +25,9 @@ type limiterEntry struct {
 	lastSeen time.Time
 }
 
-// perIPRateLimiter tracks per-IP rate limiters with TTL-based eviction.
-type perIPRateLimiter struct {
+// keyedRateLimiter tracks one rate limiter per client key (IP address or
+// API key) with TTL-based eviction.
+type keyedRateLimiter struct {
 	mu       sync.Mutex
 	limiters map[string]*limiterEntry
 	rate     rate.Limit
@@ -30,8 +35,8 @@ type perIPRateLimiter struct {
 	stopChan chan struct{}
 }
 
-func newPerIPRateLimiter(r rate.Limit, burst int) *perIPRateLimiter {
-	p := &perIPRateLimiter{
+func newKeyedRateLimiter(r rate.Limit, burst int) *keyedRateLimiter {
+	p := &keyedRateLimiter{
 		limiters: make(map[string]*limiterEntry),
 		rate:     r,
 		burst:    burst,
@@ -41,11 +46,11 @@ func newPerIPRateLimiter(r rate.Limit, burst int) *perIPRateLimiter {
 	return p
 }
 
-func (p *perIPRateLimiter) getLimiter(ip string) *rate.Limiter {
+func (p *keyedRateLimiter) getLimiter(key string) *rate.Limiter {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if entry, exists := p.limiters[ip]; exists {
+	if entry, exists := p.limiters[key]; exists {
 		entry.lastSeen = time.Now()
 		return entry.limiter
 	}
@@ -56,7 +61,7 @@ func (p *perIPRateLimiter) getLimiter(ip string) *rate.Limiter {
 	}
 
 	limiter := rate.NewLimiter(p.rate, p.burst)
-	p.limiters[ip] = &limiterEntry{
+	p.limiters[key] = &limiterEntry{
 		limiter:  limiter,
 		lastSeen: time.Now(),
 	}
@@ -64,7 +69,7 @@ func (p *perIPRateLimiter) getLimiter(ip string) *rate.Limiter {
 }
 
 // evictOldest removes the oldest entry (caller must hold lock).
-func (p *perIPRateLimiter) evictOldest() {
+func (p *keyedRateLimiter) evictOldest() {
 	var oldestIP string
 	var oldestTime time.Time
 	first := true
@@ -81,7 +86,7 @@ func (p *perIPRateLimiter) evictOldest() {
 }
 
 // cleanupLoop periodically removes stale entries.
-func (p *perIPRateLimiter) cleanupLoop() {
+func (p *keyedRateLimiter) cleanupLoop() {
 	ticker := time.NewTicker(rateLimiterCleanupInterval)
 	defer ticker.Stop()
 	for {
@@ -101,32 +106,39 @@ func (p *perIPRateLimiter) cleanupLoop() {
 	}
 }
 
-// V1RateLimitMiddleware creates a middleware that applies per-IP rate limiting.
-func V1RateLimitMiddleware(limiter *rate.Limiter, logger *zap.Logger) func(http.Handler) http.Handler {
-	perIP := newPerIPRateLimiter(limiter.Limit(), limiter.Burst())
-
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ip, _, _ := net.SplitHostPort(r.RemoteAddr)
-			if ip == "" {
-				ip = r.RemoteAddr
-			}
-
-			if !perIP.getLimiter(ip).Allow() {
-				logger.Warn("Request rate limited",
-					zap.String("method", r.Method),
-					zap.String("path", r.URL.Path),
-					zap.String("remote_addr", r.RemoteAddr))
-
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusTooManyRequests)
-				if _, err := w.Write([]byte(`{"code":"RATE_LIMIT_EXCEEDED","message":"Rate limit exceeded"}`)); err != nil {
-					logger.Error("Failed to write rate limit error response", zap.Error(err))
-				}
-				return
-			}
+// allow consumes a token from limiter if one is available now. When the request
+// must be rejected it returns the time until a token would be available.
+func allow(limiter *rate.Limiter) (bool, time.Duration) {
+	reservation := limiter.Reserve()
+	if !reservation.OK() {
+		return false, time.Second
+	}
+	delay := reservation.Delay()
+	if delay == 0 {
+		return true, 0
+	}
+	reservation.Cancel()
+	return false, delay
+}
 
-			next.ServeHTTP(w, r)
-		})
+// sendRateLimited writes a 429 response with a Retry-After header and records
+// the rejection under the given scope.
+func sendRateLimited(w http.ResponseWriter, r *http.Request, logger *zap.Logger, scope string, retryAfter time.Duration) {
+	metrics.RateLimitedRequestsTotal.WithLabelValues(scope).Inc()
+	corelog.WithContext(r.Context(), logger).Warn("Request rate limited",
+		zap.String("scope", scope),
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.Path),
+		zap.String("remote_addr", r.RemoteAddr))
+
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	if _, err := w.Write([]byte(`{"code":"RATE_LIMIT_EXCEEDED","message":"Rate limit exceeded"}`)); err != nil {
+		logger.Error("Failed to write rate limit error response", zap.Error(err))
 	}
 }
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+)
+
+// V1ReadOnlyMiddleware rejects requests changing files with 403 when
+// readOnly reports true for the path, or for the destination of a move, so
+// an instance or part of its namespace can be frozen. Reads are still served.
+// Paths protected reports true for, such as snapshots, are read-only to all
+// but peers.
+func V1ReadOnlyMiddleware(readOnly, protected func(path string) bool, logger *zap.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isWriteRequest(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if writesReadOnlyPath(r, readOnly) || (!auth.IsPeerRequest(r.Context()) && writesReadOnlyPath(r, protected)) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				if _, err := w.Write([]byte(`{"code":"READ_ONLY","message":"Path is read-only"}`)); err != nil {
+					logger.Error("Failed to write read-only response", zap.Error(err))
+				}
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writesReadOnlyPath reports whether the file r changes, or the destination
+// it moves a file to, is read-only
+func writesReadOnlyPath(r *http.Request, readOnly func(path string) bool) bool {
+	p := strings.TrimPrefix(r.URL.Path, "/v1/files/")
+	if r.Method == http.MethodGet {
+		p = strings.TrimPrefix(p, "ws/") // WebSocket upload
+	}
+	if readOnly(path.Clean("/" + p)) {
+		return true
+	}
+	if r.Method != "MOVE" {
+		return false
+	}
+	destination, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil {
+		return false // Rejected by the handler
+	}
+	p, _ = strings.CutPrefix(destination.Path, "/v1/files/")
+	return readOnly(path.Clean("/" + p))
+}
@@ -0,0 +1,205 @@
+package middleware
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+
+	"github.com/ebogdum/callfs/config"
+	"github.com/ebogdum/callfs/metrics"
+)
+
+// RequestLimiter enforces the configurable global, per-IP and per-API-key
+// request rates and upload concurrency limits for the /v1 API and the gRPC
+// API, which share one RequestLimiter.
+type RequestLimiter struct {
+	global *rate.Limiter
+	perIP  *keyedRateLimiter
+	perKey *keyedRateLimiter
+
+	proxies trustedProxies // Whose X-Forwarded-For names the client IP
+
+	maxUploads       int
+	maxUploadsPerKey int
+	uploadsMu        sync.Mutex
+	uploads          int
+	uploadsByKey     map[string]int
+
+	logger *zap.Logger
+}
+
+// NewRequestLimiter creates a RequestLimiter from configuration. Limits with a
+// zero rate or count are disabled.
+func NewRequestLimiter(cfg config.RateLimitConfig, logger *zap.Logger) *RequestLimiter {
+	l := &RequestLimiter{
+		maxUploads:       cfg.MaxConcurrentUploads,
+		maxUploadsPerKey: cfg.MaxConcurrentUploadsPerKey,
+		uploadsByKey:     make(map[string]int),
+		proxies:          newTrustedProxies(cfg.TrustedProxies),
+		logger:           logger,
+	}
+	if cfg.GlobalRPS > 0 {
+		l.global = rate.NewLimiter(rate.Limit(cfg.GlobalRPS), burstOrDefault(cfg.GlobalBurst, cfg.GlobalRPS))
+	}
+	if cfg.PerIPRPS > 0 {
+		l.perIP = newKeyedRateLimiter(rate.Limit(cfg.PerIPRPS), burstOrDefault(cfg.PerIPBurst, cfg.PerIPRPS))
+	}
+	if cfg.PerKeyRPS > 0 {
+		l.perKey = newKeyedRateLimiter(rate.Limit(cfg.PerKeyRPS), burstOrDefault(cfg.PerKeyBurst, cfg.PerKeyRPS))
+	}
+	return l
+}
+
+// burstOrDefault falls back to one second worth of requests when no burst is configured
+func burstOrDefault(burst int, rps float64) int {
+	if burst > 0 {
+		return burst
+	}
+	if rps < 1 {
+		return 1
+	}
+	return int(rps)
+}
+
+// PreAuthMiddleware applies the global and per-IP limits. It runs before
+// authentication so unauthenticated floods are throttled as well.
+func (l *RequestLimiter) PreAuthMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if scope, retryAfter := l.preAuthLimit(l.proxies.clientIP(r)); scope != "" {
+				sendRateLimited(w, r, l.logger, scope, retryAfter)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// EndpointMiddleware applies limiter to each client IP of the endpoints it
+// wraps, resolved through the trusted proxies as PreAuthMiddleware does
+func (l *RequestLimiter) EndpointMiddleware(limiter *rate.Limiter) func(http.Handler) http.Handler {
+	perIP := newKeyedRateLimiter(limiter.Limit(), limiter.Burst())
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ok, retryAfter := allow(perIP.getLimiter(l.proxies.clientIP(r))); !ok {
+				sendRateLimited(w, r, l.logger, "endpoint", retryAfter)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// PostAuthMiddleware applies the per-API-key rate and the upload concurrency
+// limits. It must run after V1AuthMiddleware so the caller is known.
+func (l *RequestLimiter) PostAuthMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, _ := GetUserID(r.Context())
+
+			if scope, retryAfter := l.keyLimit(userID); scope != "" {
+				sendRateLimited(w, r, l.logger, scope, retryAfter)
+				return
+			}
+
+			if isUploadRequest(r) {
+				if !l.acquireUpload(userID) {
+					sendRateLimited(w, r, l.logger, "concurrent_uploads", time.Second)
+					return
+				}
+				defer l.releaseUpload(userID)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// preAuthLimit returns the scope, "global" or "ip", of a limit a request
+// from ip exceeds and when to retry it, or "" when the request is allowed
+func (l *RequestLimiter) preAuthLimit(ip string) (string, time.Duration) {
+	if l.global != nil {
+		if ok, retryAfter := allow(l.global); !ok {
+			return "global", retryAfter
+		}
+	}
+	if l.perIP != nil {
+		if ok, retryAfter := allow(l.perIP.getLimiter(ip)); !ok {
+			return "ip", retryAfter
+		}
+	}
+	return "", 0
+}
+
+// keyLimit returns "key" and when to retry when a request of userID exceeds
+// the per-key rate, or "" when the request is allowed
+func (l *RequestLimiter) keyLimit(userID string) (string, time.Duration) {
+	if l.perKey != nil && userID != "" {
+		if ok, retryAfter := allow(l.perKey.getLimiter(userID)); !ok {
+			return "key", retryAfter
+		}
+	}
+	return "", 0
+}
+
+func (l *RequestLimiter) acquireUpload(userID string) bool {
+	l.uploadsMu.Lock()
+	defer l.uploadsMu.Unlock()
+
+	if l.maxUploads > 0 && l.uploads >= l.maxUploads {
+		return false
+	}
+	if l.maxUploadsPerKey > 0 && l.uploadsByKey[userID] >= l.maxUploadsPerKey {
+		return false
+	}
+	l.uploads++
+	l.uploadsByKey[userID]++
+	metrics.InFlightUploads.Inc()
+	return true
+}
+
+func (l *RequestLimiter) releaseUpload(userID string) {
+	l.uploadsMu.Lock()
+	defer l.uploadsMu.Unlock()
+
+	l.uploads--
+	if l.uploadsByKey[userID] <= 1 {
+		delete(l.uploadsByKey, userID)
+	} else {
+		l.uploadsByKey[userID]--
+	}
+	metrics.InFlightUploads.Dec()
+}
+
+// isUploadRequest reports whether r writes file content
+func isUploadRequest(r *http.Request) bool {
+	if !strings.HasPrefix(r.URL.Path, "/v1/files/") {
+		return false
+	}
+	switch r.Method {
+	case http.MethodPost:
+		// mkdir, touch and directories write no content
+		if r.URL.Query().Get("op") != "" || strings.HasSuffix(r.URL.Path, "/") {
+			return false
+		}
+		return r.ContentLength != 0
+	case http.MethodPut:
+		// Empty bodies only create files or set their attributes
+		return r.ContentLength != 0
+	case http.MethodPatch:
+		// Deltas write content; holds do not
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		return err == nil && mediaType == "application/x-rdiff-delta"
+	case http.MethodGet:
+		return strings.HasPrefix(r.URL.Path, "/v1/files/ws/") && r.URL.Query().Get("mode") == "upload"
+	}
+	return false
+}
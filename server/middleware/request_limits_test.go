@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIsUploadRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		body        string
+		contentType string
+		want        bool
+	}{
+		{"POST of content", "POST", "/v1/files/a.txt", "data", "", true},
+		{"PUT of content", "PUT", "/v1/files/a.txt", "data", "", true},
+		{"PUT of attributes only", "PUT", "/v1/files/a.txt", "", "", false},
+		{"POST of an empty file", "POST", "/v1/files/a.txt", "", "", false},
+		{"mkdir", "POST", "/v1/files/dir?op=mkdir&parents=true", "", "", false},
+		{"mkdir with a body", "POST", "/v1/files/dir?op=mkdir", "{}", "", false},
+		{"touch", "POST", "/v1/files/a.txt?op=touch", "", "", false},
+		{"directory", "POST", "/v1/files/dir/", "{}", "", false},
+		{"delta", "PATCH", "/v1/files/a.txt", "data", "application/x-rdiff-delta", true},
+		{"hold", "PATCH", "/v1/files/a.txt", "{}", "application/json", false},
+		{"WebSocket upload", "GET", "/v1/files/ws/a.txt?mode=upload", "", "", true},
+		{"download", "GET", "/v1/files/a.txt", "", "", false},
+		{"other endpoint", "POST", "/v1/links/generate", "{}", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			if got := isUploadRequest(r); got != tt.want {
+				t.Errorf("isUploadRequest = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,29 +2,49 @@ package middleware
 
 import (
 	"net/http"
+	"strconv"
+
+	"github.com/ebogdum/callfs/config"
 )
 
-// V1SecurityHeaders adds common security headers to HTTP responses
-func V1SecurityHeaders() func(http.Handler) http.Handler {
+// V1SecurityHeaders adds the security headers configured by cfg to HTTP
+// responses, and fixed ones that no deployment needs to relax
+func V1SecurityHeaders(cfg config.HeadersConfig) func(http.Handler) http.Handler {
+	hsts := ""
+	if cfg.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.FormatInt(int64(cfg.HSTSMaxAge.Seconds()), 10)
+		if cfg.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if cfg.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Content Security Policy - removed 'unsafe-inline' for better security
-			w.Header().Set("Content-Security-Policy",
-				"default-src 'self'; script-src 'self'; style-src 'self'; img-src 'self' data:; object-src 'none'; base-uri 'self'; form-action 'self'")
+			// Content Security Policy
+			if cfg.ContentSecurityPolicy != "" {
+				w.Header().Set("Content-Security-Policy", cfg.ContentSecurityPolicy)
+			}
 
 			// Strict Transport Security (HSTS)
-			if r.TLS != nil {
-				w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains; preload")
+			if r.TLS != nil && hsts != "" {
+				w.Header().Set("Strict-Transport-Security", hsts)
 			}
 
 			// X-Content-Type-Options
 			w.Header().Set("X-Content-Type-Options", "nosniff")
 
 			// X-Frame-Options
-			w.Header().Set("X-Frame-Options", "DENY")
+			if cfg.FrameOptions != "" {
+				w.Header().Set("X-Frame-Options", cfg.FrameOptions)
+			}
 
 			// Referrer Policy
-			w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
+			if cfg.ReferrerPolicy != "" {
+				w.Header().Set("Referrer-Policy", cfg.ReferrerPolicy)
+			}
 
 			// Permissions Policy
 			w.Header().Set("Permissions-Policy", "geolocation=(), microphone=(), camera=(), payment=(), usb=(), magnetometer=(), gyroscope=(), accelerometer=()")
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/ebogdum/callfs/auth"
+	"github.com/ebogdum/callfs/config"
+	"github.com/ebogdum/callfs/core"
+	"github.com/ebogdum/callfs/nfs"
+	"github.com/ebogdum/callfs/server/handlers"
+)
+
+// NewNFSServer creates the NFSv3 gateway, open to the clients of
+// nfsConfig.AllowedCIDRs
+func NewNFSServer(
+	engine *core.Engine,
+	authorizer auth.Authorizer,
+	serverConfig *config.ServerConfig,
+	backendConfig *config.BackendConfig,
+	nfsConfig config.NFSConfig,
+	logger *zap.Logger,
+) (*nfs.Server, error) {
+	allowed := make([]*net.IPNet, 0, len(nfsConfig.AllowedCIDRs))
+	for _, cidr := range nfsConfig.AllowedCIDRs {
+		cidr = strings.TrimSpace(cidr)
+		// Accept bare IPs as single-host networks
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid NFS allowed CIDR %q: %w", cidr, err)
+		}
+		allowed = append(allowed, network)
+	}
+
+	fs := handlers.NewNFSFileSystem(engine, authorizer, backendConfig, serverConfig, nfsConfig, logger)
+	server := nfs.NewServer(fs, allowed, logger)
+	// Calls act as the configured user, also on the instances owning the
+	// files they reach
+	server.BaseContext = func() context.Context {
+		return auth.WithUserID(context.Background(), nfsConfig.User)
+	}
+	return server, nil
+}
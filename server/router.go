@@ -15,27 +15,45 @@ import (
 	"github.com/ebogdum/callfs/auth"
 	"github.com/ebogdum/callfs/config"
 	"github.com/ebogdum/callfs/core"
+	corelog "github.com/ebogdum/callfs/core/log"
+	"github.com/ebogdum/callfs/jobs"
 	"github.com/ebogdum/callfs/links"
 	"github.com/ebogdum/callfs/metrics"
 	"github.com/ebogdum/callfs/server/handlers"
 	linksHandlers "github.com/ebogdum/callfs/server/handlers/links"
 	authMiddleware "github.com/ebogdum/callfs/server/middleware"
+	"github.com/ebogdum/callfs/ui"
 )
 
 // NewRouter creates and configures the HTTP router
 func NewRouter(
 	engine *core.Engine,
 	authenticator auth.Authenticator,
+	oidcAuthenticator *auth.OIDCAuthenticator, // nil without OIDC
+	delegations auth.Delegations,
+	scopedTokens *auth.ScopedTokens, // nil when scoped tokens are disabled
+	peerVerifier *auth.RequestVerifier,
 	authorizer auth.Authorizer,
 	linkManager *links.LinkManager,
+	jobScheduler *jobs.Scheduler,
 	serverConfig *config.ServerConfig,
 	backendConfig *config.BackendConfig,
+	metricsConfig *config.MetricsConfig,
+	authFailures *authMiddleware.AuthFailures, // nil without lockouts
+	requestLimiter *authMiddleware.RequestLimiter,
+	previewConfig *config.PreviewConfig,
+	logConfig *config.LogConfig,
 	apiHost string,
 	logger *zap.Logger,
 ) chi.Router {
 	// Initialize metrics
 	metrics.RegisterMetrics()
 
+	// MOVE, LOCK and UNLOCK are not standard HTTP methods; chi only routes
+	// registered ones
+	chi.RegisterMethod("MOVE")
+	chi.RegisterMethod("LOCK")
+	chi.RegisterMethod("UNLOCK")
 	r := chi.NewRouter()
 
 	// Basic middleware
@@ -44,7 +62,7 @@ func NewRouter(
 	// and X-Real-IP headers from any client, allowing IP spoofing. Only re-enable
 	// behind a trusted reverse proxy with proper IP allowlisting.
 	r.Use(middleware.Recoverer)
-	r.Use(authMiddleware.V1SecurityHeaders())
+	r.Use(authMiddleware.V1SecurityHeaders(serverConfig.SecurityHeaders))
 
 	// Custom logging and metrics middleware
 	r.Use(func(next http.Handler) http.Handler {
@@ -52,34 +70,30 @@ func NewRouter(
 			start := time.Now()
 			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
 
-			next.ServeHTTP(ww, r)
+			// Requests are labeled by their route pattern, not their path, so
+			// that file paths do not each become a series. A handler aborting
+			// its response, as a download failing mid-stream does, counts as
+			// a 500.
+			status := 0
+			metrics.HTTPInFlightRequests.Inc()
+			defer func() {
+				metrics.HTTPInFlightRequests.Dec()
+				rvr := recover()
+				if rvr != nil {
+					status = http.StatusInternalServerError
+				}
+				recordRequest(r, status, time.Since(start), logConfig.SlowRequest, logger)
+				if rvr != nil {
+					panic(rvr)
+				}
+			}()
 
-			duration := time.Since(start)
-			routePattern := chi.RouteContext(r.Context()).RoutePattern()
-			if routePattern == "" {
-				routePattern = "unmatched"
+			next.ServeHTTP(ww, r)
+			// net/http answers 200 for a handler that writes nothing
+			status = ww.Status()
+			if status == 0 {
+				status = http.StatusOK
 			}
-			statusCode := strconv.Itoa(ww.Status())
-
-			// Record metrics
-			metrics.HTTPRequestsTotal.WithLabelValues(
-				r.Method,
-				routePattern,
-				statusCode,
-			).Inc()
-
-			metrics.HTTPRequestDuration.WithLabelValues(
-				r.Method,
-				routePattern,
-			).Observe(duration.Seconds())
-
-			logger.Info("HTTP request",
-				zap.String("method", r.Method),
-				zap.String("path", routePattern),
-				zap.Int("status", ww.Status()),
-				zap.Duration("duration", duration),
-				zap.String("user_agent", r.UserAgent()),
-				zap.String("remote_addr", r.RemoteAddr))
 		})
 	})
 
@@ -93,28 +107,46 @@ func NewRouter(
 		}
 	})
 
-	// Metrics endpoint - protected by auth to prevent information disclosure
-	r.Group(func(r chi.Router) {
-		r.Use(authMiddleware.V1AuthMiddleware(authenticator, logger))
-		r.Handle("/metrics", promhttp.Handler())
-	})
+	// Liveness and readiness probes (no auth required)
+	r.Get("/healthz", handlers.V1Liveness(engine))
+	r.Get("/readyz", handlers.V1Readiness(engine, serverConfig.HealthCheckTimeout, logger))
+
+	// Metrics endpoint - protected by auth to prevent information disclosure.
+	// Only mounted here when no dedicated metrics listener is configured.
+	if metricsConfig.ListenAddr == "" {
+		r.Group(func(r chi.Router) {
+			r.Use(authMiddleware.V1AuthMiddleware(authenticator, peerVerifier, authFailures, logger))
+			r.Handle("/metrics", promhttp.Handler())
+		})
+	}
 
 	// API v1 routes with authentication
 	r.Route("/v1", func(r chi.Router) {
-		// Apply authentication middleware to all API routes
-		r.Use(authMiddleware.V1AuthMiddleware(authenticator, logger))
+		// Global and per-IP limits run before authentication; per-key and
+		// upload concurrency limits need the authenticated caller
+		r.Use(requestLimiter.PreAuthMiddleware())
+		r.Use(authMiddleware.V1AuthMiddleware(authenticator, peerVerifier, authFailures, logger))
+		r.Use(requestLimiter.PostAuthMiddleware())
+		r.Use(authMiddleware.V1OnBehalfOfMiddleware(delegations, logger))
+		r.Use(authMiddleware.V1ReadConsistencyMiddleware())
+		r.Use(authMiddleware.V1DrainMiddleware(engine.Draining, logger))
+		r.Use(authMiddleware.V1ReadOnlyMiddleware(engine.IsReadOnly, engine.IsSnapshotPath, logger))
 
 		// File operations
 		r.Route("/files", func(r chi.Router) {
 			// WebSocket file transfer endpoint (mode=download|upload)
-			r.Get("/ws/*", handlers.V1WebSocketTransfer(engine, authorizer, backendConfig, logger))
+			r.Get("/ws/*", handlers.V1WebSocketTransfer(engine, authorizer, backendConfig, serverConfig, logger))
 
 			// Handle all paths with /*
 			r.Get("/*", handlers.V1GetFile(engine, authorizer, serverConfig, logger))
 			r.Head("/*", handlers.V1HeadFileEnhanced(engine, authorizer, logger))
-			r.Post("/*", handlers.V1PostFileEnhanced(engine, authorizer, backendConfig, serverConfig, logger))
-			r.Put("/*", handlers.V1PutFileEnhanced(engine, authorizer, backendConfig, serverConfig, logger))
+			r.Post("/*", handlers.TrackTransfer(engine, logger, handlers.V1PostFileEnhanced(engine, authorizer, backendConfig, serverConfig, logger)))
+			r.Put("/*", handlers.TrackTransfer(engine, logger, handlers.V1PutFileEnhanced(engine, authorizer, backendConfig, serverConfig, logger)))
 			r.Delete("/*", handlers.V1DeleteFileEnhanced(engine, authorizer, logger))
+			r.Patch("/*", handlers.V1PatchFile(engine, authorizer, serverConfig, logger))
+			r.Method("MOVE", "/*", handlers.V1MoveFile(engine, authorizer, logger))
+			r.Method("LOCK", "/*", handlers.V1LockFile(engine, authorizer, logger))
+			r.Method("UNLOCK", "/*", handlers.V1UnlockFile(engine, authorizer, logger))
 		})
 
 		// Shard download endpoint (for erasure-coded parallel downloads)
@@ -124,26 +156,163 @@ func NewRouter(
 			})
 		}
 
+		// Thumbnails of images and documents
+		if previewConfig.Enabled && engine.PreviewsEnabled() {
+			r.Get("/preview/*", handlers.V1GetPreview(engine, authorizer, previewConfig, logger))
+		}
+
+		// Search of file names and content
+		if engine.SearchEnabled() {
+			r.Get("/search/content", handlers.V1SearchContent(engine, authorizer, logger))
+		}
+
+		// Download statistics
+		if engine.AccessStatsEnabled() {
+			r.Get("/stats/files/*", handlers.V1GetAccessStats(engine, authorizer, logger))
+			r.With(authMiddleware.V1AdminMiddleware(logger)).Get("/stats/links", handlers.V1GetLinkStats(engine, authorizer, logger))
+		}
+
 		// Directory listing API (moved from /api/directories to /directories)
 		r.Route("/directories", func(r chi.Router) {
 			r.Get("/*", handlers.V1ListDirectory(engine, authorizer, logger))
 		})
 
+		// Short-lived tokens restricted to a path, acting as the caller
+		if scopedTokens != nil {
+			r.Post("/tokens", handlers.V1IssueToken(scopedTokens, logger))
+		}
+
+		// Point-in-time copies of directories, read-only below the snapshot directory
+		if engine.SnapshotsEnabled() {
+			r.Route("/snapshots", func(r chi.Router) {
+				r.Post("/", handlers.V1CreateSnapshot(engine, authorizer, logger))
+				r.Get("/", handlers.V1ListSnapshots(engine, authorizer, logger))
+				r.Get("/{name}", handlers.V1GetSnapshot(engine, authorizer, logger))
+				r.Get("/{name}/files/*", handlers.V1GetSnapshotFile(engine, handlers.V1GetFile(engine, authorizer, serverConfig, logger), logger))
+				r.Head("/{name}/files/*", handlers.V1GetSnapshotFile(engine, handlers.V1HeadFileEnhanced(engine, authorizer, logger), logger))
+				r.With(authMiddleware.V1AdminMiddleware(logger)).Delete("/{name}", handlers.V1DeleteSnapshot(engine, logger))
+				r.With(authMiddleware.V1AdminMiddleware(logger)).Post("/{name}/restore", handlers.V1RestoreSnapshot(engine, logger))
+			})
+		}
+
+		// Capacity of every backend and entry counts
+		r.Get("/statfs", handlers.V1StatFS(engine, logger))
+
+		// Metadata of an entry as JSON, the alternative to HEAD /files
+		r.Get("/stat/*", handlers.V1GetStat(engine, authorizer, logger))
+
+		// Progress of uploads sent with X-CallFS-Transfer-Id
+		r.Get("/transfers/{id}", handlers.V1GetTransfer(engine, logger))
+
 		// Single-use link operations
 		r.Route("/links", func(r chi.Router) {
 			// Apply rate limiting specifically to link generation (100 requests per second, burst of 1)
 			linkRateLimiter := rate.NewLimiter(100, 1)
-			r.With(authMiddleware.V1RateLimitMiddleware(linkRateLimiter, logger)).
+			r.With(requestLimiter.EndpointMiddleware(linkRateLimiter)).
 				Post("/generate", linksHandlers.V1GenerateLinkHandler(linkManager, authorizer, apiHost, logger))
 		})
+
+		// Operator endpoints, restricted to admin API keys
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(authMiddleware.V1AdminMiddleware(logger))
+			r.Get("/locks", handlers.V1AdminListLocks(engine.GetLockManager(), logger))
+			r.Delete("/locks/*", handlers.V1AdminReleaseLock(engine.GetLockManager(), logger))
+			r.Get("/peers", handlers.V1AdminListPeers(engine))
+			r.Get("/members", handlers.V1AdminListMembers(engine))
+			r.Get("/listing/*", handlers.V1AdminMergedListing(engine, logger))
+			r.Post("/migrations", handlers.V1AdminStartMigration(engine, logger))
+			r.Get("/migrations", handlers.V1AdminListMigrations(engine))
+			r.Get("/migrations/{id}", handlers.V1AdminGetMigration(engine, logger))
+			r.Delete("/migrations/{id}", handlers.V1AdminCancelMigration(engine, logger))
+			r.Post("/gc", handlers.V1AdminCollectGarbage(engine, logger))
+			r.Get("/lifecycle", handlers.V1AdminLifecycleRules(engine))
+			r.Post("/lifecycle", handlers.V1AdminApplyLifecycle(engine, logger))
+			if engine.SearchEnabled() {
+				r.Post("/search/reindex", handlers.V1AdminReindex(engine, logger))
+			}
+			r.Get("/jobs", handlers.V1AdminListJobs(engine, jobScheduler))
+			r.Delete("/cache/s3", handlers.V1AdminFlushS3Cache(engine, logger))
+			r.Post("/drain", handlers.V1AdminDrain(engine, logger))
+			r.Get("/drain", handlers.V1AdminDrainStatus(engine))
+			r.Delete("/drain", handlers.V1AdminUndrain(engine))
+			r.Get("/read-only", handlers.V1AdminReadOnlyStatus(engine))
+			r.Put("/read-only", handlers.V1AdminSetReadOnly(engine, logger))
+			r.Delete("/read-only", handlers.V1AdminClearReadOnly(engine, logger))
+		})
 	})
 
+	// Web file browser. Its assets are public; it calls /v1 with the API key
+	// the user signs in with, or the access token of an OIDC sign-in.
+	if serverConfig.EnableUI {
+		r.Get("/ui", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/ui/", http.StatusMovedPermanently)
+		})
+		if oidcAuthenticator != nil {
+			oidcRateLimiter := rate.NewLimiter(10, 5)
+			r.Get("/ui/oidc/config", handlers.V1OIDCLoginConfig(oidcAuthenticator, logger))
+			r.With(requestLimiter.EndpointMiddleware(oidcRateLimiter)).
+				Post("/ui/oidc/token", handlers.V1OIDCLoginToken(oidcAuthenticator, logger))
+		}
+		r.Handle("/ui/*", http.StripPrefix("/ui", ui.Handler()))
+	}
+
 	// Single-use download endpoint (no auth required, rate-limited)
 	downloadRateLimiter := rate.NewLimiter(10, 5)
-	r.With(authMiddleware.V1RateLimitMiddleware(downloadRateLimiter, logger)).
+	r.With(requestLimiter.EndpointMiddleware(downloadRateLimiter)).
 		Get("/download/{token}", linksHandlers.V1DownloadLinkHandler(engine, linkManager, logger))
 
 	logger.Info("HTTP router configured successfully")
 
 	return r
 }
+
+// NewWorkerRouter creates the router of a worker process (server.role=worker),
+// which serves no file traffic, only its liveness and readiness
+func NewWorkerRouter(engine *core.Engine, serverConfig *config.ServerConfig, logger *zap.Logger) chi.Router {
+	r := chi.NewRouter()
+	r.Use(middleware.Recoverer)
+	r.Use(authMiddleware.V1SecurityHeaders(serverConfig.SecurityHeaders))
+
+	r.Get("/healthz", handlers.V1Liveness(engine))
+	r.Get("/readyz", handlers.V1Readiness(engine, serverConfig.HealthCheckTimeout, logger))
+	return r
+}
+
+// routedMethods are the methods the router serves. Requests are labeled
+// "other" for any other, so clients cannot make a series of each method
+// they invent.
+var routedMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, "MOVE": true, "LOCK": true, "UNLOCK": true,
+}
+
+// recordRequest records the metrics and access log of a request answered
+// with status, logged as a warning when it took slow or longer
+func recordRequest(r *http.Request, status int, duration, slow time.Duration, logger *zap.Logger) {
+	routePattern := chi.RouteContext(r.Context()).RoutePattern()
+	if routePattern == "" {
+		routePattern = "unmatched"
+	}
+	method := r.Method
+	if !routedMethods[method] {
+		method = "other"
+	}
+
+	metrics.HTTPRequestsTotal.WithLabelValues(method, routePattern, strconv.Itoa(status)).Inc()
+	metrics.HTTPRequestDuration.WithLabelValues(method, routePattern).Observe(duration.Seconds())
+
+	fields := []zap.Field{
+		zap.String("method", r.Method),
+		zap.String("path", routePattern),
+		zap.Int("status", status),
+		zap.Duration("duration", duration),
+		zap.String("user_agent", r.UserAgent()),
+		zap.String("remote_addr", r.RemoteAddr),
+	}
+	logger = corelog.WithContext(r.Context(), logger)
+	if slow > 0 && duration >= slow {
+		logger.Warn("Slow HTTP request", append(fields, zap.String("request_path", corelog.SanitizePath(r.URL.Path)))...)
+		return
+	}
+	logger.Info("HTTP request", fields...)
+}
@@ -0,0 +1,419 @@
+// CallFS web file browser. Every request goes to the /v1 API with the API key
+// entered at sign-in, or the access token of a single sign-on with the OIDC
+// provider, which is kept in sessionStorage for this tab only.
+"use strict";
+
+const KEY_STORAGE = "callfs.apiKey";
+const OIDC_STORAGE = "callfs.oidc"; // State and PKCE verifier of a sign-on in progress
+const IMAGE_PATTERN = /\.(jpe?g|png|gif|pdf)$/i;
+
+const state = {
+  dir: "/",        // Directory shown, always ending in "/"
+  selected: null,  // Listing item shown in the details pane
+};
+
+const $ = (id) => document.getElementById(id);
+
+// encodePath percent-encodes each segment of an absolute path
+function encodePath(path) {
+  return path.split("/").map(encodeURIComponent).join("/");
+}
+
+class APIError extends Error {
+  constructor(status, code, message) {
+    super(message || code || `HTTP ${status}`);
+    this.status = status;
+    this.code = code;
+  }
+}
+
+// api sends an authenticated request and throws APIError on failure
+async function api(method, url, { body, headers = {} } = {}) {
+  const response = await fetch(url, {
+    method,
+    body,
+    headers: { ...headers, Authorization: `Bearer ${sessionStorage.getItem(KEY_STORAGE)}` },
+  });
+  if (response.status === 401) {
+    signOut();
+    throw new APIError(401, "AUTHENTICATION_FAILED", "The API key or session was not accepted");
+  }
+  if (!response.ok) {
+    let code = "", message = "";
+    try {
+      ({ code, message } = await response.json());
+    } catch (_) {
+      // Not a JSON error body
+    }
+    throw new APIError(response.status, code, message);
+  }
+  return response;
+}
+
+function setStatus(message, isError = false) {
+  const status = $("status");
+  status.textContent = message;
+  status.classList.toggle("error", isError);
+}
+
+function formatSize(bytes) {
+  const units = ["B", "KiB", "MiB", "GiB", "TiB"];
+  let i = 0;
+  while (bytes >= 1024 && i < units.length - 1) {
+    bytes /= 1024;
+    i++;
+  }
+  return i === 0 ? `${bytes} B` : `${bytes.toFixed(1)} ${units[i]}`;
+}
+
+function formatTime(value) {
+  const date = new Date(value);
+  return isNaN(date) ? value : date.toLocaleString();
+}
+
+// Sign-in
+
+function signIn(key) {
+  sessionStorage.setItem(KEY_STORAGE, key);
+  $("login").hidden = true;
+  $("browser").hidden = false;
+  $("logout").hidden = false;
+  navigate(dirFromHash());
+}
+
+function signOut() {
+  sessionStorage.removeItem(KEY_STORAGE);
+  $("login").hidden = false;
+  $("browser").hidden = true;
+  $("logout").hidden = true;
+  $("api-key").value = "";
+}
+
+// Single sign-on with the authorization code flow and PKCE. The provider
+// redirects back to /ui/ with a code, which the server redeems.
+
+function randomString() {
+  const bytes = crypto.getRandomValues(new Uint8Array(32));
+  return base64URL(bytes);
+}
+
+function base64URL(bytes) {
+  return btoa(String.fromCharCode(...new Uint8Array(bytes)))
+    .replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+function redirectURI() {
+  return location.origin + "/ui/";
+}
+
+// setupSSO shows the single sign-on button when the server has OIDC
+async function setupSSO() {
+  const response = await fetch("oidc/config").catch(() => null);
+  if (!response || !response.ok) return;
+  const config = await response.json();
+  const button = $("sso");
+  button.hidden = false;
+  button.addEventListener("click", async () => {
+    const verifier = randomString();
+    const stateParam = randomString();
+    const challenge = base64URL(await crypto.subtle.digest("SHA-256", new TextEncoder().encode(verifier)));
+    sessionStorage.setItem(OIDC_STORAGE, JSON.stringify({ state: stateParam, verifier, hash: location.hash }));
+    const url = new URL(config.authorization_endpoint);
+    url.search = new URLSearchParams({
+      response_type: "code",
+      client_id: config.client_id,
+      redirect_uri: redirectURI(),
+      scope: config.scopes.join(" "),
+      state: stateParam,
+      code_challenge: challenge,
+      code_challenge_method: "S256",
+    });
+    location.assign(url);
+  });
+}
+
+// completeSSO redeems the code the provider redirected back with, returning
+// whether the page was such a redirect
+async function completeSSO() {
+  const params = new URLSearchParams(location.search);
+  if (!params.has("code") && !params.has("error")) return false;
+  const pending = JSON.parse(sessionStorage.getItem(OIDC_STORAGE) || "null");
+  sessionStorage.removeItem(OIDC_STORAGE);
+  history.replaceState(null, "", redirectURI() + (pending?.hash || ""));
+
+  if (params.has("error")) {
+    setStatus(`Single sign-on failed: ${params.get("error_description") || params.get("error")}`, true);
+    return true;
+  }
+  if (!pending || params.get("state") !== pending.state) {
+    setStatus("Single sign-on failed: the response does not match the sign-in started here", true);
+    return true;
+  }
+  const response = await fetch("oidc/token", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ code: params.get("code"), code_verifier: pending.verifier, redirect_uri: redirectURI() }),
+  });
+  if (!response.ok) {
+    setStatus("Single sign-on failed: the provider's token was not accepted", true);
+    return true;
+  }
+  signIn((await response.json()).access_token);
+  return true;
+}
+
+// Directory listing
+
+function dirFromHash() {
+  let dir = decodeURIComponent(location.hash.slice(1)) || "/";
+  if (!dir.startsWith("/")) dir = "/" + dir;
+  return dir.endsWith("/") ? dir : dir + "/";
+}
+
+function navigate(dir) {
+  if (location.hash.slice(1) !== encodePath(dir)) {
+    location.hash = encodePath(dir); // Triggers hashchange, which loads it
+    return;
+  }
+  load(dir);
+}
+
+async function load(dir) {
+  state.dir = dir;
+  showDetails(null);
+  renderBreadcrumbs();
+  setStatus("Loading…");
+  try {
+    const response = await api("GET", `/v1/directories${encodePath(dir)}`);
+    const listing = await response.json();
+    renderListing(listing.items || []);
+    setStatus(`${listing.count} entries`);
+  } catch (err) {
+    renderListing([]);
+    setStatus(`Failed to list ${dir}: ${err.message}`, true);
+  }
+}
+
+function renderBreadcrumbs() {
+  const nav = $("breadcrumbs");
+  nav.replaceChildren();
+  const parts = state.dir.split("/").filter(Boolean);
+  const crumbs = [["/", "/"]];
+  parts.forEach((part, i) => crumbs.push([part, "/" + parts.slice(0, i + 1).join("/") + "/"]));
+  crumbs.forEach(([label, dir], i) => {
+    if (i > 1) nav.append(" / ");
+    const link = document.createElement("a");
+    link.href = "#" + encodePath(dir);
+    link.textContent = label;
+    nav.append(link);
+  });
+}
+
+function renderListing(items) {
+  const tbody = $("listing").querySelector("tbody");
+  tbody.replaceChildren();
+  items.sort((a, b) => (a.type === b.type ? a.name.localeCompare(b.name) : a.type === "directory" ? -1 : 1));
+  for (const item of items) {
+    const row = document.createElement("tr");
+    row.className = item.type;
+    const cells = [
+      item.name,
+      item.type === "directory" ? "" : formatSize(item.size),
+      item.mode,
+      `${item.uid}:${item.gid}`,
+      formatTime(item.mtime),
+    ];
+    cells.forEach((text, i) => {
+      const cell = document.createElement("td");
+      cell.textContent = text;
+      if (i === 1) cell.className = "size";
+      row.append(cell);
+    });
+    row.addEventListener("click", () => {
+      tbody.querySelectorAll(".selected").forEach((r) => r.classList.remove("selected"));
+      row.classList.add("selected");
+      showDetails(item);
+    });
+    row.addEventListener("dblclick", () => {
+      if (item.type === "directory") navigate(item.path.replace(/\/?$/, "/"));
+    });
+    tbody.append(row);
+  }
+}
+
+// Details pane
+
+async function showDetails(item) {
+  state.selected = item;
+  $("details").hidden = !item;
+  $("share-url").hidden = true;
+  $("details-preview").hidden = true;
+  if (!item) return;
+
+  $("details-name").textContent = item.name;
+  $("download").hidden = item.type !== "file";
+  $("share-form").hidden = item.type !== "file";
+  const list = $("details-metadata");
+  list.replaceChildren();
+
+  const path = item.type === "directory" ? item.path.replace(/\/?$/, "/") : item.path;
+  try {
+    const response = await api("HEAD", `/v1/files${encodePath(path)}`);
+    if (state.selected !== item) return;
+    const rows = [["Path", item.path]];
+    for (const [name, value] of response.headers) {
+      if (name.startsWith("x-callfs-")) rows.push([name.slice("x-callfs-".length), value]);
+    }
+    for (const [term, value] of rows) {
+      const dt = document.createElement("dt");
+      dt.textContent = term;
+      const dd = document.createElement("dd");
+      dd.textContent = term === "mtime" ? formatTime(value) : value;
+      list.append(dt, dd);
+    }
+  } catch (err) {
+    setStatus(`Failed to read metadata of ${item.path}: ${err.message}`, true);
+  }
+
+  if (item.type === "file" && IMAGE_PATTERN.test(item.name)) {
+    loadPreview(item);
+  }
+}
+
+// loadPreview shows a thumbnail when the server has previews enabled. It is
+// inlined as a data: URL, which the server's content security policy allows.
+async function loadPreview(item) {
+  try {
+    const response = await api("GET", `/v1/preview${encodePath(item.path)}?w=320&h=320`);
+    const blob = await response.blob();
+    const reader = new FileReader();
+    reader.onload = () => {
+      if (state.selected !== item) return;
+      $("details-preview").src = reader.result;
+      $("details-preview").hidden = false;
+    };
+    reader.readAsDataURL(blob);
+  } catch (_) {
+    // No preview for this file
+  }
+}
+
+// createLink returns a single-use download link for path
+async function createLink(path, expirySeconds) {
+  const response = await api("POST", "/v1/links/generate", {
+    body: JSON.stringify({ path, expiry_seconds: expirySeconds }),
+    headers: { "Content-Type": "application/json" },
+  });
+  return response.json();
+}
+
+// Downloads go through a short-lived single-use link, so the browser streams
+// the file to disk instead of holding it in memory. The link is followed on
+// this server, whatever server.external_url says.
+async function download() {
+  const item = state.selected;
+  try {
+    const link = await createLink(item.path, 60);
+    location.assign(`/download/${link.token}`);
+  } catch (err) {
+    setStatus(`Failed to download ${item.path}: ${err.message}`, true);
+  }
+}
+
+async function share(event) {
+  event.preventDefault();
+  const item = state.selected;
+  try {
+    const link = await createLink(item.path, Number($("share-expiry").value));
+    const field = $("share-url");
+    field.value = link.url;
+    field.hidden = false;
+    field.select();
+    setStatus("Link created. It can be used once.");
+  } catch (err) {
+    setStatus(`Failed to create a link for ${item.path}: ${err.message}`, true);
+  }
+}
+
+async function remove() {
+  const item = state.selected;
+  const path = item.type === "directory" ? item.path.replace(/\/?$/, "/") : item.path;
+  if (!confirm(`Delete ${item.path}?`)) return;
+  try {
+    await api("DELETE", `/v1/files${encodePath(path)}`);
+    setStatus(`Deleted ${item.path}`);
+    load(state.dir);
+  } catch (err) {
+    setStatus(`Failed to delete ${item.path}: ${err.message}`, true);
+  }
+}
+
+// Uploads and folders
+
+async function upload(event) {
+  const files = [...event.target.files];
+  event.target.value = "";
+  for (const file of files) {
+    const path = state.dir + file.name;
+    setStatus(`Uploading ${file.name}…`);
+    try {
+      await api("POST", `/v1/files${encodePath(path)}`, {
+        body: file,
+        headers: { "Content-Type": "application/octet-stream" },
+      });
+    } catch (err) {
+      if (err.code !== "FILE_ALREADY_EXISTS" || !confirm(`${file.name} exists. Replace it?`)) {
+        setStatus(`Failed to upload ${file.name}: ${err.message}`, true);
+        return;
+      }
+      try {
+        await api("PUT", `/v1/files${encodePath(path)}`, {
+          body: file,
+          headers: { "Content-Type": "application/octet-stream" },
+        });
+      } catch (err) {
+        setStatus(`Failed to upload ${file.name}: ${err.message}`, true);
+        return;
+      }
+    }
+  }
+  await load(state.dir);
+  if (files.length) setStatus(`Uploaded ${files.length} file${files.length === 1 ? "" : "s"}`);
+}
+
+async function mkdir() {
+  const name = prompt("Folder name");
+  if (!name) return;
+  if (name.includes("/")) {
+    setStatus("Folder names cannot contain /", true);
+    return;
+  }
+  try {
+    await api("POST", `/v1/files${encodePath(state.dir + name)}?op=mkdir`);
+    load(state.dir);
+  } catch (err) {
+    setStatus(`Failed to create ${name}: ${err.message}`, true);
+  }
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+  $("login-form").addEventListener("submit", (event) => {
+    event.preventDefault();
+    signIn($("api-key").value.trim());
+  });
+  $("logout").addEventListener("click", signOut);
+  $("refresh").addEventListener("click", () => load(state.dir));
+  $("upload").addEventListener("change", upload);
+  $("mkdir").addEventListener("click", mkdir);
+  $("download").addEventListener("click", download);
+  $("delete").addEventListener("click", remove);
+  $("share-form").addEventListener("submit", share);
+  window.addEventListener("hashchange", () => load(dirFromHash()));
+
+  setupSSO();
+  completeSSO().then((redirected) => {
+    if (!redirected && sessionStorage.getItem(KEY_STORAGE)) {
+      signIn(sessionStorage.getItem(KEY_STORAGE));
+    }
+  });
+});
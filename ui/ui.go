@@ -0,0 +1,27 @@
+// Package ui embeds the web file browser served at /ui. The browser is a
+// static page that calls the /v1 API with an API key the user enters, or with
+// the access token of a single sign-on when OIDC is configured.
+package ui
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var staticFS embed.FS
+
+// Handler serves the browser's assets, with paths relative to its mount point
+func Handler() http.Handler {
+	assets, err := fs.Sub(staticFS, "static")
+	if err != nil {
+		panic(err) // The directory is embedded at build time
+	}
+	files := http.FileServerFS(assets)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Assets change with the server binary, so always revalidate
+		w.Header().Set("Cache-Control", "no-cache")
+		files.ServeHTTP(w, r)
+	})
+}